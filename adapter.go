@@ -0,0 +1,89 @@
+package iofl
+
+import "io"
+
+// nopFilter is a Filter with a no-op Close method and no source.
+type nopFilter struct {
+	io.Reader
+}
+
+func (nopFilter) Close() error          { return nil }
+func (nopFilter) Source() io.ReadCloser { return nil }
+
+// NopFilter returns a Filter that reads from r, with a no-op Close method and a
+// nil source.
+func NopFilter(r io.Reader) Filter {
+	return nopFilter{r}
+}
+
+// funcFilter is a Filter implemented by functions.
+type funcFilter struct {
+	read  func(p []byte) (n int, err error)
+	close func() error
+}
+
+func (f funcFilter) Read(p []byte) (n int, err error) { return f.read(p) }
+func (f funcFilter) Source() io.ReadCloser            { return nil }
+
+func (f funcFilter) Close() error {
+	if f.close == nil {
+		return nil
+	}
+	return f.close()
+}
+
+// FilterFunc returns a Filter with a nil source that calls read when read from,
+// and close when closed. If close is nil, then Close does nothing. Panics if
+// read is nil.
+func FilterFunc(read func(p []byte) (n int, err error), close func() error) Filter {
+	if read == nil {
+		panic("nil read function")
+	}
+	return funcFilter{read: read, close: close}
+}
+
+// WrapReader returns r as a Filter. If r is a Filter, it is returned directly.
+// If r is an io.ReadCloser, it is wrapped with Root. Otherwise, it is wrapped
+// with NopFilter. Returns nil if r is nil.
+func WrapReader(r io.Reader) Filter {
+	switch r := r.(type) {
+	case nil:
+		return nil
+	case Filter:
+		return r
+	case io.ReadCloser:
+		return Root{r}
+	default:
+		return NopFilter(r)
+	}
+}
+
+// AsFilter returns r as a Filter, avoiding unnecessary layers of indirection.
+// If r is a Root that wraps a Filter, or another Root, then the wrapped value
+// is unwrapped. If the result is not a Filter, then it is wrapped with Root.
+// Returns nil if r is nil, including a nil *Root.
+func AsFilter(r io.ReadCloser) Filter {
+	for {
+		switch v := r.(type) {
+		case nil:
+			return nil
+		case Root:
+			if v.ReadCloser == nil {
+				return v
+			}
+			r = v.ReadCloser
+		case *Root:
+			if v == nil {
+				return nil
+			}
+			if v.ReadCloser == nil {
+				return v
+			}
+			r = v.ReadCloser
+		case Filter:
+			return v
+		default:
+			return Root{r}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestNopFilter(t *testing.T) {
+	f := iofl.NopFilter(strings.NewReader("hello"))
+	if f.Source() != nil {
+		t.Error("expected nil source")
+	}
+	if got := readAll(t, f); got != "hello" {
+		t.Errorf("got %q, want %q", got, "hello")
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("second close: %v", err)
+	}
+}
+
+func TestFilterFunc(t *testing.T) {
+	r := strings.NewReader("data")
+	closed := 0
+	errClose := errors.New("close")
+	f := iofl.FilterFunc(r.Read, func() error {
+		closed++
+		return errClose
+	})
+	if f.Source() != nil {
+		t.Error("expected nil source")
+	}
+	b := make([]byte, 8)
+	n, err := f.Read(b)
+	if err != nil || string(b[:n]) != "data" {
+		t.Errorf("read: got %q, %v", b[:n], err)
+	}
+	if err := f.Close(); err != errClose {
+		t.Errorf("close: got %v, want %v", err, errClose)
+	}
+	if closed != 1 {
+		t.Errorf("close called %d times, want 1", closed)
+	}
+
+	if err := iofl.FilterFunc(r.Read, nil).Close(); err != nil {
+		t.Errorf("nil close: %v", err)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for nil read")
+		}
+	}()
+	iofl.FilterFunc(nil, nil)
+}
+
+func TestWrapReader(t *testing.T) {
+	if f := iofl.WrapReader(nil); f != nil {
+		t.Errorf("nil: got %#v, want nil", f)
+	}
+
+	nop := iofl.NopFilter(strings.NewReader(""))
+	if f := iofl.WrapReader(nop); f != nop {
+		t.Errorf("Filter: got %#v, want original", f)
+	}
+
+	rc := source("closer")
+	if f, ok := iofl.WrapReader(rc).(iofl.Root); !ok || f.ReadCloser != rc {
+		t.Errorf("ReadCloser: got %#v, want Root", f)
+	}
+
+	f := iofl.WrapReader(strings.NewReader("reader"))
+	if got := readAll(t, f); got != "reader" {
+		t.Errorf("Reader: got %q, want %q", got, "reader")
+	}
+}
+
+func TestWrapReaderResolve(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"from": "a", "to": "A"}}},
+	})
+	src := iofl.WrapReader(strings.NewReader("banana"))
+	f, err := s.Resolve("upper", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var found bool
+	iofl.Apply(f, func(r io.ReadCloser) error {
+		if r == io.ReadCloser(src) {
+			found = true
+		}
+		return nil
+	})
+	if !found {
+		t.Error("Apply did not reach wrapped source")
+	}
+	if got := readAll(t, f); got != "bAnAnA" {
+		t.Errorf("got %q, want %q", got, "bAnAnA")
+	}
+}
+
+func TestAsFilter(t *testing.T) {
+	rc := source("x")
+	nop := iofl.NopFilter(strings.NewReader("x"))
+	var nilRoot *iofl.Root
+	tests := []struct {
+		name string
+		in   io.ReadCloser
+		want iofl.Filter
+	}{
+		{"nil", nil, nil},
+		{"nil *Root", nilRoot, nil},
+		{"ReadCloser", rc, iofl.Root{rc}},
+		{"Filter", nop, nop},
+		{"Root", iofl.Root{rc}, iofl.Root{rc}},
+		{"Root of Filter", iofl.Root{nop}, nop},
+		{"Root of Root", iofl.Root{iofl.Root{nop}}, nop},
+		{"*Root", &iofl.Root{rc}, iofl.Root{rc}},
+		{"*Root of Filter", &iofl.Root{nop}, nop},
+		{"empty Root", iofl.Root{}, iofl.Root{}},
+	}
+	for _, tt := range tests {
+		if got := iofl.AsFilter(tt.in); got != tt.want {
+			t.Errorf("%s: got %#v, want %#v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestResolveUnwrapsRoot(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"empty": {}})
+	nop := iofl.NopFilter(strings.NewReader("x"))
+	f, err := s.Resolve("empty", iofl.Root{nop})
+	if err != nil {
+		t.Fatal(err)
+	}
+	iofl.Apply(f, func(r io.ReadCloser) error {
+		if _, ok := r.(iofl.Root); ok {
+			t.Error("source was re-wrapped with Root")
+		}
+		return nil
+	})
+	if got := readAll(t, f); got != "x" {
+		t.Errorf("got %q, want %q", got, "x")
+	}
+}
+
+// Ensure the adapters satisfy io.ReadCloser for use as chain sources.
+var _ io.ReadCloser = iofl.NopFilter(nil)
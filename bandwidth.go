@@ -0,0 +1,97 @@
+package iofl
+
+import (
+	"sort"
+	"sync"
+)
+
+// Bandwidth manages a set of named Limiters, allowing the combined transfer
+// rate of many chains to be capped. Filters attach to a Limiter by name. Each
+// ChainSet has its own Bandwidth, configured by the Bandwidth field of Config.
+// A Bandwidth is safe for concurrent use.
+type Bandwidth struct {
+	mu       sync.Mutex
+	limiters map[string]*Limiter
+}
+
+// DefaultBandwidth is the process-wide Bandwidth used by filters that are not
+// bound to a ChainSet, such as filters.RateLimit. It is not affected by the
+// configuration of any ChainSet.
+var DefaultBandwidth = &Bandwidth{}
+
+// Set sets the rate and burst size, in bytes, of the named Limiter, creating it
+// if it does not exist. Filters already attached to the Limiter observe the new
+// rate. A rate less than 1 removes the limit.
+func (b *Bandwidth) Set(name string, rate, burst int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if l, ok := b.limiters[name]; ok {
+		l.SetRate(rate, burst)
+		return
+	}
+	if b.limiters == nil {
+		b.limiters = map[string]*Limiter{}
+	}
+	b.limiters[name] = NewLimiter(rate, burst)
+}
+
+// Limiter returns the named Limiter, or nil if it does not exist.
+func (b *Bandwidth) Limiter(name string) *Limiter {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.limiters[name]
+}
+
+// Names returns the names of each Limiter, in lexical order.
+func (b *Bandwidth) Names() []string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	names := make([]string, 0, len(b.limiters))
+	for name := range b.limiters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// configure sets the rate of each Limiter named in rates, and removes each
+// Limiter not named in rates. The limit of a removed Limiter is lifted, so that
+// filters still attached to it are no longer limited.
+func (b *Bandwidth) configure(rates map[string]int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	for name, l := range b.limiters {
+		if _, ok := rates[name]; !ok {
+			l.SetRate(0, 0)
+			delete(b.limiters, name)
+		}
+	}
+	for name, rate := range rates {
+		if l, ok := b.limiters[name]; ok {
+			l.SetRate(rate, 0)
+			continue
+		}
+		if b.limiters == nil {
+			b.limiters = map[string]*Limiter{}
+		}
+		b.limiters[name] = NewLimiter(rate, 0)
+	}
+}
+
+// Bandwidth returns the Bandwidth of the ChainSet, whose Limiters are
+// configured by the Bandwidth field of Config. Filters registered with the
+// ChainSet, such as by filters.Register, attach to these Limiters.
+func (s *ChainSet) Bandwidth() *Bandwidth {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.bandwidthLocked()
+}
+
+// bandwidthLocked returns the Bandwidth of the ChainSet, creating it if
+// necessary. s.mu must be held.
+func (s *ChainSet) bandwidthLocked() *Bandwidth {
+	if s.limiters == nil {
+		s.limiters = &Bandwidth{}
+	}
+	return s.limiters
+}
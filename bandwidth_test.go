@@ -0,0 +1,78 @@
+package iofl_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestBandwidth(t *testing.T) {
+	var b iofl.Bandwidth
+	if b.Limiter("a") != nil {
+		t.Error("expected nil for unknown limiter")
+	}
+	b.Set("b", 1000, 0)
+	b.Set("a", 1000, 0)
+	l := b.Limiter("a")
+	if l == nil {
+		t.Fatal("limiter not created")
+	}
+	// Setting an existing limiter updates it in place.
+	b.Set("a", 0, 0)
+	if b.Limiter("a") != l {
+		t.Error("limiter replaced")
+	}
+	start := time.Now()
+	l.WaitN(iofl.PriorityNormal, 1<<20)
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("limit not removed")
+	}
+	if got := b.Names(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got names %v", got)
+	}
+}
+
+func TestConfigBandwidth(t *testing.T) {
+	s := iofl.NewChainSet()
+	config := iofl.Config{Bandwidth: map[string]int64{"a": 500, "b": 500}}
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Bandwidth().Names(); !reflect.DeepEqual(got, []string{"a", "b"}) {
+		t.Errorf("got names %v", got)
+	}
+	if iofl.DefaultBandwidth.Limiter("a") != nil {
+		t.Error("configured limiter in DefaultBandwidth")
+	}
+	config.Bandwidth["a"] = 1
+	if got := s.Config().Bandwidth; !reflect.DeepEqual(got, map[string]int64{"a": 500, "b": 500}) {
+		t.Errorf("got %v", got)
+	}
+
+	// The Bandwidth of another ChainSet is separate.
+	other := iofl.NewChainSet()
+	if err := other.SetConfig(iofl.Config{Bandwidth: map[string]int64{"a": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	if s.Bandwidth().Limiter("a") == other.Bandwidth().Limiter("a") {
+		t.Error("ChainSets share a limiter")
+	}
+
+	// A limiter removed from the configuration is removed, and no longer
+	// limits filters attached to it.
+	l := s.Bandwidth().Limiter("b")
+	l.WaitN(iofl.PriorityNormal, 500)
+	if err := s.SetConfig(iofl.Config{Bandwidth: map[string]int64{"a": 500}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Bandwidth().Names(); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("got names %v", got)
+	}
+	start := time.Now()
+	l.WaitN(iofl.PriorityNormal, 1<<20)
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("removed limiter still limits")
+	}
+}
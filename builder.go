@@ -0,0 +1,47 @@
+package iofl
+
+// ChainBuilder builds a Chain in code.
+type ChainBuilder struct {
+	chain Chain
+}
+
+// NewChain returns a new ChainBuilder that starts with an empty chain.
+func NewChain() *ChainBuilder {
+	return &ChainBuilder{}
+}
+
+// Use appends a link that applies the given filter. If multiple Params are
+// given, they are merged, with later values taking precedence. Returns the
+// ChainBuilder.
+func (b *ChainBuilder) Use(filter string, params ...Params) *ChainBuilder {
+	def := LinkDef{Filter: filter}
+	switch len(params) {
+	case 0:
+	case 1:
+		def.Params = params[0]
+	default:
+		def.Params = Params{}
+		for _, p := range params {
+			for k, v := range p {
+				def.Params[k] = v
+			}
+		}
+	}
+	b.chain = append(b.chain, def)
+	return b
+}
+
+// UseChain appends a link that refers to the chain of the given name. Returns
+// the ChainBuilder.
+func (b *ChainBuilder) UseChain(name string) *ChainBuilder {
+	b.chain = append(b.chain, LinkDef{Chain: name})
+	return b
+}
+
+// Build returns the built Chain. The ChainBuilder may continue to be used
+// without affecting the result.
+func (b *ChainBuilder) Build() Chain {
+	chain := make(Chain, len(b.chain))
+	copy(chain, b.chain)
+	return chain
+}
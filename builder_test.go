@@ -0,0 +1,60 @@
+package iofl_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestChainBuilder(t *testing.T) {
+	b := iofl.NewChain().
+		Use("translate", iofl.Params{"from": "a", "to": "b"}, iofl.Params{"to": "c", "delete": "x"}).
+		UseChain("common").
+		Use("percent")
+	want := iofl.Chain{
+		{Filter: "translate", Params: iofl.Params{"from": "a", "to": "c", "delete": "x"}},
+		{Chain: "common"},
+		{Filter: "percent"},
+	}
+	got := b.Build()
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("got %#v, want %#v", got, want)
+	}
+
+	// Continued use of the builder does not affect built chains.
+	b.Use("percent")
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("built chain modified: %#v", got)
+	}
+	if n := len(b.Build()); n != 4 {
+		t.Errorf("got %d links, want 4", n)
+	}
+}
+
+func TestAddChain(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"lower": {{Filter: "translate", Params: iofl.Params{"preset": "lower"}}},
+	})
+	chain := iofl.NewChain().
+		UseChain("lower").
+		Use("translate", iofl.Params{"from": "a", "to": "4"}).
+		Build()
+	if err := s.AddChain("leet", chain); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddChain("leet", chain); err == nil {
+		t.Error("expected error adding existing chain")
+	}
+	if err := s.AddChain("lower", chain); err == nil {
+		t.Error("expected error adding configured chain")
+	}
+
+	f, err := s.Resolve("leet", source("BANANA"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "b4n4n4" {
+		t.Errorf("got %q, want %q", got, "b4n4n4")
+	}
+}
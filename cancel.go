@@ -0,0 +1,90 @@
+package iofl
+
+import (
+	"context"
+	"io"
+)
+
+// ContextFilter is implemented by a Filter that performs operations that can
+// be canceled, such as network or subprocess I/O. When a chain is resolved with
+// a context, through ResolveContext or the Cancel option, each ContextFilter of
+// the chain receives the context, and should abandon its operations once the
+// context is done.
+type ContextFilter interface {
+	// SetContext sets the context that bounds the operations of the filter.
+	// Called before the first Read.
+	SetContext(ctx context.Context)
+}
+
+// ResolveContext behaves the same as Resolve, with the Cancel option applied
+// with ctx.
+func (s *ChainSet) ResolveContext(ctx context.Context, chain string, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	opts = append(opts[:len(opts):len(opts)], Cancel(ctx))
+	return s.Resolve(chain, src, opts...)
+}
+
+// WithCancel returns a Filter that wraps f, such that a Read returns promptly
+// with ctx.Err() when ctx is done, even if a Read of f is blocked. Reads of f
+// are made in a separate goroutine. When ctx is done, a pending Read of f
+// continues in the background until it returns. Closing the Filter while a Read
+// is pending closes the root of the chain of f to unblock the Read, and waits
+// for it to return before closing f.
+func WithCancel(ctx context.Context, f Filter) Filter {
+	return &cancelFilter{f: f, ctx: ctx, async: asyncReader{r: f}}
+}
+
+// Cancel returns an Option that applies WithCancel to a resolved chain, and
+// passes ctx to each ContextFilter of the chain. Once ctx is done, a Read of
+// the chain returns ctx.Err() wrapped in a *ReadError that identifies the
+// final link of the chain, and the number of bytes produced by the chain. ctx
+// also bounds any wait for an instance of a limited chain.
+func Cancel(ctx context.Context) Option {
+	return func(o *resolveOptions) {
+		o.ctx = ctx
+		o.finishers = append(o.finishers, func(last Link, f Filter) Filter {
+			return &cancelFilter{f: f, ctx: ctx, async: asyncReader{r: f}, link: &last}
+		})
+	}
+}
+
+// cancelFilter returns the error of a context when it is done.
+type cancelFilter struct {
+	f     Filter
+	ctx   context.Context
+	async asyncReader
+
+	// link, if not nil, is the final link of the chain, identified by errors
+	// of the context.
+	link   *Link
+	offset int64
+}
+
+func (c *cancelFilter) Read(p []byte) (n int, err error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, c.error(err)
+	}
+	n, err = c.async.Read(p, c.ctx.Done())
+	c.offset += int64(n)
+	if err == errAborted {
+		return 0, c.error(c.ctx.Err())
+	}
+	return n, err
+}
+
+// error returns err, the error of the context, wrapped in a *ReadError if the
+// link is known.
+func (c *cancelFilter) error(err error) error {
+	if c.link == nil {
+		return err
+	}
+	return &ReadError{
+		Chain:  c.link.Chain,
+		Index:  c.link.Index,
+		Filter: c.link.Def.Filter,
+		Offset: c.offset,
+		Err:    err,
+	}
+}
+
+func (c *cancelFilter) Close() error          { return c.async.close(c.f) }
+func (c *cancelFilter) Source() io.ReadCloser { return c.f }
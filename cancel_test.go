@@ -0,0 +1,152 @@
+package iofl_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// ctxFilter is a Filter that records the context it receives.
+type ctxFilter struct {
+	funcFilter
+	ctx context.Context
+}
+
+func (f *ctxFilter) SetContext(ctx context.Context) { f.ctx = ctx }
+
+func TestWithCancel(t *testing.T) {
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	f := iofl.WithCancel(ctx, iofl.Root{pr})
+	done := make(chan error, 1)
+	go func() {
+		_, err := f.Read(make([]byte, 8))
+		done <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("read did not return after cancel")
+	}
+	if _, err := f.Read(make([]byte, 8)); err != context.Canceled {
+		t.Errorf("subsequent read: got %v, want context.Canceled", err)
+	}
+	f.Close()
+}
+
+func TestCancelOption(t *testing.T) {
+	var got *ctxFilter
+	def := iofl.FilterDef{
+		Name: "ctx",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			got = &ctxFilter{funcFilter: funcFilter{src: r, read: r.Read}}
+			return got, nil
+		},
+	}
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "ctx"}, {Filter: "translate"}},
+	}, def)
+
+	pr, pw := io.Pipe()
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := s.ResolveContext(ctx, "c", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if got == nil || got.ctx != ctx {
+		t.Error("ContextFilter did not receive context")
+	}
+
+	go pw.Write([]byte("abc"))
+	p := make([]byte, 3)
+	if _, err := io.ReadFull(f, p); err != nil || string(p) != "abc" {
+		t.Fatalf("got %q, %v", p, err)
+	}
+
+	time.AfterFunc(10*time.Millisecond, cancel)
+	_, err = f.Read(p)
+	var rerr *iofl.ReadError
+	if !errors.As(err, &rerr) || !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want *ReadError wrapping context.Canceled", err)
+	}
+	if rerr.Chain != "c" || rerr.Index != 1 || rerr.Filter != "translate" || rerr.Offset != 3 {
+		t.Errorf("got %+v", *rerr)
+	}
+	pw.Close()
+}
+
+func TestCancelDeadline(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	f, err := s.Resolve("c", pr, iofl.Cancel(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestCancelClose(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := s.ResolveContext(ctx, "c", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := f.Read(make([]byte, 8)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	// The read of the chain remains pending. Closing unblocks it by closing
+	// the root of the chain, and waits for it to return.
+	closed := make(chan error, 1)
+	go func() { closed <- f.Close() }()
+	select {
+	case <-closed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not unblock pending read")
+	}
+	if _, err := pw.Write([]byte("x")); err != io.ErrClosedPipe {
+		t.Errorf("got %v, want closed source", err)
+	}
+}
+
+func TestCancelCloseFile(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	f, err := s.ResolveContext(ctx, "c", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	time.AfterFunc(10*time.Millisecond, cancel)
+	if _, err := f.Read(make([]byte, 8)); !errors.Is(err, context.Canceled) {
+		t.Fatalf("got %v, want context.Canceled", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+}
@@ -0,0 +1,119 @@
+package iofl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// Unavailable is returned when resolving a filter that was registered from a
+// Catalog, and therefore has no implementation.
+var Unavailable = errors.New("filter unavailable")
+
+// Catalog is a machine-readable description of the filters registered with a
+// ChainSet, encoded as JSON. A Catalog exported by one program can be
+// registered with a ChainSet of another, allowing configurations to be
+// validated against the filters supported by the exporting program.
+type Catalog struct {
+	// Version is the configuration version of the exporting ChainSet.
+	Version int `json:"version"`
+	// Filters describes each filter, in order of name.
+	Filters []CatalogFilter `json:"filters"`
+}
+
+// CatalogFilter describes a filter within a Catalog.
+type CatalogFilter struct {
+	Name        string     `json:"name"`
+	Version     string     `json:"version,omitempty"`
+	Description string     `json:"description,omitempty"`
+	Params      []ParamDef `json:"params,omitempty"`
+	// StrictParams indicates that Params declares every parameter accepted
+	// by the filter.
+	StrictParams bool `json:"strictParams,omitempty"`
+	// Writable indicates that the filter can be used in a write chain.
+	Writable bool `json:"writable,omitempty"`
+}
+
+// Catalog returns a Catalog describing the filters registered with the
+// ChainSet.
+func (s *ChainSet) Catalog() Catalog {
+	c := Catalog{Version: s.Version()}
+	for _, def := range s.Filters() {
+		c.Filters = append(c.Filters, CatalogFilter{
+			Name:         def.Name,
+			Version:      def.Version,
+			Description:  def.Description,
+			Params:       append([]ParamDef(nil), def.Params...),
+			StrictParams: def.StrictParams,
+			Writable:     def.NewWriter != nil,
+		})
+	}
+	return c
+}
+
+// LoadCatalog reads a Catalog encoded as JSON from r.
+func LoadCatalog(r io.Reader) (c Catalog, err error) {
+	err = json.NewDecoder(r).Decode(&c)
+	return c, err
+}
+
+// Merge adds the filters of other to c. A filter of other replaces a filter of
+// c with the same name. The version of c becomes the greater of the two.
+func (c *Catalog) Merge(other Catalog) {
+	index := make(map[string]int, len(c.Filters))
+	for i, f := range c.Filters {
+		index[f.Name] = i
+	}
+	for _, f := range other.Filters {
+		if i, ok := index[f.Name]; ok {
+			c.Filters[i] = f
+			continue
+		}
+		index[f.Name] = len(c.Filters)
+		c.Filters = append(c.Filters, f)
+	}
+	sort.Slice(c.Filters, func(i, j int) bool { return c.Filters[i].Name < c.Filters[j].Name })
+	if other.Version > c.Version {
+		c.Version = other.Version
+	}
+}
+
+// RegisterCatalog registers a filter definition for each filter of c that is
+// not already registered. The definitions describe the filters for
+// validation and documentation, but resolving them returns Unavailable.
+//
+// To validate a configuration against exactly the filters of a Catalog,
+// register it with an empty ChainSet.
+func (s *ChainSet) RegisterCatalog(c Catalog) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, f := range c.Filters {
+		if f.Name == "" {
+			return errors.New("catalog filter has no name")
+		}
+		if _, ok := s.registry[f.Name]; ok {
+			continue
+		}
+		def := FilterDef{
+			Name:         f.Name,
+			Version:      f.Version,
+			Description:  f.Description,
+			Params:       append([]ParamDef(nil), f.Params...),
+			StrictParams: f.StrictParams,
+			New: func(Params, io.ReadCloser) (Filter, error) {
+				return nil, Unavailable
+			},
+		}
+		if f.Writable {
+			def.NewWriter = func(Params, io.WriteCloser) (WriteFilter, error) {
+				return nil, Unavailable
+			}
+		}
+		if err := s.register(def); err != nil {
+			return fmt.Errorf("catalog: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,128 @@
+package iofl_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestCatalog(t *testing.T) {
+	src := newChainSet(t, nil)
+	c := src.Catalog()
+	if len(c.Filters) != len(src.Filters()) {
+		t.Fatalf("got %d filters, want %d", len(c.Filters), len(src.Filters()))
+	}
+	for i := 1; i < len(c.Filters); i++ {
+		if c.Filters[i-1].Name >= c.Filters[i].Name {
+			t.Fatalf("filters not in order of name: %q, %q", c.Filters[i-1].Name, c.Filters[i].Name)
+		}
+	}
+	var translate iofl.CatalogFilter
+	for _, f := range c.Filters {
+		if f.Name == "translate" {
+			translate = f
+		}
+	}
+	if !translate.StrictParams || len(translate.Params) == 0 {
+		t.Errorf("got %+v", translate)
+	}
+
+	var buf bytes.Buffer
+	if err := json.NewEncoder(&buf).Encode(c); err != nil {
+		t.Fatal(err)
+	}
+	loaded, err := iofl.LoadCatalog(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded, c) {
+		t.Error("loaded catalog does not match")
+	}
+
+	// A ChainSet registered with only the catalog validates configurations
+	// like the exporting ChainSet.
+	s := iofl.NewChainSet()
+	if err := s.RegisterCatalog(loaded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(s.Catalog(), c) {
+		t.Error("catalog of registered filters does not match")
+	}
+	err = s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"c": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chain := range []iofl.Chain{
+		{{Filter: "missing"}},
+		{{Filter: "translate", Params: iofl.Params{"unknown": "x"}}},
+	} {
+		if err := s.Validate(iofl.Config{Chains: map[string]iofl.Chain{"c": chain}}); err == nil {
+			t.Errorf("%v: expected error", chain)
+		}
+	}
+	if _, err := s.Resolve("c", source("abc")); !errors.Is(err, iofl.Unavailable) {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+}
+
+func TestCatalogMerge(t *testing.T) {
+	c := iofl.Catalog{Version: 2, Filters: []iofl.CatalogFilter{
+		{Name: "b", Version: "1"},
+		{Name: "d"},
+	}}
+	c.Merge(iofl.Catalog{Version: 1, Filters: []iofl.CatalogFilter{
+		{Name: "c"},
+		{Name: "b", Version: "2"},
+		{Name: "a"},
+	}})
+	want := iofl.Catalog{Version: 2, Filters: []iofl.CatalogFilter{
+		{Name: "a"},
+		{Name: "b", Version: "2"},
+		{Name: "c"},
+		{Name: "d"},
+	}}
+	if !reflect.DeepEqual(c, want) {
+		t.Errorf("got %+v, want %+v", c, want)
+	}
+	c.Merge(iofl.Catalog{Version: 3})
+	if c.Version != 3 {
+		t.Errorf("got version %d, want 3", c.Version)
+	}
+}
+
+func TestRegisterCatalog(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}}})
+	// Registered filters are not replaced.
+	err := s.RegisterCatalog(iofl.Catalog{Filters: []iofl.CatalogFilter{
+		{Name: "translate"},
+		{Name: "remote", Writable: true},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.Resolve("c", source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ABC" {
+		t.Errorf("got %q", got)
+	}
+	if err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{"r": {{Filter: "remote"}}}}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.ResolveWriter("r", nopWriteCloser{&bytes.Buffer{}}); !errors.Is(err, iofl.Unavailable) {
+		t.Errorf("got %v, want Unavailable", err)
+	}
+	if err := s.RegisterCatalog(iofl.Catalog{Filters: []iofl.CatalogFilter{{}}}); err == nil {
+		t.Error("expected error for filter without name")
+	}
+	if _, err := iofl.LoadCatalog(bytes.NewReader([]byte("{"))); err == nil {
+		t.Error("expected error for malformed catalog")
+	}
+}
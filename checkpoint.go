@@ -0,0 +1,195 @@
+package iofl
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// BadCheckpoint is returned when restoring a malformed checkpoint.
+var BadCheckpoint = errors.New("malformed checkpoint")
+
+// checkpointVersion is the version of the checkpoint encoding.
+const checkpointVersion = 1
+
+// stateFilters returns each Filter in the chain of f that has a source, from
+// the last link to the first. Returns an error if such a Filter does not
+// implement StateSaver.
+func stateFilters(f Filter) (savers []StateSaver, err error) {
+	err = Apply(f, func(r io.ReadCloser) error {
+		f, ok := r.(Filter)
+		if !ok || f.Source() == nil || isResolved(f) {
+			return nil
+		}
+		ss, ok := f.(StateSaver)
+		if !ok {
+			return fmt.Errorf("%T does not implement StateSaver", f)
+		}
+		savers = append(savers, ss)
+		return nil
+	})
+	return savers, err
+}
+
+// Checkpoint returns the saved state of each Filter in the chain of f. Every
+// Filter in the chain that has a source must implement StateSaver. Options that
+// decorate links generally produce Filters that do not.
+//
+// The state of the root of the chain is not included. To resume the chain,
+// the caller must position the root at the offset where it was checkpointed,
+// such as by counting the bytes read from it.
+func Checkpoint(f Filter) (state []byte, err error) {
+	savers, err := stateFilters(f)
+	if err != nil {
+		return nil, err
+	}
+	var n [binary.MaxVarintLen64]byte
+	state = append(state, checkpointVersion)
+	state = append(state, n[:binary.PutUvarint(n[:], uint64(len(savers)))]...)
+	for i, ss := range savers {
+		s, err := ss.SaveState()
+		if err != nil {
+			return nil, fmt.Errorf("filter %d: %w", i, err)
+		}
+		state = append(state, n[:binary.PutUvarint(n[:], uint64(len(s)))]...)
+		state = append(state, s...)
+	}
+	return state, nil
+}
+
+// Restore resolves chain with src, and restores the state of each Filter in
+// the resolved chain from state, previously returned by Checkpoint for the same
+// chain and configuration. src should be positioned where the root of the
+// checkpointed chain was.
+func (s *ChainSet) Restore(chain string, state []byte, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	if len(state) == 0 || state[0] != checkpointVersion {
+		return nil, BadCheckpoint
+	}
+	state = state[1:]
+	count, w := binary.Uvarint(state)
+	if w <= 0 {
+		return nil, BadCheckpoint
+	}
+	state = state[w:]
+
+	if filter, err = s.Resolve(chain, src, opts...); err != nil {
+		return nil, err
+	}
+	savers, err := stateFilters(filter)
+	if err != nil {
+		return nil, err
+	}
+	if uint64(len(savers)) != count {
+		return nil, fmt.Errorf("checkpoint has %d filters, chain has %d", count, len(savers))
+	}
+	for i, ss := range savers {
+		n, w := binary.Uvarint(state)
+		if w <= 0 || uint64(len(state)-w) < n {
+			return nil, BadCheckpoint
+		}
+		if err := ss.RestoreState(state[w : w+int(n)]); err != nil {
+			return nil, fmt.Errorf("filter %d: %w", i, err)
+		}
+		state = state[w+int(n):]
+	}
+	return filter, nil
+}
+
+// CheckpointStore persists checkpoints by key.
+type CheckpointStore interface {
+	// Save stores state under key, replacing any existing state.
+	Save(key string, state []byte) error
+	// Load returns the state stored under key, or nil if there is no such
+	// state.
+	Load(key string) (state []byte, err error)
+	// Delete removes the state stored under key, if present.
+	Delete(key string) error
+}
+
+// DirStore is a CheckpointStore that stores each checkpoint as a file within a
+// directory. Files are replaced atomically.
+type DirStore string
+
+func (d DirStore) path(key string) string {
+	return filepath.Join(string(d), url.PathEscape(key)+".checkpoint")
+}
+
+// Save implements CheckpointStore.
+func (d DirStore) Save(key string, state []byte) error {
+	tmp, err := ioutil.TempFile(string(d), ".checkpoint-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(state); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return os.Rename(tmp.Name(), d.path(key))
+}
+
+// Load implements CheckpointStore.
+func (d DirStore) Load(key string) (state []byte, err error) {
+	state, err = ioutil.ReadFile(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	return state, err
+}
+
+// Delete implements CheckpointStore.
+func (d DirStore) Delete(key string) error {
+	err := os.Remove(d.path(key))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	return err
+}
+
+// MemStore is a CheckpointStore that stores checkpoints in memory. It is safe
+// for concurrent use.
+type MemStore struct {
+	mu     sync.Mutex
+	states map[string][]byte
+}
+
+// Save implements CheckpointStore.
+func (m *MemStore) Save(key string, state []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.states == nil {
+		m.states = map[string][]byte{}
+	}
+	m.states[key] = append([]byte(nil), state...)
+	return nil
+}
+
+// Load implements CheckpointStore.
+func (m *MemStore) Load(key string) (state []byte, err error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]byte(nil), m.states[key]...), nil
+}
+
+// Delete implements CheckpointStore.
+func (m *MemStore) Delete(key string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.states, key)
+	return nil
+}
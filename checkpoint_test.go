@@ -0,0 +1,124 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func checkpointSet(t *testing.T) *iofl.ChainSet {
+	return newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "translate", Params: iofl.Params{"preset": "upper", "bufferSize": 16}},
+			{Filter: "percent", Params: iofl.Params{"bufferSize": 16}},
+		},
+		"short": {{Filter: "translate"}},
+		"hook":  {{Filter: "translate"}, {Filter: "hook"}},
+	}, hookFilter("hook", func() {}))
+}
+
+func TestCheckpoint(t *testing.T) {
+	s := checkpointSet(t)
+	in := []byte(strings.Repeat("hello, wörld/", 50))
+	f, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readAll(t, f)
+
+	for _, n := range []int{0, 1, 10, 100, len(want)} {
+		src := bytes.NewReader(in)
+		f, err := s.Resolve("c", ioutil.NopCloser(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		head := make([]byte, n)
+		if _, err := io.ReadFull(f, head); err != nil {
+			t.Fatal(err)
+		}
+		state, err := iofl.Checkpoint(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+
+		g, err := s.Restore("c", state, ioutil.NopCloser(src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := string(head) + readAll(t, g); got != want {
+			t.Errorf("resumed at %d: got %q, want %q", n, got, want)
+		}
+	}
+}
+
+func TestCheckpointErrors(t *testing.T) {
+	s := checkpointSet(t)
+	f, err := s.Resolve("hook", source("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := iofl.Checkpoint(f); err == nil {
+		t.Error("expected error for filter without StateSaver")
+	}
+	f.Close()
+
+	f, err = s.Resolve("c", source("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	state, err := iofl.Checkpoint(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	for _, bad := range [][]byte{nil, {2}, {1}, state[:len(state)-1]} {
+		if _, err := s.Restore("c", bad, source("")); !errors.Is(err, iofl.BadCheckpoint) {
+			t.Errorf("%q: got %v, want BadCheckpoint", bad, err)
+		}
+	}
+	if _, err := s.Restore("short", state, source("")); err == nil {
+		t.Error("expected error for mismatched chain")
+	}
+	if _, err := s.Restore("missing", state, source("")); err == nil {
+		t.Error("expected error for unknown chain")
+	}
+}
+
+func TestCheckpointStore(t *testing.T) {
+	stores := map[string]iofl.CheckpointStore{
+		"dir": iofl.DirStore(t.TempDir()),
+		"mem": &iofl.MemStore{},
+	}
+	for name, store := range stores {
+		for _, key := range []string{"a", "job/with spaces"} {
+			if state, err := store.Load(key); err != nil || state != nil {
+				t.Errorf("%s: %s: load missing: got %q, %v", name, key, state, err)
+			}
+			if err := store.Save(key, []byte("one")); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if err := store.Save(key, []byte("two")); err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if state, err := store.Load(key); err != nil || string(state) != "two" {
+				t.Errorf("%s: %s: got %q, %v", name, key, state, err)
+			}
+			if err := store.Delete(key); err != nil {
+				t.Errorf("%s: %s: delete: %v", name, key, err)
+			}
+			if err := store.Delete(key); err != nil {
+				t.Errorf("%s: %s: delete missing: %v", name, key, err)
+			}
+			if state, _ := store.Load(key); state != nil {
+				t.Errorf("%s: %s: state remains after delete", name, key)
+			}
+		}
+	}
+}
@@ -0,0 +1,190 @@
+// The iofl command provides tools for working with iofl configurations.
+//
+// Usage:
+//
+//	iofl doc [-config file] [-format markdown|html]
+//	iofl catalog
+//	iofl validate [-catalog file]... config
+//
+// The doc command writes documentation of the built-in filters, and of the
+// chains in the given JSON configuration file, to standard output.
+//
+// The catalog command writes a catalog of the built-in filters, encoded as
+// JSON, to standard output.
+//
+// The validate command checks the given JSON configuration file. If catalog
+// files are given, the configuration is checked against the merged filters of
+// the catalogs, such as those exported by the programs that will run the
+// configuration. Otherwise, it is checked against the built-in filters.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/iofldoc"
+)
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+	var err error
+	switch os.Args[1] {
+	case "doc":
+		err = doc(os.Args[2:])
+	case "catalog":
+		err = catalog(os.Args[2:])
+	case "validate":
+		err = validate(os.Args[2:])
+	default:
+		usage()
+	}
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, "usage: iofl doc [-config file] [-format markdown|html]")
+	fmt.Fprintln(os.Stderr, "       iofl catalog")
+	fmt.Fprintln(os.Stderr, "       iofl validate [-catalog file]... config")
+	os.Exit(2)
+}
+
+// loadChainSet returns a ChainSet with the built-in filters registered, and
+// configured by the given file, if not empty.
+func loadChainSet(path string) (*iofl.ChainSet, error) {
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		return nil, err
+	}
+	// Chains are only documented and validated, never run, so the filters
+	// that require a trusted configuration are included.
+	if err := filters.RegisterUnsafe(s); err != nil {
+		return nil, err
+	}
+	if path == "" {
+		return s, nil
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	config, err := iofl.LoadConfig(file, "json")
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.SetConfig(config); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return s, nil
+}
+
+func doc(args []string) error {
+	flags := flag.NewFlagSet("doc", flag.ExitOnError)
+	config := flags.String("config", "", "JSON configuration file")
+	format := flags.String("format", "markdown", "output format: markdown or html")
+	flags.Parse(args)
+	s, err := loadChainSet(*config)
+	if err != nil {
+		return err
+	}
+	d := iofldoc.New(s)
+	switch *format {
+	case "markdown", "md":
+		return d.Markdown(os.Stdout)
+	case "html":
+		return d.HTML(os.Stdout)
+	default:
+		return fmt.Errorf("unknown format %q", *format)
+	}
+}
+
+func catalog(args []string) error {
+	flags := flag.NewFlagSet("catalog", flag.ExitOnError)
+	flags.Parse(args)
+	s, err := loadChainSet("")
+	if err != nil {
+		return err
+	}
+	e := json.NewEncoder(os.Stdout)
+	e.SetIndent("", "\t")
+	return e.Encode(s.Catalog())
+}
+
+// stringsFlag is a flag that may be given several times.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return fmt.Sprint(*f)
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func validate(args []string) error {
+	flags := flag.NewFlagSet("validate", flag.ExitOnError)
+	var catalogs stringsFlag
+	flags.Var(&catalogs, "catalog", "JSON catalog file; may be given several times")
+	flags.Parse(args)
+	if flags.NArg() != 1 {
+		usage()
+	}
+	path := flags.Arg(0)
+	var s *iofl.ChainSet
+	if len(catalogs) == 0 {
+		var err error
+		if s, err = loadChainSet(""); err != nil {
+			return err
+		}
+	} else {
+		var merged iofl.Catalog
+		for _, name := range catalogs {
+			c, err := loadCatalog(name)
+			if err != nil {
+				return err
+			}
+			merged.Merge(c)
+		}
+		s = iofl.NewChainSet()
+		if err := s.RegisterCatalog(merged); err != nil {
+			return err
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	config, err := iofl.LoadConfig(file, "json")
+	if err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	if err := s.Validate(config); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+	return nil
+}
+
+// loadCatalog reads a catalog from the given file.
+func loadCatalog(path string) (iofl.Catalog, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return iofl.Catalog{}, err
+	}
+	defer file.Close()
+	c, err := iofl.LoadCatalog(file)
+	if err != nil {
+		return c, fmt.Errorf("%s: %w", path, err)
+	}
+	return c, nil
+}
@@ -0,0 +1,73 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// translate returns a constructor of a translate filter with the given params.
+func translate(params iofl.Params) func(io.ReadCloser) (iofl.Filter, error) {
+	return func(r io.ReadCloser) (iofl.Filter, error) {
+		return filters.Translate.New(params, r)
+	}
+}
+
+func TestCompose(t *testing.T) {
+	f, err := iofl.Compose(source("banana"),
+		translate(iofl.Params{"from": "a", "to": "o"}),
+		translate(iofl.Params{"preset": "upper"}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "BONONO" {
+		t.Errorf("got %q, want %q", got, "BONONO")
+	}
+}
+
+func TestComposeEmpty(t *testing.T) {
+	f, err := iofl.Compose(nil)
+	if err != nil || f != nil {
+		t.Errorf("got %#v, %v, want nil filter", f, err)
+	}
+
+	src := source("x")
+	f, err = iofl.Compose(src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r, ok := f.(iofl.Root); !ok || r.ReadCloser != src {
+		t.Errorf("got %#v, want Root of source", f)
+	}
+}
+
+func TestComposeError(t *testing.T) {
+	errCtor := errors.New("ctor")
+	var calls int
+	_, err := iofl.Compose(source("x"),
+		translate(nil),
+		func(io.ReadCloser) (iofl.Filter, error) { return nil, errCtor },
+		func(r io.ReadCloser) (iofl.Filter, error) { calls++; return iofl.AsFilter(r), nil },
+	)
+	var rerr *iofl.ResolveError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v, want *ResolveError", err)
+	}
+	if rerr.Index != 1 || rerr.Chain != "" || !errors.Is(err, errCtor) {
+		t.Errorf("got %#v", rerr)
+	}
+	var cerr iofl.ChainError
+	if !errors.As(err, &cerr) {
+		t.Fatal("error is not a ChainError")
+	}
+	if chain, index, _ := cerr.ChainLink(); chain != "" || index != 1 {
+		t.Errorf("got link %q[%d]", chain, index)
+	}
+	if calls != 0 {
+		t.Error("constructor after failure was called")
+	}
+}
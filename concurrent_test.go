@@ -0,0 +1,91 @@
+package iofl_test
+
+import (
+	"fmt"
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// TestChainSetConcurrent exercises a ChainSet from many goroutines, and is
+// intended to be run with the race detector.
+func TestChainSetConcurrent(t *testing.T) {
+	configs := []iofl.Config{
+		{Chains: map[string]iofl.Chain{"c": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}}}},
+		{Chains: map[string]iofl.Chain{"c": {{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}}}},
+	}
+	s := newChainSet(t, configs[0].Chains)
+	var constructed int64
+	count := func(string, int, iofl.LinkDef, iofl.Filter) {
+		atomic.AddInt64(&constructed, 1)
+	}
+	s.OnConstruct(count)
+	const n = 50
+	var wg sync.WaitGroup
+	errs := make(chan error, 8*n)
+	spawn := func(fn func(i int) error) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < n; i++ {
+				if err := fn(i); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}()
+	}
+
+	for r := 0; r < 4; r++ {
+		spawn(func(int) error {
+			f, err := s.Resolve("c", source("abc"))
+			if err != nil {
+				return err
+			}
+			b, err := io.ReadAll(f)
+			f.Close()
+			if err != nil {
+				return err
+			}
+			// Each resolution uses one configuration or the other.
+			if got := string(b); got != "ABC" && got != "nop" {
+				return fmt.Errorf("got %q", got)
+			}
+			return nil
+		})
+	}
+	spawn(func(i int) error {
+		return s.SetConfig(configs[i%2])
+	})
+	spawn(func(i int) error {
+		return s.Register(iofl.FilterDef{Name: fmt.Sprintf("concurrent%d", i), New: filters.Translate.New})
+	})
+	spawn(func(int) error {
+		s.OnConstruct(count)
+		s.SetLogger(nil)
+		return nil
+	})
+	spawn(func(int) error {
+		s.Filters()
+		s.Catalog()
+		s.Config()
+		s.Version()
+		return s.Verify()
+	})
+
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+	if got := len(s.Filters()); got < n {
+		t.Errorf("got %d filters", got)
+	}
+	if atomic.LoadInt64(&constructed) < 4*n {
+		t.Error("hooks not called")
+	}
+}
@@ -0,0 +1,244 @@
+package iofl
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// FormatFunc converts a configuration encoded in some format to JSON.
+type FormatFunc func(b []byte) ([]byte, error)
+
+// formats is the registry of configuration formats other than JSON.
+var formats = struct {
+	sync.RWMutex
+	m map[string]FormatFunc
+}{m: map[string]FormatFunc{}}
+
+// RegisterFormat registers a configuration format for use with LoadConfig,
+// replacing any format of the same name. The format is implemented by
+// converting to JSON. The "json" format is built in, and cannot be replaced.
+//
+// No other format is built in. In particular, YAML is not decoded by the
+// package, which would otherwise depend on a YAML library. A program that
+// wants YAML configurations registers a converter from such a library:
+//
+//	iofl.RegisterFormat("yaml", yaml.YAMLToJSON) // sigs.k8s.io/yaml
+func RegisterFormat(name string, toJSON FormatFunc) {
+	formats.Lock()
+	defer formats.Unlock()
+	formats.m[name] = toJSON
+}
+
+// LoadConfig reads a configuration of the given format from r. The format is
+// either "json", or a format registered with RegisterFormat. Any other format
+// returns an error. "yaml" is not supported unless registered.
+func LoadConfig(r io.Reader, format string) (config Config, err error) {
+	b, err := io.ReadAll(r)
+	if err != nil {
+		return config, err
+	}
+	if format != "json" {
+		formats.RLock()
+		toJSON, ok := formats.m[format]
+		formats.RUnlock()
+		if !ok {
+			return config, fmt.Errorf("unknown config format %q", format)
+		}
+		if b, err = toJSON(b); err != nil {
+			return config, err
+		}
+	}
+	err = json.Unmarshal(b, &config)
+	return config, err
+}
+
+// jsonConfig is the JSON representation of a Config.
+type jsonConfig struct {
+	Version   int                     `json:"version,omitempty"`
+	Chains    map[string]Chain        `json:"chains"`
+	Bandwidth map[string]int64        `json:"bandwidth,omitempty"`
+	Limits    map[string]jsonLimit    `json:"limits,omitempty"`
+	Tests     map[string][]jsonVector `json:"tests,omitempty"`
+	Jobs      map[string]jsonJob      `json:"jobs,omitempty"`
+	Vars      map[string][]VarDef     `json:"vars,omitempty"`
+
+	DefaultChain string `json:"defaultChain,omitempty"`
+}
+
+// jsonLimit is the JSON representation of a ChainLimit.
+type jsonLimit struct {
+	Max       int   `json:"max,omitempty"`
+	Wait      bool  `json:"wait,omitempty"`
+	MaxMemory int64 `json:"maxMemory,omitempty"`
+}
+
+// jsonVector is the JSON representation of a TestVector.
+type jsonVector struct {
+	Name   string `json:"name,omitempty"`
+	Input  string `json:"input,omitempty"`
+	File   string `json:"file,omitempty"`
+	SHA256 string `json:"sha256"`
+}
+
+// jsonJob is the JSON representation of a JobDef. The interval is encoded as
+// a duration string, such as "1h30m".
+type jsonJob struct {
+	Source   string `json:"source"`
+	Chain    string `json:"chain"`
+	Sink     string `json:"sink"`
+	Interval string `json:"interval,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler. Fields are encoded with lowercase
+// names.
+func (c Config) MarshalJSON() ([]byte, error) {
+	j := jsonConfig{
+		Version:   c.Version,
+		Chains:    c.Chains,
+		Bandwidth: c.Bandwidth,
+		Vars:      c.Vars,
+
+		DefaultChain: c.DefaultChain,
+	}
+	if c.Limits != nil {
+		j.Limits = make(map[string]jsonLimit, len(c.Limits))
+		for k, v := range c.Limits {
+			j.Limits[k] = jsonLimit(v)
+		}
+	}
+	if c.Tests != nil {
+		j.Tests = make(map[string][]jsonVector, len(c.Tests))
+		for k, v := range c.Tests {
+			vectors := make([]jsonVector, len(v))
+			for i, vector := range v {
+				vectors[i] = jsonVector(vector)
+			}
+			j.Tests[k] = vectors
+		}
+	}
+	if c.Jobs != nil {
+		j.Jobs = make(map[string]jsonJob, len(c.Jobs))
+		for k, v := range c.Jobs {
+			job := jsonJob{Source: v.Source, Chain: v.Chain, Sink: v.Sink}
+			if v.Interval != 0 {
+				job.Interval = v.Interval.String()
+			}
+			j.Jobs[k] = job
+		}
+	}
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON implements json.Unmarshaler. As with encoding/json, field
+// names are matched case-insensitively.
+func (c *Config) UnmarshalJSON(b []byte) error {
+	var j jsonConfig
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*c = Config{
+		Version:   j.Version,
+		Chains:    j.Chains,
+		Bandwidth: j.Bandwidth,
+		Vars:      j.Vars,
+
+		DefaultChain: j.DefaultChain,
+	}
+	if j.Limits != nil {
+		c.Limits = make(map[string]ChainLimit, len(j.Limits))
+		for k, v := range j.Limits {
+			c.Limits[k] = ChainLimit(v)
+		}
+	}
+	if j.Tests != nil {
+		c.Tests = make(map[string][]TestVector, len(j.Tests))
+		for k, v := range j.Tests {
+			vectors := make([]TestVector, len(v))
+			for i, vector := range v {
+				vectors[i] = TestVector(vector)
+			}
+			c.Tests[k] = vectors
+		}
+	}
+	if j.Jobs != nil {
+		c.Jobs = make(map[string]JobDef, len(j.Jobs))
+		for k, v := range j.Jobs {
+			job := JobDef{Source: v.Source, Chain: v.Chain, Sink: v.Sink}
+			if v.Interval != "" {
+				d, err := time.ParseDuration(v.Interval)
+				if err != nil {
+					return fmt.Errorf("job %q: interval: %w", k, err)
+				}
+				job.Interval = d
+			}
+			c.Jobs[k] = job
+		}
+	}
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding the chain as a list of links.
+func (c Chain) MarshalJSON() ([]byte, error) {
+	if c == nil {
+		return []byte("[]"), nil
+	}
+	return json.Marshal([]LinkDef(c))
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding a list of links.
+func (c *Chain) UnmarshalJSON(b []byte) error {
+	var links []LinkDef
+	if err := json.Unmarshal(b, &links); err != nil {
+		return err
+	}
+	*c = links
+	return nil
+}
+
+// jsonLink is the JSON representation of a LinkDef.
+type jsonLink struct {
+	Filter string `json:"filter,omitempty"`
+	Params Params `json:"params,omitempty"`
+	Chain  string `json:"chain,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler, encoding the link as an object with
+// the fields "filter", "params", and "chain", omitting those that are empty.
+func (l LinkDef) MarshalJSON() ([]byte, error) {
+	return json.Marshal(jsonLink(l))
+}
+
+// UnmarshalJSON implements json.Unmarshaler. In addition to an object, a link
+// may be a string, which is the name of a filter without params.
+func (l *LinkDef) UnmarshalJSON(b []byte) error {
+	var filter string
+	if err := json.Unmarshal(b, &filter); err == nil {
+		*l = LinkDef{Filter: filter}
+		return nil
+	}
+	var j jsonLink
+	if err := json.Unmarshal(b, &j); err != nil {
+		return err
+	}
+	*l = LinkDef(j)
+	return nil
+}
+
+// MarshalJSON implements json.Marshaler, encoding the params as an object.
+func (p Params) MarshalJSON() ([]byte, error) {
+	return json.Marshal(map[string]interface{}(p))
+}
+
+// UnmarshalJSON implements json.Unmarshaler, decoding an object. Numbers are
+// decoded as float64, as expected by GetInt.
+func (p *Params) UnmarshalJSON(b []byte) error {
+	var m map[string]interface{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return err
+	}
+	*p = m
+	return nil
+}
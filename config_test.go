@@ -0,0 +1,121 @@
+package iofl_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestLoadConfig(t *testing.T) {
+	const src = `{
+		"version": 1,
+		"chains": {
+			"c": ["identity", {"filter": "translate", "params": {"preset": "upper", "n": 3}}],
+			"ref": [{"chain": "c"}]
+		},
+		"limits": {"c": {"max": 2, "wait": true}},
+		"tests": {"c": [{"input": "a", "sha256": "00"}]},
+		"jobs": {"j": {"source": "file:in", "chain": "c", "sink": "file:out", "interval": "1m30s"}},
+		"defaultChain": "c"
+	}`
+	config, err := iofl.LoadConfig(strings.NewReader(src), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := iofl.Config{
+		Version: 1,
+		Chains: map[string]iofl.Chain{
+			"c":   {{Filter: "identity"}, {Filter: "translate", Params: iofl.Params{"preset": "upper", "n": 3.0}}},
+			"ref": {{Chain: "c"}},
+		},
+		Limits:       map[string]iofl.ChainLimit{"c": {Max: 2, Wait: true}},
+		Tests:        map[string][]iofl.TestVector{"c": {{Input: "a", SHA256: "00"}}},
+		Jobs:         map[string]iofl.JobDef{"j": {Source: "file:in", Chain: "c", Sink: "file:out", Interval: 90 * time.Second}},
+		DefaultChain: "c",
+	}
+	if !reflect.DeepEqual(config, want) {
+		t.Fatalf("got %+v, want %+v", config, want)
+	}
+	if n := config.Chains["c"][1].Params.GetInt("n"); n != 3 {
+		t.Errorf("got n %d, want 3", n)
+	}
+
+	// The configuration survives a round trip.
+	b, err := json.Marshal(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := iofl.LoadConfig(bytes.NewReader(b), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(again, want) {
+		t.Errorf("round trip: got %+v, want %+v", again, want)
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	for _, src := range []string{
+		`{"chains": {"c": [1]}}`,
+		`{"chains": {"c": {"filter": "identity"}}}`,
+		`{"chains": {"c": [{"params": []}]}}`,
+		`{"jobs": {"j": {"interval": "soon"}}}`,
+		`{"chains": `,
+	} {
+		if _, err := iofl.LoadConfig(strings.NewReader(src), "json"); err == nil {
+			t.Errorf("%s: expected error", src)
+		}
+	}
+	// No format other than JSON is built in.
+	_, err := iofl.LoadConfig(strings.NewReader("chains: {}"), "yaml")
+	if err == nil || !strings.Contains(err.Error(), `unknown config format "yaml"`) {
+		t.Errorf("got %v, want error for unregistered format", err)
+	}
+}
+
+func TestRegisterFormat(t *testing.T) {
+	errFormat := errors.New("bad format")
+	// The "wrapped" format is JSON within parentheses.
+	iofl.RegisterFormat("wrapped", func(b []byte) ([]byte, error) {
+		if len(b) < 2 || b[0] != '(' || b[len(b)-1] != ')' {
+			return nil, errFormat
+		}
+		return b[1 : len(b)-1], nil
+	})
+	config, err := iofl.LoadConfig(strings.NewReader(`({"chains": {"c": ["identity"]}})`), "wrapped")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (iofl.Chain{{Filter: "identity"}}); !reflect.DeepEqual(config.Chains["c"], want) {
+		t.Errorf("got %v, want %v", config.Chains["c"], want)
+	}
+	if _, err := iofl.LoadConfig(strings.NewReader(`{}`), "wrapped"); !errors.Is(err, errFormat) {
+		t.Errorf("got %v, want converter error", err)
+	}
+}
+
+func TestChainJSON(t *testing.T) {
+	for _, tt := range []struct {
+		chain iofl.Chain
+		want  string
+	}{
+		{nil, `[]`},
+		{iofl.Chain{{Filter: "identity"}}, `[{"filter":"identity"}]`},
+		{iofl.Chain{{Chain: "c"}}, `[{"chain":"c"}]`},
+		{iofl.Chain{{Filter: "f", Params: iofl.Params{"a": 1}}}, `[{"filter":"f","params":{"a":1}}]`},
+	} {
+		b, err := json.Marshal(tt.chain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.want {
+			t.Errorf("got %s, want %s", b, tt.want)
+		}
+	}
+}
@@ -0,0 +1,72 @@
+package iofl
+
+import (
+	"io"
+	"runtime"
+	"sync/atomic"
+)
+
+// CPUIntensive is implemented by a Filter whose Reads are dominated by
+// computation, such as decompression.
+type CPUIntensive interface {
+	// CPUIntensive returns whether Reads of the filter are CPU-intensive.
+	CPUIntensive() bool
+}
+
+// CPUSemaphore bounds the number of chains that may read from CPU-intensive
+// filters at once. A CPUSemaphore is safe for concurrent use.
+type CPUSemaphore struct {
+	slots chan struct{}
+}
+
+// NewCPUSemaphore returns a CPUSemaphore that allows n chains at once. If n is
+// less than 1, it is set to runtime.GOMAXPROCS(0).
+func NewCPUSemaphore(n int) *CPUSemaphore {
+	if n < 1 {
+		n = runtime.GOMAXPROCS(0)
+	}
+	return &CPUSemaphore{slots: make(chan struct{}, n)}
+}
+
+// LimitCPU returns an Option that causes each Read of a CPU-intensive link to
+// wait for a slot of sem, preventing a burst of chains from oversubscribing the
+// available cores. A link is CPU-intensive if its Filter implements
+// CPUIntensive and reports true. A chain holds at most one slot at a time, so a
+// CPU-intensive link that reads from another does not wait again.
+func LimitCPU(sem *CPUSemaphore) Option {
+	return func(o *resolveOptions) {
+		if sem == nil {
+			return
+		}
+		var depth int32
+		o.decorators = append(o.decorators, func(link Link, f Filter) Filter {
+			if c, ok := f.(CPUIntensive); !ok || !c.CPUIntensive() {
+				return f
+			}
+			return &cpuFilter{f: f, sem: sem, depth: &depth}
+		})
+	}
+}
+
+// cpuFilter holds a slot of a CPUSemaphore during each Read of a Filter. depth
+// is shared by the links of a chain.
+type cpuFilter struct {
+	f     Filter
+	sem   *CPUSemaphore
+	depth *int32
+}
+
+func (c *cpuFilter) Read(p []byte) (n int, err error) {
+	if atomic.AddInt32(c.depth, 1) == 1 {
+		c.sem.slots <- struct{}{}
+	}
+	defer func() {
+		if atomic.AddInt32(c.depth, -1) == 0 {
+			<-c.sem.slots
+		}
+	}()
+	return c.f.Read(p)
+}
+
+func (c *cpuFilter) Close() error          { return c.f.Close() }
+func (c *cpuFilter) Source() io.ReadCloser { return c.f }
@@ -0,0 +1,99 @@
+package iofl_test
+
+import (
+	"io"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// cpuFilter is a CPU-intensive Filter that records the number of Reads that
+// run at once.
+type cpuFilter struct {
+	funcFilter
+	intensive bool
+}
+
+func (f *cpuFilter) CPUIntensive() bool { return f.intensive }
+
+// cpuCounter counts concurrent Reads of cpuFilters.
+type cpuCounter struct {
+	active, max int32
+}
+
+// def returns the definition of a filter that counts its Reads with c. The
+// "intensive" parameter sets whether the filter is CPU-intensive.
+func (c *cpuCounter) def(name string) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			f := &cpuFilter{intensive: params.GetBool("intensive")}
+			f.funcFilter = funcFilter{src: r, read: func(p []byte) (int, error) {
+				n := atomic.AddInt32(&c.active, 1)
+				defer atomic.AddInt32(&c.active, -1)
+				for {
+					max := atomic.LoadInt32(&c.max)
+					if n <= max || atomic.CompareAndSwapInt32(&c.max, max, n) {
+						break
+					}
+				}
+				time.Sleep(time.Millisecond)
+				return r.Read(p)
+			}}
+			return f, nil
+		},
+	}
+}
+
+func (c *cpuCounter) run(t *testing.T, s *iofl.ChainSet, chain string, opts ...iofl.Option) int32 {
+	t.Helper()
+	atomic.StoreInt32(&c.max, 0)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := s.Resolve(chain, source("abcd"), opts...)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			b := make([]byte, 1)
+			for {
+				if _, err := f.Read(b); err != nil {
+					break
+				}
+			}
+			f.Close()
+		}()
+	}
+	wg.Wait()
+	return atomic.LoadInt32(&c.max)
+}
+
+func TestLimitCPU(t *testing.T) {
+	var c cpuCounter
+	s := newChainSet(t, map[string]iofl.Chain{
+		"cpu":    {{Filter: "count", Params: iofl.Params{"intensive": true}}},
+		"nested": {{Filter: "count", Params: iofl.Params{"intensive": true}}, {Filter: "count", Params: iofl.Params{"intensive": true}}},
+		"light":  {{Filter: "count"}},
+	}, c.def("count"))
+
+	if max := c.run(t, s, "cpu", iofl.LimitCPU(iofl.NewCPUSemaphore(2))); max > 2 {
+		t.Errorf("cpu: %d concurrent reads, want at most 2", max)
+	}
+	// Links of the same chain share a slot, so nested links do not deadlock.
+	// Each chain has two links that read at once.
+	if max := c.run(t, s, "nested", iofl.LimitCPU(iofl.NewCPUSemaphore(1))); max > 2 {
+		t.Errorf("nested: %d concurrent reads, want at most 2", max)
+	}
+	if max := c.run(t, s, "light", iofl.LimitCPU(iofl.NewCPUSemaphore(1))); max < 2 {
+		t.Errorf("light: %d concurrent reads, want unlimited", max)
+	}
+	if max := c.run(t, s, "cpu", iofl.LimitCPU(nil)); max < 2 {
+		t.Errorf("nil semaphore: %d concurrent reads, want unlimited", max)
+	}
+}
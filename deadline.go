@@ -0,0 +1,50 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+	"time"
+)
+
+// DeadlineUnsupported is returned by SetReadDeadline when no reader within a
+// chain implements DeadlineSetter.
+var DeadlineUnsupported = errors.New("read deadline not supported")
+
+// DeadlineSetter is implemented by a reader that supports read deadlines, such
+// as a net.Conn. A Filter may implement DeadlineSetter to intercept deadlines
+// set on its chain.
+type DeadlineSetter interface {
+	// SetReadDeadline sets the deadline for future Read calls, and any
+	// currently-blocked Read call. A zero value for t means Read will not time
+	// out.
+	SetReadDeadline(t time.Time) error
+}
+
+// SetReadDeadline sets the read deadline of the chain of r. The deadline is
+// forwarded to the first reader in the chain that implements DeadlineSetter,
+// starting from r and proceeding through the source of each Filter, and then
+// through the ReadCloser wrapped by a Root. Returns DeadlineUnsupported if no
+// such reader exists.
+//
+// A Read of a reader whose deadline has passed returns an error, which
+// propagates through the filters of the chain. For a net.Conn, the error
+// satisfies errors.Is(err, os.ErrDeadlineExceeded), which is preserved by
+// filters that wrap errors.
+func SetReadDeadline(r io.ReadCloser, t time.Time) error {
+	for r != nil {
+		if ds, ok := r.(DeadlineSetter); ok {
+			return ds.SetReadDeadline(t)
+		}
+		switch f := r.(type) {
+		case Root:
+			r = f.ReadCloser
+		case *Root:
+			r = f.ReadCloser
+		case Filter:
+			r = f.Source()
+		default:
+			r = nil
+		}
+	}
+	return DeadlineUnsupported
+}
@@ -0,0 +1,82 @@
+package iofl_test
+
+import (
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// deadlineFilter is a Filter that intercepts read deadlines.
+type deadlineFilter struct {
+	funcFilter
+	deadline time.Time
+}
+
+func (f *deadlineFilter) SetReadDeadline(t time.Time) error {
+	f.deadline = t
+	return nil
+}
+
+func TestSetReadDeadline(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate"}, {Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+	})
+	client, server := net.Pipe()
+	defer server.Close()
+	f, err := s.Resolve("c", client)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if err := iofl.SetReadDeadline(f, time.Now().Add(10*time.Millisecond)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, os.ErrDeadlineExceeded) {
+		t.Errorf("got %v, want os.ErrDeadlineExceeded", err)
+	}
+}
+
+func TestSetReadDeadlineRoot(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	defer client.Close()
+	for _, r := range []iofl.Filter{iofl.Root{client}, &iofl.Root{client}} {
+		if err := iofl.SetReadDeadline(r, time.Now()); err != nil {
+			t.Errorf("%T: %v", r, err)
+		}
+	}
+}
+
+func TestSetReadDeadlineIntercept(t *testing.T) {
+	client, server := net.Pipe()
+	defer server.Close()
+	f := &deadlineFilter{funcFilter: funcFilter{src: client, read: client.Read}}
+	defer f.Close()
+	d := time.Now().Add(time.Hour)
+	if err := iofl.SetReadDeadline(f, d); err != nil {
+		t.Fatal(err)
+	}
+	if !f.deadline.Equal(d) {
+		t.Error("filter did not receive deadline")
+	}
+}
+
+func TestSetReadDeadlineUnsupported(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	f, err := s.Resolve("c", source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := iofl.SetReadDeadline(f, time.Now()); err != iofl.DeadlineUnsupported {
+		t.Errorf("got %v, want DeadlineUnsupported", err)
+	}
+	if err := iofl.SetReadDeadline(nil, time.Now()); err != iofl.DeadlineUnsupported {
+		t.Errorf("nil: got %v, want DeadlineUnsupported", err)
+	}
+}
@@ -0,0 +1,49 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+)
+
+// NoDefaultChain is returned by ResolveDefault when no default chain is
+// configured.
+var NoDefaultChain = errors.New("no default chain")
+
+// Fallback returns an Option that causes the default chain, configured by the
+// DefaultChain field of Config, to be resolved in place of a chain that is not
+// defined, rather than returning UnknownChain. This is useful when the name of
+// the chain comes from untrusted input. Has no effect if no default chain is
+// configured.
+//
+// The option applies to Resolve and ResolveWriter, to Tenant.Resolve, and to
+// Router.Resolve when content matches no route. The ResolvedChain produced by
+// a fallback reports the name of the default chain.
+func Fallback() Option {
+	return func(o *resolveOptions) {
+		o.fallback = true
+	}
+}
+
+// ResolveDefault behaves the same as Resolve, but resolves the default chain,
+// configured by the DefaultChain field of Config. Returns a *ResolveError
+// wrapping NoDefaultChain if no default chain is configured.
+func (s *ChainSet) ResolveDefault(src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	v := s.Snapshot()
+	if v.defaultChain == "" {
+		if src != nil {
+			src.Close()
+		}
+		return nil, &ResolveError{Index: -1, Err: NoDefaultChain}
+	}
+	return v.Resolve(v.defaultChain, src, opts...)
+}
+
+// fallback returns the name of the chain to be resolved in place of a chain
+// that is not defined, according to opts. Returns false if there is no such
+// chain.
+func (v *Snapshot) fallback(opts []Option) (chain string, ok bool) {
+	if v.defaultChain == "" || !newResolveOptions(opts).fallback {
+		return "", false
+	}
+	return v.defaultChain, true
+}
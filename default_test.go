@@ -0,0 +1,130 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestDefaultChain(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"hex":   {{Filter: "hex"}},
+	})
+	if _, err := s.ResolveDefault(source("")); !errors.Is(err, iofl.NoDefaultChain) {
+		t.Errorf("got %v, want NoDefaultChain", err)
+	}
+	// Without a default chain, Fallback has no effect.
+	if _, err := s.Resolve("missing", source(""), iofl.Fallback()); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+
+	config := s.Config()
+	config.DefaultChain = "upper"
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Config().DefaultChain; got != "upper" {
+		t.Errorf("got default chain %q", got)
+	}
+	f, err := s.ResolveDefault(source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ABC" {
+		t.Errorf("got %q", got)
+	}
+
+	// An undefined chain falls back only with the Fallback option.
+	if _, err := s.Resolve("missing", source("")); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+	f, err = s.Resolve("missing", source("abc"), iofl.Fallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.(iofl.ResolvedChain).Chain(); got != "upper" {
+		t.Errorf("got resolved chain %q", got)
+	}
+	if got := readAll(t, f); got != "ABC" {
+		t.Errorf("got %q", got)
+	}
+	// A defined chain is unaffected.
+	f, err = s.Resolve("hex", source("a"), iofl.Fallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "61" {
+		t.Errorf("got %q", got)
+	}
+
+	// The default chain must be defined.
+	config.DefaultChain = "missing"
+	if err := s.SetConfig(config); err == nil || !strings.Contains(err.Error(), "default chain") {
+		t.Errorf("got %v, want error for undefined default chain", err)
+	}
+	if got := s.Config().DefaultChain; got != "upper" {
+		t.Errorf("got default chain %q after failed SetConfig", got)
+	}
+}
+
+func TestDefaultChainWriter(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"hex": {{Filter: "hex"}}})
+	config := s.Config()
+	config.DefaultChain = "hex"
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if _, err := s.ResolveWriter("missing", nopWriteCloser{&buf}); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+	w, err := s.ResolveWriter("missing", nopWriteCloser{&buf}, iofl.Fallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("6869"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "hi" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+func TestDefaultChainTenant(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"def": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+	})
+	config := s.Config()
+	config.DefaultChain = "def"
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	tenant := s.Tenant("t")
+	f, err := tenant.Resolve("missing", source("abc"), iofl.Fallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ABC" {
+		t.Errorf("got %q", got)
+	}
+	// The chain of the tenant of the same name as the default chain is
+	// resolved.
+	if err := tenant.SetChain("def", iofl.Chain{{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err = tenant.Resolve("missing", source("abc"), iofl.Fallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "nop" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := tenant.Resolve("missing", source("")); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+}
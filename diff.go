@@ -0,0 +1,226 @@
+package iofl
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// ChangeKind describes how a chain or link differs between two
+// configurations.
+type ChangeKind int
+
+const (
+	// Added indicates that the item exists only in the new configuration.
+	Added ChangeKind = iota
+	// Removed indicates that the item exists only in the old configuration.
+	Removed
+	// Changed indicates that the item exists in both configurations, but with
+	// different content.
+	Changed
+)
+
+func (k ChangeKind) String() string {
+	switch k {
+	case Added:
+		return "added"
+	case Removed:
+		return "removed"
+	case Changed:
+		return "changed"
+	}
+	return fmt.Sprintf("ChangeKind(%d)", int(k))
+}
+
+// ChainDiff describes the difference of a chain between two configurations.
+type ChainDiff struct {
+	// Chain is the name of the chain.
+	Chain string
+	// Kind is the kind of difference.
+	Kind ChangeKind
+	// Links describes the differing links of a changed chain.
+	Links []LinkDiff
+}
+
+// LinkDiff describes the difference of a link between two versions of a
+// chain.
+type LinkDiff struct {
+	// Kind is the kind of difference.
+	Kind ChangeKind
+	// OldIndex is the position of the link in the old chain, or -1 if the link
+	// was added.
+	OldIndex int
+	// NewIndex is the position of the link in the new chain, or -1 if the link
+	// was removed.
+	NewIndex int
+	// Filter is the name of the link's filter.
+	Filter string
+	// Chain is the name of the chain referred to by the link, if the link is a
+	// reference to another chain.
+	Chain string
+	// Params describes the differing params of a changed link.
+	Params []ParamDiff
+}
+
+// ParamDiff describes the difference of a parameter between two versions of a
+// link.
+type ParamDiff struct {
+	// Key is the name of the parameter.
+	Key string
+	// Kind is the kind of difference.
+	Kind ChangeKind
+	// Old is the value in the old link, or nil if the parameter was added.
+	Old interface{}
+	// New is the value in the new link, or nil if the parameter was removed.
+	New interface{}
+}
+
+// String returns a human-readable description of the difference, for
+// logging.
+func (d ChainDiff) String() string {
+	if d.Kind != Changed {
+		return fmt.Sprintf("chain %q %s", d.Chain, d.Kind)
+	}
+	var b strings.Builder
+	fmt.Fprintf(&b, "chain %q changed:", d.Chain)
+	for _, l := range d.Links {
+		switch l.Kind {
+		case Added:
+			fmt.Fprintf(&b, " [%d]%s added;", l.NewIndex, l.label())
+		case Removed:
+			fmt.Fprintf(&b, " [%d]%s removed;", l.OldIndex, l.label())
+		case Changed:
+			fmt.Fprintf(&b, " [%d]%s", l.NewIndex, l.label())
+			for _, p := range l.Params {
+				switch p.Kind {
+				case Added:
+					fmt.Fprintf(&b, " +%s=%v", p.Key, p.New)
+				case Removed:
+					fmt.Fprintf(&b, " -%s", p.Key)
+				case Changed:
+					fmt.Fprintf(&b, " %s=%v->%v", p.Key, p.Old, p.New)
+				}
+			}
+			b.WriteByte(';')
+		}
+	}
+	return strings.TrimSuffix(b.String(), ";")
+}
+
+// DiffConfigs reports the chains that differ between configurations a and b,
+// in order of chain name. Links are matched by filter name, such that
+// inserting or removing a link does not cause subsequent links to be reported
+// as changed.
+func DiffConfigs(a, b Config) []ChainDiff {
+	names := make([]string, 0, len(a.Chains)+len(b.Chains))
+	for name := range a.Chains {
+		names = append(names, name)
+	}
+	for name := range b.Chains {
+		if _, ok := a.Chains[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	var diffs []ChainDiff
+	for _, name := range names {
+		before, inA := a.Chains[name]
+		after, inB := b.Chains[name]
+		switch {
+		case !inA:
+			diffs = append(diffs, ChainDiff{Chain: name, Kind: Added})
+		case !inB:
+			diffs = append(diffs, ChainDiff{Chain: name, Kind: Removed})
+		default:
+			if links := diffLinks(before, after); len(links) > 0 {
+				diffs = append(diffs, ChainDiff{Chain: name, Kind: Changed, Links: links})
+			}
+		}
+	}
+	return diffs
+}
+
+// label returns the name of the link's filter, or the name of the referred
+// chain prefixed with "@".
+func (l LinkDiff) label() string {
+	if l.Chain != "" {
+		return "@" + l.Chain
+	}
+	return l.Filter
+}
+
+// sameLink returns whether a and b apply the same filter, or refer to the same
+// chain.
+func sameLink(a, b LinkDef) bool {
+	return a.Filter == b.Filter && a.Chain == b.Chain
+}
+
+// diffLinks aligns the links of a and b by the longest common subsequence of
+// filter names and chain references, and reports the differences.
+func diffLinks(a, b Chain) []LinkDiff {
+	// lcs[i][j] is the length of the longest common subsequence of a[i:] and
+	// b[j:].
+	lcs := make([][]int, len(a)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if sameLink(a[i], b[j]) {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+	var diffs []LinkDiff
+	i, j := 0, 0
+	for i < len(a) || j < len(b) {
+		switch {
+		case i < len(a) && j < len(b) && sameLink(a[i], b[j]):
+			if params := diffParams(a[i].Params, b[j].Params); len(params) > 0 {
+				diffs = append(diffs, LinkDiff{Kind: Changed, OldIndex: i, NewIndex: j, Filter: b[j].Filter, Chain: b[j].Chain, Params: params})
+			}
+			i++
+			j++
+		case j < len(b) && (i >= len(a) || lcs[i][j+1] >= lcs[i+1][j]):
+			diffs = append(diffs, LinkDiff{Kind: Added, OldIndex: -1, NewIndex: j, Filter: b[j].Filter, Chain: b[j].Chain})
+			j++
+		default:
+			diffs = append(diffs, LinkDiff{Kind: Removed, OldIndex: i, NewIndex: -1, Filter: a[i].Filter, Chain: a[i].Chain})
+			i++
+		}
+	}
+	return diffs
+}
+
+// diffParams reports the differences between a and b, in order of key.
+func diffParams(a, b Params) []ParamDiff {
+	keys := make([]string, 0, len(a)+len(b))
+	for k := range a {
+		keys = append(keys, k)
+	}
+	for k := range b {
+		if _, ok := a[k]; !ok {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	var diffs []ParamDiff
+	for _, k := range keys {
+		before, inA := a[k]
+		after, inB := b[k]
+		switch {
+		case !inA:
+			diffs = append(diffs, ParamDiff{Key: k, Kind: Added, New: after})
+		case !inB:
+			diffs = append(diffs, ParamDiff{Key: k, Kind: Removed, Old: before})
+		case !reflect.DeepEqual(before, after):
+			diffs = append(diffs, ParamDiff{Key: k, Kind: Changed, Old: before, New: after})
+		}
+	}
+	return diffs
+}
@@ -0,0 +1,88 @@
+package iofl_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestDiffConfigs(t *testing.T) {
+	a := iofl.Config{Chains: map[string]iofl.Chain{
+		"same":    {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"removed": {{Filter: "translate"}},
+		"changed": {
+			{Filter: "gzip"},
+			{Filter: "translate", Params: iofl.Params{"preset": "upper", "delete": "x", "from": "a"}},
+			{Chain: "same"},
+			{Filter: "base64"},
+		},
+	}}
+	b := iofl.Config{Chains: map[string]iofl.Chain{
+		"same":  {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"added": {{Filter: "translate"}},
+		"changed": {
+			{Filter: "charset"},
+			{Filter: "gzip"},
+			{Filter: "translate", Params: iofl.Params{"preset": "lower", "from": "a", "to": "b"}},
+			{Chain: "same"},
+		},
+	}}
+	diffs := iofl.DiffConfigs(a, b)
+	want := []iofl.ChainDiff{
+		{Chain: "added", Kind: iofl.Added},
+		{Chain: "changed", Kind: iofl.Changed, Links: []iofl.LinkDiff{
+			{Kind: iofl.Added, OldIndex: -1, NewIndex: 0, Filter: "charset"},
+			{Kind: iofl.Changed, OldIndex: 1, NewIndex: 2, Filter: "translate", Params: []iofl.ParamDiff{
+				{Key: "delete", Kind: iofl.Removed, Old: "x"},
+				{Key: "preset", Kind: iofl.Changed, Old: "upper", New: "lower"},
+				{Key: "to", Kind: iofl.Added, New: "b"},
+			}},
+			{Kind: iofl.Removed, OldIndex: 3, NewIndex: -1, Filter: "base64"},
+		}},
+		{Chain: "removed", Kind: iofl.Removed},
+	}
+	if !reflect.DeepEqual(diffs, want) {
+		t.Fatalf("got %+v\nwant %+v", diffs, want)
+	}
+
+	strs := []string{
+		`chain "added" added`,
+		`chain "changed" changed: [0]charset added; [2]translate -delete preset=upper->lower +to=b; [3]base64 removed`,
+		`chain "removed" removed`,
+	}
+	for i, d := range diffs {
+		if got := d.String(); got != strs[i] {
+			t.Errorf("got %q, want %q", got, strs[i])
+		}
+	}
+
+	if diffs := iofl.DiffConfigs(a, a); len(diffs) != 0 {
+		t.Errorf("identical: got %v", diffs)
+	}
+}
+
+func TestDiffConfigsChainRefs(t *testing.T) {
+	a := iofl.Config{Chains: map[string]iofl.Chain{"c": {{Chain: "x"}}}}
+	b := iofl.Config{Chains: map[string]iofl.Chain{"c": {{Chain: "y", Params: iofl.Params{"k": 1}}}}}
+	diffs := iofl.DiffConfigs(a, b)
+	if len(diffs) != 1 {
+		t.Fatalf("got %v", diffs)
+	}
+	if got, want := diffs[0].String(), `chain "c" changed: [0]@y added; [0]@x removed`; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestChangeKindString(t *testing.T) {
+	for k, want := range map[iofl.ChangeKind]string{
+		iofl.Added:         "added",
+		iofl.Removed:       "removed",
+		iofl.Changed:       "changed",
+		iofl.ChangeKind(7): "ChangeKind(7)",
+	} {
+		if got := k.String(); got != want {
+			t.Errorf("got %q, want %q", got, want)
+		}
+	}
+}
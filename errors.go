@@ -0,0 +1,295 @@
+package iofl
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// UnknownChain is returned when resolving a chain that is not defined.
+var UnknownChain = errors.New("unknown chain")
+
+// UnknownFilter is returned when resolving a link whose filter is not
+// registered.
+var UnknownFilter = errors.New("unknown filter")
+
+// ChainError is implemented by errors that identify the link of a chain at
+// which they occurred, allowing the failing link to be found with errors.As
+// without parsing error messages. It is implemented by *ResolveError, for
+// errors that occur while resolving or configuring a chain, and *ReadError,
+// for errors that occur while reading.
+type ChainError interface {
+	error
+	// ChainLink returns the name of the chain, the index of the link within
+	// the chain, and the name of the link's filter. The index is -1 if the
+	// error does not concern a particular link.
+	ChainLink() (chain string, index int, filter string)
+}
+
+// ResolveError is an error that occurred while resolving a chain.
+type ResolveError struct {
+	// Chain is the name of the chain. Empty if the chain was resolved with
+	// ResolveChain.
+	Chain string
+	// Index is the position of the failing link within the chain, or -1 if the
+	// error does not concern a particular link.
+	Index int
+	// Filter is the name of the failing link's filter.
+	Filter string
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ResolveError) Error() string {
+	switch {
+	case e.Index >= 0:
+		return fmt.Sprintf("%s[%d]%s: %s", e.Chain, e.Index, e.Filter, e.message())
+	case e.Chain != "":
+		return fmt.Sprintf("%q: %s", e.Chain, e.message())
+	}
+	return e.message()
+}
+
+// message returns the message of the underlying error, which may be nil.
+func (e *ResolveError) message() string {
+	return errMessage(e.Err)
+}
+
+// errMessage returns the message of err, or a placeholder if err is nil, for
+// errors that wrap an underlying error that has not been set.
+func errMessage(err error) string {
+	if err == nil {
+		return "unknown error"
+	}
+	return err.Error()
+}
+
+func (e *ResolveError) Unwrap() error {
+	return e.Err
+}
+
+// ChainLink implements ChainError.
+func (e *ResolveError) ChainLink() (chain string, index int, filter string) {
+	return e.Chain, e.Index, e.Filter
+}
+
+// MarshalJSON implements json.Marshaler, encoding the error as an object with
+// the fields "chain", "index", "filter", and "error". The index and filter are
+// omitted when the error does not concern a particular link.
+func (e *ResolveError) MarshalJSON() ([]byte, error) {
+	v := struct {
+		Chain  string `json:"chain"`
+		Index  *int   `json:"index,omitempty"`
+		Filter string `json:"filter,omitempty"`
+		Error  string `json:"error"`
+	}{Chain: e.Chain, Filter: e.Filter, Error: e.message()}
+	if e.Index >= 0 {
+		v.Index = &e.Index
+	}
+	return json.Marshal(v)
+}
+
+// ReadError is an error that occurred while reading from a link of a chain.
+type ReadError struct {
+	// Chain is the name of the chain.
+	Chain string
+	// Index is the position of the failing link within the chain.
+	Index int
+	// Filter is the name of the failing link's filter.
+	Filter string
+	// Offset is the number of bytes successfully produced by the link before
+	// the error occurred.
+	Offset int64
+	// Err is the underlying error.
+	Err error
+}
+
+func (e *ReadError) Error() string {
+	return fmt.Sprintf("%s[%d]%s: offset %d: %s", e.Chain, e.Index, e.Filter, e.Offset, errMessage(e.Err))
+}
+
+func (e *ReadError) Unwrap() error {
+	return e.Err
+}
+
+// ChainLink implements ChainError.
+func (e *ReadError) ChainLink() (chain string, index int, filter string) {
+	return e.Chain, e.Index, e.Filter
+}
+
+// AnnotateErrors returns an Option that causes errors returned by a Read of a
+// link to be wrapped in a *ReadError, which identifies the link and the offset
+// at which the error occurred. An error is annotated only by the link where it
+// first appears. io.EOF is not annotated.
+func AnnotateErrors() Option {
+	return Decorate(func(link Link, f Filter) Filter {
+		return &annotateFilter{f: f, link: link}
+	})
+}
+
+// annotateFilter wraps errors returned by a Filter in a *ReadError.
+type annotateFilter struct {
+	f      Filter
+	link   Link
+	offset int64
+}
+
+func (a *annotateFilter) Read(p []byte) (n int, err error) {
+	n, err = a.f.Read(p)
+	a.offset += int64(n)
+	if err != nil && err != io.EOF {
+		var rerr *ReadError
+		if !errors.As(err, &rerr) {
+			err = &ReadError{
+				Chain:  a.link.Chain,
+				Index:  a.link.Index,
+				Filter: a.link.Def.Filter,
+				Offset: a.offset,
+				Err:    err,
+			}
+		}
+	}
+	return n, err
+}
+
+func (a *annotateFilter) Close() error          { return a.f.Close() }
+func (a *annotateFilter) Source() io.ReadCloser { return a.f }
+
+// Salvage returns an Option that causes a chain to return as much output as
+// possible when a link fails mid-stream. When a link returns an error, the
+// error is recorded, and the link instead appears to reach the end of its
+// stream, allowing subsequent links to flush the data they have produced so
+// far. Once the chain has produced all of its salvaged data, the recorded error
+// is returned as a *ReadError.
+//
+// Errors returned by subsequent links as a consequence of the truncated stream
+// are discarded in favor of the original error.
+func Salvage() Option {
+	return func(o *resolveOptions) {
+		s := &salvageState{}
+		o.decorators = append(o.decorators, func(link Link, f Filter) Filter {
+			return &salvageFilter{f: f, link: link, state: s}
+		})
+		o.finishers = append(o.finishers, func(_ Link, f Filter) Filter {
+			return &salvageResult{f: f, state: s}
+		})
+	}
+}
+
+// salvageState is shared by the links of a chain resolved with Salvage.
+type salvageState struct {
+	err *ReadError
+}
+
+// salvageFilter records the first error of a chain, and replaces errors with
+// io.EOF.
+type salvageFilter struct {
+	f      Filter
+	link   Link
+	state  *salvageState
+	offset int64
+}
+
+func (s *salvageFilter) Read(p []byte) (n int, err error) {
+	n, err = s.f.Read(p)
+	s.offset += int64(n)
+	if err != nil && err != io.EOF {
+		if s.state.err == nil {
+			s.state.err = &ReadError{
+				Chain:  s.link.Chain,
+				Index:  s.link.Index,
+				Filter: s.link.Def.Filter,
+				Offset: s.offset,
+				Err:    err,
+			}
+		}
+		err = io.EOF
+	}
+	return n, err
+}
+
+func (s *salvageFilter) Close() error          { return s.f.Close() }
+func (s *salvageFilter) Source() io.ReadCloser { return s.f }
+
+// salvageResult returns the recorded error of a chain in place of io.EOF.
+type salvageResult struct {
+	f     Filter
+	state *salvageState
+}
+
+func (s *salvageResult) Read(p []byte) (n int, err error) {
+	n, err = s.f.Read(p)
+	if err == io.EOF && s.state.err != nil {
+		err = s.state.err
+	}
+	return n, err
+}
+
+func (s *salvageResult) Close() error          { return s.f.Close() }
+func (s *salvageResult) Source() io.ReadCloser { return s.f }
+
+// Errors is a list of errors, returned by operations that report several
+// failures at once.
+type Errors []error
+
+func (e Errors) Error() string {
+	switch len(e) {
+	case 0:
+		return "no errors"
+	case 1:
+		return e[0].Error()
+	}
+	s := make([]string, len(e))
+	for i, err := range e {
+		s[i] = err.Error()
+	}
+	return fmt.Sprintf("%d errors: %s", len(e), strings.Join(s, "; "))
+}
+
+// errorOrNil returns e, or nil if e is empty.
+func (e Errors) errorOrNil() error {
+	if len(e) == 0 {
+		return nil
+	}
+	return e
+}
+
+// CorruptError indicates that a filter received malformed data. Such an error
+// is neither temporary nor retryable: reading the same data again fails the
+// same way.
+type CorruptError struct {
+	Err error
+}
+
+func (e *CorruptError) Error() string   { return e.Err.Error() }
+func (e *CorruptError) Unwrap() error   { return e.Err }
+func (e *CorruptError) Temporary() bool { return false }
+func (e *CorruptError) Retryable() bool { return false }
+
+// IsCorrupt returns whether err, or any error it wraps, is a *CorruptError.
+func IsCorrupt(err error) bool {
+	var cerr *CorruptError
+	return errors.As(err, &cerr)
+}
+
+// IsTemporary returns whether err is classified as temporary, such as a
+// transient network failure. An error is temporary if it, or the first error it
+// wraps that has a Temporary method, reports true from that method.
+func IsTemporary(err error) bool {
+	var t interface{ Temporary() bool }
+	return errors.As(err, &t) && t.Temporary()
+}
+
+// IsRetryable returns whether an operation that failed with err may succeed if
+// retried. An error is retryable if it, or the first error it wraps that has a
+// Retryable method, reports true from that method. Otherwise, an error is
+// retryable if it is temporary.
+func IsRetryable(err error) bool {
+	var r interface{ Retryable() bool }
+	if errors.As(err, &r) {
+		return r.Retryable()
+	}
+	return IsTemporary(err)
+}
@@ -0,0 +1,273 @@
+package iofl_test
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+var errBoom = errors.New("boom")
+
+// failFilter returns the definition of a filter that passes through its
+// source, then returns errBoom instead of io.EOF.
+func failFilter(name string) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return &funcFilter{src: r, read: func(p []byte) (int, error) {
+				n, err := r.Read(p)
+				if err == io.EOF {
+					err = errBoom
+				}
+				return n, err
+			}}, nil
+		},
+	}
+}
+
+func TestAnnotateErrors(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "translate"},
+			{Filter: "fail"},
+			{Filter: "translate"},
+		},
+	}, failFilter("fail"))
+	f, err := s.Resolve("c", source("banana"), iofl.AnnotateErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	if string(b) != "banana" {
+		t.Errorf("got %q, want %q", b, "banana")
+	}
+	var rerr *iofl.ReadError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v, want *ReadError", err)
+	}
+	want := iofl.ReadError{Chain: "c", Index: 1, Filter: "fail", Offset: 6, Err: errBoom}
+	if *rerr != want {
+		t.Errorf("got %+v, want %+v", *rerr, want)
+	}
+	if !errors.Is(err, errBoom) {
+		t.Error("error does not unwrap to underlying error")
+	}
+	var cerr iofl.ChainError
+	if !errors.As(err, &cerr) {
+		t.Fatal("error is not a ChainError")
+	}
+	if chain, index, filter := cerr.ChainLink(); chain != "c" || index != 1 || filter != "fail" {
+		t.Errorf("got link %s[%d]%s", chain, index, filter)
+	}
+	if got, want := err.Error(), "c[1]fail: offset 6: boom"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+	nilErr := &iofl.ReadError{Chain: "c", Index: 1, Filter: "fail", Offset: 6}
+	if got, want := nilErr.Error(), "c[1]fail: offset 6: unknown error"; got != want {
+		t.Errorf("got message %q, want %q", got, want)
+	}
+}
+
+func TestAnnotateErrorsEOF(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	f, err := s.Resolve("c", source("ok"), iofl.AnnotateErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ok" {
+		t.Errorf("got %q, want %q", got, "ok")
+	}
+}
+
+func TestResolveError(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate"}, {Filter: "broken"}},
+	}, iofl.FilterDef{
+		Name: "broken",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return nil, errBoom
+		},
+	})
+	_, err := s.Resolve("c", source(""))
+	var rerr *iofl.ResolveError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v, want *ResolveError", err)
+	}
+	want := iofl.ResolveError{Chain: "c", Index: 1, Filter: "broken", Err: errBoom}
+	if *rerr != want {
+		t.Errorf("got %+v, want %+v", *rerr, want)
+	}
+	var cerr iofl.ChainError
+	if !errors.As(err, &cerr) {
+		t.Fatal("error is not a ChainError")
+	}
+	if chain, index, filter := cerr.ChainLink(); chain != "c" || index != 1 || filter != "broken" {
+		t.Errorf("got link %s[%d]%s", chain, index, filter)
+	}
+
+	_, err = s.Resolve("missing", source(""))
+	if !errors.As(err, &rerr) || !errors.Is(err, iofl.UnknownChain) {
+		t.Fatalf("got %v, want UnknownChain", err)
+	}
+	if rerr.Index != -1 {
+		t.Errorf("got index %d, want -1", rerr.Index)
+	}
+
+	for _, tt := range []struct {
+		err  *iofl.ResolveError
+		msg  string
+		json string
+	}{
+		{
+			&iofl.ResolveError{Chain: "c", Index: 1, Filter: "broken", Err: errBoom},
+			"c[1]broken: boom",
+			`{"chain":"c","index":1,"filter":"broken","error":"boom"}`,
+		},
+		{
+			&iofl.ResolveError{Chain: "c", Index: 0, Filter: "f", Err: errBoom},
+			"c[0]f: boom",
+			`{"chain":"c","index":0,"filter":"f","error":"boom"}`,
+		},
+		{
+			&iofl.ResolveError{Chain: "c", Index: -1, Err: iofl.UnknownChain},
+			`"c": unknown chain`,
+			`{"chain":"c","error":"unknown chain"}`,
+		},
+		{
+			&iofl.ResolveError{Index: -1, Err: errBoom},
+			"boom",
+			`{"chain":"","error":"boom"}`,
+		},
+		{
+			&iofl.ResolveError{Chain: "c", Index: 2, Filter: "f"},
+			"c[2]f: unknown error",
+			`{"chain":"c","index":2,"filter":"f","error":"unknown error"}`,
+		},
+		{
+			&iofl.ResolveError{Index: -1},
+			"unknown error",
+			`{"chain":"","error":"unknown error"}`,
+		},
+	} {
+		if got := tt.err.Error(); got != tt.msg {
+			t.Errorf("got message %q, want %q", got, tt.msg)
+		}
+		b, err := json.Marshal(tt.err)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(b) != tt.json {
+			t.Errorf("got JSON %s, want %s", b, tt.json)
+		}
+	}
+}
+
+func TestSalvage(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "fail"},
+			{Filter: "fail"},
+			{Filter: "translate", Params: iofl.Params{"preset": "upper"}},
+		},
+	}, failFilter("fail"))
+	f, err := s.Resolve("c", source("banana"), iofl.Salvage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	if string(b) != "BANANA" {
+		t.Errorf("got %q, want %q", b, "BANANA")
+	}
+	var rerr *iofl.ReadError
+	if !errors.As(err, &rerr) {
+		t.Fatalf("got %v, want *ReadError", err)
+	}
+	// Only the first failing link is reported.
+	want := iofl.ReadError{Chain: "c", Index: 0, Filter: "fail", Offset: 6, Err: errBoom}
+	if *rerr != want {
+		t.Errorf("got %+v, want %+v", *rerr, want)
+	}
+}
+
+func TestSalvageNoError(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	f, err := s.Resolve("c", source("ok"), iofl.Salvage())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ok" {
+		t.Errorf("got %q, want %q", got, "ok")
+	}
+}
+
+// classified is an error with explicit classification.
+type classified struct {
+	temporary, retryable bool
+}
+
+func (e classified) Error() string   { return "classified" }
+func (e classified) Temporary() bool { return e.temporary }
+func (e classified) Retryable() bool { return e.retryable }
+
+// temporary is an error that is only classified as temporary.
+type temporary struct{}
+
+func (temporary) Error() string   { return "temporary" }
+func (temporary) Temporary() bool { return true }
+
+func TestErrorClassification(t *testing.T) {
+	corrupt := &iofl.CorruptError{Err: errBoom}
+	tests := []struct {
+		name                          string
+		err                           error
+		corrupt, temporary, retryable bool
+	}{
+		{"nil", nil, false, false, false},
+		{"plain", errBoom, false, false, false},
+		{"corrupt", corrupt, true, false, false},
+		{"wrapped corrupt", fmt.Errorf("link: %w", corrupt), true, false, false},
+		{"temporary", temporary{}, false, true, true},
+		{"wrapped temporary", fmt.Errorf("link: %w", temporary{}), false, true, true},
+		{"retryable only", classified{retryable: true}, false, false, true},
+		{"temporary not retryable", classified{temporary: true}, false, true, false},
+		{"corrupt temporary", &iofl.CorruptError{Err: temporary{}}, true, false, false},
+		{"net error", &net.OpError{Op: "read", Err: classified{temporary: true, retryable: true}}, false, true, true},
+	}
+	for _, tt := range tests {
+		if got := iofl.IsCorrupt(tt.err); got != tt.corrupt {
+			t.Errorf("%s: IsCorrupt got %v", tt.name, got)
+		}
+		if got := iofl.IsTemporary(tt.err); got != tt.temporary {
+			t.Errorf("%s: IsTemporary got %v", tt.name, got)
+		}
+		if got := iofl.IsRetryable(tt.err); got != tt.retryable {
+			t.Errorf("%s: IsRetryable got %v", tt.name, got)
+		}
+	}
+	if !errors.Is(corrupt, errBoom) || corrupt.Error() != errBoom.Error() {
+		t.Error("CorruptError does not wrap its error")
+	}
+}
+
+func TestCorruptFilterError(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "percent", Params: iofl.Params{"mode": "decode"}}},
+	})
+	f, err := s.Resolve("c", source("a%zz"), iofl.AnnotateErrors())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	_, err = ioutil.ReadAll(f)
+	if !iofl.IsCorrupt(err) || iofl.IsRetryable(err) {
+		t.Errorf("got %v, want corrupt error", err)
+	}
+}
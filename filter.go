@@ -5,6 +5,11 @@ import (
 	"errors"
 	"fmt"
 	"io"
+	"reflect"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
 )
 
 // Closed is returned by a filter that has been closed.
@@ -12,8 +17,33 @@ var Closed = errors.New("closed")
 
 // Config configures a ChainSet.
 type Config struct {
+	// Version is the version of the configuration format. When applied to a
+	// ChainSet, an older configuration is upgraded by the migrations
+	// registered with the ChainSet. A version of 0 is treated as 1.
+	Version int
 	// Chains maps a name to a Chain.
 	Chains map[string]Chain
+	// Bandwidth maps the name of a Limiter in the Bandwidth of the ChainSet to
+	// a rate, in bytes per second. Rate-limiting filters attach to these
+	// Limiters by name, capping the total rate of all chains that use them.
+	Bandwidth map[string]int64
+	// Limits maps the name of a chain to a limit on the number of instances
+	// of the chain that may be open at once, and on the memory used by each
+	// instance.
+	Limits map[string]ChainLimit
+	// Tests maps the name of a chain to a list of vectors that test the
+	// chain. Tests are run by ChainSet.Verify.
+	Tests map[string][]TestVector
+	// Jobs maps a name to a job, which runs a chain from a source to a sink.
+	// Jobs are run by a Runner.
+	Jobs map[string]JobDef
+	// Vars maps the name of a chain to the variables it declares. Declared
+	// variables are checked when the chain is resolved.
+	Vars map[string][]VarDef
+	// DefaultChain is the name of the chain resolved by ResolveDefault, and in
+	// place of an undefined chain when the Fallback option is given. May be
+	// empty.
+	DefaultChain string
 }
 
 // Chain defines a list of Filters that are to be applied in order.
@@ -26,11 +56,24 @@ type LinkDef struct {
 	Filter string
 	// Params configure the Filter.
 	Params Params
+	// Chain is the name of another chain whose links are applied in place of
+	// the link, allowing common sequences of links to be shared. If set,
+	// Filter and Params must be empty.
+	Chain string
 }
 
 // Params contains a set of parameters that configure a Filter.
 type Params map[string]interface{}
 
+// copy returns a shallow copy of p.
+func (p Params) copy() Params {
+	c := make(Params, len(p)+1)
+	for k, v := range p {
+		c[k] = v
+	}
+	return c
+}
+
 // GetString returns the value of key as a string, or an empty string if the key
 // is not present or the value is not a string.
 func (p Params) GetString(key string) string {
@@ -39,11 +82,55 @@ func (p Params) GetString(key string) string {
 }
 
 // GetInt returns the value of key as an int, or 0 if the key is not present or
-// the value is not a number.
+// the value is not a number. The value may be of any numeric type, and a
+// fractional part is truncated.
 func (p Params) GetInt(key string) int {
-	// TODO: all numbers.
-	v, _ := p[key].(float64)
-	return int(v)
+	v := reflect.ValueOf(p[key])
+	if n, ok := toInt(v); ok {
+		return int(n)
+	}
+	f, _ := toFloat(v)
+	return int(f)
+}
+
+// GetFloat64 returns the value of key as a float64, or 0 if the key is not
+// present or the value is not a number. The value may be of any numeric type.
+func (p Params) GetFloat64(key string) float64 {
+	f, _ := toFloat(reflect.ValueOf(p[key]))
+	return f
+}
+
+// GetBool returns the value of key as a bool, or false if the key is not
+// present or the value is not a bool.
+func (p Params) GetBool(key string) bool {
+	v, _ := p[key].(bool)
+	return v
+}
+
+// GetDuration returns the value of key as a duration, or 0 if the key is not
+// present or the value is not a duration. A string is parsed as by
+// time.ParseDuration, such as "5s", and a number is interpreted as seconds.
+func (p Params) GetDuration(key string) time.Duration {
+	if s, ok := p[key].(string); ok {
+		d, _ := time.ParseDuration(s)
+		return d
+	}
+	f, _ := toFloat(reflect.ValueOf(p[key]))
+	return time.Duration(f * float64(time.Second))
+}
+
+// GetByteSize returns the value of key as a number of bytes, or 0 if the key
+// is not present or the value is not a size. A number is interpreted as bytes.
+// A string is a number followed by an optional unit: "B", a decimal unit such
+// as "KB" or "MB", or a binary unit such as "KiB" or "MiB", up to exabytes.
+// For example, "64KiB" is 65536 bytes.
+func (p Params) GetByteSize(key string) int64 {
+	if s, ok := p[key].(string); ok {
+		n, _ := parseByteSize(s)
+		return n
+	}
+	n, _ := toInt(reflect.ValueOf(p[key]))
+	return n
 }
 
 // Filter is implemented by any value that reads from an underlying source while
@@ -71,15 +158,94 @@ func (Root) Source() io.ReadCloser { return nil }
 type NewFilter func(params Params, r io.ReadCloser) (f Filter, err error)
 
 // ChainSet contains Filters, and Chains composed of those Filters.
+//
+// A ChainSet is safe for concurrent use by multiple goroutines. Filters may be
+// registered and the configuration replaced while other goroutines resolve
+// chains. Such changes do not affect Filters that have already been resolved.
 type ChainSet struct {
-	registry map[string]NewFilter
-	chains   map[string]Chain
+	// mu guards the fields below. It is not held while a chain is resolved,
+	// allowing filters to resolve other chains as they are constructed.
+	mu sync.RWMutex
+
+	registry  map[string]FilterDef
+	chains    map[string]Chain
+	tenants   map[string]map[string]Chain
+	bandwidth map[string]int64
+	limiters  *Bandwidth
+	tests     map[string][]TestVector
+	limits    map[string]*chainLimiter
+	jobs      map[string]JobDef
+	vars      map[string][]VarDef
+	// defaultChain is the name of the default chain.
+	defaultChain string
+
+	migrations  map[int]Migration
+	onConstruct []Hook
+	onClose     []Hook
+	logger      Logger
+
+	// snapshot caches the *Snapshot of the current state. It holds a nil
+	// *Snapshot after the state changes.
+	snapshot atomic.Value
 }
 
 // FilterDef describes a filter to be added to a ChainSet.
 type FilterDef struct {
 	Name string
 	New  NewFilter
+	// NewWriter constructs the filter for use in a write chain. May be nil if
+	// the filter cannot be written to.
+	NewWriter NewWriteFilter
+
+	// Description is a short, human-readable description of the filter, used
+	// for documentation.
+	Description string
+	// Version identifies the revision of the filter's behavior, such as a
+	// semantic version, allowing filter sets of different programs to be
+	// compared. May be empty.
+	Version string
+	// Params documents the parameters accepted by the filter.
+	//
+	// When a configuration is applied or validated, the parameters of each
+	// link are checked against Params. A link that lacks a required
+	// parameter, or has a parameter whose value is not of the declared type,
+	// is an error. The check is repeated when a chain is resolved, after
+	// options and variables are applied. Meta-parameters are not checked.
+	// Params are only checked: an absent parameter is not given its Default,
+	// and the filter receives the parameters of the link as configured.
+	Params []ParamDef
+	// StrictParams indicates that Params declares every parameter accepted by
+	// the filter, so that a link with an undeclared parameter, such as a
+	// misspelled name, is an error. ParamBufferSize is always accepted.
+	StrictParams bool
+	// Traits returns the traits of the filter when configured by params. May
+	// be nil if the filter has no traits.
+	Traits func(params Params) Trait
+	// Validate returns an error if params do not configure the filter
+	// correctly. It is used to check a configuration before any chain is
+	// resolved. May be nil.
+	Validate func(params Params) error
+}
+
+// ParamDef documents a parameter accepted by a filter.
+type ParamDef struct {
+	// Name is the name of the parameter.
+	Name string `json:"name"`
+	// Description is a short, human-readable description of the parameter.
+	Description string `json:"description,omitempty"`
+	// Default describes, for documentation, the value used when the
+	// parameter is absent, such as "rate" or "unlimited". It is not applied
+	// by the ChainSet; the filter applies its own default to an absent
+	// parameter. Empty if the parameter is required or has no default.
+	Default string `json:"default,omitempty"`
+	// Chain indicates that the value of the parameter is the name of a chain,
+	// or a list of names.
+	Chain bool `json:"chain,omitempty"`
+	// Type is the type of the value of the parameter. If empty, the value may
+	// be of any type.
+	Type ParamType `json:"type,omitempty"`
+	// Required indicates that the parameter must be present.
+	Required bool `json:"required,omitempty"`
 }
 
 // NewChainSet returns a ChainSet registered with the given filter definitions.
@@ -95,16 +261,47 @@ func NewChainSet(filters ...FilterDef) *ChainSet {
 // Register registers a filter definition. Returns an error if the filter of the
 // given name already exists.
 func (s *ChainSet) Register(filter FilterDef) error {
-	if s.registry[filter.Name] != nil {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.register(filter)
+}
+
+// register registers a filter definition. s.mu must be held.
+func (s *ChainSet) register(filter FilterDef) error {
+	if _, ok := s.registry[filter.Name]; ok {
 		return fmt.Errorf("filter %q already registered", filter.Name)
 	}
+	if err := validateParamDefs(filter.Params); err != nil {
+		return fmt.Errorf("filter %q: %w", filter.Name, err)
+	}
 	if s.registry == nil {
-		s.registry = map[string]NewFilter{}
+		s.registry = map[string]FilterDef{}
 	}
-	s.registry[filter.Name] = filter.New
+	s.registry[filter.Name] = filter
+	s.invalidate()
 	return nil
 }
 
+// filter returns the registered definition of the filter of the given name.
+func (s *ChainSet) filter(name string) (FilterDef, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	def, ok := s.registry[name]
+	return def, ok
+}
+
+// Filters returns the definitions of the registered filters, in order of name.
+func (s *ChainSet) Filters() []FilterDef {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	defs := make([]FilterDef, 0, len(s.registry))
+	for _, def := range s.registry {
+		defs = append(defs, def)
+	}
+	sort.Slice(defs, func(i, j int) bool { return defs[i].Name < defs[j].Name })
+	return defs
+}
+
 // MustRegister behaves the same as Register, but panics if an error occurs.
 func (s *ChainSet) MustRegister(filter FilterDef) {
 	if err := s.Register(filter); err != nil {
@@ -114,19 +311,154 @@ func (s *ChainSet) MustRegister(filter FilterDef) {
 
 // Config returns a copy of the configuration used by the ChainSet.
 func (s *ChainSet) Config() Config {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
 	chains := make(map[string]Chain, len(s.chains))
 	for k, v := range s.chains {
 		chains[k] = v
 	}
-	return Config{Chains: chains}
+	var bandwidth map[string]int64
+	if s.bandwidth != nil {
+		bandwidth = make(map[string]int64, len(s.bandwidth))
+		for k, v := range s.bandwidth {
+			bandwidth[k] = v
+		}
+	}
+	var tests map[string][]TestVector
+	if s.tests != nil {
+		tests = make(map[string][]TestVector, len(s.tests))
+		for k, v := range s.tests {
+			tests[k] = append([]TestVector(nil), v...)
+		}
+	}
+	var limits map[string]ChainLimit
+	if s.limits != nil {
+		limits = make(map[string]ChainLimit, len(s.limits))
+		for k, v := range s.limits {
+			limits[k] = v.limit
+		}
+	}
+	var jobs map[string]JobDef
+	if s.jobs != nil {
+		jobs = make(map[string]JobDef, len(s.jobs))
+		for k, v := range s.jobs {
+			jobs[k] = v
+		}
+	}
+	var vars map[string][]VarDef
+	if s.vars != nil {
+		vars = make(map[string][]VarDef, len(s.vars))
+		for k, v := range s.vars {
+			vars[k] = append([]VarDef(nil), v...)
+		}
+	}
+	return Config{
+		Version:   s.version(),
+		Chains:    chains,
+		Bandwidth: bandwidth,
+		Limits:    limits,
+		Tests:     tests,
+		Jobs:      jobs,
+		Vars:      vars,
+
+		DefaultChain: s.defaultChain,
+	}
 }
 
-// SetConfig uses Config to configure the ChainSet.
+// SetConfig uses Config to configure the ChainSet. If the version of config is
+// older than that of the ChainSet, config is first upgraded by the registered
+// migrations. config is then checked as by Validate, and is not applied if any
+// problems are found. The Limiters of the ChainSet's Bandwidth are configured
+// according to config.Bandwidth, and those not in config.Bandwidth are removed.
 func (s *ChainSet) SetConfig(config Config) error {
+	if err := s.migrate(&config); err != nil {
+		return err
+	}
+	if err := s.validate(config); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setConfig(config, false)
+	return nil
+}
+
+// setConfig applies config, which has been validated. If keepLimits is true,
+// the limiter of a chain whose limit is unchanged is retained, so that open
+// instances continue to count toward the limit. s.mu must be held.
+//
+// Each map is replaced rather than modified, so that a map retrieved while
+// the lock is held may be used after it is released.
+func (s *ChainSet) setConfig(config Config, keepLimits bool) {
 	s.chains = make(map[string]Chain, len(config.Chains))
 	for k, v := range config.Chains {
 		s.chains[k] = v
 	}
+	s.bandwidth = nil
+	if config.Bandwidth != nil {
+		s.bandwidth = make(map[string]int64, len(config.Bandwidth))
+		for k, v := range config.Bandwidth {
+			s.bandwidth[k] = v
+		}
+	}
+	s.bandwidthLocked().configure(config.Bandwidth)
+	old := s.limits
+	s.limits = nil
+	if config.Limits != nil {
+		s.limits = make(map[string]*chainLimiter, len(config.Limits))
+		for k, v := range config.Limits {
+			if v.Max <= 0 && v.MaxMemory <= 0 {
+				continue
+			}
+			if l, ok := old[k]; ok && keepLimits && l.limit == v {
+				s.limits[k] = l
+			} else {
+				s.limits[k] = newChainLimiter(v)
+			}
+		}
+	}
+	s.tests = nil
+	if config.Tests != nil {
+		s.tests = make(map[string][]TestVector, len(config.Tests))
+		for k, v := range config.Tests {
+			s.tests[k] = append([]TestVector(nil), v...)
+		}
+	}
+	s.jobs = nil
+	if config.Jobs != nil {
+		s.jobs = make(map[string]JobDef, len(config.Jobs))
+		for k, v := range config.Jobs {
+			s.jobs[k] = v
+		}
+	}
+	s.vars = nil
+	if config.Vars != nil {
+		s.vars = make(map[string][]VarDef, len(config.Vars))
+		for k, v := range config.Vars {
+			s.vars[k] = append([]VarDef(nil), v...)
+		}
+	}
+	s.defaultChain = config.DefaultChain
+	s.invalidate()
+}
+
+// AddChain adds a chain of the given name to the ChainSet's configuration.
+// Returns an error if a chain of the given name already exists.
+func (s *ChainSet) AddChain(name string, chain Chain) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.chains[name]; ok {
+		return fmt.Errorf("chain %q already exists", name)
+	}
+	// Copy the map, so that the chains of existing snapshots are not
+	// modified.
+	chains := make(map[string]Chain, len(s.chains)+1)
+	for k, v := range s.chains {
+		chains[k] = v
+	}
+	chains[name] = chain
+	s.chains = chains
+	s.invalidate()
 	return nil
 }
 
@@ -140,29 +472,135 @@ func (s *ChainSet) MustSetConfig(config Config) *ChainSet {
 }
 
 // Resolve locates the chain of the given name, and produces a Filter that
-// recursively applies all filters in the chain. If vars is non-nil, then any
-// Filters that implement Expander will be called with vars. If src is non-nil,
-// then it will be used as the source of the first filter in the chain.
-func (s *ChainSet) Resolve(chain string, src io.ReadCloser) (filter Filter, err error) {
-	filterChain, ok := s.chains[chain]
-	if !ok {
-		return nil, fmt.Errorf("unknown chain %q", chain)
-	}
-	if f, ok := src.(Filter); ok {
-		filter = f
-	} else if src != nil {
-		filter = Root{src}
-	}
-	for i, def := range filterChain {
-		newFilter, ok := s.registry[def.Filter]
+// recursively applies all filters in the chain. If src is non-nil, then it will
+// be used as the source of the first filter in the chain. Each Option is applied
+// to the resolution. An error that occurs while resolving is returned as a
+// *ResolveError, in which case the links constructed so far, and src, are
+// closed. A non-nil Filter implements ResolvedChain.
+//
+// The chain is resolved from a Snapshot of the ChainSet, so that it is
+// unaffected by concurrent changes to the configuration.
+func (s *ChainSet) Resolve(chain string, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	return s.Snapshot().Resolve(chain, src, opts...)
+}
+
+// ResolveChain behaves the same as Resolve, but resolves chain, which need not
+// be configured in the ChainSet. This allows a one-off chain, such as one
+// constructed from user input, to be resolved using the registered filters.
+// Errors identify links by index alone.
+func (s *ChainSet) ResolveChain(chain Chain, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	return s.Snapshot().ResolveChain(chain, src, opts...)
+}
+
+// resolve produces a Filter from filterChain, identified by the name chain.
+// References to other chains are located with lookup. Filters and limits are
+// located within v.
+func (v *Snapshot) resolve(chain string, filterChain Chain, lookup func(string) (Chain, bool), src io.ReadCloser, opts []Option) (filter Filter, err error) {
+	// f is the chain constructed so far, which is closed if resolving fails.
+	f := AsFilter(src)
+	defer func() {
+		if err != nil && f != nil {
+			f.Close()
+		}
+	}()
+	links, err := expand(chain, filterChain, lookup)
+	if err != nil {
+		return nil, err
+	}
+	o := newResolveOptions(opts)
+	if err := o.checkOverrides(chain, links); err != nil {
+		return nil, err
+	}
+	if o.vars, err = v.checkVars(chain, links, o.vars); err != nil {
+		return nil, err
+	}
+	limiter := v.limits[chain]
+	release, err := limiter.acquire(o.ctx)
+	if err != nil {
+		return nil, &ResolveError{Chain: chain, Index: -1, Err: err}
+	}
+	defer func() {
+		if err != nil {
+			release()
+		}
+	}()
+	budget := limiter.newMemoryBudget(chain)
+	var chainIn *countFilter
+	if o.ratio > 0 && f != nil {
+		chainIn = &countFilter{f: f}
+		f = chainIn
+	}
+	last := Link{Chain: chain, Index: -1}
+	infos := make([]LinkInfo, 0, len(links))
+	for i, link := range links {
+		last = link
+		filterDef, ok := v.registry[link.Def.Filter]
 		if !ok {
-			return nil, fmt.Errorf("%s[%d]: unknown filter %q", chain, i, def.Filter)
+			return nil, link.error(UnknownFilter)
+		}
+		params := o.params(i, link.Def)
+		if o.vars != nil {
+			if params, err = expandParams(params, o.vars); err != nil {
+				return nil, link.error(err)
+			}
+		}
+		params, meta := splitMeta(params)
+		if err = validateMeta(meta); err != nil {
+			return nil, link.error(err)
+		}
+		if err = checkParams(filterDef, params, false).errorOrNil(); err != nil {
+			return nil, link.error(err)
+		}
+		var in *countFilter
+		if _, ok := meta["#ratio"]; ok && f != nil {
+			in = &countFilter{f: f}
+			f = in
+		}
+		var next Filter
+		if next, err = filterDef.New(params, f); err != nil {
+			return nil, link.error(err)
+		}
+		f = next
+		infos = append(infos, LinkInfo{Link: link, Params: params, Filter: f})
+		if e, ok := f.(Expander); ok && o.vars != nil {
+			if err = e.Expand(o.vars); err != nil {
+				return nil, link.error(err)
+			}
+		}
+		if err = o.applyOutputs(meta, f); err != nil {
+			return nil, link.error(err)
+		}
+		if b, ok := f.(BudgetUser); ok && budget != nil {
+			b.SetMemoryBudget(budget)
 		}
-		if filter, err = newFilter(def.Params, filter); err != nil {
-			return nil, fmt.Errorf("%s[%d]%s: %w", chain, i, def.Filter, err)
+		if c, ok := f.(ContextFilter); ok && o.ctx != nil {
+			c.SetContext(o.ctx)
 		}
+		f = v.constructed(link, f)
+		if meta != nil {
+			if next, err = o.applyMeta(meta, f, in, budget); err != nil {
+				return nil, link.error(err)
+			}
+			f = next
+		}
+		f = o.decorate(link, f, budget)
 	}
-	return filter, nil
+	f = o.finish(last, f)
+	if chainIn != nil {
+		f = &ratioFilter{f: f, in: chainIn, ratio: o.ratio}
+	}
+	if f == nil {
+		// Nothing to close, so the instance is not held open.
+		release()
+		return nil, nil
+	}
+	if limiter != nil && limiter.slots != nil {
+		f = &releaseFilter{f: f, release: release}
+	}
+	if o.idle > 0 {
+		f = v.set.reaper(chain, o.idle, f)
+	}
+	return resolved(chain, infos, f), nil
 }
 
 // Apply calls cb for each io.ReadCloser that implements Filter. The filter's
@@ -181,3 +619,18 @@ func Apply(r io.ReadCloser, cb func(io.ReadCloser) error) error {
 	}
 	return nil
 }
+
+// Compose produces a Filter by applying each constructor in order, without
+// the use of a ChainSet. The first constructor receives src, which may be nil,
+// and each subsequent constructor receives the Filter returned by the previous.
+// If a constructor returns an error, the error is returned as a *ResolveError
+// identifying the index of the constructor.
+func Compose(src io.ReadCloser, ctors ...func(r io.ReadCloser) (Filter, error)) (filter Filter, err error) {
+	filter = AsFilter(src)
+	for i, ctor := range ctors {
+		if filter, err = ctor(filter); err != nil {
+			return nil, &ResolveError{Index: i, Err: err}
+		}
+	}
+	return filter, nil
+}
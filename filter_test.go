@@ -0,0 +1,90 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestResolveChain(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+	})
+	tests := []struct {
+		chain iofl.Chain
+		want  string
+	}{
+		{iofl.Chain{}, "abc"},
+		{iofl.Chain{{Filter: "translate", Params: iofl.Params{"preset": "upper"}}}, "ABC"},
+		{iofl.Chain{{Chain: "upper"}, {Filter: "translate", Params: iofl.Params{"preset": "rot13"}}}, "NOP"},
+	}
+	for i, tt := range tests {
+		f, err := s.ResolveChain(tt.chain, source("abc"))
+		if err != nil {
+			t.Fatalf("%d: %v", i, err)
+		}
+		if got := readAll(t, f); got != tt.want {
+			t.Errorf("%d: got %q, want %q", i, got, tt.want)
+		}
+	}
+	if got := s.Config().Chains; len(got) != 1 {
+		t.Errorf("resolved chain was configured: %v", got)
+	}
+}
+
+func TestResolveChainErrors(t *testing.T) {
+	s := newChainSet(t, nil)
+	for _, tt := range []struct {
+		chain iofl.Chain
+		err   error
+		index int
+	}{
+		{iofl.Chain{{Filter: "translate"}, {Filter: "missing"}}, iofl.UnknownFilter, 1},
+		{iofl.Chain{{Chain: "missing"}}, iofl.UnknownChain, 0},
+	} {
+		_, err := s.ResolveChain(tt.chain, source(""))
+		var rerr *iofl.ResolveError
+		if !errors.As(err, &rerr) || !errors.Is(err, tt.err) {
+			t.Errorf("got %v, want %v", err, tt.err)
+			continue
+		}
+		if rerr.Chain != "" || rerr.Index != tt.index {
+			t.Errorf("got link %q[%d], want [%d]", rerr.Chain, rerr.Index, tt.index)
+		}
+	}
+}
+
+func TestResolveErrorCloses(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"first": {{Filter: "broken"}},
+		"later": {{Filter: "translate"}, {Filter: "identity"}, {Filter: "broken"}},
+		"meta":  {{Filter: "translate"}, {Filter: "identity", Params: iofl.Params{"#tee": "missing"}}},
+	}, iofl.FilterDef{
+		Name: "broken",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return nil, errBoom
+		},
+	})
+	// Constructed links, and the source, are closed when a later link fails.
+	for _, chain := range []string{"first", "later", "meta", "undefined"} {
+		src := &closeCounter{Reader: strings.NewReader("abc")}
+		if _, err := s.Resolve(chain, src); err == nil {
+			t.Errorf("%s: expected error", chain)
+		}
+		if src.closes != 1 {
+			t.Errorf("%s: source closed %d times", chain, src.closes)
+		}
+	}
+
+	src := &closeCounter{Reader: strings.NewReader("abc")}
+	if _, err := s.Run("later", ioutil.Discard, src); !errors.Is(err, errBoom) {
+		t.Errorf("Run: got %v, want errBoom", err)
+	}
+	if src.closes != 1 {
+		t.Errorf("Run: source closed %d times", src.closes)
+	}
+}
@@ -0,0 +1,531 @@
+package filters
+
+import (
+	"bufio"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// AESGCM encrypts or decrypts a stream with AES-GCM. The stream is divided
+// into chunks that are authenticated individually, so that content can be
+// produced without buffering the entire stream. Params:
+//
+//	mode:  "decrypt" (default) or "encrypt".
+//	key:   The key, as a reference of the form "scheme:name", retrieved from
+//	       the KeyProvider registered for the scheme. The key must be 16, 24,
+//	       or 32 bytes, selecting AES-128, AES-192, or AES-256. Required.
+//	chunk: The size of the plaintext of each chunk when encrypting, in bytes.
+//	       Defaults to 64KiB.
+//
+// An encrypted stream begins with a 37-byte header containing a version byte,
+// the chunk size as a big-endian uint32, and a random 32-byte salt. The chunks
+// of the stream are sealed with a key derived from the key and the salt with
+// HKDF-SHA256, so that each stream is encrypted with its own key, and nonces
+// are never reused across streams. Each chunk follows the header, with a
+// 16-byte tag. The nonce of a chunk is 7 zero bytes, followed by the index of
+// the chunk as a big-endian uint32, and a byte that is 1 for the final chunk,
+// and 0 otherwise. The header is authenticated as additional data. Thus,
+// reordering, truncating, or extending the stream is detected.
+//
+// When decrypting, a chunk is produced only once it has been authenticated,
+// but the chunks preceding a failure will have been produced. Authentication
+// failures are corrupt errors. When used in a write chain, the filter encrypts
+// written content if mode is "decrypt". A filter in "encrypt" mode cannot be
+// written to.
+var AESGCM = iofl.FilterDef{
+	Name:         "aesgcm",
+	New:          newAESGCM,
+	NewWriter:    newAESGCMWriter,
+	Validate:     validateAESGCM,
+	Description:  "Encrypts or decrypts a stream with chunked AES-GCM.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decrypt\" or \"encrypt\".", Default: "decrypt"},
+		{Name: "key", Type: iofl.ParamString, Required: true, Description: "A reference to the key, of the form \"scheme:name\"."},
+		{Name: "chunk", Type: iofl.ParamInt, Description: "The size of the plaintext of each chunk when encrypting, in bytes.", Default: "64KiB"},
+	},
+	Traits: func(params iofl.Params) iofl.Trait {
+		if params.GetString("mode") == "encrypt" {
+			return iofl.Encrypts
+		}
+		return iofl.Decrypts
+	},
+}
+
+// Constants of the chunked AES-GCM format.
+const (
+	aesgcmVersion     = 1
+	aesgcmHeaderSize  = 37
+	aesgcmSaltSize    = 32
+	aesgcmTagSize     = 16
+	aesgcmDefaultSize = 64 << 10
+	aesgcmMaxChunk    = 16 << 20
+)
+
+// errAuthentication is returned when a chunk fails to authenticate.
+var errAuthentication error = &iofl.CorruptError{Err: errors.New("message authentication failed")}
+
+// aesgcmParams contains the parsed params of the filter, not including the
+// key.
+type aesgcmParams struct {
+	encrypt bool
+	key     string
+	chunk   int
+}
+
+func parseAESGCM(params iofl.Params) (p aesgcmParams, err error) {
+	mode, err := getMode(params, "decrypt", "decrypt", "encrypt")
+	if err != nil {
+		return p, err
+	}
+	p.encrypt = mode == "encrypt"
+	if p.key = params.GetString("key"); p.key == "" {
+		return p, errors.New("key required")
+	}
+	if _, _, err := parseKeyRef(p.key); err != nil {
+		return p, err
+	}
+	if p.chunk = params.GetInt("chunk"); p.chunk <= 0 {
+		p.chunk = aesgcmDefaultSize
+	} else if p.chunk > aesgcmMaxChunk {
+		return p, fmt.Errorf("chunk %d exceeds maximum", p.chunk)
+	}
+	return p, nil
+}
+
+func validateAESGCM(params iofl.Params) error {
+	_, err := parseAESGCM(params)
+	return err
+}
+
+// getAESKey retrieves the key referred to by ref, and checks that it is a
+// valid AES key.
+func getAESKey(ref string) ([]byte, error) {
+	key, err := getKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := aes.NewCipher(key); err != nil {
+		return nil, fmt.Errorf("key %q: %w", ref, err)
+	}
+	return key, nil
+}
+
+// deriveKey derives a key of the same size as key from key and salt with
+// HKDF-SHA256, as specified by RFC 5869. The size of key must not exceed the
+// size of a SHA-256 hash.
+func deriveKey(key, salt []byte) []byte {
+	extract := hmac.New(sha256.New, salt)
+	extract.Write(key)
+	expand := hmac.New(sha256.New, extract.Sum(nil))
+	expand.Write([]byte("iofl aesgcm"))
+	expand.Write([]byte{1})
+	return expand.Sum(nil)[:len(key)]
+}
+
+// aesgcmStream seals and opens the chunks of a stream.
+type aesgcmStream struct {
+	key    []byte
+	aead   cipher.AEAD
+	header [aesgcmHeaderSize]byte
+	nonce  [12]byte
+	index  uint32
+	done   bool
+}
+
+// init sets the header of the stream to the given chunk size and salt, and
+// derives the key of the stream.
+func (s *aesgcmStream) init(chunk int, salt []byte) error {
+	s.header[0] = aesgcmVersion
+	binary.BigEndian.PutUint32(s.header[1:5], uint32(chunk))
+	copy(s.header[5:], salt)
+	block, err := aes.NewCipher(deriveKey(s.key, salt))
+	if err != nil {
+		return err
+	}
+	if s.aead, err = cipher.NewGCM(block); err != nil {
+		return err
+	}
+	s.nonce = [12]byte{}
+	s.index = 0
+	s.done = false
+	return nil
+}
+
+// initRandom sets the header of the stream to the given chunk size and a
+// random salt, and derives the key of the stream.
+func (s *aesgcmStream) initRandom(chunk int) error {
+	salt := make([]byte, aesgcmSaltSize)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	return s.init(chunk, salt)
+}
+
+// next sets the nonce for the next chunk.
+func (s *aesgcmStream) next(last bool) error {
+	if s.done {
+		return errors.New("stream already finished")
+	}
+	if s.index == 1<<32-1 && !last {
+		return errors.New("too many chunks")
+	}
+	binary.BigEndian.PutUint32(s.nonce[7:11], s.index)
+	s.nonce[11] = 0
+	if last {
+		s.nonce[11] = 1
+		s.done = true
+	}
+	s.index++
+	return nil
+}
+
+// seal appends the sealed chunk to dst.
+func (s *aesgcmStream) seal(dst, plain []byte, last bool) ([]byte, error) {
+	if err := s.next(last); err != nil {
+		return nil, err
+	}
+	return s.aead.Seal(dst, s.nonce[:], plain, s.header[:]), nil
+}
+
+// open appends the opened chunk to dst.
+func (s *aesgcmStream) open(dst, sealed []byte, last bool) ([]byte, error) {
+	if err := s.next(last); err != nil {
+		return nil, err
+	}
+	dst, err := s.aead.Open(dst, s.nonce[:], sealed, s.header[:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: chunk %d", errAuthentication, s.index-1)
+	}
+	return dst, nil
+}
+
+func newAESGCM(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	p, err := parseAESGCM(params)
+	if err != nil {
+		return nil, err
+	}
+	filter := &aesgcmFilter{params: p}
+	if filter.stream.key, err = getAESKey(p.key); err != nil {
+		return nil, err
+	}
+	filter.Reset(r)
+	return filter, nil
+}
+
+// aesgcmFilter implements the AESGCM filter.
+type aesgcmFilter struct {
+	src    io.ReadCloser
+	params aesgcmParams
+	budget *iofl.MemoryBudget
+	closed bool
+	// reserved is the number of bytes of the buffers reserved from budget.
+	reserved int
+
+	stream  aesgcmStream
+	br      *bufio.Reader
+	started bool
+	chunk   int
+	in      []byte
+	out     []byte
+	pending []byte
+	err     error
+}
+
+// Source implements iofl.Filter.
+func (f *aesgcmFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// reserve allocates the source buffer, and buffers for chunks of the given
+// size, reserving the memory from the budget. Buffers that are already large
+// enough are retained.
+func (f *aesgcmFilter) reserve(chunk int) error {
+	size := f.params.chunk + aesgcmHeaderSize + aesgcmTagSize
+	sealed := chunk + aesgcmHeaderSize + aesgcmTagSize
+	if need := size + 2*sealed; need > f.reserved {
+		if err := f.budget.Resize(f.reserved, need); err != nil {
+			return err
+		}
+		f.reserved = need
+	}
+	if f.br == nil {
+		f.br = bufio.NewReaderSize(f.src, size)
+	}
+	if cap(f.in) < sealed {
+		f.in = make([]byte, 0, sealed)
+	}
+	if cap(f.out) < sealed {
+		f.out = make([]byte, 0, sealed)
+	}
+	return nil
+}
+
+// readChunk reads up to n bytes from the source. Returns whether the chunk is
+// the last of the stream.
+func (f *aesgcmFilter) readChunk(n int) (b []byte, last bool, err error) {
+	if cap(f.in) < n {
+		f.in = make([]byte, n)
+	}
+	m, err := io.ReadFull(f.br, f.in[:n])
+	switch err {
+	case nil:
+		if _, err := f.br.Peek(1); err == io.EOF {
+			last = true
+		} else if err != nil {
+			return nil, false, err
+		}
+	case io.EOF, io.ErrUnexpectedEOF:
+		last = true
+	default:
+		return nil, false, err
+	}
+	return f.in[:m], last, nil
+}
+
+// start begins the stream, producing or consuming the header.
+func (f *aesgcmFilter) start() error {
+	f.started = true
+	if f.params.encrypt {
+		f.chunk = f.params.chunk
+		if err := f.reserve(f.chunk); err != nil {
+			return err
+		}
+		if err := f.stream.initRandom(f.chunk); err != nil {
+			return err
+		}
+		f.pending = append(f.out[:0], f.stream.header[:]...)
+		return nil
+	}
+	if err := f.reserve(0); err != nil {
+		return err
+	}
+	var header [aesgcmHeaderSize]byte
+	if _, err := io.ReadFull(f.br, header[:]); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return &iofl.CorruptError{Err: errors.New("truncated header")}
+		}
+		return err
+	}
+	if header[0] != aesgcmVersion {
+		return &iofl.CorruptError{Err: fmt.Errorf("unknown version %d", header[0])}
+	}
+	chunk := binary.BigEndian.Uint32(header[1:5])
+	if chunk == 0 || chunk > aesgcmMaxChunk {
+		return &iofl.CorruptError{Err: fmt.Errorf("invalid chunk size %d", chunk)}
+	}
+	f.chunk = int(chunk)
+	if err := f.reserve(f.chunk); err != nil {
+		return err
+	}
+	return f.stream.init(f.chunk, header[5:])
+}
+
+// nextChunk produces the next chunk of output.
+func (f *aesgcmFilter) nextChunk() error {
+	if !f.started {
+		return f.start()
+	}
+	if f.stream.done {
+		return io.EOF
+	}
+	overhead := f.stream.aead.Overhead()
+	if f.params.encrypt {
+		plain, last, err := f.readChunk(f.chunk)
+		if err != nil {
+			return err
+		}
+		f.out, err = f.stream.seal(f.out[:0], plain, last)
+		f.pending = f.out
+		return err
+	}
+	sealed, last, err := f.readChunk(f.chunk + overhead)
+	if err != nil {
+		return err
+	}
+	if len(sealed) < overhead {
+		return &iofl.CorruptError{Err: errors.New("truncated chunk")}
+	}
+	f.out, err = f.stream.open(f.out[:0], sealed, last)
+	f.pending = f.out
+	return err
+}
+
+// Read implements io.Reader.
+func (f *aesgcmFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	for len(f.pending) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		f.err = f.nextChunk()
+	}
+	n = copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// Close implements io.Closer, closing the source.
+func (f *aesgcmFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	f.budget.Free(f.reserved)
+	f.reserved = 0
+	f.br = nil
+	f.in = nil
+	f.out = nil
+	f.pending = nil
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *aesgcmFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.started = false
+	f.pending = nil
+	f.err = nil
+	if f.br != nil {
+		f.br.Reset(src)
+	}
+	return nil
+}
+
+// SetMemoryBudget implements iofl.BudgetUser. The buffers of the filter are
+// allocated by the first Read, and reserved from b.
+func (f *aesgcmFilter) SetMemoryBudget(b *iofl.MemoryBudget) {
+	f.budget = b
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *aesgcmFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *aesgcmFilter) MemoryUsage() int {
+	if f.br == nil {
+		return 0
+	}
+	return f.br.Size() + cap(f.in) + cap(f.out)
+}
+
+// newAESGCMWriter returns a writer that encrypts written content. The mode
+// param must be "decrypt".
+func newAESGCMWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	p, err := parseAESGCM(params)
+	if err != nil {
+		return nil, err
+	}
+	if p.encrypt {
+		return nil, iofl.NotWritable
+	}
+	writer := &aesgcmWriter{dst: w, buf: make([]byte, 0, p.chunk)}
+	if writer.stream.key, err = getAESKey(p.key); err != nil {
+		return nil, err
+	}
+	if err := writer.stream.initRandom(p.chunk); err != nil {
+		return nil, err
+	}
+	return writer, nil
+}
+
+// aesgcmWriter implements the AESGCM filter in a write chain.
+type aesgcmWriter struct {
+	dst    io.WriteCloser
+	closed bool
+	err    error
+
+	stream  aesgcmStream
+	started bool
+	// buf holds plaintext that has not been sealed. A full chunk is sealed
+	// only once more content is written, since the final chunk is sealed
+	// differently.
+	buf []byte
+	out []byte
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *aesgcmWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// flush seals and writes the buffered chunk.
+func (w *aesgcmWriter) flush(last bool) (err error) {
+	if !w.started {
+		w.started = true
+		if _, err := w.dst.Write(w.stream.header[:]); err != nil {
+			return err
+		}
+	}
+	if w.out, err = w.stream.seal(w.out[:0], w.buf, last); err != nil {
+		return err
+	}
+	w.buf = w.buf[:0]
+	_, err = w.dst.Write(w.out)
+	return err
+}
+
+// Write implements io.Writer.
+func (w *aesgcmWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		if len(w.buf) == cap(w.buf) {
+			if w.err = w.flush(false); w.err != nil {
+				return n, w.err
+			}
+		}
+		m := copy(w.buf[len(w.buf):cap(w.buf)], p)
+		w.buf = w.buf[:len(w.buf)+m]
+		p = p[m:]
+		n += m
+	}
+	return n, nil
+}
+
+// Close implements io.Closer, sealing the final chunk, and closing the sink.
+func (w *aesgcmWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	err := w.err
+	if err == nil {
+		err = w.flush(true)
+	}
+	if cerr := w.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (w *aesgcmWriter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (w *aesgcmWriter) MemoryUsage() int {
+	return cap(w.buf) + cap(w.out)
+}
@@ -0,0 +1,207 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// Sizes of the format.
+const (
+	aesHeaderSize = 37
+	aesTagSize    = 16
+)
+
+func aesEncrypt(t *testing.T, chunk int, plain []byte) []byte {
+	t.Helper()
+	return mustRead(t, filters.AESGCM, iofl.Params{"mode": "encrypt", "key": "test:k", "chunk": chunk}, plain)
+}
+
+func aesDecrypt(t *testing.T, sealed []byte) ([]byte, error) {
+	t.Helper()
+	return readFilter(t, filters.AESGCM, iofl.Params{"key": "test:k"}, sealed)
+}
+
+func TestAESGCMRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	const chunk = 16
+	for _, n := range []int{0, 1, chunk - 1, chunk, chunk + 1, 3 * chunk, 1000} {
+		plain := make([]byte, n)
+		rnd.Read(plain)
+		sealed := aesEncrypt(t, chunk, plain)
+		chunks := n/chunk + 1
+		if n > 0 && n%chunk == 0 {
+			chunks--
+		}
+		if want := aesHeaderSize + n + chunks*aesTagSize; len(sealed) != want {
+			t.Errorf("%d bytes: got %d sealed bytes, want %d", n, len(sealed), want)
+		}
+		out, err := aesDecrypt(t, sealed)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if !bytes.Equal(out, plain) {
+			t.Errorf("%d bytes: round trip does not match input", n)
+		}
+	}
+
+	// Each stream is encrypted with its own key.
+	plain := []byte("same plaintext")
+	if bytes.Equal(aesEncrypt(t, chunk, plain)[aesHeaderSize:], aesEncrypt(t, chunk, plain)[aesHeaderSize:]) {
+		t.Error("streams of the same plaintext are identical")
+	}
+}
+
+func TestAESGCMTamper(t *testing.T) {
+	const chunk = 16
+	plain := bytes.Repeat([]byte("0123456789abcdef"), 3)
+	plain = append(plain, "tail"...)
+	sealed := aesEncrypt(t, chunk, plain)
+	sealedChunk := chunk + aesTagSize
+	mutate := func(f func(b []byte) []byte) []byte {
+		return f(append([]byte{}, sealed...))
+	}
+	flip := func(i int) []byte {
+		return mutate(func(b []byte) []byte { b[i] ^= 1; return b })
+	}
+	tests := map[string][]byte{
+		"version":    flip(0),
+		"chunk size": flip(4),
+		"salt":       flip(5),
+		"ciphertext": flip(aesHeaderSize),
+		"tag":        flip(aesHeaderSize + sealedChunk - 1),
+		"last chunk": flip(len(sealed) - 1),
+		"header only": mutate(func(b []byte) []byte {
+			return b[:aesHeaderSize]
+		}),
+		"truncated header": mutate(func(b []byte) []byte {
+			return b[:aesHeaderSize-1]
+		}),
+		"dropped last chunk": mutate(func(b []byte) []byte {
+			return b[:aesHeaderSize+3*sealedChunk]
+		}),
+		"truncated chunk": mutate(func(b []byte) []byte {
+			return b[:len(b)-1]
+		}),
+		"extended": mutate(func(b []byte) []byte {
+			return append(b, 0)
+		}),
+		"reordered": mutate(func(b []byte) []byte {
+			c0 := append([]byte{}, b[aesHeaderSize:aesHeaderSize+sealedChunk]...)
+			copy(b[aesHeaderSize:], b[aesHeaderSize+sealedChunk:aesHeaderSize+2*sealedChunk])
+			copy(b[aesHeaderSize+sealedChunk:], c0)
+			return b
+		}),
+		"empty": nil,
+	}
+	for name, in := range tests {
+		if _, err := aesDecrypt(t, in); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", name, err)
+		}
+	}
+
+	// Chunks preceding a failure are produced.
+	out, err := aesDecrypt(t, flip(len(sealed)-1))
+	if !bytes.Equal(out, plain[:3*chunk]) || err == nil {
+		t.Errorf("got %d bytes, %v", len(out), err)
+	}
+
+	// A different key fails to authenticate.
+	filters.RegisterKeyProvider("other", filters.KeyFunc(func(name string) ([]byte, error) {
+		return bytes.Repeat([]byte{8}, 32), nil
+	}))
+	if _, err := readFilter(t, filters.AESGCM, iofl.Params{"key": "other:k"}, sealed); !iofl.IsCorrupt(err) {
+		t.Errorf("wrong key: got %v, want corrupt", err)
+	}
+}
+
+func TestAESGCMWriter(t *testing.T) {
+	for _, n := range []int{0, 16, 100} {
+		plain := bytes.Repeat([]byte{'w'}, n)
+		sealed, err := writeFilter(t, filters.AESGCM, iofl.Params{"key": "test:k", "chunk": 16}, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := aesDecrypt(t, sealed)
+		if err != nil || !bytes.Equal(out, plain) {
+			t.Errorf("%d bytes: got %q, %v", n, out, err)
+		}
+	}
+	if _, err := writeFilter(t, filters.AESGCM, iofl.Params{"mode": "encrypt", "key": "test:k"}, nil); err != iofl.NotWritable {
+		t.Errorf("got %v, want NotWritable", err)
+	}
+}
+
+func TestAESGCMParams(t *testing.T) {
+	filters.RegisterKeyProvider("short", filters.KeyFunc(func(name string) ([]byte, error) {
+		return []byte("short"), nil
+	}))
+	for _, params := range []iofl.Params{
+		{},
+		{"key": "nocolon"},
+		{"key": "missing:k"},
+		{"key": "test:k", "mode": "seal"},
+		{"key": "test:k", "chunk": 32 << 20},
+	} {
+		if err := filters.AESGCM.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	for _, params := range []iofl.Params{
+		{"key": "short:k"},
+		{"key": "env:IOFL_TEST_MISSING_KEY"},
+	} {
+		if _, err := filters.AESGCM.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error from New", params)
+		}
+	}
+}
+
+func TestKeyProviders(t *testing.T) {
+	key := bytes.Repeat([]byte{0xAB}, 16)
+	path := filepath.Join(t.TempDir(), "key")
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		t.Fatal(err)
+	}
+	os.Setenv("IOFL_TEST_KEY", hex.EncodeToString(key)+"\n")
+	defer os.Unsetenv("IOFL_TEST_KEY")
+	os.Setenv("IOFL_TEST_BAD_KEY", "not hex")
+	defer os.Unsetenv("IOFL_TEST_BAD_KEY")
+
+	if got, err := filters.FileKeys.Key(path); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("file: got %x, %v", got, err)
+	}
+	if got, err := filters.EnvKeys.Key("IOFL_TEST_KEY"); err != nil || !bytes.Equal(got, key) {
+		t.Errorf("env: got %x, %v", got, err)
+	}
+	for _, name := range []string{"IOFL_TEST_BAD_KEY", "IOFL_TEST_MISSING_KEY"} {
+		if _, err := filters.EnvKeys.Key(name); err == nil {
+			t.Errorf("%s: expected error", name)
+		}
+	}
+
+	// Keys are retrieved through the registered providers.
+	plain := []byte("keyed by file")
+	for _, ref := range []string{"file:" + path, "env:IOFL_TEST_KEY"} {
+		sealed := mustRead(t, filters.AESGCM, iofl.Params{"mode": "encrypt", "key": ref}, plain)
+		out, err := readFilter(t, filters.AESGCM, iofl.Params{"key": "file:" + path}, sealed)
+		if err != nil || !bytes.Equal(out, plain) {
+			t.Errorf("%s: got %q, %v", ref, out, err)
+		}
+	}
+	errKey := errors.New("no such key")
+	filters.RegisterKeyProvider("fail", filters.KeyFunc(func(name string) ([]byte, error) {
+		return nil, errKey
+	}))
+	if _, err := filters.AESGCM.New(iofl.Params{"key": "fail:k"}, ioutil.NopCloser(bytes.NewReader(nil))); !errors.Is(err, errKey) {
+		t.Errorf("got %v, want provider error", err)
+	}
+}
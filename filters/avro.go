@@ -0,0 +1,987 @@
+package filters
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/anaminus/iofl"
+)
+
+// Avro converts between Avro Object Container Files and newline-delimited JSON,
+// with one record per line. Params:
+//
+//	mode:   "decode" (default) reads a container file and produces JSON.
+//	        "encode" reads JSON and produces a container file.
+//	schema: The schema of records when encoding, either as a JSON string or
+//	        as a structured value. Required when encoding. When decoding, the
+//	        schema is read from the file.
+//	codec:  The codec used to compress blocks when encoding. Either "null"
+//	        (default) or "deflate". When decoding, both are supported.
+//	block:  The number of records per block when encoding. Defaults to 100.
+//	unions: The representation of union values. "plain" (default) uses the
+//	        value alone. "tagged" wraps non-null values in an object keyed by
+//	        the name of the branch, as in Avro's JSON encoding.
+//	max:    The maximum size of a block when decoding, in bytes. Defaults to
+//	        64MiB.
+//
+// Values of type bytes and fixed are represented as strings of code points in
+// the range 0-255. Non-finite floating-point values are represented as null.
+// Logical types are represented by their underlying type. When encoding, a
+// missing field takes the default value from the schema, and a plain union
+// value is encoded with the first branch that can represent it.
+var Avro = iofl.FilterDef{
+	Name:         "avro",
+	New:          newAvro,
+	Description:  "Converts between Avro Object Container Files and newline-delimited JSON.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decode\" reads a container file and produces JSON. \"encode\" reads JSON and produces a container file.", Default: "decode"},
+		{Name: "schema", Description: "The schema of records when encoding, as a JSON string or a structured value. Required when encoding."},
+		{Name: "codec", Type: iofl.ParamString, Description: "The codec used to compress blocks when encoding, \"null\" or \"deflate\".", Default: "null"},
+		{Name: "block", Type: iofl.ParamInt, Description: "The number of records per block when encoding.", Default: "100"},
+		{Name: "unions", Type: iofl.ParamString, Description: "The representation of union values, \"plain\" or \"tagged\".", Default: "plain"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of a block when decoding, in bytes.", Default: "64MiB"},
+	},
+	Traits: func(params iofl.Params) iofl.Trait {
+		if params.GetString("mode") == "encode" && params.GetString("codec") == "deflate" {
+			return iofl.Compresses
+		}
+		return 0
+	},
+}
+
+// avroMagic begins every container file.
+const avroMagic = "Obj\x01"
+
+// errAvroData is returned when a container file is malformed.
+var errAvroData error = &iofl.CorruptError{Err: errors.New("malformed avro data")}
+
+func newAvro(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	mode, err := getMode(params, "decode", "decode", "encode")
+	if err != nil {
+		return nil, err
+	}
+	filter := &avroFilter{encode: mode == "encode", size: bufferSize(params)}
+	switch unions := params.GetString("unions"); unions {
+	case "", "plain":
+	case "tagged":
+		filter.tagged = true
+	default:
+		return nil, fmt.Errorf("unknown unions %q", unions)
+	}
+	if filter.max = params.GetInt("max"); filter.max <= 0 {
+		filter.max = defaultMaxMessage
+	}
+	if filter.encode {
+		var schema []byte
+		switch v := params["schema"].(type) {
+		case nil:
+			return nil, errors.New("schema required")
+		case string:
+			schema = []byte(v)
+		default:
+			if schema, err = json.Marshal(v); err != nil {
+				return nil, fmt.Errorf("schema: %w", err)
+			}
+		}
+		if filter.schema, err = parseAvroSchema(schema); err != nil {
+			return nil, fmt.Errorf("schema: %w", err)
+		}
+		filter.rawSchema = schema
+		switch filter.codec = params.GetString("codec"); filter.codec {
+		case "":
+			filter.codec = "null"
+		case "null", "deflate":
+		default:
+			return nil, fmt.Errorf("unknown codec %q", filter.codec)
+		}
+		if filter.block = params.GetInt("block"); filter.block <= 0 {
+			filter.block = 100
+		}
+	}
+	filter.stream.next = filter.next
+	filter.Reset(r)
+	return filter, nil
+}
+
+// avroFilter implements the Avro filter.
+type avroFilter struct {
+	src       io.ReadCloser
+	encode    bool
+	tagged    bool
+	max       int
+	size      int
+	codec     string
+	block     int
+	schema    *avroSchema
+	rawSchema []byte
+	closed    bool
+
+	br     *bufio.Reader
+	header bool
+	sync   [16]byte
+	buf    []byte
+	out    []byte
+	stream frameStream
+}
+
+// Source implements iofl.Filter.
+func (f *avroFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *avroFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	return f.stream.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *avroFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *avroFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.header = false
+	f.stream.reset()
+	if f.br == nil {
+		f.br = bufio.NewReaderSize(src, f.size)
+	} else {
+		f.br.Reset(src)
+	}
+	if !f.encode {
+		f.schema = nil
+		f.codec = ""
+	}
+	return nil
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *avroFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *avroFilter) MemoryUsage() int {
+	return f.br.Size() + cap(f.buf) + cap(f.out)
+}
+
+// next returns the next chunk of output.
+func (f *avroFilter) next() ([]byte, error) {
+	if f.encode {
+		return f.nextEncoded()
+	}
+	return f.nextDecoded()
+}
+
+// readLong reads a zig-zag encoded long from the source.
+func (f *avroFilter) readLong() (int64, error) {
+	n, err := binary.ReadVarint(f.br)
+	if err == io.EOF {
+		return 0, io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+// readBytes reads a length-prefixed value from the source.
+func (f *avroFilter) readBytes() ([]byte, error) {
+	n, err := f.readLong()
+	if err != nil {
+		return nil, err
+	}
+	if n < 0 || n > int64(f.max) {
+		return nil, errAvroData
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(f.br, b); err != nil {
+		return nil, noEOF(err)
+	}
+	return b, nil
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readHeader reads the header of a container file.
+func (f *avroFilter) readHeader() error {
+	var magic [4]byte
+	if _, err := io.ReadFull(f.br, magic[:]); err != nil {
+		return noEOF(err)
+	}
+	if string(magic[:]) != avroMagic {
+		return fmt.Errorf("%w: not an object container file", errAvroData)
+	}
+	meta := map[string][]byte{}
+	for {
+		count, err := f.readLong()
+		if err != nil {
+			return err
+		}
+		if count == 0 {
+			break
+		}
+		if count < 0 {
+			count = -count
+			if _, err := f.readLong(); err != nil {
+				return err
+			}
+		}
+		for ; count > 0; count-- {
+			key, err := f.readBytes()
+			if err != nil {
+				return err
+			}
+			value, err := f.readBytes()
+			if err != nil {
+				return err
+			}
+			meta[string(key)] = value
+		}
+	}
+	if _, err := io.ReadFull(f.br, f.sync[:]); err != nil {
+		return noEOF(err)
+	}
+	schema, ok := meta["avro.schema"]
+	if !ok {
+		return fmt.Errorf("%w: missing schema", errAvroData)
+	}
+	var err error
+	if f.schema, err = parseAvroSchema(schema); err != nil {
+		return fmt.Errorf("schema: %w", err)
+	}
+	switch f.codec = string(meta["avro.codec"]); f.codec {
+	case "":
+		f.codec = "null"
+	case "null", "deflate":
+	default:
+		return fmt.Errorf("unsupported codec %q", f.codec)
+	}
+	return nil
+}
+
+// nextDecoded decodes the next block of the container file as JSON.
+func (f *avroFilter) nextDecoded() ([]byte, error) {
+	if !f.header {
+		if err := f.readHeader(); err != nil {
+			return nil, err
+		}
+		f.header = true
+	}
+	count, err := binary.ReadVarint(f.br)
+	if err != nil {
+		return nil, err
+	}
+	data, err := f.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	var sync [16]byte
+	if _, err := io.ReadFull(f.br, sync[:]); err != nil {
+		return nil, noEOF(err)
+	}
+	if sync != f.sync {
+		return nil, fmt.Errorf("%w: sync marker mismatch", errAvroData)
+	}
+	if f.codec == "deflate" {
+		if data, err = io.ReadAll(io.LimitReader(flate.NewReader(bytes.NewReader(data)), int64(f.max)+1)); err != nil {
+			return nil, fmt.Errorf("%w: %s", errAvroData, err)
+		}
+		if len(data) > f.max {
+			return nil, fmt.Errorf("%w: block exceeds maximum size", errAvroData)
+		}
+	}
+	if count < 0 || count > int64(len(data)) {
+		return nil, fmt.Errorf("%w: invalid record count", errAvroData)
+	}
+	d := avroDecoder{b: data, tagged: f.tagged, out: f.out[:0]}
+	for ; count > 0; count-- {
+		if err := d.value(f.schema); err != nil {
+			return nil, err
+		}
+		d.out = append(d.out, '\n')
+	}
+	f.out = d.out
+	return f.out, nil
+}
+
+// nextEncoded encodes the next block of records, preceded by the header of the
+// container file.
+func (f *avroFilter) nextEncoded() ([]byte, error) {
+	f.out = f.out[:0]
+	if !f.header {
+		if _, err := rand.Read(f.sync[:]); err != nil {
+			return nil, err
+		}
+		f.out = append(f.out, avroMagic...)
+		f.out = appendLong(f.out, 2)
+		f.out = appendAvroBytes(f.out, []byte("avro.schema"))
+		f.out = appendAvroBytes(f.out, f.rawSchema)
+		f.out = appendAvroBytes(f.out, []byte("avro.codec"))
+		f.out = appendAvroBytes(f.out, []byte(f.codec))
+		f.out = appendLong(f.out, 0)
+		f.out = append(f.out, f.sync[:]...)
+		f.header = true
+		return f.out, nil
+	}
+	f.buf = f.buf[:0]
+	var count int
+	var err error
+	for count < f.block {
+		var line []byte
+		line, err = f.br.ReadBytes('\n')
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			dec := json.NewDecoder(bytes.NewReader(line))
+			dec.UseNumber()
+			var v interface{}
+			if derr := dec.Decode(&v); derr != nil {
+				return nil, derr
+			}
+			if f.buf, err = appendAvroValue(f.buf, f.schema, v); err != nil {
+				return nil, err
+			}
+			count++
+		}
+		if err != nil {
+			break
+		}
+	}
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+	if count == 0 {
+		return nil, io.EOF
+	}
+	data := f.buf
+	if f.codec == "deflate" {
+		var b bytes.Buffer
+		w, _ := flate.NewWriter(&b, flate.DefaultCompression)
+		w.Write(data)
+		w.Close()
+		data = b.Bytes()
+	}
+	f.out = appendLong(f.out, int64(count))
+	f.out = appendAvroBytes(f.out, data)
+	f.out = append(f.out, f.sync[:]...)
+	return f.out, nil
+}
+
+// avroSchema is a parsed Avro schema.
+type avroSchema struct {
+	// kind is the name of a primitive type, or one of "record", "enum",
+	// "array", "map", "union", or "fixed".
+	kind string
+	// name is the full name of a named type.
+	name     string
+	fields   []avroField
+	symbols  []string
+	items    *avroSchema
+	branches []*avroSchema
+	size     int
+}
+
+// avroField is a field of a record schema.
+type avroField struct {
+	name   string
+	schema *avroSchema
+	def    interface{}
+	hasDef bool
+}
+
+// avroPrimitives is the set of primitive type names.
+var avroPrimitives = map[string]bool{
+	"null": true, "boolean": true, "int": true, "long": true,
+	"float": true, "double": true, "bytes": true, "string": true,
+}
+
+// parseAvroSchema parses a schema from its JSON representation.
+func parseAvroSchema(b []byte) (*avroSchema, error) {
+	var v interface{}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, err
+	}
+	p := avroParser{names: map[string]*avroSchema{}}
+	return p.parse(v, "")
+}
+
+// avroParser parses schemas, tracking named types.
+type avroParser struct {
+	names map[string]*avroSchema
+}
+
+// fullName returns the full name and namespace of a named type.
+func (p *avroParser) fullName(name, namespace, enclosing string) (full, ns string) {
+	if i := strings.LastIndexByte(name, '.'); i >= 0 {
+		return name, name[:i]
+	}
+	if namespace == "" {
+		namespace = enclosing
+	}
+	if namespace == "" {
+		return name, ""
+	}
+	return namespace + "." + name, namespace
+}
+
+// define registers a named type.
+func (p *avroParser) define(s *avroSchema) error {
+	if _, ok := p.names[s.name]; ok {
+		return fmt.Errorf("type %q redefined", s.name)
+	}
+	p.names[s.name] = s
+	return nil
+}
+
+func (p *avroParser) parse(v interface{}, ns string) (*avroSchema, error) {
+	switch v := v.(type) {
+	case string:
+		if avroPrimitives[v] {
+			return &avroSchema{kind: v}, nil
+		}
+		if s, ok := p.names[v]; ok {
+			return s, nil
+		}
+		if ns != "" {
+			if s, ok := p.names[ns+"."+v]; ok {
+				return s, nil
+			}
+		}
+		return nil, fmt.Errorf("unknown type %q", v)
+	case []interface{}:
+		s := &avroSchema{kind: "union"}
+		for _, b := range v {
+			branch, err := p.parse(b, ns)
+			if err != nil {
+				return nil, err
+			}
+			if branch.kind == "union" {
+				return nil, errors.New("union directly within union")
+			}
+			s.branches = append(s.branches, branch)
+		}
+		return s, nil
+	case map[string]interface{}:
+		kind, _ := v["type"].(string)
+		name, _ := v["name"].(string)
+		namespace, _ := v["namespace"].(string)
+		switch kind {
+		case "record", "error":
+			s := &avroSchema{kind: "record"}
+			if name == "" {
+				return nil, errors.New("record requires name")
+			}
+			s.name, ns = p.fullName(name, namespace, ns)
+			if err := p.define(s); err != nil {
+				return nil, err
+			}
+			fields, _ := v["fields"].([]interface{})
+			for _, fv := range fields {
+				fm, ok := fv.(map[string]interface{})
+				if !ok {
+					return nil, errors.New("malformed field")
+				}
+				field := avroField{}
+				field.name, _ = fm["name"].(string)
+				var err error
+				if field.schema, err = p.parse(fm["type"], ns); err != nil {
+					return nil, fmt.Errorf("field %q: %w", field.name, err)
+				}
+				field.def, field.hasDef = fm["default"]
+				s.fields = append(s.fields, field)
+			}
+			return s, nil
+		case "enum":
+			s := &avroSchema{kind: "enum"}
+			s.name, _ = p.fullName(name, namespace, ns)
+			symbols, _ := v["symbols"].([]interface{})
+			for _, sym := range symbols {
+				str, _ := sym.(string)
+				s.symbols = append(s.symbols, str)
+			}
+			return s, p.define(s)
+		case "fixed":
+			s := &avroSchema{kind: "fixed"}
+			s.name, _ = p.fullName(name, namespace, ns)
+			size, _ := v["size"].(float64)
+			if size < 0 {
+				return nil, errors.New("negative fixed size")
+			}
+			s.size = int(size)
+			return s, p.define(s)
+		case "array":
+			items, err := p.parse(v["items"], ns)
+			if err != nil {
+				return nil, err
+			}
+			return &avroSchema{kind: "array", items: items}, nil
+		case "map":
+			values, err := p.parse(v["values"], ns)
+			if err != nil {
+				return nil, err
+			}
+			return &avroSchema{kind: "map", items: values}, nil
+		default:
+			// A primitive type, possibly annotated with a logical type.
+			return p.parse(v["type"], ns)
+		}
+	default:
+		return nil, fmt.Errorf("malformed schema")
+	}
+}
+
+// branchName returns the name that identifies s within a union.
+func (s *avroSchema) branchName() string {
+	if s.name != "" {
+		return s.name
+	}
+	return s.kind
+}
+
+// avroDecoder decodes binary-encoded values as JSON.
+type avroDecoder struct {
+	b      []byte
+	tagged bool
+	out    []byte
+}
+
+func (d *avroDecoder) long() (int64, error) {
+	v, n := binary.Varint(d.b)
+	if n <= 0 {
+		return 0, errAvroData
+	}
+	d.b = d.b[n:]
+	return v, nil
+}
+
+func (d *avroDecoder) fixed(n int) ([]byte, error) {
+	if n < 0 || n > len(d.b) {
+		return nil, errAvroData
+	}
+	b := d.b[:n]
+	d.b = d.b[n:]
+	return b, nil
+}
+
+func (d *avroDecoder) bytes() ([]byte, error) {
+	n, err := d.long()
+	if err != nil {
+		return nil, err
+	}
+	if n > int64(len(d.b)) {
+		return nil, errAvroData
+	}
+	return d.fixed(int(n))
+}
+
+// blockCount reads the count of an array or map block.
+func (d *avroDecoder) blockCount() (int64, error) {
+	n, err := d.long()
+	if err != nil {
+		return 0, err
+	}
+	if n < 0 {
+		n = -n
+		if _, err := d.long(); err != nil {
+			return 0, err
+		}
+	}
+	if n > int64(len(d.b)) {
+		return 0, errAvroData
+	}
+	return n, nil
+}
+
+// value decodes a value of schema s, appending its JSON representation to
+// d.out.
+func (d *avroDecoder) value(s *avroSchema) error {
+	switch s.kind {
+	case "null":
+		d.out = append(d.out, "null"...)
+	case "boolean":
+		b, err := d.fixed(1)
+		if err != nil {
+			return err
+		}
+		d.out = strconv.AppendBool(d.out, b[0] != 0)
+	case "int", "long":
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		d.out = strconv.AppendInt(d.out, n, 10)
+	case "float":
+		b, err := d.fixed(4)
+		if err != nil {
+			return err
+		}
+		d.out = appendJSONFloat(d.out, float64(math.Float32frombits(binary.LittleEndian.Uint32(b))), 32)
+	case "double":
+		b, err := d.fixed(8)
+		if err != nil {
+			return err
+		}
+		d.out = appendJSONFloat(d.out, math.Float64frombits(binary.LittleEndian.Uint64(b)), 64)
+	case "bytes":
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		d.out = appendJSONBytes(d.out, b)
+	case "fixed":
+		b, err := d.fixed(s.size)
+		if err != nil {
+			return err
+		}
+		d.out = appendJSONBytes(d.out, b)
+	case "string":
+		b, err := d.bytes()
+		if err != nil {
+			return err
+		}
+		d.out = appendJSONString(d.out, string(b))
+	case "enum":
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		if n < 0 || n >= int64(len(s.symbols)) {
+			return errAvroData
+		}
+		d.out = appendJSONString(d.out, s.symbols[n])
+	case "record":
+		d.out = append(d.out, '{')
+		for i, field := range s.fields {
+			if i > 0 {
+				d.out = append(d.out, ',')
+			}
+			d.out = appendJSONString(d.out, field.name)
+			d.out = append(d.out, ':')
+			if err := d.value(field.schema); err != nil {
+				return err
+			}
+		}
+		d.out = append(d.out, '}')
+	case "array", "map":
+		open, close := byte('['), byte(']')
+		if s.kind == "map" {
+			open, close = '{', '}'
+		}
+		d.out = append(d.out, open)
+		first := true
+		for {
+			n, err := d.blockCount()
+			if err != nil {
+				return err
+			}
+			if n == 0 {
+				break
+			}
+			for ; n > 0; n-- {
+				if !first {
+					d.out = append(d.out, ',')
+				}
+				first = false
+				if s.kind == "map" {
+					key, err := d.bytes()
+					if err != nil {
+						return err
+					}
+					d.out = appendJSONString(d.out, string(key))
+					d.out = append(d.out, ':')
+				}
+				if err := d.value(s.items); err != nil {
+					return err
+				}
+			}
+		}
+		d.out = append(d.out, close)
+	case "union":
+		n, err := d.long()
+		if err != nil {
+			return err
+		}
+		if n < 0 || n >= int64(len(s.branches)) {
+			return errAvroData
+		}
+		branch := s.branches[n]
+		if !d.tagged || branch.kind == "null" {
+			return d.value(branch)
+		}
+		d.out = append(d.out, '{')
+		d.out = appendJSONString(d.out, branch.branchName())
+		d.out = append(d.out, ':')
+		if err := d.value(branch); err != nil {
+			return err
+		}
+		d.out = append(d.out, '}')
+	}
+	return nil
+}
+
+// appendJSONFloat appends f as a JSON number, or null if f is not finite.
+func appendJSONFloat(b []byte, f float64, bits int) []byte {
+	if math.IsNaN(f) || math.IsInf(f, 0) {
+		return append(b, "null"...)
+	}
+	return strconv.AppendFloat(b, f, 'g', -1, bits)
+}
+
+// appendJSONBytes appends v as a JSON string of code points in the range 0-255.
+func appendJSONBytes(b []byte, v []byte) []byte {
+	r := make([]rune, len(v))
+	for i, c := range v {
+		r[i] = rune(c)
+	}
+	return appendJSONString(b, string(r))
+}
+
+// appendJSONString appends s as a JSON string. Invalid UTF-8 is replaced with
+// U+FFFD.
+func appendJSONString(b []byte, s string) []byte {
+	b = append(b, '"')
+	for _, r := range s {
+		switch {
+		case r == '"' || r == '\\':
+			b = append(b, '\\', byte(r))
+		case r == '\n':
+			b = append(b, '\\', 'n')
+		case r == '\r':
+			b = append(b, '\\', 'r')
+		case r == '\t':
+			b = append(b, '\\', 't')
+		case r < 0x20:
+			b = append(b, '\\', 'u', '0', '0', upperhex[r>>4], upperhex[r&0xF])
+		default:
+			b = append(b, string(r)...)
+		}
+	}
+	return append(b, '"')
+}
+
+// appendLong appends n as a zig-zag encoded long.
+func appendLong(b []byte, n int64) []byte {
+	var buf [binary.MaxVarintLen64]byte
+	return append(b, buf[:binary.PutVarint(buf[:], n)]...)
+}
+
+// appendAvroBytes appends v prefixed with its length.
+func appendAvroBytes(b []byte, v []byte) []byte {
+	return append(appendLong(b, int64(len(v))), v...)
+}
+
+// jsonNumber returns v as a float64 if it is a number.
+func jsonNumber(v interface{}) (float64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		f, err := v.Float64()
+		return f, err == nil
+	case float64:
+		return v, true
+	}
+	return 0, false
+}
+
+// jsonInt returns v as an int64 if it is an integral number.
+func jsonInt(v interface{}) (int64, bool) {
+	switch v := v.(type) {
+	case json.Number:
+		n, err := v.Int64()
+		return n, err == nil
+	case float64:
+		if v == math.Trunc(v) && math.Abs(v) < 1<<63 {
+			return int64(v), true
+		}
+	}
+	return 0, false
+}
+
+// jsonBytes returns a string of code points in the range 0-255 as bytes.
+func jsonBytes(v interface{}) ([]byte, bool) {
+	s, ok := v.(string)
+	if !ok {
+		return nil, false
+	}
+	b := make([]byte, 0, len(s))
+	for _, r := range s {
+		if r > 0xFF || r == utf8.RuneError {
+			return nil, false
+		}
+		b = append(b, byte(r))
+	}
+	return b, true
+}
+
+// avroMatches returns whether v can be represented by s.
+func avroMatches(s *avroSchema, v interface{}) bool {
+	switch s.kind {
+	case "null":
+		return v == nil
+	case "boolean":
+		_, ok := v.(bool)
+		return ok
+	case "int":
+		n, ok := jsonInt(v)
+		return ok && n >= math.MinInt32 && n <= math.MaxInt32
+	case "long":
+		_, ok := jsonInt(v)
+		return ok
+	case "float", "double":
+		_, ok := jsonNumber(v)
+		return ok
+	case "string":
+		_, ok := v.(string)
+		return ok
+	case "bytes":
+		_, ok := jsonBytes(v)
+		return ok
+	case "fixed":
+		b, ok := jsonBytes(v)
+		return ok && len(b) == s.size
+	case "enum":
+		str, _ := v.(string)
+		for _, sym := range s.symbols {
+			if sym == str {
+				return true
+			}
+		}
+		return false
+	case "record", "map":
+		_, ok := v.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := v.([]interface{})
+		return ok
+	}
+	return false
+}
+
+// appendAvroValue appends the binary encoding of v according to s.
+func appendAvroValue(b []byte, s *avroSchema, v interface{}) ([]byte, error) {
+	if !avroMatches(s, v) && s.kind != "union" {
+		return nil, fmt.Errorf("cannot encode %T as %s", v, s.branchName())
+	}
+	switch s.kind {
+	case "null":
+	case "boolean":
+		if v.(bool) {
+			return append(b, 1), nil
+		}
+		return append(b, 0), nil
+	case "int", "long":
+		n, _ := jsonInt(v)
+		return appendLong(b, n), nil
+	case "float":
+		f, _ := jsonNumber(v)
+		var buf [4]byte
+		binary.LittleEndian.PutUint32(buf[:], math.Float32bits(float32(f)))
+		return append(b, buf[:]...), nil
+	case "double":
+		f, _ := jsonNumber(v)
+		var buf [8]byte
+		binary.LittleEndian.PutUint64(buf[:], math.Float64bits(f))
+		return append(b, buf[:]...), nil
+	case "string":
+		return appendAvroBytes(b, []byte(v.(string))), nil
+	case "bytes":
+		data, _ := jsonBytes(v)
+		return appendAvroBytes(b, data), nil
+	case "fixed":
+		data, _ := jsonBytes(v)
+		return append(b, data...), nil
+	case "enum":
+		for i, sym := range s.symbols {
+			if sym == v.(string) {
+				return appendLong(b, int64(i)), nil
+			}
+		}
+	case "record":
+		m := v.(map[string]interface{})
+		for _, field := range s.fields {
+			fv, ok := m[field.name]
+			if !ok {
+				if !field.hasDef {
+					return nil, fmt.Errorf("missing field %q", field.name)
+				}
+				fv = field.def
+			}
+			var err error
+			if b, err = appendAvroValue(b, field.schema, fv); err != nil {
+				return nil, fmt.Errorf("%s: %w", field.name, err)
+			}
+		}
+	case "array":
+		items := v.([]interface{})
+		if len(items) > 0 {
+			b = appendLong(b, int64(len(items)))
+			for _, item := range items {
+				var err error
+				if b, err = appendAvroValue(b, s.items, item); err != nil {
+					return nil, err
+				}
+			}
+		}
+		b = appendLong(b, 0)
+	case "map":
+		m := v.(map[string]interface{})
+		keys := make([]string, 0, len(m))
+		for k := range m {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		if len(keys) > 0 {
+			b = appendLong(b, int64(len(keys)))
+			for _, k := range keys {
+				b = appendAvroBytes(b, []byte(k))
+				var err error
+				if b, err = appendAvroValue(b, s.items, m[k]); err != nil {
+					return nil, fmt.Errorf("%s: %w", k, err)
+				}
+			}
+		}
+		b = appendLong(b, 0)
+	case "union":
+		// A tagged value is an object with a single key naming a branch.
+		if m, ok := v.(map[string]interface{}); ok && len(m) == 1 {
+			for i, branch := range s.branches {
+				if inner, ok := m[branch.branchName()]; ok && avroMatches(branch, inner) {
+					return appendAvroValue(appendLong(b, int64(i)), branch, inner)
+				}
+			}
+		}
+		for i, branch := range s.branches {
+			if avroMatches(branch, v) {
+				return appendAvroValue(appendLong(b, int64(i)), branch, v)
+			}
+		}
+		return nil, fmt.Errorf("no union branch for %T", v)
+	}
+	return b, nil
+}
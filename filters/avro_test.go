@@ -0,0 +1,186 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+const avroTestSchema = `{
+	"type": "record", "name": "Event", "namespace": "test",
+	"fields": [
+		{"name": "id", "type": "long"},
+		{"name": "count", "type": "int"},
+		{"name": "name", "type": "string"},
+		{"name": "ok", "type": "boolean"},
+		{"name": "score", "type": "double"},
+		{"name": "ratio", "type": "float"},
+		{"name": "raw", "type": "bytes"},
+		{"name": "tags", "type": {"type": "array", "items": "string"}},
+		{"name": "attrs", "type": {"type": "map", "values": "int"}},
+		{"name": "note", "type": ["null", "string"]},
+		{"name": "kind", "type": {"type": "enum", "name": "Kind", "symbols": ["A", "B"]}},
+		{"name": "hash", "type": {"type": "fixed", "name": "Hash", "size": 2}},
+		{"name": "child", "type": ["null", {"type": "record", "name": "Child", "fields": [
+			{"name": "v", "type": "int"}
+		]}]},
+		{"name": "extra", "type": "string", "default": "none"}
+	]
+}`
+
+var avroRecords = []string{
+	`{"id":1,"count":-2,"name":"first","ok":true,"score":1.5,"ratio":0.25,"raw":"\u0000ÿ","tags":["a","b"],"attrs":{"x":1},"note":null,"kind":"A","hash":"ab","child":{"v":3},"extra":"e"}`,
+	`{"id":-9007199254740991,"count":0,"name":"café","ok":false,"score":-0.5,"ratio":2,"raw":"","tags":[],"attrs":{},"note":"hi","kind":"B","hash":"\u0001\u0002","child":null,"extra":"none"}`,
+}
+
+// sameJSON returns whether each line of a and b holds equal JSON values.
+func sameJSON(t *testing.T, a, b string) bool {
+	t.Helper()
+	al := strings.Split(strings.TrimSpace(a), "\n")
+	bl := strings.Split(strings.TrimSpace(b), "\n")
+	if len(al) != len(bl) {
+		return false
+	}
+	for i := range al {
+		var av, bv interface{}
+		if err := json.Unmarshal([]byte(al[i]), &av); err != nil {
+			t.Fatalf("line %d: %v: %s", i, err, al[i])
+		}
+		if err := json.Unmarshal([]byte(bl[i]), &bv); err != nil {
+			t.Fatalf("line %d: %v: %s", i, err, bl[i])
+		}
+		if !reflect.DeepEqual(av, bv) {
+			return false
+		}
+	}
+	return true
+}
+
+func avroEncode(t *testing.T, params iofl.Params, records string) []byte {
+	t.Helper()
+	p := iofl.Params{"mode": "encode", "schema": avroTestSchema}
+	for k, v := range params {
+		p[k] = v
+	}
+	return mustRead(t, filters.Avro, p, []byte(records))
+}
+
+func TestAvroRoundTrip(t *testing.T) {
+	var many []string
+	for i := 0; i < 25; i++ {
+		many = append(many, avroRecords[i%2])
+	}
+	for _, params := range []iofl.Params{
+		nil,
+		{"codec": "deflate"},
+		{"block": 3},
+		{"codec": "deflate", "block": 1},
+	} {
+		in := strings.Join(many, "\n") + "\n"
+		ocf := avroEncode(t, params, in)
+		if !bytes.HasPrefix(ocf, []byte("Obj\x01")) {
+			t.Fatalf("%v: missing magic", params)
+		}
+		out := mustRead(t, filters.Avro, iofl.Params{"bufferSize": 64}, ocf)
+		if !sameJSON(t, string(out), in) {
+			t.Errorf("%v: round trip mismatch:\n%s", params, out)
+		}
+	}
+}
+
+func TestAvroDefaults(t *testing.T) {
+	in := strings.Replace(avroRecords[0], `,"extra":"e"`, "", 1)
+	out := mustRead(t, filters.Avro, nil, avroEncode(t, nil, in))
+	want := strings.Replace(avroRecords[0], `"extra":"e"`, `"extra":"none"`, 1)
+	if !sameJSON(t, string(out), want) {
+		t.Errorf("got %s", out)
+	}
+}
+
+func TestAvroTaggedUnions(t *testing.T) {
+	schema := `{"type":"record","name":"R","fields":[{"name":"u","type":["null","int","string"]}]}`
+	in := "{\"u\":{\"string\":\"x\"}}\n{\"u\":null}\n{\"u\":{\"int\":5}}\n"
+	tagged := iofl.Params{"unions": "tagged"}
+	ocf := mustRead(t, filters.Avro, iofl.Params{"mode": "encode", "schema": schema, "unions": "tagged"}, []byte(in))
+	if out := mustRead(t, filters.Avro, tagged, ocf); !sameJSON(t, string(out), in) {
+		t.Errorf("tagged: got %s", out)
+	}
+	if out := mustRead(t, filters.Avro, nil, ocf); !sameJSON(t, string(out), "{\"u\":\"x\"}\n{\"u\":null}\n{\"u\":5}\n") {
+		t.Errorf("plain: got %s", out)
+	}
+}
+
+func TestAvroStructuredSchema(t *testing.T) {
+	var schema interface{}
+	json.Unmarshal([]byte(avroTestSchema), &schema)
+	ocf := mustRead(t, filters.Avro, iofl.Params{"mode": "encode", "schema": schema}, []byte(avroRecords[0]))
+	if out := mustRead(t, filters.Avro, nil, ocf); !sameJSON(t, string(out), avroRecords[0]) {
+		t.Errorf("got %s", out)
+	}
+}
+
+func TestAvroCorrupt(t *testing.T) {
+	ocf := avroEncode(t, nil, avroRecords[0])
+	deflated := avroEncode(t, iofl.Params{"codec": "deflate"}, avroRecords[0])
+	// The header ends with the sync marker, which also ends each block.
+	sync := ocf[len(ocf)-16:]
+	header := ocf[:bytes.Index(ocf, sync)+16]
+	block := ocf[len(header) : len(ocf)-16]
+
+	mutate := func(b []byte, i int, v byte) []byte {
+		b = append([]byte{}, b...)
+		b[i] = v
+		return b
+	}
+	tests := []struct {
+		name   string
+		in     []byte
+		params iofl.Params
+	}{
+		{"bad magic", mutate(ocf, 0, 'X'), nil},
+		{"truncated header", header[:len(header)-4], nil},
+		{"truncated block", ocf[:len(ocf)-20], nil},
+		{"sync mismatch", mutate(ocf, len(ocf)-1, ocf[len(ocf)-1]^1), nil},
+		{"bad record count", append(append(append([]byte{}, header...), 0x7E), block[1:]...), nil},
+		{"oversize block", ocf, iofl.Params{"max": 8}},
+		{"bad deflate", mutate(deflated, len(deflated)-20, 0xFF), nil},
+		{"missing schema", []byte("Obj\x01\x00" + strings.Repeat("s", 16)), nil},
+	}
+	for _, tt := range tests {
+		_, err := readFilter(t, filters.Avro, tt.params, tt.in)
+		if err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		} else if !iofl.IsCorrupt(err) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v, want corrupt or truncated", tt.name, err)
+		}
+	}
+}
+
+func TestAvroEncodeErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		params iofl.Params
+		in     string
+	}{
+		{"missing schema", iofl.Params{"mode": "encode"}, ""},
+		{"bad schema", iofl.Params{"mode": "encode", "schema": `{"type":"nope"}`}, ""},
+		{"bad codec", iofl.Params{"mode": "encode", "schema": `"int"`, "codec": "snappy"}, ""},
+		{"bad unions", iofl.Params{"unions": "nested"}, ""},
+		{"mismatched value", iofl.Params{"mode": "encode", "schema": `"int"`}, `"text"`},
+		{"missing field", iofl.Params{"mode": "encode", "schema": `{"type":"record","name":"R","fields":[{"name":"a","type":"int"}]}`}, `{}`},
+		{"bad enum", iofl.Params{"mode": "encode", "schema": `{"type":"enum","name":"E","symbols":["A"]}`}, `"B"`},
+		{"bad json", iofl.Params{"mode": "encode", "schema": `"int"`}, `{`},
+	}
+	for _, tt := range tests {
+		if _, err := readFilter(t, filters.Avro, tt.params, []byte(tt.in)); err == nil {
+			t.Errorf("%s: expected error", tt.name)
+		}
+	}
+}
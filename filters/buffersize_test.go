@@ -0,0 +1,44 @@
+package filters_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// TestBufferSize checks that filters that buffer their source size the buffer
+// with the bufferSize param.
+func TestBufferSize(t *testing.T) {
+	tests := []struct {
+		def    iofl.FilterDef
+		params iofl.Params
+	}{
+		{filters.Avro, iofl.Params{}},
+		{filters.Gzip, iofl.Params{}},
+		{filters.JWS, iofl.Params{"key": "test:hmac", "alg": "HS256"}},
+		{filters.Members, iofl.Params{}},
+		{filters.ProtoDelim, iofl.Params{}},
+		{filters.ZstdSeek, iofl.Params{}},
+	}
+	usage := func(def iofl.FilterDef, params iofl.Params) int {
+		f, err := def.New(params, ioutil.NopCloser(bytes.NewReader(nil)))
+		if err != nil {
+			t.Fatalf("%s: %v", def.Name, err)
+		}
+		defer f.Close()
+		return f.(iofl.MemoryUser).MemoryUsage()
+	}
+	for _, tt := range tests {
+		sized := iofl.Params{iofl.ParamBufferSize: 8 << 10}
+		for k, v := range tt.params {
+			sized[k] = v
+		}
+		base := usage(tt.def, tt.params)
+		if got, want := usage(tt.def, sized)-base, 4<<10; got != want {
+			t.Errorf("%s: usage grew by %d with bufferSize, want %d", tt.def.Name, got, want)
+		}
+	}
+}
@@ -0,0 +1,461 @@
+package filters
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"unicode/utf16"
+	"unicode/utf8"
+
+	"github.com/anaminus/iofl"
+)
+
+// CharsetDetector is implemented by filters that detect the character encoding
+// of their source.
+type CharsetDetector interface {
+	// Charset returns the name of the detected encoding. Detection may require
+	// reading from the source, in which case an empty string is returned if an
+	// error occurs. The error is returned by the next Read.
+	Charset() string
+}
+
+// Charset detects the character encoding of text by sniffing a prefix of the
+// source. The detected encoding is reported by the filter through the
+// CharsetDetector interface, and is one of "utf-8", "utf-16le", "utf-16be",
+// "windows-1252", or "iso-8859-1". Params:
+//
+//	mode:    "detect" (default) passes data through unchanged. "convert"
+//	         converts the data to UTF-8, removing any byte order mark. Invalid
+//	         sequences are replaced with U+FFFD.
+//	sniff:   The number of bytes examined for detection. Defaults to 4096.
+//	default: The encoding assumed when the prefix is not valid UTF-8 or
+//	         UTF-16. Defaults to "windows-1252" if the prefix contains bytes in
+//	         the range 0x80-0x9F, or "iso-8859-1" otherwise.
+//
+// The filter honors the bufferSize param when converting.
+var Charset = iofl.FilterDef{
+	Name:         "charset",
+	New:          newCharset,
+	Description:  "Detects the character encoding of text, optionally converting it to UTF-8.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"detect\" passes data through unchanged. \"convert\" converts the data to UTF-8.", Default: "detect"},
+		{Name: "sniff", Type: iofl.ParamInt, Description: "The number of bytes examined for detection.", Default: "4096"},
+		{Name: "default", Type: iofl.ParamString, Description: "The encoding assumed when the content is not valid UTF-8 or UTF-16.", Default: "windows-1252 or iso-8859-1"},
+	},
+}
+
+// charsetDecoders maps an encoding name to a transformer that converts it to
+// UTF-8.
+var charsetDecoders = map[string]func() transformer{
+	"utf-8":        func() transformer { return &utf8Decoder{} },
+	"utf-16le":     func() transformer { return &utf16Decoder{order: 0} },
+	"utf-16be":     func() transformer { return &utf16Decoder{order: 1} },
+	"windows-1252": func() transformer { return singleByteDecoder{table: &windows1252} },
+	"iso-8859-1":   func() transformer { return singleByteDecoder{} },
+}
+
+func newCharset(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	mode, err := getMode(params, "detect", "detect", "convert")
+	if err != nil {
+		return nil, err
+	}
+	def := params.GetString("default")
+	if _, ok := charsetDecoders[def]; def != "" && !ok {
+		return nil, fmt.Errorf("unknown encoding %q", def)
+	}
+	sniff := params.GetInt("sniff")
+	if sniff <= 0 {
+		sniff = 4096
+	}
+	return &charsetFilter{
+		src:     r,
+		convert: mode == "convert",
+		def:     def,
+		sniff:   sniff,
+		size:    bufferSize(params),
+	}, nil
+}
+
+// charsetFilter implements the Charset filter.
+type charsetFilter struct {
+	src     io.ReadCloser
+	convert bool
+	def     string
+	sniff   int
+	size    int
+	budget  *iofl.MemoryBudget
+
+	charset string
+	err     error
+	prefix  *bytes.Reader
+	r       io.Reader
+	closed  bool
+}
+
+// prefixCloser reads from a prefix, and then from a source.
+type prefixCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// detect sniffs the source, if it has not already been sniffed.
+func (f *charsetFilter) detect() {
+	if f.r != nil || f.err != nil {
+		return
+	}
+	prefix, eof, err := readPrefix(f.src, f.sniff)
+	if err != nil {
+		f.err = err
+		return
+	}
+	f.setCharset(detectCharset(prefix, eof, f.def), prefix)
+}
+
+// setCharset sets the detected encoding, and prepares the filter to read the
+// remaining prefix followed by the source.
+func (f *charsetFilter) setCharset(charset string, prefix []byte) {
+	f.release()
+	f.charset = charset
+	f.prefix = bytes.NewReader(prefix)
+	f.r = io.MultiReader(f.prefix, f.src)
+	if f.convert {
+		t := newTransformFilter(prefixCloser{f.r, f.src}, charsetDecoders[f.charset](), f.size)
+		t.budget = f.budget
+		f.r = t
+	}
+}
+
+// detectCharset guesses the encoding of prefix. eof indicates whether prefix
+// is the entire stream.
+func detectCharset(prefix []byte, eof bool, def string) string {
+	switch {
+	case bytes.HasPrefix(prefix, []byte{0xEF, 0xBB, 0xBF}):
+		return "utf-8"
+	case bytes.HasPrefix(prefix, []byte{0xFF, 0xFE}):
+		return "utf-16le"
+	case bytes.HasPrefix(prefix, []byte{0xFE, 0xFF}):
+		return "utf-16be"
+	}
+
+	// Text encoded as UTF-16 without a BOM typically has many zero bytes in
+	// either even or odd positions.
+	var zeros [2]int
+	for i, c := range prefix {
+		if c == 0 {
+			zeros[i&1]++
+		}
+	}
+	if half := len(prefix) / 2; half > 0 {
+		switch {
+		case zeros[1] > half/2 && zeros[0]*10 < zeros[1]:
+			return "utf-16le"
+		case zeros[0] > half/2 && zeros[1]*10 < zeros[0]:
+			return "utf-16be"
+		}
+	}
+
+	valid := prefix
+	if !eof {
+		// Allow the prefix to end in the middle of a character.
+		valid = trimPartialRune(valid)
+	}
+	if utf8.Valid(valid) {
+		return "utf-8"
+	}
+	if def != "" {
+		return def
+	}
+	for _, c := range prefix {
+		if 0x80 <= c && c <= 0x9F {
+			return "windows-1252"
+		}
+	}
+	return "iso-8859-1"
+}
+
+// Charset implements CharsetDetector.
+func (f *charsetFilter) Charset() string {
+	if !f.closed {
+		f.detect()
+	}
+	return f.charset
+}
+
+// Source implements iofl.Filter.
+func (f *charsetFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *charsetFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	f.detect()
+	if f.err != nil {
+		err, f.err = f.err, nil
+		return 0, err
+	}
+	return f.r.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *charsetFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	f.release()
+	return f.src.Close()
+}
+
+// release discards the conversion, if any, returning its buffers to the
+// budget.
+func (f *charsetFilter) release() {
+	if t, ok := f.r.(*transformFilter); ok {
+		t.release()
+	}
+}
+
+// SetMemoryBudget implements iofl.BudgetUser. The buffers of the conversion
+// are reserved from b.
+func (f *charsetFilter) SetMemoryBudget(b *iofl.MemoryBudget) {
+	f.budget = b
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *charsetFilter) MemoryUsage() int {
+	if m, ok := f.r.(iofl.MemoryUser); ok {
+		return m.MemoryUsage()
+	}
+	return 0
+}
+
+// SaveState implements iofl.StateSaver. The state includes the detected
+// encoding, the unread portion of the sniffed prefix, and the state of the
+// conversion.
+func (f *charsetFilter) SaveState() (state []byte, err error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	var prefix, inner []byte
+	if f.prefix != nil {
+		prefix = make([]byte, f.prefix.Len())
+		f.prefix.Read(prefix)
+		f.prefix.Seek(-int64(len(prefix)), io.SeekCurrent)
+	}
+	if ss, ok := f.r.(iofl.StateSaver); ok {
+		if inner, err = ss.SaveState(); err != nil {
+			return nil, err
+		}
+	}
+	state = appendState(state, []byte(f.charset))
+	state = appendState(state, prefix)
+	state = appendState(state, inner)
+	return state, nil
+}
+
+// RestoreState implements iofl.StateSaver. If an encoding was detected, the
+// source is not sniffed again.
+func (f *charsetFilter) RestoreState(state []byte) error {
+	if f.closed {
+		return iofl.Closed
+	}
+	charset, state, err := readState(state)
+	if err != nil {
+		return err
+	}
+	prefix, state, err := readState(state)
+	if err != nil {
+		return err
+	}
+	inner, _, err := readState(state)
+	if err != nil {
+		return err
+	}
+	if len(charset) == 0 {
+		return nil
+	}
+	if _, ok := charsetDecoders[string(charset)]; !ok {
+		return errBadState
+	}
+	f.setCharset(string(charset), append([]byte(nil), prefix...))
+	if ss, ok := f.r.(iofl.StateSaver); ok {
+		return ss.RestoreState(inner)
+	}
+	return nil
+}
+
+// Reset implements iofl.Resetter.
+func (f *charsetFilter) Reset(src io.ReadCloser) error {
+	f.release()
+	f.src = src
+	f.charset = ""
+	f.err = nil
+	f.prefix = nil
+	f.r = nil
+	f.closed = false
+	return nil
+}
+
+// writeRune writes r to dst as UTF-8, returning the number of bytes written,
+// or -1 if dst is too short.
+func writeRune(dst []byte, r rune) int {
+	if len(dst) < utf8.RuneLen(r) {
+		return -1
+	}
+	return utf8.EncodeRune(dst, r)
+}
+
+// bomStripper tracks whether a decoder has passed the start of the stream, so
+// that only a leading byte order mark is removed. U+FEFF elsewhere is content.
+type bomStripper struct {
+	started bool
+}
+
+// strip returns whether r, the next rune of the stream, is a byte order mark
+// to be removed.
+func (b *bomStripper) strip(r rune) bool {
+	if b.started {
+		return false
+	}
+	b.started = true
+	return r == 0xFEFF
+}
+
+func (b *bomStripper) reset() {
+	b.started = false
+}
+
+// SaveState implements iofl.StateSaver.
+func (b *bomStripper) SaveState() (state []byte, err error) {
+	if b.started {
+		return []byte{1}, nil
+	}
+	return []byte{0}, nil
+}
+
+// RestoreState implements iofl.StateSaver.
+func (b *bomStripper) RestoreState(state []byte) error {
+	if len(state) != 1 || state[0] > 1 {
+		return errBadState
+	}
+	b.started = state[0] == 1
+	return nil
+}
+
+// utf8Decoder removes a leading byte order mark, and replaces invalid UTF-8
+// sequences.
+type utf8Decoder struct {
+	bomStripper
+}
+
+func (d *utf8Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if !atEOF && !utf8.FullRune(src[nSrc:]) {
+			return nDst, nSrc, errShortSrc
+		}
+		r, size := utf8.DecodeRune(src[nSrc:])
+		if d.strip(r) {
+			nSrc += size
+			continue
+		}
+		w := writeRune(dst[nDst:], r)
+		if w < 0 {
+			return nDst, nSrc, errShortDst
+		}
+		nDst += w
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+// utf16Decoder converts UTF-16 to UTF-8, removing a leading byte order mark.
+// order is 0 for little-endian, and 1 for big-endian.
+type utf16Decoder struct {
+	bomStripper
+	order int
+}
+
+func (d *utf16Decoder) unit(b []byte) rune {
+	if d.order == 0 {
+		return rune(b[0]) | rune(b[1])<<8
+	}
+	return rune(b[0])<<8 | rune(b[1])
+}
+
+func (d *utf16Decoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if len(src)-nSrc < 2 {
+			if !atEOF {
+				return nDst, nSrc, errShortSrc
+			}
+			w := writeRune(dst[nDst:], utf8.RuneError)
+			if w < 0 {
+				return nDst, nSrc, errShortDst
+			}
+			return nDst + w, len(src), nil
+		}
+		r, size := d.unit(src[nSrc:]), 2
+		if utf16.IsSurrogate(r) {
+			if len(src)-nSrc < 4 && !atEOF {
+				return nDst, nSrc, errShortSrc
+			}
+			if len(src)-nSrc >= 4 {
+				if dec := utf16.DecodeRune(r, d.unit(src[nSrc+2:])); dec != utf8.RuneError {
+					r, size = dec, 4
+				} else {
+					r = utf8.RuneError
+				}
+			} else {
+				r = utf8.RuneError
+			}
+		}
+		if d.strip(r) {
+			nSrc += size
+			continue
+		}
+		w := writeRune(dst[nDst:], r)
+		if w < 0 {
+			return nDst, nSrc, errShortDst
+		}
+		nDst += w
+		nSrc += size
+	}
+	return nDst, nSrc, nil
+}
+
+// windows1252 maps the range 0x80-0x9F of Windows-1252.
+var windows1252 = [32]rune{
+	0x20AC, 0xFFFD, 0x201A, 0x0192, 0x201E, 0x2026, 0x2020, 0x2021,
+	0x02C6, 0x2030, 0x0160, 0x2039, 0x0152, 0xFFFD, 0x017D, 0xFFFD,
+	0xFFFD, 0x2018, 0x2019, 0x201C, 0x201D, 0x2022, 0x2013, 0x2014,
+	0x02DC, 0x2122, 0x0161, 0x203A, 0x0153, 0xFFFD, 0x017E, 0x0178,
+}
+
+// singleByteDecoder converts a single-byte encoding to UTF-8. The table maps
+// bytes in the range 0x80-0x9F; other bytes map to the equivalent code point.
+// A nil table maps all bytes to the equivalent code point (ISO 8859-1).
+type singleByteDecoder struct {
+	table *[32]rune
+}
+
+func (t singleByteDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for _, c := range src {
+		r := rune(c)
+		if t.table != nil && 0x80 <= c && c <= 0x9F {
+			r = t.table[c-0x80]
+		}
+		w := writeRune(dst[nDst:], r)
+		if w < 0 {
+			return nDst, nSrc, errShortDst
+		}
+		nDst += w
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
@@ -0,0 +1,99 @@
+package filters_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestCharsetDetect(t *testing.T) {
+	tests := []struct {
+		in      string
+		params  iofl.Params
+		charset string
+	}{
+		{"plain ascii", nil, "utf-8"},
+		{"caf\xc3\xa9", nil, "utf-8"},
+		{"\xef\xbb\xbfbom", nil, "utf-8"},
+		{"\xff\xfeh\x00i\x00", nil, "utf-16le"},
+		{"\xfe\xff\x00h\x00i", nil, "utf-16be"},
+		{"h\x00e\x00l\x00l\x00o\x00", nil, "utf-16le"},
+		{"\x00h\x00e\x00l\x00l\x00o", nil, "utf-16be"},
+		{"caf\xe9", nil, "iso-8859-1"},
+		{"\x93quoted\x94", nil, "windows-1252"},
+		{"caf\xe9", iofl.Params{"default": "windows-1252"}, "windows-1252"},
+		// A multi-byte character split by the sniff limit is still UTF-8.
+		{"ab\xc3\xa9", iofl.Params{"sniff": 3}, "utf-8"},
+	}
+	for _, tt := range tests {
+		f, err := filters.Charset.New(tt.params, ioutil.NopCloser(bytes.NewReader([]byte(tt.in))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := f.(filters.CharsetDetector).Charset(); got != tt.charset {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.charset)
+		}
+		// Detection does not consume data.
+		out, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(out) != tt.in {
+			t.Errorf("%q: detect mode changed data to %q", tt.in, out)
+		}
+		f.Close()
+	}
+}
+
+func TestCharsetConvert(t *testing.T) {
+	convert := iofl.Params{"mode": "convert"}
+	tests := []struct {
+		in, want string
+	}{
+		{"caf\xc3\xa9", "café"},
+		{"\xef\xbb\xbfbom", "bom"},
+		{"\xff\xfeh\x00i\x00", "hi"},
+		{"\xfe\xff\x00h\x00i", "hi"},
+		{"\xfe\xff\xd8\x3d\xde\x00", "\U0001F600"},
+		{"caf\xe9", "café"},
+		{"\x93quoted\x94", "“quoted”"},
+		// Only a leading byte order mark is removed.
+		{"\xef\xbb\xbfa\xef\xbb\xbfb", "a\ufeffb"},
+		{"\xff\xfea\x00\xff\xfeb\x00", "a\ufeffb"},
+		// Unpaired surrogates and truncated units are replaced.
+		{"\xff\xfe\x00\xd8a\x00", "\ufffda"},
+		{"\xff\xfea\x00b", "a\ufffd"},
+	}
+	for _, tt := range tests {
+		if got := mustRead(t, filters.Charset, convert, []byte(tt.in)); string(got) != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestCharsetConvertLarge(t *testing.T) {
+	// Exceed both the sniffed prefix and the conversion buffer.
+	in := bytes.Repeat([]byte("caf\xe9 "), 10000)
+	want := bytes.Repeat([]byte("café "), 10000)
+	got := mustRead(t, filters.Charset, iofl.Params{"mode": "convert", "sniff": 100, "bufferSize": 512}, in)
+	if !bytes.Equal(got, want) {
+		t.Error("large conversion mismatch")
+	}
+}
+
+func TestCharsetParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "guess"},
+		{"default": "ebcdic"},
+	} {
+		if _, err := filters.Charset.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.Charset.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
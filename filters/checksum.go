@@ -0,0 +1,235 @@
+package filters
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Checksum computes a digest of the content passing through it, and optionally
+// verifies the digest against an expected value. Params:
+//
+//	algorithm: The hash algorithm. One of "sha256" (default), "sha512",
+//	           "sha1", or "crc32" (IEEE polynomial, big-endian).
+//	expected:  The expected digest, encoded as hexadecimal. If empty, the
+//	           digest is computed but not verified.
+//
+// Content passes through unchanged. When the end of the source is reached, the
+// digest is compared to expected, and a mismatch is returned by Read in place
+// of io.EOF. The mismatch is a corrupt error. The filter reports the digest
+// through the Digester and iofl.Reporter interfaces.
+//
+// When used in a write chain, written content is hashed, and the digest is
+// verified when the writer is closed.
+var Checksum = iofl.FilterDef{
+	Name:         "checksum",
+	New:          newChecksum,
+	NewWriter:    newChecksumWriter,
+	Validate:     validateChecksum,
+	Description:  "Computes and verifies a digest of content.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "algorithm", Type: iofl.ParamString, Description: "\"sha256\", \"sha512\", \"sha1\", or \"crc32\".", Default: "sha256"},
+		{Name: "expected", Type: iofl.ParamString, Description: "The expected digest, encoded as hexadecimal."},
+	},
+}
+
+// Digester is implemented by filters that compute a digest of their content.
+type Digester interface {
+	// Digest returns the digest of the content that has passed through the
+	// filter so far.
+	Digest() []byte
+}
+
+// checksumParams contains the parsed params of the filter.
+type checksumParams struct {
+	algorithm string
+	new       func() hash.Hash
+	expected  []byte
+}
+
+func parseChecksum(params iofl.Params) (p checksumParams, err error) {
+	switch p.algorithm = params.GetString("algorithm"); p.algorithm {
+	case "", "sha256":
+		p.algorithm = "sha256"
+		p.new = sha256.New
+	case "sha512":
+		p.new = sha512.New
+	case "sha1":
+		p.new = sha1.New
+	case "crc32":
+		p.new = func() hash.Hash { return crc32.NewIEEE() }
+	default:
+		return p, fmt.Errorf("unknown algorithm %q", p.algorithm)
+	}
+	if s := params.GetString("expected"); s != "" {
+		if p.expected, err = hex.DecodeString(s); err != nil {
+			return p, fmt.Errorf("expected: %w", err)
+		}
+		if size := p.new().Size(); len(p.expected) != size {
+			return p, fmt.Errorf("expected: %s digest must be %d bytes", p.algorithm, size)
+		}
+	}
+	return p, nil
+}
+
+func validateChecksum(params iofl.Params) error {
+	_, err := parseChecksum(params)
+	return err
+}
+
+// verify compares the digest of h to the expected digest, if any.
+func (p checksumParams) verify(h hash.Hash) error {
+	if p.expected == nil {
+		return nil
+	}
+	if sum := h.Sum(nil); !bytes.Equal(sum, p.expected) {
+		return &iofl.CorruptError{Err: fmt.Errorf("%s checksum mismatch: expected %x, got %x", p.algorithm, p.expected, sum)}
+	}
+	return nil
+}
+
+// report returns the values of an iofl.Reporter for the digest of h.
+func (p checksumParams) report(h hash.Hash) map[string]interface{} {
+	return map[string]interface{}{
+		"algorithm": p.algorithm,
+		"digest":    hex.EncodeToString(h.Sum(nil)),
+	}
+}
+
+func newChecksum(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	p, err := parseChecksum(params)
+	if err != nil {
+		return nil, err
+	}
+	filter := &checksumFilter{params: p, h: p.new()}
+	filter.Reset(r)
+	return filter, nil
+}
+
+// checksumFilter implements the Checksum filter.
+type checksumFilter struct {
+	src    io.ReadCloser
+	params checksumParams
+	h      hash.Hash
+	err    error
+	closed bool
+}
+
+// Source implements iofl.Filter.
+func (f *checksumFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *checksumFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err = f.src.Read(p)
+	f.h.Write(p[:n])
+	if err == io.EOF {
+		if verr := f.params.verify(f.h); verr != nil {
+			err = verr
+		}
+	}
+	f.err = err
+	return n, err
+}
+
+// Digest implements Digester.
+func (f *checksumFilter) Digest() []byte {
+	return f.h.Sum(nil)
+}
+
+// Report implements iofl.Reporter, reporting the algorithm and the digest,
+// encoded as hexadecimal.
+func (f *checksumFilter) Report() map[string]interface{} {
+	return f.params.report(f.h)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *checksumFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *checksumFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.h.Reset()
+	f.err = nil
+	f.closed = false
+	return nil
+}
+
+// PreservesSize implements iofl.SizePreserver.
+func (f *checksumFilter) PreservesSize() bool {
+	return true
+}
+
+func newChecksumWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	p, err := parseChecksum(params)
+	if err != nil {
+		return nil, err
+	}
+	return &checksumWriter{dst: w, params: p, h: p.new()}, nil
+}
+
+// checksumWriter implements the Checksum filter in a write chain.
+type checksumWriter struct {
+	dst    io.WriteCloser
+	params checksumParams
+	h      hash.Hash
+	closed bool
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *checksumWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// Write implements io.Writer.
+func (w *checksumWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	n, err = w.dst.Write(p)
+	w.h.Write(p[:n])
+	return n, err
+}
+
+// Digest implements Digester.
+func (w *checksumWriter) Digest() []byte {
+	return w.h.Sum(nil)
+}
+
+// Close implements io.Closer, verifying the digest, and closing the sink.
+func (w *checksumWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	err := w.params.verify(w.h)
+	if cerr := w.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
@@ -0,0 +1,141 @@
+package filters_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"hash/crc32"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+var checksumContent = []byte("verify the integrity of this content")
+
+// checksumTests pairs each algorithm with the digest of checksumContent.
+var checksumTests = []struct {
+	algorithm string
+	sum       func([]byte) []byte
+}{
+	{"sha256", func(b []byte) []byte { s := sha256.Sum256(b); return s[:] }},
+	{"sha512", func(b []byte) []byte { s := sha512.Sum512(b); return s[:] }},
+	{"sha1", func(b []byte) []byte { s := sha1.Sum(b); return s[:] }},
+	{"crc32", func(b []byte) []byte {
+		s := crc32.ChecksumIEEE(b)
+		return []byte{byte(s >> 24), byte(s >> 16), byte(s >> 8), byte(s)}
+	}},
+}
+
+func TestChecksum(t *testing.T) {
+	for _, tt := range checksumTests {
+		want := tt.sum(checksumContent)
+		f, err := filters.Checksum.New(iofl.Params{"algorithm": tt.algorithm}, ioutil.NopCloser(bytes.NewReader(checksumContent)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := ioutil.ReadAll(f)
+		if err != nil || !bytes.Equal(out, checksumContent) {
+			t.Errorf("%s: got %q, %v", tt.algorithm, out, err)
+		}
+		if got := f.(filters.Digester).Digest(); !bytes.Equal(got, want) {
+			t.Errorf("%s: got digest %x, want %x", tt.algorithm, got, want)
+		}
+		report := f.(iofl.Reporter).Report()
+		if report["algorithm"] != tt.algorithm || report["digest"] != hex.EncodeToString(want) {
+			t.Errorf("%s: got report %v", tt.algorithm, report)
+		}
+		f.Close()
+
+		params := iofl.Params{"algorithm": tt.algorithm, "expected": hex.EncodeToString(want)}
+		if _, err := readFilter(t, filters.Checksum, params, checksumContent); err != nil {
+			t.Errorf("%s: %v", tt.algorithm, err)
+		}
+		// Content passes through before the mismatch is reported.
+		bad := append([]byte{}, checksumContent...)
+		bad[0] ^= 1
+		out, err = readFilter(t, filters.Checksum, params, bad)
+		if !iofl.IsCorrupt(err) || !bytes.Equal(out, bad) {
+			t.Errorf("%s: got %d bytes, %v, want corrupt", tt.algorithm, len(out), err)
+		}
+	}
+}
+
+func TestChecksumReset(t *testing.T) {
+	params := iofl.Params{"expected": hex.EncodeToString(checksumTests[0].sum(checksumContent))}
+	f, err := filters.Checksum.New(params, ioutil.NopCloser(bytes.NewReader(nil)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); !iofl.IsCorrupt(err) {
+		t.Fatalf("got %v, want corrupt", err)
+	}
+	// The error is sticky until the filter is reset.
+	if _, err := f.Read(make([]byte, 1)); !iofl.IsCorrupt(err) {
+		t.Errorf("got %v, want corrupt", err)
+	}
+	f.Close()
+	if _, err := f.Read(make([]byte, 1)); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+	if err := f.(iofl.Resetter).Reset(ioutil.NopCloser(bytes.NewReader(checksumContent))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); err != nil {
+		t.Errorf("after reset: %v", err)
+	}
+	f.Close()
+	if !f.(iofl.SizePreserver).PreservesSize() {
+		t.Error("size not preserved")
+	}
+}
+
+func TestChecksumWriter(t *testing.T) {
+	sum := hex.EncodeToString(checksumTests[0].sum(checksumContent))
+	out, err := writeFilter(t, filters.Checksum, iofl.Params{"expected": sum}, checksumContent)
+	if err != nil || !bytes.Equal(out, checksumContent) {
+		t.Errorf("got %q, %v", out, err)
+	}
+	out, err = writeFilter(t, filters.Checksum, iofl.Params{"expected": sum}, checksumContent[1:])
+	if !iofl.IsCorrupt(err) || !bytes.Equal(out, checksumContent[1:]) {
+		t.Errorf("got %q, %v, want corrupt", out, err)
+	}
+
+	var buf bytes.Buffer
+	w, err := filters.Checksum.NewWriter(nil, nopWriteCloser{&buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write(checksumContent)
+	if got := w.(filters.Digester).Digest(); hex.EncodeToString(got) != sum {
+		t.Errorf("got digest %x", got)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+	if _, err := w.Write(nil); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
+
+func TestChecksumParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"algorithm": "md5"},
+		{"expected": "not hex"},
+		{"expected": "abcd"},
+		{"algorithm": "crc32", "expected": hex.EncodeToString(make([]byte, 32))},
+	} {
+		if err := filters.Checksum.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.Checksum.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
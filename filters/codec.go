@@ -0,0 +1,49 @@
+package filters
+
+import (
+	"fmt"
+	"sync"
+)
+
+// FrameCodec compresses and decompresses individual, self-contained frames of a
+// compression format. Codecs allow filters to support formats without
+// depending on a particular implementation.
+type FrameCodec interface {
+	// EncodeFrame appends to dst a frame containing the compressed content of
+	// src.
+	EncodeFrame(dst, src []byte) ([]byte, error)
+	// DecodeFrame appends to dst the decompressed content of the frame src.
+	DecodeFrame(dst, src []byte) ([]byte, error)
+}
+
+// codecs is the registry of FrameCodecs.
+var codecs = struct {
+	sync.RWMutex
+	m map[string]FrameCodec
+}{m: map[string]FrameCodec{
+	"zstd-raw": zstdRawCodec{},
+}}
+
+// RegisterCodec registers c under the given name, replacing any codec of the
+// same name. The following names are used by filters:
+//
+//	zstd:     Zstandard. Not registered by default; registered by the
+//	          ioflzstd module.
+//	zstd-raw: Zstandard frames containing uncompressed blocks. Decoding
+//	          fails on compressed blocks. Registered by default.
+func RegisterCodec(name string, c FrameCodec) {
+	codecs.Lock()
+	defer codecs.Unlock()
+	codecs.m[name] = c
+}
+
+// getCodec returns the codec of the given name.
+func getCodec(name string) (FrameCodec, error) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+	c, ok := codecs.m[name]
+	if !ok {
+		return nil, fmt.Errorf("codec %q not registered", name)
+	}
+	return c, nil
+}
@@ -0,0 +1,584 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+
+	"github.com/anaminus/iofl"
+)
+
+// getChains returns the "chains" parameter, a non-empty list of chain names.
+func getChains(params iofl.Params) ([]string, error) {
+	list, ok := params["chains"].([]interface{})
+	if !ok || len(list) == 0 {
+		return nil, errors.New("chains required")
+	}
+	chains := make([]string, len(list))
+	for i, v := range list {
+		name, ok := v.(string)
+		if !ok {
+			return nil, fmt.Errorf("chains: expected string, got %T", v)
+		}
+		chains[i] = name
+	}
+	return chains, nil
+}
+
+// resolveChain resolves the named chain from s over src. An empty name passes
+// src through unchanged. If resolving fails, src has been closed, and the
+// returned closedChain may be held in place of the chain.
+func resolveChain(s *iofl.ChainSet, chain string, src io.ReadCloser) (io.ReadCloser, error) {
+	if chain == "" {
+		return src, nil
+	}
+	r, err := s.Resolve(chain, src)
+	if err != nil {
+		return closedChain{}, err
+	}
+	return r, nil
+}
+
+// closedChain stands in for a chain that failed to resolve. Resolve closes the
+// source of such a chain, so closing a closedChain does nothing.
+type closedChain struct{}
+
+func (closedChain) Read(p []byte) (n int, err error) { return 0, iofl.Closed }
+func (closedChain) Close() error                     { return nil }
+
+// Concat returns the definition of a filter that passes its source through
+// several chains, resolved from s, in sequence, such that the output of each
+// chain is the source of the next. Params:
+//
+//	chains: A list of chain names, applied in order. Required. An empty name
+//	        is skipped.
+//
+// This is the same as a chain of references to each chain, but allows the
+// sequence to be given by a parameter, such as one expanded from variables.
+// An error resolving a chain is returned by the first Read.
+func Concat(s *iofl.ChainSet) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:         "concat",
+		Description:  "Passes content through several chains in sequence.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "chains", Type: iofl.ParamList, Required: true, Description: "A list of chain names, applied in order.", Chain: true},
+		},
+		New: func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+			if r == nil {
+				return nil, errNoSource
+			}
+			chains, err := getChains(params)
+			if err != nil {
+				return nil, err
+			}
+			return &concatFilter{set: s, src: r, chains: chains}, nil
+		},
+	}
+}
+
+// concatFilter implements the Concat filter.
+type concatFilter struct {
+	set    *iofl.ChainSet
+	src    io.ReadCloser
+	chains []string
+
+	r      io.ReadCloser
+	err    error
+	closed bool
+}
+
+// resolve resolves each chain, if this has not already been done.
+func (f *concatFilter) resolve() {
+	if f.r != nil || f.err != nil {
+		return
+	}
+	f.r = f.src
+	for _, chain := range f.chains {
+		r, err := resolveChain(f.set, chain, f.r)
+		// The previous chain is closed if r fails to resolve, so r is held in
+		// its place.
+		f.r = r
+		if err != nil {
+			f.err = fmt.Errorf("%q: %w", chain, err)
+			return
+		}
+	}
+}
+
+// Source implements iofl.Filter.
+func (f *concatFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *concatFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	f.resolve()
+	if f.err != nil {
+		return 0, f.err
+	}
+	return f.r.Read(p)
+}
+
+// Close implements io.Closer, closing the resolved chains and the source.
+func (f *concatFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	if f.r != nil {
+		// Closing the last resolved chain closes each chain before it.
+		return f.r.Close()
+	}
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *concatFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.r = nil
+	f.err = nil
+	f.closed = false
+	return nil
+}
+
+// fanout writes to each of several pipes, dropping a pipe once a write to it
+// fails, such as when its reader is closed.
+type fanout struct {
+	pipes []*io.PipeWriter
+	errs  []error
+}
+
+// write writes p to each pipe. The pipes are written concurrently, so that a
+// slow reader does not delay the others from receiving p. Returns the number
+// of pipes remaining.
+func (f *fanout) write(p []byte) int {
+	if len(f.pipes) == 1 {
+		if _, err := f.pipes[0].Write(p); err != nil {
+			f.pipes = nil
+		}
+		return len(f.pipes)
+	}
+	if cap(f.errs) < len(f.pipes) {
+		f.errs = make([]error, len(f.pipes))
+	}
+	errs := f.errs[:len(f.pipes)]
+	var wg sync.WaitGroup
+	for i, w := range f.pipes {
+		wg.Add(1)
+		go func(i int, w *io.PipeWriter) {
+			defer wg.Done()
+			_, errs[i] = w.Write(p)
+		}(i, w)
+	}
+	wg.Wait()
+	live := f.pipes[:0]
+	for i, w := range f.pipes {
+		if errs[i] == nil {
+			live = append(live, w)
+		}
+	}
+	f.pipes = live
+	return len(live)
+}
+
+// close closes each pipe with err, which is nil or io.EOF if the content ended
+// normally.
+func (f *fanout) close(err error) {
+	if err == io.EOF {
+		err = nil
+	}
+	for _, w := range f.pipes {
+		w.CloseWithError(err)
+	}
+	f.pipes = nil
+}
+
+// Parallel returns the definition of a filter that sends its source to
+// several chains, resolved from s, at once. Params:
+//
+//	chains: A list of chain names. Required. The output of the first chain is
+//	        the output of the filter. An empty first name passes the content
+//	        through unchanged.
+//
+// Each chain after the first runs in its own goroutine, and receives the
+// content as the first chain reads it. The filter implements
+// iofl.SideOutputter, with a side output named after each chain after the
+// first receiving its output. Output that is not directed by the #outputs
+// meta-parameter is discarded, so a chain may also be used for its effects
+// alone, such as writing to a sink. Chains after the first must be distinct,
+// and may not be empty.
+//
+// Reading the first chain is blocked while another chain has not consumed the
+// content, so the chains proceed together. Once the first chain is read to
+// the end, the filter waits for the other chains to finish, and the first
+// error of another chain, if any, is returned in place of io.EOF. An error
+// resolving a chain is returned by the first Read.
+func Parallel(s *iofl.ChainSet) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:         "parallel",
+		Description:  "Sends content to several chains at once.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "chains", Type: iofl.ParamList, Required: true, Description: "A list of chain names. The output of the first chain is the output of the filter, and the others are side outputs.", Chain: true},
+		},
+		New: func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+			if r == nil {
+				return nil, errNoSource
+			}
+			chains, err := getChains(params)
+			if err != nil {
+				return nil, err
+			}
+			outputs := make(map[string]io.Writer, len(chains)-1)
+			for _, chain := range chains[1:] {
+				if chain == "" {
+					return nil, errors.New("chains: only the first chain may be empty")
+				}
+				if _, ok := outputs[chain]; ok {
+					return nil, fmt.Errorf("chains: duplicate chain %q", chain)
+				}
+				outputs[chain] = ioutil.Discard
+			}
+			return &parallelFilter{set: s, src: r, chains: chains, outputs: outputs}, nil
+		},
+	}
+}
+
+// parallelFilter implements the Parallel filter.
+type parallelFilter struct {
+	set     *iofl.ChainSet
+	src     io.ReadCloser
+	chains  []string
+	outputs map[string]io.Writer
+
+	tee    *teeSource
+	r      io.ReadCloser
+	wg     sync.WaitGroup
+	mu     sync.Mutex
+	errs   []error
+	err    error
+	closed bool
+}
+
+// teeSource reads from the source of a parallelFilter, writing what is read to
+// the other chains.
+type teeSource struct {
+	src io.ReadCloser
+	fan fanout
+}
+
+func (t *teeSource) Read(p []byte) (n int, err error) {
+	n, err = t.src.Read(p)
+	if n > 0 {
+		t.fan.write(p[:n])
+	}
+	if err != nil {
+		t.fan.close(err)
+	}
+	return n, err
+}
+
+func (t *teeSource) Close() error {
+	t.fan.close(iofl.Closed)
+	return t.src.Close()
+}
+
+// fail records an error of a chain after the first.
+func (f *parallelFilter) fail(chain string, err error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.errs = append(f.errs, fmt.Errorf("%q: %w", chain, err))
+}
+
+// resolve resolves each chain, and starts the chains after the first, if this
+// has not already been done.
+func (f *parallelFilter) resolve() {
+	if f.tee != nil {
+		return
+	}
+	f.tee = &teeSource{src: f.src}
+	for _, chain := range f.chains[1:] {
+		pr, pw := io.Pipe()
+		r, err := f.set.Resolve(chain, pr)
+		if err != nil {
+			f.err = fmt.Errorf("%q: %w", chain, err)
+			return
+		}
+		f.tee.fan.pipes = append(f.tee.fan.pipes, pw)
+		f.wg.Add(1)
+		go func(chain string, r io.ReadCloser, w io.Writer) {
+			defer f.wg.Done()
+			if _, err := io.Copy(w, r); err != nil && !errors.Is(err, iofl.Closed) {
+				f.fail(chain, err)
+			}
+			r.Close()
+		}(chain, r, f.outputs[chain])
+	}
+	if f.r, f.err = resolveChain(f.set, f.chains[0], f.tee); f.err != nil {
+		f.err = fmt.Errorf("%q: %w", f.chains[0], f.err)
+	}
+}
+
+// SideOutputs implements iofl.SideOutputter.
+func (f *parallelFilter) SideOutputs() []string {
+	return append([]string(nil), f.chains[1:]...)
+}
+
+// SetSideOutput implements iofl.SideOutputter.
+func (f *parallelFilter) SetSideOutput(name string, w io.Writer) {
+	if _, ok := f.outputs[name]; ok {
+		f.outputs[name] = w
+	}
+}
+
+// Source implements iofl.Filter.
+func (f *parallelFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *parallelFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	f.resolve()
+	if f.err != nil {
+		return 0, f.err
+	}
+	n, err = f.r.Read(p)
+	if err == io.EOF {
+		f.wg.Wait()
+		f.mu.Lock()
+		if len(f.errs) > 0 {
+			err = f.errs[0]
+		}
+		f.mu.Unlock()
+	}
+	return n, err
+}
+
+// Close implements io.Closer, closing the chains and the source. Waits for
+// the chains after the first to finish.
+func (f *parallelFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	var err error
+	switch {
+	case f.r != nil:
+		err = f.r.Close()
+	case f.tee != nil:
+		err = f.tee.Close()
+	default:
+		err = f.src.Close()
+	}
+	f.wg.Wait()
+	return err
+}
+
+// Race returns the definition of a filter that sends its source to several
+// chains, resolved from s, at once, and selects the first chain to succeed.
+// Params:
+//
+//	chains: A list of chain names. Required. An empty name passes the content
+//	        through unchanged.
+//	check:  The number of bytes a chain must produce without error to be
+//	        selected. Defaults to 512.
+//
+// Each chain runs in its own goroutine. A chain succeeds once it produces check
+// bytes, or reaches the end of its output, without error. The first chain to
+// succeed is selected, and the others are stopped. Unlike Fallback, the source
+// is not buffered, and the chains proceed together, so a slow chain does not
+// delay the selection of a faster one. The filter reports the selected chain
+// through the Router interface. If every chain fails, an error describing each
+// failure is returned by the first Read.
+func Race(s *iofl.ChainSet) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:         "race",
+		Description:  "Sends content to several chains at once, and selects the first that succeeds.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "chains", Type: iofl.ParamList, Required: true, Description: "A list of chain names. An empty name passes content through unchanged.", Chain: true},
+			{Name: "check", Type: iofl.ParamInt, Description: "The number of bytes a chain must produce without error to be selected.", Default: "512"},
+		},
+		New: func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+			if r == nil {
+				return nil, errNoSource
+			}
+			chains, err := getChains(params)
+			if err != nil {
+				return nil, err
+			}
+			filter := &raceFilter{set: s, src: r, chains: chains}
+			if filter.check = params.GetInt("check"); filter.check <= 0 {
+				filter.check = 512
+			}
+			return filter, nil
+		},
+	}
+}
+
+// raceFilter implements the Race filter.
+type raceFilter struct {
+	set    *iofl.ChainSet
+	src    io.ReadCloser
+	chains []string
+	check  int
+
+	// pumped is closed once the goroutine that reads the source returns.
+	pumped chan struct{}
+
+	route  string
+	r      io.ReadCloser
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// errStopped stops a chain that was not selected.
+var errStopped = errors.New("stopped")
+
+// raceResult is the outcome of a chain.
+type raceResult struct {
+	index int
+	r     io.ReadCloser
+	buf   []byte
+	err   error
+}
+
+// pump reads the source, writing it to each chain.
+func (f *raceFilter) pump(fan *fanout) {
+	defer close(f.pumped)
+	buf := make([]byte, 32<<10)
+	for {
+		n, err := f.src.Read(buf)
+		if n > 0 && fan.write(buf[:n]) == 0 {
+			return
+		}
+		if err != nil {
+			fan.close(err)
+			return
+		}
+	}
+}
+
+// resolve runs the chains and selects one, if this has not already been done.
+func (f *raceFilter) resolve() {
+	if f.pumped != nil {
+		return
+	}
+	f.pumped = make(chan struct{})
+	errs := make([]string, 0, len(f.chains))
+	readers := make(map[int]*io.PipeReader, len(f.chains))
+	results := make(chan raceResult, len(f.chains))
+	var fan fanout
+	for i, chain := range f.chains {
+		pr, pw := io.Pipe()
+		r, err := resolveChain(f.set, chain, pr)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%q: %s", chain, err))
+			continue
+		}
+		fan.pipes = append(fan.pipes, pw)
+		readers[i] = pr
+		go func(i int, r io.ReadCloser) {
+			buf := make([]byte, f.check)
+			n, err := io.ReadFull(r, buf)
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				err = nil
+			}
+			results <- raceResult{index: i, r: r, buf: buf[:n], err: err}
+		}(i, r)
+	}
+	if len(readers) == 0 {
+		close(f.pumped)
+		f.err = fmt.Errorf("all chains failed: %s", strings.Join(errs, "; "))
+		return
+	}
+	go f.pump(&fan)
+	for range readers {
+		result := <-results
+		if result.err == nil && f.r == nil {
+			f.route = f.chains[result.index]
+			f.r = result.r
+			f.buf = result.buf
+			for i, pr := range readers {
+				if i != result.index {
+					pr.CloseWithError(errStopped)
+				}
+			}
+			continue
+		}
+		if result.err != nil && !errors.Is(result.err, errStopped) {
+			errs = append(errs, fmt.Sprintf("%q: %s", f.chains[result.index], result.err))
+		}
+		result.r.Close()
+	}
+	if f.r == nil {
+		f.err = fmt.Errorf("all chains failed: %s", strings.Join(errs, "; "))
+	}
+}
+
+// Route implements Router, returning the name of the selected chain.
+func (f *raceFilter) Route() string {
+	return f.route
+}
+
+// Source implements iofl.Filter.
+func (f *raceFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *raceFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	f.resolve()
+	if f.err != nil {
+		return 0, f.err
+	}
+	if len(f.buf) > 0 {
+		n = copy(p, f.buf)
+		f.buf = f.buf[n:]
+		return n, nil
+	}
+	return f.r.Read(p)
+}
+
+// Close implements io.Closer, closing the selected chain and the source. Waits
+// for the pending Read of the source to return before closing it.
+func (f *raceFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	var err error
+	if f.r != nil {
+		err = f.r.Close()
+	}
+	if f.pumped != nil {
+		<-f.pumped
+	}
+	if cerr := f.src.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *raceFilter) MemoryUsage() int {
+	n := cap(f.buf)
+	if f.r != nil {
+		n += iofl.MemoryUsage(f.r)
+	}
+	return n
+}
@@ -0,0 +1,353 @@
+package filters_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+var errChain = errors.New("chain failed")
+
+// composeFilter returns the definition of a filter that calls read on each
+// Read, reading from its source.
+func composeFilter(name string, read func(r io.Reader, p []byte) (int, error)) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return iofl.AsFilter(struct {
+				io.Reader
+				io.Closer
+			}{readerFunc(func(p []byte) (int, error) { return read(r, p) }), r}), nil
+		},
+	}
+}
+
+// readerFunc is an io.Reader that calls itself.
+type readerFunc func(p []byte) (int, error)
+
+func (f readerFunc) Read(p []byte) (int, error) { return f(p) }
+
+// composeSet returns a ChainSet with the built-in filters, and the given
+// chains in addition to the following:
+//
+//	upper: Upper-cases content.
+//	rot13: Applies ROT13 to content.
+//	hex:   Encodes content as hex.
+//	fail:  Fails on the first Read.
+//	late:  Fails after reading its source.
+//	slow:  Delays each Read.
+//	drain: Reads its source, producing nothing.
+func composeSet(t *testing.T, chains map[string]iofl.Chain) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	for _, def := range []iofl.FilterDef{
+		composeFilter("fail", func(r io.Reader, p []byte) (int, error) {
+			return 0, errChain
+		}),
+		composeFilter("late", func(r io.Reader, p []byte) (int, error) {
+			n, err := r.Read(p)
+			if err == io.EOF {
+				err = errChain
+			}
+			return n, err
+		}),
+		composeFilter("slow", func(r io.Reader, p []byte) (int, error) {
+			time.Sleep(20 * time.Millisecond)
+			return r.Read(p)
+		}),
+		composeFilter("drain", func(r io.Reader, p []byte) (int, error) {
+			if _, err := io.Copy(ioutil.Discard, r); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}),
+	} {
+		if err := s.Register(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	all := map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"rot13": {{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}},
+		"hex":   {{Filter: "hex"}},
+		"fail":  {{Filter: "fail"}},
+		"late":  {{Filter: "late"}},
+		"slow":  {{Filter: "slow"}, {Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"drain": {{Filter: "drain"}},
+	}
+	for k, v := range chains {
+		all[k] = v
+	}
+	if err := s.SetConfig(iofl.Config{Chains: all}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// composeRead resolves chain from s over in, and returns its output.
+func composeRead(t *testing.T, s *iofl.ChainSet, chain, in string, opts ...iofl.Option) (string, error) {
+	t.Helper()
+	f, err := s.Resolve(chain, ioutil.NopCloser(strings.NewReader(in)), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return string(b), err
+}
+
+// chains returns the params of a composing filter over the given chains.
+func chains(names ...string) iofl.Params {
+	list := make([]interface{}, len(names))
+	for i, name := range names {
+		list[i] = name
+	}
+	return iofl.Params{"chains": list}
+}
+
+func TestConcat(t *testing.T) {
+	s := composeSet(t, map[string]iofl.Chain{
+		"cat":     {{Filter: "concat", Params: chains("upper", "", "rot13", "hex")}},
+		"vars":    {{Filter: "concat", Params: iofl.Params{"chains": []interface{}{"${a}", "${b}"}}}},
+		"failing": {{Filter: "concat", Params: chains("upper", "late")}},
+	})
+	if got, err := composeRead(t, s, "cat", "abc"); err != nil || got != "4e4f50" {
+		t.Errorf("got %q, %v", got, err)
+	}
+	f, err := s.ResolveVars("vars", map[string]string{"a": "rot13", "b": "upper"}, ioutil.NopCloser(strings.NewReader("abc")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b, err := ioutil.ReadAll(f); err != nil || string(b) != "NOP" {
+		t.Errorf("vars: got %q, %v", b, err)
+	}
+	f.Close()
+	if got, err := composeRead(t, s, "failing", "abc"); !errors.Is(err, errChain) || got != "ABC" {
+		t.Errorf("failing: got %q, %v", got, err)
+	}
+
+	// An error resolving a chain is returned by the first Read. The chains
+	// resolved before it, and the source, are closed once.
+	src := &closeCounter{Reader: strings.NewReader("")}
+	f, err = filters.Concat(s).New(chains("upper", "missing"), src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, iofl.UnknownChain) || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	if src.n != 1 {
+		t.Errorf("source closed %d times", src.n)
+	}
+	if err := f.Close(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
+
+func TestConcatClose(t *testing.T) {
+	s := composeSet(t, nil)
+	for _, read := range []bool{false, true} {
+		src := &closeCounter{Reader: strings.NewReader("abc")}
+		f, err := filters.Concat(s).New(chains("upper", "rot13"), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if read {
+			ioutil.ReadAll(f)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if src.n != 1 {
+			t.Errorf("read %v: source closed %d times", read, src.n)
+		}
+	}
+}
+
+func TestParallel(t *testing.T) {
+	s := composeSet(t, map[string]iofl.Chain{
+		"par": {{Filter: "parallel", Params: withParams(chains("upper", "hex", "rot13"),
+			"#outputs", map[string]interface{}{"hex": "h", "rot13": "r"})}},
+		"through": {{Filter: "parallel", Params: withParams(chains("", "hex"),
+			"#outputs", map[string]interface{}{"hex": "h"})}},
+		"effects": {{Filter: "parallel", Params: chains("upper", "drain")}},
+		"failing": {{Filter: "parallel", Params: chains("upper", "late", "fail")}},
+	})
+	content := strings.Repeat("parallel content ", 10000)
+	var h, r bytes.Buffer
+	got, err := composeRead(t, s, "par", content, iofl.Sink("h", &h), iofl.Sink("r", &r))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != strings.ToUpper(content) {
+		t.Error("main output does not match")
+	}
+	if h.Len() != 2*len(content) {
+		t.Errorf("got %d bytes of hex side output", h.Len())
+	}
+	if rot, _ := composeRead(t, s, "rot13", content); r.String() != rot {
+		t.Error("rot13 side output does not match")
+	}
+
+	// An empty first chain passes the content through.
+	h.Reset()
+	if got, err := composeRead(t, s, "through", "ab", iofl.Sink("h", &h)); err != nil || got != "ab" || h.String() != "6162" {
+		t.Errorf("through: got %q, %q, %v", got, h.String(), err)
+	}
+	// Output that is not directed is discarded.
+	if got, err := composeRead(t, s, "effects", content); err != nil || got != strings.ToUpper(content) {
+		t.Errorf("effects: got %d bytes, %v", len(got), err)
+	}
+	// The error of another chain is returned in place of io.EOF.
+	if got, err := composeRead(t, s, "failing", "abc"); !errors.Is(err, errChain) || got != "ABC" {
+		t.Errorf("failing: got %q, %v", got, err)
+	}
+
+	def := filters.Parallel(s)
+	f, err := def.New(chains("upper", "hex"), ioutil.NopCloser(strings.NewReader("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := f.(iofl.SideOutputter).SideOutputs(); len(got) != 1 || got[0] != "hex" {
+		t.Errorf("got side outputs %q", got)
+	}
+	f.Close()
+	for _, params := range []iofl.Params{
+		chains("upper", ""),
+		chains("upper", "hex", "hex"),
+	} {
+		if _, err := def.New(params, ioutil.NopCloser(strings.NewReader(""))); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	f, err = def.New(chains("upper", "missing"), ioutil.NopCloser(strings.NewReader("")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+	f.Close()
+}
+
+func TestParallelClose(t *testing.T) {
+	s := composeSet(t, nil)
+	content := strings.Repeat("x", 1<<20)
+	for _, n := range []int{-1, 0, 100} {
+		src := &closeCounter{Reader: strings.NewReader(content)}
+		f, err := filters.Parallel(s).New(chains("upper", "slow", "hex"), src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n >= 0 {
+			if _, err := io.ReadFull(f, make([]byte, n)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		// Closing before the end stops the other chains.
+		done := make(chan error, 1)
+		go func() { done <- f.Close() }()
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Errorf("read %d: %v", n, err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("read %d: close did not return", n)
+		}
+		if src.n != 1 {
+			t.Errorf("read %d: source closed %d times", n, src.n)
+		}
+	}
+}
+
+func TestRace(t *testing.T) {
+	s := composeSet(t, map[string]iofl.Chain{
+		"fast":    {{Filter: "race", Params: chains("fail", "slow", "rot13")}},
+		"first":   {{Filter: "race", Params: withParams(chains("late", "slow"), "check", 2)}},
+		"through": {{Filter: "race", Params: chains("fail", "")}},
+		"none":    {{Filter: "race", Params: chains("fail", "late", "missing")}},
+	})
+	content := strings.Repeat("race ", 1000)
+	rot, _ := composeRead(t, s, "rot13", content)
+	tests := []struct {
+		chain, in, out, route string
+	}{
+		{"fast", content, rot, "rot13"},
+		{"fast", "ab", "no", "rot13"},
+		{"through", "abc", "abc", ""},
+	}
+	for _, tt := range tests {
+		f, err := s.Resolve(tt.chain, ioutil.NopCloser(strings.NewReader(tt.in)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(f)
+		if err != nil || string(b) != tt.out {
+			t.Errorf("%s: got %d bytes, %v", tt.chain, len(b), err)
+		}
+		var route string
+		iofl.Apply(f, func(r io.ReadCloser) error {
+			if v, ok := r.(filters.Router); ok {
+				route = v.Route()
+			}
+			return nil
+		})
+		if route != tt.route {
+			t.Errorf("%s: got route %q, want %q", tt.chain, route, tt.route)
+		}
+		f.Close()
+	}
+
+	// A chain that produces check bytes is selected, even if it fails later.
+	got, err := composeRead(t, s, "first", "abcd")
+	if got != "abcd" || !errors.Is(err, errChain) {
+		t.Errorf("first: got %q, %v", got, err)
+	}
+
+	_, err = composeRead(t, s, "none", "abc")
+	if err == nil || !strings.Contains(err.Error(), "all chains failed") ||
+		!strings.Contains(err.Error(), `"fail"`) || !strings.Contains(err.Error(), `"missing"`) {
+		t.Errorf("got %v", err)
+	}
+	if _, err := filters.Race(s).New(iofl.Params{}, ioutil.NopCloser(strings.NewReader(""))); err == nil {
+		t.Error("expected error without chains")
+	}
+}
+
+func TestRaceConcurrent(t *testing.T) {
+	s := composeSet(t, map[string]iofl.Chain{
+		"race": {{Filter: "race", Params: chains("slow", "upper", "fail")}},
+		"par":  {{Filter: "parallel", Params: chains("upper", "slow", "hex", "drain")}},
+	})
+	content := strings.Repeat("concurrent ", 10000)
+	want := strings.ToUpper(content)
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(chain string) {
+			defer wg.Done()
+			got, err := composeRead(t, s, chain, content)
+			if err != nil || got != want {
+				t.Errorf("%s: got %d bytes, %v", chain, len(got), err)
+			}
+		}([]string{"race", "par"}[i%2])
+	}
+	wg.Wait()
+}
@@ -0,0 +1,250 @@
+package filters
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/anaminus/iofl"
+)
+
+// Each returns the definition of a filter that applies a chain, resolved from
+// s, to each frame of its source, for transforming a stream of records
+// individually. Params:
+//
+//	chain:      The chain applied to each frame. Required.
+//	policy:     The handling of frames that fail to transform. "error"
+//	            (default) returns an error. "skip" drops the frame, routing it
+//	            to the dead-letter chain and side output.
+//	deadletter: The chain applied to each dropped frame before it is written
+//	            to the side output. If empty, the frame is written unchanged.
+//
+// If the source does not implement iofl.Framer, the entire source is treated
+// as one frame. The filter implements iofl.Framer, with each frame being the
+// output of the chain for one frame of the source.
+//
+// A frame fails to transform if resolving or reading the chain returns an
+// error. A temporary error, such as a failure of the source, is not attributed
+// to the frame, and is always returned. An error from the dead-letter chain is
+// also returned.
+//
+// The filter implements iofl.SideOutputter, with the side output "rejected"
+// receiving the output of the dead-letter chain for each dropped frame. Frames
+// written to the side output are not delimited; a dead-letter chain ending
+// with a joining protodelim link, for example, can be used to preserve frame
+// boundaries. The filter reports the number of frames processed and dropped
+// through the RecordCounter and iofl.Reporter interfaces.
+func Each(s *iofl.ChainSet) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:         "each",
+		Description:  "Applies a chain to each frame of the source.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "chain", Type: iofl.ParamString, Required: true, Description: "The chain applied to each frame.", Chain: true},
+			{Name: "policy", Type: iofl.ParamString, Description: "The handling of frames that fail to transform, \"error\" or \"skip\".", Default: "error"},
+			{Name: "deadletter", Type: iofl.ParamString, Description: "The chain applied to each dropped frame.", Default: "unchanged", Chain: true},
+		},
+		New: func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+			if r == nil {
+				return nil, errNoSource
+			}
+			filter := &eachFilter{
+				set:        s,
+				chain:      params.GetString("chain"),
+				deadletter: params.GetString("deadletter"),
+			}
+			if filter.chain == "" {
+				return nil, errors.New("chain required")
+			}
+			switch policy := params.GetString("policy"); policy {
+			case "", "error":
+			case "skip":
+				filter.skip = true
+			default:
+				return nil, fmt.Errorf("unknown policy %q", policy)
+			}
+			filter.stream.next = filter.ReadFrame
+			filter.Reset(r)
+			return filter, nil
+		},
+	}
+}
+
+// RecordStats contains counts of the records processed by a filter.
+type RecordStats struct {
+	// Records is the number of records read from the source.
+	Records int64
+	// Rejected is the number of records that were dropped.
+	Rejected int64
+}
+
+// RecordCounter is implemented by filters that count the records they
+// process. Stats may be called concurrently with reading the filter.
+type RecordCounter interface {
+	// Stats returns the counts of records processed since the filter was
+	// created or last reset.
+	Stats() RecordStats
+}
+
+// report returns the stats as values of an iofl.Reporter.
+func (s RecordStats) report() map[string]interface{} {
+	return map[string]interface{}{
+		"records":  s.Records,
+		"rejected": s.Rejected,
+	}
+}
+
+// eachFilter implements the Each filter.
+type eachFilter struct {
+	set        *iofl.ChainSet
+	src        io.ReadCloser
+	chain      string
+	deadletter string
+	skip       bool
+	closed     bool
+
+	rejected io.Writer
+
+	done    bool
+	frame   bytes.Buffer
+	records int64
+	dropped int64
+	stream  frameStream
+}
+
+// next returns the next frame of the source.
+func (f *eachFilter) next() ([]byte, error) {
+	if fr, ok := f.src.(iofl.Framer); ok {
+		return fr.ReadFrame()
+	}
+	if f.done {
+		return nil, io.EOF
+	}
+	f.done = true
+	return io.ReadAll(f.src)
+}
+
+// apply resolves chain over b, and returns its output, which is written to
+// f.frame.
+func (f *eachFilter) apply(chain string, b []byte) ([]byte, error) {
+	f.frame.Reset()
+	r, err := f.set.Resolve(chain, io.NopCloser(bytes.NewReader(b)))
+	if err != nil {
+		return nil, err
+	}
+	_, err = f.frame.ReadFrom(r)
+	if cerr := r.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return nil, err
+	}
+	return f.frame.Bytes(), nil
+}
+
+// reject routes a dropped frame through the dead-letter chain to the side
+// output.
+func (f *eachFilter) reject(b []byte) error {
+	atomic.AddInt64(&f.dropped, 1)
+	if f.deadletter != "" {
+		var err error
+		if b, err = f.apply(f.deadletter, b); err != nil {
+			return fmt.Errorf("deadletter: %w", err)
+		}
+	}
+	if f.rejected == nil {
+		return nil
+	}
+	_, err := f.rejected.Write(b)
+	return err
+}
+
+// ReadFrame implements iofl.Framer, returning the output of the chain for the
+// next frame of the source.
+func (f *eachFilter) ReadFrame() ([]byte, error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	for {
+		b, err := f.next()
+		if err != nil {
+			return nil, err
+		}
+		atomic.AddInt64(&f.records, 1)
+		out, err := f.apply(f.chain, b)
+		if err == nil {
+			return out, nil
+		}
+		if !f.skip || iofl.IsTemporary(err) {
+			return nil, err
+		}
+		if err := f.reject(b); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// Stats implements RecordCounter.
+func (f *eachFilter) Stats() RecordStats {
+	return RecordStats{
+		Records:  atomic.LoadInt64(&f.records),
+		Rejected: atomic.LoadInt64(&f.dropped),
+	}
+}
+
+// Report implements iofl.Reporter, reporting the stats of the filter.
+func (f *eachFilter) Report() map[string]interface{} {
+	return f.Stats().report()
+}
+
+// SideOutputs implements iofl.SideOutputter.
+func (f *eachFilter) SideOutputs() []string {
+	return []string{"rejected"}
+}
+
+// SetSideOutput implements iofl.SideOutputter.
+func (f *eachFilter) SetSideOutput(name string, w io.Writer) {
+	if name == "rejected" {
+		f.rejected = w
+	}
+}
+
+// Source implements iofl.Filter.
+func (f *eachFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *eachFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	return f.stream.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *eachFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *eachFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.done = false
+	atomic.StoreInt64(&f.records, 0)
+	atomic.StoreInt64(&f.dropped, 0)
+	f.stream.reset()
+	return nil
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *eachFilter) MemoryUsage() int {
+	return f.frame.Cap()
+}
@@ -0,0 +1,125 @@
+package filters_test
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// gzipRecords returns each record compressed as a gzip member, which is a
+// frame once decompressed.
+func gzipRecords(t *testing.T, records ...string) []byte {
+	t.Helper()
+	var b []byte
+	for _, r := range records {
+		b = append(b, gzipMember(t, "", r)...)
+	}
+	return b
+}
+
+// eachSet returns a ChainSet where the chain "c" percent-decodes each gzip
+// member of its source, routing malformed members, upper-cased, to the sink
+// "dl".
+func eachSet(t *testing.T) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"decode": {{Filter: "percent", Params: iofl.Params{"mode": "decode"}}},
+		"upper":  {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"c": {
+			{Filter: "gzip"},
+			{Filter: "each", Params: iofl.Params{
+				"chain":      "decode",
+				"policy":     "skip",
+				"deadletter": "upper",
+				"#outputs":   map[string]interface{}{"rejected": "dl"},
+			}},
+			{Filter: "protodelim", Params: iofl.Params{"mode": "join"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestEach(t *testing.T) {
+	s := eachSet(t)
+	in := gzipRecords(t, "a%20b", "%zz", "c%21", "x%")
+	var dl bytes.Buffer
+	f, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(in)), iofl.Sink("dl", &dl))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := delimited([]byte("a b"), []byte("c!")); !bytes.Equal(out, want) {
+		t.Errorf("got %q, want %q", out, want)
+	}
+	if got := dl.String(); got != "%ZZX%" {
+		t.Errorf("got rejected %q, want %q", got, "%ZZX%")
+	}
+}
+
+func TestEachStats(t *testing.T) {
+	s := eachSet(t)
+	each := filters.Each(s)
+	in := gzipRecords(t, "a", "%", "b")
+	split, err := filters.Gzip.New(nil, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := each.New(iofl.Params{"chain": "decode", "policy": "skip"}, split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	want := filters.RecordStats{Records: 3, Rejected: 1}
+	if got := f.(filters.RecordCounter).Stats(); got != want {
+		t.Errorf("got %+v, want %+v", got, want)
+	}
+	report := f.(iofl.Reporter).Report()
+	if report["records"] != int64(3) || report["rejected"] != int64(1) {
+		t.Errorf("got report %v", report)
+	}
+	f.Close()
+}
+
+func TestEachPolicy(t *testing.T) {
+	s := eachSet(t)
+	each := filters.Each(s)
+
+	// A source that is not framed is one frame.
+	out, err := readFilter(t, each, iofl.Params{"chain": "upper"}, []byte("abc"))
+	if err != nil || string(out) != "ABC" {
+		t.Errorf("unframed: got %q, %v", out, err)
+	}
+
+	// By default, a failed frame is an error.
+	_, err = readFilter(t, each, iofl.Params{"chain": "decode"}, []byte("%zz"))
+	var corrupt *iofl.CorruptError
+	if !errors.As(err, &corrupt) {
+		t.Errorf("got %v, want CorruptError", err)
+	}
+
+	for _, params := range []iofl.Params{
+		{},
+		{"chain": "decode", "policy": "retry"},
+	} {
+		if _, err := readFilter(t, each, params, nil); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+}
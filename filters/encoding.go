@@ -0,0 +1,293 @@
+package filters
+
+import (
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Base64 applies base64 encoding (RFC 4648) to data. Params:
+//
+//	mode:     "encode" (default) or "decode".
+//	alphabet: "std" (default) for the standard alphabet, or "url" for the
+//	          URL and filename safe alphabet.
+//	padding:  Whether encoded data is padded with "=" to a multiple of 4
+//	          bytes. Defaults to true.
+//
+// Decoding ignores line breaks, and returns an error if the data is malformed.
+// When used in a write chain, the filter applies the inverse of mode. The
+// filter honors the bufferSize param.
+var Base64 = iofl.FilterDef{
+	Name:         "base64",
+	New:          newEncodingFilter(base64Encoding),
+	NewWriter:    newEncodingWriter(base64Encoding),
+	Validate:     validateEncoding(base64Encoding),
+	Description:  "Applies or removes base64 encoding (RFC 4648).",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"encode\" or \"decode\".", Default: "encode"},
+		{Name: "alphabet", Type: iofl.ParamString, Description: "\"std\" or \"url\".", Default: "std"},
+		{Name: "padding", Type: iofl.ParamBool, Description: "Whether encoded data is padded.", Default: "true"},
+	},
+}
+
+// Base32 applies base32 encoding (RFC 4648) to data. Params:
+//
+//	mode:     "encode" (default) or "decode".
+//	alphabet: "std" (default) for the standard alphabet, or "hex" for the
+//	          extended hex alphabet.
+//	padding:  Whether encoded data is padded with "=" to a multiple of 8
+//	          bytes. Defaults to true.
+//
+// Decoding ignores line breaks, and returns an error if the data is malformed.
+// When used in a write chain, the filter applies the inverse of mode. The
+// filter honors the bufferSize param.
+var Base32 = iofl.FilterDef{
+	Name:         "base32",
+	New:          newEncodingFilter(base32Encoding),
+	NewWriter:    newEncodingWriter(base32Encoding),
+	Validate:     validateEncoding(base32Encoding),
+	Description:  "Applies or removes base32 encoding (RFC 4648).",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"encode\" or \"decode\".", Default: "encode"},
+		{Name: "alphabet", Type: iofl.ParamString, Description: "\"std\" or \"hex\".", Default: "std"},
+		{Name: "padding", Type: iofl.ParamBool, Description: "Whether encoded data is padded.", Default: "true"},
+	},
+}
+
+// Hex applies hexadecimal encoding to data. Params:
+//
+//	mode: "encode" (default) or "decode".
+//
+// Encoding produces lowercase digits. Decoding accepts either case, ignores
+// line breaks, and returns an error if the data is malformed. When used in a
+// write chain, the filter applies the inverse of mode. The filter honors the
+// bufferSize param.
+var Hex = iofl.FilterDef{
+	Name:         "hex",
+	New:          newEncodingFilter(hexEncoding),
+	NewWriter:    newEncodingWriter(hexEncoding),
+	Validate:     validateEncoding(hexEncoding),
+	Description:  "Applies or removes hexadecimal encoding.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"encode\" or \"decode\".", Default: "encode"},
+	},
+}
+
+// blockEncoding is an encoding that maps blocks of bytes to blocks of
+// characters, as implemented by base64.Encoding and base32.Encoding.
+type blockEncoding interface {
+	Encode(dst, src []byte)
+	EncodedLen(n int) int
+	Decode(dst, src []byte) (n int, err error)
+	DecodedLen(n int) int
+}
+
+// encodingFunc returns the blockEncoding configured by params, along with the
+// number of bytes in a block, and the number of characters that encode a
+// block.
+type encodingFunc func(params iofl.Params) (enc blockEncoding, in, out int, err error)
+
+// getPadding returns whether the padding param is true, defaulting to true.
+func getPadding(params iofl.Params) (bool, error) {
+	v, ok := params["padding"]
+	if !ok {
+		return true, nil
+	}
+	padding, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("padding: expected bool, got %T", v)
+	}
+	return padding, nil
+}
+
+func base64Encoding(params iofl.Params) (enc blockEncoding, in, out int, err error) {
+	var e *base64.Encoding
+	switch alphabet := params.GetString("alphabet"); alphabet {
+	case "", "std":
+		e = base64.StdEncoding
+	case "url":
+		e = base64.URLEncoding
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown alphabet %q", alphabet)
+	}
+	padding, err := getPadding(params)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if !padding {
+		e = e.WithPadding(base64.NoPadding)
+	}
+	return e, 3, 4, nil
+}
+
+func base32Encoding(params iofl.Params) (enc blockEncoding, in, out int, err error) {
+	var e *base32.Encoding
+	switch alphabet := params.GetString("alphabet"); alphabet {
+	case "", "std":
+		e = base32.StdEncoding
+	case "hex":
+		e = base32.HexEncoding
+	default:
+		return nil, 0, 0, fmt.Errorf("unknown alphabet %q", alphabet)
+	}
+	padding, err := getPadding(params)
+	if err != nil {
+		return nil, 0, 0, err
+	}
+	if !padding {
+		e = e.WithPadding(base32.NoPadding)
+	}
+	return e, 5, 8, nil
+}
+
+func hexEncoding(params iofl.Params) (enc blockEncoding, in, out int, err error) {
+	return hexBlockEncoding{}, 1, 2, nil
+}
+
+// hexBlockEncoding implements blockEncoding with the hex package.
+type hexBlockEncoding struct{}
+
+func (hexBlockEncoding) Encode(dst, src []byte)                    { hex.Encode(dst, src) }
+func (hexBlockEncoding) EncodedLen(n int) int                      { return hex.EncodedLen(n) }
+func (hexBlockEncoding) Decode(dst, src []byte) (n int, err error) { return hex.Decode(dst, src) }
+func (hexBlockEncoding) DecodedLen(n int) int                      { return hex.DecodedLen(n) }
+
+// encodingTransformer returns the transformer for the given mode, configured
+// by params.
+func encodingTransformer(get encodingFunc, params iofl.Params, mode string) (transformer, error) {
+	enc, in, out, err := get(params)
+	if err != nil {
+		return nil, err
+	}
+	if mode == "decode" {
+		return blockDecoder{enc: enc, out: out}, nil
+	}
+	return blockEncoder{enc: enc, in: in, out: out}, nil
+}
+
+func newEncodingFilter(get encodingFunc) iofl.NewFilter {
+	return func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+		if r == nil {
+			return nil, errNoSource
+		}
+		mode, err := getMode(params, "encode", "encode", "decode")
+		if err != nil {
+			return nil, err
+		}
+		t, err := encodingTransformer(get, params, mode)
+		if err != nil {
+			return nil, err
+		}
+		return newTransformFilter(r, t, bufferSize(params)), nil
+	}
+}
+
+// newEncodingWriter returns a constructor of writers that apply the inverse of
+// the mode parameter.
+func newEncodingWriter(get encodingFunc) iofl.NewWriteFilter {
+	return func(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+		mode, err := getMode(params, "encode", "encode", "decode")
+		if err != nil {
+			return nil, err
+		}
+		if mode == "encode" {
+			mode = "decode"
+		} else {
+			mode = "encode"
+		}
+		t, err := encodingTransformer(get, params, mode)
+		if err != nil {
+			return nil, err
+		}
+		return newTransformWriter(w, t, bufferSize(params)), nil
+	}
+}
+
+func validateEncoding(get encodingFunc) func(params iofl.Params) error {
+	return func(params iofl.Params) error {
+		if _, err := getMode(params, "encode", "encode", "decode"); err != nil {
+			return err
+		}
+		_, _, _, err := get(params)
+		return err
+	}
+}
+
+// blockEncoder encodes blocks of in bytes as out characters. A final partial
+// block is encoded at the end of the stream.
+type blockEncoder struct {
+	enc     blockEncoding
+	in, out int
+}
+
+func (e blockEncoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for {
+		rem := len(src) - nSrc
+		n := rem - rem%e.in
+		if max := (len(dst) - nDst) / e.out * e.in; n > max {
+			n = max
+		}
+		if n == 0 {
+			switch {
+			case rem == 0:
+				return nDst, nSrc, nil
+			case rem >= e.in:
+				return nDst, nSrc, errShortDst
+			case !atEOF:
+				return nDst, nSrc, errShortSrc
+			case e.enc.EncodedLen(rem) > len(dst)-nDst:
+				return nDst, nSrc, errShortDst
+			}
+			n = rem
+		}
+		e.enc.Encode(dst[nDst:], src[nSrc:nSrc+n])
+		nDst += e.enc.EncodedLen(n)
+		nSrc += n
+	}
+}
+
+// blockDecoder decodes blocks of out characters, ignoring line breaks. A
+// final partial block is decoded at the end of the stream.
+type blockDecoder struct {
+	enc blockEncoding
+	out int
+}
+
+func (d blockDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	var block [8]byte
+	for {
+		// Consume leading line breaks, then gather a block of characters.
+		for nSrc < len(src) && (src[nSrc] == '\r' || src[nSrc] == '\n') {
+			nSrc++
+		}
+		n, i := 0, nSrc
+		for ; i < len(src) && n < d.out; i++ {
+			if c := src[i]; c != '\r' && c != '\n' {
+				block[n] = c
+				n++
+			}
+		}
+		if n == 0 {
+			return nDst, i, nil
+		}
+		if n < d.out && !atEOF {
+			return nDst, nSrc, errShortSrc
+		}
+		if d.enc.DecodedLen(n) > len(dst)-nDst {
+			return nDst, nSrc, errShortDst
+		}
+		m, err := d.enc.Decode(dst[nDst:], block[:n])
+		if err != nil {
+			return nDst, nSrc, &iofl.CorruptError{Err: fmt.Errorf("invalid encoding %q", block[:n])}
+		}
+		nDst += m
+		nSrc = i
+	}
+}
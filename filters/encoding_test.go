@@ -0,0 +1,156 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// encodingTests pairs the params of an encoding filter with the equivalent
+// encoder of the standard library.
+var encodingTests = []struct {
+	def    iofl.FilterDef
+	params iofl.Params
+	encode func([]byte) string
+}{
+	{filters.Base64, iofl.Params{}, base64.StdEncoding.EncodeToString},
+	{filters.Base64, iofl.Params{"alphabet": "url"}, base64.URLEncoding.EncodeToString},
+	{filters.Base64, iofl.Params{"padding": false}, base64.RawStdEncoding.EncodeToString},
+	{filters.Base64, iofl.Params{"alphabet": "url", "padding": false}, base64.RawURLEncoding.EncodeToString},
+	{filters.Base32, iofl.Params{}, base32.StdEncoding.EncodeToString},
+	{filters.Base32, iofl.Params{"alphabet": "hex"}, base32.HexEncoding.EncodeToString},
+	{filters.Base32, iofl.Params{"padding": false}, base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString},
+	{filters.Hex, iofl.Params{}, hex.EncodeToString},
+}
+
+// withParams returns a copy of params with the given additional params.
+func withParams(params iofl.Params, kv ...interface{}) iofl.Params {
+	p := iofl.Params{}
+	for k, v := range params {
+		p[k] = v
+	}
+	for i := 0; i < len(kv); i += 2 {
+		p[kv[i].(string)] = kv[i+1]
+	}
+	return p
+}
+
+func TestEncodingRoundTrip(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, tt := range encodingTests {
+		for _, n := range []int{0, 1, 2, 3, 4, 5, 7, 8, 63, 1000} {
+			in := make([]byte, n)
+			rnd.Read(in)
+			want := tt.encode(in)
+			// A small buffer exercises blocks split across reads of the
+			// source.
+			params := withParams(tt.params, iofl.ParamBufferSize, 16)
+			f, err := tt.def.New(params, ioutil.NopCloser(iotest.OneByteReader(bytes.NewReader(in))))
+			if err != nil {
+				t.Fatal(err)
+			}
+			encoded, err := ioutil.ReadAll(f)
+			if err != nil {
+				t.Fatalf("%s %v: %v", tt.def.Name, tt.params, err)
+			}
+			if string(encoded) != want {
+				t.Errorf("%s %v %d bytes: got %q, want %q", tt.def.Name, tt.params, n, encoded, want)
+			}
+			decoded := mustRead(t, tt.def, withParams(params, "mode", "decode"), encoded)
+			if !bytes.Equal(decoded, in) {
+				t.Errorf("%s %v %d bytes: round trip does not match input", tt.def.Name, tt.params, n)
+			}
+		}
+	}
+}
+
+func TestEncodingLineBreaks(t *testing.T) {
+	in := []byte(strings.Repeat("line breaks are ignored ", 10))
+	for _, tt := range encodingTests {
+		encoded := tt.encode(in)
+		var b strings.Builder
+		for i := 0; i < len(encoded); i += 7 {
+			end := i + 7
+			if end > len(encoded) {
+				end = len(encoded)
+			}
+			b.WriteString(encoded[i:end])
+			b.WriteString("\r\n")
+		}
+		decoded := mustRead(t, tt.def, withParams(tt.params, "mode", "decode"), []byte("\n"+b.String()))
+		if !bytes.Equal(decoded, in) {
+			t.Errorf("%s %v: got %q", tt.def.Name, tt.params, decoded)
+		}
+	}
+}
+
+func TestEncodingCorrupt(t *testing.T) {
+	tests := []struct {
+		def iofl.FilterDef
+		in  string
+	}{
+		{filters.Base64, "ab!d"},
+		{filters.Base64, "a"},
+		{filters.Base64, "ab=d"},
+		{filters.Base32, "AAAAAAA1"},
+		{filters.Base32, "A"},
+		{filters.Hex, "0g"},
+		{filters.Hex, "abc"},
+	}
+	for _, tt := range tests {
+		_, err := readFilter(t, tt.def, iofl.Params{"mode": "decode"}, []byte(tt.in))
+		var corrupt *iofl.CorruptError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("%s %q: got %v, want CorruptError", tt.def.Name, tt.in, err)
+		}
+	}
+}
+
+func TestEncodingWriter(t *testing.T) {
+	in := []byte("written through the chain")
+	for _, tt := range encodingTests {
+		// A writer applies the inverse of mode.
+		decoded, err := writeFilter(t, tt.def, tt.params, []byte(tt.encode(in)))
+		if err != nil || !bytes.Equal(decoded, in) {
+			t.Errorf("%s %v: got %q, %v", tt.def.Name, tt.params, decoded, err)
+		}
+		encoded, err := writeFilter(t, tt.def, withParams(tt.params, "mode", "decode"), in)
+		if err != nil || string(encoded) != tt.encode(in) {
+			t.Errorf("%s %v decode: got %q, %v", tt.def.Name, tt.params, encoded, err)
+		}
+	}
+}
+
+func TestEncodingParams(t *testing.T) {
+	for _, tt := range []struct {
+		def    iofl.FilterDef
+		params iofl.Params
+	}{
+		{filters.Base64, iofl.Params{"mode": "encrypt"}},
+		{filters.Base64, iofl.Params{"alphabet": "hex"}},
+		{filters.Base64, iofl.Params{"padding": "no"}},
+		{filters.Base32, iofl.Params{"alphabet": "url"}},
+		{filters.Base32, iofl.Params{"padding": 0}},
+		{filters.Hex, iofl.Params{"mode": "upper"}},
+	} {
+		if err := tt.def.Validate(tt.params); err == nil {
+			t.Errorf("%s %v: expected error", tt.def.Name, tt.params)
+		}
+		if _, err := tt.def.New(tt.params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%s %v: expected error from New", tt.def.Name, tt.params)
+		}
+	}
+	if _, err := filters.Hex.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
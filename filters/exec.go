@@ -0,0 +1,343 @@
+package filters
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"sync"
+
+	"github.com/anaminus/iofl"
+)
+
+// Exec pipes the source through an external command, producing the output of
+// the command, for formats that have no Go implementation. Params:
+//
+//	args: A list of strings, the first of which is the command to run, and
+//	      the remaining are its arguments. The command is located as by
+//	      exec.LookPath. Required.
+//	env:  A map of names to values of environment variables, added to the
+//	      environment of the current process.
+//	dir:  The working directory of the command. Defaults to the working
+//	      directory of the current process.
+//
+// The params documented by SandboxParams limit the resources of the command.
+//
+// The source is written to the standard input of the command, and the standard
+// output of the command is produced. If there is no source, the standard input
+// is empty. The command is started on the first Read, with the context of the
+// chain, if any, as the filter implements iofl.ContextFilter; canceling the
+// context kills the command.
+//
+// Once the output ends, the command is waited on. If the command exits with a
+// non-zero status, the error wraps an *exec.ExitError, whose Stderr field
+// contains the end of the standard error of the command, and the last line of
+// the standard error is included in the message. An error reading the source
+// kills the command, and is returned instead. Closing the filter before the
+// output has ended kills the command.
+//
+// A configuration using Exec can run any command, so Exec is registered by
+// RegisterUnsafe rather than Register.
+var Exec = iofl.FilterDef{
+	Name:         "exec",
+	New:          newExec,
+	Validate:     validateExec,
+	Description:  "Pipes the source through an external command.",
+	StrictParams: true,
+	Params: append([]iofl.ParamDef{
+		{Name: "args", Type: iofl.ParamList, Required: true, Description: "The command to run, followed by its arguments. Required."},
+		{Name: "env", Type: iofl.ParamMap, Description: "A map of environment variables added to those of the current process."},
+		{Name: "dir", Type: iofl.ParamString, Description: "The working directory of the command.", Default: "the current directory"},
+	}, SandboxParams...),
+}
+
+// maxStderr is the number of bytes at the end of the standard error of a
+// command retained for reporting.
+const maxStderr = 4096
+
+// execFilter implements the Exec filter.
+type execFilter struct {
+	ctx     context.Context
+	path    string
+	args    []string
+	env     []string
+	dir     string
+	sandbox Sandbox
+	src     io.ReadCloser
+	stderr  tailBuffer
+	err     error
+
+	mu      sync.Mutex
+	cmd     *exec.Cmd
+	stdout  io.ReadCloser
+	copyErr error
+	closed  bool
+	// copied is closed once the source has been copied to the command.
+	copied chan struct{}
+
+	waitOnce sync.Once
+	wait     func() error
+	waitErr  error
+}
+
+func parseExec(params iofl.Params) (*execFilter, error) {
+	f := &execFilter{
+		dir: params.GetString("dir"),
+	}
+	switch args := params["args"].(type) {
+	case nil:
+		return nil, errors.New("args required")
+	case []interface{}:
+		if len(args) == 0 {
+			return nil, errors.New("args required")
+		}
+		for i, arg := range args {
+			s, ok := arg.(string)
+			if !ok {
+				return nil, fmt.Errorf("args[%d]: expected string, got %T", i, arg)
+			}
+			f.args = append(f.args, s)
+		}
+	default:
+		return nil, fmt.Errorf("args: expected list, got %T", args)
+	}
+	if f.args[0] == "" {
+		return nil, errors.New("args[0]: command required")
+	}
+	switch env := params["env"].(type) {
+	case nil:
+	case map[string]interface{}:
+		for name, value := range env {
+			s, ok := value.(string)
+			if !ok {
+				return nil, fmt.Errorf("env: %s: expected string, got %T", name, value)
+			}
+			f.env = append(f.env, name+"="+s)
+		}
+		sort.Strings(f.env)
+	default:
+		return nil, fmt.Errorf("env: expected map, got %T", env)
+	}
+	var err error
+	if f.sandbox, err = ParseSandbox(params); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+func validateExec(params iofl.Params) error {
+	_, err := parseExec(params)
+	return err
+}
+
+func newExec(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	filter, err := parseExec(params)
+	if err != nil {
+		return nil, err
+	}
+	if filter.path, err = exec.LookPath(filter.args[0]); err != nil {
+		return nil, err
+	}
+	filter.src = r
+	return filter, nil
+}
+
+// Source implements iofl.Filter.
+func (f *execFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// SetContext implements iofl.ContextFilter. The command is run with ctx.
+func (f *execFilter) SetContext(ctx context.Context) {
+	f.ctx = ctx
+}
+
+// start starts the command. f.mu must be held.
+func (f *execFilter) start() error {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	cmd := exec.CommandContext(ctx, f.path, f.args[1:]...)
+	cmd.Args[0] = f.args[0]
+	cmd.Dir = f.dir
+	if f.env != nil {
+		cmd.Env = append(os.Environ(), f.env...)
+	}
+	cmd.Stderr = &f.stderr
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	var stdin io.WriteCloser
+	if f.src != nil {
+		if stdin, err = cmd.StdinPipe(); err != nil {
+			stdout.Close()
+			return err
+		}
+	}
+	if f.wait, err = f.sandbox.Start(cmd); err != nil {
+		return fmt.Errorf("%s: %w", f.args[0], err)
+	}
+	f.cmd = cmd
+	f.stdout = stdout
+	if stdin != nil {
+		f.copied = make(chan struct{})
+		go f.copy(stdin)
+	}
+	return nil
+}
+
+// copy writes the source to stdin. An error reading the source kills the
+// command. An error writing to stdin indicates that the command has stopped
+// reading, and is left to be reported by the exit status of the command.
+func (f *execFilter) copy(stdin io.WriteCloser) {
+	defer close(f.copied)
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := f.src.Read(buf)
+		if n > 0 {
+			if _, werr := stdin.Write(buf[:n]); werr != nil {
+				stdin.Close()
+				return
+			}
+		}
+		if err != nil {
+			stdin.Close()
+			if err != io.EOF {
+				f.mu.Lock()
+				f.copyErr = err
+				f.mu.Unlock()
+				f.cmd.Process.Kill()
+			}
+			return
+		}
+	}
+}
+
+// finish waits for the command to exit, returning an error describing how the
+// command failed, if at all.
+func (f *execFilter) finish() error {
+	f.waitOnce.Do(func() {
+		f.waitErr = f.wait()
+	})
+	f.mu.Lock()
+	copyErr := f.copyErr
+	f.mu.Unlock()
+	if copyErr != nil {
+		return copyErr
+	}
+	if f.waitErr == nil {
+		return nil
+	}
+	var exit *exec.ExitError
+	if errors.As(f.waitErr, &exit) {
+		exit.Stderr = f.stderr.Bytes()
+		if line := lastLine(exit.Stderr); line != "" {
+			return fmt.Errorf("%s: %w: %s", f.args[0], f.waitErr, line)
+		}
+	}
+	return fmt.Errorf("%s: %w", f.args[0], f.waitErr)
+}
+
+// Read implements io.Reader.
+func (f *execFilter) Read(p []byte) (n int, err error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return 0, iofl.Closed
+	}
+	if f.cmd == nil {
+		if f.err = f.start(); f.err != nil {
+			f.mu.Unlock()
+			return 0, f.err
+		}
+	}
+	stdout := f.stdout
+	f.mu.Unlock()
+	n, err = stdout.Read(p)
+	if err == io.EOF {
+		if werr := f.finish(); werr != nil {
+			err = werr
+		}
+	}
+	if err != nil {
+		f.mu.Lock()
+		if f.closed {
+			err = iofl.Closed
+		}
+		f.mu.Unlock()
+		f.err = err
+	}
+	return n, err
+}
+
+// Close implements io.Closer. If the command has not exited, it is killed.
+// The source, if any, is closed, which interrupts a pending Read of the source
+// by the copy to the command, and Close waits for the copy to stop.
+func (f *execFilter) Close() error {
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		return iofl.Closed
+	}
+	f.closed = true
+	cmd := f.cmd
+	f.mu.Unlock()
+	if cmd != nil {
+		f.waitOnce.Do(func() {
+			cmd.Process.Kill()
+			f.waitErr = f.wait()
+		})
+	}
+	// Killing the command does not interrupt a pending Read of the source, so
+	// the source is closed before waiting for the copy to stop.
+	var err error
+	if f.src != nil {
+		err = f.src.Close()
+	}
+	if f.copied != nil {
+		<-f.copied
+	}
+	return err
+}
+
+// tailBuffer retains the last maxStderr bytes written to it. It is safe for
+// concurrent use.
+type tailBuffer struct {
+	mu  sync.Mutex
+	buf []byte
+}
+
+func (b *tailBuffer) Write(p []byte) (n int, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf = append(b.buf, p...)
+	if len(b.buf) > maxStderr {
+		b.buf = append(b.buf[:0], b.buf[len(b.buf)-maxStderr:]...)
+	}
+	return len(p), nil
+}
+
+// Bytes returns a copy of the retained bytes.
+func (b *tailBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf...)
+}
+
+// lastLine returns the last non-empty line of b.
+func lastLine(b []byte) string {
+	b = bytes.TrimSpace(b)
+	if i := bytes.LastIndexByte(b, '\n'); i >= 0 {
+		b = bytes.TrimSpace(b[i+1:])
+	}
+	return string(b)
+}
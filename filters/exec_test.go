@@ -0,0 +1,260 @@
+package filters_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// errBoom is returned by a failing source.
+var errBoom = errors.New("boom")
+
+// requireCommands skips the test if any of the given commands are not
+// available.
+func requireCommands(t *testing.T, names ...string) {
+	t.Helper()
+	for _, name := range names {
+		if _, err := exec.LookPath(name); err != nil {
+			t.Skipf("%s not available", name)
+		}
+	}
+}
+
+// args returns a list of strings as an args param.
+func args(s ...string) []interface{} {
+	list := make([]interface{}, len(s))
+	for i, v := range s {
+		list[i] = v
+	}
+	return list
+}
+
+func TestExec(t *testing.T) {
+	requireCommands(t, "sh", "tr", "cat", "echo", "pwd")
+	dir, err := filepath.EvalSymlinks(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	large := bytes.Repeat([]byte("0123456789"), 1<<16)
+	for _, test := range []struct {
+		name   string
+		params iofl.Params
+		src    io.ReadCloser
+		out    string
+	}{
+		{"Pipe", iofl.Params{"args": args("tr", "a-z", "A-Z")}, ioutil.NopCloser(strings.NewReader("hello")), "HELLO"},
+		{"Large", iofl.Params{"args": args("cat")}, ioutil.NopCloser(bytes.NewReader(large)), string(large)},
+		{"EmptySource", iofl.Params{"args": args("cat")}, ioutil.NopCloser(strings.NewReader("")), ""},
+		{"NoSource", iofl.Params{"args": args("echo", "hi")}, nil, "hi\n"},
+		{"Env", iofl.Params{"args": args("sh", "-c", `printf %s "$IOFL_TEST"`), "env": map[string]interface{}{"IOFL_TEST": "value"}}, nil, "value"},
+		{"Dir", iofl.Params{"args": args("pwd"), "dir": dir}, nil, dir + "\n"},
+		{"IgnoredInput", iofl.Params{"args": args("echo", "done")}, ioutil.NopCloser(bytes.NewReader(large)), "done\n"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := readFilterFrom(t, filters.Exec, test.params, test.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(out) != test.out {
+				t.Errorf("got %q, want %q", truncate(out), truncate([]byte(test.out)))
+			}
+		})
+	}
+}
+
+// truncate shortens b for display.
+func truncate(b []byte) string {
+	if len(b) > 32 {
+		return string(b[:32]) + "..."
+	}
+	return string(b)
+}
+
+func TestExecExitError(t *testing.T) {
+	requireCommands(t, "sh")
+	out, err := readFilterFrom(t, filters.Exec, iofl.Params{
+		"args": args("sh", "-c", "echo partial; echo first >&2; echo last >&2; exit 3"),
+	}, nil)
+	if string(out) != "partial\n" {
+		t.Errorf("got output %q", out)
+	}
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		t.Fatalf("got error %v, want *exec.ExitError", err)
+	}
+	if exit.ExitCode() != 3 {
+		t.Errorf("got exit code %d, want 3", exit.ExitCode())
+	}
+	if string(exit.Stderr) != "first\nlast\n" {
+		t.Errorf("got stderr %q", exit.Stderr)
+	}
+	if msg := err.Error(); !strings.HasPrefix(msg, "sh: ") || !strings.HasSuffix(msg, ": last") {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestExecStderrLimit(t *testing.T) {
+	requireCommands(t, "sh")
+	_, err := readFilterFrom(t, filters.Exec, iofl.Params{
+		"args": args("sh", "-c", `i=0; while [ $i -lt 1000 ]; do echo "line $i" >&2; i=$((i+1)); done; exit 1`),
+	}, nil)
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		t.Fatalf("got error %v, want *exec.ExitError", err)
+	}
+	if len(exit.Stderr) != 4096 || !bytes.HasSuffix(exit.Stderr, []byte("line 999\n")) {
+		t.Errorf("got %d bytes of stderr, ending %q", len(exit.Stderr), exit.Stderr[len(exit.Stderr)-10:])
+	}
+}
+
+func TestExecSourceError(t *testing.T) {
+	requireCommands(t, "cat")
+	src := &closeRecorder{Reader: io.MultiReader(strings.NewReader("abc"), iotest.ErrReader(errBoom))}
+	_, err := readFilterFrom(t, filters.Exec, iofl.Params{"args": args("cat")}, src)
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got error %v, want errBoom", err)
+	}
+	if !src.closed {
+		t.Error("source not closed")
+	}
+}
+
+func TestExecClose(t *testing.T) {
+	requireCommands(t, "sleep", "cat", "true")
+
+	t.Run("PendingRead", func(t *testing.T) {
+		f, err := filters.Exec.New(iofl.Params{"args": args("sleep", "10")}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			_, err := f.Read(make([]byte, 8))
+			done <- err
+		}()
+		time.Sleep(50 * time.Millisecond)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			if !errors.Is(err, iofl.Closed) {
+				t.Errorf("got error %v, want Closed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not kill the command")
+		}
+		if err := f.Close(); !errors.Is(err, iofl.Closed) {
+			t.Errorf("second close: got %v, want Closed", err)
+		}
+	})
+
+	t.Run("IdleSource", func(t *testing.T) {
+		// Closing the source interrupts the copy, which would otherwise wait
+		// on the source indefinitely.
+		for _, argv := range [][]string{{"cat"}, {"true"}} {
+			pr, pw := io.Pipe()
+			defer pw.Close()
+			src := &closeRecorder{Reader: pr}
+			f, err := filters.Exec.New(iofl.Params{"args": args(argv...)}, src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan struct{})
+			go func() {
+				defer close(done)
+				io.Copy(ioutil.Discard, f)
+			}()
+			time.Sleep(50 * time.Millisecond)
+			closed := make(chan error)
+			go func() { closed <- f.Close() }()
+			select {
+			case err := <-closed:
+				if err != nil {
+					t.Fatalf("%s: %v", argv[0], err)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatalf("%s: Close did not return with an idle source", argv[0])
+			}
+			<-done
+			if !src.closed {
+				t.Errorf("%s: source not closed", argv[0])
+			}
+		}
+	})
+
+	t.Run("BeforeRead", func(t *testing.T) {
+		src := &closeRecorder{Reader: strings.NewReader("abc")}
+		f, err := filters.Exec.New(iofl.Params{"args": args("cat")}, src)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if !src.closed {
+			t.Error("source not closed")
+		}
+		if _, err := f.Read(make([]byte, 1)); !errors.Is(err, iofl.Closed) {
+			t.Errorf("got error %v, want Closed", err)
+		}
+	})
+}
+
+func TestExecContext(t *testing.T) {
+	requireCommands(t, "sleep")
+	f, err := filters.Exec.New(iofl.Params{"args": args("sleep", "10")}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	f.(iofl.ContextFilter).SetContext(ctx)
+	time.AfterFunc(50*time.Millisecond, cancel)
+	start := time.Now()
+	if _, err := f.Read(make([]byte, 8)); err == nil {
+		t.Error("expected error after cancel")
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("command ran for %v", d)
+	}
+}
+
+func TestExecParams(t *testing.T) {
+	for _, test := range []struct {
+		params iofl.Params
+		err    string
+	}{
+		{iofl.Params{}, "args required"},
+		{iofl.Params{"args": args()}, "args required"},
+		{iofl.Params{"args": "cat"}, "expected list"},
+		{iofl.Params{"args": []interface{}{"cat", 1}}, "args[1]: expected string"},
+		{iofl.Params{"args": args("")}, "command required"},
+		{iofl.Params{"args": args("cat"), "env": "A=b"}, "env: expected map"},
+		{iofl.Params{"args": args("cat"), "env": map[string]interface{}{"A": 1}}, "env: A: expected string"},
+		{iofl.Params{"args": args("cat"), "env": map[string]interface{}{"A": "b"}, "dir": "/"}, ""},
+	} {
+		err := filters.Exec.Validate(test.params)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.params, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got error %v, want %q", test.params, err, test.err)
+		}
+	}
+	if _, err := filters.Exec.New(iofl.Params{"args": args("iofl-no-such-command")}, nil); !errors.Is(err, exec.ErrNotFound) {
+		t.Errorf("got error %v, want not found", err)
+	}
+}
@@ -0,0 +1,221 @@
+package filters
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anaminus/iofl"
+)
+
+// Fallback returns the definition of a filter that decodes its source with the
+// first of several chains, resolved from s, that succeeds, for ingesting
+// content whose encoding is mislabeled. Params:
+//
+//	chains: A list of chain names, tried in order. An empty name passes the
+//	        content through unchanged, which is useful as a last resort.
+//	prefix: The number of bytes of the source that are buffered, allowing a
+//	        failed chain to be rewound. Defaults to 64KiB.
+//	check:  The number of bytes a chain must produce without error to be
+//	        selected. Defaults to 512.
+//
+// A chain fails if it returns an error before producing check bytes, or before
+// reaching the end of its output, provided that it has not read beyond the
+// buffered prefix. The source is then rewound, and the next chain is tried. A
+// chain that fails after reading beyond the prefix cannot be rewound, and its
+// error is returned. A temporary error, such as a failure of the source, is
+// not attributed to the chain, and is returned without trying further chains.
+// The filter reports the selected chain through the Router interface. If every
+// chain fails, an error describing each failure is returned by the first Read.
+func Fallback(s *iofl.ChainSet) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:         "fallback",
+		Description:  "Decodes content with the first of several chains that succeeds.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "chains", Type: iofl.ParamList, Required: true, Description: "A list of chain names, tried in order. An empty name passes content through unchanged.", Chain: true},
+			{Name: "prefix", Type: iofl.ParamInt, Description: "The number of bytes of the source buffered for rewinding.", Default: "64KiB"},
+			{Name: "check", Type: iofl.ParamInt, Description: "The number of bytes a chain must produce without error to be selected.", Default: "512"},
+		},
+		New: func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+			if r == nil {
+				return nil, errNoSource
+			}
+			list, ok := params["chains"].([]interface{})
+			if !ok || len(list) == 0 {
+				return nil, errors.New("chains required")
+			}
+			filter := &fallbackFilter{set: s, src: r}
+			for _, v := range list {
+				name, ok := v.(string)
+				if !ok {
+					return nil, fmt.Errorf("chains: expected string, got %T", v)
+				}
+				filter.chains = append(filter.chains, name)
+			}
+			if filter.prefix = params.GetInt("prefix"); filter.prefix <= 0 {
+				filter.prefix = 64 << 10
+			}
+			if filter.check = params.GetInt("check"); filter.check <= 0 {
+				filter.check = 512
+			}
+			return filter, nil
+		},
+	}
+}
+
+// fallbackFilter implements the Fallback filter.
+type fallbackFilter struct {
+	set    *iofl.ChainSet
+	src    io.ReadCloser
+	chains []string
+	prefix int
+	check  int
+
+	route     string
+	r         io.ReadCloser
+	buf       []byte
+	committed bool
+	err       error
+	closed    bool
+}
+
+// replaySource reads a buffered prefix followed by the remainder of a source,
+// counting the bytes read. It closes the source only once a chain has been
+// selected.
+type replaySource struct {
+	f *fallbackFilter
+	r io.Reader
+	n int
+}
+
+func (r *replaySource) Read(p []byte) (n int, err error) {
+	n, err = r.r.Read(p)
+	r.n += n
+	return n, err
+}
+
+func (r *replaySource) Close() error {
+	if r.f.committed {
+		return r.f.src.Close()
+	}
+	return nil
+}
+
+// try resolves chain over the prefix and source, and reads until check bytes
+// are produced. Returns whether the chain may be rewound after failing.
+func (f *fallbackFilter) try(chain string, prefix []byte) (rewind bool, err error) {
+	src := &replaySource{f: f, r: io.MultiReader(bytes.NewReader(prefix), f.src)}
+	// Discard any output of a previously failed chain.
+	f.buf = nil
+	if chain == "" {
+		f.r = src
+		return false, nil
+	}
+	r, err := f.set.Resolve(chain, src)
+	if err != nil {
+		return true, err
+	}
+	f.buf = make([]byte, f.check)
+	n, err := io.ReadFull(r, f.buf)
+	f.buf = f.buf[:n]
+	switch err {
+	case nil, io.EOF, io.ErrUnexpectedEOF:
+		f.r = r
+		return false, nil
+	}
+	r.Close()
+	return src.n <= len(prefix), err
+}
+
+// resolve selects a chain, if this has not already been done.
+func (f *fallbackFilter) resolve() {
+	if f.r != nil || f.err != nil {
+		return
+	}
+	prefix, _, err := readPrefix(f.src, f.prefix)
+	if err != nil {
+		f.err = err
+		return
+	}
+	var errs []string
+	for _, chain := range f.chains {
+		rewind, err := f.try(chain, prefix)
+		if err == nil {
+			f.route = chain
+			f.committed = true
+			return
+		}
+		errs = append(errs, fmt.Sprintf("%q: %s", chain, err))
+		if !rewind || iofl.IsTemporary(err) {
+			f.route = chain
+			f.committed = true
+			f.err = fmt.Errorf("%q: %w", chain, err)
+			return
+		}
+	}
+	f.err = fmt.Errorf("all chains failed: %s", strings.Join(errs, "; "))
+}
+
+// Route implements Router, returning the name of the selected chain.
+func (f *fallbackFilter) Route() string {
+	return f.route
+}
+
+// Source implements iofl.Filter.
+func (f *fallbackFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *fallbackFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	f.resolve()
+	if f.err != nil {
+		return 0, f.err
+	}
+	if len(f.buf) > 0 {
+		n = copy(p, f.buf)
+		f.buf = f.buf[n:]
+		return n, nil
+	}
+	return f.r.Read(p)
+}
+
+// Close implements io.Closer, closing the selected chain and the source.
+func (f *fallbackFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	f.committed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *fallbackFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.route = ""
+	f.r = nil
+	f.buf = nil
+	f.committed = false
+	f.err = nil
+	f.closed = false
+	return nil
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *fallbackFilter) MemoryUsage() int {
+	n := cap(f.buf)
+	if f.r != nil {
+		n += iofl.MemoryUsage(f.r)
+	}
+	return n
+}
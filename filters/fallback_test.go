@@ -0,0 +1,175 @@
+package filters_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// fallbackSet returns a ChainSet with the built-in filters registered and a
+// fallback chain with the given params.
+func fallbackSet(t *testing.T, params iofl.Params) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"fallback": {{Filter: "fallback", Params: params}},
+		"gz":       {{Filter: "gzip"}},
+		"b64":      {{Filter: "base64", Params: iofl.Params{"mode": "decode"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// tempError is a temporary error.
+type tempError struct{}
+
+func (tempError) Error() string   { return "temporary failure" }
+func (tempError) Temporary() bool { return true }
+
+type errReader struct{ err error }
+
+func (r errReader) Read(p []byte) (int, error) { return 0, r.err }
+
+// closeCounter counts calls to Close.
+type closeCounter struct {
+	io.Reader
+	n int
+}
+
+func (c *closeCounter) Close() error {
+	c.n++
+	return nil
+}
+
+// fallbackRead resolves the fallback chain of s over src, and returns its
+// output and selected route.
+func fallbackRead(t *testing.T, s *iofl.ChainSet, src io.ReadCloser) (out []byte, route string, err error) {
+	t.Helper()
+	f, err := s.Resolve("fallback", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err = ioutil.ReadAll(f)
+	iofl.Apply(f, func(r io.ReadCloser) error {
+		if v, ok := r.(filters.Router); ok {
+			route = v.Route()
+		}
+		return nil
+	})
+	return out, route, err
+}
+
+func TestFallback(t *testing.T) {
+	content := strings.Repeat("some content, ", 100)
+	var gz bytes.Buffer
+	zw := gzip.NewWriter(&gz)
+	zw.Write([]byte(content))
+	zw.Close()
+	b64 := base64.StdEncoding.EncodeToString([]byte(content))
+
+	s := fallbackSet(t, iofl.Params{"chains": []interface{}{"gz", "b64", ""}, "check": 16})
+	tests := []struct {
+		name  string
+		in    []byte
+		route string
+	}{
+		{"gzip", gz.Bytes(), "gz"},
+		{"base64", []byte(b64), "b64"},
+		{"plain", []byte(content), ""},
+		{"short", []byte("x"), ""},
+	}
+	for _, tt := range tests {
+		src := &closeCounter{Reader: bytes.NewReader(tt.in)}
+		out, route, err := fallbackRead(t, s, src)
+		if err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		want := content
+		if tt.name == "short" {
+			want = "x"
+		}
+		if string(out) != want {
+			t.Errorf("%s: got %q", tt.name, out)
+		}
+		if route != tt.route {
+			t.Errorf("%s: got route %q, want %q", tt.name, route, tt.route)
+		}
+		if src.n != 1 {
+			t.Errorf("%s: source closed %d times", tt.name, src.n)
+		}
+	}
+}
+
+func TestFallbackFailure(t *testing.T) {
+	s := fallbackSet(t, iofl.Params{"chains": []interface{}{"gz", "b64"}})
+	_, _, err := fallbackRead(t, s, ioutil.NopCloser(strings.NewReader("not, encoded")))
+	if err == nil || !strings.Contains(err.Error(), "all chains failed") {
+		t.Errorf("got %v, want failure of all chains", err)
+	}
+	if err != nil && (!strings.Contains(err.Error(), `"gz"`) || !strings.Contains(err.Error(), `"b64"`)) {
+		t.Errorf("error does not describe each chain: %v", err)
+	}
+}
+
+func TestFallbackBeyondPrefix(t *testing.T) {
+	// The base64 chain reads beyond the prefix before failing, and cannot be
+	// rewound.
+	in := strings.Repeat("QUJD", 100) + "!!!!"
+	s := fallbackSet(t, iofl.Params{"chains": []interface{}{"b64", ""}, "prefix": 8, "check": 1000})
+	_, route, err := fallbackRead(t, s, ioutil.NopCloser(strings.NewReader(in)))
+	if err == nil || !strings.HasPrefix(err.Error(), `"b64"`) {
+		t.Errorf("got %v, want error of b64 chain", err)
+	}
+	if route != "b64" {
+		t.Errorf("got route %q, want b64", route)
+	}
+}
+
+func TestFallbackTemporary(t *testing.T) {
+	src := io.MultiReader(strings.NewReader("QUJD"), errReader{tempError{}})
+	s := fallbackSet(t, iofl.Params{"chains": []interface{}{"b64", ""}, "prefix": 2})
+	_, route, err := fallbackRead(t, s, ioutil.NopCloser(src))
+	if !iofl.IsTemporary(err) {
+		t.Errorf("got %v, want temporary error", err)
+	}
+	if route != "b64" {
+		t.Errorf("got route %q, want b64", route)
+	}
+
+	// A failure while buffering the prefix is returned.
+	src = io.MultiReader(strings.NewReader("QUJD"), errReader{tempError{}})
+	s = fallbackSet(t, iofl.Params{"chains": []interface{}{"b64", ""}, "prefix": 100})
+	if _, _, err := fallbackRead(t, s, ioutil.NopCloser(src)); !iofl.IsTemporary(err) {
+		t.Errorf("prefix: got %v, want temporary error", err)
+	}
+}
+
+func TestFallbackParams(t *testing.T) {
+	def := filters.Fallback(iofl.NewChainSet())
+	for _, params := range []iofl.Params{
+		nil,
+		{"chains": []interface{}{}},
+		{"chains": []interface{}{1}},
+	} {
+		if _, err := def.New(params, ioutil.NopCloser(strings.NewReader(""))); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := def.New(iofl.Params{"chains": []interface{}{""}}, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,186 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/anaminus/iofl"
+)
+
+// File is a source filter that produces the content of a file, allowing a
+// chain to describe an entire input pipeline, from the location of the content
+// to its decoded form. Unlike other source filters, File accepts a source, but
+// ignores it, so that it may be used as the first link of a chain resolved
+// with any source. An ignored source is closed with the filter. Params:
+//
+//	path: The path of the file. Required.
+//	dir:  A directory to which path is relative. If set, path must be relative,
+//	      and must not refer to a location outside of dir, including through
+//	      symbolic links.
+//
+// The path may refer to variables, such as "${input}", which are expanded
+// when the chain is resolved with ResolveVars.
+//
+// The file is opened when the filter is constructed. The filter implements
+// io.Seeker, so a chain consisting of only a File link may be resolved with
+// ResolveSeeker.
+//
+// A dir given by a configuration does not confine that configuration, which
+// may name any dir, so File is registered by RegisterUnsafe rather than
+// Register. FileIn returns a variant confined to a directory chosen by the
+// program instead.
+var File = iofl.FilterDef{
+	Name:         "file",
+	New:          newFile,
+	Validate:     validateFile,
+	Description:  "Produces the content of a file, ignoring the source.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "path", Type: iofl.ParamString, Required: true, Description: "The path of the file. Required."},
+		{Name: "dir", Type: iofl.ParamString, Description: "A directory to which path is relative, and which path must not leave."},
+	},
+}
+
+// FileIn returns the definition of a File filter confined to dir, which is
+// chosen by the program rather than by the configuration. The filter has the
+// same name as File, and accepts the path param, which must be relative to dir
+// and must not refer to a location outside of dir, including through symbolic
+// links. The dir param is not accepted. Since a configuration cannot use the
+// filter to read files outside of dir, the filter may be registered with a
+// ChainSet whose configurations are not trusted.
+func FileIn(dir string) iofl.FilterDef {
+	def := File
+	def.Description = "Produces the content of a file within a fixed directory, ignoring the source."
+	def.Params = []iofl.ParamDef{
+		{Name: "path", Type: iofl.ParamString, Required: true, Description: "The path of the file, relative to the directory. Required."},
+	}
+	confine := func(params iofl.Params) (iofl.Params, error) {
+		if _, ok := params["dir"]; ok {
+			return nil, errors.New("dir not accepted")
+		}
+		p := make(iofl.Params, len(params)+1)
+		for k, v := range params {
+			p[k] = v
+		}
+		p["dir"] = dir
+		return p, nil
+	}
+	def.New = func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+		params, err := confine(params)
+		if err != nil {
+			return nil, err
+		}
+		return newFile(params, r)
+	}
+	def.Validate = func(params iofl.Params) error {
+		params, err := confine(params)
+		if err != nil {
+			return err
+		}
+		return validateFile(params)
+	}
+	return def
+}
+
+// filePath returns the path of the file named by params.
+func filePath(params iofl.Params) (string, error) {
+	path := params.GetString("path")
+	if path == "" {
+		return "", errors.New("path required")
+	}
+	dir := params.GetString("dir")
+	if dir == "" {
+		return path, nil
+	}
+	if filepath.IsAbs(path) {
+		return "", fmt.Errorf("path %q must be relative to dir", path)
+	}
+	rel := filepath.Clean(path)
+	if rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("path %q is outside of dir", path)
+	}
+	return filepath.Join(dir, rel), nil
+}
+
+func validateFile(params iofl.Params) error {
+	if strings.Contains(params.GetString("path"), "$") {
+		// Checked once variables have been expanded.
+		return nil
+	}
+	_, err := filePath(params)
+	return err
+}
+
+// within returns whether path, once symbolic links are evaluated, is within
+// dir.
+func within(dir, path string) (bool, error) {
+	root, err := filepath.EvalSymlinks(dir)
+	if err != nil {
+		return false, err
+	}
+	resolved, err := filepath.EvalSymlinks(path)
+	if err != nil {
+		return false, err
+	}
+	rel, err := filepath.Rel(root, resolved)
+	if err != nil {
+		return false, nil
+	}
+	return rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)), nil
+}
+
+func newFile(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	path, err := filePath(params)
+	if err != nil {
+		return nil, err
+	}
+	if dir := params.GetString("dir"); dir != "" {
+		ok, err := within(dir, path)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			return nil, fmt.Errorf("path %q is outside of dir", params.GetString("path"))
+		}
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	return &fileFilter{file: file, src: r}, nil
+}
+
+// fileFilter implements the File filter.
+type fileFilter struct {
+	file *os.File
+	src  io.ReadCloser
+}
+
+// Source implements iofl.Filter. Returns nil, since the source is ignored.
+func (f *fileFilter) Source() io.ReadCloser {
+	return nil
+}
+
+func (f *fileFilter) Read(p []byte) (n int, err error) {
+	return f.file.Read(p)
+}
+
+// Seek implements io.Seeker.
+func (f *fileFilter) Seek(offset int64, whence int) (int64, error) {
+	return f.file.Seek(offset, whence)
+}
+
+// Close closes the file, and the ignored source, if any.
+func (f *fileFilter) Close() error {
+	err := f.file.Close()
+	if f.src != nil {
+		if serr := f.src.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
@@ -0,0 +1,199 @@
+package filters_test
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// closeRecorder is a source that records whether it is closed, closing the
+// underlying reader if it is an io.Closer.
+type closeRecorder struct {
+	io.Reader
+	closed bool
+}
+
+func (r *closeRecorder) Close() error {
+	r.closed = true
+	if c, ok := r.Reader.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// fileDir creates a temporary directory containing the file "a/b.txt".
+func fileDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	if err := os.Mkdir(filepath.Join(dir, "a"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "a", "b.txt"), []byte("content"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return dir
+}
+
+func TestFile(t *testing.T) {
+	dir := fileDir(t)
+	src := &closeRecorder{Reader: strings.NewReader("ignored")}
+	out, err := readFilterFrom(t, filters.File, iofl.Params{"path": filepath.Join(dir, "a", "b.txt")}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "content" {
+		t.Errorf("got %q, want %q", out, "content")
+	}
+	if !src.closed {
+		t.Error("ignored source not closed")
+	}
+
+	_, err = readFilterFrom(t, filters.File, iofl.Params{"path": filepath.Join(dir, "missing")}, nil)
+	if !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("got error %v, want not exist", err)
+	}
+}
+
+func TestFileDir(t *testing.T) {
+	dir := fileDir(t)
+	for _, test := range []struct {
+		path string
+		err  string
+	}{
+		{"a/b.txt", ""},
+		{"./a/../a/b.txt", ""},
+		{"", "path required"},
+		{"/a/b.txt", "must be relative"},
+		{"..", "outside of dir"},
+		{"../a/b.txt", "outside of dir"},
+		{"a/../../b.txt", "outside of dir"},
+	} {
+		params := iofl.Params{"path": filepath.FromSlash(test.path), "dir": dir}
+		out, err := readFilterFrom(t, filters.File, params, nil)
+		if test.err == "" {
+			if err != nil || string(out) != "content" {
+				t.Errorf("%q: got %q, %v", test.path, out, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, want %q", test.path, err, test.err)
+		}
+		if verr := filters.File.Validate(params); verr == nil || verr.Error() != err.Error() {
+			t.Errorf("%q: validate: got error %v, want %v", test.path, verr, err)
+		}
+	}
+	// Paths with variables are checked once expanded.
+	if err := filters.File.Validate(iofl.Params{"path": "../${name}", "dir": dir}); err != nil {
+		t.Errorf("unexpected error %v", err)
+	}
+}
+
+func TestFileChain(t *testing.T) {
+	dir := fileDir(t)
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(filters.File); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"hex":  {{Filter: "file", Params: iofl.Params{"path": "${name}", "dir": dir}}, {Filter: "hex"}},
+		"file": {{Filter: "file", Params: iofl.Params{"path": "a/b.txt", "dir": dir}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := s.ResolveVars("hex", map[string]string{"name": "a/b.txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(out) != "636f6e74656e74" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	if _, err := s.ResolveVars("hex", map[string]string{"name": "../b.txt"}, nil); err == nil || !strings.Contains(err.Error(), "outside of dir") {
+		t.Errorf("got error %v, want outside of dir", err)
+	}
+
+	r, err := s.ResolveSeeker("file", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if _, err := r.Seek(3, io.SeekStart); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ioutil.ReadAll(r); err != nil || string(out) != "tent" {
+		t.Errorf("after seek: got %q, %v", out, err)
+	}
+}
+
+func TestFileSymlink(t *testing.T) {
+	dir := fileDir(t)
+	outside := fileDir(t)
+	if err := os.Symlink(filepath.Join(outside, "a", "b.txt"), filepath.Join(dir, "link")); err != nil {
+		t.Skip(err)
+	}
+	if err := os.Symlink("b.txt", filepath.Join(dir, "a", "inner")); err != nil {
+		t.Fatal(err)
+	}
+	_, err := readFilterFrom(t, filters.File, iofl.Params{"path": "link", "dir": dir}, nil)
+	if err == nil || !strings.Contains(err.Error(), "outside of dir") {
+		t.Errorf("got error %v, want outside of dir", err)
+	}
+	out, err := readFilterFrom(t, filters.File, iofl.Params{"path": filepath.Join("a", "inner"), "dir": dir}, nil)
+	if err != nil || string(out) != "content" {
+		t.Errorf("link within dir: got %q, %v", out, err)
+	}
+}
+
+func TestFileIn(t *testing.T) {
+	dir := fileDir(t)
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Register(filters.FileIn(dir)); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"c": {{Filter: "file", Params: iofl.Params{"path": "${name}"}}},
+	}}); err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.ResolveVars("c", map[string]string{"name": "a/b.txt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out, err := ioutil.ReadAll(f); err != nil || string(out) != "content" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	f.Close()
+	for _, name := range []string{"../b.txt", "/etc/passwd"} {
+		if _, err := s.ResolveVars("c", map[string]string{"name": name}, nil); err == nil {
+			t.Errorf("%q: expected error", name)
+		}
+	}
+
+	// The directory is not chosen by the configuration.
+	err = s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"c": {{Filter: "file", Params: iofl.Params{"path": "passwd", "dir": "/etc"}}},
+	}})
+	if err == nil {
+		t.Error("expected error for dir param")
+	}
+	if _, err := filters.FileIn(dir).New(iofl.Params{"path": "passwd", "dir": "/etc"}, nil); err == nil {
+		t.Error("expected error for dir param")
+	}
+}
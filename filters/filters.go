@@ -0,0 +1,103 @@
+// The filters package provides general-purpose Filters to be registered with an
+// iofl.ChainSet.
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// errNoSource is returned by a filter that requires a source, but did not
+// receive one.
+var errNoSource = errors.New("source required")
+
+// Register registers each filter provided by the package with s, except for
+// those registered by RegisterUnsafe. Filters that resolve other chains, such
+// as Route, resolve them from s, and RateLimit attaches to the Limiters of
+// s.Bandwidth.
+func Register(s *iofl.ChainSet) error {
+	return register(s,
+		AESGCM,
+		Avro,
+		Base32,
+		Base64,
+		Charset,
+		Checksum,
+		Concat(s),
+		Discard,
+		Each(s),
+		Fallback(s),
+		Gzip,
+		Header,
+		Hex,
+		Identity,
+		JWE,
+		JWS,
+		Members,
+		Parallel(s),
+		PEM,
+		Percent,
+		ProtoDelim,
+		Race(s),
+		RateLimitIn(s.Bandwidth()),
+		Route(s),
+		Translate,
+		ZstdSeek,
+	)
+}
+
+// RegisterUnsafe registers with s the filters provided by the package that
+// reach outside of the process on behalf of a configuration: Exec, which runs
+// commands, File, which reads any file, HTTP and Stream, which connect to
+// arbitrary hosts, and SQL, which queries databases. These are registered only
+// by a program that trusts its configurations, and are otherwise omitted by
+// Register. A single such filter may instead be registered on its own, such as
+// with s.Register(filters.Exec).
+func RegisterUnsafe(s *iofl.ChainSet) error {
+	return register(s,
+		Exec,
+		File,
+		HTTP,
+		SQL,
+		Stream,
+	)
+}
+
+func register(s *iofl.ChainSet, defs ...iofl.FilterDef) error {
+	for _, def := range defs {
+		if err := s.Register(def); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getMode returns the "mode" parameter, or def if the parameter is empty.
+// Returns an error if the mode is not one of the given modes.
+func getMode(params iofl.Params, def string, modes ...string) (string, error) {
+	mode := params.GetString("mode")
+	if mode == "" {
+		return def, nil
+	}
+	for _, m := range modes {
+		if mode == m {
+			return mode, nil
+		}
+	}
+	return "", fmt.Errorf("unknown mode %q", mode)
+}
+
+// rootReader returns the io.ReadCloser wrapped by r if r is an iofl.Root, or r
+// otherwise.
+func rootReader(r io.ReadCloser) io.ReadCloser {
+	switch v := r.(type) {
+	case iofl.Root:
+		return v.ReadCloser
+	case *iofl.Root:
+		return v.ReadCloser
+	}
+	return r
+}
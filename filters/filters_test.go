@@ -0,0 +1,57 @@
+package filters_test
+
+import (
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// registered returns the set of names of the filters registered with s.
+func registered(s *iofl.ChainSet) map[string]bool {
+	names := map[string]bool{}
+	for _, def := range s.Filters() {
+		names[def.Name] = true
+	}
+	return names
+}
+
+func TestRegisterUnsafe(t *testing.T) {
+	unsafe := []string{"exec", "file", "http", "sql", "stream"}
+
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	names := registered(s)
+	for _, name := range unsafe {
+		if names[name] {
+			t.Errorf("Register registers %q", name)
+		}
+	}
+	if !names["gzip"] || !names["route"] {
+		t.Error("Register does not register safe filters")
+	}
+
+	if err := filters.RegisterUnsafe(s); err != nil {
+		t.Fatal(err)
+	}
+	names = registered(s)
+	for _, name := range unsafe {
+		if !names[name] {
+			t.Errorf("RegisterUnsafe does not register %q", name)
+		}
+	}
+	if err := filters.RegisterUnsafe(s); err == nil {
+		t.Error("expected error registering twice")
+	}
+
+	// An unsafe filter is rejected by a configuration unless registered.
+	s = iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{"c": {{Filter: "exec", Params: iofl.Params{"args": []interface{}{"true"}}}}}}); err == nil {
+		t.Error("expected error for unregistered exec filter")
+	}
+}
@@ -0,0 +1,29 @@
+package filters
+
+// frameStream produces a stream of bytes from a sequence of frames.
+type frameStream struct {
+	// next returns the bytes of the next frame.
+	next func() ([]byte, error)
+	buf  []byte
+	err  error
+}
+
+// Read reads the bytes of the current frame, advancing to the next frame as
+// needed.
+func (s *frameStream) Read(p []byte) (n int, err error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		s.buf, s.err = s.next()
+	}
+	n = copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// reset discards any pending bytes.
+func (s *frameStream) reset() {
+	s.buf = nil
+	s.err = nil
+}
@@ -0,0 +1,483 @@
+package filters
+
+import (
+	"bufio"
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// Member describes one member of a multi-member compressed stream, such as a
+// concatenated gzip file.
+type Member struct {
+	// Index is the position of the member within the stream.
+	Index int
+	// Offset is the position of the first byte of the member within the
+	// compressed stream.
+	Offset int64
+	// Name, Comment, ModTime, and Extra are from the gzip header of the
+	// member, if present.
+	Name    string
+	Comment string
+	ModTime time.Time
+	Extra   []byte
+}
+
+// MemberNotifier is implemented by filters that process multi-member
+// compressed streams.
+type MemberNotifier interface {
+	// OnMember sets a function that is called at the start of each member.
+	// Must be called before the first Read.
+	OnMember(fn func(m Member))
+}
+
+// Gzip compresses or decompresses gzip data. A gzip file may contain several
+// members, each compressed independently, as produced by concatenating files,
+// or by formats such as BGZF. Params:
+//
+//	mode:    "decompress" (default) or "compress".
+//	level:   The compression level, from 1 (fastest) to 9 (smallest), 0 for
+//	         no compression, or -2 for Huffman encoding only. Defaults to -1,
+//	         the default level of compress/gzip.
+//	members: "all" (default) decompresses every member, producing their
+//	         concatenated content. "first" decompresses only the first member,
+//	         ignoring the remainder of the source.
+//	max:     The maximum size of a frame, in bytes. Defaults to 64MiB.
+//
+// When compressing, the source is compressed as a single member. The filter
+// honors the bufferSize param.
+//
+// When decompressing, the filter implements iofl.Framer, with the decompressed
+// content of each member being a frame, and MemberNotifier, reporting the
+// start of each member. Frame buffers are reserved from the memory budget of
+// the chain.
+//
+// When used in a write chain, the filter compresses written content if mode is
+// "decompress". A filter in "compress" mode cannot be written to.
+var Gzip = iofl.FilterDef{
+	Name:         "gzip",
+	New:          newGzip,
+	NewWriter:    newGzipWriter,
+	Validate:     validateGzip,
+	Description:  "Compresses or decompresses gzip data, including files of several members.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decompress\" or \"compress\".", Default: "decompress"},
+		{Name: "level", Type: iofl.ParamInt, Description: "The compression level, from 1 (fastest) to 9 (smallest).", Default: "-1"},
+		{Name: "members", Type: iofl.ParamString, Description: "\"all\" decompresses every member. \"first\" decompresses only the first member.", Default: "all"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of a frame, in bytes.", Default: "64MiB"},
+	},
+	Traits: func(params iofl.Params) iofl.Trait {
+		if params.GetString("mode") == "compress" {
+			return iofl.Compresses
+		}
+		return iofl.Decompresses
+	},
+}
+
+func validateGzip(params iofl.Params) error {
+	if _, err := getMode(params, "decompress", "decompress", "compress"); err != nil {
+		return err
+	}
+	if _, err := gzipLevel(params); err != nil {
+		return err
+	}
+	switch members := params.GetString("members"); members {
+	case "", "all", "first":
+		return nil
+	default:
+		return fmt.Errorf("unknown members %q", members)
+	}
+}
+
+// gzipLevel returns the level param, or the default compression level if the
+// param is not present.
+func gzipLevel(params iofl.Params) (int, error) {
+	if _, ok := params["level"]; !ok {
+		return gzip.DefaultCompression, nil
+	}
+	level := params.GetInt("level")
+	if level < gzip.HuffmanOnly || level > gzip.BestCompression {
+		return 0, fmt.Errorf("invalid level %d", level)
+	}
+	return level, nil
+}
+
+func newGzip(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	if err := validateGzip(params); err != nil {
+		return nil, err
+	}
+	if params.GetString("mode") == "compress" {
+		level, _ := gzipLevel(params)
+		filter := &gzipCompressFilter{level: level, chunk: make([]byte, bufferSize(params))}
+		filter.Reset(r)
+		return filter, nil
+	}
+	filter := &gzipFilter{first: params.GetString("members") == "first", size: bufferSize(params)}
+	if filter.max = params.GetInt("max"); filter.max <= 0 {
+		filter.max = defaultMaxMessage
+	}
+	filter.Reset(r)
+	return filter, nil
+}
+
+// countReader is a buffered reader that counts the bytes consumed from it.
+type countReader struct {
+	br *bufio.Reader
+	n  int64
+}
+
+func (c *countReader) Read(p []byte) (n int, err error) {
+	n, err = c.br.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countReader) ReadByte() (b byte, err error) {
+	if b, err = c.br.ReadByte(); err == nil {
+		c.n++
+	}
+	return b, err
+}
+
+// gzipFilter implements the Gzip filter.
+type gzipFilter struct {
+	src      io.ReadCloser
+	first    bool
+	max      int
+	size     int
+	onMember func(Member)
+	budget   *iofl.MemoryBudget
+	closed   bool
+
+	cr       countReader
+	zr       *gzip.Reader
+	member   int
+	inMember bool
+	err      error
+	frame    []byte
+}
+
+// OnMember implements MemberNotifier.
+func (f *gzipFilter) OnMember(fn func(m Member)) {
+	f.onMember = fn
+}
+
+// Source implements iofl.Filter.
+func (f *gzipFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// nextMember begins the next member, returning io.EOF if none remain.
+func (f *gzipFilter) nextMember() error {
+	if f.member > 0 && f.first {
+		return io.EOF
+	}
+	if _, err := f.cr.br.Peek(1); err != nil {
+		if err == io.EOF && f.member == 0 {
+			return io.ErrUnexpectedEOF
+		}
+		return err
+	}
+	offset := f.cr.n
+	var err error
+	if f.zr == nil {
+		f.zr, err = gzip.NewReader(&f.cr)
+	} else {
+		err = f.zr.Reset(&f.cr)
+	}
+	if err != nil {
+		return fmt.Errorf("member %d: %w", f.member, gzipError(err))
+	}
+	f.zr.Multistream(false)
+	if f.onMember != nil {
+		f.onMember(Member{
+			Index:   f.member,
+			Offset:  offset,
+			Name:    f.zr.Name,
+			Comment: f.zr.Comment,
+			ModTime: f.zr.ModTime,
+			Extra:   f.zr.Extra,
+		})
+	}
+	f.member++
+	f.inMember = true
+	return nil
+}
+
+// Read implements io.Reader.
+func (f *gzipFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	for f.err == nil {
+		if !f.inMember {
+			if f.err = f.nextMember(); f.err != nil {
+				break
+			}
+		}
+		n, err = f.zr.Read(p)
+		if err == io.EOF {
+			f.inMember = false
+			err = nil
+		}
+		if n > 0 || err != nil {
+			return n, gzipError(err)
+		}
+	}
+	return 0, f.err
+}
+
+// ReadFrame implements iofl.Framer, returning the decompressed content of the
+// next member. If the current member was partially read by Read, the
+// remainder is returned.
+func (f *gzipFilter) ReadFrame() ([]byte, error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	if f.err != nil {
+		return nil, f.err
+	}
+	if !f.inMember {
+		if f.err = f.nextMember(); f.err != nil {
+			return nil, f.err
+		}
+	}
+	f.frame = f.frame[:0]
+	for {
+		if len(f.frame) == cap(f.frame) {
+			if err := f.grow(); err != nil {
+				return nil, err
+			}
+		}
+		n, err := f.zr.Read(f.frame[len(f.frame):cap(f.frame)])
+		f.frame = f.frame[:len(f.frame)+n]
+		if len(f.frame) > f.max {
+			return nil, fmt.Errorf("member %d exceeds maximum size", f.member-1)
+		}
+		if err == io.EOF {
+			f.inMember = false
+			return f.frame, nil
+		}
+		if err != nil {
+			return nil, gzipError(err)
+		}
+	}
+}
+
+// grow increases the capacity of the frame buffer, reserving the memory from
+// the budget.
+func (f *gzipFilter) grow() error {
+	size := 2 * cap(f.frame)
+	if size < 512 {
+		size = 512
+	}
+	if err := f.budget.Resize(cap(f.frame), size); err != nil {
+		return err
+	}
+	frame := make([]byte, len(f.frame), size)
+	copy(frame, f.frame)
+	f.frame = frame
+	return nil
+}
+
+// SetMemoryBudget implements iofl.BudgetUser.
+func (f *gzipFilter) SetMemoryBudget(b *iofl.MemoryBudget) {
+	f.budget = b
+}
+
+// gzipError classifies errors caused by malformed gzip data as corrupt.
+func gzipError(err error) error {
+	var ferr flate.CorruptInputError
+	if err == gzip.ErrHeader || err == gzip.ErrChecksum || errors.As(err, &ferr) {
+		return &iofl.CorruptError{Err: err}
+	}
+	return err
+}
+
+// Close implements io.Closer, closing the source.
+func (f *gzipFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *gzipFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	if f.cr.br == nil {
+		f.cr.br = bufio.NewReaderSize(src, f.size)
+	} else {
+		f.cr.br.Reset(src)
+	}
+	f.cr.n = 0
+	f.member = 0
+	f.inMember = false
+	f.err = nil
+	return nil
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *gzipFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *gzipFilter) MemoryUsage() int {
+	n := f.cr.br.Size() + cap(f.frame)
+	if f.zr != nil {
+		// The window of the decompressor.
+		n += 32 << 10
+	}
+	return n
+}
+
+// gzipCompressFilter implements the Gzip filter in compress mode.
+type gzipCompressFilter struct {
+	src    io.ReadCloser
+	level  int
+	closed bool
+
+	zw    *gzip.Writer
+	buf   bytes.Buffer
+	chunk []byte
+	err   error
+}
+
+// Source implements iofl.Filter.
+func (f *gzipCompressFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *gzipCompressFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	for f.buf.Len() == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		n, err := f.src.Read(f.chunk)
+		if n > 0 {
+			if _, werr := f.zw.Write(f.chunk[:n]); werr != nil {
+				return 0, werr
+			}
+		}
+		if err == io.EOF {
+			if cerr := f.zw.Close(); cerr != nil {
+				return 0, cerr
+			}
+		}
+		f.err = err
+	}
+	return f.buf.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *gzipCompressFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *gzipCompressFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.err = nil
+	f.buf.Reset()
+	if f.zw == nil {
+		// The level has been validated.
+		f.zw, _ = gzip.NewWriterLevel(&f.buf, f.level)
+	} else {
+		f.zw.Reset(&f.buf)
+	}
+	return nil
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *gzipCompressFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *gzipCompressFilter) MemoryUsage() int {
+	// Approximately the state of the compressor.
+	return cap(f.chunk) + f.buf.Cap() + 1<<20
+}
+
+// newGzipWriter returns a writer that compresses written content. The mode
+// param must be "decompress".
+func newGzipWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	if err := validateGzip(params); err != nil {
+		return nil, err
+	}
+	if params.GetString("mode") == "compress" {
+		return nil, iofl.NotWritable
+	}
+	level, _ := gzipLevel(params)
+	zw, _ := gzip.NewWriterLevel(w, level)
+	return &gzipWriter{dst: w, zw: zw}, nil
+}
+
+// gzipWriter implements the Gzip filter in a write chain.
+type gzipWriter struct {
+	dst    io.WriteCloser
+	zw     *gzip.Writer
+	closed bool
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *gzipWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// Write implements io.Writer.
+func (w *gzipWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	return w.zw.Write(p)
+}
+
+// Flush implements iofl.Flusher, completing the current compressed block.
+func (w *gzipWriter) Flush() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	return w.zw.Flush()
+}
+
+// Close implements io.Closer, completing the compressed stream, and closing
+// the sink.
+func (w *gzipWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	err := w.zw.Close()
+	if cerr := w.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (w *gzipWriter) CPUIntensive() bool {
+	return true
+}
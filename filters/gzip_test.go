@@ -0,0 +1,265 @@
+package filters_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// gzipMember returns content compressed as one gzip member with the given name.
+func gzipMember(t *testing.T, name, content string) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Name = name
+	zw.ModTime = time.Unix(1000, 0)
+	if _, err := zw.Write([]byte(content)); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+// gzipMembers returns a multistream gzip file of three members.
+func gzipMembers(t *testing.T) (b []byte, offsets []int64) {
+	t.Helper()
+	for i, content := range []string{"first ", "", strings.Repeat("third ", 100)} {
+		offsets = append(offsets, int64(len(b)))
+		b = append(b, gzipMember(t, string(rune('a'+i)), content)...)
+	}
+	return b, offsets
+}
+
+func TestGzipMembers(t *testing.T) {
+	in, offsets := gzipMembers(t)
+	want := "first " + strings.Repeat("third ", 100)
+	if out := mustRead(t, filters.Gzip, iofl.Params{"bufferSize": 16}, in); string(out) != want {
+		t.Errorf("all: got %q", out)
+	}
+	if out := mustRead(t, filters.Gzip, iofl.Params{"members": "first"}, in); string(out) != "first " {
+		t.Errorf("first: got %q", out)
+	}
+
+	f, err := filters.Gzip.New(nil, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var members []filters.Member
+	f.(filters.MemberNotifier).OnMember(func(m filters.Member) {
+		members = append(members, m)
+	})
+	if _, err := ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if len(members) != 3 {
+		t.Fatalf("got %d members, want 3", len(members))
+	}
+	for i, m := range members {
+		if m.Index != i || m.Offset != offsets[i] || m.Name != string(rune('a'+i)) || !m.ModTime.Equal(time.Unix(1000, 0)) {
+			t.Errorf("member %d: got %+v", i, m)
+		}
+	}
+}
+
+func TestGzipFrames(t *testing.T) {
+	in, _ := gzipMembers(t)
+	f, err := filters.Gzip.New(nil, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	// A partially read member is completed by ReadFrame.
+	b := make([]byte, 2)
+	if _, err := io.ReadFull(f, b); err != nil {
+		t.Fatal(err)
+	}
+	frames := []string{string(b)}
+	for {
+		frame, err := f.(iofl.Framer).ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, string(frame))
+	}
+	if want := []string{"fi", "rst ", "", strings.Repeat("third ", 100)}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("got %q, want %q", frames, want)
+	}
+}
+
+func TestGzipFrameLimits(t *testing.T) {
+	in, _ := gzipMembers(t)
+	f, err := filters.Gzip.New(iofl.Params{"max": 100}, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fr := f.(iofl.Framer)
+	for i := 0; i < 2; i++ {
+		if _, err := fr.ReadFrame(); err != nil {
+			t.Fatalf("frame %d: %v", i, err)
+		}
+	}
+	if _, err := fr.ReadFrame(); err == nil {
+		t.Error("expected error for oversize member")
+	}
+}
+
+func TestGzipCorrupt(t *testing.T) {
+	in, offsets := gzipMembers(t)
+	mutate := func(i int) []byte {
+		b := append([]byte{}, in...)
+		b[i] ^= 0xFF
+		return b
+	}
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"bad header", mutate(0)},
+		{"bad second header", mutate(int(offsets[1]))},
+		{"bad checksum", mutate(int(offsets[1]) - 8)},
+		{"bad data", mutate(int(offsets[2]) + 20)},
+	}
+	for _, tt := range tests {
+		if _, err := readFilter(t, filters.Gzip, nil, tt.in); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", tt.name, err)
+		}
+	}
+	for _, in := range [][]byte{nil, in[:len(in)-4]} {
+		if _, err := readFilter(t, filters.Gzip, nil, in); !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%d bytes: got %v, want ErrUnexpectedEOF", len(in), err)
+		}
+	}
+}
+
+func TestGzipCompress(t *testing.T) {
+	content := strings.Repeat("compress me ", 50)
+	for _, level := range []interface{}{nil, 0, 1, 9, -2} {
+		params := iofl.Params{"mode": "compress"}
+		if level != nil {
+			params["level"] = level
+		}
+		out := mustRead(t, filters.Gzip, params, []byte(content))
+		zr, err := gzip.NewReader(bytes.NewReader(out))
+		if err != nil {
+			t.Fatalf("level %v: %v", level, err)
+		}
+		if b, err := ioutil.ReadAll(zr); err != nil || string(b) != content {
+			t.Errorf("level %v: got %q, %v", level, b, err)
+		}
+	}
+}
+
+func TestGzipCompressRoundTrip(t *testing.T) {
+	content := strings.Repeat("round trip ", 1000)
+	params := iofl.Params{"mode": "compress", "level": 9, iofl.ParamBufferSize: 16}
+	f, err := filters.Gzip.New(params, ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(content))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		out, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if b := mustRead(t, filters.Gzip, nil, out); string(b) != content {
+			t.Errorf("pass %d: round trip does not match input", i)
+		}
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.Close(); err != iofl.Closed {
+			t.Errorf("got %v, want Closed", err)
+		}
+		if _, err := f.Read(make([]byte, 1)); err != iofl.Closed {
+			t.Errorf("got %v, want Closed", err)
+		}
+		// A reset filter compresses a new source.
+		if err := f.(iofl.Resetter).Reset(ioutil.NopCloser(strings.NewReader(content))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Higher levels compress better.
+	fast := mustRead(t, filters.Gzip, iofl.Params{"mode": "compress", "level": 1}, []byte(content))
+	best := mustRead(t, filters.Gzip, iofl.Params{"mode": "compress", "level": 9}, []byte(content))
+	if len(best) > len(fast) {
+		t.Errorf("level 9 produced %d bytes, level 1 produced %d", len(best), len(fast))
+	}
+}
+
+func TestGzipWriter(t *testing.T) {
+	content := strings.Repeat("written ", 50)
+	out, err := writeFilter(t, filters.Gzip, nil, []byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if b := mustRead(t, filters.Gzip, nil, out); string(b) != content {
+		t.Errorf("got %q", b)
+	}
+
+	// A flush completes the current block, so that the content written so far
+	// can be decompressed before the stream ends.
+	var buf bytes.Buffer
+	w, err := filters.Gzip.NewWriter(nil, nopWriteCloser{&buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte(content))
+	if err := w.(iofl.Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := make([]byte, len(content))
+	if _, err := io.ReadFull(zr, b); err != nil || string(b) != content {
+		t.Errorf("after flush: got %q, %v", b, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.(iofl.Flusher).Flush(); err != iofl.Closed {
+		t.Errorf("flush after close: got %v, want Closed", err)
+	}
+	if _, err := writeFilter(t, filters.Gzip, iofl.Params{"mode": "compress"}, nil); err != iofl.NotWritable {
+		t.Errorf("got %v, want NotWritable", err)
+	}
+}
+
+func TestGzipParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "inflate"},
+		{"level": 10},
+		{"level": -3},
+		{"members": "last"},
+	} {
+		if err := filters.Gzip.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+		if _, err := filters.Gzip.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error from New", params)
+		}
+	}
+	if _, err := filters.Gzip.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,470 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+
+	"github.com/anaminus/iofl"
+)
+
+// Header parses and strips a binary header from the start of its source,
+// producing the content that follows. Params:
+//
+//	format:    "fixed" (default) for a header with fields at fixed offsets,
+//	           or "tlv" for a header of tag-length-value entries.
+//	magic:     Bytes, encoded as hexadecimal, with which the header must
+//	           begin.
+//	order:     The byte order of integers, "big" (default) or "little".
+//	size:      (fixed) The size of the header, in bytes, including the
+//	           magic.
+//	sizeField: (fixed) The name of a field containing the size of the
+//	           header, in bytes, used instead of size.
+//	fields:    (fixed) A list of fields, each a map with the following
+//	           entries:
+//	           name:   The name of the field. Required.
+//	           offset: The offset of the field within the header, in bytes.
+//	           type:   "u8", "u16", "u32", or "u64" for an unsigned integer,
+//	                   "bytes", or "string", which has trailing NUL bytes
+//	                   removed. Required.
+//	           size:   The size of a bytes or string field, in bytes.
+//	tag:       (tlv) The size of the tag of each entry, 1 (default), 2, or 4
+//	           bytes.
+//	length:    (tlv) The size of the length of each entry, 1, 2 (default), or
+//	           4 bytes.
+//	end:       (tlv) The tag of the entry that ends the header. The entry is
+//	           stripped along with the header. Defaults to 0.
+//	names:     (tlv) A map of tags, in decimal, to the names of their fields.
+//	           Entries with unnamed tags are named by their tag in decimal.
+//	max:       (tlv) The maximum size of the header, in bytes. Defaults to
+//	           64KiB.
+//
+// Integer fields are uint64, bytes fields and TLV values are []byte, and
+// string fields are string. If a TLV tag repeats, the last value is used. The
+// filter implements HeaderParser and iofl.Reporter, providing the parsed
+// fields. A malformed or truncated header is a corrupt error.
+var Header = iofl.FilterDef{
+	Name:         "header",
+	New:          newHeader,
+	Validate:     validateHeader,
+	Description:  "Parses and strips a fixed or TLV binary header.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "format", Type: iofl.ParamString, Description: "\"fixed\" or \"tlv\".", Default: "fixed"},
+		{Name: "magic", Type: iofl.ParamString, Description: "Hexadecimal bytes with which the header must begin."},
+		{Name: "order", Type: iofl.ParamString, Description: "The byte order of integers, \"big\" or \"little\".", Default: "big"},
+		{Name: "size", Type: iofl.ParamInt, Description: "The size of a fixed header, in bytes."},
+		{Name: "sizeField", Type: iofl.ParamString, Description: "The name of a field containing the size of a fixed header."},
+		{Name: "fields", Type: iofl.ParamList, Description: "The fields of a fixed header, each a map of name, offset, type, and size."},
+		{Name: "tag", Type: iofl.ParamInt, Description: "The size of each TLV tag, in bytes.", Default: "1"},
+		{Name: "length", Type: iofl.ParamInt, Description: "The size of each TLV length, in bytes.", Default: "2"},
+		{Name: "end", Type: iofl.ParamInt, Description: "The TLV tag that ends the header.", Default: "0"},
+		{Name: "names", Type: iofl.ParamMap, Description: "A map of TLV tags to field names."},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of a TLV header, in bytes.", Default: "64KiB"},
+	},
+}
+
+// HeaderParser is implemented by a filter that parses a header from its
+// source.
+type HeaderParser interface {
+	// HeaderFields returns the fields of the header, mapped by name. The
+	// header is read from the source if it has not yet been read.
+	HeaderFields() (map[string]interface{}, error)
+}
+
+// headerDefaultMax is the default maximum size of a TLV header.
+const headerDefaultMax = 64 << 10
+
+// headerField describes a field of a fixed header.
+type headerField struct {
+	name   string
+	offset int
+	typ    string
+	size   int
+}
+
+// end returns the offset following the field.
+func (f headerField) end() int {
+	return f.offset + f.size
+}
+
+// headerParams contains the parsed params of the filter.
+type headerParams struct {
+	tlv   bool
+	magic []byte
+	order binary.ByteOrder
+
+	size      int
+	sizeField string
+	fields    []headerField
+
+	tag    int
+	length int
+	end    uint64
+	names  map[uint64]string
+	max    int
+}
+
+// headerIntSizes maps the types of integer fields to their size.
+var headerIntSizes = map[string]int{"u8": 1, "u16": 2, "u32": 4, "u64": 8}
+
+func parseHeader(params iofl.Params) (p headerParams, err error) {
+	switch format := params.GetString("format"); format {
+	case "", "fixed":
+	case "tlv":
+		p.tlv = true
+	default:
+		return p, fmt.Errorf("unknown format %q", format)
+	}
+	if p.magic, err = hex.DecodeString(params.GetString("magic")); err != nil {
+		return p, errors.New("magic: expected hexadecimal")
+	}
+	switch order := params.GetString("order"); order {
+	case "", "big":
+		p.order = binary.BigEndian
+	case "little":
+		p.order = binary.LittleEndian
+	default:
+		return p, fmt.Errorf("unknown order %q", order)
+	}
+	if p.tlv {
+		return p, parseHeaderTLV(params, &p)
+	}
+	return p, parseHeaderFixed(params, &p)
+}
+
+func parseHeaderFixed(params iofl.Params, p *headerParams) error {
+	if v, ok := params["fields"]; ok {
+		list, ok := v.([]interface{})
+		if !ok {
+			return fmt.Errorf("fields: expected list, got %T", v)
+		}
+		for i, v := range list {
+			m, ok := v.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("fields[%d]: expected map, got %T", i, v)
+			}
+			fp := iofl.Params(m)
+			f := headerField{
+				name:   fp.GetString("name"),
+				offset: fp.GetInt("offset"),
+				typ:    fp.GetString("type"),
+				size:   fp.GetInt("size"),
+			}
+			if f.name == "" {
+				return fmt.Errorf("fields[%d]: name required", i)
+			}
+			if f.offset < 0 {
+				return fmt.Errorf("field %s: negative offset", f.name)
+			}
+			switch f.typ {
+			case "bytes", "string":
+				if f.size <= 0 {
+					return fmt.Errorf("field %s: size required", f.name)
+				}
+			default:
+				size, ok := headerIntSizes[f.typ]
+				if !ok {
+					return fmt.Errorf("field %s: unknown type %q", f.name, f.typ)
+				}
+				f.size = size
+			}
+			p.fields = append(p.fields, f)
+		}
+	}
+	p.size = params.GetInt("size")
+	if p.sizeField = params.GetString("sizeField"); p.sizeField != "" {
+		if p.size != 0 {
+			return errors.New("size and sizeField cannot both be specified")
+		}
+		for _, f := range p.fields {
+			if f.name == p.sizeField {
+				if _, ok := headerIntSizes[f.typ]; !ok {
+					return fmt.Errorf("sizeField: field %s is not an integer", f.name)
+				}
+				return nil
+			}
+		}
+		return fmt.Errorf("sizeField: unknown field %q", p.sizeField)
+	}
+	if p.size <= 0 {
+		return errors.New("size or sizeField required")
+	}
+	if len(p.magic) > p.size {
+		return errors.New("magic exceeds size")
+	}
+	for _, f := range p.fields {
+		if f.end() > p.size {
+			return fmt.Errorf("field %s exceeds size", f.name)
+		}
+	}
+	return nil
+}
+
+func parseHeaderTLV(params iofl.Params, p *headerParams) error {
+	if p.tag = params.GetInt("tag"); p.tag == 0 {
+		p.tag = 1
+	}
+	if p.length = params.GetInt("length"); p.length == 0 {
+		p.length = 2
+	}
+	if !validTLVSize(p.tag) {
+		return errors.New("tag: expected 1, 2, or 4 bytes")
+	}
+	if !validTLVSize(p.length) {
+		return errors.New("length: expected 1, 2, or 4 bytes")
+	}
+	end := params.GetInt("end")
+	if end < 0 {
+		return errors.New("end: negative tag")
+	}
+	p.end = uint64(end)
+	if v, ok := params["names"]; ok {
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("names: expected map, got %T", v)
+		}
+		p.names = make(map[uint64]string, len(m))
+		for k, v := range m {
+			tag, err := strconv.ParseUint(k, 10, 32)
+			if err != nil {
+				return fmt.Errorf("names: invalid tag %q", k)
+			}
+			name, ok := v.(string)
+			if !ok {
+				return fmt.Errorf("names: %s: expected string, got %T", k, v)
+			}
+			p.names[tag] = name
+		}
+	}
+	if p.max = params.GetInt("max"); p.max <= 0 {
+		p.max = headerDefaultMax
+	}
+	return nil
+}
+
+// validTLVSize returns whether size is a valid size of a tag or length.
+func validTLVSize(size int) bool {
+	return size == 1 || size == 2 || size == 4
+}
+
+func validateHeader(params iofl.Params) error {
+	_, err := parseHeader(params)
+	return err
+}
+
+func newHeader(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	p, err := parseHeader(params)
+	if err != nil {
+		return nil, err
+	}
+	return &headerFilter{src: r, params: p}, nil
+}
+
+// headerFilter implements the Header filter.
+type headerFilter struct {
+	src    io.ReadCloser
+	params headerParams
+	closed bool
+
+	parsed bool
+	fields map[string]interface{}
+	err    error
+}
+
+// Source implements iofl.Filter.
+func (f *headerFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// headerCorrupt returns a corrupt error for a malformed header.
+func headerCorrupt(format string, v ...interface{}) error {
+	return &iofl.CorruptError{Err: fmt.Errorf("header: "+format, v...)}
+}
+
+// readFull reads len(b) bytes of the header.
+func (f *headerFilter) readFull(b []byte) error {
+	if _, err := io.ReadFull(f.src, b); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return headerCorrupt("truncated")
+		}
+		return err
+	}
+	return nil
+}
+
+// uint decodes an unsigned integer of 1, 2, 4, or 8 bytes.
+func (f *headerFilter) uint(b []byte) uint64 {
+	switch len(b) {
+	case 1:
+		return uint64(b[0])
+	case 2:
+		return uint64(f.params.order.Uint16(b))
+	case 4:
+		return uint64(f.params.order.Uint32(b))
+	}
+	return f.params.order.Uint64(b)
+}
+
+// parse reads and parses the header.
+func (f *headerFilter) parse() {
+	f.parsed = true
+	f.fields = map[string]interface{}{}
+	magic := make([]byte, len(f.params.magic))
+	if f.err = f.readFull(magic); f.err != nil {
+		return
+	}
+	if !bytes.Equal(magic, f.params.magic) {
+		f.err = headerCorrupt("magic %x does not match", magic)
+		return
+	}
+	if f.params.tlv {
+		f.err = f.parseTLV(len(magic))
+	} else {
+		f.err = f.parseFixed(magic)
+	}
+}
+
+// parseFixed parses a fixed header, of which magic has been read.
+func (f *headerFilter) parseFixed(magic []byte) error {
+	p := f.params
+	size := p.size
+	if p.sizeField != "" {
+		// Read enough to parse every field, then the remainder of the header
+		// according to the size field.
+		size = len(magic)
+		for _, field := range p.fields {
+			if field.end() > size {
+				size = field.end()
+			}
+		}
+	}
+	b := make([]byte, size)
+	copy(b, magic)
+	if err := f.readFull(b[len(magic):]); err != nil {
+		return err
+	}
+	for _, field := range p.fields {
+		v := b[field.offset:field.end()]
+		switch field.typ {
+		case "bytes":
+			f.fields[field.name] = append([]byte(nil), v...)
+		case "string":
+			f.fields[field.name] = string(bytes.TrimRight(v, "\x00"))
+		default:
+			f.fields[field.name] = f.uint(v)
+		}
+	}
+	if p.sizeField == "" {
+		return nil
+	}
+	total := f.fields[p.sizeField].(uint64)
+	if total < uint64(size) {
+		return headerCorrupt("size %d is smaller than its fields", total)
+	}
+	if total > math.MaxInt64 {
+		return headerCorrupt("size %d exceeds maximum", total)
+	}
+	_, err := io.CopyN(io.Discard, f.src, int64(total)-int64(size))
+	if err == io.EOF {
+		return headerCorrupt("truncated")
+	}
+	return err
+}
+
+// parseTLV parses a TLV header, of which n bytes have been read.
+func (f *headerFilter) parseTLV(n int) error {
+	p := f.params
+	var tl [8]byte
+	for {
+		if n += p.tag + p.length; n > p.max {
+			return headerCorrupt("exceeds max of %d bytes", p.max)
+		}
+		if err := f.readFull(tl[:p.tag+p.length]); err != nil {
+			return err
+		}
+		tag := f.uint(tl[:p.tag])
+		length := f.uint(tl[p.tag : p.tag+p.length])
+		if length > uint64(p.max-n) {
+			return headerCorrupt("exceeds max of %d bytes", p.max)
+		}
+		n += int(length)
+		value := make([]byte, length)
+		if err := f.readFull(value); err != nil {
+			return err
+		}
+		if tag == p.end {
+			return nil
+		}
+		name, ok := p.names[tag]
+		if !ok {
+			name = strconv.FormatUint(tag, 10)
+		}
+		f.fields[name] = value
+	}
+}
+
+// HeaderFields implements HeaderParser.
+func (f *headerFilter) HeaderFields() (map[string]interface{}, error) {
+	if !f.parsed {
+		if f.closed {
+			return nil, iofl.Closed
+		}
+		f.parse()
+	}
+	return f.fields, f.err
+}
+
+// Report implements iofl.Reporter, reporting the parsed fields. Byte values
+// are encoded as hexadecimal.
+func (f *headerFilter) Report() map[string]interface{} {
+	report := make(map[string]interface{}, len(f.fields))
+	for k, v := range f.fields {
+		if b, ok := v.([]byte); ok {
+			v = hex.EncodeToString(b)
+		}
+		report[k] = v
+	}
+	return report
+}
+
+// Read implements io.Reader.
+func (f *headerFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if !f.parsed {
+		f.parse()
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	return f.src.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *headerFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *headerFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.parsed = false
+	f.fields = nil
+	f.err = nil
+	return nil
+}
@@ -0,0 +1,235 @@
+package filters_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// headerFields returns the fields parsed by the Header filter over in, and the
+// content following the header.
+func headerFields(t *testing.T, params iofl.Params, in []byte) (fields map[string]interface{}, content []byte, err error) {
+	t.Helper()
+	f, err := filters.Header.New(params, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatalf("%v: %v", params, err)
+	}
+	defer f.Close()
+	if fields, err = f.(filters.HeaderParser).HeaderFields(); err != nil {
+		return fields, nil, err
+	}
+	content, err = ioutil.ReadAll(f)
+	return fields, content, err
+}
+
+// headerFixed returns params of a fixed header of 16 bytes beginning with the
+// magic "IOFL".
+func headerFixed(kv ...interface{}) iofl.Params {
+	return withParams(iofl.Params{
+		"magic": "494f464c",
+		"size":  16,
+		"fields": []interface{}{
+			map[string]interface{}{"name": "version", "offset": 4, "type": "u8"},
+			map[string]interface{}{"name": "flags", "offset": 5, "type": "u16"},
+			map[string]interface{}{"name": "length", "offset": 7, "type": "u32"},
+			map[string]interface{}{"name": "id", "offset": 11, "type": "bytes", "size": 2},
+			map[string]interface{}{"name": "label", "offset": 13, "type": "string", "size": 3},
+		},
+	}, kv...)
+}
+
+func TestHeaderFixed(t *testing.T) {
+	header := []byte("IOFL\x02\x01\x02\x00\x00\x01\x00\xAB\xCDhi\x00")
+	tests := []struct {
+		order string
+		want  map[string]interface{}
+	}{
+		{"big", map[string]interface{}{
+			"version": uint64(2), "flags": uint64(0x0102), "length": uint64(0x100),
+			"id": []byte{0xAB, 0xCD}, "label": "hi",
+		}},
+		{"little", map[string]interface{}{
+			"version": uint64(2), "flags": uint64(0x0201), "length": uint64(0x10000),
+			"id": []byte{0xAB, 0xCD}, "label": "hi",
+		}},
+	}
+	for _, tt := range tests {
+		fields, content, err := headerFields(t, headerFixed("order", tt.order), append(append([]byte{}, header...), "content"...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !reflect.DeepEqual(fields, tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.order, fields, tt.want)
+		}
+		if string(content) != "content" {
+			t.Errorf("%s: got content %q", tt.order, content)
+		}
+	}
+
+	// A header described only by its size is stripped.
+	out := mustRead(t, filters.Header, iofl.Params{"size": 3}, []byte("abcdef"))
+	if string(out) != "def" {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestHeaderSizeField(t *testing.T) {
+	params := iofl.Params{
+		"magic":     "ff",
+		"sizeField": "size",
+		"fields": []interface{}{
+			map[string]interface{}{"name": "size", "offset": 1, "type": "u16"},
+		},
+	}
+	fields, content, err := headerFields(t, params, []byte("\xff\x00\x06padcontent"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fields["size"] != uint64(6) || string(content) != "content" {
+		t.Errorf("got %v, %q", fields, content)
+	}
+	for name, in := range map[string]string{
+		"smaller than fields": "\xff\x00\x02content",
+		"truncated":           "\xff\x00\x10pad",
+		"missing size":        "\xff\x00",
+	} {
+		if _, _, err := headerFields(t, params, []byte(in)); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", name, err)
+		}
+	}
+	huge := withParams(params, "fields", []interface{}{
+		map[string]interface{}{"name": "size", "offset": 1, "type": "u64"},
+	})
+	if _, _, err := headerFields(t, huge, []byte("\xff\xff\xff\xff\xff\xff\xff\xff\xffcontent")); !iofl.IsCorrupt(err) {
+		t.Errorf("huge: got %v, want corrupt", err)
+	}
+}
+
+func TestHeaderTLV(t *testing.T) {
+	params := iofl.Params{
+		"format": "tlv",
+		"magic":  "00ff",
+		"names":  map[string]interface{}{"1": "name", "3": "unused"},
+	}
+	in := []byte("\x00\xff" +
+		"\x01\x00\x03abc" +
+		"\x02\x00\x00" +
+		"\x07\x00\x01x" +
+		"\x07\x00\x01y" +
+		"\x00\x00\x02zz" +
+		"content")
+	fields, content, err := headerFields(t, params, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]interface{}{"name": []byte("abc"), "2": []byte{}, "7": []byte("y")}
+	if !reflect.DeepEqual(fields, want) {
+		t.Errorf("got %v, want %v", fields, want)
+	}
+	if string(content) != "content" {
+		t.Errorf("got content %q", content)
+	}
+
+	wide := iofl.Params{"format": "tlv", "tag": 2, "length": 4, "order": "little", "end": 0xFFFF}
+	fields, content, err = headerFields(t, wide, []byte("\x01\x02\x02\x00\x00\x00hi\xff\xff\x00\x00\x00\x00content"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(fields, map[string]interface{}{"513": []byte("hi")}) || string(content) != "content" {
+		t.Errorf("got %v, %q", fields, content)
+	}
+
+	for name, in := range map[string]string{
+		"magic":           "\xff\x00\x00\x00\x00",
+		"truncated magic": "\x00",
+		"truncated tag":   "\x00\xff\x01\x00",
+		"truncated value": "\x00\xff\x01\x00\x05abc",
+		"missing end":     "\x00\xff\x01\x00\x01a",
+		"exceeds max":     "\x00\xff\x01\x00\xf0" + string(make([]byte, 0xf0)) + "\x00\x00\x00",
+	} {
+		if _, _, err := headerFields(t, withParams(params, "max", 64), []byte(in)); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", name, err)
+		}
+	}
+}
+
+func TestHeaderFilter(t *testing.T) {
+	in := []byte("IOFL\x02\x01\x02\x00\x00\x01\x00\xAB\xCDhi\x00content")
+	f, err := filters.Header.New(headerFixed(), ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The header is parsed by the first Read.
+	if out, err := ioutil.ReadAll(f); err != nil || string(out) != "content" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	report := f.(iofl.Reporter).Report()
+	if report["id"] != "abcd" || report["label"] != "hi" || report["version"] != uint64(2) {
+		t.Errorf("got report %v", report)
+	}
+
+	// The error of a corrupt header persists.
+	if err := f.(iofl.Resetter).Reset(ioutil.NopCloser(bytes.NewReader([]byte("IOFX")))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); !iofl.IsCorrupt(err) {
+		t.Errorf("got %v, want corrupt", err)
+	}
+	if _, err := f.(filters.HeaderParser).HeaderFields(); !iofl.IsCorrupt(err) {
+		t.Errorf("got %v, want corrupt", err)
+	}
+
+	// The header cannot be parsed once the filter is closed.
+	f.(iofl.Resetter).Reset(ioutil.NopCloser(bytes.NewReader(in)))
+	f.Close()
+	if _, err := f.(filters.HeaderParser).HeaderFields(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+	if _, err := f.Read(make([]byte, 1)); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
+
+func TestHeaderParams(t *testing.T) {
+	field := func(kv ...interface{}) []interface{} {
+		m := map[string]interface{}{"name": "f", "offset": 0, "type": "u8"}
+		for i := 0; i < len(kv); i += 2 {
+			m[kv[i].(string)] = kv[i+1]
+		}
+		return []interface{}{m}
+	}
+	for _, params := range []iofl.Params{
+		{},
+		{"format": "xml", "size": 1},
+		{"size": 1, "magic": "xyz"},
+		{"size": 1, "magic": "0102"},
+		{"size": 1, "order": "middle"},
+		{"size": 1, "fields": "f"},
+		{"size": 1, "fields": []interface{}{"f"}},
+		{"size": 1, "fields": field("name", "")},
+		{"size": 1, "fields": field("offset", -1)},
+		{"size": 1, "fields": field("type", "u24")},
+		{"size": 4, "fields": field("type", "bytes")},
+		{"size": 1, "fields": field("type", "u16")},
+		{"size": 1, "sizeField": "f", "fields": field()},
+		{"sizeField": "g", "fields": field()},
+		{"sizeField": "f", "fields": field("type", "string", "size", 1)},
+		{"format": "tlv", "tag": 3},
+		{"format": "tlv", "length": 8},
+		{"format": "tlv", "end": -1},
+		{"format": "tlv", "names": []interface{}{}},
+		{"format": "tlv", "names": map[string]interface{}{"x": "name"}},
+		{"format": "tlv", "names": map[string]interface{}{"1": 2}},
+	} {
+		if err := filters.Header.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.Header.New(iofl.Params{"size": 1}, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,73 @@
+package filters_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func init() {
+	// A fixed key for filters that require one, retrieved as "test:name".
+	filters.RegisterKeyProvider("test", filters.KeyFunc(func(name string) ([]byte, error) {
+		return bytes.Repeat([]byte{7}, 32), nil
+	}))
+}
+
+// nopWriteCloser adds a no-op Close method to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// readFilter constructs the filter of def over in, and returns everything read
+// from it. The filter is closed before returning.
+func readFilter(t *testing.T, def iofl.FilterDef, params iofl.Params, in []byte) ([]byte, error) {
+	t.Helper()
+	return readFilterFrom(t, def, params, ioutil.NopCloser(bytes.NewReader(in)))
+}
+
+// readFilterFrom constructs the filter of def over src, and returns everything
+// read from it. The filter is closed before returning.
+func readFilterFrom(t *testing.T, def iofl.FilterDef, params iofl.Params, src io.ReadCloser) ([]byte, error) {
+	t.Helper()
+	f, err := def.New(params, src)
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(f)
+	if cerr := f.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return out, err
+}
+
+// writeFilter constructs the write filter of def, writes in to it, and returns
+// what the filter wrote to its sink once closed.
+func writeFilter(t *testing.T, def iofl.FilterDef, params iofl.Params, in []byte) ([]byte, error) {
+	t.Helper()
+	var buf bytes.Buffer
+	w, err := def.NewWriter(params, nopWriteCloser{&buf})
+	if err != nil {
+		return nil, err
+	}
+	_, err = w.Write(in)
+	if cerr := w.Close(); cerr != nil && err == nil {
+		err = cerr
+	}
+	return buf.Bytes(), err
+}
+
+// mustRead is like readFilter, but fails the test on error.
+func mustRead(t *testing.T, def iofl.FilterDef, params iofl.Params, in []byte) []byte {
+	t.Helper()
+	out, err := readFilter(t, def, params, in)
+	if err != nil {
+		t.Fatalf("%s %v: %v", def.Name, params, err)
+	}
+	return out
+}
@@ -0,0 +1,333 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// HTTP is a source filter that produces the body of the response to an HTTP
+// GET request, allowing a download to be described by a chain, such as one
+// followed by decompression and checksum links. Like File, HTTP accepts a
+// source, but ignores it, and the ignored source is closed with the filter.
+// Params:
+//
+//	url:     The URL to request, with an "http" or "https" scheme. Required.
+//	headers: A map of header names to values sent with the request. A value
+//	         may be a string, or a list of strings.
+//	timeout: The time limit of the request, including reading the body, such
+//	         as "30s". Defaults to no limit.
+//	retries: The number of times a failed request is retried. Defaults to 0.
+//	backoff: The delay before the first retry, which doubles for each
+//	         subsequent retry. Defaults to 1s.
+//
+// The request is made on the first Read, with the context of the chain, if
+// any, as the filter implements iofl.ContextFilter. A request fails if it
+// cannot be made, or if the response has a status other than 2xx. A failed
+// request is retried unless the status is a client error other than 408 or
+// 429. Once the body has started to be produced, an error is returned without
+// retrying. Closing the filter interrupts a pending request or Read.
+//
+// The url and headers may refer to variables, such as "${token}", which are
+// expanded when the chain is resolved with ResolveVars. The filter reports the
+// status of the response and the number of attempts made through the
+// iofl.Reporter interface.
+//
+// HTTP requests any URL it is given, including those of internal hosts, so it
+// is registered by RegisterUnsafe rather than Register.
+var HTTP = iofl.FilterDef{
+	Name:         "http",
+	New:          newHTTP,
+	Validate:     validateHTTP,
+	Description:  "Produces the body of the response to an HTTP GET request, ignoring the source.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "url", Type: iofl.ParamString, Required: true, Description: "The http or https URL to request. Required."},
+		{Name: "headers", Type: iofl.ParamMap, Description: "A map of header names to values sent with the request."},
+		{Name: "timeout", Type: iofl.ParamDuration, Description: "The time limit of the request, including reading the body.", Default: "no limit"},
+		{Name: "retries", Type: iofl.ParamInt, Description: "The number of times a failed request is retried.", Default: "0"},
+		{Name: "backoff", Type: iofl.ParamDuration, Description: "The delay before the first retry, doubling for each retry.", Default: "1s"},
+	},
+}
+
+// httpFilter implements the HTTP filter.
+type httpFilter struct {
+	ctx     context.Context
+	url     string
+	header  http.Header
+	timeout time.Duration
+	retries int
+	backoff time.Duration
+	src     io.ReadCloser
+	err     error
+
+	mu       sync.Mutex
+	body     io.ReadCloser
+	cancel   context.CancelFunc
+	status   int
+	attempts int
+	closed   bool
+	// done is closed by Close, interrupting a pending backoff.
+	done chan struct{}
+}
+
+func parseHTTP(params iofl.Params) (*httpFilter, error) {
+	f := &httpFilter{
+		header:  http.Header{},
+		timeout: params.GetDuration("timeout"),
+		retries: params.GetInt("retries"),
+		backoff: time.Second,
+	}
+	raw := params.GetString("url")
+	if raw == "" {
+		return nil, errors.New("url required")
+	}
+	// Variables are expanded before the filter is constructed, so an
+	// unexpanded URL is only checked for its scheme.
+	if !strings.Contains(raw, "$") {
+		u, err := url.Parse(raw)
+		if err != nil {
+			return nil, err
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, fmt.Errorf("url: unknown scheme %q", u.Scheme)
+		}
+		if u.Host == "" {
+			return nil, errors.New("url: host required")
+		}
+	}
+	f.url = raw
+	switch headers := params["headers"].(type) {
+	case nil:
+	case map[string]interface{}:
+		for name, value := range headers {
+			switch value := value.(type) {
+			case string:
+				f.header.Add(name, value)
+			case []interface{}:
+				for _, v := range value {
+					s, ok := v.(string)
+					if !ok {
+						return nil, fmt.Errorf("headers: %s: expected string, got %T", name, v)
+					}
+					f.header.Add(name, s)
+				}
+			default:
+				return nil, fmt.Errorf("headers: %s: expected string or list, got %T", name, value)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("headers: expected map, got %T", headers)
+	}
+	if _, ok := params["backoff"]; ok {
+		f.backoff = params.GetDuration("backoff")
+	}
+	switch {
+	case f.timeout < 0:
+		return nil, errors.New("timeout: must not be negative")
+	case f.retries < 0:
+		return nil, errors.New("retries: must not be negative")
+	case f.backoff <= 0:
+		return nil, errors.New("backoff: must be positive")
+	}
+	return f, nil
+}
+
+func validateHTTP(params iofl.Params) error {
+	_, err := parseHTTP(params)
+	return err
+}
+
+func newHTTP(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	filter, err := parseHTTP(params)
+	if err != nil {
+		return nil, err
+	}
+	filter.src = r
+	filter.done = make(chan struct{})
+	return filter, nil
+}
+
+// Source implements iofl.Filter. Returns nil, since the source is ignored.
+func (f *httpFilter) Source() io.ReadCloser {
+	return nil
+}
+
+// SetContext implements iofl.ContextFilter. The request is made with ctx.
+func (f *httpFilter) SetContext(ctx context.Context) {
+	f.ctx = ctx
+}
+
+// Report implements iofl.Reporter.
+func (f *httpFilter) Report() map[string]interface{} {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return map[string]interface{}{
+		"status":   f.status,
+		"attempts": f.attempts,
+	}
+}
+
+// do makes one request with ctx.
+func (f *httpFilter) do(ctx context.Context) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.url, nil)
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	for name, values := range f.header {
+		if name == "Host" {
+			req.Host = values[0]
+			continue
+		}
+		req.Header[name] = values
+	}
+	resp, err := http.DefaultClient.Do(req)
+	f.mu.Lock()
+	f.attempts++
+	if resp != nil {
+		f.status = resp.StatusCode
+	}
+	f.mu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+		return resp, nil
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("%s: %s", req.URL.Redacted(), resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout &&
+		resp.StatusCode != http.StatusTooManyRequests {
+		return nil, permanentError{err}
+	}
+	return nil, err
+}
+
+// wait waits for the backoff before the given retry.
+func (f *httpFilter) wait(ctx context.Context, retry int) error {
+	d := f.backoff
+	for i := 1; i < retry; i++ {
+		d *= 2
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-f.done:
+		return iofl.Closed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// request makes the request, retrying failed attempts, and sets the body of
+// the response.
+func (f *httpFilter) request() error {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	var cancel context.CancelFunc
+	if f.timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, f.timeout)
+	} else {
+		ctx, cancel = context.WithCancel(ctx)
+	}
+	f.mu.Lock()
+	if f.closed {
+		f.mu.Unlock()
+		cancel()
+		return iofl.Closed
+	}
+	f.cancel = cancel
+	f.mu.Unlock()
+
+	for retry := 0; ; retry++ {
+		if retry > 0 {
+			if err := f.wait(ctx, retry); err != nil {
+				return err
+			}
+		}
+		resp, err := f.do(ctx)
+		if err == nil {
+			f.mu.Lock()
+			defer f.mu.Unlock()
+			if f.closed {
+				resp.Body.Close()
+				return iofl.Closed
+			}
+			f.body = resp.Body
+			return nil
+		}
+		var perm permanentError
+		if errors.As(err, &perm) {
+			return perm.err
+		}
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if retry >= f.retries {
+			if retry > 0 {
+				return fmt.Errorf("after %d attempts: %w", retry+1, err)
+			}
+			return err
+		}
+	}
+}
+
+// Read implements io.Reader.
+func (f *httpFilter) Read(p []byte) (n int, err error) {
+	if f.err != nil {
+		return 0, f.err
+	}
+	f.mu.Lock()
+	body, closed := f.body, f.closed
+	f.mu.Unlock()
+	if closed {
+		return 0, iofl.Closed
+	}
+	if body == nil {
+		if f.err = f.request(); f.err != nil {
+			return 0, f.err
+		}
+		f.mu.Lock()
+		body = f.body
+		f.mu.Unlock()
+	}
+	return body.Read(p)
+}
+
+// Close implements io.Closer, closing the response body, if any, and the
+// ignored source, if any.
+func (f *httpFilter) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	close(f.done)
+	if f.cancel != nil {
+		f.cancel()
+	}
+	var err error
+	if f.body != nil {
+		err = f.body.Close()
+	}
+	if f.src != nil {
+		if serr := f.src.Close(); err == nil {
+			err = serr
+		}
+	}
+	return err
+}
@@ -0,0 +1,296 @@
+package filters_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// failingServer starts an HTTP server that responds with status to the first
+// fails requests, and with content to the rest. Returns the server and the
+// number of requests made.
+func failingServer(t *testing.T, status, fails int, content string) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if int(atomic.AddInt32(&requests, 1)) <= fails {
+			w.WriteHeader(status)
+			return
+		}
+		io.WriteString(w, content)
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+func TestHTTP(t *testing.T) {
+	var header http.Header
+	var host string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header, host = r.Header, r.Host
+		io.WriteString(w, "body of "+r.URL.Path)
+	}))
+	defer s.Close()
+	src := &closeRecorder{Reader: strings.NewReader("ignored")}
+	f, err := filters.HTTP.New(iofl.Params{
+		"url": s.URL + "/file",
+		"headers": map[string]interface{}{
+			"Authorization": "Bearer token",
+			"X-List":        []interface{}{"a", "b"},
+			"Host":          "example.com",
+		},
+	}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != "body of /file" {
+		t.Errorf("got %q, want %q", out, "body of /file")
+	}
+	if got := header.Get("Authorization"); got != "Bearer token" {
+		t.Errorf("Authorization: got %q", got)
+	}
+	if got := strings.Join(header["X-List"], ","); got != "a,b" {
+		t.Errorf("X-List: got %q", got)
+	}
+	if host != "example.com" {
+		t.Errorf("Host: got %q", host)
+	}
+	report := f.(iofl.Reporter).Report()
+	if report["status"] != http.StatusOK || report["attempts"] != 1 {
+		t.Errorf("unexpected report %v", report)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if !src.closed {
+		t.Error("ignored source not closed")
+	}
+	if err := f.Close(); !errors.Is(err, iofl.Closed) {
+		t.Errorf("second close: got %v, want Closed", err)
+	}
+}
+
+func TestHTTPRetries(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		fails    int
+		retries  int
+		requests int32
+		err      string
+	}{
+		{http.StatusServiceUnavailable, 2, 2, 3, ""},
+		{http.StatusTooManyRequests, 1, 1, 2, ""},
+		{http.StatusRequestTimeout, 1, 1, 2, ""},
+		{http.StatusServiceUnavailable, 2, 1, 2, "after 2 attempts"},
+		{http.StatusServiceUnavailable, 1, 0, 1, "503"},
+		{http.StatusNotFound, 1, 3, 1, "404"},
+		{http.StatusUnauthorized, 1, 3, 1, "401"},
+	} {
+		t.Run(strconv.Itoa(test.status), func(t *testing.T) {
+			s, requests := failingServer(t, test.status, test.fails, "content")
+			out, err := readFilterFrom(t, filters.HTTP, iofl.Params{"url": s.URL, "retries": test.retries, "backoff": "1ms"}, nil)
+			if test.err == "" {
+				if err != nil || string(out) != "content" {
+					t.Errorf("got %q, %v", out, err)
+				}
+			} else if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("got error %v, want %q", err, test.err)
+			}
+			if n := atomic.LoadInt32(requests); n != test.requests {
+				t.Errorf("made %d requests, want %d", n, test.requests)
+			}
+		})
+	}
+}
+
+func TestHTTPBackoff(t *testing.T) {
+	s, _ := failingServer(t, http.StatusServiceUnavailable, 3, "content")
+	start := time.Now()
+	out, err := readFilterFrom(t, filters.HTTP, iofl.Params{"url": s.URL, "retries": 3, "backoff": "20ms"}, nil)
+	if err != nil || string(out) != "content" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	// Waits 20ms, then 40ms, then 80ms.
+	if d := time.Since(start); d < 140*time.Millisecond {
+		t.Errorf("took %v, want at least 140ms", d)
+	}
+}
+
+// blockingServer starts an HTTP server whose handlers block until the test
+// ends, after writing head, if any.
+func blockingServer(t *testing.T, head string) *httptest.Server {
+	t.Helper()
+	release := make(chan struct{})
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if head != "" {
+			io.WriteString(w, head)
+			w.(http.Flusher).Flush()
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	}))
+	t.Cleanup(func() {
+		close(release)
+		s.Close()
+	})
+	return s
+}
+
+func TestHTTPTimeout(t *testing.T) {
+	for _, head := range []string{"", "head"} {
+		s := blockingServer(t, head)
+		out, err := readFilterFrom(t, filters.HTTP, iofl.Params{"url": s.URL, "timeout": "50ms", "retries": 2, "backoff": "1ms"}, nil)
+		if !errors.Is(err, context.DeadlineExceeded) {
+			t.Errorf("head %q: got error %v, want deadline exceeded", head, err)
+		}
+		if string(out) != head {
+			t.Errorf("got %q, want %q", out, head)
+		}
+	}
+}
+
+func TestHTTPClose(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		url    func(t *testing.T) string
+		params iofl.Params
+	}{
+		{"Request", func(t *testing.T) string { return blockingServer(t, "").URL }, nil},
+		{"Body", func(t *testing.T) string { return blockingServer(t, "head").URL }, nil},
+		{"Backoff", func(t *testing.T) string {
+			s, _ := failingServer(t, http.StatusServiceUnavailable, 1, "")
+			return s.URL
+		}, iofl.Params{"retries": 1, "backoff": "1h"}},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			params := iofl.Params{"url": test.url(t)}
+			for k, v := range test.params {
+				params[k] = v
+			}
+			f, err := filters.HTTP.New(params, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			done := make(chan error)
+			go func() {
+				_, err := io.Copy(ioutil.Discard, f)
+				done <- err
+			}()
+			time.Sleep(50 * time.Millisecond)
+			if err := f.Close(); err != nil {
+				t.Fatal(err)
+			}
+			select {
+			case err := <-done:
+				if err == nil {
+					t.Error("expected error after close")
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("Close did not interrupt the pending read")
+			}
+			if _, err := f.Read(make([]byte, 1)); err == nil {
+				t.Error("expected error reading after close")
+			}
+		})
+	}
+}
+
+func TestHTTPContext(t *testing.T) {
+	s := blockingServer(t, "")
+	f, err := filters.HTTP.New(iofl.Params{"url": s.URL}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	f.(iofl.ContextFilter).SetContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := f.Read(make([]byte, 8)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestHTTPVars(t *testing.T) {
+	var auth string
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		auth = r.Header.Get("Authorization")
+		io.WriteString(w, r.URL.Path)
+	}))
+	defer s.Close()
+	set := iofl.NewChainSet()
+	if err := filters.Register(set); err != nil {
+		t.Fatal(err)
+	}
+	if err := set.Register(filters.HTTP); err != nil {
+		t.Fatal(err)
+	}
+	err := set.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"c": {
+			{Filter: "http", Params: iofl.Params{
+				"url":     "${base}/${name}",
+				"headers": map[string]interface{}{"Authorization": "Bearer ${token}"},
+			}},
+			{Filter: "hex"},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := set.ResolveVars("c", map[string]string{"base": s.URL, "name": "x", "token": "secret"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err := ioutil.ReadAll(f)
+	if err != nil || string(out) != "2f78" {
+		t.Errorf("got %q, %v", out, err)
+	}
+	if auth != "Bearer secret" {
+		t.Errorf("Authorization: got %q", auth)
+	}
+}
+
+func TestHTTPParams(t *testing.T) {
+	for _, test := range []struct {
+		params iofl.Params
+		err    string
+	}{
+		{iofl.Params{}, "url required"},
+		{iofl.Params{"url": "ftp://host/x"}, "unknown scheme"},
+		{iofl.Params{"url": "http:///x"}, "host required"},
+		{iofl.Params{"url": "http://host", "headers": "x"}, "expected map"},
+		{iofl.Params{"url": "http://host", "headers": map[string]interface{}{"A": 1}}, "A: expected string or list"},
+		{iofl.Params{"url": "http://host", "headers": map[string]interface{}{"A": []interface{}{"a", 1}}}, "A: expected string"},
+		{iofl.Params{"url": "http://host", "timeout": "-1s"}, "timeout"},
+		{iofl.Params{"url": "http://host", "retries": -1}, "retries"},
+		{iofl.Params{"url": "http://host", "backoff": "0s"}, "backoff"},
+		{iofl.Params{"url": "${base}/x"}, ""},
+		{iofl.Params{"url": "https://host/x", "headers": map[string]interface{}{"A": "a"}, "timeout": "1s", "retries": 2, "backoff": "10ms"}, ""},
+	} {
+		err := filters.HTTP.Validate(test.params)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.params, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got error %v, want %q", test.params, err, test.err)
+		}
+	}
+}
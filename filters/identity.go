@@ -0,0 +1,246 @@
+package filters
+
+import (
+	"io"
+	"sync/atomic"
+
+	"github.com/anaminus/iofl"
+)
+
+// Identity passes its source through unchanged. It is useful as a placeholder
+// in a chain, such as one whose links are selected by variables. The filter
+// has no params.
+//
+// The filter implements iofl.Framer, passing through the frames of the source.
+// If the source does not implement iofl.Framer, the entire source is treated
+// as one frame. The filter also implements iofl.Lender, lending the output of
+// the source directly if the source is an iofl.Lender, or from a buffer
+// otherwise. When used in a write chain, written content also passes through
+// unchanged.
+var Identity = iofl.FilterDef{
+	Name:         "identity",
+	New:          newIdentity,
+	NewWriter:    newIdentityWriter,
+	Description:  "Passes content through unchanged.",
+	StrictParams: true,
+}
+
+func newIdentity(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	filter := &identityFilter{}
+	filter.Reset(r)
+	return filter, nil
+}
+
+// identityFilter implements the Identity filter.
+type identityFilter struct {
+	src    io.ReadCloser
+	lender iofl.Lender
+	done   bool
+	frame  []byte
+	closed bool
+}
+
+// Source implements iofl.Filter.
+func (f *identityFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *identityFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if f.lender != nil {
+		// Content may have been buffered by the lender.
+		return iofl.LenderReader(f.lender).Read(p)
+	}
+	return f.src.Read(p)
+}
+
+// Next implements iofl.Lender.
+func (f *identityFilter) Next(n int) ([]byte, error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	if f.lender == nil {
+		f.lender = iofl.Lend(f.src, 0)
+	}
+	return f.lender.Next(n)
+}
+
+// ReadFrame implements iofl.Framer, returning the next frame of the source.
+func (f *identityFilter) ReadFrame() ([]byte, error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	if fr, ok := f.src.(iofl.Framer); ok {
+		return fr.ReadFrame()
+	}
+	if f.done {
+		return nil, io.EOF
+	}
+	f.done = true
+	var err error
+	f.frame, err = io.ReadAll(f.src)
+	return f.frame, err
+}
+
+// Close implements io.Closer, closing the source.
+func (f *identityFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *identityFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.lender = nil
+	f.done = false
+	f.frame = nil
+	f.closed = false
+	return nil
+}
+
+// PreservesSize implements iofl.SizePreserver.
+func (f *identityFilter) PreservesSize() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *identityFilter) MemoryUsage() int {
+	return cap(f.frame)
+}
+
+func newIdentityWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	return &identityWriter{dst: w}, nil
+}
+
+// identityWriter implements the Identity filter in a write chain.
+type identityWriter struct {
+	dst    io.WriteCloser
+	closed bool
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *identityWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// Write implements io.Writer.
+func (w *identityWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	return w.dst.Write(p)
+}
+
+// Close implements io.Closer, closing the sink.
+func (w *identityWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	return w.dst.Close()
+}
+
+// Discard consumes its source, and produces no content. It is useful for
+// measuring a chain, or for driving a chain whose filters produce side outputs.
+// The filter has no params.
+//
+// The source is consumed entirely by the first Read. The filter reports the
+// number of bytes consumed through the ByteCounter and iofl.Reporter
+// interfaces.
+var Discard = iofl.FilterDef{
+	Name:         "discard",
+	New:          newDiscard,
+	Description:  "Consumes content, producing nothing.",
+	StrictParams: true,
+}
+
+// ByteCounter is implemented by filters that count the bytes they consume.
+type ByteCounter interface {
+	// ByteCount returns the number of bytes consumed from the source since
+	// the filter was created or last reset. May be called concurrently with
+	// reading the filter.
+	ByteCount() int64
+}
+
+func newDiscard(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	filter := &discardFilter{}
+	filter.Reset(r)
+	return filter, nil
+}
+
+// discardFilter implements the Discard filter.
+type discardFilter struct {
+	src    io.ReadCloser
+	n      int64
+	buf    []byte
+	err    error
+	closed bool
+}
+
+// Source implements iofl.Filter.
+func (f *discardFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader, consuming the remainder of the source. Returns
+// io.EOF once the source is consumed.
+func (f *discardFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if f.buf == nil && f.err == nil {
+		f.buf = make([]byte, defaultBufferSize)
+	}
+	for f.err == nil {
+		n, f.err = f.src.Read(f.buf)
+		atomic.AddInt64(&f.n, int64(n))
+	}
+	f.buf = nil
+	return 0, f.err
+}
+
+// ByteCount implements ByteCounter.
+func (f *discardFilter) ByteCount() int64 {
+	return atomic.LoadInt64(&f.n)
+}
+
+// Report implements iofl.Reporter, reporting the number of bytes consumed as
+// "bytes".
+func (f *discardFilter) Report() map[string]interface{} {
+	return map[string]interface{}{"bytes": f.ByteCount()}
+}
+
+// Close implements io.Closer, closing the source.
+func (f *discardFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *discardFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	atomic.StoreInt64(&f.n, 0)
+	f.err = nil
+	f.closed = false
+	return nil
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *discardFilter) MemoryUsage() int {
+	return cap(f.buf)
+}
@@ -0,0 +1,138 @@
+package filters_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestIdentity(t *testing.T) {
+	in := []byte("passed through")
+	if out := mustRead(t, filters.Identity, nil, in); !bytes.Equal(out, in) {
+		t.Errorf("got %q", out)
+	}
+	if out, err := writeFilter(t, filters.Identity, nil, in); err != nil || !bytes.Equal(out, in) {
+		t.Errorf("writer: got %q, %v", out, err)
+	}
+
+	// An unframed source is one frame.
+	f, err := filters.Identity.New(nil, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fr := f.(iofl.Framer)
+	if frame, err := fr.ReadFrame(); err != nil || !bytes.Equal(frame, in) {
+		t.Errorf("got frame %q, %v", frame, err)
+	}
+	if _, err := fr.ReadFrame(); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+	f.Close()
+	if _, err := f.Read(make([]byte, 1)); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+
+	// Frames of the source pass through.
+	gz, err := filters.Gzip.New(nil, ioutil.NopCloser(bytes.NewReader(gzipRecords(t, "a", "bc"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err = filters.Identity.New(nil, gz)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"a", "bc"} {
+		if frame, err := f.(iofl.Framer).ReadFrame(); err != nil || string(frame) != want {
+			t.Errorf("got frame %q, %v, want %q", frame, err, want)
+		}
+	}
+	f.Close()
+
+	if _, err := filters.Identity.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
+
+func TestIdentityLender(t *testing.T) {
+	in := strings.Repeat("lent ", 100)
+	f, err := filters.Identity.New(nil, ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(in))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := f.(iofl.Lender).Next(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := string(b)
+	// Content buffered by the lender is returned by Read.
+	rest, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got+string(rest) != in {
+		t.Errorf("got %q", got+string(rest))
+	}
+
+	// A reset filter lends from the new source.
+	if err := f.(iofl.Resetter).Reset(ioutil.NopCloser(strings.NewReader("abc"))); err != nil {
+		t.Fatal(err)
+	}
+	if b, err := f.(iofl.Lender).Next(0); err != nil || string(b) != "abc" {
+		t.Errorf("after reset: got %q, %v", b, err)
+	}
+	f.Close()
+	if _, err := f.(iofl.Lender).Next(0); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
+
+func TestDiscard(t *testing.T) {
+	in := bytes.Repeat([]byte("x"), 100000)
+	f, err := filters.Discard.New(nil, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n, err := f.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("got %d, %v, want 0, EOF", n, err)
+	}
+	if n, err := f.Read(make([]byte, 10)); n != 0 || err != io.EOF {
+		t.Errorf("second read: got %d, %v", n, err)
+	}
+	if got := f.(filters.ByteCounter).ByteCount(); got != int64(len(in)) {
+		t.Errorf("got count %d, want %d", got, len(in))
+	}
+	if got := f.(iofl.Reporter).Report()["bytes"]; got != int64(len(in)) {
+		t.Errorf("got report %v", got)
+	}
+	if err := f.(iofl.Resetter).Reset(ioutil.NopCloser(strings.NewReader("abc"))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); err != nil {
+		t.Fatal(err)
+	}
+	if got := f.(filters.ByteCounter).ByteCount(); got != 3 {
+		t.Errorf("after reset: got count %d, want 3", got)
+	}
+	f.Close()
+	if err := f.Close(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+
+	errRead := errors.New("read failed")
+	f, err = filters.Discard.New(nil, ioutil.NopCloser(iotest.DataErrReader(iotest.ErrReader(errRead))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(nil); err != errRead {
+		t.Errorf("got %v, want source error", err)
+	}
+	f.Close()
+}
@@ -0,0 +1,675 @@
+package filters
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// This file contains the parts shared by the JWS and JWE filters. Both filters
+// support two formats:
+//
+//	compact: The entire stream is a single compact serialization. The content
+//	         is buffered in full, up to the max param.
+//	stream:  The content is divided into chunks, each a compact serialization
+//	         on its own line. The protected header of each chunk contains the
+//	         "iofl-id" member, a random identifier of the stream, the
+//	         "iofl-seq" member, the index of the chunk, and the "iofl-end"
+//	         member, true for the final chunk. Thus, reordering, truncating,
+//	         or extending the stream, or splicing in chunks of another stream
+//	         sealed with the same key, is detected.
+
+// Constants of the JOSE filters.
+const (
+	joseDefaultChunk = 64 << 10
+	joseMaxChunk     = 16 << 20
+	joseDefaultMax   = 64 << 20
+
+	joseID     = "iofl-id"
+	joseSeq    = "iofl-seq"
+	joseEnd    = "iofl-end"
+	joseIDSize = 16
+)
+
+// joseEncoding is the encoding of each part of a compact serialization.
+var joseEncoding = base64.RawURLEncoding
+
+// joseCodec produces and consumes compact serializations.
+type joseCodec interface {
+	// seal produces the compact serialization of payload, adding the members
+	// of extra to the protected header.
+	seal(payload []byte, extra map[string]interface{}) (token []byte, err error)
+	// open verifies or decrypts token, returning the payload and the protected
+	// header.
+	open(token []byte) (payload []byte, header map[string]interface{}, err error)
+}
+
+// joseParams contains the params shared by the JOSE filters.
+type joseParams struct {
+	// produce is whether the filter produces serializations, rather than
+	// consuming them.
+	produce bool
+	stream  bool
+	chunk   int
+	max     int
+	size    int
+	key     string
+	kid     string
+}
+
+// parseJOSE parses the params shared by the JOSE filters. The mode param is
+// one of consume (the default) or produce.
+func parseJOSE(params iofl.Params, consume, produce string) (p joseParams, err error) {
+	mode, err := getMode(params, consume, consume, produce)
+	if err != nil {
+		return p, err
+	}
+	p.produce = mode == produce
+	switch format := params.GetString("format"); format {
+	case "", "compact":
+	case "stream":
+		p.stream = true
+	default:
+		return p, fmt.Errorf("unknown format %q", format)
+	}
+	if p.chunk = params.GetInt("chunk"); p.chunk <= 0 {
+		p.chunk = joseDefaultChunk
+	} else if p.chunk > joseMaxChunk {
+		return p, fmt.Errorf("chunk %d exceeds maximum", p.chunk)
+	}
+	if p.max = params.GetInt("max"); p.max <= 0 {
+		p.max = joseDefaultMax
+	}
+	p.size = bufferSize(params)
+	if p.key = params.GetString("key"); p.key == "" {
+		return p, errors.New("key required")
+	}
+	if _, _, err := parseKeyRef(p.key); err != nil {
+		return p, err
+	}
+	p.kid = params.GetString("kid")
+	return p, nil
+}
+
+// joseTraits returns the traits of a JOSE filter configured by params.
+func joseTraits(params iofl.Params) iofl.Trait {
+	if params.GetString("format") == "stream" {
+		return 0
+	}
+	return iofl.BuffersAll
+}
+
+// joseCorrupt returns a corrupt error for a malformed serialization.
+func joseCorrupt(format string, v ...interface{}) error {
+	return &iofl.CorruptError{Err: fmt.Errorf(format, v...)}
+}
+
+// joseSplit splits token into n parts separated by periods.
+func joseSplit(token []byte, n int) ([][]byte, error) {
+	parts := bytes.Split(token, []byte{'.'})
+	if len(parts) != n {
+		return nil, joseCorrupt("expected %d parts, got %d", n, len(parts))
+	}
+	return parts, nil
+}
+
+// joseDecode decodes a base64url encoded part of a serialization.
+func joseDecode(part []byte, name string) ([]byte, error) {
+	b := make([]byte, joseEncoding.DecodedLen(len(part)))
+	n, err := joseEncoding.Decode(b, part)
+	if err != nil {
+		return nil, joseCorrupt("invalid encoding of %s", name)
+	}
+	return b[:n], nil
+}
+
+// joseHeader encodes a protected header containing the given members, along
+// with those of extra.
+func joseHeader(kid string, extra map[string]interface{}, members ...string) ([]byte, error) {
+	header := make(map[string]interface{}, len(members)/2+len(extra)+1)
+	for k, v := range extra {
+		header[k] = v
+	}
+	for i := 0; i+1 < len(members); i += 2 {
+		header[members[i]] = members[i+1]
+	}
+	if kid != "" {
+		header["kid"] = kid
+	}
+	b, err := json.Marshal(header)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]byte, joseEncoding.EncodedLen(len(b)))
+	joseEncoding.Encode(out, b)
+	return out, nil
+}
+
+// joseParseHeader decodes a protected header, and checks that each of the
+// given members has the expected value.
+func joseParseHeader(part []byte, members ...string) (header map[string]interface{}, err error) {
+	b, err := joseDecode(part, "header")
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &header); err != nil {
+		return nil, joseCorrupt("malformed header: %s", err)
+	}
+	if crit, ok := header["crit"]; ok {
+		return nil, joseCorrupt("unsupported critical header members %v", crit)
+	}
+	for i := 0; i+1 < len(members); i += 2 {
+		if v, _ := header[members[i]].(string); v != members[i+1] {
+			return nil, joseCorrupt("header %s: expected %q, got %q", members[i], members[i+1], v)
+		}
+	}
+	return header, nil
+}
+
+// joseJoin joins the parts of a compact serialization, encoding each part
+// after the first.
+func joseJoin(header []byte, parts ...[]byte) []byte {
+	n := len(header)
+	for _, p := range parts {
+		n += 1 + joseEncoding.EncodedLen(len(p))
+	}
+	token := make([]byte, len(header), n)
+	copy(token, header)
+	for _, p := range parts {
+		m := len(token) + 1
+		token = token[:m+joseEncoding.EncodedLen(len(p))]
+		token[m-1] = '.'
+		joseEncoding.Encode(token[m:], p)
+	}
+	return token
+}
+
+// parseJOSEKey parses a public or private key, encoded as DER or PEM. Private
+// keys may be PKCS #8, PKCS #1, or SEC 1. Public keys may be PKIX or PKCS #1,
+// or contained in a certificate.
+func parseJOSEKey(ref string) (key interface{}, err error) {
+	b, err := getKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(b); block != nil {
+		b = block.Bytes
+	}
+	if key, err = x509.ParsePKCS8PrivateKey(b); err == nil {
+		return key, nil
+	}
+	if key, err = x509.ParsePKCS1PrivateKey(b); err == nil {
+		return key, nil
+	}
+	if key, err = x509.ParseECPrivateKey(b); err == nil {
+		return key, nil
+	}
+	if key, err = x509.ParsePKIXPublicKey(b); err == nil {
+		return key, nil
+	}
+	if key, err = x509.ParsePKCS1PublicKey(b); err == nil {
+		return key, nil
+	}
+	if cert, err := x509.ParseCertificate(b); err == nil {
+		return cert.PublicKey, nil
+	}
+	return nil, fmt.Errorf("key %q: unsupported key format", ref)
+}
+
+// josePublicKey returns the public key of key if it is a private key, or key
+// otherwise.
+func josePublicKey(key interface{}) interface{} {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &k.PublicKey
+	case *ecdsa.PrivateKey:
+		return &k.PublicKey
+	case ed25519.PrivateKey:
+		return k.Public()
+	}
+	return key
+}
+
+// joseStream produces and consumes the chunks of the stream format.
+type joseStream struct {
+	codec joseCodec
+	id    string
+	seq   int
+	ended bool
+}
+
+// reset prepares the stream to produce or consume a new stream.
+func (s *joseStream) reset() {
+	s.id = ""
+	s.seq = 0
+	s.ended = false
+}
+
+// seal produces the serialization of the next chunk, followed by a line
+// break. The identifier of the stream is generated with the first chunk.
+func (s *joseStream) seal(chunk []byte, last bool) ([]byte, error) {
+	if s.id == "" {
+		id := make([]byte, joseIDSize)
+		if _, err := rand.Read(id); err != nil {
+			return nil, err
+		}
+		s.id = joseEncoding.EncodeToString(id)
+	}
+	extra := map[string]interface{}{joseID: s.id, joseSeq: s.seq}
+	if last {
+		extra[joseEnd] = true
+	}
+	token, err := s.codec.seal(chunk, extra)
+	if err != nil {
+		return nil, err
+	}
+	s.seq++
+	s.ended = last
+	return append(token, '\n'), nil
+}
+
+// open consumes the serialization of the next chunk from line, which has no
+// line break. Blank lines are ignored. Each chunk must have the identifier of
+// the first.
+func (s *joseStream) open(line []byte) ([]byte, error) {
+	line = bytes.TrimSpace(line)
+	if len(line) == 0 {
+		return nil, nil
+	}
+	if s.ended {
+		return nil, joseCorrupt("content after final chunk")
+	}
+	payload, header, err := s.codec.open(line)
+	if err != nil {
+		return nil, fmt.Errorf("chunk %d: %w", s.seq, err)
+	}
+	id, _ := header[joseID].(string)
+	if s.seq == 0 {
+		if id == "" {
+			return nil, joseCorrupt("chunk 0: missing stream identifier")
+		}
+		s.id = id
+	} else if id != s.id {
+		return nil, joseCorrupt("chunk %d: from another stream", s.seq)
+	}
+	if seq, _ := header[joseSeq].(float64); seq != float64(s.seq) {
+		return nil, joseCorrupt("chunk %d: out of sequence", s.seq)
+	}
+	s.seq++
+	s.ended, _ = header[joseEnd].(bool)
+	return payload, nil
+}
+
+// finish returns an error if the final chunk has not been consumed.
+func (s *joseStream) finish() error {
+	if !s.ended {
+		return joseCorrupt("truncated stream after %d chunks", s.seq)
+	}
+	return nil
+}
+
+// newJOSEFilter returns a filter that applies codec as configured by p.
+func newJOSEFilter(p joseParams, codec joseCodec, r io.ReadCloser) *joseFilter {
+	f := &joseFilter{params: p, stream: joseStream{codec: codec}}
+	f.Reset(r)
+	return f
+}
+
+// joseFilter implements the JOSE filters.
+type joseFilter struct {
+	src    io.ReadCloser
+	params joseParams
+	closed bool
+	budget *iofl.MemoryBudget
+
+	stream  joseStream
+	br      *bufio.Reader
+	buf     []byte
+	done    bool
+	pending []byte
+	err     error
+}
+
+// Source implements iofl.Filter.
+func (f *joseFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// grow ensures that buf has room for n more bytes, reserving memory from the
+// budget.
+func (f *joseFilter) grow(n int) error {
+	if len(f.buf)+n > f.params.max {
+		return fmt.Errorf("content exceeds max of %d bytes", f.params.max)
+	}
+	if cap(f.buf)-len(f.buf) >= n {
+		return nil
+	}
+	size := 2*cap(f.buf) + n
+	if size > f.params.max {
+		size = f.params.max
+	}
+	if err := f.budget.Resize(cap(f.buf), size); err != nil {
+		return err
+	}
+	buf := make([]byte, len(f.buf), size)
+	copy(buf, f.buf)
+	f.buf = buf
+	return nil
+}
+
+// readAll reads the remainder of the source into buf.
+func (f *joseFilter) readAll() error {
+	f.buf = f.buf[:0]
+	for {
+		if err := f.grow(bytes.MinRead); err != nil {
+			return err
+		}
+		n, err := f.br.Read(f.buf[len(f.buf):cap(f.buf)])
+		f.buf = f.buf[:len(f.buf)+n]
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+	}
+}
+
+// readChunk reads up to n bytes from the source into buf. Returns whether the
+// chunk is the last of the stream.
+func (f *joseFilter) readChunk(n int) (last bool, err error) {
+	f.buf = f.buf[:0]
+	if err := f.grow(n); err != nil {
+		return false, err
+	}
+	m, err := io.ReadFull(f.br, f.buf[:n])
+	f.buf = f.buf[:m]
+	switch err {
+	case nil:
+		if _, err := f.br.Peek(1); err == io.EOF {
+			return true, nil
+		} else if err != nil {
+			return false, err
+		}
+		return false, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return true, nil
+	}
+	return false, err
+}
+
+// readLine reads a line from the source into buf, without the line break.
+func (f *joseFilter) readLine() error {
+	f.buf = f.buf[:0]
+	for {
+		b, err := f.br.ReadSlice('\n')
+		if gerr := f.grow(len(b)); gerr != nil {
+			return gerr
+		}
+		f.buf = append(f.buf, b...)
+		switch err {
+		case bufio.ErrBufferFull:
+			continue
+		case nil:
+			f.buf = f.buf[:len(f.buf)-1]
+			return nil
+		case io.EOF:
+			if len(f.buf) > 0 {
+				return nil
+			}
+		}
+		return err
+	}
+}
+
+// next produces the next output of the filter.
+func (f *joseFilter) next() (err error) {
+	if f.done {
+		return io.EOF
+	}
+	switch p := f.params; {
+	case p.produce && p.stream:
+		last, err := f.readChunk(p.chunk)
+		if err != nil {
+			return err
+		}
+		f.pending, err = f.stream.seal(f.buf, last)
+		f.done = last
+		return err
+	case p.produce:
+		if err := f.readAll(); err != nil {
+			return err
+		}
+		f.pending, err = f.stream.codec.seal(f.buf, nil)
+		f.done = true
+		return err
+	case p.stream:
+		if err := f.readLine(); err == io.EOF {
+			f.done = true
+			return f.stream.finish()
+		} else if err != nil {
+			return err
+		}
+		f.pending, err = f.stream.open(f.buf)
+		return err
+	default:
+		if err := f.readAll(); err != nil {
+			return err
+		}
+		f.pending, _, err = f.stream.codec.open(bytes.TrimSpace(f.buf))
+		f.done = true
+		return err
+	}
+}
+
+// Read implements io.Reader.
+func (f *joseFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	for len(f.pending) == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		f.err = f.next()
+	}
+	n = copy(p, f.pending)
+	f.pending = f.pending[n:]
+	return n, nil
+}
+
+// Close implements io.Closer, closing the source.
+func (f *joseFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	f.budget.Free(cap(f.buf))
+	f.buf = nil
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *joseFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.stream.reset()
+	f.done = false
+	f.pending = nil
+	f.err = nil
+	if f.br == nil {
+		f.br = bufio.NewReaderSize(src, f.params.size)
+	} else {
+		f.br.Reset(src)
+	}
+	return nil
+}
+
+// SetMemoryBudget implements iofl.BudgetUser.
+func (f *joseFilter) SetMemoryBudget(b *iofl.MemoryBudget) {
+	f.budget = b
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *joseFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *joseFilter) MemoryUsage() int {
+	return f.br.Size() + cap(f.buf)
+}
+
+// joseWriter implements the JOSE filters in a write chain.
+type joseWriter struct {
+	dst    io.WriteCloser
+	params joseParams
+	closed bool
+	err    error
+
+	stream joseStream
+	// buf holds content that has not been processed. When producing the
+	// stream format, a full chunk is sealed only once more content is
+	// written, since the final chunk is sealed differently.
+	buf []byte
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *joseWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// process processes the complete chunks or lines of buf.
+func (w *joseWriter) process() error {
+	for {
+		var out []byte
+		var err error
+		if w.params.produce {
+			if len(w.buf) <= w.params.chunk {
+				return nil
+			}
+			out, err = w.stream.seal(w.buf[:w.params.chunk], false)
+			w.buf = w.buf[:copy(w.buf, w.buf[w.params.chunk:])]
+		} else {
+			i := bytes.IndexByte(w.buf, '\n')
+			if i < 0 {
+				return nil
+			}
+			out, err = w.stream.open(w.buf[:i])
+			w.buf = w.buf[:copy(w.buf, w.buf[i+1:])]
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := w.dst.Write(out); err != nil {
+			return err
+		}
+	}
+}
+
+// Write implements io.Writer.
+func (w *joseWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	if len(w.buf)+len(p) > w.params.max {
+		w.err = fmt.Errorf("content exceeds max of %d bytes", w.params.max)
+		return 0, w.err
+	}
+	w.buf = append(w.buf, p...)
+	if w.params.stream {
+		if w.err = w.process(); w.err != nil {
+			return 0, w.err
+		}
+	}
+	return len(p), nil
+}
+
+// Flush implements iofl.Flusher. When producing the stream format, the
+// buffered content is sealed as a chunk. Otherwise, content remains buffered
+// until the filter is closed.
+func (w *joseWriter) Flush() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	if w.err != nil {
+		return w.err
+	}
+	if !w.params.produce || !w.params.stream || len(w.buf) == 0 {
+		return nil
+	}
+	out, err := w.stream.seal(w.buf, false)
+	if err != nil {
+		w.err = err
+		return err
+	}
+	w.buf = w.buf[:0]
+	if _, w.err = w.dst.Write(out); w.err != nil {
+		return w.err
+	}
+	return nil
+}
+
+// finish processes the remaining content.
+func (w *joseWriter) finish() (err error) {
+	var out []byte
+	switch p := w.params; {
+	case p.produce && p.stream:
+		out, err = w.stream.seal(w.buf, true)
+	case p.produce:
+		out, err = w.stream.codec.seal(w.buf, nil)
+	case p.stream:
+		if out, err = w.stream.open(w.buf); err == nil {
+			err = w.stream.finish()
+		}
+	default:
+		out, _, err = w.stream.codec.open(bytes.TrimSpace(w.buf))
+	}
+	if err != nil {
+		return err
+	}
+	_, err = w.dst.Write(out)
+	return err
+}
+
+// Close implements io.Closer, processing the remaining content, and closing
+// the sink.
+func (w *joseWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	err := w.err
+	if err == nil {
+		err = w.finish()
+	}
+	w.buf = nil
+	if cerr := w.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (w *joseWriter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (w *joseWriter) MemoryUsage() int {
+	return cap(w.buf)
+}
@@ -0,0 +1,280 @@
+package filters_test
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// joseKeys contains the keys retrieved as "jose:name", generated on first use.
+var joseKeys struct {
+	once sync.Once
+	m    map[string][]byte
+}
+
+func init() {
+	filters.RegisterKeyProvider("jose", filters.KeyFunc(func(name string) ([]byte, error) {
+		joseKeys.once.Do(generateJOSEKeys)
+		key, ok := joseKeys.m[name]
+		if !ok {
+			return nil, fmt.Errorf("no key %q", name)
+		}
+		return key, nil
+	}))
+}
+
+// generateJOSEKeys generates a key of each kind, in various encodings. The
+// public key of a private key "name" is "name.pub".
+func generateJOSEKeys() {
+	m := map[string][]byte{"garbage": []byte("not a key")}
+	for name, size := range map[string]int{"hmac": 64, "a128": 16, "a192": 24, "a256": 32} {
+		m[name] = make([]byte, size)
+		rand.Read(m[name])
+	}
+	must := func(b []byte, err error) []byte {
+		if err != nil {
+			panic(err)
+		}
+		return b
+	}
+	public := func(name string, key interface{}) {
+		m[name+".pub"] = pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: must(x509.MarshalPKIXPublicKey(key))})
+	}
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+	m["rsa"] = must(x509.MarshalPKCS8PrivateKey(rsaKey))
+	m["rsa1"] = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(rsaKey)})
+	public("rsa", &rsaKey.PublicKey)
+	for name, curve := range map[string]elliptic.Curve{"p256": elliptic.P256(), "p384": elliptic.P384(), "p521": elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(curve, rand.Reader)
+		if err != nil {
+			panic(err)
+		}
+		m[name] = must(x509.MarshalECPrivateKey(key))
+		public(name, &key.PublicKey)
+	}
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		panic(err)
+	}
+	m["ed25519"] = must(x509.MarshalPKCS8PrivateKey(priv))
+	public("ed25519", pub)
+	joseKeys.m = m
+}
+
+// joseHeader decodes the protected header of a compact serialization.
+func joseHeader(t *testing.T, token []byte) map[string]interface{} {
+	t.Helper()
+	i := bytes.IndexByte(token, '.')
+	if i < 0 {
+		t.Fatalf("malformed token %q", token)
+	}
+	b, err := base64.RawURLEncoding.DecodeString(string(token[:i]))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var header map[string]interface{}
+	if err := json.Unmarshal(b, &header); err != nil {
+		t.Fatal(err)
+	}
+	return header
+}
+
+// joseStreamTests are the params of each filter that produce and consume the
+// stream format.
+var joseStreamTests = []struct {
+	def             iofl.FilterDef
+	produce, params iofl.Params
+}{
+	{
+		filters.JWS,
+		iofl.Params{"mode": "sign", "alg": "HS256", "key": "jose:hmac", "format": "stream", "chunk": 16},
+		iofl.Params{"alg": "HS256", "key": "jose:hmac", "format": "stream"},
+	},
+	{
+		filters.JWE,
+		iofl.Params{"mode": "encrypt", "alg": "dir", "key": "jose:a256", "format": "stream", "chunk": 16},
+		iofl.Params{"alg": "dir", "key": "jose:a256", "format": "stream"},
+	},
+}
+
+func TestJOSEStream(t *testing.T) {
+	for _, tt := range joseStreamTests {
+		for _, n := range []int{0, 1, 16, 17, 50} {
+			plain := bytes.Repeat([]byte{'s'}, n)
+			produced := mustRead(t, tt.def, tt.produce, plain)
+			lines := strings.Split(strings.TrimSuffix(string(produced), "\n"), "\n")
+			chunks := (n + 15) / 16
+			if chunks == 0 {
+				chunks = 1
+			}
+			if len(lines) != chunks {
+				t.Errorf("%s %d bytes: got %d chunks, want %d", tt.def.Name, n, len(lines), chunks)
+			}
+			id := joseHeader(t, []byte(lines[0]))["iofl-id"]
+			if s, _ := id.(string); s == "" {
+				t.Errorf("%s %d bytes: got stream identifier %v", tt.def.Name, n, id)
+			}
+			for i, line := range lines {
+				header := joseHeader(t, []byte(line))
+				if header["iofl-id"] != id || header["iofl-seq"] != float64(i) || (header["iofl-end"] == true) != (i == len(lines)-1) {
+					t.Errorf("%s %d bytes: chunk %d: got header %v", tt.def.Name, n, i, header)
+				}
+			}
+			if out := mustRead(t, tt.def, tt.params, produced); !bytes.Equal(out, plain) {
+				t.Errorf("%s %d bytes: round trip does not match input", tt.def.Name, n)
+			}
+		}
+	}
+}
+
+func TestJOSEStreamCorrupt(t *testing.T) {
+	for _, tt := range joseStreamTests {
+		plain := []byte(strings.Repeat("0123456789abcdef", 3) + "tail")
+		split := func(b []byte) []string {
+			lines := strings.SplitAfter(string(b), "\n")
+			return lines[:len(lines)-1]
+		}
+		lines := split(mustRead(t, tt.def, tt.produce, plain))
+		// Another stream of the same content, sealed with the same key.
+		other := split(mustRead(t, tt.def, tt.produce, plain))
+		join := func(order ...int) []byte {
+			var b []byte
+			for _, i := range order {
+				b = append(b, lines[i]...)
+			}
+			return b
+		}
+		for name, in := range map[string][]byte{
+			"reordered":     join(1, 0, 2, 3),
+			"dropped first": join(1, 2, 3),
+			"dropped last":  join(0, 1, 2),
+			"duplicated":    join(0, 1, 1, 2, 3),
+			"extended":      join(0, 1, 2, 3, 3),
+			"empty":         nil,
+			"tampered":      append(join(0, 1, 2), strings.Replace(lines[3], ".", ".A", 1)...),
+			"spliced":       append(append(join(0), other[1]...), join(2, 3)...),
+			"swapped first": append([]byte(other[0]), join(1, 2, 3)...),
+			"swapped last":  append(join(0, 1, 2), other[3]...),
+		} {
+			if _, err := readFilter(t, tt.def, tt.params, in); !iofl.IsCorrupt(err) {
+				t.Errorf("%s %s: got %v, want corrupt", tt.def.Name, name, err)
+			}
+		}
+
+		// Chunks preceding a failure are produced, and blank lines are
+		// ignored.
+		out, err := readFilter(t, tt.def, tt.params, append(join(0, 1), "\n\n"...))
+		if !iofl.IsCorrupt(err) || !bytes.Equal(out, plain[:32]) {
+			t.Errorf("%s: got %q, %v", tt.def.Name, out, err)
+		}
+	}
+}
+
+func TestJOSEWriter(t *testing.T) {
+	plain := []byte(strings.Repeat("written ", 10))
+	for _, tt := range joseStreamTests {
+		// A writer applies the inverse of mode.
+		produced, err := writeFilter(t, tt.def, tt.params, plain)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if out := mustRead(t, tt.def, tt.params, produced); !bytes.Equal(out, plain) {
+			t.Errorf("%s: got %q", tt.def.Name, out)
+		}
+		out, err := writeFilter(t, tt.def, tt.produce, produced)
+		if err != nil || !bytes.Equal(out, plain) {
+			t.Errorf("%s: got %q, %v", tt.def.Name, out, err)
+		}
+
+		// A flush seals the buffered content as a chunk.
+		var buf bytes.Buffer
+		w, err := tt.def.NewWriter(tt.params, nopWriteCloser{&buf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("abc"))
+		if err := w.(iofl.Flusher).Flush(); err != nil {
+			t.Fatal(err)
+		}
+		if n := bytes.Count(buf.Bytes(), []byte("\n")); n != 1 {
+			t.Errorf("%s: got %d chunks after flush", tt.def.Name, n)
+		}
+		w.Write([]byte("def"))
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if out := mustRead(t, tt.def, tt.params, buf.Bytes()); string(out) != "abcdef" {
+			t.Errorf("%s: got %q", tt.def.Name, out)
+		}
+		if err := w.(iofl.Flusher).Flush(); err != iofl.Closed {
+			t.Errorf("%s: flush after close: got %v, want Closed", tt.def.Name, err)
+		}
+
+		// The compact format remains buffered until the writer is closed.
+		buf.Reset()
+		w, err = tt.def.NewWriter(withParams(tt.params, "format", "compact"), nopWriteCloser{&buf})
+		if err != nil {
+			t.Fatal(err)
+		}
+		w.Write([]byte("abc"))
+		if err := w.(iofl.Flusher).Flush(); err != nil || buf.Len() != 0 {
+			t.Errorf("%s: compact flush wrote %d bytes, %v", tt.def.Name, buf.Len(), err)
+		}
+		if err := w.Close(); err != nil || buf.Len() == 0 {
+			t.Errorf("%s: compact close wrote %d bytes, %v", tt.def.Name, buf.Len(), err)
+		}
+
+		// Content beyond max is rejected.
+		_, err = writeFilter(t, tt.def, withParams(tt.params, "format", "compact", "max", 10), plain)
+		if err == nil {
+			t.Errorf("%s: expected error for content beyond max", tt.def.Name)
+		}
+		if _, err := readFilter(t, tt.def, withParams(tt.produce, "format", "compact", "max", 10), plain); err == nil {
+			t.Errorf("%s: expected error for source beyond max", tt.def.Name)
+		}
+	}
+}
+
+func TestJOSEParams(t *testing.T) {
+	for _, def := range []iofl.FilterDef{filters.JWS, filters.JWE} {
+		for _, params := range []iofl.Params{
+			{"alg": "dir"},
+			{"alg": "dir", "key": "nocolon"},
+			{"alg": "dir", "key": "missing:k"},
+			{"alg": "dir", "key": "jose:a256", "format": "json"},
+			{"alg": "dir", "key": "jose:a256", "chunk": 32 << 20},
+			{"alg": "dir", "key": "jose:a256", "mode": "seal"},
+		} {
+			if err := def.Validate(params); err == nil {
+				t.Errorf("%s %v: expected error", def.Name, params)
+			}
+		}
+		if _, err := def.New(nil, nil); err == nil {
+			t.Errorf("%s: expected error without source", def.Name)
+		}
+		if got := def.Traits(iofl.Params{"format": "stream"}) & iofl.BuffersAll; got != 0 {
+			t.Errorf("%s: stream format buffers all", def.Name)
+		}
+		if got := def.Traits(nil) & iofl.BuffersAll; got == 0 {
+			t.Errorf("%s: compact format does not buffer all", def.Name)
+		}
+	}
+}
@@ -0,0 +1,370 @@
+package filters
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// JWE encrypts or decrypts content as a JSON Web Encryption (RFC 7516).
+// Params:
+//
+//	mode:   "decrypt" (default) or "encrypt".
+//	alg:    The key management algorithm. One of the following:
+//	        "dir":          The key is used directly as the content
+//	                        encryption key.
+//	        "A128KW", "A192KW", "A256KW":
+//	                        A random content encryption key is wrapped with
+//	                        the key, using AES Key Wrap.
+//	        "RSA-OAEP", "RSA-OAEP-256":
+//	                        A random content encryption key is encrypted
+//	                        with the RSA key, using RSAES-OAEP.
+//	        Required.
+//	enc:    The content encryption algorithm. "A256GCM" (default), "A192GCM",
+//	        or "A128GCM".
+//	key:    The key, as a reference of the form "scheme:name", retrieved from
+//	        the KeyProvider registered for the scheme. Symmetric algorithms use
+//	        the raw bytes of the key. RSA algorithms use a public or private
+//	        key for encrypting, and a private key for decrypting, encoded as
+//	        DER or PEM. Required.
+//	kid:    The key ID included in the header when encrypting.
+//	format: "compact" (default) or "stream".
+//	chunk:  The size of the plaintext of each chunk when encrypting the stream
+//	        format, in bytes. Defaults to 64KiB.
+//	max:    The maximum size of buffered content, in bytes. Defaults to
+//	        64MiB.
+//
+// With the compact format, the content is a single JWE Compact Serialization,
+// and is buffered in full. With the stream format, the content is divided into
+// chunks, each encrypted individually and serialized on its own line, so that
+// content can be produced without buffering the entire stream. The protected
+// header of each chunk contains the "iofl-id" member, a random identifier of
+// the stream, the "iofl-seq" member, the index of the chunk, and the
+// "iofl-end" member, true for the final chunk. When decrypting, a
+// chunk is produced only once it has been authenticated, but the chunks
+// preceding a failure will have been produced.
+//
+// The alg and enc members of each header must match the params. Headers with
+// the crit or zip members are rejected. Authentication failures are corrupt
+// errors. When used in a write chain, the filter encrypts written content if
+// mode is "decrypt", and decrypts it if mode is "encrypt".
+var JWE = iofl.FilterDef{
+	Name:         "jwe",
+	New:          newJWE,
+	NewWriter:    newJWEWriter,
+	Validate:     validateJWE,
+	Description:  "Encrypts or decrypts content as a JSON Web Encryption.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decrypt\" or \"encrypt\".", Default: "decrypt"},
+		{Name: "alg", Type: iofl.ParamString, Required: true, Description: "The key management algorithm, such as \"dir\" or \"A256KW\"."},
+		{Name: "enc", Type: iofl.ParamString, Description: "The content encryption algorithm.", Default: "A256GCM"},
+		{Name: "key", Type: iofl.ParamString, Required: true, Description: "A reference to the key, of the form \"scheme:name\"."},
+		{Name: "kid", Type: iofl.ParamString, Description: "The key ID included in the header when encrypting."},
+		{Name: "format", Type: iofl.ParamString, Description: "\"compact\" or \"stream\".", Default: "compact"},
+		{Name: "chunk", Type: iofl.ParamInt, Description: "The size of the plaintext of each chunk of the stream format, in bytes.", Default: "64KiB"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of buffered content, in bytes.", Default: "64MiB"},
+	},
+	Traits: func(params iofl.Params) iofl.Trait {
+		t := joseTraits(params)
+		if params.GetString("mode") == "encrypt" {
+			return t | iofl.Encrypts
+		}
+		return t | iofl.Decrypts
+	},
+}
+
+// jweEncs maps each content encryption algorithm to its key size.
+var jweEncs = map[string]int{
+	"A128GCM": 16,
+	"A192GCM": 24,
+	"A256GCM": 32,
+}
+
+// jweKWs maps each AES Key Wrap algorithm to its key size.
+var jweKWs = map[string]int{
+	"A128KW": 16,
+	"A192KW": 24,
+	"A256KW": 32,
+}
+
+// jweOAEPs maps each RSAES-OAEP algorithm to its hash.
+var jweOAEPs = map[string]func() hash.Hash{
+	"RSA-OAEP":     sha1.New,
+	"RSA-OAEP-256": sha256.New,
+}
+
+func parseJWE(params iofl.Params) (p joseParams, alg, enc string, err error) {
+	if p, err = parseJOSE(params, "decrypt", "encrypt"); err != nil {
+		return p, "", "", err
+	}
+	alg = params.GetString("alg")
+	if alg == "" {
+		return p, "", "", errors.New("alg required")
+	}
+	_, kw := jweKWs[alg]
+	_, oaep := jweOAEPs[alg]
+	if alg != "dir" && !kw && !oaep {
+		return p, "", "", fmt.Errorf("unknown alg %q", alg)
+	}
+	if enc = params.GetString("enc"); enc == "" {
+		enc = "A256GCM"
+	}
+	if _, ok := jweEncs[enc]; !ok {
+		return p, "", "", fmt.Errorf("unknown enc %q", enc)
+	}
+	return p, alg, enc, nil
+}
+
+func validateJWE(params iofl.Params) error {
+	_, _, _, err := parseJWE(params)
+	return err
+}
+
+// jweCodec implements joseCodec for JWE.
+type jweCodec struct {
+	alg string
+	enc string
+	kid string
+	// key is the raw key for symmetric algorithms, or the parsed key
+	// otherwise.
+	key interface{}
+}
+
+// newJWECodec returns a codec for alg and enc, with a key suitable for
+// encrypting if encrypt is true, and for decrypting otherwise.
+func newJWECodec(alg, enc, ref, kid string, encrypt bool) (*jweCodec, error) {
+	c := &jweCodec{alg: alg, enc: enc, kid: kid}
+	if _, ok := jweOAEPs[alg]; ok {
+		key, err := parseJOSEKey(ref)
+		if err != nil {
+			return nil, err
+		}
+		if encrypt {
+			if c.key, ok = josePublicKey(key).(*rsa.PublicKey); !ok {
+				return nil, fmt.Errorf("key %q: not an RSA key", ref)
+			}
+		} else if c.key, ok = key.(*rsa.PrivateKey); !ok {
+			return nil, fmt.Errorf("key %q: not an RSA private key", ref)
+		}
+		return c, nil
+	}
+	key, err := getKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	size := jweEncs[enc]
+	if alg != "dir" {
+		size = jweKWs[alg]
+	}
+	if len(key) != size {
+		return nil, fmt.Errorf("key %q: %s requires a %d-byte key", ref, alg, size)
+	}
+	c.key = key
+	return c, nil
+}
+
+// wrap returns the encrypted key and the content encryption key of a new
+// message.
+func (c *jweCodec) wrap() (encryptedKey, cek []byte, err error) {
+	if c.alg == "dir" {
+		return nil, c.key.([]byte), nil
+	}
+	cek = make([]byte, jweEncs[c.enc])
+	if _, err := rand.Read(cek); err != nil {
+		return nil, nil, err
+	}
+	if h, ok := jweOAEPs[c.alg]; ok {
+		encryptedKey, err = rsa.EncryptOAEP(h(), rand.Reader, c.key.(*rsa.PublicKey), cek, nil)
+		return encryptedKey, cek, err
+	}
+	encryptedKey, err = aesKeyWrap(c.key.([]byte), cek)
+	return encryptedKey, cek, err
+}
+
+// unwrap returns the content encryption key of a message from its encrypted
+// key.
+func (c *jweCodec) unwrap(encryptedKey []byte) (cek []byte, err error) {
+	if c.alg == "dir" {
+		if len(encryptedKey) != 0 {
+			return nil, joseCorrupt("unexpected encrypted key")
+		}
+		return c.key.([]byte), nil
+	}
+	if h, ok := jweOAEPs[c.alg]; ok {
+		cek, err = rsa.DecryptOAEP(h(), nil, c.key.(*rsa.PrivateKey), encryptedKey, nil)
+	} else {
+		cek, err = aesKeyUnwrap(c.key.([]byte), encryptedKey)
+	}
+	if err != nil || len(cek) != jweEncs[c.enc] {
+		return nil, errAuthentication
+	}
+	return cek, nil
+}
+
+func (c *jweCodec) seal(payload []byte, extra map[string]interface{}) (token []byte, err error) {
+	header, err := joseHeader(c.kid, extra, "alg", c.alg, "enc", c.enc)
+	if err != nil {
+		return nil, err
+	}
+	encryptedKey, cek, err := c.wrap()
+	if err != nil {
+		return nil, err
+	}
+	aead, err := newJWEAEAD(cek)
+	if err != nil {
+		return nil, err
+	}
+	iv := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(iv); err != nil {
+		return nil, err
+	}
+	sealed := aead.Seal(nil, iv, payload, header)
+	tag := len(sealed) - aead.Overhead()
+	return joseJoin(header, encryptedKey, iv, sealed[:tag], sealed[tag:]), nil
+}
+
+func (c *jweCodec) open(token []byte) (payload []byte, header map[string]interface{}, err error) {
+	parts, err := joseSplit(token, 5)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header, err = joseParseHeader(parts[0], "alg", c.alg, "enc", c.enc); err != nil {
+		return nil, nil, err
+	}
+	if _, ok := header["zip"]; ok {
+		return nil, nil, joseCorrupt("unsupported header zip")
+	}
+	var dec [4][]byte
+	for i, name := range [...]string{"encrypted key", "initialization vector", "ciphertext", "authentication tag"} {
+		if dec[i], err = joseDecode(parts[i+1], name); err != nil {
+			return nil, nil, err
+		}
+	}
+	cek, err := c.unwrap(dec[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	aead, err := newJWEAEAD(cek)
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(dec[1]) != aead.NonceSize() || len(dec[3]) != aead.Overhead() {
+		return nil, nil, errAuthentication
+	}
+	payload, err = aead.Open(nil, dec[1], append(dec[2], dec[3]...), parts[0])
+	if err != nil {
+		return nil, nil, errAuthentication
+	}
+	return payload, header, nil
+}
+
+// newJWEAEAD returns the AES-GCM AEAD of a content encryption key.
+func newJWEAEAD(cek []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// aesKeyWrapIV is the initial value of AES Key Wrap.
+const aesKeyWrapIV = 0xA6A6A6A6A6A6A6A6
+
+// aesKeyWrap wraps key with kek, as described by RFC 3394.
+func aesKeyWrap(kek, key []byte) ([]byte, error) {
+	if len(key)%8 != 0 || len(key) < 16 {
+		return nil, errors.New("key wrap: invalid key size")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(key) / 8
+	out := make([]byte, 8+len(key))
+	copy(out[8:], key)
+	var b [16]byte
+	binary.BigEndian.PutUint64(b[:8], aesKeyWrapIV)
+	for j := 0; j < 6; j++ {
+		for i := 1; i <= n; i++ {
+			copy(b[8:], out[i*8:])
+			block.Encrypt(b[:], b[:])
+			t := binary.BigEndian.Uint64(b[:8]) ^ uint64(n*j+i)
+			binary.BigEndian.PutUint64(b[:8], t)
+			copy(out[i*8:], b[8:])
+		}
+	}
+	copy(out, b[:8])
+	return out, nil
+}
+
+// aesKeyUnwrap unwraps a key wrapped with kek, as described by RFC 3394.
+func aesKeyUnwrap(kek, wrapped []byte) ([]byte, error) {
+	if len(wrapped)%8 != 0 || len(wrapped) < 24 {
+		return nil, errors.New("key wrap: invalid wrapped key size")
+	}
+	block, err := aes.NewCipher(kek)
+	if err != nil {
+		return nil, err
+	}
+	n := len(wrapped)/8 - 1
+	out := make([]byte, len(wrapped))
+	copy(out, wrapped)
+	var b [16]byte
+	copy(b[:8], out[:8])
+	for j := 5; j >= 0; j-- {
+		for i := n; i >= 1; i-- {
+			t := binary.BigEndian.Uint64(b[:8]) ^ uint64(n*j+i)
+			binary.BigEndian.PutUint64(b[:8], t)
+			copy(b[8:], out[i*8:])
+			block.Decrypt(b[:], b[:])
+			copy(out[i*8:], b[8:])
+		}
+	}
+	var iv [8]byte
+	binary.BigEndian.PutUint64(iv[:], aesKeyWrapIV)
+	if subtle.ConstantTimeCompare(b[:8], iv[:]) != 1 {
+		return nil, errors.New("key wrap: integrity check failed")
+	}
+	return out[8:], nil
+}
+
+func newJWE(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	p, alg, enc, err := parseJWE(params)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newJWECodec(alg, enc, p.key, p.kid, p.produce)
+	if err != nil {
+		return nil, err
+	}
+	return newJOSEFilter(p, codec, r), nil
+}
+
+// newJWEWriter returns a writer that applies the inverse of the mode param.
+func newJWEWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	p, alg, enc, err := parseJWE(params)
+	if err != nil {
+		return nil, err
+	}
+	p.produce = !p.produce
+	codec, err := newJWECodec(alg, enc, p.key, p.kid, p.produce)
+	if err != nil {
+		return nil, err
+	}
+	return &joseWriter{dst: w, params: p, stream: joseStream{codec: codec}}, nil
+}
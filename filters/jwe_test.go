@@ -0,0 +1,142 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// jweKeys pairs each combination of algorithms with the keys used to encrypt
+// and decrypt.
+var jweKeys = []struct {
+	alg, enc, encrypt, decrypt string
+}{
+	{"dir", "A256GCM", "a256", "a256"},
+	{"dir", "A192GCM", "a192", "a192"},
+	{"dir", "A128GCM", "a128", "a128"},
+	{"A128KW", "A256GCM", "a128", "a128"},
+	{"A192KW", "A128GCM", "a192", "a192"},
+	{"A256KW", "A192GCM", "a256", "a256"},
+	{"RSA-OAEP", "A256GCM", "rsa.pub", "rsa"},
+	{"RSA-OAEP-256", "A128GCM", "rsa", "rsa1"},
+}
+
+func TestJWERoundTrip(t *testing.T) {
+	plain := []byte("encrypted content")
+	for _, k := range jweKeys {
+		encrypt := iofl.Params{"mode": "encrypt", "alg": k.alg, "enc": k.enc, "key": "jose:" + k.encrypt, "kid": "k1"}
+		decrypt := iofl.Params{"alg": k.alg, "enc": k.enc, "key": "jose:" + k.decrypt}
+		token := mustRead(t, filters.JWE, encrypt, plain)
+		parts := strings.Split(string(token), ".")
+		if len(parts) != 5 {
+			t.Fatalf("%s %s: got %d parts", k.alg, k.enc, len(parts))
+		}
+		if (k.alg == "dir") != (parts[1] == "") {
+			t.Errorf("%s %s: got encrypted key %q", k.alg, k.enc, parts[1])
+		}
+		if header := joseHeader(t, token); header["alg"] != k.alg || header["enc"] != k.enc || header["kid"] != "k1" {
+			t.Errorf("%s %s: got header %v", k.alg, k.enc, header)
+		}
+		out, err := readFilter(t, filters.JWE, decrypt, token)
+		if err != nil || !bytes.Equal(out, plain) {
+			t.Errorf("%s %s: got %q, %v", k.alg, k.enc, out, err)
+		}
+		if bytes.Equal(token, mustRead(t, filters.JWE, encrypt, plain)) {
+			t.Errorf("%s %s: encryptions are identical", k.alg, k.enc)
+		}
+	}
+}
+
+func TestJWECorrupt(t *testing.T) {
+	for _, k := range jweKeys {
+		if k.enc != "A256GCM" || k.alg == "RSA-OAEP" {
+			continue
+		}
+		encrypt := iofl.Params{"mode": "encrypt", "alg": k.alg, "key": "jose:" + k.encrypt}
+		decrypt := iofl.Params{"alg": k.alg, "key": "jose:" + k.decrypt}
+		token := string(mustRead(t, filters.JWE, encrypt, []byte("encrypted content")))
+		parts := strings.Split(token, ".")
+		replace := func(i int, part string) []byte {
+			p := append([]string{}, parts...)
+			p[i] = part
+			return []byte(strings.Join(p, "."))
+		}
+		// flip flips a bit of the first byte of part i.
+		flip := func(i int) []byte {
+			b, err := base64.RawURLEncoding.DecodeString(parts[i])
+			if err != nil || len(b) == 0 {
+				t.Fatalf("part %d: %v", i, err)
+			}
+			b[0] ^= 1
+			return replace(i, base64.RawURLEncoding.EncodeToString(b))
+		}
+		enc := func(s string) string {
+			return base64.RawURLEncoding.EncodeToString([]byte(s))
+		}
+		tests := map[string][]byte{
+			"header":      replace(0, enc(`{"alg":"`+k.alg+`","enc":"A256GCM","x":1}`)),
+			"enc":         replace(0, enc(`{"alg":"`+k.alg+`","enc":"A128GCM"}`)),
+			"zip":         replace(0, enc(`{"alg":"`+k.alg+`","enc":"A256GCM","zip":"DEF"}`)),
+			"iv":          flip(2),
+			"iv size":     replace(2, enc("short")),
+			"ciphertext":  flip(3),
+			"tag":         flip(4),
+			"tag size":    replace(4, parts[4][:8]),
+			"four parts":  []byte(strings.Join(parts[:4], ".")),
+			"iv encoding": replace(2, "!"),
+		}
+		if k.alg == "dir" {
+			tests["encrypted key"] = replace(1, enc("key"))
+		} else {
+			tests["encrypted key"] = flip(1)
+		}
+		for name, in := range tests {
+			if _, err := readFilter(t, filters.JWE, decrypt, in); !iofl.IsCorrupt(err) {
+				t.Errorf("%s %s: got %v, want corrupt", k.alg, name, err)
+			}
+		}
+	}
+
+	// A different key fails to authenticate.
+	token := mustRead(t, filters.JWE, iofl.Params{"mode": "encrypt", "alg": "dir", "key": "jose:a256"}, []byte("x"))
+	if _, err := readFilter(t, filters.JWE, iofl.Params{"alg": "dir", "key": "test:k"}, token); !iofl.IsCorrupt(err) {
+		t.Errorf("wrong key: got %v, want corrupt", err)
+	}
+}
+
+func TestJWEParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"key": "jose:a256"},
+		{"alg": "A512KW", "key": "jose:a256"},
+		{"alg": "dir", "enc": "A256CBC-HS512", "key": "jose:a256"},
+	} {
+		if err := filters.JWE.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	// Keys are checked when the filter is constructed.
+	for _, params := range []iofl.Params{
+		{"alg": "dir", "key": "jose:a128"},
+		{"alg": "dir", "enc": "A128GCM", "key": "jose:a256"},
+		{"alg": "A128KW", "key": "jose:a256"},
+		{"alg": "RSA-OAEP", "key": "jose:rsa.pub"},
+		{"alg": "RSA-OAEP", "key": "jose:p256"},
+		{"mode": "encrypt", "alg": "RSA-OAEP", "key": "jose:ed25519.pub"},
+		{"alg": "RSA-OAEP", "key": "jose:garbage"},
+	} {
+		if _, err := filters.JWE.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error from New", params)
+		}
+	}
+	if got := filters.JWE.Traits(iofl.Params{"mode": "encrypt"}); got&iofl.Encrypts == 0 {
+		t.Errorf("encrypt: got traits %v", got)
+	}
+	if got := filters.JWE.Traits(nil); got&iofl.Decrypts == 0 {
+		t.Errorf("decrypt: got traits %v", got)
+	}
+}
@@ -0,0 +1,297 @@
+package filters
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/anaminus/iofl"
+)
+
+// JWS signs or verifies content as a JSON Web Signature (RFC 7515). Params:
+//
+//	mode:   "verify" (default) or "sign".
+//	alg:    The signature algorithm. One of HS256, HS384, HS512, RS256,
+//	        RS384, RS512, PS256, PS384, PS512, ES256, ES384, ES512, or EdDSA.
+//	        Required.
+//	key:    The key, as a reference of the form "scheme:name", retrieved from
+//	        the KeyProvider registered for the scheme. HMAC algorithms use the
+//	        raw bytes of the key. Other algorithms use a private key for
+//	        signing, and a public or private key for verifying, encoded as DER
+//	        or PEM. Required.
+//	kid:    The key ID included in the header when signing.
+//	format: "compact" (default) or "stream".
+//	chunk:  The size of the payload of each chunk when signing the stream
+//	        format, in bytes. Defaults to 64KiB.
+//	max:    The maximum size of buffered content, in bytes. Defaults to
+//	        64MiB.
+//
+// With the compact format, the content is a single JWS Compact Serialization,
+// and is buffered in full. With the stream format, the content is divided into
+// chunks, each signed individually and serialized on its own line, so that
+// content can be produced without buffering the entire stream. The protected
+// header of each chunk contains the "iofl-id" member, a random identifier of
+// the stream, the "iofl-seq" member, the index of the chunk, and the
+// "iofl-end" member, true for the final chunk. When verifying, a chunk
+// is produced only once it has been verified, but the chunks preceding a
+// failure will have been produced.
+//
+// The alg member of each header must match the alg param. Headers with the
+// crit member are rejected. Verification failures are corrupt errors. When
+// used in a write chain, the filter signs written content if mode is
+// "verify", and verifies it if mode is "sign".
+var JWS = iofl.FilterDef{
+	Name:         "jws",
+	New:          newJWS,
+	NewWriter:    newJWSWriter,
+	Validate:     validateJWS,
+	Description:  "Signs or verifies content as a JSON Web Signature.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"verify\" or \"sign\".", Default: "verify"},
+		{Name: "alg", Type: iofl.ParamString, Required: true, Description: "The signature algorithm, such as \"HS256\" or \"ES256\"."},
+		{Name: "key", Type: iofl.ParamString, Required: true, Description: "A reference to the key, of the form \"scheme:name\"."},
+		{Name: "kid", Type: iofl.ParamString, Description: "The key ID included in the header when signing."},
+		{Name: "format", Type: iofl.ParamString, Description: "\"compact\" or \"stream\".", Default: "compact"},
+		{Name: "chunk", Type: iofl.ParamInt, Description: "The size of the payload of each chunk of the stream format, in bytes.", Default: "64KiB"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of buffered content, in bytes.", Default: "64MiB"},
+	},
+	Traits: joseTraits,
+}
+
+// errSignature is returned when a signature fails to verify.
+var errSignature error = &iofl.CorruptError{Err: errors.New("signature verification failed")}
+
+// jwsAlg describes a JWS signature algorithm.
+type jwsAlg struct {
+	hash crypto.Hash
+	// kind is "hmac", "rsa", "pss", "ecdsa", or "eddsa".
+	kind  string
+	curve elliptic.Curve
+}
+
+var jwsAlgs = map[string]jwsAlg{
+	"HS256": {hash: crypto.SHA256, kind: "hmac"},
+	"HS384": {hash: crypto.SHA384, kind: "hmac"},
+	"HS512": {hash: crypto.SHA512, kind: "hmac"},
+	"RS256": {hash: crypto.SHA256, kind: "rsa"},
+	"RS384": {hash: crypto.SHA384, kind: "rsa"},
+	"RS512": {hash: crypto.SHA512, kind: "rsa"},
+	"PS256": {hash: crypto.SHA256, kind: "pss"},
+	"PS384": {hash: crypto.SHA384, kind: "pss"},
+	"PS512": {hash: crypto.SHA512, kind: "pss"},
+	"ES256": {hash: crypto.SHA256, kind: "ecdsa", curve: elliptic.P256()},
+	"ES384": {hash: crypto.SHA384, kind: "ecdsa", curve: elliptic.P384()},
+	"ES512": {hash: crypto.SHA512, kind: "ecdsa", curve: elliptic.P521()},
+	"EdDSA": {kind: "eddsa"},
+}
+
+func parseJWS(params iofl.Params) (p joseParams, alg string, err error) {
+	if p, err = parseJOSE(params, "verify", "sign"); err != nil {
+		return p, "", err
+	}
+	alg = params.GetString("alg")
+	if alg == "" {
+		return p, "", errors.New("alg required")
+	}
+	if _, ok := jwsAlgs[alg]; !ok {
+		return p, "", fmt.Errorf("unknown alg %q", alg)
+	}
+	return p, alg, nil
+}
+
+func validateJWS(params iofl.Params) error {
+	_, _, err := parseJWS(params)
+	return err
+}
+
+// jwsCodec implements joseCodec for JWS.
+type jwsCodec struct {
+	name string
+	alg  jwsAlg
+	kid  string
+	// key is the raw key for HMAC, or the parsed key otherwise.
+	key interface{}
+}
+
+// newJWSCodec returns a codec for alg, with a key suitable for signing if sign
+// is true, and for verifying otherwise.
+func newJWSCodec(name, ref, kid string, sign bool) (*jwsCodec, error) {
+	c := &jwsCodec{name: name, alg: jwsAlgs[name], kid: kid}
+	if c.alg.kind == "hmac" {
+		key, err := getKey(ref)
+		if err != nil {
+			return nil, err
+		}
+		c.key = key
+		return c, nil
+	}
+	key, err := parseJOSEKey(ref)
+	if err != nil {
+		return nil, err
+	}
+	if !sign {
+		key = josePublicKey(key)
+	}
+	// A key for verifying has been reduced to its public key, so a public
+	// key is suitable only for verifying.
+	ok := false
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		ok = c.alg.kind == "rsa" || c.alg.kind == "pss"
+	case *rsa.PublicKey:
+		ok = !sign && (c.alg.kind == "rsa" || c.alg.kind == "pss")
+	case *ecdsa.PrivateKey:
+		ok = c.alg.kind == "ecdsa" && k.Curve == c.alg.curve
+	case *ecdsa.PublicKey:
+		ok = !sign && c.alg.kind == "ecdsa" && k.Curve == c.alg.curve
+	case ed25519.PrivateKey:
+		ok = c.alg.kind == "eddsa"
+	case ed25519.PublicKey:
+		ok = !sign && c.alg.kind == "eddsa"
+	}
+	if !ok {
+		if sign {
+			return nil, fmt.Errorf("key %q: not a private key for %s", ref, name)
+		}
+		return nil, fmt.Errorf("key %q: not a key for %s", ref, name)
+	}
+	c.key = key
+	return c, nil
+}
+
+// digest returns the hash of the signing input.
+func (c *jwsCodec) digest(input []byte) []byte {
+	h := c.alg.hash.New()
+	h.Write(input)
+	return h.Sum(nil)
+}
+
+// sign returns the signature of the signing input.
+func (c *jwsCodec) sign(input []byte) ([]byte, error) {
+	switch key := c.key.(type) {
+	case []byte:
+		m := hmac.New(c.alg.hash.New, key)
+		m.Write(input)
+		return m.Sum(nil), nil
+	case *rsa.PrivateKey:
+		if c.alg.kind == "pss" {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash}
+			return rsa.SignPSS(rand.Reader, key, c.alg.hash, c.digest(input), opts)
+		}
+		return rsa.SignPKCS1v15(rand.Reader, key, c.alg.hash, c.digest(input))
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, key, c.digest(input))
+		if err != nil {
+			return nil, err
+		}
+		size := (key.Curve.Params().BitSize + 7) / 8
+		sig := make([]byte, 2*size)
+		r.FillBytes(sig[:size])
+		s.FillBytes(sig[size:])
+		return sig, nil
+	case ed25519.PrivateKey:
+		return ed25519.Sign(key, input), nil
+	}
+	return nil, fmt.Errorf("cannot sign %s with a public key", c.name)
+}
+
+// verify returns whether sig is a valid signature of the signing input.
+func (c *jwsCodec) verify(input, sig []byte) bool {
+	switch key := c.key.(type) {
+	case []byte:
+		m := hmac.New(c.alg.hash.New, key)
+		m.Write(input)
+		return hmac.Equal(m.Sum(nil), sig)
+	case *rsa.PublicKey:
+		if c.alg.kind == "pss" {
+			opts := &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthAuto}
+			return rsa.VerifyPSS(key, c.alg.hash, c.digest(input), sig, opts) == nil
+		}
+		return rsa.VerifyPKCS1v15(key, c.alg.hash, c.digest(input), sig) == nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return false
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(key, c.digest(input), r, s)
+	case ed25519.PublicKey:
+		return ed25519.Verify(key, input, sig)
+	}
+	return false
+}
+
+func (c *jwsCodec) seal(payload []byte, extra map[string]interface{}) (token []byte, err error) {
+	header, err := joseHeader(c.kid, extra, "alg", c.name)
+	if err != nil {
+		return nil, err
+	}
+	input := joseJoin(header, payload)
+	sig, err := c.sign(input)
+	if err != nil {
+		return nil, err
+	}
+	return joseJoin(input, sig), nil
+}
+
+func (c *jwsCodec) open(token []byte) (payload []byte, header map[string]interface{}, err error) {
+	parts, err := joseSplit(token, 3)
+	if err != nil {
+		return nil, nil, err
+	}
+	if header, err = joseParseHeader(parts[0], "alg", c.name); err != nil {
+		return nil, nil, err
+	}
+	sig, err := joseDecode(parts[2], "signature")
+	if err != nil {
+		return nil, nil, err
+	}
+	if !c.verify(token[:len(parts[0])+1+len(parts[1])], sig) {
+		return nil, nil, errSignature
+	}
+	if payload, err = joseDecode(parts[1], "payload"); err != nil {
+		return nil, nil, err
+	}
+	return payload, header, nil
+}
+
+func newJWS(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	p, alg, err := parseJWS(params)
+	if err != nil {
+		return nil, err
+	}
+	codec, err := newJWSCodec(alg, p.key, p.kid, p.produce)
+	if err != nil {
+		return nil, err
+	}
+	return newJOSEFilter(p, codec, r), nil
+}
+
+// newJWSWriter returns a writer that applies the inverse of the mode param.
+func newJWSWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	p, alg, err := parseJWS(params)
+	if err != nil {
+		return nil, err
+	}
+	p.produce = !p.produce
+	codec, err := newJWSCodec(alg, p.key, p.kid, p.produce)
+	if err != nil {
+		return nil, err
+	}
+	return &joseWriter{dst: w, params: p, stream: joseStream{codec: codec}}, nil
+}
@@ -0,0 +1,143 @@
+package filters_test
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// jwsKeys pairs each algorithm with the keys used to sign and verify.
+var jwsKeys = []struct {
+	alg, sign, verify string
+}{
+	{"HS256", "hmac", "hmac"},
+	{"HS384", "hmac", "hmac"},
+	{"HS512", "hmac", "hmac"},
+	{"RS256", "rsa", "rsa.pub"},
+	{"RS384", "rsa1", "rsa"},
+	{"RS512", "rsa", "rsa.pub"},
+	{"PS256", "rsa", "rsa.pub"},
+	{"PS384", "rsa", "rsa1"},
+	{"PS512", "rsa", "rsa.pub"},
+	{"ES256", "p256", "p256.pub"},
+	{"ES384", "p384", "p384"},
+	{"ES512", "p521", "p521.pub"},
+	{"EdDSA", "ed25519", "ed25519.pub"},
+}
+
+func TestJWSRoundTrip(t *testing.T) {
+	payload := []byte(`{"iss":"iofl"}`)
+	for _, k := range jwsKeys {
+		sign := iofl.Params{"mode": "sign", "alg": k.alg, "key": "jose:" + k.sign, "kid": "k1"}
+		verify := iofl.Params{"alg": k.alg, "key": "jose:" + k.verify}
+		token := mustRead(t, filters.JWS, sign, payload)
+		if n := bytes.Count(token, []byte(".")); n != 2 {
+			t.Errorf("%s: got %d separators", k.alg, n)
+		}
+		if header := joseHeader(t, token); header["alg"] != k.alg || header["kid"] != "k1" {
+			t.Errorf("%s: got header %v", k.alg, header)
+		}
+		out, err := readFilter(t, filters.JWS, verify, append(token, '\n'))
+		if err != nil || !bytes.Equal(out, payload) {
+			t.Errorf("%s: got %q, %v", k.alg, out, err)
+		}
+		// Flip a bit of the last byte of the signature that is significant
+		// in the base64 encoding.
+		tampered := append([]byte{}, token...)
+		tampered[len(tampered)-2] ^= 1
+		if _, err := readFilter(t, filters.JWS, verify, tampered); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", k.alg, err)
+		}
+	}
+}
+
+// TestJWSInterop verifies the HS256 example of RFC 7515, appendix A.1.
+func TestJWSInterop(t *testing.T) {
+	const token = "eyJ0eXAiOiJKV1QiLA0KICJhbGciOiJIUzI1NiJ9" +
+		".eyJpc3MiOiJqb2UiLA0KICJleHAiOjEzMDA4MTkzODAsDQogImh0dHA6Ly9leGFtcGxlLmNvbS9pc19yb290Ijp0cnVlfQ" +
+		".dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	key, err := base64.RawURLEncoding.DecodeString("AyM1SysPpbyDfgZld3umj1qzKObwVMkoqQ-EstJQLr_T-1qS0gZH75aKtMN3Yj0iPS4hcgUuTwjAzZr1Z9CAow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	filters.RegisterKeyProvider("rfc7515", filters.KeyFunc(func(string) ([]byte, error) { return key, nil }))
+	out, err := readFilter(t, filters.JWS, iofl.Params{"alg": "HS256", "key": "rfc7515:"}, []byte(token))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "{\"iss\":\"joe\",\r\n \"exp\":1300819380,\r\n \"http://example.com/is_root\":true}"
+	if string(out) != want {
+		t.Errorf("got %q, want %q", out, want)
+	}
+}
+
+// hs256Token returns a compact serialization of payload with the given header,
+// signed with key.
+func hs256Token(key []byte, header, payload string) []byte {
+	enc := base64.RawURLEncoding
+	input := enc.EncodeToString([]byte(header)) + "." + enc.EncodeToString([]byte(payload))
+	m := hmac.New(sha256.New, key)
+	m.Write([]byte(input))
+	return []byte(input + "." + enc.EncodeToString(m.Sum(nil)))
+}
+
+func TestJWSCorrupt(t *testing.T) {
+	key := bytes.Repeat([]byte{7}, 32)
+	verify := iofl.Params{"alg": "HS256", "key": "test:k"}
+	valid := hs256Token(key, `{"alg":"HS256"}`, "payload")
+	if out, err := readFilter(t, filters.JWS, verify, valid); err != nil || string(out) != "payload" {
+		t.Fatalf("got %q, %v", out, err)
+	}
+	parts := strings.Split(string(valid), ".")
+	for name, in := range map[string][]byte{
+		"alg mismatch":     hs256Token(key, `{"alg":"HS384"}`, "payload"),
+		"alg none":         hs256Token(key, `{"alg":"none"}`, "payload"),
+		"crit":             hs256Token(key, `{"alg":"HS256","crit":["exp"]}`, "payload"),
+		"malformed header": hs256Token(key, `{"alg":`, "payload"),
+		"wrong key":        hs256Token(bytes.Repeat([]byte{8}, 32), `{"alg":"HS256"}`, "payload"),
+		"two parts":        []byte(parts[0] + "." + parts[1]),
+		"four parts":       append(append([]byte{}, valid...), ".x"...),
+		"header encoding":  []byte("!" + string(valid)),
+		"sig encoding":     append(append([]byte{}, valid...), '!'),
+		"modified payload": []byte(parts[0] + "." + base64.RawURLEncoding.EncodeToString([]byte("payloaf")) + "." + parts[2]),
+		"empty":            nil,
+	} {
+		if _, err := readFilter(t, filters.JWS, verify, in); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", name, err)
+		}
+	}
+}
+
+func TestJWSParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"key": "jose:hmac"},
+		{"alg": "none", "key": "jose:hmac"},
+		{"alg": "HS257", "key": "jose:hmac"},
+	} {
+		if err := filters.JWS.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	// Keys are checked when the filter is constructed.
+	for _, params := range []iofl.Params{
+		{"alg": "RS256", "key": "jose:garbage"},
+		{"alg": "RS256", "key": "jose:p256"},
+		{"alg": "ES256", "key": "jose:p384"},
+		{"alg": "ES256", "key": "jose:rsa"},
+		{"alg": "EdDSA", "key": "jose:rsa.pub"},
+		{"alg": "HS256", "key": "jose:missing"},
+		{"mode": "sign", "alg": "RS256", "key": "jose:rsa.pub"},
+		{"mode": "sign", "alg": "EdDSA", "key": "jose:ed25519.pub"},
+	} {
+		if _, err := filters.JWS.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error from New", params)
+		}
+	}
+}
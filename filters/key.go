@@ -0,0 +1,93 @@
+package filters
+
+import (
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+)
+
+// KeyProvider provides encryption keys, allowing keys to be stored outside of
+// the params of a chain.
+type KeyProvider interface {
+	// Key returns the key of the given name.
+	Key(name string) ([]byte, error)
+}
+
+// KeyFunc is a KeyProvider implemented by a function.
+type KeyFunc func(name string) ([]byte, error)
+
+// Key implements KeyProvider.
+func (f KeyFunc) Key(name string) ([]byte, error) {
+	return f(name)
+}
+
+// EnvKeys provides keys from environment variables, where the name is the
+// name of the variable, and the value is the key, encoded as hexadecimal.
+var EnvKeys KeyProvider = KeyFunc(func(name string) ([]byte, error) {
+	v, ok := os.LookupEnv(name)
+	if !ok {
+		return nil, fmt.Errorf("environment variable %q not set", name)
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(v))
+	if err != nil {
+		return nil, fmt.Errorf("environment variable %q: %w", name, err)
+	}
+	return key, nil
+})
+
+// FileKeys provides keys from files, where the name is the path of the file,
+// and the content is the raw bytes of the key.
+var FileKeys KeyProvider = KeyFunc(os.ReadFile)
+
+// keyProviders is the registry of KeyProviders.
+var keyProviders = struct {
+	sync.RWMutex
+	m map[string]KeyProvider
+}{m: map[string]KeyProvider{
+	"env":  EnvKeys,
+	"file": FileKeys,
+}}
+
+// RegisterKeyProvider registers p under the given scheme, replacing any
+// provider of the same scheme. Filters refer to a key with a param of the form
+// "scheme:name", which is retrieved by calling the Key method of the provider
+// with name. The following schemes are registered by default:
+//
+//	env:  EnvKeys.
+//	file: FileKeys.
+func RegisterKeyProvider(scheme string, p KeyProvider) {
+	keyProviders.Lock()
+	defer keyProviders.Unlock()
+	keyProviders.m[scheme] = p
+}
+
+// parseKeyRef splits a key reference of the form "scheme:name", returning the
+// provider of the scheme.
+func parseKeyRef(ref string) (p KeyProvider, name string, err error) {
+	i := strings.IndexByte(ref, ':')
+	if i < 0 {
+		return nil, "", fmt.Errorf("key %q: expected scheme:name", ref)
+	}
+	keyProviders.RLock()
+	defer keyProviders.RUnlock()
+	p, ok := keyProviders.m[ref[:i]]
+	if !ok {
+		return nil, "", fmt.Errorf("key %q: unknown scheme %q", ref, ref[:i])
+	}
+	return p, ref[i+1:], nil
+}
+
+// getKey retrieves the key referred to by ref.
+func getKey(ref string) ([]byte, error) {
+	p, name, err := parseKeyRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	key, err := p.Key(name)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %w", ref, err)
+	}
+	return key, nil
+}
@@ -0,0 +1,288 @@
+package filters
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Members splits a stream of concatenated compressed members, such as daily
+// logs appended to one file, into separate records without decompressing their
+// content. Params:
+//
+//	format: The format of the members. One of "gzip", "zstd", "xz", or
+//	        "auto" (default), which detects the format of each member from its
+//	        magic number.
+//	max:    The maximum size of a member, in bytes. Defaults to 64MiB.
+//
+// The filter produces its source unchanged. It implements iofl.Framer, with
+// each compressed member being a frame, and MemberNotifier, reporting the start
+// of each member. Each zstd frame is a member, including skippable frames. Each
+// xz stream is a member, including any stream padding that follows it. Gzip
+// members are decompressed to locate their end, and their checksums are
+// verified.
+var Members = iofl.FilterDef{
+	Name:         "members",
+	New:          newMembers,
+	Description:  "Splits concatenated compressed members into frames without decompressing them.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "format", Type: iofl.ParamString, Description: "The format of the members, \"gzip\", \"zstd\", \"xz\", or \"auto\".", Default: "auto"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of a member, in bytes.", Default: "64MiB"},
+	},
+}
+
+// Magic numbers of member formats.
+var (
+	gzipMagic = []byte{0x1F, 0x8B}
+	xzMagic   = []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+)
+
+// errUnknownFormat is returned when the format of a member cannot be detected.
+var errUnknownFormat error = &iofl.CorruptError{Err: errors.New("unknown member format")}
+
+func newMembers(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	filter := &membersFilter{format: params.GetString("format"), size: bufferSize(params)}
+	switch filter.format {
+	case "":
+		filter.format = "auto"
+	case "auto", "gzip", "zstd", "xz":
+	default:
+		return nil, fmt.Errorf("unknown format %q", filter.format)
+	}
+	if filter.max = params.GetInt("max"); filter.max <= 0 {
+		filter.max = defaultMaxMessage
+	}
+	filter.stream.next = filter.ReadFrame
+	filter.Reset(r)
+	return filter, nil
+}
+
+// recordReader records the bytes read from a buffered reader.
+type recordReader struct {
+	br  *bufio.Reader
+	buf []byte
+	max int
+}
+
+func (r *recordReader) Read(p []byte) (n int, err error) {
+	n, err = r.br.Read(p)
+	r.buf = append(r.buf, p[:n]...)
+	if len(r.buf) > r.max {
+		return n, errMemberSize
+	}
+	return n, err
+}
+
+func (r *recordReader) ReadByte() (b byte, err error) {
+	if b, err = r.br.ReadByte(); err == nil {
+		r.buf = append(r.buf, b)
+		if len(r.buf) > r.max {
+			return b, errMemberSize
+		}
+	}
+	return b, err
+}
+
+// errMemberSize is returned when a member exceeds the maximum size.
+var errMemberSize = errors.New("member exceeds maximum size")
+
+// membersFilter implements the Members filter.
+type membersFilter struct {
+	src      io.ReadCloser
+	format   string
+	max      int
+	size     int
+	onMember func(Member)
+	closed   bool
+
+	rr     recordReader
+	zr     *gzip.Reader
+	index  int
+	offset int64
+	stream frameStream
+}
+
+// OnMember implements MemberNotifier.
+func (f *membersFilter) OnMember(fn func(m Member)) {
+	f.onMember = fn
+}
+
+// Source implements iofl.Filter.
+func (f *membersFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *membersFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	return f.stream.Read(p)
+}
+
+// detect returns the format of the member at the start of b.
+func detectMember(b []byte) string {
+	switch {
+	case bytes.HasPrefix(b, gzipMagic):
+		return "gzip"
+	case bytes.HasPrefix(b, xzMagic):
+		return "xz"
+	case len(b) >= 4:
+		magic := binary.LittleEndian.Uint32(b)
+		if magic == zstdMagic || magic&zstdSkippableMask == zstdSkippableBase {
+			return "zstd"
+		}
+	}
+	return ""
+}
+
+// ReadFrame implements iofl.Framer, returning the next compressed member.
+func (f *membersFilter) ReadFrame() ([]byte, error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	peek, err := f.rr.br.Peek(len(xzMagic))
+	if len(peek) == 0 {
+		return nil, err
+	}
+	format := f.format
+	if format == "auto" {
+		if format = detectMember(peek); format == "" {
+			return nil, fmt.Errorf("member %d: %w", f.index, errUnknownFormat)
+		}
+	}
+	m := Member{Index: f.index, Offset: f.offset}
+	f.rr.buf = f.rr.buf[:0]
+	switch format {
+	case "gzip":
+		err = f.readGzip(&m)
+	case "zstd":
+		f.rr.buf, _, err = readZstdFrame(f.rr.br, f.rr.buf, f.max)
+	case "xz":
+		err = f.readXz()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("member %d: %w", f.index, noEOF(err))
+	}
+	if f.onMember != nil {
+		f.onMember(m)
+	}
+	f.index++
+	f.offset += int64(len(f.rr.buf))
+	return f.rr.buf, nil
+}
+
+// readGzip reads a gzip member, recording the header in m.
+func (f *membersFilter) readGzip(m *Member) (err error) {
+	if f.zr == nil {
+		f.zr, err = gzip.NewReader(&f.rr)
+	} else {
+		err = f.zr.Reset(&f.rr)
+	}
+	if err != nil {
+		return gzipError(err)
+	}
+	f.zr.Multistream(false)
+	m.Name = f.zr.Name
+	m.Comment = f.zr.Comment
+	m.ModTime = f.zr.ModTime
+	m.Extra = append([]byte(nil), f.zr.Extra...)
+	_, err = io.Copy(io.Discard, f.zr)
+	return gzipError(err)
+}
+
+// Sizes of xz stream structures.
+const (
+	xzHeaderSize = 12
+	xzFooterSize = 12
+)
+
+// readXz reads an xz stream, followed by any stream padding. The end of the
+// stream is located by scanning for a stream footer whose flags match the
+// stream header, and whose checksum is valid.
+func (f *membersFilter) readXz() (err error) {
+	if f.rr.buf, err = readN(f.rr.br, f.rr.buf, xzHeaderSize); err != nil {
+		return err
+	}
+	header := f.rr.buf
+	if crc32.ChecksumIEEE(header[6:8]) != binary.LittleEndian.Uint32(header[8:]) {
+		return &iofl.CorruptError{Err: errors.New("xz: bad stream header checksum")}
+	}
+	flags := [2]byte{header[6], header[7]}
+	// Streams are a multiple of four bytes in size.
+	for {
+		if f.rr.buf, err = readN(f.rr.br, f.rr.buf, 4); err != nil {
+			return err
+		}
+		if len(f.rr.buf) > f.max {
+			return errMemberSize
+		}
+		n := len(f.rr.buf)
+		if n < xzHeaderSize+xzFooterSize || f.rr.buf[n-2] != 'Y' || f.rr.buf[n-1] != 'Z' {
+			continue
+		}
+		footer := f.rr.buf[n-xzFooterSize:]
+		if footer[8] != flags[0] || footer[9] != flags[1] {
+			continue
+		}
+		if crc32.ChecksumIEEE(footer[4:10]) != binary.LittleEndian.Uint32(footer) {
+			continue
+		}
+		break
+	}
+	// Consume stream padding.
+	for {
+		pad, err := f.rr.br.Peek(4)
+		if err != nil || !bytes.Equal(pad, []byte{0, 0, 0, 0}) {
+			return nil
+		}
+		f.rr.buf, _ = readN(f.rr.br, f.rr.buf, 4)
+	}
+}
+
+// Close implements io.Closer, closing the source.
+func (f *membersFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *membersFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	if f.rr.br == nil {
+		f.rr.br = bufio.NewReaderSize(src, f.size)
+	} else {
+		f.rr.br.Reset(src)
+	}
+	f.rr.buf = f.rr.buf[:0]
+	f.rr.max = f.max
+	f.index = 0
+	f.offset = 0
+	f.stream.reset()
+	return nil
+}
+
+// PreservesSize implements iofl.SizePreserver.
+func (f *membersFilter) PreservesSize() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *membersFilter) MemoryUsage() int {
+	return f.rr.br.Size() + cap(f.rr.buf)
+}
@@ -0,0 +1,173 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// appendLE32 appends v to b in little-endian order.
+func appendLE32(b []byte, v uint32) []byte {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], v)
+	return append(b, n[:]...)
+}
+
+// zstdFrame returns content as a zstd frame of one uncompressed block.
+func zstdFrame(content string) []byte {
+	b := []byte{0x28, 0xB5, 0x2F, 0xFD, 0x20, byte(len(content))}
+	v := uint32(len(content))<<3 | 1
+	b = append(b, byte(v), byte(v>>8), byte(v>>16))
+	return append(b, content...)
+}
+
+// zstdSkippable returns a skippable zstd frame containing data.
+func zstdSkippable(data string) []byte {
+	b := []byte{0x50, 0x2A, 0x4D, 0x18}
+	b = appendLE32(b, uint32(len(data)))
+	return append(b, data...)
+}
+
+// xzEmpty returns an xz stream containing no blocks, followed by padding
+// bytes.
+func xzEmpty(padding int) []byte {
+	flags := []byte{0x00, 0x01}
+	b := []byte{0xFD, '7', 'z', 'X', 'Z', 0x00}
+	b = append(b, flags...)
+	b = appendLE32(b, crc32.ChecksumIEEE(flags))
+	// An index of no records.
+	index := []byte{0x00, 0x00, 0x00, 0x00}
+	b = append(b, index...)
+	b = appendLE32(b, crc32.ChecksumIEEE(index))
+	footer := appendLE32(nil, 1)
+	footer = append(footer, flags...)
+	b = appendLE32(b, crc32.ChecksumIEEE(footer))
+	b = append(b, footer...)
+	b = append(b, 'Y', 'Z')
+	return append(b, make([]byte, padding)...)
+}
+
+// memberFrames reads all frames from the Members filter over in.
+func memberFrames(t *testing.T, params iofl.Params, in []byte) (frames [][]byte, members []filters.Member, err error) {
+	t.Helper()
+	f, err := filters.Members.New(params, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	f.(filters.MemberNotifier).OnMember(func(m filters.Member) {
+		members = append(members, m)
+	})
+	for {
+		frame, err := f.(iofl.Framer).ReadFrame()
+		if err == io.EOF {
+			return frames, members, nil
+		}
+		if err != nil {
+			return frames, members, err
+		}
+		frames = append(frames, append([]byte{}, frame...))
+	}
+}
+
+func TestMembers(t *testing.T) {
+	parts := [][]byte{
+		gzipMember(t, "day1", "log one\n"),
+		zstdFrame("log two\n"),
+		zstdSkippable("meta"),
+		xzEmpty(8),
+		gzipMember(t, "day2", ""),
+	}
+	in := bytes.Join(parts, nil)
+	frames, members, err := memberFrames(t, nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != len(parts) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(parts))
+	}
+	var offset int64
+	for i, frame := range frames {
+		if !bytes.Equal(frame, parts[i]) {
+			t.Errorf("frame %d: got %x, want %x", i, frame, parts[i])
+		}
+		if members[i].Index != i || members[i].Offset != offset {
+			t.Errorf("member %d: got %+v", i, members[i])
+		}
+		offset += int64(len(parts[i]))
+	}
+	if members[0].Name != "day1" || members[4].Name != "day2" {
+		t.Errorf("got names %q, %q", members[0].Name, members[4].Name)
+	}
+
+	if out := mustRead(t, filters.Members, iofl.Params{"bufferSize": 16}, in); !bytes.Equal(out, in) {
+		t.Error("stream does not reproduce source")
+	}
+}
+
+func TestMembersFormat(t *testing.T) {
+	in := append(zstdFrame("a"), zstdFrame("bc")...)
+	frames, _, err := memberFrames(t, iofl.Params{"format": "zstd"}, in)
+	if err != nil || len(frames) != 2 {
+		t.Errorf("zstd: got %d frames, %v", len(frames), err)
+	}
+	if _, _, err := memberFrames(t, iofl.Params{"format": "gzip"}, in); err == nil {
+		t.Error("gzip: expected error")
+	}
+}
+
+func TestMembersCorrupt(t *testing.T) {
+	gz := gzipMember(t, "", "content")
+	badCRC := append([]byte{}, gz...)
+	badCRC[len(badCRC)-8] ^= 0xFF
+	badXz := xzEmpty(0)
+	badXz[8] ^= 0xFF
+	tests := []struct {
+		name   string
+		in     []byte
+		params iofl.Params
+	}{
+		{"unknown format", []byte("plain text"), nil},
+		{"gzip checksum", badCRC, nil},
+		{"xz header checksum", badXz, nil},
+		{"zstd reserved block", append(zstdFrame("x")[:6], 0x06, 0, 0), nil},
+	}
+	for _, tt := range tests {
+		if _, _, err := memberFrames(t, tt.params, append(zstdFrame("ok"), tt.in...)); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", tt.name, err)
+		}
+	}
+
+	for _, tt := range []struct {
+		name   string
+		in     []byte
+		params iofl.Params
+	}{
+		{"truncated gzip", gz[:len(gz)-3], nil},
+		{"truncated zstd", zstdFrame("content")[:10], nil},
+		{"unterminated xz", xzEmpty(0)[:20], nil},
+		{"oversize gzip", gz, iofl.Params{"max": 10}},
+		{"oversize zstd", zstdFrame("content"), iofl.Params{"max": 10}},
+		{"oversize xz", xzEmpty(0), iofl.Params{"max": 16}},
+	} {
+		frames, _, err := memberFrames(t, tt.params, tt.in)
+		if err == nil || len(frames) != 0 {
+			t.Errorf("%s: got %d frames, %v", tt.name, len(frames), err)
+		}
+	}
+}
+
+func TestMembersParams(t *testing.T) {
+	if _, err := filters.Members.New(iofl.Params{"format": "bzip2"}, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("expected error for unknown format")
+	}
+	if _, err := filters.Members.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,385 @@
+package filters
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/anaminus/iofl"
+)
+
+// PEM converts between PEM (RFC 7468) and DER encoded data. Params:
+//
+//	mode: One of the following:
+//	      "decode":  Converts PEM to DER. The contents of each selected
+//	                 block are decoded and concatenated. This is the
+//	                 default.
+//	      "encode":  Converts DER to PEM. The data is encoded as a single
+//	                 block.
+//	      "extract": Selects PEM blocks, producing them unchanged.
+//	type: When decoding or extracting, a comma-separated list of the block
+//	      types to select, such as "CERTIFICATE". If empty, all blocks are
+//	      selected. When encoding, the type of the produced block, which is
+//	      required.
+//
+// Text outside of blocks, and the headers of blocks, are ignored when
+// decoding. When used in a write chain, the filter converts DER to PEM if the
+// mode is "decode", converts PEM to DER if the mode is "encode", and extracts
+// blocks if the mode is "extract". The filter honors the bufferSize param, with
+// a minimum of 256 bytes, which also limits the length of a line.
+var PEM = iofl.FilterDef{
+	Name:         "pem",
+	New:          newPEMFilter,
+	NewWriter:    newPEMWriter,
+	Validate:     validatePEM,
+	Description:  "Converts between PEM and DER, or extracts PEM blocks by type.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decode\", \"encode\", or \"extract\".", Default: "decode"},
+		{Name: "type", Type: iofl.ParamString, Description: "Comma-separated block types to select, or the type of the encoded block."},
+	},
+}
+
+// errPEMType is returned when the type param is required but not specified.
+var errPEMType = errors.New("type required")
+
+const (
+	pemBegin = "-----BEGIN "
+	pemEnd   = "-----END "
+	pemDash  = "-----"
+
+	// pemLineBytes is the number of bytes encoded on each line of a block,
+	// producing lines of 64 characters.
+	pemLineBytes = 48
+
+	// pemMinBufferSize is the smallest size of buffers used by the filter.
+	// Input is processed line by line, so a line longer than the buffer
+	// cannot be processed.
+	pemMinBufferSize = 256
+)
+
+// pemBufferSize returns the buffer size configured by params, no smaller than
+// pemMinBufferSize.
+func pemBufferSize(params iofl.Params) int {
+	if size := bufferSize(params); size > pemMinBufferSize {
+		return size
+	}
+	return pemMinBufferSize
+}
+
+// pemTransformer returns the transformer for the given mode, configured by
+// params.
+func pemTransformer(params iofl.Params, mode string) (transformer, error) {
+	typ := params.GetString("type")
+	if mode == "encode" {
+		if typ == "" {
+			return nil, errPEMType
+		}
+		if strings.ContainsAny(typ, "-\r\n") {
+			return nil, fmt.Errorf("invalid type %q", typ)
+		}
+		return &pemEncoder{typ: typ}, nil
+	}
+	d := &pemDecoder{extract: mode == "extract"}
+	if typ != "" {
+		d.types = map[string]bool{}
+		for _, t := range strings.Split(typ, ",") {
+			d.types[strings.TrimSpace(t)] = true
+		}
+	}
+	return d, nil
+}
+
+func newPEMFilter(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	mode, err := getMode(params, "decode", "decode", "encode", "extract")
+	if err != nil {
+		return nil, err
+	}
+	t, err := pemTransformer(params, mode)
+	if err != nil {
+		return nil, err
+	}
+	return newTransformFilter(r, t, pemBufferSize(params)), nil
+}
+
+func newPEMWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	mode, err := getMode(params, "decode", "decode", "encode", "extract")
+	if err != nil {
+		return nil, err
+	}
+	switch mode {
+	case "decode":
+		mode = "encode"
+	case "encode":
+		mode = "decode"
+	}
+	t, err := pemTransformer(params, mode)
+	if err != nil {
+		return nil, err
+	}
+	return newTransformWriter(w, t, pemBufferSize(params)), nil
+}
+
+func validatePEM(params iofl.Params) error {
+	mode, err := getMode(params, "decode", "decode", "encode", "extract")
+	if err != nil {
+		return err
+	}
+	_, err = pemTransformer(params, mode)
+	return err
+}
+
+// pemBoundary returns the block type of line if it is a boundary line with
+// the given prefix.
+func pemBoundary(line []byte, prefix string) (typ string, ok bool) {
+	if len(line) < len(prefix)+len(pemDash) ||
+		!bytes.HasPrefix(line, []byte(prefix)) ||
+		!bytes.HasSuffix(line, []byte(pemDash)) {
+		return "", false
+	}
+	return string(line[len(prefix) : len(line)-len(pemDash)]), true
+}
+
+// pemEncoder encodes data as a single PEM block.
+type pemEncoder struct {
+	typ string
+	// state is 0 before the header is written, 1 while encoding data, and 2
+	// once the footer is written.
+	state byte
+}
+
+func (e *pemEncoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	if e.state == 0 {
+		header := pemBegin + e.typ + pemDash + "\n"
+		if len(dst) < len(header) {
+			return nDst, nSrc, errShortDst
+		}
+		nDst += copy(dst, header)
+		e.state = 1
+	}
+	for e.state == 1 {
+		n := pemLineBytes
+		switch rem := len(src) - nSrc; {
+		case rem >= pemLineBytes:
+		case rem == 0 && !atEOF:
+			return nDst, nSrc, nil
+		case !atEOF:
+			return nDst, nSrc, errShortSrc
+		case rem > 0:
+			n = rem
+		default:
+			footer := pemEnd + e.typ + pemDash + "\n"
+			if len(dst)-nDst < len(footer) {
+				return nDst, nSrc, errShortDst
+			}
+			nDst += copy(dst[nDst:], footer)
+			e.state = 2
+			continue
+		}
+		m := base64.StdEncoding.EncodedLen(n)
+		if len(dst)-nDst < m+1 {
+			return nDst, nSrc, errShortDst
+		}
+		base64.StdEncoding.Encode(dst[nDst:], src[nSrc:nSrc+n])
+		dst[nDst+m] = '\n'
+		nDst += m + 1
+		nSrc += n
+	}
+	return nDst, nSrc, nil
+}
+
+func (e *pemEncoder) reset() {
+	e.state = 0
+}
+
+// SaveState implements iofl.StateSaver.
+func (e *pemEncoder) SaveState() (state []byte, err error) {
+	return []byte{e.state}, nil
+}
+
+// RestoreState implements iofl.StateSaver.
+func (e *pemEncoder) RestoreState(state []byte) error {
+	if len(state) != 1 || state[0] > 2 {
+		return errBadState
+	}
+	e.state = state[0]
+	return nil
+}
+
+// pemDecoder decodes or extracts the PEM blocks of selected types, line by
+// line.
+type pemDecoder struct {
+	// types is the set of selected types. All types are selected if nil.
+	types   map[string]bool
+	extract bool
+
+	inBlock   bool
+	inHeaders bool
+	selected  bool
+	typ       string
+	quantum   [4]byte
+	nq        int
+}
+
+func (d *pemDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for {
+		rest := src[nSrc:]
+		if len(rest) == 0 {
+			if atEOF && d.inBlock {
+				return nDst, nSrc, &iofl.CorruptError{Err: fmt.Errorf("unterminated %q block", d.typ)}
+			}
+			return nDst, nSrc, nil
+		}
+		line, n := rest, len(rest)
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, n = rest[:i], i+1
+		} else if !atEOF {
+			return nDst, nSrc, errShortSrc
+		}
+		m, err := d.line(dst[nDst:], bytes.TrimRight(line, " \t\r"))
+		if err != nil {
+			return nDst, nSrc, err
+		}
+		nDst += m
+		nSrc += n
+	}
+}
+
+// emit writes line to dst, followed by a line break, if the current block is
+// being extracted. The state of the decoder must not be changed before emit
+// returns successfully.
+func (d *pemDecoder) emit(dst, line []byte, selected bool) (n int, err error) {
+	if !d.extract || !selected {
+		return 0, nil
+	}
+	if len(dst) < len(line)+1 {
+		return 0, errShortDst
+	}
+	n = copy(dst, line)
+	dst[n] = '\n'
+	return n + 1, nil
+}
+
+// line processes a single line of input, without its line break.
+func (d *pemDecoder) line(dst, line []byte) (n int, err error) {
+	if !d.inBlock {
+		typ, ok := pemBoundary(line, pemBegin)
+		if !ok {
+			return 0, nil
+		}
+		selected := d.types == nil || d.types[typ]
+		if n, err = d.emit(dst, line, selected); err != nil {
+			return 0, err
+		}
+		d.inBlock, d.inHeaders, d.selected, d.typ = true, true, selected, typ
+		return n, nil
+	}
+	if typ, ok := pemBoundary(line, pemEnd); ok {
+		if typ != d.typ {
+			return 0, &iofl.CorruptError{Err: fmt.Errorf("%q block ended by %q", d.typ, typ)}
+		}
+		if d.nq != 0 {
+			return 0, &iofl.CorruptError{Err: fmt.Errorf("truncated %q block", d.typ)}
+		}
+		if n, err = d.emit(dst, line, d.selected); err != nil {
+			return 0, err
+		}
+		d.inBlock, d.selected, d.typ = false, false, ""
+		return n, nil
+	}
+	if bytes.HasPrefix(line, []byte(pemDash)) {
+		return 0, &iofl.CorruptError{Err: fmt.Errorf("unexpected boundary in %q block", d.typ)}
+	}
+	if d.inHeaders {
+		switch {
+		case len(line) == 0:
+			// Blank line terminating headers.
+			if n, err = d.emit(dst, line, d.selected); err != nil {
+				return 0, err
+			}
+			d.inHeaders = false
+			return n, nil
+		case bytes.IndexByte(line, ':') >= 0, line[0] == ' ', line[0] == '\t':
+			// Header, or continuation of a header.
+			return d.emit(dst, line, d.selected)
+		}
+		d.inHeaders = false
+	}
+	if !d.selected || d.extract {
+		return d.emit(dst, line, d.selected)
+	}
+	if len(dst) < (d.nq+len(line))/4*3 {
+		return 0, errShortDst
+	}
+	for _, c := range line {
+		if c == ' ' || c == '\t' {
+			continue
+		}
+		d.quantum[d.nq] = c
+		if d.nq++; d.nq < len(d.quantum) {
+			continue
+		}
+		m, err := base64.StdEncoding.Decode(dst[n:], d.quantum[:])
+		if err != nil {
+			return 0, &iofl.CorruptError{Err: fmt.Errorf("invalid encoding %q in %q block", d.quantum[:], d.typ)}
+		}
+		n += m
+		d.nq = 0
+	}
+	return n, nil
+}
+
+func (d *pemDecoder) reset() {
+	d.inBlock, d.inHeaders, d.selected = false, false, false
+	d.typ = ""
+	d.nq = 0
+}
+
+// SaveState implements iofl.StateSaver.
+func (d *pemDecoder) SaveState() (state []byte, err error) {
+	var flags byte
+	if d.inBlock {
+		flags |= 1
+	}
+	if d.inHeaders {
+		flags |= 2
+	}
+	if d.selected {
+		flags |= 4
+	}
+	state = append(state, flags)
+	state = appendState(state, []byte(d.typ))
+	state = appendState(state, d.quantum[:d.nq])
+	return state, nil
+}
+
+// RestoreState implements iofl.StateSaver.
+func (d *pemDecoder) RestoreState(state []byte) error {
+	if len(state) < 1 {
+		return errBadState
+	}
+	flags := state[0]
+	typ, state, err := readState(state[1:])
+	if err != nil {
+		return err
+	}
+	quantum, _, err := readState(state)
+	if err != nil {
+		return err
+	}
+	if len(quantum) >= len(d.quantum) {
+		return errBadState
+	}
+	d.inBlock = flags&1 != 0
+	d.inHeaders = flags&2 != 0
+	d.selected = flags&4 != 0
+	d.typ = string(typ)
+	d.nq = copy(d.quantum[:], quantum)
+	return nil
+}
@@ -0,0 +1,179 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/pem"
+	"io/ioutil"
+	"math/rand"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// pemBlocks returns the PEM encoding of a certificate, key, and second
+// certificate, surrounded by text, and the contents of each block.
+func pemBlocks() (encoded []byte, cert1, key, cert2 []byte) {
+	rnd := rand.New(rand.NewSource(1))
+	cert1, key, cert2 = make([]byte, 300), make([]byte, 47), make([]byte, 1)
+	rnd.Read(cert1)
+	rnd.Read(key)
+	rnd.Read(cert2)
+	var b bytes.Buffer
+	b.WriteString("leading text\n")
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: cert1})
+	b.WriteString("between blocks\r\n")
+	pem.Encode(&b, &pem.Block{Type: "PRIVATE KEY", Headers: map[string]string{"Proc-Type": "4,ENCRYPTED"}, Bytes: key})
+	pem.Encode(&b, &pem.Block{Type: "CERTIFICATE", Bytes: cert2})
+	b.WriteString("trailing text")
+	return b.Bytes(), cert1, key, cert2
+}
+
+func TestPEMDecode(t *testing.T) {
+	encoded, cert1, key, cert2 := pemBlocks()
+	tests := []struct {
+		typ  string
+		want []byte
+	}{
+		{"", append(append(append([]byte{}, cert1...), key...), cert2...)},
+		{"CERTIFICATE", append(append([]byte{}, cert1...), cert2...)},
+		{"PRIVATE KEY", key},
+		{"PRIVATE KEY, CERTIFICATE", append(append(append([]byte{}, cert1...), key...), cert2...)},
+		{"PUBLIC KEY", nil},
+	}
+	for _, tt := range tests {
+		params := iofl.Params{"type": tt.typ, iofl.ParamBufferSize: 256}
+		out, err := readFilterFrom(t, filters.PEM, params, ioutil.NopCloser(iotest.OneByteReader(bytes.NewReader(encoded))))
+		if err != nil {
+			t.Fatalf("%q: %v", tt.typ, err)
+		}
+		if !bytes.Equal(out, tt.want) {
+			t.Errorf("%q: got %d bytes, want %d", tt.typ, len(out), len(tt.want))
+		}
+	}
+}
+
+func TestPEMEncode(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+	for _, n := range []int{0, 1, 47, 48, 49, 1000} {
+		der := make([]byte, n)
+		rnd.Read(der)
+		out := mustRead(t, filters.PEM, iofl.Params{"mode": "encode", "type": "CERTIFICATE"}, der)
+		want := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+		if !bytes.Equal(out, want) {
+			t.Errorf("%d bytes: got %q, want %q", n, out, want)
+		}
+		if decoded := mustRead(t, filters.PEM, nil, out); !bytes.Equal(decoded, der) {
+			t.Errorf("%d bytes: round trip does not match input", n)
+		}
+	}
+}
+
+func TestPEMExtract(t *testing.T) {
+	encoded, _, key, _ := pemBlocks()
+	out := mustRead(t, filters.PEM, iofl.Params{"mode": "extract", "type": "PRIVATE KEY"}, encoded)
+	block, rest := pem.Decode(out)
+	if block == nil || block.Type != "PRIVATE KEY" || !bytes.Equal(block.Bytes, key) {
+		t.Fatalf("got %q", out)
+	}
+	if block.Headers["Proc-Type"] != "4,ENCRYPTED" {
+		t.Errorf("got headers %v", block.Headers)
+	}
+	if len(rest) != 0 {
+		t.Errorf("got trailing %q", rest)
+	}
+	out = mustRead(t, filters.PEM, iofl.Params{"mode": "extract"}, encoded)
+	if strings.Contains(string(out), "text") || strings.Count(string(out), "-----BEGIN ") != 3 {
+		t.Errorf("got %q", out)
+	}
+}
+
+func TestPEMCorrupt(t *testing.T) {
+	valid := string(pem.EncodeToMemory(&pem.Block{Type: "A", Bytes: []byte("content")}))
+	tests := map[string]string{
+		"unterminated":        strings.Replace(valid, "-----END A-----\n", "", 1),
+		"mismatched end":      strings.Replace(valid, "END A", "END B", 1),
+		"invalid encoding":    strings.Replace(valid, "Y29u", "Y2!u", 1),
+		"truncated quantum":   strings.Replace(valid, "Y29u", "Y29", 1),
+		"unexpected boundary": strings.Replace(valid, "-----END", "-----BEGIN B-----\n-----END", 1),
+	}
+	for name, in := range tests {
+		if _, err := readFilter(t, filters.PEM, nil, []byte(in)); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", name, err)
+		}
+	}
+	// Blocks that are not selected are not decoded, but must be well formed.
+	if _, err := readFilter(t, filters.PEM, iofl.Params{"type": "B"}, []byte(tests["unterminated"])); !iofl.IsCorrupt(err) {
+		t.Errorf("unselected: got %v, want corrupt", err)
+	}
+}
+
+func TestPEMWriter(t *testing.T) {
+	encoded, cert1, _, _ := pemBlocks()
+	// A writer applies the inverse of mode.
+	out, err := writeFilter(t, filters.PEM, iofl.Params{"type": "CERTIFICATE"}, cert1)
+	if err != nil || !bytes.Equal(out, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert1})) {
+		t.Errorf("decode: got %q, %v", out, err)
+	}
+	block, _ := pem.Decode(encoded)
+	out, err = writeFilter(t, filters.PEM, iofl.Params{"mode": "encode", "type": "CERTIFICATE"}, pem.EncodeToMemory(block))
+	if err != nil || !bytes.Equal(out, cert1) {
+		t.Errorf("encode: got %d bytes, %v", len(out), err)
+	}
+	extract := iofl.Params{"mode": "extract", "type": "CERTIFICATE"}
+	out, err = writeFilter(t, filters.PEM, extract, encoded)
+	if err != nil || !bytes.Equal(out, mustRead(t, filters.PEM, extract, encoded)) {
+		t.Errorf("extract: got %q, %v", out, err)
+	}
+}
+
+func TestPEMReset(t *testing.T) {
+	valid := pem.EncodeToMemory(&pem.Block{Type: "A", Bytes: []byte("content")})
+	f, err := filters.PEM.New(nil, ioutil.NopCloser(bytes.NewReader(valid[:len(valid)/2])))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); !iofl.IsCorrupt(err) {
+		t.Fatalf("got %v, want corrupt", err)
+	}
+	// The state of the partial block is discarded.
+	if err := f.(iofl.Resetter).Reset(ioutil.NopCloser(bytes.NewReader(valid))); err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(out) != "content" {
+		t.Errorf("got %q, %v", out, err)
+	}
+}
+
+func TestPEMResume(t *testing.T) {
+	encoded, cert1, key, cert2 := pemBlocks()
+	want := append(append(append([]byte{}, cert1...), key...), cert2...)
+	for _, n := range []int{1, 100, 301} {
+		if out := resume(t, filters.PEM, iofl.Params{iofl.ParamBufferSize: 256}, encoded, n); !bytes.Equal(out, want) {
+			t.Errorf("%d: got %d bytes, want %d", n, len(out), len(want))
+		}
+	}
+}
+
+func TestPEMParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "convert"},
+		{"mode": "encode"},
+		{"mode": "encode", "type": "BAD-TYPE"},
+		{"mode": "encode", "type": "BAD\nTYPE"},
+	} {
+		if err := filters.PEM.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.PEM.NewWriter(iofl.Params{}, nopWriteCloser{&bytes.Buffer{}}); err == nil {
+		t.Error("expected error for writer without type")
+	}
+	if _, err := filters.PEM.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
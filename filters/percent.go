@@ -0,0 +1,211 @@
+package filters
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Percent applies percent-encoding (RFC 3986) to data. Params:
+//
+//	mode:  "encode" (default) or "decode".
+//	class: The class of characters left unescaped while encoding. One of:
+//	       "component": Unreserved characters (default).
+//	       "path":      Characters permitted in a URL path segment.
+//	       "query":     Characters permitted in a URL query value.
+//	       "form":      Same as component, but spaces are encoded as "+"
+//	                    (application/x-www-form-urlencoded). When decoding,
+//	                    "+" is decoded as a space.
+//	       "none":      All bytes are escaped.
+//	safe:  Additional characters to leave unescaped while encoding.
+//
+// Decoding returns an error if a malformed escape sequence is encountered. When
+// used in a write chain, the filter applies the inverse of mode. The filter
+// honors the bufferSize param.
+var Percent = iofl.FilterDef{
+	Name:         "percent",
+	New:          newPercent,
+	NewWriter:    newPercentWriter,
+	Validate:     validatePercent,
+	Description:  "Applies or removes percent-encoding (RFC 3986).",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"encode\" or \"decode\".", Default: "encode"},
+		{Name: "class", Type: iofl.ParamString, Description: "The class of characters left unescaped while encoding: \"component\", \"path\", \"query\", \"form\", or \"none\".", Default: "component"},
+		{Name: "safe", Type: iofl.ParamString, Description: "Additional characters to leave unescaped while encoding."},
+	},
+}
+
+// percentClasses maps a class name to the set of characters it leaves
+// unescaped, not including unreserved characters.
+var percentClasses = map[string]string{
+	"component": "",
+	"path":      "!$&'()*+,;=:@",
+	"query":     "!$'()*,;:@/?",
+	"form":      "",
+	"none":      "",
+}
+
+func newPercent(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	mode, err := getMode(params, "encode", "encode", "decode")
+	if err != nil {
+		return nil, err
+	}
+	t, err := percentTransformer(params, mode)
+	if err != nil {
+		return nil, err
+	}
+	return newTransformFilter(r, t, bufferSize(params)), nil
+}
+
+func validatePercent(params iofl.Params) error {
+	mode, err := getMode(params, "encode", "encode", "decode")
+	if err != nil {
+		return err
+	}
+	_, err = percentTransformer(params, mode)
+	return err
+}
+
+// newPercentWriter returns a writer that applies the inverse of the mode
+// parameter.
+func newPercentWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	mode, err := getMode(params, "encode", "encode", "decode")
+	if err != nil {
+		return nil, err
+	}
+	if mode == "encode" {
+		mode = "decode"
+	} else {
+		mode = "encode"
+	}
+	t, err := percentTransformer(params, mode)
+	if err != nil {
+		return nil, err
+	}
+	return newTransformWriter(w, t, bufferSize(params)), nil
+}
+
+// percentTransformer returns the transformer for the given mode, configured by
+// params.
+func percentTransformer(params iofl.Params, mode string) (transformer, error) {
+	class := params.GetString("class")
+	if class == "" {
+		class = "component"
+	}
+	chars, ok := percentClasses[class]
+	if !ok {
+		return nil, fmt.Errorf("unknown class %q", class)
+	}
+	plus := class == "form"
+	if mode == "decode" {
+		return percentDecoder{plus: plus}, nil
+	}
+	e := &percentEncoder{plus: plus}
+	if class != "none" {
+		for c := 'a'; c <= 'z'; c++ {
+			e.keep[c] = true
+		}
+		for c := 'A'; c <= 'Z'; c++ {
+			e.keep[c] = true
+		}
+		for c := '0'; c <= '9'; c++ {
+			e.keep[c] = true
+		}
+		chars += "-._~"
+	}
+	chars += params.GetString("safe")
+	for i := 0; i < len(chars); i++ {
+		e.keep[chars[i]] = true
+	}
+	return e, nil
+}
+
+const upperhex = "0123456789ABCDEF"
+
+type percentEncoder struct {
+	keep [256]bool
+	plus bool
+}
+
+func (e *percentEncoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for _, c := range src {
+		switch {
+		case e.keep[c]:
+			if nDst >= len(dst) {
+				return nDst, nSrc, errShortDst
+			}
+			dst[nDst] = c
+			nDst++
+		case c == ' ' && e.plus:
+			if nDst >= len(dst) {
+				return nDst, nSrc, errShortDst
+			}
+			dst[nDst] = '+'
+			nDst++
+		default:
+			if nDst+3 > len(dst) {
+				return nDst, nSrc, errShortDst
+			}
+			dst[nDst] = '%'
+			dst[nDst+1] = upperhex[c>>4]
+			dst[nDst+2] = upperhex[c&15]
+			nDst += 3
+		}
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+type percentDecoder struct {
+	plus bool
+}
+
+func unhex(c byte) (byte, bool) {
+	switch {
+	case '0' <= c && c <= '9':
+		return c - '0', true
+	case 'a' <= c && c <= 'f':
+		return c - 'a' + 10, true
+	case 'A' <= c && c <= 'F':
+		return c - 'A' + 10, true
+	}
+	return 0, false
+}
+
+func (d percentDecoder) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for nSrc < len(src) {
+		if nDst >= len(dst) {
+			return nDst, nSrc, errShortDst
+		}
+		c := src[nSrc]
+		switch {
+		case c == '%':
+			if nSrc+3 > len(src) {
+				if atEOF {
+					return nDst, nSrc, &iofl.CorruptError{Err: fmt.Errorf("invalid escape %q", src[nSrc:])}
+				}
+				return nDst, nSrc, errShortSrc
+			}
+			hi, ok1 := unhex(src[nSrc+1])
+			lo, ok2 := unhex(src[nSrc+2])
+			if !ok1 || !ok2 {
+				return nDst, nSrc, &iofl.CorruptError{Err: fmt.Errorf("invalid escape %q", src[nSrc:nSrc+3])}
+			}
+			dst[nDst] = hi<<4 | lo
+			nSrc += 3
+		case c == '+' && d.plus:
+			dst[nDst] = ' '
+			nSrc++
+		default:
+			dst[nDst] = c
+			nSrc++
+		}
+		nDst++
+	}
+	return nDst, nSrc, nil
+}
@@ -0,0 +1,114 @@
+package filters_test
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestPercentEncode(t *testing.T) {
+	tests := []struct {
+		params iofl.Params
+		in     string
+		want   string
+	}{
+		{iofl.Params{}, "a b/c?d=e~", "a%20b%2Fc%3Fd%3De~"},
+		{iofl.Params{"class": "path"}, "a b/c:d@e", "a%20b%2Fc:d@e"},
+		{iofl.Params{"class": "query"}, "a b/c?d&e", "a%20b/c?d%26e"},
+		{iofl.Params{"class": "form"}, "a b+c", "a+b%2Bc"},
+		{iofl.Params{"class": "none"}, "az", "%61%7A"},
+		{iofl.Params{"safe": "/"}, "a/b c", "a/b%20c"},
+		{iofl.Params{}, "\xff\x00", "%FF%00"},
+	}
+	for _, tt := range tests {
+		got := mustRead(t, filters.Percent, tt.params, []byte(tt.in))
+		if string(got) != tt.want {
+			t.Errorf("encode %v %q: got %q, want %q", tt.params, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPercentDecode(t *testing.T) {
+	tests := []struct {
+		params iofl.Params
+		in     string
+		want   string
+	}{
+		{iofl.Params{"mode": "decode"}, "a%20b%2fc", "a b/c"},
+		{iofl.Params{"mode": "decode"}, "a+b", "a+b"},
+		{iofl.Params{"mode": "decode", "class": "form"}, "a+b%2B", "a b+"},
+	}
+	for _, tt := range tests {
+		got := mustRead(t, filters.Percent, tt.params, []byte(tt.in))
+		if string(got) != tt.want {
+			t.Errorf("decode %v %q: got %q, want %q", tt.params, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestPercentDecodeMalformed(t *testing.T) {
+	for _, in := range []string{"%", "ab%2", "%zz", "a%g0"} {
+		_, err := readFilter(t, filters.Percent, iofl.Params{"mode": "decode"}, []byte(in))
+		var corrupt *iofl.CorruptError
+		if !errors.As(err, &corrupt) {
+			t.Errorf("decode %q: got error %v, want CorruptError", in, err)
+		}
+	}
+}
+
+func TestPercentRoundTrip(t *testing.T) {
+	var b strings.Builder
+	for i := 0; i < 3000; i++ {
+		b.WriteByte(byte(i * 7))
+	}
+	in := b.String()
+	// A small buffer exercises escapes split across reads of the source.
+	params := iofl.Params{iofl.ParamBufferSize: 16}
+	f, err := filters.Percent.New(params, ioutil.NopCloser(iotest.OneByteReader(strings.NewReader(in))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded := mustRead(t, filters.Percent, iofl.Params{"mode": "decode", iofl.ParamBufferSize: 16}, encoded)
+	if string(decoded) != in {
+		t.Error("round trip does not match input")
+	}
+}
+
+func TestPercentWriter(t *testing.T) {
+	// A writer applies the inverse of mode.
+	got, err := writeFilter(t, filters.Percent, iofl.Params{}, []byte("a%20b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a b" {
+		t.Errorf("got %q, want %q", got, "a b")
+	}
+	got, err = writeFilter(t, filters.Percent, iofl.Params{"mode": "decode"}, []byte("a b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "a%20b" {
+		t.Errorf("got %q, want %q", got, "a%20b")
+	}
+}
+
+func TestPercentParams(t *testing.T) {
+	if err := filters.Percent.Validate(iofl.Params{"class": "bogus"}); err == nil {
+		t.Error("expected error for unknown class")
+	}
+	if err := filters.Percent.Validate(iofl.Params{"mode": "bogus"}); err == nil {
+		t.Error("expected error for unknown mode")
+	}
+	if _, err := filters.Percent.New(iofl.Params{}, nil); err == nil {
+		t.Error("expected error for missing source")
+	}
+}
@@ -0,0 +1,385 @@
+package filters
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+
+	"github.com/anaminus/iofl"
+)
+
+// ProtoDelim frames a stream of protocol buffer messages, each prefixed with
+// its length as a varint, as produced by Java's writeDelimitedTo and Go's
+// protodelim package. The filter implements iofl.Framer, with each frame being
+// the encoded message without its length prefix. Params:
+//
+//	mode:   "split" (default) or "join". When splitting, the source is a
+//	        delimited stream, which is reproduced with malformed records
+//	        handled according to policy. When joining, each frame of the
+//	        source is delimited. If the source does not implement iofl.Framer,
+//	        the entire source is treated as one message.
+//	policy: The handling of malformed records while splitting. "error"
+//	        (default) returns an error. "skip" drops the record.
+//	max:    The maximum size of a message, in bytes. Larger messages are
+//	        malformed. Defaults to 64MiB.
+//
+// While splitting, a record is malformed if it exceeds max, is truncated by the
+// end of the stream, or is not valid protocol buffer wire format. A length
+// prefix that is not a valid varint cannot be skipped, and always returns an
+// error.
+//
+// The filter implements iofl.SideOutputter, with the side output "rejected"
+// receiving each record, including its length prefix, that is dropped for
+// exceeding max or not being valid wire format. The filter reports the number
+// of records split and dropped through the RecordCounter and iofl.Reporter
+// interfaces. Message buffers are reserved from the memory budget of the
+// chain.
+var ProtoDelim = iofl.FilterDef{
+	Name:         "protodelim",
+	New:          newProtoDelim,
+	Description:  "Frames a stream of varint-delimited protocol buffer messages.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"split\" reads a delimited stream. \"join\" delimits each frame of the source.", Default: "split"},
+		{Name: "policy", Type: iofl.ParamString, Description: "The handling of malformed records while splitting, \"error\" or \"skip\".", Default: "error"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of a message, in bytes.", Default: "64MiB"},
+	},
+}
+
+// defaultMaxMessage is the default value of the max param.
+const defaultMaxMessage = 64 << 20
+
+// errMalformedRecord is returned when a malformed record is encountered.
+var errMalformedRecord error = &iofl.CorruptError{Err: errors.New("malformed record")}
+
+func newProtoDelim(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	mode, err := getMode(params, "split", "split", "join")
+	if err != nil {
+		return nil, err
+	}
+	var skip bool
+	switch policy := params.GetString("policy"); policy {
+	case "", "error":
+	case "skip":
+		skip = true
+	default:
+		return nil, fmt.Errorf("unknown policy %q", policy)
+	}
+	max := params.GetInt("max")
+	if max <= 0 {
+		max = defaultMaxMessage
+	}
+	filter := &protoDelimFilter{join: mode == "join", skip: skip, max: max, size: bufferSize(params)}
+	filter.stream.next = filter.nextRecord
+	filter.Reset(r)
+	return filter, nil
+}
+
+// protoDelimFilter implements the ProtoDelim filter.
+type protoDelimFilter struct {
+	src    io.ReadCloser
+	join   bool
+	skip   bool
+	max    int
+	size   int
+	closed bool
+
+	rejected io.Writer
+	budget   *iofl.MemoryBudget
+	records  int64
+	dropped  int64
+
+	br     *bufio.Reader
+	done   bool
+	msg    []byte
+	record []byte
+	stream frameStream
+}
+
+// ReadFrame implements iofl.Framer.
+func (f *protoDelimFilter) ReadFrame() ([]byte, error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	if f.join {
+		return f.joinFrame()
+	}
+	return f.splitFrame()
+}
+
+// joinFrame returns the next frame of the source.
+func (f *protoDelimFilter) joinFrame() ([]byte, error) {
+	if fr, ok := f.src.(iofl.Framer); ok {
+		return fr.ReadFrame()
+	}
+	if f.done {
+		return nil, io.EOF
+	}
+	f.done = true
+	msg, err := io.ReadAll(f.src)
+	if err != nil {
+		return nil, err
+	}
+	return msg, nil
+}
+
+// splitFrame reads the next well-formed message from the source.
+func (f *protoDelimFilter) splitFrame() ([]byte, error) {
+	for {
+		size, err := readUvarint(f.br)
+		if err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return nil, f.truncated("length")
+			}
+			return nil, err
+		}
+		atomic.AddInt64(&f.records, 1)
+		if size > uint64(f.max) {
+			if !f.skip {
+				return nil, fmt.Errorf("%w: size %d exceeds maximum", errMalformedRecord, size)
+			}
+			atomic.AddInt64(&f.dropped, 1)
+			w := io.Discard
+			if f.rejected != nil {
+				if err := writeUvarint(f.rejected, size); err != nil {
+					return nil, err
+				}
+				w = f.rejected
+			}
+			if _, err := io.CopyN(w, f.br, int64(size)); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if cap(f.msg) < int(size) {
+			if err := f.budget.Resize(cap(f.msg), int(size)); err != nil {
+				return nil, err
+			}
+			f.msg = make([]byte, size)
+		}
+		f.msg = f.msg[:size]
+		if _, err := io.ReadFull(f.br, f.msg); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				if f.skip {
+					atomic.AddInt64(&f.dropped, 1)
+				}
+				return nil, f.truncated("message")
+			}
+			return nil, err
+		}
+		if !validWireFormat(f.msg) {
+			if !f.skip {
+				return nil, fmt.Errorf("%w: invalid wire format", errMalformedRecord)
+			}
+			atomic.AddInt64(&f.dropped, 1)
+			if f.rejected != nil {
+				if err := writeUvarint(f.rejected, size); err != nil {
+					return nil, err
+				}
+				if _, err := f.rejected.Write(f.msg); err != nil {
+					return nil, err
+				}
+			}
+			continue
+		}
+		return f.msg, nil
+	}
+}
+
+// Stats implements RecordCounter.
+func (f *protoDelimFilter) Stats() RecordStats {
+	return RecordStats{
+		Records:  atomic.LoadInt64(&f.records),
+		Rejected: atomic.LoadInt64(&f.dropped),
+	}
+}
+
+// Report implements iofl.Reporter, reporting the stats of the filter.
+func (f *protoDelimFilter) Report() map[string]interface{} {
+	return f.Stats().report()
+}
+
+// SetMemoryBudget implements iofl.BudgetUser.
+func (f *protoDelimFilter) SetMemoryBudget(b *iofl.MemoryBudget) {
+	f.budget = b
+}
+
+// SideOutputs implements iofl.SideOutputter.
+func (f *protoDelimFilter) SideOutputs() []string {
+	return []string{"rejected"}
+}
+
+// SetSideOutput implements iofl.SideOutputter.
+func (f *protoDelimFilter) SetSideOutput(name string, w io.Writer) {
+	if name == "rejected" {
+		f.rejected = w
+	}
+}
+
+// writeUvarint writes x to w as a varint.
+func writeUvarint(w io.Writer, x uint64) error {
+	var b [binary.MaxVarintLen64]byte
+	_, err := w.Write(b[:binary.PutUvarint(b[:], x)])
+	return err
+}
+
+// truncated returns the error for a record truncated by the end of the stream.
+// When skipping, the record is dropped, ending the stream.
+func (f *protoDelimFilter) truncated(part string) error {
+	if f.skip {
+		return io.EOF
+	}
+	return fmt.Errorf("%w: truncated %s", errMalformedRecord, part)
+}
+
+// readUvarint reads a varint from r. Returns io.EOF if no bytes were read, and
+// io.ErrUnexpectedEOF if the varint is incomplete.
+func readUvarint(r io.ByteReader) (uint64, error) {
+	var x uint64
+	for i := 0; i < binary.MaxVarintLen64; i++ {
+		b, err := r.ReadByte()
+		if err != nil {
+			if err == io.EOF && i > 0 {
+				return 0, io.ErrUnexpectedEOF
+			}
+			return 0, err
+		}
+		if b < 0x80 {
+			if i == binary.MaxVarintLen64-1 && b > 1 {
+				break
+			}
+			return x | uint64(b)<<(7*i), nil
+		}
+		x |= uint64(b&0x7F) << (7 * i)
+	}
+	return 0, fmt.Errorf("%w: invalid length", errMalformedRecord)
+}
+
+// nextRecord returns the next frame with its length prefix.
+func (f *protoDelimFilter) nextRecord() ([]byte, error) {
+	if f.join {
+		msg, err := f.joinFrame()
+		if err != nil {
+			return nil, err
+		}
+		return f.delimit(msg), nil
+	}
+	msg, err := f.splitFrame()
+	if err != nil {
+		return nil, err
+	}
+	return f.delimit(msg), nil
+}
+
+// delimit returns msg prefixed with its length.
+func (f *protoDelimFilter) delimit(msg []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	f.record = append(f.record[:0], n[:binary.PutUvarint(n[:], uint64(len(msg)))]...)
+	f.record = append(f.record, msg...)
+	return f.record
+}
+
+// Source implements iofl.Filter.
+func (f *protoDelimFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *protoDelimFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	return f.stream.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *protoDelimFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *protoDelimFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.done = false
+	atomic.StoreInt64(&f.records, 0)
+	atomic.StoreInt64(&f.dropped, 0)
+	f.stream.reset()
+	if !f.join {
+		if f.br == nil {
+			f.br = bufio.NewReaderSize(src, f.size)
+		} else {
+			f.br.Reset(src)
+		}
+	}
+	return nil
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *protoDelimFilter) MemoryUsage() int {
+	n := cap(f.msg) + cap(f.record)
+	if f.br != nil {
+		n += f.br.Size()
+	}
+	return n
+}
+
+// validWireFormat returns whether b is a well-formed sequence of protocol buffer
+// fields. The contents of length-delimited fields are not examined.
+func validWireFormat(b []byte) bool {
+	var groups []uint64
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			return false
+		}
+		b = b[n:]
+		num := tag >> 3
+		if num == 0 || num > 1<<29-1 {
+			return false
+		}
+		switch tag & 7 {
+		case 0:
+			if _, n = binary.Uvarint(b); n <= 0 {
+				return false
+			}
+			b = b[n:]
+		case 1:
+			if len(b) < 8 {
+				return false
+			}
+			b = b[8:]
+		case 2:
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return false
+			}
+			b = b[n+int(size):]
+		case 3:
+			groups = append(groups, num)
+		case 4:
+			if len(groups) == 0 || groups[len(groups)-1] != num {
+				return false
+			}
+			groups = groups[:len(groups)-1]
+		case 5:
+			if len(b) < 4 {
+				return false
+			}
+			b = b[4:]
+		default:
+			return false
+		}
+	}
+	return len(groups) == 0
+}
@@ -0,0 +1,169 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// delimited returns msgs, each prefixed with its length as a varint.
+func delimited(msgs ...[]byte) []byte {
+	var b []byte
+	var n [binary.MaxVarintLen64]byte
+	for _, msg := range msgs {
+		b = append(b, n[:binary.PutUvarint(n[:], uint64(len(msg)))]...)
+		b = append(b, msg...)
+	}
+	return b
+}
+
+var protoMessages = [][]byte{
+	{0x08, 0x96, 0x01},          // 1: varint 150
+	{0x12, 0x03, 'a', 'b', 'c'}, // 2: string "abc"
+	{},                          // empty
+	{0x0D, 1, 2, 3, 4, 0x19, 1, 2, 3, 4, 5, 6, 7, 8}, // fixed32, fixed64
+	{0x1B, 0x08, 0x01, 0x1C},                         // group 3 containing 1: varint 1
+	bytes.Repeat([]byte{0x08, 0x01}, 200),            // longer than one varint byte
+}
+
+// protoFrames reads all frames from the filter of params over in.
+func protoFrames(t *testing.T, params iofl.Params, in []byte) ([][]byte, error) {
+	t.Helper()
+	f, err := filters.ProtoDelim.New(params, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var frames [][]byte
+	for {
+		frame, err := f.(iofl.Framer).ReadFrame()
+		if err == io.EOF {
+			return frames, nil
+		}
+		if err != nil {
+			return frames, err
+		}
+		frames = append(frames, append([]byte{}, frame...))
+	}
+}
+
+func TestProtoDelimSplit(t *testing.T) {
+	in := delimited(protoMessages...)
+	frames, err := protoFrames(t, nil, in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(frames) != len(protoMessages) {
+		t.Fatalf("got %d frames, want %d", len(frames), len(protoMessages))
+	}
+	for i, frame := range frames {
+		if !bytes.Equal(frame, protoMessages[i]) {
+			t.Errorf("frame %d: got %x, want %x", i, frame, protoMessages[i])
+		}
+	}
+	if out := mustRead(t, filters.ProtoDelim, iofl.Params{"bufferSize": 16}, in); !bytes.Equal(out, in) {
+		t.Error("split stream does not reproduce source")
+	}
+}
+
+func TestProtoDelimJoin(t *testing.T) {
+	in := delimited(protoMessages...)
+	split, err := filters.ProtoDelim.New(nil, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := readFilterFrom(t, filters.ProtoDelim, iofl.Params{"mode": "join"}, split)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Error("joined frames do not match source")
+	}
+
+	// Without a Framer, the source is one message.
+	msg := protoMessages[1]
+	if out := mustRead(t, filters.ProtoDelim, iofl.Params{"mode": "join"}, msg); !bytes.Equal(out, delimited(msg)) {
+		t.Errorf("got %x, want %x", out, delimited(msg))
+	}
+}
+
+func TestProtoDelimCorrupt(t *testing.T) {
+	valid := delimited(protoMessages[0])
+	tests := []struct {
+		name   string
+		in     []byte
+		params iofl.Params
+	}{
+		{"oversize", delimited(protoMessages[1]), iofl.Params{"max": 3}},
+		{"zero field", delimited([]byte{0x00, 0x01}), nil},
+		{"bad wire type", delimited([]byte{0x0F}), nil},
+		{"short fixed64", delimited([]byte{0x09, 1, 2}), nil},
+		{"short string", delimited([]byte{0x12, 0x05, 'a'}), nil},
+		{"unclosed group", delimited([]byte{0x1B}), nil},
+		{"mismatched group", delimited([]byte{0x1B, 0x24}), nil},
+		{"truncated message", []byte{0x05, 0x08}, nil},
+		{"truncated length", []byte{0x80}, nil},
+		{"invalid length", bytes.Repeat([]byte{0xFF}, 11), nil},
+	}
+	for _, tt := range tests {
+		frames, err := protoFrames(t, tt.params, append(append([]byte{}, valid...), tt.in...))
+		if !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got error %v, want corrupt", tt.name, err)
+		}
+		if len(frames) != 1 {
+			t.Errorf("%s: got %d frames before error, want 1", tt.name, len(frames))
+		}
+	}
+}
+
+func TestProtoDelimSkip(t *testing.T) {
+	bad := []byte{0x00}
+	big := protoMessages[5]
+	in := delimited(protoMessages[0], bad, big, protoMessages[1])
+	params := iofl.Params{"policy": "skip", "max": 100}
+	f, err := filters.ProtoDelim.New(params, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var rejected bytes.Buffer
+	f.(iofl.SideOutputter).SetSideOutput("rejected", &rejected)
+	out, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := delimited(protoMessages[0], protoMessages[1]); !bytes.Equal(out, want) {
+		t.Errorf("got %x, want %x", out, want)
+	}
+	if want := delimited(bad, big); !bytes.Equal(rejected.Bytes(), want) {
+		t.Errorf("rejected: got %x, want %x", rejected.Bytes(), want)
+	}
+	if got := f.(filters.RecordCounter).Stats(); got != (filters.RecordStats{Records: 4, Rejected: 2}) {
+		t.Errorf("got stats %+v", got)
+	}
+	f.Close()
+
+	// A truncated record ends the stream.
+	frames, err := protoFrames(t, params, append(delimited(protoMessages[0]), 0x05, 0x08))
+	if err != nil || len(frames) != 1 {
+		t.Errorf("truncated: got %d frames, %v", len(frames), err)
+	}
+}
+
+func TestProtoDelimParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "merge"},
+		{"policy": "ignore"},
+	} {
+		if _, err := filters.ProtoDelim.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.ProtoDelim.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,133 @@
+package filters
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// RateLimit limits the rate at which data is read from the source. Params:
+//
+//	rate:     The rate of the filter, in bytes per second. If zero, only the
+//	          shared limiter applies.
+//	burst:    The burst size of rate, in bytes. Defaults to rate.
+//	bucket:   The name of a Limiter in iofl.DefaultBandwidth that is shared
+//	          with other filters, capping their combined rate. The Limiter
+//	          must exist when the filter is created.
+//	priority: The priority with which the filter waits on the limiters. One of
+//	          "background", "normal" (default), or "interactive".
+//
+// Each Read waits on both the filter's own rate and the shared limiter, if
+// given. A Read returns at most burst bytes.
+//
+// Register registers the filter as returned by RateLimitIn with the Bandwidth
+// of the ChainSet instead.
+var RateLimit = RateLimitIn(iofl.DefaultBandwidth)
+
+// RateLimitIn returns the definition of the RateLimit filter, where the bucket
+// param names a Limiter in b.
+func RateLimitIn(b *iofl.Bandwidth) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: "ratelimit",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return newRateLimit(b, params, r)
+		},
+		Description:  "Limits the rate at which data is read from the source.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "rate", Type: iofl.ParamInt, Description: "The rate of the filter, in bytes per second.", Default: "unlimited"},
+			{Name: "burst", Type: iofl.ParamInt, Description: "The burst size of rate, in bytes.", Default: "rate"},
+			{Name: "bucket", Type: iofl.ParamString, Description: "The name of a shared Limiter in the Bandwidth of the filter."},
+			{Name: "priority", Type: iofl.ParamString, Description: "The priority with which the filter waits: \"background\", \"normal\", or \"interactive\".", Default: "normal"},
+		},
+	}
+}
+
+// priorities maps the name of a priority to its value.
+var priorities = map[string]iofl.Priority{
+	"background":  iofl.PriorityBackground,
+	"normal":      iofl.PriorityNormal,
+	"interactive": iofl.PriorityInteractive,
+}
+
+// newRateLimit constructs the RateLimit filter, attaching to the Limiters of b.
+func newRateLimit(b *iofl.Bandwidth, params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	rate := int64(params.GetInt("rate"))
+	burst := int64(params.GetInt("burst"))
+	if rate < 0 || burst < 0 {
+		return nil, fmt.Errorf("rate and burst must not be negative")
+	}
+	if burst == 0 {
+		burst = rate
+	}
+	filter := &rateLimitFilter{src: r, burst: int(burst)}
+	if rate > 0 {
+		filter.own = iofl.NewLimiter(rate, burst)
+	}
+	if name := params.GetString("bucket"); name != "" {
+		if filter.shared = b.Limiter(name); filter.shared == nil {
+			return nil, fmt.Errorf("unknown bandwidth bucket %q", name)
+		}
+	}
+	if p := params.GetString("priority"); p != "" {
+		var ok bool
+		if filter.priority, ok = priorities[p]; !ok {
+			return nil, fmt.Errorf("unknown priority %q", p)
+		}
+	}
+	return filter, nil
+}
+
+// rateLimitFilter implements the RateLimit filter.
+type rateLimitFilter struct {
+	src      io.ReadCloser
+	own      *iofl.Limiter
+	shared   *iofl.Limiter
+	priority iofl.Priority
+	burst    int
+	closed   bool
+}
+
+// Source implements iofl.Filter.
+func (f *rateLimitFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *rateLimitFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if f.burst > 0 && len(p) > f.burst {
+		p = p[:f.burst]
+	}
+	n, err = f.src.Read(p)
+	f.own.WaitN(f.priority, n)
+	f.shared.WaitN(f.priority, n)
+	return n, err
+}
+
+// Close implements io.Closer, closing the source.
+func (f *rateLimitFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// PreservesSize implements iofl.SizePreserver.
+func (f *rateLimitFilter) PreservesSize() bool {
+	return true
+}
+
+// Reset implements iofl.Resetter.
+func (f *rateLimitFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	return nil
+}
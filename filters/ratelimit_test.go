@@ -0,0 +1,124 @@
+package filters_test
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestRateLimit(t *testing.T) {
+	in := bytes.Repeat([]byte("x"), 300)
+	start := time.Now()
+	out := mustRead(t, filters.RateLimit, iofl.Params{"rate": 2000, "burst": 100}, in)
+	if !bytes.Equal(out, in) {
+		t.Error("output mismatch")
+	}
+	// The first 100 bytes are the burst, and the remaining 200 take 100ms.
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("rate not limited: took %v", d)
+	}
+}
+
+func TestRateLimitBurst(t *testing.T) {
+	f, err := filters.RateLimit.New(iofl.Params{"rate": 1 << 20, "burst": 10}, ioutil.NopCloser(bytes.NewReader(make([]byte, 100))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if n, _ := f.Read(make([]byte, 100)); n != 10 {
+		t.Errorf("got %d bytes, want at most burst of 10", n)
+	}
+}
+
+func TestRateLimitBucket(t *testing.T) {
+	iofl.DefaultBandwidth.Set("test-ratelimit", 2000, 100)
+	in := bytes.Repeat([]byte("x"), 150)
+	start := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < 2; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := readFilter(t, filters.RateLimit, iofl.Params{"bucket": "test-ratelimit"}, in)
+			if err != nil || !bytes.Equal(out, in) {
+				t.Errorf("got %d bytes, %v", len(out), err)
+			}
+		}()
+	}
+	wg.Wait()
+	// The first read takes the full bucket and 50 bytes more, so the second
+	// waits 75ms for the bucket to refill.
+	if d := time.Since(start); d < 60*time.Millisecond {
+		t.Errorf("shared rate not limited: took %v", d)
+	}
+}
+
+func TestRateLimitChainSet(t *testing.T) {
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{
+		Chains:    map[string]iofl.Chain{"c": {{Filter: "ratelimit", Params: iofl.Params{"bucket": "test-ratelimit-set"}}}},
+		Bandwidth: map[string]int64{"test-ratelimit-set": 1000},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The bucket is located in the Bandwidth of the ChainSet.
+	if iofl.DefaultBandwidth.Limiter("test-ratelimit-set") != nil {
+		t.Fatal("bucket configured in DefaultBandwidth")
+	}
+	f, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(make([]byte, 1100))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if out, err := ioutil.ReadAll(f); err != nil || len(out) != 1100 {
+		t.Errorf("got %d bytes, %v", len(out), err)
+	}
+	f.Close()
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("shared rate not limited: took %v", d)
+	}
+
+	// Removing the bucket from the configuration leaves the chain invalid.
+	config := s.Config()
+	config.Bandwidth = nil
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("expected error for removed bucket")
+	}
+}
+
+func TestRateLimitParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"rate": -1},
+		{"burst": -1},
+		{"bucket": "test-ratelimit-missing"},
+		{"priority": "urgent"},
+	} {
+		if _, err := filters.RateLimit.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.RateLimit.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+	// Without a rate or bucket, the filter does not limit.
+	in := make([]byte, 1<<20)
+	start := time.Now()
+	if out := mustRead(t, filters.RateLimit, iofl.Params{"priority": "background"}, in); len(out) != len(in) {
+		t.Error("output mismatch")
+	}
+	if time.Since(start) > time.Second {
+		t.Error("unlimited filter was slow")
+	}
+}
@@ -0,0 +1,156 @@
+package filters
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Route returns the definition of a filter that sniffs a prefix of its source
+// to determine whether the content is text or binary, then sends the content
+// through one of two chains resolved from s. Params:
+//
+//	text:   The chain applied to text content. If empty, text content passes
+//	        through unchanged.
+//	binary: The chain applied to binary content. If empty, binary content
+//	        passes through unchanged.
+//	sniff:  The number of bytes examined to classify the content. Defaults to
+//	        512.
+//
+// Content is considered text if the prefix is valid UTF-8, contains no NUL
+// bytes, and consists of few control characters. The filter reports the
+// classification through the Router interface. An error resolving the selected
+// chain is returned by the first Read.
+func Route(s *iofl.ChainSet) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:         "route",
+		Description:  "Sends content through one of two chains, depending on whether it is text or binary.",
+		StrictParams: true,
+		Params: []iofl.ParamDef{
+			{Name: "text", Type: iofl.ParamString, Description: "The chain applied to text content.", Default: "unchanged", Chain: true},
+			{Name: "binary", Type: iofl.ParamString, Description: "The chain applied to binary content.", Default: "unchanged", Chain: true},
+			{Name: "sniff", Type: iofl.ParamInt, Description: "The number of bytes examined to classify the content.", Default: "512"},
+		},
+		New: func(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+			if r == nil {
+				return nil, errNoSource
+			}
+			sniff := params.GetInt("sniff")
+			if sniff <= 0 {
+				sniff = 512
+			}
+			return &routeFilter{
+				set:    s,
+				src:    r,
+				text:   params.GetString("text"),
+				binary: params.GetString("binary"),
+				sniff:  sniff,
+			}, nil
+		},
+	}
+}
+
+// Router is implemented by filters that route content through one of several
+// chains.
+type Router interface {
+	// Route returns the name of the route that was selected, or an empty
+	// string if no route has been selected yet.
+	Route() string
+}
+
+// routeFilter implements the Route filter.
+type routeFilter struct {
+	set    *iofl.ChainSet
+	src    io.ReadCloser
+	text   string
+	binary string
+	sniff  int
+
+	route  string
+	r      iofl.Filter
+	err    error
+	closed bool
+}
+
+// resolve sniffs the source and resolves the selected chain, if this has not
+// already been done.
+func (f *routeFilter) resolve() {
+	if f.r != nil || f.err != nil {
+		return
+	}
+	prefix, eof, err := readPrefix(f.src, f.sniff)
+	if err != nil {
+		f.err = err
+		return
+	}
+	chain := f.binary
+	f.route = "binary"
+	if isText(prefix, eof) {
+		chain = f.text
+		f.route = "text"
+	}
+	src := prefixCloser{io.MultiReader(bytes.NewReader(prefix), f.src), f.src}
+	if chain == "" {
+		f.r = iofl.Root{ReadCloser: src}
+		return
+	}
+	if f.r, f.err = f.set.Resolve(chain, src); f.err != nil {
+		// Resolve closed the source, so it is not closed again.
+		f.r = iofl.Root{ReadCloser: closedChain{}}
+		f.err = fmt.Errorf("%s: %w", f.route, f.err)
+	}
+}
+
+// Route implements Router.
+func (f *routeFilter) Route() string {
+	return f.route
+}
+
+// Source implements iofl.Filter.
+func (f *routeFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *routeFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	f.resolve()
+	if f.err != nil {
+		return 0, f.err
+	}
+	return f.r.Read(p)
+}
+
+// Close implements io.Closer, closing the selected chain and the source.
+func (f *routeFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	if f.r != nil {
+		return f.r.Close()
+	}
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *routeFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.route = ""
+	f.r = nil
+	f.err = nil
+	f.closed = false
+	return nil
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *routeFilter) MemoryUsage() int {
+	if f.r == nil {
+		return 0
+	}
+	return iofl.MemoryUsage(f.r)
+}
@@ -0,0 +1,108 @@
+package filters_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// routeSet returns a ChainSet with the built-in filters registered and a
+// route chain that upper-cases text and deletes zeros from binary content.
+func routeSet(t *testing.T, params iofl.Params) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"route": {{Filter: "route", Params: params}},
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"strip": {{Filter: "translate", Params: iofl.Params{"delete": "\\x00"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRoute(t *testing.T) {
+	s := routeSet(t, iofl.Params{"text": "upper", "binary": "strip"})
+	tests := []struct {
+		in, want, route string
+	}{
+		{"hello\nworld\t!", "HELLO\nWORLD\t!", "text"},
+		{"caf\xc3\xa9", "CAF\xc3\xa9", "text"},
+		{"", "", "text"},
+		{"a\x00b\x00c", "abc", "binary"},
+		{"\x01\x02\x03\x04abc", "\x01\x02\x03\x04abc", "binary"},
+		{"caf\xe9", "caf\xe9", "binary"},
+	}
+	for _, tt := range tests {
+		f, err := s.Resolve("route", ioutil.NopCloser(bytes.NewReader([]byte(tt.in))))
+		if err != nil {
+			t.Fatal(err)
+		}
+		var router filters.Router
+		iofl.Apply(f, func(r io.ReadCloser) error {
+			if v, ok := r.(filters.Router); ok {
+				router = v
+			}
+			return nil
+		})
+		if router == nil {
+			t.Fatal("no Router in chain")
+		}
+		if router.Route() != "" {
+			t.Errorf("%q: route selected before read", tt.in)
+		}
+		out, err := ioutil.ReadAll(f)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+		if string(out) != tt.want {
+			t.Errorf("%q: got %q, want %q", tt.in, out, tt.want)
+		}
+		if got := router.Route(); got != tt.route {
+			t.Errorf("%q: got route %q, want %q", tt.in, got, tt.route)
+		}
+	}
+}
+
+func TestRouteSniffLimit(t *testing.T) {
+	// Binary content beyond the sniffed prefix does not affect the route.
+	s := routeSet(t, iofl.Params{"text": "upper", "sniff": 4})
+	f, err := s.Resolve("route", ioutil.NopCloser(bytes.NewReader([]byte("text\x00"))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || string(out) != "TEXT\x00" {
+		t.Errorf("got %q, %v", out, err)
+	}
+}
+
+func TestRouteUnknownChain(t *testing.T) {
+	// An error resolving the selected chain is returned by the first Read.
+	s := routeSet(t, iofl.Params{"text": "missing"})
+	src := &closeCounter{Reader: bytes.NewReader([]byte("text"))}
+	f, err := s.Resolve("route", src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); err == nil || !strings.HasPrefix(err.Error(), "text: ") {
+		t.Errorf("got error %v, want text route error", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Error(err)
+	}
+	if src.n != 1 {
+		t.Errorf("source closed %d times", src.n)
+	}
+}
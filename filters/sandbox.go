@@ -0,0 +1,105 @@
+package filters
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+	"sync/atomic"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// ResourceExceeded is returned when a sandboxed process exceeds its CPU time
+// limit.
+var ResourceExceeded = errors.New("resource limit exceeded")
+
+// Sandbox limits the resources available to an external process run by a
+// filter, such as one that runs a command or script, so that an untrusted
+// transform cannot exhaust the resources of the host.
+//
+// Limits on CPU time and memory, and network isolation, are supported only on
+// Linux. Starting a process with these limits on other platforms returns an
+// error. Network isolation uses user and network namespaces, which may be
+// disabled for unprivileged processes by the host.
+type Sandbox struct {
+	// CPU is the maximum CPU time of the process, rounded up to a whole
+	// second. The process is killed when the limit is exceeded. If zero, CPU
+	// time is not limited.
+	CPU time.Duration
+	// Memory is the maximum size of the address space of the process, in
+	// bytes. Allocations beyond the limit fail. If zero, memory is not
+	// limited.
+	Memory int64
+	// Timeout is the maximum wall-clock time of the process. The process is
+	// killed when the limit is exceeded. If zero, time is not limited.
+	Timeout time.Duration
+	// NoNetwork runs the process without access to the network.
+	NoNetwork bool
+}
+
+// SandboxParams documents the params read by ParseSandbox, for inclusion in the
+// Params of a filter definition.
+var SandboxParams = []iofl.ParamDef{
+	{Name: "cpu", Type: iofl.ParamDuration, Description: "The maximum CPU time of the process, as a duration string or seconds."},
+	{Name: "memory", Type: iofl.ParamInt, Description: "The maximum size of the address space of the process, in bytes."},
+	{Name: "timeout", Type: iofl.ParamDuration, Description: "The maximum wall-clock time of the process, as a duration string or seconds."},
+	{Name: "network", Type: iofl.ParamBool, Description: "Whether the process may access the network.", Default: "true"},
+}
+
+// ParseSandbox returns the Sandbox configured by params, as documented by
+// SandboxParams. Absent params do not limit.
+func ParseSandbox(params iofl.Params) (s Sandbox, err error) {
+	for _, def := range SandboxParams {
+		if v, ok := params[def.Name]; ok {
+			if err := def.Type.Check(v); err != nil {
+				return s, fmt.Errorf("%s: %w", def.Name, err)
+			}
+		}
+	}
+	if s.CPU = params.GetDuration("cpu"); s.CPU < 0 {
+		return s, fmt.Errorf("cpu: must not be negative")
+	}
+	if s.Timeout = params.GetDuration("timeout"); s.Timeout < 0 {
+		return s, fmt.Errorf("timeout: must not be negative")
+	}
+	if s.Memory = int64(params.GetInt("memory")); s.Memory < 0 {
+		return s, fmt.Errorf("memory: must not be negative")
+	}
+	if _, ok := params["network"]; ok {
+		s.NoNetwork = !params.GetBool("network")
+	}
+	return s, nil
+}
+
+// Start starts cmd within the sandbox. The returned function waits for the
+// command to exit, as by cmd.Wait, and must be called to release the
+// resources of the sandbox. If the process was killed for exceeding its
+// limits, the error wraps iofl.TimedOut for the wall-clock limit, or
+// ResourceExceeded for the CPU limit.
+func (s Sandbox) Start(cmd *exec.Cmd) (wait func() error, err error) {
+	if err := s.start(cmd); err != nil {
+		return nil, err
+	}
+	var timedOut int32
+	var timer *time.Timer
+	if s.Timeout > 0 {
+		timer = time.AfterFunc(s.Timeout, func() {
+			atomic.StoreInt32(&timedOut, 1)
+			cmd.Process.Kill()
+		})
+	}
+	return func() error {
+		err := cmd.Wait()
+		if timer != nil {
+			timer.Stop()
+		}
+		switch {
+		case atomic.LoadInt32(&timedOut) == 1:
+			return fmt.Errorf("%w: wall-clock limit of %s exceeded", iofl.TimedOut, s.Timeout)
+		case s.CPU > 0 && s.cpuExceeded(err):
+			return fmt.Errorf("%w: CPU limit of %s exceeded", ResourceExceeded, s.CPU)
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,131 @@
+//go:build linux
+// +build linux
+
+package filters
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+// start starts cmd with the limits of the sandbox. Resource limits must apply
+// to the command from its first instruction, but cannot be set on the child
+// between fork and exec by os/exec. Instead, the child is started as a tracee,
+// which stops once execve has loaded the command, and is released once its
+// limits have been set.
+func (s Sandbox) start(cmd *exec.Cmd) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if s.NoNetwork {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWUSER | syscall.CLONE_NEWNET
+		cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getuid(), HostID: os.Getuid(), Size: 1}}
+		cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: os.Getgid(), HostID: os.Getgid(), Size: 1}}
+	}
+	if s.CPU <= 0 && s.Memory <= 0 {
+		return cmd.Start()
+	}
+
+	// Requests to a tracee must be made from the thread that started it.
+	runtime.LockOSThread()
+	defer runtime.UnlockOSThread()
+	cmd.SysProcAttr.Ptrace = true
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	pid := cmd.Process.Pid
+	var status syscall.WaitStatus
+	for {
+		_, err := syscall.Wait4(pid, &status, syscall.WALL, nil)
+		if err == syscall.EINTR {
+			continue
+		}
+		if err != nil {
+			cmd.Process.Kill()
+			cmd.Wait()
+			return os.NewSyscallError("wait4", err)
+		}
+		break
+	}
+	if !status.Stopped() {
+		// The child has been reaped, such as when it was killed by the
+		// context of cmd. Wait releases the resources of cmd regardless.
+		cmd.Wait()
+		return errors.New("process exited before limits were applied")
+	}
+	err := s.limit(pid)
+	if err != nil {
+		cmd.Process.Kill()
+	}
+	if derr := syscall.PtraceDetach(pid); derr != nil && err == nil {
+		cmd.Process.Kill()
+		err = os.NewSyscallError("ptrace", derr)
+	}
+	if err != nil {
+		cmd.Wait()
+		return err
+	}
+	return nil
+}
+
+// cpuLimit returns the CPU limit of the sandbox in whole seconds.
+func (s Sandbox) cpuLimit() uint64 {
+	return uint64((s.CPU + time.Second - 1) / time.Second)
+}
+
+// limit applies resource limits to the process of the given pid. The soft
+// limit of CPU time sends SIGXCPU, and the hard limit, a second later, kills
+// the process if SIGXCPU was handled.
+func (s Sandbox) limit(pid int) error {
+	if s.CPU > 0 {
+		secs := s.cpuLimit()
+		if err := prlimit(pid, syscall.RLIMIT_CPU, secs, secs+1); err != nil {
+			return err
+		}
+	}
+	if s.Memory > 0 {
+		if err := prlimit(pid, syscall.RLIMIT_AS, uint64(s.Memory), uint64(s.Memory)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// prlimit sets the soft and hard limit of resource for the process of the
+// given pid.
+func prlimit(pid int, resource int, soft, hard uint64) error {
+	rlimit := syscall.Rlimit{Cur: soft, Max: hard}
+	_, _, errno := syscall.RawSyscall6(syscall.SYS_PRLIMIT64, uintptr(pid), uintptr(resource), uintptr(unsafe.Pointer(&rlimit)), 0, 0, 0)
+	if errno != 0 {
+		return os.NewSyscallError("prlimit", errno)
+	}
+	return nil
+}
+
+// cpuExceeded returns whether err indicates that a process was killed for
+// exceeding its CPU time limit. A process killed by SIGKILL is attributed to
+// the limit only if it has used the CPU time allowed by the limit, since it
+// may otherwise have been killed by the filter, its context, or the host.
+func (s Sandbox) cpuExceeded(err error) bool {
+	var exit *exec.ExitError
+	if !errors.As(err, &exit) {
+		return false
+	}
+	status, ok := exit.Sys().(syscall.WaitStatus)
+	if !ok || !status.Signaled() {
+		return false
+	}
+	switch status.Signal() {
+	case syscall.SIGXCPU:
+		return true
+	case syscall.SIGKILL:
+		used := exit.UserTime() + exit.SystemTime()
+		return used >= time.Duration(s.cpuLimit())*time.Second
+	}
+	return false
+}
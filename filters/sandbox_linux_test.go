@@ -0,0 +1,95 @@
+//go:build linux
+// +build linux
+
+package filters_test
+
+import (
+	"bytes"
+	"errors"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl/filters"
+)
+
+// sandboxCat returns the content of a file of /proc/self, as read by a process
+// started within s.
+func sandboxCat(t *testing.T, s filters.Sandbox, name string) string {
+	t.Helper()
+	var out bytes.Buffer
+	cmd := exec.Command("cat", "/proc/self/"+name)
+	cmd.Stdout = &out
+	wait, err := s.Start(cmd)
+	if err != nil {
+		t.Skipf("cannot start sandbox: %v", err)
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	return out.String()
+}
+
+// limitLine returns the line of limits describing the named limit, with
+// spacing collapsed.
+func limitLine(limits, name string) string {
+	for _, line := range strings.Split(limits, "\n") {
+		if strings.HasPrefix(line, name) {
+			return strings.Join(strings.Fields(line), " ")
+		}
+	}
+	return ""
+}
+
+func TestSandboxLimits(t *testing.T) {
+	limits := sandboxCat(t, filters.Sandbox{CPU: 1500 * time.Millisecond, Memory: 256 << 20}, "limits")
+	// CPU time is rounded up to whole seconds, with a hard limit a second
+	// later.
+	if got, want := limitLine(limits, "Max cpu time"), "Max cpu time 2 3 seconds"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := limitLine(limits, "Max address space"), "Max address space 268435456 268435456 bytes"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestSandboxCPU(t *testing.T) {
+	if testing.Short() {
+		t.Skip("exceeds a CPU limit of one second")
+	}
+	s := filters.Sandbox{CPU: time.Second}
+	wait, err := s.Start(exec.Command("sh", "-c", "while :; do :; done"))
+	if err != nil {
+		t.Skipf("cannot start sandbox: %v", err)
+	}
+	if err := wait(); !errors.Is(err, filters.ResourceExceeded) {
+		t.Errorf("got %v, want ResourceExceeded", err)
+	}
+
+	// A process killed for another reason is not attributed to the limit.
+	cmd := exec.Command("sleep", "10")
+	wait, err = s.Start(cmd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd.Process.Kill()
+	if err := wait(); err == nil || errors.Is(err, filters.ResourceExceeded) {
+		t.Errorf("got %v, want exit error", err)
+	}
+}
+
+func TestSandboxNoNetwork(t *testing.T) {
+	// Only the loopback interface exists within the network namespace of the
+	// process.
+	dev := sandboxCat(t, filters.Sandbox{NoNetwork: true}, "net/dev")
+	var ifaces []string
+	for _, line := range strings.Split(dev, "\n") {
+		if i := strings.IndexByte(line, ':'); i >= 0 {
+			ifaces = append(ifaces, strings.TrimSpace(line[:i]))
+		}
+	}
+	if len(ifaces) != 1 || ifaces[0] != "lo" {
+		t.Errorf("got interfaces %q", ifaces)
+	}
+}
@@ -0,0 +1,27 @@
+//go:build !linux
+// +build !linux
+
+package filters
+
+import (
+	"errors"
+	"os/exec"
+)
+
+// errSandboxUnsupported is returned when a sandbox limit is not supported on
+// the current platform.
+var errSandboxUnsupported = errors.New("sandbox limit not supported on this platform")
+
+// start starts cmd with the limits of the sandbox.
+func (s Sandbox) start(cmd *exec.Cmd) error {
+	if s.NoNetwork || s.CPU > 0 || s.Memory > 0 {
+		return errSandboxUnsupported
+	}
+	return cmd.Start()
+}
+
+// cpuExceeded returns whether err indicates that a process was killed for
+// exceeding its CPU time limit.
+func (s Sandbox) cpuExceeded(err error) bool {
+	return false
+}
@@ -0,0 +1,73 @@
+package filters_test
+
+import (
+	"errors"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestParseSandbox(t *testing.T) {
+	tests := []struct {
+		params iofl.Params
+		want   filters.Sandbox
+	}{
+		{nil, filters.Sandbox{}},
+		{iofl.Params{"cpu": "1500ms", "timeout": 2.5}, filters.Sandbox{CPU: 1500 * time.Millisecond, Timeout: 2500 * time.Millisecond}},
+		{iofl.Params{"memory": 1 << 20}, filters.Sandbox{Memory: 1 << 20}},
+		{iofl.Params{"network": false}, filters.Sandbox{NoNetwork: true}},
+		{iofl.Params{"network": true}, filters.Sandbox{}},
+	}
+	for _, tt := range tests {
+		got, err := filters.ParseSandbox(tt.params)
+		if err != nil {
+			t.Errorf("%v: %v", tt.params, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%v: got %+v, want %+v", tt.params, got, tt.want)
+		}
+	}
+	for _, params := range []iofl.Params{
+		{"cpu": "-1s"},
+		{"timeout": -1},
+		{"memory": -1},
+		{"cpu": "fast"},
+		{"memory": "lots"},
+		{"network": "off"},
+	} {
+		if _, err := filters.ParseSandbox(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+}
+
+func TestSandboxTimeout(t *testing.T) {
+	if _, err := exec.LookPath("sleep"); err != nil {
+		t.Skip("sleep not available")
+	}
+	s := filters.Sandbox{Timeout: 50 * time.Millisecond}
+	wait, err := s.Start(exec.Command("sleep", "10"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	if err := wait(); !errors.Is(err, iofl.TimedOut) {
+		t.Errorf("got %v, want TimedOut", err)
+	}
+	if d := time.Since(start); d > 5*time.Second {
+		t.Errorf("process ran for %v", d)
+	}
+
+	// A process that exits within the limit is unaffected.
+	wait, err = s.Start(exec.Command("sleep", "0"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wait(); err != nil {
+		t.Errorf("got %v", err)
+	}
+}
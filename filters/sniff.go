@@ -0,0 +1,55 @@
+package filters
+
+import (
+	"io"
+	"unicode/utf8"
+)
+
+// readPrefix reads up to n bytes from r. Returns the bytes read, and whether
+// the end of r was reached.
+func readPrefix(r io.Reader, n int) (prefix []byte, eof bool, err error) {
+	prefix = make([]byte, n)
+	n, err = io.ReadFull(r, prefix)
+	switch err {
+	case nil:
+		return prefix, false, nil
+	case io.EOF, io.ErrUnexpectedEOF:
+		return prefix[:n], true, nil
+	default:
+		return nil, false, err
+	}
+}
+
+// trimPartialRune removes an incomplete UTF-8 sequence from the end of b.
+func trimPartialRune(b []byte) []byte {
+	for i := 1; i < utf8.UTFMax && i <= len(b); i++ {
+		if utf8.RuneStart(b[len(b)-i]) {
+			if !utf8.FullRune(b[len(b)-i:]) {
+				return b[:len(b)-i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// isText returns whether prefix appears to be UTF-8 text. eof indicates
+// whether prefix is the entire stream.
+func isText(prefix []byte, eof bool) bool {
+	if !eof {
+		prefix = trimPartialRune(prefix)
+	}
+	if !utf8.Valid(prefix) {
+		return false
+	}
+	controls := 0
+	for _, c := range prefix {
+		switch {
+		case c == 0:
+			return false
+		case c < 0x20 && c != '\t' && c != '\n' && c != '\r' && c != '\f' && c != '\b' && c != 0x1B:
+			controls++
+		}
+	}
+	return controls*10 <= len(prefix)
+}
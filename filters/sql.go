@@ -0,0 +1,255 @@
+package filters
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/anaminus/iofl"
+)
+
+// SQL is a source filter that produces the content of a column, such as a
+// BLOB or bytea, from the result of a database query made with database/sql.
+// The database driver must be registered by the program. Params:
+//
+//	driver: The name of the database driver. Required.
+//	dsn:    The data source name passed to the driver. Required.
+//	query:  The query to execute. Required.
+//	args:   A list of arguments to the query.
+//	column: The name of the column to produce. Defaults to the first column.
+//	rows:   "first" (default) produces the column of the first row, returning
+//	        an error if there are no rows. "all" produces the concatenation of
+//	        the column of each row, such as for content stored as a sequence of
+//	        ordered chunks.
+//
+// The query is executed on the first Read, and is canceled by the context of
+// the chain, if any, as it implements iofl.ContextFilter. A NULL value produces
+// no content.
+// Databases are opened once per driver and data source name, and are shared
+// between filters. Most drivers load each value fully into memory, so large
+// values are best split across rows.
+//
+// A configuration using SQL can query any database reachable by a registered
+// driver, so SQL is registered by RegisterUnsafe rather than Register.
+var SQL = iofl.FilterDef{
+	Name:         "sql",
+	New:          newSQL,
+	Description:  "Produces the content of a column from the result of a database query.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "driver", Type: iofl.ParamString, Required: true, Description: "The name of the database driver. Required."},
+		{Name: "dsn", Type: iofl.ParamString, Required: true, Description: "The data source name passed to the driver. Required."},
+		{Name: "query", Type: iofl.ParamString, Required: true, Description: "The query to execute. Required."},
+		{Name: "args", Type: iofl.ParamList, Description: "A list of arguments to the query."},
+		{Name: "column", Type: iofl.ParamString, Description: "The name of the column to produce.", Default: "the first column"},
+		{Name: "rows", Type: iofl.ParamString, Description: "\"first\" produces the first row. \"all\" concatenates every row.", Default: "first"},
+	},
+}
+
+// errHasSource is returned by a source filter that received a source.
+var errHasSource = errors.New("filter does not accept a source")
+
+// sqlDBs caches open databases by driver and data source name.
+var sqlDBs struct {
+	sync.Mutex
+	m map[[2]string]*sql.DB
+}
+
+// openDB returns the database for the given driver and data source name,
+// opening it if necessary.
+func openDB(driver, dsn string) (*sql.DB, error) {
+	sqlDBs.Lock()
+	defer sqlDBs.Unlock()
+	key := [2]string{driver, dsn}
+	if db, ok := sqlDBs.m[key]; ok {
+		return db, nil
+	}
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, err
+	}
+	if sqlDBs.m == nil {
+		sqlDBs.m = map[[2]string]*sql.DB{}
+	}
+	sqlDBs.m[key] = db
+	return db, nil
+}
+
+func newSQL(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r != nil {
+		return nil, errHasSource
+	}
+	filter := &sqlFilter{
+		query:  params.GetString("query"),
+		column: params.GetString("column"),
+	}
+	driver := params.GetString("driver")
+	dsn := params.GetString("dsn")
+	switch {
+	case driver == "":
+		return nil, errors.New("driver required")
+	case dsn == "":
+		return nil, errors.New("dsn required")
+	case filter.query == "":
+		return nil, errors.New("query required")
+	}
+	switch rows := params.GetString("rows"); rows {
+	case "", "first":
+	case "all":
+		filter.all = true
+	default:
+		return nil, fmt.Errorf("unknown rows %q", rows)
+	}
+	switch args := params["args"].(type) {
+	case nil:
+	case []interface{}:
+		filter.args = args
+	default:
+		return nil, fmt.Errorf("args: expected list, got %T", args)
+	}
+	if filter.db, err = openDB(driver, dsn); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// sqlFilter implements the SQL filter.
+type sqlFilter struct {
+	ctx    context.Context
+	db     *sql.DB
+	query  string
+	args   []interface{}
+	column string
+	all    bool
+
+	rows   *sql.Rows
+	dest   []interface{}
+	index  int
+	value  []byte
+	done   bool
+	err    error
+	closed bool
+}
+
+// Source implements iofl.Filter.
+func (f *sqlFilter) Source() io.ReadCloser {
+	return nil
+}
+
+// SetContext implements iofl.ContextFilter. The query is run with ctx.
+func (f *sqlFilter) SetContext(ctx context.Context) {
+	f.ctx = ctx
+}
+
+// execute runs the query, and locates the column.
+func (f *sqlFilter) execute() error {
+	ctx := f.ctx
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	rows, err := f.db.QueryContext(ctx, f.query, f.args...)
+	if err != nil {
+		return err
+	}
+	f.rows = rows
+	columns, err := rows.Columns()
+	if err != nil {
+		return err
+	}
+	f.index = -1
+	if f.column == "" && len(columns) > 0 {
+		f.index = 0
+	}
+	for i, name := range columns {
+		if name == f.column {
+			f.index = i
+			break
+		}
+	}
+	if f.index < 0 {
+		return fmt.Errorf("unknown column %q", f.column)
+	}
+	f.dest = make([]interface{}, len(columns))
+	for i := range f.dest {
+		f.dest[i] = new(sql.RawBytes)
+	}
+	if !f.rows.Next() {
+		if err := f.rows.Err(); err != nil {
+			return err
+		}
+		return sql.ErrNoRows
+	}
+	return f.scan()
+}
+
+// scan reads the value of the column from the current row.
+func (f *sqlFilter) scan() error {
+	if err := f.rows.Scan(f.dest...); err != nil {
+		return err
+	}
+	f.value = *f.dest[f.index].(*sql.RawBytes)
+	return nil
+}
+
+// Read implements io.Reader.
+func (f *sqlFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if f.err != nil {
+		return 0, f.err
+	}
+	if f.rows == nil {
+		if f.err = f.execute(); f.err != nil {
+			return 0, f.err
+		}
+	}
+	for len(f.value) == 0 {
+		if f.done || !f.all || !f.rows.Next() {
+			f.done = true
+			if f.err = f.rows.Err(); f.err == nil {
+				f.err = io.EOF
+			}
+			return 0, f.err
+		}
+		if f.err = f.scan(); f.err != nil {
+			return 0, f.err
+		}
+	}
+	n = copy(p, f.value)
+	f.value = f.value[n:]
+	return n, nil
+}
+
+// Close implements io.Closer, closing the result of the query.
+func (f *sqlFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	if f.rows != nil {
+		return f.rows.Close()
+	}
+	return nil
+}
+
+// Reset implements iofl.Resetter. The filter does not accept a source, so src
+// must be nil.
+func (f *sqlFilter) Reset(src io.ReadCloser) error {
+	if src != nil {
+		return errHasSource
+	}
+	if f.rows != nil {
+		f.rows.Close()
+	}
+	f.rows = nil
+	f.dest = nil
+	f.value = nil
+	f.done = false
+	f.err = nil
+	f.closed = false
+	return nil
+}
@@ -0,0 +1,179 @@
+package filters_test
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// sqlResult is the result of a query made with the test driver.
+type sqlResult struct {
+	columns []string
+	rows    [][]driver.Value
+}
+
+// sqlResults maps a query to its result.
+var sqlResults = map[string]sqlResult{
+	"one": {[]string{"id", "data"}, [][]driver.Value{
+		{int64(1), []byte("first")},
+		{int64(2), []byte("second")},
+	}},
+	"chunks": {[]string{"data"}, [][]driver.Value{
+		{[]byte("ab")},
+		{nil},
+		{[]byte("cd")},
+		{[]byte("ef")},
+	}},
+	"none": {[]string{"data"}, nil},
+}
+
+func init() {
+	sql.Register("iofltest", sqlDriver{})
+}
+
+type sqlDriver struct{}
+
+func (sqlDriver) Open(name string) (driver.Conn, error) { return sqlConn{}, nil }
+
+type sqlConn struct{}
+
+func (sqlConn) Prepare(query string) (driver.Stmt, error) {
+	result, ok := sqlResults[query]
+	if !ok {
+		return nil, errors.New("unknown query")
+	}
+	return sqlStmt{result}, nil
+}
+func (sqlConn) Close() error              { return nil }
+func (sqlConn) Begin() (driver.Tx, error) { return nil, errors.New("unsupported") }
+
+type sqlStmt struct {
+	result sqlResult
+}
+
+func (sqlStmt) Close() error  { return nil }
+func (sqlStmt) NumInput() int { return -1 }
+func (sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return nil, errors.New("unsupported")
+}
+func (s sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return &sqlRows{result: s.result}, nil
+}
+
+type sqlRows struct {
+	result sqlResult
+	i      int
+}
+
+func (r *sqlRows) Columns() []string { return r.result.columns }
+func (r *sqlRows) Close() error      { return nil }
+func (r *sqlRows) Next(dest []driver.Value) error {
+	if r.i >= len(r.result.rows) {
+		return io.EOF
+	}
+	copy(dest, r.result.rows[r.i])
+	r.i++
+	return nil
+}
+
+func sqlParams(query string, extra iofl.Params) iofl.Params {
+	params := iofl.Params{"driver": "iofltest", "dsn": "test", "query": query}
+	for k, v := range extra {
+		params[k] = v
+	}
+	return params
+}
+
+func TestSQL(t *testing.T) {
+	tests := []struct {
+		params iofl.Params
+		want   string
+	}{
+		{sqlParams("one", nil), "1"},
+		{sqlParams("one", iofl.Params{"column": "data"}), "first"},
+		{sqlParams("one", iofl.Params{"column": "data", "rows": "all"}), "firstsecond"},
+		{sqlParams("chunks", iofl.Params{"rows": "all", "args": []interface{}{1}}), "abcdef"},
+	}
+	for _, tt := range tests {
+		out, err := readFilterFrom(t, filters.SQL, tt.params, nil)
+		if err != nil {
+			t.Errorf("%v: %v", tt.params, err)
+			continue
+		}
+		if string(out) != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.params, out, tt.want)
+		}
+	}
+}
+
+func TestSQLErrors(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"dsn": "test", "query": "one"},
+		{"driver": "iofltest", "query": "one"},
+		{"driver": "iofltest", "dsn": "test"},
+		sqlParams("one", iofl.Params{"rows": "some"}),
+		sqlParams("one", iofl.Params{"args": "x"}),
+		{"driver": "iofltest-missing", "dsn": "test", "query": "one"},
+	} {
+		if _, err := filters.SQL.New(params, nil); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.SQL.New(sqlParams("one", nil), io.NopCloser(nil)); err == nil {
+		t.Error("expected error with source")
+	}
+
+	for _, tt := range []struct {
+		params iofl.Params
+		err    error
+	}{
+		{sqlParams("none", nil), sql.ErrNoRows},
+		{sqlParams("one", iofl.Params{"column": "missing"}), nil},
+		{sqlParams("missing", nil), nil},
+	} {
+		_, err := readFilterFrom(t, filters.SQL, tt.params, nil)
+		if err == nil || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%v: got %v, want %v", tt.params, err, tt.err)
+		}
+	}
+}
+
+func TestSQLReset(t *testing.T) {
+	f, err := filters.SQL.New(sqlParams("one", iofl.Params{"column": "data"}), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		b := make([]byte, 3)
+		if n, err := f.Read(b); err != nil || string(b[:n]) != "fir" {
+			t.Errorf("%d: got %q, %v", i, b[:n], err)
+		}
+		f.Close()
+		if err := f.(iofl.Resetter).Reset(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.(iofl.Resetter).Reset(io.NopCloser(nil)); err == nil {
+		t.Error("expected error resetting with source")
+	}
+}
+
+func TestSQLContext(t *testing.T) {
+	f, err := filters.SQL.New(sqlParams("one", nil), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	f.(iofl.ContextFilter).SetContext(ctx)
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
@@ -0,0 +1,26 @@
+package filters
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// errBadState is returned when restoring a malformed state.
+var errBadState = errors.New("malformed state")
+
+// appendState appends b to state, prefixed with its length.
+func appendState(state []byte, b []byte) []byte {
+	var n [binary.MaxVarintLen64]byte
+	state = append(state, n[:binary.PutUvarint(n[:], uint64(len(b)))]...)
+	return append(state, b...)
+}
+
+// readState reads a length-prefixed value from state, returning the value and
+// the remainder of state.
+func readState(state []byte) (b, rest []byte, err error) {
+	n, w := binary.Uvarint(state)
+	if w <= 0 || uint64(len(state)-w) < n {
+		return nil, nil, errBadState
+	}
+	return state[w : w+int(n)], state[w+int(n):], nil
+}
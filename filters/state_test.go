@@ -0,0 +1,99 @@
+package filters_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// resume reads n bytes from the filter of def over in, saves its state, and
+// restores the state to a new filter reading the remainder of in. Returns the
+// output of both filters.
+func resume(t *testing.T, def iofl.FilterDef, params iofl.Params, in []byte, n int) []byte {
+	t.Helper()
+	src := bytes.NewReader(in)
+	f, err := def.New(params, ioutil.NopCloser(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, n)
+	if _, err := io.ReadFull(f, out); err != nil {
+		t.Fatalf("%s: read before save: %v", def.Name, err)
+	}
+	state, err := f.(iofl.StateSaver).SaveState()
+	if err != nil {
+		t.Fatalf("%s: save: %v", def.Name, err)
+	}
+	f.Close()
+
+	g, err := def.New(params, ioutil.NopCloser(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.(iofl.StateSaver).RestoreState(state); err != nil {
+		t.Fatalf("%s: restore: %v", def.Name, err)
+	}
+	rest, err := ioutil.ReadAll(g)
+	g.Close()
+	if err != nil {
+		t.Fatalf("%s: read after restore: %v", def.Name, err)
+	}
+	return append(out, rest...)
+}
+
+func TestStateResume(t *testing.T) {
+	text := strings.Repeat("caf\xc3\xa9 \xe2\x82\xac ", 300)
+	utf16 := []byte{0xFF, 0xFE}
+	for i := 0; i < 2000; i++ {
+		utf16 = append(utf16, byte('a'+i%26), 0, 0x3D, 0xD8, 0x00, 0xDE)
+	}
+	tests := []struct {
+		def    iofl.FilterDef
+		params iofl.Params
+		in     []byte
+	}{
+		{filters.Percent, iofl.Params{"bufferSize": 64}, []byte(text)},
+		{filters.Percent, iofl.Params{"mode": "decode", "bufferSize": 64}, mustRead(t, filters.Percent, nil, []byte(text))},
+		{filters.Translate, iofl.Params{"preset": "upper", "delete": " ", "bufferSize": 64}, []byte(text)},
+		{filters.Base64, iofl.Params{"bufferSize": 64}, []byte(text)},
+		{filters.Charset, iofl.Params{"mode": "convert", "bufferSize": 64}, utf16},
+		{filters.Charset, iofl.Params{"mode": "convert", "sniff": 64, "bufferSize": 64}, []byte("caf\xe9 " + text)},
+	}
+	for _, tt := range tests {
+		want := mustRead(t, tt.def, tt.params, tt.in)
+		for _, n := range []int{0, 1, 7, 63, 64, 100, len(want) / 2, len(want)} {
+			if got := resume(t, tt.def, tt.params, tt.in, n); !bytes.Equal(got, want) {
+				t.Errorf("%s %v: resumed at %d: output mismatch", tt.def.Name, tt.params, n)
+			}
+		}
+	}
+}
+
+func TestStateMalformed(t *testing.T) {
+	for _, def := range []iofl.FilterDef{filters.Percent, filters.Charset} {
+		f, err := def.New(nil, ioutil.NopCloser(strings.NewReader("")))
+		if err != nil {
+			t.Fatal(err)
+		}
+		ss := f.(iofl.StateSaver)
+		for _, state := range [][]byte{
+			nil,
+			{0xFF},
+			{10, 'a'},
+			{0, 0, 5},
+		} {
+			if err := ss.RestoreState(state); err == nil {
+				t.Errorf("%s: %q: expected error", def.Name, state)
+			}
+		}
+		f.Close()
+		if _, err := ss.SaveState(); err != iofl.Closed {
+			t.Errorf("%s: save after close: got %v, want Closed", def.Name, err)
+		}
+	}
+}
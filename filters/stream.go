@@ -0,0 +1,431 @@
+package filters
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// Stream is a source filter that produces a long-lived network stream,
+// reconnecting with exponential backoff when the connection fails, for
+// streaming ingestion from an unreliable upstream. Params:
+//
+//	url:        The address of the stream. "tcp://host:port" connects over
+//	            TCP. An "http" or "https" URL is requested with GET, as for a
+//	            long poll. Required.
+//	cursor:     The offset within the stream, in bytes, from which to start.
+//	            Defaults to 0.
+//	resume:     For TCP, a message sent after each connection is made, such as
+//	            "RESUME {cursor}\n". If empty, nothing is sent.
+//	eof:        "reconnect" (default) reconnects when the upstream ends the
+//	            stream. "end" ends the output instead.
+//	backoff:    The delay before the first reconnection attempt. Defaults to
+//	            1s.
+//	maxBackoff: The maximum delay between attempts. Defaults to 1m.
+//	attempts:   The number of consecutive failed attempts after which the
+//	            last error is returned. Defaults to 0, which never gives up.
+//
+// The cursor is advanced by each byte produced, so that a reconnection resumes
+// where the previous connection left off. Within the url and the resume
+// message, "{cursor}" is replaced by the current cursor. If an HTTP url does
+// not contain "{cursor}", the cursor is sent with a Range header, and if the
+// upstream ignores the header, the content before the cursor is discarded.
+//
+// The delay before each attempt doubles from backoff, up to maxBackoff. An
+// attempt fails if the connection cannot be made, or if it ends before
+// producing any content. A connection that produces content resets the delay.
+// An HTTP status other than 200 or 206 fails, and a client error, other than
+// 408 or 429, is returned without further attempts.
+//
+// Connections are made with the context of the chain, if any, as the filter
+// implements iofl.ContextFilter. Closing the filter interrupts a pending Read.
+// The filter reports the current cursor through the Cursorer interface, and
+// reports the cursor and the number of connections made through the
+// iofl.Reporter interface.
+//
+// Stream connects to any host named by its url, including internal hosts, so
+// it is registered by RegisterUnsafe rather than Register.
+var Stream = iofl.FilterDef{
+	Name:         "stream",
+	New:          newStream,
+	Validate:     validateStream,
+	Description:  "Produces a long-lived network stream, reconnecting with exponential backoff.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "url", Type: iofl.ParamString, Required: true, Description: "The address of the stream, a tcp, http, or https URL. Required."},
+		{Name: "cursor", Description: "The offset within the stream from which to start.", Default: "0"},
+		{Name: "resume", Type: iofl.ParamString, Description: "For TCP, a message sent after connecting, in which {cursor} is replaced."},
+		{Name: "eof", Type: iofl.ParamString, Description: "\"reconnect\" reconnects when the stream ends. \"end\" ends the output.", Default: "reconnect"},
+		{Name: "backoff", Type: iofl.ParamDuration, Description: "The delay before the first reconnection attempt.", Default: "1s"},
+		{Name: "maxBackoff", Type: iofl.ParamDuration, Description: "The maximum delay between attempts.", Default: "1m"},
+		{Name: "attempts", Type: iofl.ParamInt, Description: "The number of consecutive failed attempts after which an error is returned.", Default: "0"},
+	},
+}
+
+// Cursorer is implemented by filters that track their position within a
+// resumable source.
+type Cursorer interface {
+	// Cursor returns the position from which the source would be resumed.
+	Cursor() int64
+}
+
+// streamFilter implements the Stream filter.
+type streamFilter struct {
+	ctx        context.Context
+	url        *url.URL
+	resume     string
+	endOnEOF   bool
+	backoff    time.Duration
+	maxBackoff time.Duration
+	attempts   int
+
+	// cursor and connects are accessed atomically.
+	cursor   int64
+	connects int64
+	failures int
+	// produced is whether the current connection has produced content.
+	produced bool
+	err      error
+
+	mu     sync.Mutex
+	conn   io.ReadCloser
+	closed bool
+	// done is closed by Close, interrupting a pending backoff.
+	done chan struct{}
+}
+
+func parseStream(params iofl.Params) (*streamFilter, error) {
+	f := &streamFilter{
+		resume:     params.GetString("resume"),
+		backoff:    time.Second,
+		maxBackoff: time.Minute,
+		attempts:   params.GetInt("attempts"),
+	}
+	raw := params.GetString("url")
+	if raw == "" {
+		return nil, errors.New("url required")
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	switch u.Scheme {
+	case "tcp":
+		if u.Host == "" {
+			return nil, errors.New("url: host required")
+		}
+	case "http", "https":
+	default:
+		return nil, fmt.Errorf("url: unknown scheme %q", u.Scheme)
+	}
+	f.url = u
+	switch v := params["cursor"].(type) {
+	case nil:
+	case string:
+		if f.cursor, err = strconv.ParseInt(v, 10, 64); err != nil {
+			return nil, fmt.Errorf("cursor: %w", err)
+		}
+	default:
+		f.cursor = int64(params.GetInt("cursor"))
+	}
+	if f.cursor < 0 {
+		return nil, errors.New("cursor: must not be negative")
+	}
+	switch eof := params.GetString("eof"); eof {
+	case "", "reconnect":
+	case "end":
+		f.endOnEOF = true
+	default:
+		return nil, fmt.Errorf("unknown eof %q", eof)
+	}
+	if _, ok := params["backoff"]; ok {
+		f.backoff = params.GetDuration("backoff")
+	}
+	if _, ok := params["maxBackoff"]; ok {
+		f.maxBackoff = params.GetDuration("maxBackoff")
+	}
+	switch {
+	case f.backoff <= 0:
+		return nil, errors.New("backoff: must be positive")
+	case f.maxBackoff < f.backoff:
+		return nil, errors.New("maxBackoff: must not be less than backoff")
+	case f.attempts < 0:
+		return nil, errors.New("attempts: must not be negative")
+	}
+	return f, nil
+}
+
+func validateStream(params iofl.Params) error {
+	_, err := parseStream(params)
+	return err
+}
+
+func newStream(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r != nil {
+		return nil, errHasSource
+	}
+	filter, err := parseStream(params)
+	if err != nil {
+		return nil, err
+	}
+	filter.done = make(chan struct{})
+	return filter, nil
+}
+
+// permanentError is an error after which no further attempts are made.
+type permanentError struct {
+	err error
+}
+
+func (e permanentError) Error() string { return e.err.Error() }
+func (e permanentError) Unwrap() error { return e.err }
+
+// context returns the context of the filter.
+func (f *streamFilter) context() context.Context {
+	if f.ctx == nil {
+		return context.Background()
+	}
+	return f.ctx
+}
+
+// expand replaces "{cursor}" in s with the current cursor.
+func (f *streamFilter) expand(s string) string {
+	return strings.ReplaceAll(s, "{cursor}", strconv.FormatInt(atomic.LoadInt64(&f.cursor), 10))
+}
+
+// dial makes a connection.
+func (f *streamFilter) dial() (io.ReadCloser, error) {
+	ctx := f.context()
+	if f.url.Scheme == "tcp" {
+		var d net.Dialer
+		conn, err := d.DialContext(ctx, "tcp", f.url.Host)
+		if err != nil {
+			return nil, err
+		}
+		if f.resume != "" {
+			if _, err := io.WriteString(conn, f.expand(f.resume)); err != nil {
+				conn.Close()
+				return nil, err
+			}
+		}
+		return conn, nil
+	}
+	raw := f.url.String()
+	// The placeholder is escaped by URL.String.
+	raw = strings.ReplaceAll(raw, "%7Bcursor%7D", "{cursor}")
+	ranged := !strings.Contains(raw, "{cursor}")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, f.expand(raw), nil)
+	if err != nil {
+		return nil, permanentError{err}
+	}
+	cursor := atomic.LoadInt64(&f.cursor)
+	if ranged && cursor > 0 {
+		req.Header.Set("Range", "bytes="+strconv.FormatInt(cursor, 10)+"-")
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case resp.StatusCode == http.StatusOK:
+		if ranged && cursor > 0 {
+			// The range was ignored, so skip to the cursor.
+			if _, err := io.CopyN(ioutil.Discard, resp.Body, cursor); err != nil {
+				resp.Body.Close()
+				return nil, err
+			}
+		}
+		return resp.Body, nil
+	case resp.StatusCode == http.StatusPartialContent:
+		return resp.Body, nil
+	}
+	resp.Body.Close()
+	err = fmt.Errorf("%s: %s", req.URL.Redacted(), resp.Status)
+	if resp.StatusCode >= 400 && resp.StatusCode < 500 &&
+		resp.StatusCode != http.StatusRequestTimeout &&
+		resp.StatusCode != http.StatusTooManyRequests {
+		return nil, permanentError{err}
+	}
+	return nil, err
+}
+
+// wait waits for the backoff of the current number of failures.
+func (f *streamFilter) wait() error {
+	d := f.backoff
+	for i := 1; i < f.failures && d < f.maxBackoff; i++ {
+		d *= 2
+	}
+	if d > f.maxBackoff {
+		d = f.maxBackoff
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-f.done:
+		return iofl.Closed
+	case <-f.context().Done():
+		return f.context().Err()
+	}
+}
+
+// fail records a failed attempt that produced err. Returns an error if no
+// further attempts are to be made.
+func (f *streamFilter) fail(err error) error {
+	var perm permanentError
+	if errors.As(err, &perm) {
+		return perm.err
+	}
+	if ctx := f.context(); ctx.Err() != nil {
+		return ctx.Err()
+	}
+	f.failures++
+	if f.attempts > 0 && f.failures >= f.attempts {
+		return fmt.Errorf("after %d attempts: %w", f.failures, err)
+	}
+	return nil
+}
+
+// connect makes a connection, retrying failed attempts.
+func (f *streamFilter) connect() (io.ReadCloser, error) {
+	for {
+		if f.failures > 0 {
+			if err := f.wait(); err != nil {
+				return nil, err
+			}
+		}
+		conn, err := f.dial()
+		if err != nil {
+			if err = f.fail(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		atomic.AddInt64(&f.connects, 1)
+		f.mu.Lock()
+		defer f.mu.Unlock()
+		if f.closed {
+			conn.Close()
+			return nil, iofl.Closed
+		}
+		f.conn = conn
+		f.produced = false
+		return conn, nil
+	}
+}
+
+// drop closes and forgets conn.
+func (f *streamFilter) drop(conn io.ReadCloser) {
+	f.mu.Lock()
+	if f.conn == conn {
+		f.conn = nil
+	}
+	f.mu.Unlock()
+	conn.Close()
+}
+
+// isClosed returns whether the filter is closed.
+func (f *streamFilter) isClosed() bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.closed
+}
+
+// Source implements iofl.Filter.
+func (f *streamFilter) Source() io.ReadCloser {
+	return nil
+}
+
+// SetContext implements iofl.ContextFilter. Connections are made with ctx.
+func (f *streamFilter) SetContext(ctx context.Context) {
+	f.ctx = ctx
+}
+
+// Cursor implements Cursorer, returning the offset within the stream of the
+// next byte to be produced.
+func (f *streamFilter) Cursor() int64 {
+	return atomic.LoadInt64(&f.cursor)
+}
+
+// Report implements iofl.Reporter.
+func (f *streamFilter) Report() map[string]interface{} {
+	return map[string]interface{}{
+		"cursor":   atomic.LoadInt64(&f.cursor),
+		"connects": atomic.LoadInt64(&f.connects),
+	}
+}
+
+// Read implements io.Reader.
+func (f *streamFilter) Read(p []byte) (n int, err error) {
+	for {
+		if f.isClosed() {
+			return 0, iofl.Closed
+		}
+		if f.err != nil {
+			return 0, f.err
+		}
+		f.mu.Lock()
+		conn := f.conn
+		f.mu.Unlock()
+		if conn == nil {
+			if conn, f.err = f.connect(); f.err != nil {
+				return 0, f.err
+			}
+		}
+		n, err = conn.Read(p)
+		if n > 0 {
+			atomic.AddInt64(&f.cursor, int64(n))
+			f.failures = 0
+			f.produced = true
+		}
+		if err == nil {
+			return n, nil
+		}
+		f.drop(conn)
+		if f.isClosed() {
+			return n, iofl.Closed
+		}
+		if err == io.EOF && f.endOnEOF {
+			f.err = io.EOF
+			return n, io.EOF
+		}
+		if !f.produced {
+			// The connection ended before producing content.
+			if f.err = f.fail(err); f.err != nil {
+				return 0, f.err
+			}
+		}
+		if n > 0 {
+			return n, nil
+		}
+	}
+}
+
+// Close implements io.Closer, closing the current connection, if any.
+func (f *streamFilter) Close() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	close(f.done)
+	if f.conn != nil {
+		f.conn.Close()
+		f.conn = nil
+	}
+	return nil
+}
@@ -0,0 +1,370 @@
+package filters_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+const streamContent = "the quick brown fox jumps over the lazy dog"
+
+// tcpStream starts a TCP server that, for each connection, reads a resume
+// message of the form "RESUME <cursor>\n", then writes at most chunk bytes of
+// streamContent from the cursor before closing the connection. Returns the
+// address of the server, and a function returning the received cursors.
+func tcpStream(t *testing.T, chunk int) (addr string, cursors func() []int) {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	var mu sync.Mutex
+	var received []int
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				line, err := bufio.NewReader(conn).ReadString('\n')
+				if err != nil {
+					return
+				}
+				cursor, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "RESUME ")))
+				if err != nil || cursor > len(streamContent) {
+					return
+				}
+				mu.Lock()
+				received = append(received, cursor)
+				mu.Unlock()
+				end := cursor + chunk
+				if end > len(streamContent) {
+					end = len(streamContent)
+				}
+				io.WriteString(conn, streamContent[cursor:end])
+			}()
+		}
+	}()
+	return "tcp://" + ln.Addr().String(), func() []int {
+		mu.Lock()
+		defer mu.Unlock()
+		return append([]int(nil), received...)
+	}
+}
+
+// httpStream starts an HTTP server that writes at most chunk bytes of
+// streamContent per request, from the offset given by a Range header, or by a
+// "cursor" query, if any. If ranges is false, the Range header is ignored, and
+// a request without a query receives all of streamContent.
+// Returns the server and the number of requests made.
+func httpStream(t *testing.T, chunk int, ranges bool) (*httptest.Server, *int32) {
+	t.Helper()
+	var requests int32
+	s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		cursor, status, n := 0, http.StatusOK, chunk
+		if q := r.URL.Query().Get("cursor"); q != "" {
+			cursor, _ = strconv.Atoi(q)
+		} else if h := r.Header.Get("Range"); ranges && h != "" {
+			cursor, _ = strconv.Atoi(strings.TrimSuffix(strings.TrimPrefix(h, "bytes="), "-"))
+			status = http.StatusPartialContent
+		} else if !ranges {
+			n = len(streamContent)
+		}
+		end := cursor + n
+		if end > len(streamContent) {
+			end = len(streamContent)
+		}
+		w.WriteHeader(status)
+		io.WriteString(w, streamContent[cursor:end])
+	}))
+	t.Cleanup(s.Close)
+	return s, &requests
+}
+
+// refusedAddr returns the address of a TCP port on which nothing listens.
+func refusedAddr(t *testing.T) string {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+	return "tcp://" + addr
+}
+
+// readStream reads n bytes from a stream filter with params, returning the
+// content and the filter, which is closed when the test ends.
+func readStream(t *testing.T, params iofl.Params, n int) ([]byte, iofl.Filter) {
+	t.Helper()
+	f, err := filters.Stream.New(params, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { f.Close() })
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(f, buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf, f
+}
+
+func TestStreamTCP(t *testing.T) {
+	addr, cursors := tcpStream(t, 10)
+	out, f := readStream(t, iofl.Params{
+		"url":     addr,
+		"resume":  "RESUME {cursor}\n",
+		"cursor":  4,
+		"backoff": "1ms",
+	}, len(streamContent)-4)
+	if string(out) != streamContent[4:] {
+		t.Errorf("got %q, want %q", out, streamContent[4:])
+	}
+	if got, want := fmt.Sprint(cursors()), "[4 14 24 34]"; got != want {
+		t.Errorf("resumed from %s, want %s", got, want)
+	}
+	if c := f.(filters.Cursorer).Cursor(); c != int64(len(streamContent)) {
+		t.Errorf("cursor %d, want %d", c, len(streamContent))
+	}
+	report := f.(iofl.Reporter).Report()
+	if report["connects"] != int64(4) || report["cursor"] != int64(len(streamContent)) {
+		t.Errorf("unexpected report %v", report)
+	}
+}
+
+func TestStreamHTTP(t *testing.T) {
+	for _, test := range []struct {
+		name     string
+		query    string
+		ranges   bool
+		requests int32
+	}{
+		{"Range", "", true, 5},
+		{"IgnoredRange", "", false, 1},
+		{"Query", "?cursor={cursor}", false, 5},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			s, requests := httpStream(t, 8, test.ranges)
+			out, _ := readStream(t, iofl.Params{
+				"url":     s.URL + "/" + test.query,
+				"cursor":  "3",
+				"backoff": "1ms",
+			}, len(streamContent)-3)
+			if string(out) != streamContent[3:] {
+				t.Errorf("got %q, want %q", out, streamContent[3:])
+			}
+			if n := atomic.LoadInt32(requests); n != test.requests {
+				t.Errorf("made %d requests, want %d", n, test.requests)
+			}
+		})
+	}
+}
+
+func TestStreamEOF(t *testing.T) {
+	s, requests := httpStream(t, len(streamContent), true)
+	out, err := readFilterFrom(t, filters.Stream, iofl.Params{"url": s.URL, "eof": "end"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out) != streamContent {
+		t.Errorf("got %q, want %q", out, streamContent)
+	}
+	if n := atomic.LoadInt32(requests); n != 1 {
+		t.Errorf("made %d requests, want 1", n)
+	}
+}
+
+func TestStreamBackoff(t *testing.T) {
+	start := time.Now()
+	_, err := readFilterFrom(t, filters.Stream, iofl.Params{
+		"url":        refusedAddr(t),
+		"backoff":    "20ms",
+		"maxBackoff": "40ms",
+		"attempts":   4,
+	}, nil)
+	if err == nil || !strings.Contains(err.Error(), "after 4 attempts") {
+		t.Fatalf("got error %v, want after 4 attempts", err)
+	}
+	// Waits 20ms, then 40ms, then 40ms, limited by maxBackoff.
+	if d := time.Since(start); d < 100*time.Millisecond || d > 2*time.Second {
+		t.Errorf("took %v, want at least 100ms", d)
+	}
+}
+
+func TestStreamStatus(t *testing.T) {
+	for _, test := range []struct {
+		status   int
+		requests int32
+		err      bool
+	}{
+		{http.StatusNotFound, 1, true},
+		{http.StatusForbidden, 1, true},
+		{http.StatusTooManyRequests, 3, false},
+		{http.StatusServiceUnavailable, 3, false},
+	} {
+		t.Run(strconv.Itoa(test.status), func(t *testing.T) {
+			var requests int32
+			s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if atomic.AddInt32(&requests, 1) < 3 {
+					w.WriteHeader(test.status)
+					return
+				}
+				io.WriteString(w, streamContent)
+			}))
+			defer s.Close()
+			out, err := readFilterFrom(t, filters.Stream, iofl.Params{"url": s.URL, "eof": "end", "backoff": "1ms"}, nil)
+			if test.err {
+				if err == nil || !strings.Contains(err.Error(), strconv.Itoa(test.status)) {
+					t.Errorf("got error %v, want status %d", err, test.status)
+				}
+			} else if err != nil || string(out) != streamContent {
+				t.Errorf("got %q, %v", out, err)
+			}
+			if n := atomic.LoadInt32(&requests); n != test.requests {
+				t.Errorf("made %d requests, want %d", n, test.requests)
+			}
+		})
+	}
+}
+
+func TestStreamEmptyConnection(t *testing.T) {
+	// Connections that end without content are failed attempts.
+	s, requests := httpStream(t, 0, true)
+	_, err := readFilterFrom(t, filters.Stream, iofl.Params{"url": s.URL, "backoff": "1ms", "attempts": 3}, nil)
+	if err == nil || !strings.Contains(err.Error(), "after 3 attempts") {
+		t.Errorf("got error %v, want after 3 attempts", err)
+	}
+	if n := atomic.LoadInt32(requests); n != 3 {
+		t.Errorf("made %d requests, want 3", n)
+	}
+}
+
+func TestStreamClose(t *testing.T) {
+	t.Run("Backoff", func(t *testing.T) {
+		f, err := filters.Stream.New(iofl.Params{"url": refusedAddr(t), "backoff": "1h", "maxBackoff": "1h"}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			_, err := f.Read(make([]byte, 8))
+			done <- err
+		}()
+		time.Sleep(20 * time.Millisecond)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			if !errors.Is(err, iofl.Closed) {
+				t.Errorf("got error %v, want Closed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not interrupt backoff")
+		}
+	})
+	t.Run("Read", func(t *testing.T) {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer ln.Close()
+		accepted := make(chan net.Conn, 1)
+		go func() {
+			conn, err := ln.Accept()
+			if err == nil {
+				accepted <- conn
+			}
+		}()
+		f, err := filters.Stream.New(iofl.Params{"url": "tcp://" + ln.Addr().String()}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		done := make(chan error)
+		go func() {
+			_, err := f.Read(make([]byte, 8))
+			done <- err
+		}()
+		conn := <-accepted
+		defer conn.Close()
+		time.Sleep(20 * time.Millisecond)
+		if err := f.Close(); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case err := <-done:
+			if !errors.Is(err, iofl.Closed) {
+				t.Errorf("got error %v, want Closed", err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close did not interrupt pending read")
+		}
+		if err := f.Close(); !errors.Is(err, iofl.Closed) {
+			t.Errorf("second close: got %v, want Closed", err)
+		}
+	})
+}
+
+func TestStreamContext(t *testing.T) {
+	f, err := filters.Stream.New(iofl.Params{"url": refusedAddr(t), "backoff": "1h", "maxBackoff": "1h"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	ctx, cancel := context.WithCancel(context.Background())
+	f.(iofl.ContextFilter).SetContext(ctx)
+	time.AfterFunc(20*time.Millisecond, cancel)
+	if _, err := f.Read(make([]byte, 8)); !errors.Is(err, context.Canceled) {
+		t.Errorf("got error %v, want context.Canceled", err)
+	}
+}
+
+func TestStreamParams(t *testing.T) {
+	for _, test := range []struct {
+		params iofl.Params
+		err    string
+	}{
+		{iofl.Params{}, "url required"},
+		{iofl.Params{"url": "ftp://host"}, "unknown scheme"},
+		{iofl.Params{"url": "tcp://"}, "host required"},
+		{iofl.Params{"url": "tcp://host:1", "cursor": "x"}, "cursor"},
+		{iofl.Params{"url": "tcp://host:1", "cursor": -1}, "must not be negative"},
+		{iofl.Params{"url": "tcp://host:1", "eof": "stop"}, "unknown eof"},
+		{iofl.Params{"url": "tcp://host:1", "backoff": "0s"}, "backoff: must be positive"},
+		{iofl.Params{"url": "tcp://host:1", "backoff": "2s", "maxBackoff": "1s"}, "maxBackoff"},
+		{iofl.Params{"url": "tcp://host:1", "attempts": -1}, "attempts"},
+		{iofl.Params{"url": "http://host/{cursor}", "cursor": "10", "eof": "end", "backoff": "1ms", "maxBackoff": "1s", "attempts": 2}, ""},
+	} {
+		err := filters.Stream.Validate(test.params)
+		if test.err == "" {
+			if err != nil {
+				t.Errorf("%v: unexpected error %v", test.params, err)
+			}
+		} else if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%v: got error %v, want %q", test.params, err, test.err)
+		}
+	}
+	if _, err := filters.Stream.New(iofl.Params{"url": "tcp://host:1"}, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+		t.Error("expected error for filter with source")
+	}
+}
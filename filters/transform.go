@@ -0,0 +1,384 @@
+package filters
+
+import (
+	"errors"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+var (
+	// errShortDst is returned by a transformer when dst is too short to
+	// receive all of the transformed bytes.
+	errShortDst = errors.New("short destination buffer")
+	// errShortSrc is returned by a transformer when src has insufficient data
+	// to complete the transformation.
+	errShortSrc = errors.New("short source buffer")
+	// errInconsistent is returned when a transformer reports success without
+	// consuming all of src.
+	errInconsistent = errors.New("inconsistent byte count")
+)
+
+// transformer transforms bytes from src into dst. atEOF indicates that src
+// contains the final bytes of the stream.
+//
+// A transformer returns the number of bytes written to dst and consumed from
+// src. If it returns a nil error, all of src must have been consumed. If dst
+// is too short, errShortDst is returned. If src does not contain enough bytes
+// to make progress, errShortSrc is returned.
+type transformer interface {
+	Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error)
+}
+
+// resetter is implemented by a transformer that retains state between calls
+// to Transform. reset returns the transformer to its initial state.
+type resetter interface {
+	reset()
+}
+
+// defaultBufferSize is the size of buffers used by filters when a size is not
+// configured.
+const defaultBufferSize = 4096
+
+// minBufferSize is the smallest size of buffers used by filters.
+const minBufferSize = 16
+
+// bufferSize returns the buffer size configured by params, or
+// defaultBufferSize if no size is configured.
+func bufferSize(params iofl.Params) int {
+	size := params.GetInt(iofl.ParamBufferSize)
+	if size <= 0 {
+		return defaultBufferSize
+	}
+	if size < minBufferSize {
+		return minBufferSize
+	}
+	return size
+}
+
+// transformFilter is a Filter that applies a transformer to the bytes read from
+// its source.
+type transformFilter struct {
+	src    io.ReadCloser
+	t      transformer
+	size   int
+	budget *iofl.MemoryBudget
+	closed bool
+
+	// err is the error returned by src, or the final error once complete is
+	// true.
+	err      error
+	complete bool
+
+	srcBuf     []byte
+	src0, src1 int
+	dstBuf     []byte
+	dst0, dst1 int
+}
+
+// newTransformFilter returns a transformFilter that reads from src, with
+// buffers of the given size. The buffers are allocated by the first Read, once
+// the memory budget of the filter has been set.
+func newTransformFilter(src io.ReadCloser, t transformer, size int) *transformFilter {
+	if size < minBufferSize {
+		size = minBufferSize
+	}
+	return &transformFilter{
+		src:  src,
+		t:    t,
+		size: size,
+	}
+}
+
+// alloc allocates the buffers of the filter, if they have not been allocated,
+// reserving them from the budget.
+func (f *transformFilter) alloc() error {
+	if f.srcBuf != nil {
+		return nil
+	}
+	if err := f.budget.Alloc(2 * f.size); err != nil {
+		return err
+	}
+	f.srcBuf = make([]byte, f.size)
+	f.dstBuf = make([]byte, f.size)
+	return nil
+}
+
+// release discards the buffers of the filter, returning them to the budget.
+func (f *transformFilter) release() {
+	if f.srcBuf != nil {
+		f.budget.Free(2 * f.size)
+	}
+	f.srcBuf = nil
+	f.dstBuf = nil
+}
+
+// SetMemoryBudget implements iofl.BudgetUser.
+func (f *transformFilter) SetMemoryBudget(b *iofl.MemoryBudget) {
+	f.budget = b
+}
+
+// Source implements iofl.Filter.
+func (f *transformFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *transformFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if err := f.alloc(); err != nil {
+		return 0, err
+	}
+	for {
+		// Copy out transformed bytes, and return the final error once done.
+		if f.dst0 != f.dst1 {
+			n = copy(p, f.dstBuf[f.dst0:f.dst1])
+			f.dst0 += n
+			if f.dst0 == f.dst1 && f.complete {
+				return n, f.err
+			}
+			return n, nil
+		} else if f.complete {
+			return 0, f.err
+		}
+
+		// Transform buffered bytes, or flush the transformer once the source
+		// has no more bytes.
+		if f.src0 != f.src1 || f.err != nil {
+			f.dst0 = 0
+			f.dst1, n, err = f.t.Transform(f.dstBuf, f.srcBuf[f.src0:f.src1], f.err == io.EOF)
+			f.src0 += n
+			switch {
+			case err == nil:
+				if f.src0 != f.src1 {
+					f.err = errInconsistent
+				}
+				f.complete = f.err != nil
+				continue
+			case err == errShortDst && (f.dst1 != 0 || n != 0):
+				continue
+			case err == errShortSrc && f.src1-f.src0 != len(f.srcBuf) && f.err == nil:
+				// Read more below.
+			default:
+				f.complete = true
+				// Errors from the source take precedence.
+				if f.err == nil || f.err == io.EOF {
+					f.err = err
+				}
+				continue
+			}
+		}
+
+		// Move remaining bytes to the start of the buffer and read more.
+		if f.src0 != 0 {
+			f.src0, f.src1 = 0, copy(f.srcBuf, f.srcBuf[f.src0:f.src1])
+		}
+		n, f.err = f.src.Read(f.srcBuf[f.src1:])
+		f.src1 += n
+	}
+}
+
+// Close implements io.Closer, closing the source.
+func (f *transformFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	f.release()
+	return f.src.Close()
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *transformFilter) MemoryUsage() int {
+	return cap(f.srcBuf) + cap(f.dstBuf)
+}
+
+// PreservesSize implements iofl.SizePreserver, reporting whether the
+// transformer preserves size.
+func (f *transformFilter) PreservesSize() bool {
+	if sp, ok := f.t.(iofl.SizePreserver); ok {
+		return sp.PreservesSize()
+	}
+	return false
+}
+
+// SaveState implements iofl.StateSaver. The state includes bytes that have been
+// read from the source but not yet returned, and the state of the transformer,
+// if it implements iofl.StateSaver.
+func (f *transformFilter) SaveState() (state []byte, err error) {
+	if f.closed {
+		return nil, iofl.Closed
+	}
+	if f.err != nil && f.err != io.EOF {
+		return nil, f.err
+	}
+	var tstate []byte
+	if ss, ok := f.t.(iofl.StateSaver); ok {
+		if tstate, err = ss.SaveState(); err != nil {
+			return nil, err
+		}
+	}
+	state = appendState(state, f.srcBuf[f.src0:f.src1])
+	state = appendState(state, f.dstBuf[f.dst0:f.dst1])
+	state = appendState(state, tstate)
+	return state, nil
+}
+
+// RestoreState implements iofl.StateSaver.
+func (f *transformFilter) RestoreState(state []byte) error {
+	if f.closed {
+		return iofl.Closed
+	}
+	src, state, err := readState(state)
+	if err != nil {
+		return err
+	}
+	dst, state, err := readState(state)
+	if err != nil {
+		return err
+	}
+	tstate, _, err := readState(state)
+	if err != nil {
+		return err
+	}
+	if err := f.alloc(); err != nil {
+		return err
+	}
+	if len(src) > len(f.srcBuf) || len(dst) > len(f.dstBuf) {
+		return errBadState
+	}
+	if ss, ok := f.t.(iofl.StateSaver); ok {
+		if err := ss.RestoreState(tstate); err != nil {
+			return err
+		}
+	}
+	f.src0, f.src1 = 0, copy(f.srcBuf, src)
+	f.dst0, f.dst1 = 0, copy(f.dstBuf, dst)
+	f.err = nil
+	f.complete = false
+	return nil
+}
+
+// Reset implements iofl.Resetter.
+func (f *transformFilter) Reset(src io.ReadCloser) error {
+	if r, ok := f.t.(resetter); ok {
+		r.reset()
+	}
+	f.src = src
+	f.closed = false
+	f.err = nil
+	f.complete = false
+	f.src0, f.src1 = 0, 0
+	f.dst0, f.dst1 = 0, 0
+	return nil
+}
+
+// transformWriter is a WriteFilter that applies a transformer to the bytes
+// written to it, writing the result to its sink.
+type transformWriter struct {
+	dst    io.WriteCloser
+	t      transformer
+	closed bool
+	err    error
+
+	// srcBuf[:n] contains bytes that have been written but not yet
+	// transformed.
+	srcBuf []byte
+	n      int
+	dstBuf []byte
+}
+
+// newTransformWriter returns a transformWriter that writes to dst, with
+// buffers of the given size.
+func newTransformWriter(dst io.WriteCloser, t transformer, size int) *transformWriter {
+	if size < minBufferSize {
+		size = minBufferSize
+	}
+	return &transformWriter{
+		dst:    dst,
+		t:      t,
+		srcBuf: make([]byte, size),
+		dstBuf: make([]byte, size),
+	}
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *transformWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// Write implements io.Writer.
+func (w *transformWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+	for len(p) > 0 {
+		m := copy(w.srcBuf[w.n:], p)
+		w.n += m
+		p = p[m:]
+		n += m
+		if w.err = w.transform(false); w.err != nil {
+			return n, w.err
+		}
+	}
+	return n, nil
+}
+
+// transform transforms pending bytes, and writes the result to the sink. Bytes
+// that cannot yet be transformed remain pending.
+func (w *transformWriter) transform(atEOF bool) error {
+	src0 := 0
+	for {
+		nDst, nSrc, err := w.t.Transform(w.dstBuf, w.srcBuf[src0:w.n], atEOF)
+		src0 += nSrc
+		if nDst > 0 {
+			if _, err := w.dst.Write(w.dstBuf[:nDst]); err != nil {
+				return err
+			}
+		}
+		switch {
+		case err == nil:
+			if src0 != w.n {
+				return errInconsistent
+			}
+			w.n = 0
+			return nil
+		case err == errShortDst && (nDst != 0 || nSrc != 0):
+			continue
+		case err == errShortSrc && !atEOF && w.n-src0 != len(w.srcBuf):
+			w.n = copy(w.srcBuf, w.srcBuf[src0:w.n])
+			return nil
+		default:
+			return err
+		}
+	}
+}
+
+// Close implements io.Closer, transforming any pending bytes, and closing the
+// sink.
+func (w *transformWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	err := w.err
+	if err == nil {
+		err = w.transform(true)
+	}
+	w.srcBuf = nil
+	w.dstBuf = nil
+	if cerr := w.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (w *transformWriter) MemoryUsage() int {
+	return cap(w.srcBuf) + cap(w.dstBuf)
+}
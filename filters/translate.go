@@ -0,0 +1,210 @@
+package filters
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/anaminus/iofl"
+)
+
+// Translate maps or deletes individual bytes according to a 256-entry table,
+// similar to the tr utility. Params:
+//
+//	preset: Initializes the table with a predefined mapping. One of:
+//	        "upper":    Maps ASCII letters to uppercase.
+//	        "lower":    Maps ASCII letters to lowercase.
+//	        "rot13":    Rotates ASCII letters by 13 places.
+//	        "controls": Deletes ASCII control characters other than tab, line
+//	                    feed, and carriage return.
+//	table:  A list of 256 numbers, where the value at index i is the byte that
+//	        i is mapped to. A negative value deletes the byte.
+//	from:   A set of bytes to be mapped to the corresponding byte in the "to"
+//	        set. If "to" is shorter, its last byte is repeated.
+//	to:     The set of bytes mapped to from "from".
+//	delete: A set of bytes to be deleted.
+//
+// Params are applied in the order listed. A set is a string of bytes, which
+// may contain ranges ("a-z") and escapes ("\n", "\t", "\r", "\\", "\-",
+// "\xHH"). The filter honors the bufferSize param.
+var Translate = iofl.FilterDef{
+	Name:         "translate",
+	New:          newTranslate,
+	Description:  "Maps or deletes individual bytes according to a table.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "preset", Type: iofl.ParamString, Description: "Initializes the table: \"upper\", \"lower\", \"rot13\", or \"controls\"."},
+		{Name: "table", Type: iofl.ParamList, Description: "A list of 256 numbers mapping each byte. A negative value deletes the byte."},
+		{Name: "from", Type: iofl.ParamString, Description: "A set of bytes to be mapped to the corresponding byte in \"to\"."},
+		{Name: "to", Type: iofl.ParamString, Description: "The set of bytes mapped to from \"from\"."},
+		{Name: "delete", Type: iofl.ParamString, Description: "A set of bytes to be deleted."},
+	},
+}
+
+// translator is a translation table, mapping a byte to another byte, or -1 to
+// delete.
+type translator [256]int16
+
+func newTranslate(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	t := &translator{}
+	for i := range t {
+		t[i] = int16(i)
+	}
+	switch preset := params.GetString("preset"); preset {
+	case "":
+	case "upper":
+		for c := 'a'; c <= 'z'; c++ {
+			t[c] = int16(c - 'a' + 'A')
+		}
+	case "lower":
+		for c := 'A'; c <= 'Z'; c++ {
+			t[c] = int16(c - 'A' + 'a')
+		}
+	case "rot13":
+		for c := 0; c < 26; c++ {
+			t['a'+c] = int16('a' + (c+13)%26)
+			t['A'+c] = int16('A' + (c+13)%26)
+		}
+	case "controls":
+		for c := 0; c < 0x20; c++ {
+			if c != '\t' && c != '\n' && c != '\r' {
+				t[c] = -1
+			}
+		}
+		t[0x7F] = -1
+	default:
+		return nil, fmt.Errorf("unknown preset %q", preset)
+	}
+
+	if v, ok := params["table"]; ok {
+		table, ok := v.([]interface{})
+		if !ok || len(table) != len(t) {
+			return nil, fmt.Errorf("table must be a list of %d numbers", len(t))
+		}
+		for i, v := range table {
+			if err := iofl.ParamInt.Check(v); err != nil {
+				return nil, fmt.Errorf("table[%d]: %w", i, err)
+			}
+			n := iofl.Params{"n": v}.GetInt("n")
+			if n > 255 {
+				return nil, fmt.Errorf("table[%d]: invalid value %v", i, v)
+			}
+			if n < 0 {
+				n = -1
+			}
+			t[i] = int16(n)
+		}
+	}
+
+	from, err := parseByteSet(params.GetString("from"))
+	if err != nil {
+		return nil, fmt.Errorf("from: %w", err)
+	}
+	to, err := parseByteSet(params.GetString("to"))
+	if err != nil {
+		return nil, fmt.Errorf("to: %w", err)
+	}
+	if len(from) > 0 {
+		if len(to) == 0 {
+			return nil, fmt.Errorf("from requires non-empty to")
+		}
+		for i, c := range from {
+			if i < len(to) {
+				t[c] = int16(to[i])
+			} else {
+				t[c] = int16(to[len(to)-1])
+			}
+		}
+	}
+
+	del, err := parseByteSet(params.GetString("delete"))
+	if err != nil {
+		return nil, fmt.Errorf("delete: %w", err)
+	}
+	for _, c := range del {
+		t[c] = -1
+	}
+
+	return newTransformFilter(r, t, bufferSize(params)), nil
+}
+
+// parseByteSet parses a tr-style set of bytes.
+func parseByteSet(s string) (set []byte, err error) {
+	// next returns the byte at i, and the index after it.
+	next := func(i int) (c byte, j int, err error) {
+		if s[i] != '\\' {
+			return s[i], i + 1, nil
+		}
+		if i+1 >= len(s) {
+			return 0, i, fmt.Errorf("trailing backslash")
+		}
+		switch s[i+1] {
+		case 'n':
+			return '\n', i + 2, nil
+		case 't':
+			return '\t', i + 2, nil
+		case 'r':
+			return '\r', i + 2, nil
+		case 'x':
+			if i+4 > len(s) {
+				return 0, i, fmt.Errorf("invalid escape %q", s[i:])
+			}
+			n, err := strconv.ParseUint(s[i+2:i+4], 16, 8)
+			if err != nil {
+				return 0, i, fmt.Errorf("invalid escape %q", s[i:i+4])
+			}
+			return byte(n), i + 4, nil
+		default:
+			return s[i+1], i + 2, nil
+		}
+	}
+	for i := 0; i < len(s); {
+		var lo byte
+		if lo, i, err = next(i); err != nil {
+			return nil, err
+		}
+		if i+1 < len(s) && s[i] == '-' {
+			var hi byte
+			if hi, i, err = next(i + 1); err != nil {
+				return nil, err
+			}
+			if hi < lo {
+				return nil, fmt.Errorf("invalid range %q-%q", lo, hi)
+			}
+			for c := int(lo); c <= int(hi); c++ {
+				set = append(set, byte(c))
+			}
+			continue
+		}
+		set = append(set, lo)
+	}
+	return set, nil
+}
+
+func (t *translator) Transform(dst, src []byte, atEOF bool) (nDst, nSrc int, err error) {
+	for _, c := range src {
+		if m := t[c]; m >= 0 {
+			if nDst >= len(dst) {
+				return nDst, nSrc, errShortDst
+			}
+			dst[nDst] = byte(m)
+			nDst++
+		}
+		nSrc++
+	}
+	return nDst, nSrc, nil
+}
+
+// PreservesSize implements iofl.SizePreserver, returning true if no bytes are
+// deleted.
+func (t *translator) PreservesSize() bool {
+	for _, m := range t {
+		if m < 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,96 @@
+package filters_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestTranslate(t *testing.T) {
+	tests := []struct {
+		params iofl.Params
+		in     string
+		want   string
+	}{
+		{iofl.Params{}, "Hello", "Hello"},
+		{iofl.Params{"preset": "upper"}, "Hello, World", "HELLO, WORLD"},
+		{iofl.Params{"preset": "lower"}, "Hello, World", "hello, world"},
+		{iofl.Params{"preset": "rot13"}, "Hello, World", "Uryyb, Jbeyq"},
+		{iofl.Params{"preset": "controls"}, "a\x00b\tc\nd\x7f\re", "ab\tc\nd\re"},
+		{iofl.Params{"from": "a-c", "to": "x-z"}, "abcd", "xyzd"},
+		{iofl.Params{"from": "a-e", "to": "xy"}, "abcdef", "xyyyyf"},
+		{iofl.Params{"delete": "\\n\\r"}, "a\r\nb\n", "ab"},
+		{iofl.Params{"delete": "\\x00-\\x1f"}, "a\x01b\x1fc", "abc"},
+		{iofl.Params{"delete": "\\-"}, "a-b", "ab"},
+		// Each param maps input bytes, and later params take precedence.
+		{iofl.Params{"from": "a", "to": "b", "delete": "b"}, "abc", "bc"},
+		{iofl.Params{"from": "a", "to": "b", "delete": "a"}, "abc", "bc"},
+		{iofl.Params{"preset": "upper", "from": "A", "to": "a"}, "aAb", "AaB"},
+	}
+	for _, tt := range tests {
+		got := mustRead(t, filters.Translate, tt.params, []byte(tt.in))
+		if string(got) != tt.want {
+			t.Errorf("%v %q: got %q, want %q", tt.params, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestTranslateTable(t *testing.T) {
+	table := make([]interface{}, 256)
+	for i := range table {
+		table[i] = float64(255 - i)
+	}
+	table['x'] = -1
+	got := mustRead(t, filters.Translate, iofl.Params{"table": table}, []byte("\x00x\xff"))
+	if string(got) != "\xff\x00" {
+		t.Errorf("got %q", got)
+	}
+
+	// Table values may be of any integer type.
+	for i := range table {
+		table[i] = i
+	}
+	table['a'] = int64('b')
+	got = mustRead(t, filters.Translate, iofl.Params{"table": table}, []byte("abc"))
+	if string(got) != "bbc" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestTranslateInvalid(t *testing.T) {
+	short := make([]interface{}, 10)
+	bad := make([]interface{}, 256)
+	for i := range bad {
+		bad[i] = float64(i)
+	}
+	bad[3] = "x"
+	large := append([]interface{}(nil), bad...)
+	large[3] = float64(256)
+	fraction := append([]interface{}(nil), bad...)
+	fraction[3] = 1.5
+	for _, params := range []iofl.Params{
+		{"preset": "bogus"},
+		{"table": short},
+		{"table": bad},
+		{"table": large},
+		{"table": fraction},
+		{"from": "abc"},
+		{"from": "z-a", "to": "b"},
+		{"delete": "\\"},
+		{"delete": "\\xZZ"},
+	} {
+		if _, err := readFilter(t, filters.Translate, params, nil); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+}
+
+func TestTranslateLarge(t *testing.T) {
+	in := strings.Repeat("abc\x00", 5000)
+	got := mustRead(t, filters.Translate, iofl.Params{"preset": "controls", iofl.ParamBufferSize: 16}, []byte(in))
+	if string(got) != strings.Repeat("abc", 5000) {
+		t.Error("output does not match")
+	}
+}
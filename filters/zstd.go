@@ -0,0 +1,226 @@
+package filters
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Magic numbers of zstd frames.
+const (
+	zstdMagic         = 0xFD2FB528
+	zstdSkippableMask = 0xFFFFFFF0
+	zstdSkippableBase = 0x184D2A50
+)
+
+// zstdMaxBlockSize is the maximum size of a zstd block.
+const zstdMaxBlockSize = 128 << 10
+
+// errZstdData is returned when zstd data is malformed.
+var errZstdData error = &iofl.CorruptError{Err: errors.New("malformed zstd data")}
+
+// zstdHeader is the parsed header of a zstd frame.
+type zstdHeader struct {
+	// size is the size of the header, including the magic number.
+	size int
+	// contentSize is the decompressed size of the frame, or -1 if unknown.
+	contentSize int64
+	checksum    bool
+}
+
+// parseZstdHeader parses the header of a zstd frame from the beginning of b.
+// Returns io.ErrUnexpectedEOF if b is too short.
+func parseZstdHeader(b []byte) (h zstdHeader, err error) {
+	if len(b) < 5 {
+		return h, io.ErrUnexpectedEOF
+	}
+	if binary.LittleEndian.Uint32(b) != zstdMagic {
+		return h, fmt.Errorf("%w: bad magic number", errZstdData)
+	}
+	fhd := b[4]
+	if fhd&0x08 != 0 {
+		return h, fmt.Errorf("%w: reserved bit set", errZstdData)
+	}
+	single := fhd&0x20 != 0
+	h.checksum = fhd&0x04 != 0
+	h.size = 5
+	if !single {
+		h.size++
+	}
+	h.size += [4]int{0, 1, 2, 4}[fhd&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fcsSize == 0 && single {
+		fcsSize = 1
+	}
+	if len(b) < h.size+fcsSize {
+		return h, io.ErrUnexpectedEOF
+	}
+	fcs := b[h.size : h.size+fcsSize]
+	h.size += fcsSize
+	switch fcsSize {
+	case 0:
+		h.contentSize = -1
+	case 1:
+		h.contentSize = int64(fcs[0])
+	case 2:
+		h.contentSize = int64(binary.LittleEndian.Uint16(fcs)) + 256
+	case 4:
+		h.contentSize = int64(binary.LittleEndian.Uint32(fcs))
+	case 8:
+		h.contentSize = int64(binary.LittleEndian.Uint64(fcs))
+	}
+	return h, nil
+}
+
+// readZstdFrame reads one complete frame from br, appending its bytes to buf.
+// skippable indicates whether the frame is a skippable frame. Returns io.EOF
+// if br is empty.
+func readZstdFrame(br *bufio.Reader, buf []byte, max int) (frame []byte, skippable bool, err error) {
+	magic, err := br.Peek(4)
+	if err != nil {
+		if err == io.EOF && len(magic) == 0 {
+			return buf, false, io.EOF
+		}
+		return buf, false, noEOF(err)
+	}
+	if binary.LittleEndian.Uint32(magic)&zstdSkippableMask == zstdSkippableBase {
+		var hdr [8]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return buf, true, noEOF(err)
+		}
+		size := binary.LittleEndian.Uint32(hdr[4:])
+		if int64(size) > int64(max) {
+			return buf, true, fmt.Errorf("%w: frame exceeds maximum size", errZstdData)
+		}
+		buf = append(buf, hdr[:]...)
+		buf, err = readN(br, buf, int(size))
+		return buf, true, err
+	}
+	// Read the largest possible header, which is 18 bytes.
+	peek, _ := br.Peek(18)
+	h, err := parseZstdHeader(peek)
+	if err != nil {
+		return buf, false, noEOF(err)
+	}
+	if buf, err = readN(br, buf, h.size); err != nil {
+		return buf, false, err
+	}
+	start := len(buf) - h.size
+	for {
+		var bh [3]byte
+		if _, err := io.ReadFull(br, bh[:]); err != nil {
+			return buf, false, noEOF(err)
+		}
+		buf = append(buf, bh[:]...)
+		v := uint32(bh[0]) | uint32(bh[1])<<8 | uint32(bh[2])<<16
+		size := int(v >> 3)
+		switch (v >> 1) & 3 {
+		case 1:
+			// RLE blocks store a single byte.
+			size = 1
+		case 3:
+			return buf, false, fmt.Errorf("%w: reserved block type", errZstdData)
+		}
+		if len(buf)-start+size > max {
+			return buf, false, fmt.Errorf("%w: frame exceeds maximum size", errZstdData)
+		}
+		if buf, err = readN(br, buf, size); err != nil {
+			return buf, false, err
+		}
+		if v&1 != 0 {
+			break
+		}
+	}
+	if h.checksum {
+		buf, err = readN(br, buf, 4)
+	}
+	return buf, false, err
+}
+
+// readN reads exactly n bytes from r, appending them to buf.
+func readN(r io.Reader, buf []byte, n int) ([]byte, error) {
+	start := len(buf)
+	if cap(buf)-start < n {
+		b := make([]byte, start, start+n)
+		copy(b, buf)
+		buf = b
+	}
+	buf = buf[:start+n]
+	if _, err := io.ReadFull(r, buf[start:]); err != nil {
+		return buf[:start], noEOF(err)
+	}
+	return buf, nil
+}
+
+// zstdRawCodec is a FrameCodec that produces zstd frames containing
+// uncompressed blocks. It decodes frames containing only uncompressed and RLE
+// blocks.
+type zstdRawCodec struct{}
+
+func (zstdRawCodec) EncodeFrame(dst, src []byte) ([]byte, error) {
+	var hdr [13]byte
+	binary.LittleEndian.PutUint32(hdr[:], zstdMagic)
+	// Single segment, with an 8-byte content size.
+	hdr[4] = 0xE0
+	binary.LittleEndian.PutUint64(hdr[5:], uint64(len(src)))
+	dst = append(dst, hdr[:]...)
+	for {
+		n := len(src)
+		if n > zstdMaxBlockSize {
+			n = zstdMaxBlockSize
+		}
+		v := uint32(n) << 3
+		if n == len(src) {
+			v |= 1
+		}
+		dst = append(dst, byte(v), byte(v>>8), byte(v>>16))
+		dst = append(dst, src[:n]...)
+		src = src[n:]
+		if len(src) == 0 {
+			return dst, nil
+		}
+	}
+}
+
+func (zstdRawCodec) DecodeFrame(dst, src []byte) ([]byte, error) {
+	h, err := parseZstdHeader(src)
+	if err != nil {
+		return dst, err
+	}
+	src = src[h.size:]
+	for {
+		if len(src) < 3 {
+			return dst, fmt.Errorf("%w: truncated block", errZstdData)
+		}
+		v := uint32(src[0]) | uint32(src[1])<<8 | uint32(src[2])<<16
+		src = src[3:]
+		size := int(v >> 3)
+		switch (v >> 1) & 3 {
+		case 0:
+			if len(src) < size {
+				return dst, fmt.Errorf("%w: truncated block", errZstdData)
+			}
+			dst = append(dst, src[:size]...)
+			src = src[size:]
+		case 1:
+			if len(src) < 1 {
+				return dst, fmt.Errorf("%w: truncated block", errZstdData)
+			}
+			for i := 0; i < size; i++ {
+				dst = append(dst, src[0])
+			}
+			src = src[1:]
+		case 2:
+			return dst, errors.New("compressed zstd block requires the zstd codec")
+		default:
+			return dst, fmt.Errorf("%w: reserved block type", errZstdData)
+		}
+		if v&1 != 0 {
+			return dst, nil
+		}
+	}
+}
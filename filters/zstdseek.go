@@ -0,0 +1,423 @@
+package filters
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+
+	"github.com/anaminus/iofl"
+)
+
+// ZstdSeek encodes and decodes the zstd seekable format, in which content is
+// compressed as a sequence of independent frames, followed by a seek table
+// that records the size of each frame. Params:
+//
+//	mode:  "decode" (default) or "encode".
+//	codec: The name of the FrameCodec that compresses and decompresses frames.
+//	       Defaults to "zstd". When decoding, "zstd-raw" is used if "zstd" is
+//	       not registered.
+//	frame: The decompressed size of each frame when encoding, in bytes.
+//	       Defaults to 1MiB. Smaller frames allow finer random access at the
+//	       cost of compression ratio.
+//	max:   The maximum size of a frame when decoding, in bytes. Defaults to
+//	       64MiB.
+//
+// When decoding, the filter reads its source sequentially. If the source
+// implements io.ReaderAt and io.Seeker, such as an *os.File, the filter also
+// implements io.ReaderAt and io.Seeker over the decompressed content, using the
+// seek table to decompress only the frames required. After a call to Seek,
+// Read continues from the new offset. Checksums in the seek table are not
+// verified, and are not produced when encoding.
+var ZstdSeek = iofl.FilterDef{
+	Name:         "zstdseek",
+	New:          newZstdSeek,
+	Description:  "Encodes and decodes the zstd seekable format, supporting random access.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decode\" or \"encode\".", Default: "decode"},
+		{Name: "codec", Type: iofl.ParamString, Description: "The name of the FrameCodec that compresses and decompresses frames.", Default: "zstd"},
+		{Name: "frame", Type: iofl.ParamInt, Description: "The decompressed size of each frame when encoding, in bytes.", Default: "1MiB"},
+		{Name: "max", Type: iofl.ParamInt, Description: "The maximum size of a frame when decoding, in bytes.", Default: "64MiB"},
+	},
+	Traits: func(params iofl.Params) iofl.Trait {
+		if params.GetString("mode") == "encode" {
+			return iofl.Compresses
+		}
+		return iofl.Decompresses
+	},
+}
+
+// Constants of the seekable format.
+const (
+	zstdSeekTableMagic   = 0x184D2A5E
+	zstdSeekFooterMagic  = 0x8F92EAB1
+	zstdSeekFooterSize   = 9
+	zstdSeekChecksumFlag = 0x80
+)
+
+// errNoRandomAccess is returned when seeking a filter whose source does not
+// support random access.
+var errNoRandomAccess = errors.New("source does not support random access")
+
+func newZstdSeek(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errNoSource
+	}
+	mode, err := getMode(params, "decode", "decode", "encode")
+	if err != nil {
+		return nil, err
+	}
+	name := params.GetString("codec")
+	if name == "" {
+		name = "zstd"
+		if _, err := getCodec(name); err != nil && mode == "decode" {
+			name = "zstd-raw"
+		}
+	}
+	filter := &zstdSeekFilter{encode: mode == "encode", bufSize: bufferSize(params)}
+	if filter.codec, err = getCodec(name); err != nil {
+		return nil, err
+	}
+	if filter.frame = params.GetInt("frame"); filter.frame <= 0 {
+		filter.frame = 1 << 20
+	}
+	if filter.max = params.GetInt("max"); filter.max <= 0 {
+		filter.max = defaultMaxMessage
+	}
+	filter.stream.next = filter.next
+	filter.Reset(r)
+	return filter, nil
+}
+
+// zstdSeekEntry locates a frame within seekable content.
+type zstdSeekEntry struct {
+	cOff, cSize int64
+	dOff, dSize int64
+}
+
+// zstdSeekFilter implements the ZstdSeek filter.
+type zstdSeekFilter struct {
+	src     io.ReadCloser
+	encode  bool
+	codec   FrameCodec
+	frame   int
+	max     int
+	bufSize int
+	closed  bool
+
+	br     *bufio.Reader
+	buf    []byte
+	out    []byte
+	done   bool
+	sizes  [][2]uint32
+	stream frameStream
+
+	// Random access.
+	random   bool
+	pos      int64
+	table    []zstdSeekEntry
+	cached   int
+	cache    []byte
+	tableErr error
+}
+
+// Source implements iofl.Filter.
+func (f *zstdSeekFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *zstdSeekFilter) CPUIntensive() bool {
+	return true
+}
+
+// Read implements io.Reader.
+func (f *zstdSeekFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if f.random {
+		n, err = f.ReadAt(p, f.pos)
+		f.pos += int64(n)
+		if err == io.EOF && n > 0 {
+			err = nil
+		}
+		return n, err
+	}
+	n, err = f.stream.Read(p)
+	f.pos += int64(n)
+	return n, err
+}
+
+// Close implements io.Closer, closing the source.
+func (f *zstdSeekFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *zstdSeekFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.done = false
+	f.sizes = f.sizes[:0]
+	f.stream.reset()
+	f.random = false
+	f.pos = 0
+	f.table = nil
+	f.cached = -1
+	f.tableErr = nil
+	if f.br == nil {
+		f.br = bufio.NewReaderSize(src, f.bufSize)
+	} else {
+		f.br.Reset(src)
+	}
+	return nil
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *zstdSeekFilter) MemoryUsage() int {
+	return f.br.Size() + cap(f.buf) + cap(f.out) + cap(f.cache)
+}
+
+// next returns the next chunk of output.
+func (f *zstdSeekFilter) next() (b []byte, err error) {
+	if f.encode {
+		return f.nextEncoded()
+	}
+	for {
+		var skippable bool
+		if f.buf, skippable, err = readZstdFrame(f.br, f.buf[:0], f.max); err != nil {
+			return nil, err
+		}
+		if skippable {
+			continue
+		}
+		if f.out, err = f.codec.DecodeFrame(f.out[:0], f.buf); err != nil {
+			return nil, err
+		}
+		return f.out, nil
+	}
+}
+
+// nextEncoded encodes the next frame, or the seek table once the source is
+// exhausted.
+func (f *zstdSeekFilter) nextEncoded() (b []byte, err error) {
+	if f.done {
+		return nil, io.EOF
+	}
+	if cap(f.buf) < f.frame {
+		f.buf = make([]byte, f.frame)
+	}
+	n, err := io.ReadFull(f.br, f.buf[:f.frame])
+	if n > 0 {
+		if f.out, err = f.codec.EncodeFrame(f.out[:0], f.buf[:n]); err != nil {
+			return nil, err
+		}
+		if int64(len(f.out)) > 1<<32-1 {
+			return nil, errors.New("frame too large")
+		}
+		f.sizes = append(f.sizes, [2]uint32{uint32(len(f.out)), uint32(n)})
+		return f.out, nil
+	}
+	if err != io.EOF {
+		return nil, err
+	}
+	f.done = true
+	tableSize := len(f.sizes)*8 + zstdSeekFooterSize
+	f.out = f.out[:0]
+	f.out = appendUint32(f.out, zstdSeekTableMagic)
+	f.out = appendUint32(f.out, uint32(tableSize))
+	for _, s := range f.sizes {
+		f.out = appendUint32(f.out, s[0])
+		f.out = appendUint32(f.out, s[1])
+	}
+	f.out = appendUint32(f.out, uint32(len(f.sizes)))
+	f.out = append(f.out, 0)
+	f.out = appendUint32(f.out, zstdSeekFooterMagic)
+	return f.out, nil
+}
+
+// appendUint32 appends v in little-endian order.
+func appendUint32(b []byte, v uint32) []byte {
+	return append(b, byte(v), byte(v>>8), byte(v>>16), byte(v>>24))
+}
+
+// randomAccess returns the source as an io.ReaderAt, along with its size.
+func (f *zstdSeekFilter) randomAccess() (io.ReaderAt, int64, error) {
+	src := rootReader(f.src)
+	ra, ok := src.(io.ReaderAt)
+	if !ok {
+		return nil, 0, errNoRandomAccess
+	}
+	seeker, ok := src.(io.Seeker)
+	if !ok {
+		return nil, 0, errNoRandomAccess
+	}
+	cur, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return nil, 0, err
+	}
+	size, err := seeker.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, err
+	}
+	if _, err := seeker.Seek(cur, io.SeekStart); err != nil {
+		return nil, 0, err
+	}
+	return ra, size, nil
+}
+
+// loadTable reads the seek table from the end of the source.
+func (f *zstdSeekFilter) loadTable() error {
+	if f.encode {
+		return errors.New("cannot seek while encoding")
+	}
+	if f.table != nil || f.tableErr != nil {
+		return f.tableErr
+	}
+	f.tableErr = f.readTable()
+	return f.tableErr
+}
+
+func (f *zstdSeekFilter) readTable() error {
+	ra, size, err := f.randomAccess()
+	if err != nil {
+		return err
+	}
+	var footer [zstdSeekFooterSize]byte
+	if size < 8+zstdSeekFooterSize {
+		return fmt.Errorf("%w: missing seek table", errZstdData)
+	}
+	if _, err := ra.ReadAt(footer[:], size-zstdSeekFooterSize); err != nil {
+		return noEOF(err)
+	}
+	if binary.LittleEndian.Uint32(footer[5:]) != zstdSeekFooterMagic {
+		return fmt.Errorf("%w: missing seek table", errZstdData)
+	}
+	desc := footer[4]
+	if desc&0x7C != 0 {
+		return fmt.Errorf("%w: reserved bits set in seek table", errZstdData)
+	}
+	entrySize := int64(8)
+	if desc&zstdSeekChecksumFlag != 0 {
+		entrySize = 12
+	}
+	frames := int64(binary.LittleEndian.Uint32(footer[:4]))
+	tableSize := frames*entrySize + zstdSeekFooterSize
+	start := size - 8 - tableSize
+	if start < 0 {
+		return fmt.Errorf("%w: seek table exceeds content", errZstdData)
+	}
+	table := make([]byte, 8+tableSize-zstdSeekFooterSize)
+	if _, err := ra.ReadAt(table, start); err != nil {
+		return noEOF(err)
+	}
+	if binary.LittleEndian.Uint32(table) != zstdSeekTableMagic || int64(binary.LittleEndian.Uint32(table[4:])) != tableSize {
+		return fmt.Errorf("%w: malformed seek table", errZstdData)
+	}
+	entries := make([]zstdSeekEntry, frames)
+	var cOff, dOff int64
+	for i := range entries {
+		e := table[8+int64(i)*entrySize:]
+		entries[i] = zstdSeekEntry{
+			cOff:  cOff,
+			cSize: int64(binary.LittleEndian.Uint32(e)),
+			dOff:  dOff,
+			dSize: int64(binary.LittleEndian.Uint32(e[4:])),
+		}
+		cOff += entries[i].cSize
+		dOff += entries[i].dSize
+		if entries[i].cSize > int64(f.max) || entries[i].dSize > int64(f.max) {
+			return fmt.Errorf("%w: frame exceeds maximum size", errZstdData)
+		}
+	}
+	if cOff > start {
+		return fmt.Errorf("%w: seek table exceeds content", errZstdData)
+	}
+	f.table = entries
+	return nil
+}
+
+// size returns the decompressed size of the content.
+func (f *zstdSeekFilter) size() int64 {
+	if len(f.table) == 0 {
+		return 0
+	}
+	last := f.table[len(f.table)-1]
+	return last.dOff + last.dSize
+}
+
+// Seek implements io.Seeker over the decompressed content.
+func (f *zstdSeekFilter) Seek(offset int64, whence int) (int64, error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if err := f.loadTable(); err != nil {
+		return 0, err
+	}
+	switch whence {
+	case io.SeekStart:
+	case io.SeekCurrent:
+		offset += f.pos
+	case io.SeekEnd:
+		offset += f.size()
+	default:
+		return 0, errors.New("invalid whence")
+	}
+	if offset < 0 {
+		return 0, errors.New("negative position")
+	}
+	f.random = true
+	f.pos = offset
+	return offset, nil
+}
+
+// ReadAt implements io.ReaderAt over the decompressed content.
+func (f *zstdSeekFilter) ReadAt(p []byte, off int64) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	if err := f.loadTable(); err != nil {
+		return 0, err
+	}
+	if off < 0 {
+		return 0, errors.New("negative offset")
+	}
+	ra, _, err := f.randomAccess()
+	if err != nil {
+		return 0, err
+	}
+	for n < len(p) {
+		i := sort.Search(len(f.table), func(i int) bool {
+			return f.table[i].dOff+f.table[i].dSize > off
+		})
+		if i >= len(f.table) {
+			return n, io.EOF
+		}
+		e := f.table[i]
+		if f.cached != i {
+			f.cached = -1
+			if f.buf, err = readN(io.NewSectionReader(ra, e.cOff, e.cSize), f.buf[:0], int(e.cSize)); err != nil {
+				return n, err
+			}
+			if f.cache, err = f.codec.DecodeFrame(f.cache[:0], f.buf); err != nil {
+				return n, err
+			}
+			if int64(len(f.cache)) != e.dSize {
+				return n, fmt.Errorf("%w: frame size does not match seek table", errZstdData)
+			}
+			f.cached = i
+		}
+		c := copy(p[n:], f.cache[off-e.dOff:])
+		n += c
+		off += int64(c)
+	}
+	return n, nil
+}
@@ -0,0 +1,227 @@
+package filters_test
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// byteFile is a source that supports random access.
+type byteFile struct {
+	*bytes.Reader
+}
+
+func (byteFile) Close() error { return nil }
+
+var zstdSeekContent = []byte(strings.Repeat("0123456789abcdefghijklmnopqrstuvwxyz", 20))
+
+func zstdSeekEncode(t *testing.T, frame int, content []byte) []byte {
+	t.Helper()
+	return mustRead(t, filters.ZstdSeek, iofl.Params{"mode": "encode", "codec": "zstd-raw", "frame": frame}, content)
+}
+
+// zstdSeekOpen returns a decoding filter over the random access source b.
+func zstdSeekOpen(t *testing.T, b []byte) iofl.Filter {
+	t.Helper()
+	f, err := filters.ZstdSeek.New(nil, iofl.Root{ReadCloser: byteFile{bytes.NewReader(b)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestZstdSeekRoundTrip(t *testing.T) {
+	for _, frame := range []int{1, 7, 100, 10000} {
+		enc := zstdSeekEncode(t, frame, zstdSeekContent)
+		if binary.LittleEndian.Uint32(enc[len(enc)-4:]) != 0x8F92EAB1 {
+			t.Fatalf("frame %d: missing seek table footer", frame)
+		}
+		if out := mustRead(t, filters.ZstdSeek, iofl.Params{"codec": "zstd-raw"}, enc); !bytes.Equal(out, zstdSeekContent) {
+			t.Errorf("frame %d: round trip mismatch", frame)
+		}
+	}
+	enc := zstdSeekEncode(t, 10, nil)
+	if out := mustRead(t, filters.ZstdSeek, nil, enc); len(out) != 0 {
+		t.Errorf("empty: got %q", out)
+	}
+}
+
+func TestZstdSeekRandomAccess(t *testing.T) {
+	f := zstdSeekOpen(t, zstdSeekEncode(t, 16, zstdSeekContent))
+	defer f.Close()
+	ra := f.(io.ReaderAt)
+	for _, off := range []int{0, 5, 15, 16, 31, 100, len(zstdSeekContent) - 3} {
+		b := make([]byte, 20)
+		n, err := ra.ReadAt(b, int64(off))
+		want := zstdSeekContent[off:]
+		if len(want) > len(b) {
+			want = want[:len(b)]
+		} else if err != io.EOF {
+			t.Errorf("%d: got %v, want EOF", off, err)
+		}
+		if !bytes.Equal(b[:n], want) {
+			t.Errorf("%d: got %q, want %q", off, b[:n], want)
+		}
+	}
+
+	seeker := f.(io.Seeker)
+	if pos, err := seeker.Seek(-10, io.SeekEnd); err != nil || pos != int64(len(zstdSeekContent)-10) {
+		t.Fatalf("got %d, %v", pos, err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || !bytes.Equal(rest, zstdSeekContent[len(zstdSeekContent)-10:]) {
+		t.Errorf("after seek: got %q, %v", rest, err)
+	}
+	if pos, err := seeker.Seek(-30, io.SeekCurrent); err != nil || pos != int64(len(zstdSeekContent)-30) {
+		t.Errorf("got %d, %v", pos, err)
+	}
+	if _, err := seeker.Seek(-1, io.SeekStart); err == nil {
+		t.Error("expected error seeking before start")
+	}
+}
+
+func TestZstdSeekSequentialThenSeek(t *testing.T) {
+	f := zstdSeekOpen(t, zstdSeekEncode(t, 8, zstdSeekContent))
+	defer f.Close()
+	b := make([]byte, 12)
+	if _, err := io.ReadFull(f, b); err != nil || !bytes.Equal(b, zstdSeekContent[:12]) {
+		t.Fatalf("got %q, %v", b, err)
+	}
+	if pos, err := f.(io.Seeker).Seek(0, io.SeekCurrent); err != nil || pos != 12 {
+		t.Fatalf("got %d, %v", pos, err)
+	}
+	rest, err := ioutil.ReadAll(f)
+	if err != nil || !bytes.Equal(rest, zstdSeekContent[12:]) {
+		t.Errorf("got %q, %v", rest, err)
+	}
+}
+
+func TestZstdSeekNoRandomAccess(t *testing.T) {
+	f, err := filters.ZstdSeek.New(nil, ioutil.NopCloser(bytes.NewReader(zstdSeekEncode(t, 8, zstdSeekContent))))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.(io.Seeker).Seek(0, io.SeekStart); err == nil {
+		t.Error("expected error seeking without random access")
+	}
+}
+
+func TestZstdSeekCorrupt(t *testing.T) {
+	enc := zstdSeekEncode(t, 16, zstdSeekContent)
+	mutate := func(i int, v byte) []byte {
+		b := append([]byte{}, enc...)
+		if i < 0 {
+			i += len(b)
+		}
+		b[i] = v
+		return b
+	}
+	// Offsets from the end: the footer is 9 bytes, preceded by 8-byte entries.
+	tests := []struct {
+		name string
+		in   []byte
+	}{
+		{"bad footer magic", mutate(-1, 0)},
+		{"reserved bits", mutate(-5, 0x04)},
+		{"frame count", mutate(-9, 0xFF)},
+		{"table magic", mutate(len(enc)-9-8*((len(zstdSeekContent)+15)/16)-8, 0)},
+		{"frame size", mutate(-10, 0x7F)},
+		{"too short", enc[len(enc)-10:]},
+	}
+	for _, tt := range tests {
+		f := zstdSeekOpen(t, tt.in)
+		_, err := f.(io.ReaderAt).ReadAt(make([]byte, 1), 0)
+		if !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", tt.name, err)
+		}
+		f.Close()
+	}
+
+	// Sequential decoding of damaged frames.
+	for _, tt := range []struct {
+		name string
+		in   []byte
+	}{
+		{"bad frame magic", mutate(0, 0)},
+		{"reserved block type", mutate(13, 0x06)},
+		{"truncated", enc[:20]},
+	} {
+		_, err := readFilter(t, filters.ZstdSeek, nil, tt.in)
+		if !iofl.IsCorrupt(err) && !errors.Is(err, io.ErrUnexpectedEOF) {
+			t.Errorf("%s: got %v, want corrupt or truncated", tt.name, err)
+		}
+	}
+	if _, err := readFilter(t, filters.ZstdSeek, iofl.Params{"max": 8}, enc); !iofl.IsCorrupt(err) {
+		t.Errorf("oversize: got %v, want corrupt", err)
+	}
+}
+
+// xorCodec is a FrameCodec that stores each frame as a single uncompressed zstd
+// block, with its content inverted.
+type xorCodec struct{}
+
+func (xorCodec) EncodeFrame(dst, src []byte) ([]byte, error) {
+	var hdr [16]byte
+	binary.LittleEndian.PutUint32(hdr[:], 0xFD2FB528)
+	hdr[4] = 0xE0
+	binary.LittleEndian.PutUint64(hdr[5:], uint64(len(src)))
+	v := uint32(len(src))<<3 | 1
+	hdr[13], hdr[14], hdr[15] = byte(v), byte(v>>8), byte(v>>16)
+	dst = append(dst, hdr[:]...)
+	for _, c := range src {
+		dst = append(dst, ^c)
+	}
+	return dst, nil
+}
+
+func (xorCodec) DecodeFrame(dst, src []byte) ([]byte, error) {
+	if len(src) < 16 {
+		return dst, errors.New("short frame")
+	}
+	for _, c := range src[16:] {
+		dst = append(dst, ^c)
+	}
+	return dst, nil
+}
+
+func TestZstdSeekCodec(t *testing.T) {
+	filters.RegisterCodec("test-xor", xorCodec{})
+	params := iofl.Params{"mode": "encode", "codec": "test-xor", "frame": 50}
+	enc := mustRead(t, filters.ZstdSeek, params, zstdSeekContent)
+	if bytes.Contains(enc, zstdSeekContent[:10]) {
+		t.Error("content was not encoded by codec")
+	}
+	if out := mustRead(t, filters.ZstdSeek, iofl.Params{"codec": "test-xor"}, enc); !bytes.Equal(out, zstdSeekContent) {
+		t.Error("round trip mismatch")
+	}
+
+	// The raw codec rejects compressed blocks.
+	compressed := append([]byte{}, zstdSeekEncode(t, 16, []byte("abc"))...)
+	compressed[13] |= 0x04
+	if _, err := readFilter(t, filters.ZstdSeek, nil, compressed); err == nil {
+		t.Error("expected error decoding compressed block")
+	}
+}
+
+func TestZstdSeekParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "compress"},
+		{"codec": "missing"},
+		{"mode": "encode", "codec": ""},
+	} {
+		if _, err := filters.ZstdSeek.New(params, ioutil.NopCloser(bytes.NewReader(nil))); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := filters.ZstdSeek.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
@@ -0,0 +1,46 @@
+package iofl
+
+import "io"
+
+// Flusher is implemented by a WriteFilter that buffers written content. Flush
+// writes buffered content to the sink of the filter, as far as the format of
+// its output allows, without ending the output. For example, a compressor
+// completes the current block. Content that cannot be written until the
+// output ends, such as a partial group of an encoding, remains buffered.
+type Flusher interface {
+	// Flush writes buffered content to the sink.
+	Flush() error
+}
+
+// Flush flushes the write chain of w, such as one produced by ResolveWriter,
+// so that content written to w reaches the destination of the chain without
+// closing it. Each WriteFilter of the chain that implements Flusher is
+// flushed, starting with w and proceeding toward the destination, so that the
+// content flushed by a link is flushed by the next. The destination is then
+// flushed if it has a Flush method, such as a *bufio.Writer or an
+// http.ResponseWriter. Stops at the first error.
+func Flush(w io.WriteCloser) error {
+	return ApplyWriter(w, func(w io.WriteCloser) error {
+		if r, ok := w.(RootWriter); ok {
+			return Flush(r.WriteCloser)
+		}
+		if f, ok := w.(WriteFilter); !ok || f.Sink() == nil {
+			return flushDest(w)
+		}
+		if f, ok := w.(Flusher); ok {
+			return f.Flush()
+		}
+		return nil
+	})
+}
+
+// flushDest flushes the destination of a write chain, if possible.
+func flushDest(w io.Writer) error {
+	switch f := w.(type) {
+	case Flusher:
+		return f.Flush()
+	case interface{ Flush() }:
+		f.Flush()
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package iofl_test
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// bufferWriter is a WriteFilter that buffers content until it is flushed,
+// recording its flushes in log.
+type bufferWriter struct {
+	name string
+	dst  io.WriteCloser
+	buf  bytes.Buffer
+	log  *[]string
+	err  error
+}
+
+func (w *bufferWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *bufferWriter) Sink() io.WriteCloser        { return w.dst }
+
+func (w *bufferWriter) Flush() error {
+	*w.log = append(*w.log, w.name)
+	if w.err != nil {
+		return w.err
+	}
+	_, err := w.buf.WriteTo(w.dst)
+	return err
+}
+
+func (w *bufferWriter) Close() error {
+	if err := w.Flush(); err != nil {
+		return err
+	}
+	return w.dst.Close()
+}
+
+// bufioWriteCloser is a destination with a Flush method that returns an
+// error.
+type bufioWriteCloser struct {
+	*bufio.Writer
+}
+
+func (bufioWriteCloser) Close() error { return nil }
+
+// flushRecorder is a destination with a Flush method that returns nothing.
+type flushRecorder struct {
+	*httptest.ResponseRecorder
+}
+
+func (flushRecorder) Close() error { return nil }
+
+func TestFlush(t *testing.T) {
+	var log []string
+	var buf bytes.Buffer
+	dst := bufioWriteCloser{bufio.NewWriter(&buf)}
+	inner := &bufferWriter{name: "inner", dst: iofl.RootWriter{WriteCloser: dst}, log: &log}
+	outer := &bufferWriter{name: "outer", dst: upperWriter{inner}, log: &log}
+	if _, err := outer.Write([]byte("abc")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() != 0 {
+		t.Fatalf("got %q before flush", buf.String())
+	}
+	if err := iofl.Flush(outer); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "ABC" {
+		t.Errorf("got %q after flush", buf.String())
+	}
+	if len(log) != 2 || log[0] != "outer" || log[1] != "inner" {
+		t.Errorf("got flushes %v", log)
+	}
+
+	// Flushing stops at the first error.
+	log = nil
+	inner.err = errBoom
+	outer.Write([]byte("def"))
+	if err := iofl.Flush(outer); err != errBoom {
+		t.Errorf("got %v, want errBoom", err)
+	}
+	if inner.buf.String() != "DEF" || buf.String() != "ABC" {
+		t.Errorf("destination flushed after error: %q", buf.String())
+	}
+
+	// A destination whose Flush method returns nothing is flushed.
+	rec := flushRecorder{httptest.NewRecorder()}
+	if err := iofl.Flush(upperWriter{iofl.RootWriter{WriteCloser: rec}}); err != nil || !rec.Flushed {
+		t.Errorf("got flushed %v, %v", rec.Flushed, err)
+	}
+	// A destination without a Flush method is ignored.
+	if err := iofl.Flush(nopWriteCloser{&buf}); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFlushChain(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "gzip", Params: iofl.Params{"mode": "decompress"}}, {Filter: "identity"}},
+	})
+	var buf bytes.Buffer
+	w, err := s.ResolveWriter("c", nopWriteCloser{&buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	content := []byte("flushed through the chain")
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := iofl.Flush(w); err != nil {
+		t.Fatal(err)
+	}
+	// The content written so far can be decompressed before the stream ends.
+	zr, err := gzip.NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	got := make([]byte, len(content))
+	if _, err := io.ReadFull(zr, got); err != nil || !bytes.Equal(got, content) {
+		t.Errorf("got %q, %v", got, err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := iofl.Flush(w); err != iofl.Closed {
+		t.Errorf("after close: got %v, want Closed", err)
+	}
+}
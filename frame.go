@@ -0,0 +1,11 @@
+package iofl
+
+// Framer is implemented by a Filter that produces a sequence of discrete
+// records, or frames. Frames can be read individually with ReadFrame, rather
+// than as a stream of bytes with Read. Calls to ReadFrame and Read should not be
+// interleaved.
+type Framer interface {
+	// ReadFrame returns the next frame. Returns io.EOF when no frames remain.
+	// The returned slice is valid only until the next call to ReadFrame.
+	ReadFrame() ([]byte, error)
+}
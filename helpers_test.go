@@ -0,0 +1,85 @@
+package iofl_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+// newChainSet returns a ChainSet with the built-in filters and defs
+// registered, and configured with the given chains.
+func newChainSet(t *testing.T, chains map[string]iofl.Chain, defs ...iofl.FilterDef) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	for _, def := range defs {
+		if err := s.Register(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.SetConfig(iofl.Config{Chains: chains}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// source returns a source that reads s.
+func source(s string) io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader([]byte(s)))
+}
+
+// readAll reads f to completion, and closes it.
+func readAll(t *testing.T, f io.ReadCloser) string {
+	t.Helper()
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return string(b)
+}
+
+// nopWriteCloser adds a no-op Close method to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// funcFilter is a Filter whose Read calls read.
+type funcFilter struct {
+	src  io.ReadCloser
+	read func(p []byte) (int, error)
+}
+
+func (f *funcFilter) Read(p []byte) (int, error) { return f.read(p) }
+func (f *funcFilter) Source() io.ReadCloser      { return f.src }
+
+func (f *funcFilter) Close() error {
+	if f.src != nil {
+		return f.src.Close()
+	}
+	return nil
+}
+
+// hookFilter returns the definition of a filter of the given name that calls
+// fn on each Read, before reading from its source.
+func hookFilter(name string, fn func()) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return &funcFilter{src: r, read: func(p []byte) (int, error) {
+				fn()
+				return r.Read(p)
+			}}, nil
+		},
+	}
+}
@@ -0,0 +1,66 @@
+package iofl
+
+import "io"
+
+// Hook is called with a Filter produced by the link at index of chain, defined
+// by def.
+type Hook func(chain string, index int, def LinkDef, f Filter)
+
+// OnConstruct registers a Hook that is called with each Filter constructed by a
+// link of a chain, as the chain is resolved. The Filter is passed before any
+// decorators are applied. Hooks are called in the order they are registered.
+func (s *ChainSet) OnConstruct(hook Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onConstruct = append(s.onConstruct, hook)
+	s.invalidate()
+}
+
+// OnClose registers a Hook that is called with each Filter constructed by a
+// link of a chain, after the Filter is closed. Hooks are called once per
+// Filter, in the order they are registered.
+func (s *ChainSet) OnClose(hook Hook) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onClose = append(s.onClose, hook)
+	s.invalidate()
+}
+
+// constructed calls the construction hooks with f, and returns f wrapped to call
+// the close hooks, if any.
+func (v *Snapshot) constructed(link Link, f Filter) Filter {
+	for _, hook := range v.onConstruct {
+		hook(link.Chain, link.Index, link.Def, f)
+	}
+	if len(v.onClose) == 0 {
+		return f
+	}
+	return &hooked{f: f, link: link, hooks: v.onClose}
+}
+
+// hooked calls close hooks when a Filter is closed.
+type hooked struct {
+	f      Filter
+	link   Link
+	hooks  []Hook
+	closed bool
+}
+
+func (h *hooked) Read(p []byte) (n int, err error) {
+	return h.f.Read(p)
+}
+
+func (h *hooked) Close() error {
+	err := h.f.Close()
+	if !h.closed {
+		h.closed = true
+		for _, hook := range h.hooks {
+			hook(h.link.Chain, h.link.Index, h.link.Def, h.f)
+		}
+	}
+	return err
+}
+
+func (h *hooked) Source() io.ReadCloser {
+	return h.f
+}
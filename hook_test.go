@@ -0,0 +1,70 @@
+package iofl_test
+
+import (
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestHooks(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "translate", Params: iofl.Params{"preset": "upper"}},
+			{Filter: "identity"},
+		},
+	})
+	var events []string
+	record := func(event string) iofl.Hook {
+		return func(chain string, index int, def iofl.LinkDef, f iofl.Filter) {
+			if f == nil {
+				t.Errorf("%s: nil filter", event)
+			}
+			events = append(events, fmt.Sprintf("%s %s[%d]%s", event, chain, index, def.Filter))
+		}
+	}
+	s.OnConstruct(record("construct"))
+	s.OnConstruct(record("construct2"))
+	s.OnClose(record("close"))
+
+	f, err := s.Resolve("c", source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"construct c[0]translate",
+		"construct2 c[0]translate",
+		"construct c[1]identity",
+		"construct2 c[1]identity",
+	}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %q, want %q", events, want)
+	}
+	events = nil
+	if got := readAll(t, f); got != "ABC" {
+		t.Errorf("got %q", got)
+	}
+	// Closing again does not call the hooks again. Each filter closes its
+	// source before its own hooks are called.
+	f.Close()
+	want = []string{"close c[0]translate", "close c[1]identity"}
+	if !reflect.DeepEqual(events, want) {
+		t.Errorf("got %q, want %q", events, want)
+	}
+}
+
+func TestHooksFilter(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	var constructed, closed iofl.Filter
+	s.OnConstruct(func(chain string, index int, def iofl.LinkDef, f iofl.Filter) { constructed = f })
+	s.OnClose(func(chain string, index int, def iofl.LinkDef, f iofl.Filter) { closed = f })
+	f, err := s.Resolve("c", source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if constructed == nil || closed != constructed {
+		t.Error("hooks did not receive the same filter")
+	}
+}
@@ -0,0 +1,109 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+	"sync"
+	"time"
+)
+
+// Reaped is returned by a chain that was closed after being idle.
+var Reaped = errors.New("reaped while idle")
+
+// ReapIdle returns an Option that closes a resolved chain when it has not been
+// read for the duration d, such as when a reader of a network source is
+// leaked. A chain is not idle while a Read is in progress. Once closed, Reads
+// of the chain return Reaped. Reaped chains are reported to the ChainSet's
+// Logger.
+func ReapIdle(d time.Duration) Option {
+	return func(o *resolveOptions) {
+		o.idle = d
+	}
+}
+
+// reaper returns f wrapped to be closed after being idle for d.
+func (s *ChainSet) reaper(chain string, d time.Duration, f Filter) Filter {
+	r := &idleFilter{f: f, d: d, last: time.Now()}
+	// The timer is assigned under the lock, since reap, which may run as soon
+	// as the timer is created, resets the timer while holding the lock.
+	r.mu.Lock()
+	r.timer = time.AfterFunc(d, func() {
+		if r.reap() {
+			s.logf("iofl: reaped chain %q after %s idle", chain, d)
+		}
+	})
+	r.mu.Unlock()
+	return r
+}
+
+// idleFilter closes a Filter that has been idle.
+type idleFilter struct {
+	f     Filter
+	d     time.Duration
+	timer *time.Timer
+
+	mu      sync.Mutex
+	last    time.Time
+	reading int
+	reaped  bool
+	closed  bool
+}
+
+// reap closes the Filter if it is idle, or reschedules the check otherwise.
+// Returns whether the Filter was closed.
+func (r *idleFilter) reap() bool {
+	r.mu.Lock()
+	if r.closed {
+		r.mu.Unlock()
+		return false
+	}
+	if r.reading > 0 {
+		r.timer.Reset(r.d)
+		r.mu.Unlock()
+		return false
+	}
+	if idle := time.Since(r.last); idle < r.d {
+		r.timer.Reset(r.d - idle)
+		r.mu.Unlock()
+		return false
+	}
+	r.reaped = true
+	r.closed = true
+	r.mu.Unlock()
+	r.f.Close()
+	return true
+}
+
+func (r *idleFilter) Read(p []byte) (n int, err error) {
+	r.mu.Lock()
+	if r.reaped {
+		r.mu.Unlock()
+		return 0, Reaped
+	}
+	r.reading++
+	r.mu.Unlock()
+	n, err = r.f.Read(p)
+	r.mu.Lock()
+	r.reading--
+	r.last = time.Now()
+	r.mu.Unlock()
+	return n, err
+}
+
+func (r *idleFilter) Close() error {
+	r.mu.Lock()
+	if r.closed {
+		reaped := r.reaped
+		r.mu.Unlock()
+		if reaped {
+			return nil
+		}
+		return Closed
+	}
+	r.closed = true
+	r.mu.Unlock()
+	r.timer.Stop()
+	return r.f.Close()
+}
+
+func (r *idleFilter) Source() io.ReadCloser { return r.f }
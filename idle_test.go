@@ -0,0 +1,136 @@
+package iofl_test
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// logRecorder is a Logger that records messages.
+type logRecorder struct {
+	mu   sync.Mutex
+	msgs []string
+}
+
+func (l *logRecorder) Printf(format string, v ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.msgs = append(l.msgs, fmt.Sprintf(format, v...))
+}
+
+func (l *logRecorder) messages() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.msgs...)
+}
+
+// closeSignal is a source that signals when it is closed.
+type closeSignal struct {
+	io.Reader
+	closed chan struct{}
+}
+
+func newCloseSignal(s string) *closeSignal {
+	return &closeSignal{Reader: strings.NewReader(s), closed: make(chan struct{})}
+}
+
+func (c *closeSignal) Close() error {
+	close(c.closed)
+	return nil
+}
+
+func TestReapIdle(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	var log logRecorder
+	s.SetLogger(&log)
+	src := newCloseSignal("abc")
+	f, err := s.Resolve("c", src, iofl.ReapIdle(20*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-src.closed:
+	case <-time.After(time.Second):
+		t.Fatal("chain was not reaped")
+	}
+	if _, err := f.Read(make([]byte, 1)); err != iofl.Reaped {
+		t.Errorf("got %v, want Reaped", err)
+	}
+	if err := f.Close(); err != nil {
+		t.Errorf("close after reap: %v", err)
+	}
+	if msgs := log.messages(); len(msgs) != 1 || !strings.Contains(msgs[0], `"c"`) {
+		t.Errorf("got log %q", msgs)
+	}
+}
+
+func TestReapIdleActive(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	src := newCloseSignal(strings.Repeat("x", 10))
+	f, err := s.Resolve("c", src, iofl.ReapIdle(40*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Reads more frequent than the idle duration keep the chain open.
+	for i := 0; i < 8; i++ {
+		if _, err := f.Read(make([]byte, 1)); err != nil {
+			t.Fatalf("read %d: %v", i, err)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
+
+func TestReapIdleReading(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	pr, pw := io.Pipe()
+	f, err := s.Resolve("c", pr, iofl.ReapIdle(10*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// A chain blocked in Read is not idle.
+	go func() {
+		for _, c := range "ab" {
+			time.Sleep(50 * time.Millisecond)
+			pw.Write([]byte{byte(c)})
+		}
+	}()
+	for i := 0; i < 2; i++ {
+		if n, err := f.Read(make([]byte, 1)); n != 1 || err != nil {
+			t.Fatalf("read %d: got %d, %v", i, n, err)
+		}
+	}
+}
+
+func TestReapIdleRace(t *testing.T) {
+	// Timers that fire immediately run reap concurrently with the rest of
+	// Resolve.
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	s.SetLogger(&logRecorder{})
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := s.Resolve("c", source("abc"), iofl.ReapIdle(time.Nanosecond))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			f.Read(make([]byte, 1))
+			f.Close()
+		}()
+	}
+	wg.Wait()
+}
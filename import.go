@@ -0,0 +1,57 @@
+package iofl
+
+import "fmt"
+
+// Conflict specifies how a filter is handled when its name is already
+// registered.
+type Conflict int
+
+const (
+	// ConflictError causes an error to be returned.
+	ConflictError Conflict = iota
+	// ConflictSkip keeps the existing filter.
+	ConflictSkip
+	// ConflictReplace replaces the existing filter.
+	ConflictReplace
+)
+
+// ImportOptions configures ChainSet.Import.
+type ImportOptions struct {
+	// Prefix is prepended to the name of each imported filter.
+	Prefix string
+	// Conflict specifies how to handle an imported filter whose name is
+	// already registered.
+	Conflict Conflict
+}
+
+// Import registers the filter definitions of other with s, configured by opts.
+// Chains are not imported. If an error is returned, no filters are registered.
+func (s *ChainSet) Import(other *ChainSet, opts ImportOptions) error {
+	if other == nil {
+		return nil
+	}
+	// Filters returns the definitions in order of name, and the lock of other
+	// is released before s is locked.
+	defs := other.Filters()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if opts.Conflict == ConflictError {
+		for _, def := range defs {
+			if _, ok := s.registry[opts.Prefix+def.Name]; ok {
+				return fmt.Errorf("filter %q already registered", opts.Prefix+def.Name)
+			}
+		}
+	}
+	if s.registry == nil {
+		s.registry = make(map[string]FilterDef, len(defs))
+	}
+	for _, def := range defs {
+		if _, ok := s.registry[opts.Prefix+def.Name]; ok && opts.Conflict == ConflictSkip {
+			continue
+		}
+		def.Name = opts.Prefix + def.Name
+		s.registry[def.Name] = def
+	}
+	s.invalidate()
+	return nil
+}
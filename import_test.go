@@ -0,0 +1,87 @@
+package iofl_test
+
+import (
+	"io"
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// namedFilter returns a filter definition that ignores its source and reads
+// the given string.
+func namedFilter(name, s string) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return iofl.Root{source(s)}, nil
+		},
+	}
+}
+
+// bundle returns a ChainSet with the given filters registered.
+func bundle(t *testing.T, defs ...iofl.FilterDef) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	for _, def := range defs {
+		if err := s.Register(def); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func filterNames(s *iofl.ChainSet) []string {
+	var names []string
+	for _, def := range s.Filters() {
+		names = append(names, def.Name)
+	}
+	return names
+}
+
+func TestImport(t *testing.T) {
+	other := bundle(t, namedFilter("a", "other a"), namedFilter("b", "other b"))
+
+	tests := []struct {
+		name    string
+		opts    iofl.ImportOptions
+		names   []string
+		a       string
+		wantErr bool
+	}{
+		{"error", iofl.ImportOptions{}, []string{"a"}, "own a", true},
+		{"skip", iofl.ImportOptions{Conflict: iofl.ConflictSkip}, []string{"a", "b"}, "own a", false},
+		{"replace", iofl.ImportOptions{Conflict: iofl.ConflictReplace}, []string{"a", "b"}, "other a", false},
+		{"prefix", iofl.ImportOptions{Prefix: "x."}, []string{"a", "x.a", "x.b"}, "own a", false},
+	}
+	for _, tt := range tests {
+		s := bundle(t, namedFilter("a", "own a"))
+		err := s.Import(other, tt.opts)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+		if got := filterNames(s); !reflect.DeepEqual(got, tt.names) {
+			t.Errorf("%s: got filters %v, want %v", tt.name, got, tt.names)
+		}
+		if err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{"c": {{Filter: "a"}}}}); err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		f, err := s.Resolve("c", nil)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if got := readAll(t, f); got != tt.a {
+			t.Errorf("%s: got %q, want %q", tt.name, got, tt.a)
+		}
+	}
+}
+
+func TestImportNil(t *testing.T) {
+	s := bundle(t, namedFilter("a", ""))
+	if err := s.Import(nil, iofl.ImportOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if got := filterNames(s); !reflect.DeepEqual(got, []string{"a"}) {
+		t.Errorf("got %v", got)
+	}
+}
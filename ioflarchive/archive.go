@@ -0,0 +1,342 @@
+// The ioflarchive package builds archives from sequences of named entries, for
+// use at either end of an iofl chain.
+package ioflarchive
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// Entry describes a file added to an archive.
+type Entry struct {
+	// Name is the slash-separated path of the file within the archive.
+	Name string
+	// Size is the size of the file's content, or -1 if unknown. Tar archives
+	// require the size to be known.
+	Size int64
+	// Mode is the file's mode. Defaults to 0644.
+	Mode fs.FileMode
+	// ModTime is the modification time of the file. Defaults to the current
+	// time.
+	ModTime time.Time
+	// Sparse is set when reading an entry that was stored sparsely. It is
+	// ignored when building.
+	Sparse bool
+}
+
+// Builder writes a sequence of entries to an archive.
+type Builder interface {
+	// Create begins a new entry, returning a writer that receives the content
+	// of the entry. The writer must be closed before the next entry is created.
+	Create(entry Entry) (io.WriteCloser, error)
+	// Close finishes the archive, and closes the underlying writer.
+	Close() error
+}
+
+// Options configures a Builder.
+type Options struct {
+	// Method is the compression method used by zip archives. Either "deflate"
+	// (default) or "store".
+	Method string
+
+	// Zip64 controls the use of zip64 extensions by zip archives, which are
+	// required for entries of 4GiB or larger, archives of 4GiB or larger, and
+	// archives with more than 65535 entries. One of:
+	//
+	//	"auto":  Extensions are used when required (default).
+	//	"never": An error is returned when an extension would be required,
+	//	         for compatibility with legacy readers.
+	Zip64 string
+
+	// TarFormat is the header format used by tar archives. One of:
+	//
+	//	"pax":   POSIX.1-2001 format (default). Supports entries of any size
+	//	         and long names.
+	//	"gnu":   GNU format. Supports entries of any size and long names.
+	//	"ustar": POSIX.1-1988 format. Limited to entries smaller than 8GiB,
+	//	         for compatibility with legacy readers.
+	//
+	// Sparse entries are not written; content with holes is written densely.
+	// Sparse entries are supported when reading with Walk.
+	TarFormat string
+}
+
+// zip64Limit is the largest size representable without zip64 extensions.
+const zip64Limit = 1<<32 - 1
+
+// zip64EntryLimit is the largest number of entries representable without zip64
+// extensions.
+const zip64EntryLimit = 1<<16 - 1
+
+// New returns a Builder that writes an archive of the given format to w.
+// format is either "tar" or "zip".
+func New(format string, w io.WriteCloser, opts Options) (Builder, error) {
+	switch format {
+	case "tar":
+		var tf tar.Format
+		switch opts.TarFormat {
+		case "", "pax":
+			tf = tar.FormatPAX
+		case "gnu":
+			tf = tar.FormatGNU
+		case "ustar":
+			tf = tar.FormatUSTAR
+		default:
+			return nil, fmt.Errorf("unknown tar format %q", opts.TarFormat)
+		}
+		return &tarBuilder{w: w, tw: tar.NewWriter(w), format: tf}, nil
+	case "zip":
+		var method uint16
+		switch opts.Method {
+		case "", "deflate":
+			method = zip.Deflate
+		case "store":
+			method = zip.Store
+		default:
+			return nil, fmt.Errorf("unknown method %q", opts.Method)
+		}
+		var legacy bool
+		switch opts.Zip64 {
+		case "", "auto":
+		case "never":
+			legacy = true
+		default:
+			return nil, fmt.Errorf("unknown zip64 mode %q", opts.Zip64)
+		}
+		cw := &countWriter{w: w}
+		return &zipBuilder{w: w, cw: cw, zw: zip.NewWriter(cw), method: method, legacy: legacy}, nil
+	default:
+		return nil, fmt.Errorf("unknown format %q", format)
+	}
+}
+
+// countWriter counts the bytes written to an underlying writer.
+type countWriter struct {
+	w io.Writer
+	n int64
+}
+
+func (c *countWriter) Write(p []byte) (n int, err error) {
+	n, err = c.w.Write(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (e *Entry) defaults() {
+	if e.Mode == 0 {
+		e.Mode = 0644
+	}
+	if e.ModTime.IsZero() {
+		e.ModTime = time.Now()
+	}
+}
+
+// entryWriter is the writer of an entry's content.
+type entryWriter struct {
+	w      io.Writer
+	remain int64
+	closed bool
+}
+
+func (e *entryWriter) Write(p []byte) (n int, err error) {
+	if e.closed {
+		return 0, iofl.Closed
+	}
+	if e.remain >= 0 && int64(len(p)) > e.remain {
+		return 0, fmt.Errorf("entry exceeds declared size")
+	}
+	n, err = e.w.Write(p)
+	if e.remain >= 0 {
+		e.remain -= int64(n)
+	}
+	return n, err
+}
+
+func (e *entryWriter) Close() error {
+	if e.closed {
+		return iofl.Closed
+	}
+	e.closed = true
+	if e.remain > 0 {
+		return fmt.Errorf("entry short by %d bytes", e.remain)
+	}
+	return nil
+}
+
+type tarBuilder struct {
+	w      io.WriteCloser
+	tw     *tar.Writer
+	format tar.Format
+}
+
+func (b *tarBuilder) Create(entry Entry) (io.WriteCloser, error) {
+	if entry.Size < 0 {
+		return nil, errors.New("tar entry requires size")
+	}
+	entry.defaults()
+	hdr := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     entry.Name,
+		Size:     entry.Size,
+		Mode:     int64(entry.Mode.Perm()),
+		ModTime:  entry.ModTime,
+		Format:   b.format,
+	}
+	if b.format == tar.FormatUSTAR {
+		// USTAR cannot represent sub-second times.
+		hdr.ModTime = hdr.ModTime.Truncate(time.Second)
+	}
+	if err := b.tw.WriteHeader(hdr); err != nil {
+		return nil, err
+	}
+	return &entryWriter{w: b.tw, remain: entry.Size}, nil
+}
+
+func (b *tarBuilder) Close() error {
+	if err := b.tw.Close(); err != nil {
+		b.w.Close()
+		return err
+	}
+	return b.w.Close()
+}
+
+type zipBuilder struct {
+	w       io.WriteCloser
+	cw      *countWriter
+	zw      *zip.Writer
+	method  uint16
+	legacy  bool
+	entries int
+}
+
+func (b *zipBuilder) Create(entry Entry) (io.WriteCloser, error) {
+	if b.legacy {
+		if entry.Size > zip64Limit {
+			return nil, errors.New("entry requires zip64")
+		}
+		if b.entries >= zip64EntryLimit {
+			return nil, errors.New("entry count requires zip64")
+		}
+	}
+	b.entries++
+	entry.defaults()
+	hdr := &zip.FileHeader{
+		Name:     entry.Name,
+		Method:   b.method,
+		Modified: entry.ModTime,
+	}
+	hdr.SetMode(entry.Mode)
+	w, err := b.zw.CreateHeader(hdr)
+	if err != nil {
+		return nil, err
+	}
+	if b.legacy {
+		w = &legacyWriter{w: w, b: b}
+	}
+	return &entryWriter{w: w, remain: entry.Size}, nil
+}
+
+// legacyWriter returns an error when writing to a zip entry would require
+// zip64 extensions.
+type legacyWriter struct {
+	w io.Writer
+	b *zipBuilder
+	n int64
+}
+
+func (l *legacyWriter) Write(p []byte) (n int, err error) {
+	if l.n+int64(len(p)) > zip64Limit || l.b.cw.n+int64(len(p)) > zip64Limit {
+		return 0, errors.New("entry requires zip64")
+	}
+	n, err = l.w.Write(p)
+	l.n += int64(n)
+	return n, err
+}
+
+func (b *zipBuilder) Close() error {
+	if err := b.zw.Close(); err != nil {
+		b.w.Close()
+		return err
+	}
+	return b.w.Close()
+}
+
+// Add adds an entry to b, with content read from r.
+func Add(b Builder, entry Entry, r io.Reader) error {
+	w, err := b.Create(entry)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(w, r); err != nil {
+		w.Close()
+		return err
+	}
+	return w.Close()
+}
+
+// AddFS adds each regular file within fsys to b, walking from root. Entries are
+// named by their path relative to root.
+func AddFS(b Builder, fsys fs.FS, root string) error {
+	return fs.WalkDir(fsys, root, func(name string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		rel := name
+		if root != "." {
+			rel = name[len(root):]
+			if len(rel) > 0 && rel[0] == '/' {
+				rel = rel[1:]
+			}
+			if rel == "" {
+				rel = d.Name()
+			}
+		}
+		file, err := fsys.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		return Add(b, Entry{
+			Name:    rel,
+			Size:    info.Size(),
+			Mode:    info.Mode(),
+			ModTime: info.ModTime(),
+		}, file)
+	})
+}
+
+// Pack returns a Filter that produces an archive of the given format,
+// containing the files within fsys under root. The archive is built in a
+// separate goroutine as the Filter is read, allowing it to be used as the
+// source of a chain, such as one that compresses, encrypts, and uploads.
+func Pack(format string, fsys fs.FS, root string, opts Options) (iofl.Filter, error) {
+	pr, pw := io.Pipe()
+	b, err := New(format, pw, opts)
+	if err != nil {
+		return nil, err
+	}
+	go func() {
+		if err := AddFS(b, fsys, root); err != nil {
+			pw.CloseWithError(err)
+			return
+		}
+		if err := b.Close(); err != nil {
+			pw.CloseWithError(err)
+		}
+	}()
+	return iofl.Root{ReadCloser: pr}, nil
+}
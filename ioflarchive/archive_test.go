@@ -0,0 +1,253 @@
+package ioflarchive_test
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anaminus/iofl/ioflarchive"
+)
+
+// nopWriteCloser adds a no-op Close method to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+var modTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+var testFS = fstest.MapFS{
+	"a.txt":     {Data: []byte("alpha"), Mode: 0600, ModTime: modTime},
+	"dir/b.txt": {Data: []byte("bravo"), ModTime: modTime},
+	"dir/c.txt": {Data: []byte(strings.Repeat("charlie", 100)), ModTime: modTime},
+}
+
+// readTar returns the content of each file in a tar archive.
+func readTar(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	files := map[string]string{}
+	tr := tar.NewReader(bytes.NewReader(b))
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return files
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[hdr.Name] = string(content)
+	}
+}
+
+// readZip returns the content of each file in a zip archive.
+func readZip(t *testing.T, b []byte) map[string]string {
+	t.Helper()
+	zr, err := zip.NewReader(bytes.NewReader(b), int64(len(b)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, f := range zr.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(r)
+		r.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+	return files
+}
+
+func checkFiles(t *testing.T, name string, got map[string]string, want map[string]string) {
+	t.Helper()
+	if len(got) != len(want) {
+		t.Errorf("%s: got %d files, want %d", name, len(got), len(want))
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("%s: %s: got %q, want %q", name, k, got[k], v)
+		}
+	}
+}
+
+func TestBuilder(t *testing.T) {
+	want := map[string]string{"one": "first", "two/three": "second"}
+	for _, tt := range []struct {
+		format string
+		opts   ioflarchive.Options
+		read   func(*testing.T, []byte) map[string]string
+	}{
+		{"tar", ioflarchive.Options{}, readTar},
+		{"tar", ioflarchive.Options{TarFormat: "gnu"}, readTar},
+		{"tar", ioflarchive.Options{TarFormat: "ustar"}, readTar},
+		{"zip", ioflarchive.Options{}, readZip},
+		{"zip", ioflarchive.Options{Method: "store"}, readZip},
+	} {
+		var buf bytes.Buffer
+		b, err := ioflarchive.New(tt.format, nopWriteCloser{&buf}, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, name := range []string{"one", "two/three"} {
+			content := want[name]
+			entry := ioflarchive.Entry{Name: name, Size: int64(len(content)), ModTime: modTime}
+			if err := ioflarchive.Add(b, entry, strings.NewReader(content)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := b.Close(); err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, tt.format, tt.read(t, buf.Bytes()), want)
+	}
+}
+
+func TestBuilderErrors(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		opts   ioflarchive.Options
+	}{
+		{"rar", ioflarchive.Options{}},
+		{"tar", ioflarchive.Options{TarFormat: "v7"}},
+		{"zip", ioflarchive.Options{Zip64: "always"}},
+		{"zip", ioflarchive.Options{Method: "bzip2"}},
+	} {
+		if _, err := ioflarchive.New(tt.format, nopWriteCloser{ioutil.Discard}, tt.opts); err == nil {
+			t.Errorf("%s %+v: expected error", tt.format, tt.opts)
+		}
+	}
+
+	b, _ := ioflarchive.New("tar", nopWriteCloser{ioutil.Discard}, ioflarchive.Options{})
+	if _, err := b.Create(ioflarchive.Entry{Name: "x", Size: -1}); err == nil {
+		t.Error("tar: expected error for unknown size")
+	}
+	if err := ioflarchive.Add(b, ioflarchive.Entry{Name: "x", Size: 2}, strings.NewReader("abc")); err == nil {
+		t.Error("expected error for entry exceeding size")
+	}
+
+	b, _ = ioflarchive.New("zip", nopWriteCloser{ioutil.Discard}, ioflarchive.Options{})
+	if err := ioflarchive.Add(b, ioflarchive.Entry{Name: "x", Size: 4}, strings.NewReader("abc")); err == nil {
+		t.Error("expected error for short entry")
+	}
+	// Zip entries may have an unknown size.
+	if err := ioflarchive.Add(b, ioflarchive.Entry{Name: "y", Size: -1}, strings.NewReader("abc")); err != nil {
+		t.Errorf("unknown size: %v", err)
+	}
+}
+
+func TestPack(t *testing.T) {
+	for _, tt := range []struct {
+		format string
+		root   string
+		want   map[string]string
+		read   func(*testing.T, []byte) map[string]string
+	}{
+		{"tar", ".", map[string]string{
+			"a.txt":     "alpha",
+			"dir/b.txt": "bravo",
+			"dir/c.txt": strings.Repeat("charlie", 100),
+		}, readTar},
+		{"zip", "dir", map[string]string{
+			"b.txt": "bravo",
+			"c.txt": strings.Repeat("charlie", 100),
+		}, readZip},
+		{"zip", "a.txt", map[string]string{"a.txt": "alpha"}, readZip},
+	} {
+		f, err := ioflarchive.Pack(tt.format, testFS, tt.root, ioflarchive.Options{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, err := ioutil.ReadAll(f)
+		f.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		checkFiles(t, tt.format+" "+tt.root, tt.read(t, b), tt.want)
+	}
+}
+
+func TestPackError(t *testing.T) {
+	f, err := ioflarchive.Pack("tar", testFS, "missing", ioflarchive.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := ioutil.ReadAll(f); err == nil {
+		t.Error("expected error for missing root")
+	}
+}
+
+func TestLargeEntries(t *testing.T) {
+	const large = 8 << 30
+	for _, tt := range []struct {
+		format  string
+		opts    ioflarchive.Options
+		wantErr bool
+	}{
+		{"tar", ioflarchive.Options{}, false},
+		{"tar", ioflarchive.Options{TarFormat: "gnu"}, false},
+		{"tar", ioflarchive.Options{TarFormat: "ustar"}, true},
+		{"zip", ioflarchive.Options{}, false},
+		{"zip", ioflarchive.Options{Zip64: "auto"}, false},
+		{"zip", ioflarchive.Options{Zip64: "never"}, true},
+	} {
+		b, err := ioflarchive.New(tt.format, nopWriteCloser{ioutil.Discard}, tt.opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = b.Create(ioflarchive.Entry{Name: "large", Size: large})
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s %+v: got error %v, want error %v", tt.format, tt.opts, err, tt.wantErr)
+		}
+	}
+}
+
+func TestZip64EntryCount(t *testing.T) {
+	for _, mode := range []string{"auto", "never"} {
+		var buf bytes.Buffer
+		b, err := ioflarchive.New("zip", nopWriteCloser{&buf}, ioflarchive.Options{Method: "store", Zip64: mode})
+		if err != nil {
+			t.Fatal(err)
+		}
+		var cerr error
+		for i := 0; i <= 1<<16 && cerr == nil; i++ {
+			var w io.WriteCloser
+			if w, cerr = b.Create(ioflarchive.Entry{Name: "f", Size: 0, ModTime: modTime}); cerr == nil {
+				w.Close()
+			}
+		}
+		if mode == "never" && cerr == nil {
+			t.Error("never: expected error exceeding entry count")
+		}
+		if mode == "auto" {
+			if cerr != nil {
+				t.Fatalf("auto: %v", cerr)
+			}
+			if err := b.Close(); err != nil {
+				t.Fatal(err)
+			}
+			zr, err := zip.NewReader(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if n := len(zr.File); n != 1<<16+1 {
+				t.Errorf("auto: got %d entries, want %d", n, 1<<16+1)
+			}
+		}
+	}
+}
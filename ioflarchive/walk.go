@@ -0,0 +1,54 @@
+package ioflarchive
+
+import (
+	"archive/tar"
+	"io"
+	"strings"
+)
+
+// WalkTar reads a tar archive from r, calling fn for each regular file with the
+// file's entry and content. If fn returns an error, walking stops and the error
+// is returned.
+//
+// Sparse entries, in either the GNU or PAX formats, are expanded, with holes
+// read as zeros. The Sparse field of such entries is set to true.
+func WalkTar(r io.Reader, fn func(entry Entry, content io.Reader) error) error {
+	tr := tar.NewReader(r)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		switch hdr.Typeflag {
+		case tar.TypeReg, tar.TypeGNUSparse:
+		default:
+			continue
+		}
+		entry := Entry{
+			Name:    hdr.Name,
+			Size:    hdr.Size,
+			Mode:    hdr.FileInfo().Mode(),
+			ModTime: hdr.ModTime,
+			Sparse:  isSparse(hdr),
+		}
+		if err := fn(entry, tr); err != nil {
+			return err
+		}
+	}
+}
+
+// isSparse returns whether hdr describes a sparse file.
+func isSparse(hdr *tar.Header) bool {
+	if hdr.Typeflag == tar.TypeGNUSparse {
+		return true
+	}
+	for k := range hdr.PAXRecords {
+		if strings.HasPrefix(k, "GNU.sparse.") {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,135 @@
+package ioflarchive_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl/ioflarchive"
+)
+
+func TestWalkTar(t *testing.T) {
+	var buf bytes.Buffer
+	b, err := ioflarchive.New("tar", nopWriteCloser{&buf}, ioflarchive.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ioflarchive.AddFS(b, testFS, "."); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	got := map[string]string{}
+	err = ioflarchive.WalkTar(&buf, func(entry ioflarchive.Entry, content io.Reader) error {
+		b, err := ioutil.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		if entry.Size != int64(len(b)) || entry.Sparse || !entry.ModTime.Equal(modTime) {
+			t.Errorf("%s: unexpected entry %+v", entry.Name, entry)
+		}
+		got[entry.Name] = string(b)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkFiles(t, "walk", got, map[string]string{
+		"a.txt":     "alpha",
+		"dir/b.txt": "bravo",
+		"dir/c.txt": strings.Repeat("charlie", 100),
+	})
+}
+
+func TestWalkTarStop(t *testing.T) {
+	f, err := ioflarchive.Pack("tar", testFS, ".", ioflarchive.Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	errStop := errors.New("stop")
+	var n int
+	err = ioflarchive.WalkTar(f, func(ioflarchive.Entry, io.Reader) error {
+		n++
+		return errStop
+	})
+	if err != errStop || n != 1 {
+		t.Errorf("got %v after %d entries", err, n)
+	}
+
+	err = ioflarchive.WalkTar(strings.NewReader(strings.Repeat("x", 1024)), func(ioflarchive.Entry, io.Reader) error {
+		return nil
+	})
+	if err == nil {
+		t.Error("expected error for corrupt archive")
+	}
+}
+
+// gnuSparse returns an old-GNU-format tar archive containing a single sparse
+// file of the given size, with data stored at the given offsets.
+func gnuSparse(name string, size int64, data map[int64]string, offsets []int64) []byte {
+	hdr := make([]byte, 512)
+	octal := func(b []byte, v int64) {
+		copy(b, fmt.Sprintf("%0*o", len(b)-1, v))
+	}
+	var stored []byte
+	copy(hdr[0:], name)
+	octal(hdr[100:108], 0644)
+	octal(hdr[108:116], 0)
+	octal(hdr[116:124], 0)
+	octal(hdr[136:148], 0)
+	hdr[156] = 'S'
+	copy(hdr[257:], "ustar  \x00")
+	for i, off := range offsets {
+		entry := hdr[386+i*24:]
+		octal(entry[0:12], off)
+		octal(entry[12:24], int64(len(data[off])))
+		stored = append(stored, data[off]...)
+	}
+	octal(hdr[483:495], size)
+	octal(hdr[124:136], int64(len(stored)))
+	copy(hdr[148:156], "        ")
+	var sum int64
+	for _, c := range hdr {
+		sum += int64(c)
+	}
+	copy(hdr[148:156], fmt.Sprintf("%06o\x00 ", sum))
+
+	archive := append(hdr, stored...)
+	archive = append(archive, make([]byte, (512-len(stored)%512)%512+1024)...)
+	return archive
+}
+
+func TestWalkTarSparse(t *testing.T) {
+	archive := gnuSparse("sparse", 12, map[int64]string{2: "ab", 8: "cd"}, []int64{2, 8})
+	var n int
+	err := ioflarchive.WalkTar(bytes.NewReader(archive), func(entry ioflarchive.Entry, content io.Reader) error {
+		n++
+		b, err := ioutil.ReadAll(content)
+		if err != nil {
+			return err
+		}
+		if !entry.Sparse {
+			t.Error("entry not marked sparse")
+		}
+		if entry.Name != "sparse" || entry.Size != 12 {
+			t.Errorf("unexpected entry %+v", entry)
+		}
+		if want := "\x00\x00ab\x00\x00\x00\x00cd\x00\x00"; string(b) != want {
+			t.Errorf("got %q, want %q", b, want)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != 1 {
+		t.Errorf("got %d entries, want 1", n)
+	}
+}
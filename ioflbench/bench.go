@@ -0,0 +1,208 @@
+// The ioflbench package measures the performance of iofl chains.
+package ioflbench
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"runtime"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// Source returns a new reader of the data to be benchmarked. It is called once
+// per iteration, and must produce the same data each time.
+type Source func() (io.ReadCloser, error)
+
+// Options configures a benchmark.
+type Options struct {
+	// Iterations is the number of times the chain is run. Defaults to 1.
+	Iterations int
+	// BufferSize is the size of the buffer used to read from the chain.
+	// Defaults to 32KiB.
+	BufferSize int
+	// Pipelined, if greater than 0, causes the chain to be resolved with the
+	// iofl.Pipelined option, with buffers of the given size. The reads of each
+	// link then overlap, so the Self duration of a link is not meaningful.
+	Pipelined int
+}
+
+// Report contains the results of a benchmark. Counts and durations are
+// accumulated over all iterations.
+type Report struct {
+	// Chain is the name of the benchmarked chain.
+	Chain string
+	// Iterations is the number of times the chain was run.
+	Iterations int
+	// InputBytes is the number of bytes read from the source.
+	InputBytes int64
+	// OutputBytes is the number of bytes read from the chain.
+	OutputBytes int64
+	// Duration is the total time taken to resolve, read, and close the chain.
+	Duration time.Duration
+	// Allocs is the number of heap allocations made.
+	Allocs uint64
+	// AllocBytes is the number of bytes allocated on the heap.
+	AllocBytes uint64
+	// Links reports on each link of the chain.
+	Links []LinkReport
+}
+
+// LinkReport contains the results of a single link of a benchmarked chain.
+type LinkReport struct {
+	// Index is the position of the link within the chain.
+	Index int
+	// Filter is the name of the link's filter.
+	Filter string
+	// Reads is the number of times the link was read from.
+	Reads int64
+	// Bytes is the number of bytes produced by the link.
+	Bytes int64
+	// Total is the time spent within the Read method of the link, including
+	// time spent reading from preceding links.
+	Total time.Duration
+	// Self is the time spent within the link itself, excluding preceding
+	// links.
+	Self time.Duration
+}
+
+// Throughput returns the number of output bytes produced per second.
+func (r *Report) Throughput() float64 {
+	if r.Duration <= 0 {
+		return 0
+	}
+	return float64(r.OutputBytes) / r.Duration.Seconds()
+}
+
+// String returns a human-readable summary of the report.
+func (r *Report) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "chain %q, %d iteration(s)\n", r.Chain, r.Iterations)
+	fmt.Fprintf(&b, "input %d B, output %d B, %v, %.2f MB/s\n", r.InputBytes, r.OutputBytes, r.Duration, r.Throughput()/1e6)
+	fmt.Fprintf(&b, "%d allocs, %d B allocated\n", r.Allocs, r.AllocBytes)
+	w := tabwriter.NewWriter(&b, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "index\tfilter\treads\tbytes\ttotal\tself\t")
+	for _, l := range r.Links {
+		fmt.Fprintf(w, "%d\t%s\t%d\t%d\t%v\t%v\t\n", l.Index, l.Filter, l.Reads, l.Bytes, l.Total, l.Self)
+	}
+	w.Flush()
+	return b.String()
+}
+
+// timer is a Filter that measures reads from an underlying reader.
+type timer struct {
+	r     io.ReadCloser
+	reads int64
+	bytes int64
+	total time.Duration
+}
+
+func (t *timer) Read(p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = t.r.Read(p)
+	t.total += time.Since(start)
+	t.reads++
+	t.bytes += int64(n)
+	return n, err
+}
+
+func (t *timer) Close() error {
+	return t.r.Close()
+}
+
+func (t *timer) Source() io.ReadCloser {
+	return t.r
+}
+
+// Benchmark resolves chain from s and reads it to completion, using source as
+// the chain's source. The chain is run for the number of iterations specified
+// by opts.
+func Benchmark(s *iofl.ChainSet, chain string, source Source, opts Options) (report *Report, err error) {
+	if source == nil {
+		return nil, errors.New("nil source")
+	}
+	if opts.Iterations <= 0 {
+		opts.Iterations = 1
+	}
+	if opts.BufferSize <= 0 {
+		opts.BufferSize = 32 * 1024
+	}
+	report = &Report{Chain: chain, Iterations: opts.Iterations}
+	buf := make([]byte, opts.BufferSize)
+
+	var before, after runtime.MemStats
+	runtime.ReadMemStats(&before)
+	for i := 0; i < opts.Iterations; i++ {
+		if err := run(s, chain, source, buf, opts.Pipelined, report); err != nil {
+			return nil, err
+		}
+	}
+	runtime.ReadMemStats(&after)
+	report.Allocs = after.Mallocs - before.Mallocs
+	report.AllocBytes = after.TotalAlloc - before.TotalAlloc
+	return report, nil
+}
+
+// run runs a single iteration of the benchmark, accumulating results into
+// report.
+func run(s *iofl.ChainSet, chain string, source Source, buf []byte, pipelined int, report *Report) error {
+	start := time.Now()
+	src, err := source()
+	if err != nil {
+		return err
+	}
+	root := &timer{r: src}
+	var links []*timer
+	var defs []iofl.LinkDef
+	opts := []iofl.Option{iofl.Decorate(func(link iofl.Link, f iofl.Filter) iofl.Filter {
+		t := &timer{r: f}
+		links = append(links, t)
+		defs = append(defs, link.Def)
+		return t
+	})}
+	if pipelined > 0 {
+		opts = append(opts, iofl.Pipelined(pipelined))
+	}
+	f, err := s.Resolve(chain, root, opts...)
+	if err != nil {
+		return err
+	}
+	for {
+		n, err := f.Read(buf)
+		report.OutputBytes += int64(n)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			f.Close()
+			return err
+		}
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	report.Duration += time.Since(start)
+	report.InputBytes += root.bytes
+
+	if report.Links == nil {
+		report.Links = make([]LinkReport, len(links))
+		for i, def := range defs {
+			report.Links[i] = LinkReport{Index: i, Filter: def.Filter}
+		}
+	}
+	prev := root.total
+	for i, t := range links {
+		l := &report.Links[i]
+		l.Reads += t.reads
+		l.Bytes += t.bytes
+		l.Total += t.total
+		if self := t.total - prev; self > 0 {
+			l.Self += self
+		}
+		prev = t.total
+	}
+	return nil
+}
@@ -0,0 +1,109 @@
+package ioflbench_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/ioflbench"
+)
+
+func newChainSet(t *testing.T) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"hex": {
+			{Filter: "identity"},
+			{Filter: "hex", Params: iofl.Params{"mode": "encode"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func source(data []byte) ioflbench.Source {
+	return func() (io.ReadCloser, error) {
+		return ioutil.NopCloser(bytes.NewReader(data)), nil
+	}
+}
+
+func TestBenchmark(t *testing.T) {
+	s := newChainSet(t)
+	data := bytes.Repeat([]byte("abc"), 10000)
+	for _, pipelined := range []int{0, 4096} {
+		report, err := ioflbench.Benchmark(s, "hex", source(data), ioflbench.Options{
+			Iterations: 3,
+			BufferSize: 1000,
+			Pipelined:  pipelined,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if report.Chain != "hex" || report.Iterations != 3 {
+			t.Errorf("got chain %q, iterations %d", report.Chain, report.Iterations)
+		}
+		if want := int64(3 * len(data)); report.InputBytes != want {
+			t.Errorf("input bytes: got %d, want %d", report.InputBytes, want)
+		}
+		if want := int64(6 * len(data)); report.OutputBytes != want {
+			t.Errorf("output bytes: got %d, want %d", report.OutputBytes, want)
+		}
+		if len(report.Links) != 2 {
+			t.Fatalf("got %d links, want 2", len(report.Links))
+		}
+		for i, want := range []struct {
+			filter string
+			bytes  int64
+		}{
+			{"identity", int64(3 * len(data))},
+			{"hex", int64(6 * len(data))},
+		} {
+			l := report.Links[i]
+			if l.Index != i || l.Filter != want.filter || l.Bytes != want.bytes {
+				t.Errorf("link %d: got %+v, want filter %s, bytes %d", i, l, want.filter, want.bytes)
+			}
+			if l.Reads == 0 {
+				t.Errorf("link %d: no reads", i)
+			}
+		}
+		if report.Duration <= 0 || report.Throughput() <= 0 {
+			t.Errorf("got duration %v, throughput %v", report.Duration, report.Throughput())
+		}
+		str := report.String()
+		if !strings.Contains(str, "identity") || !strings.Contains(str, "hex") {
+			t.Errorf("report does not name links:\n%s", str)
+		}
+	}
+}
+
+func TestBenchmarkErrors(t *testing.T) {
+	s := newChainSet(t)
+	if _, err := ioflbench.Benchmark(s, "hex", nil, ioflbench.Options{}); err == nil {
+		t.Error("expected error for nil source")
+	}
+	if _, err := ioflbench.Benchmark(s, "missing", source(nil), ioflbench.Options{}); err == nil {
+		t.Error("expected error for unknown chain")
+	}
+	failing := func() (io.ReadCloser, error) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if _, err := ioflbench.Benchmark(s, "hex", failing, ioflbench.Options{}); err != io.ErrUnexpectedEOF {
+		t.Errorf("got %v, want source error", err)
+	}
+}
+
+func TestReportThroughputZero(t *testing.T) {
+	var r ioflbench.Report
+	if r.Throughput() != 0 {
+		t.Error("expected zero throughput for zero duration")
+	}
+}
@@ -0,0 +1,316 @@
+// The iofldir package processes files dropped into a directory through iofl
+// chains. A Watcher polls a directory, and passes each new or modified file
+// through a chain to a destination, such as another directory:
+//
+//	w := &iofldir.Watcher{
+//		FS:     os.DirFS("incoming"),
+//		Chains: s,
+//		Chain:  "compress",
+//		Dest:   iofldir.OutputDir("outgoing", ".gz"),
+//	}
+//	err := w.Run(ctx)
+package iofldir
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// DefaultInterval is the polling interval used when the Interval of a Watcher
+// is not set.
+const DefaultInterval = time.Second
+
+// Result describes the processing of one file by a Watcher.
+type Result struct {
+	// Name is the name of the file within the watched directory.
+	Name string
+	// Attempts is the number of times the file was run through the chain.
+	Attempts int
+	// Report is the report of the last attempt.
+	Report iofl.RunReport
+	// Err is the error of the last attempt, or nil if it succeeded.
+	Err error
+}
+
+// Watcher watches a directory, passing the content of each new or modified
+// file through a chain to a destination.
+//
+// The directory is polled, and is not watched recursively. A file is
+// processed once its size and modification time have been unchanged for one
+// polling interval, so that files that are still being written are not
+// processed early. Files whose names begin with "." are ignored, so that a
+// file can be written under a temporary name and then renamed into place.
+//
+// A file is processed again if it is modified after it has been processed.
+// Once the attempts of a file are exhausted, the file is not processed again
+// until it is modified.
+type Watcher struct {
+	// FS is the file system of the watched directory. Required.
+	FS fs.FS
+	// Chains is the ChainSet from which Chain is resolved. Required.
+	Chains *iofl.ChainSet
+	// Chain is the name of the chain through which files are passed.
+	Chain string
+	// Dest returns the destination of the output of the file with the given
+	// name. The destination is closed once the file has been processed. If
+	// processing fails, the destination is closed, and Dest is called again
+	// for the next attempt, and should replace the previous output.
+	// Required.
+	Dest func(name string) (io.WriteCloser, error)
+	// Pattern, if not empty, selects the files to process, using the syntax
+	// of path.Match on the name of each file.
+	Pattern string
+	// Interval is the polling interval. Defaults to DefaultInterval.
+	Interval time.Duration
+	// Concurrency is the number of files processed at the same time.
+	// Defaults to 1.
+	Concurrency int
+	// Retries is the number of times the processing of a file is retried
+	// after failing.
+	Retries int
+	// RetryDelay is the duration to wait before retrying.
+	RetryDelay time.Duration
+	// SkipExisting causes files present when Run is called to be ignored
+	// until they are modified.
+	SkipExisting bool
+	// Options are applied to each run of the chain.
+	Options []iofl.Option
+	// OnResult, if not nil, is called with the result of each processed file.
+	// It may be called concurrently when Concurrency is greater than 1.
+	OnResult func(Result)
+}
+
+// fileState is the state of a file observed by a Watcher.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	// active is whether the file is queued or being processed.
+	active bool
+	// done is whether the current version of the file has been dispatched
+	// for processing.
+	done bool
+}
+
+// Run watches the directory until ctx is done, then waits for files being
+// processed to finish, and returns ctx.Err(). An error is returned
+// immediately if the directory cannot be read.
+func (w *Watcher) Run(ctx context.Context) error {
+	if w.FS == nil || w.Chains == nil || w.Dest == nil {
+		return errors.New("watcher requires FS, Chains, and Dest")
+	}
+	if w.Pattern != "" {
+		if _, err := path.Match(w.Pattern, ""); err != nil {
+			return err
+		}
+	}
+	interval := w.Interval
+	if interval <= 0 {
+		interval = DefaultInterval
+	}
+	concurrency := w.Concurrency
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	var mu sync.Mutex
+	files := map[string]*fileState{}
+	jobs := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < concurrency; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for name := range jobs {
+				r := w.process(ctx, name)
+				mu.Lock()
+				if st, ok := files[name]; ok {
+					st.active = false
+				}
+				mu.Unlock()
+				if w.OnResult != nil {
+					w.OnResult(r)
+				}
+			}
+		}()
+	}
+	defer wg.Wait()
+	defer close(jobs)
+
+	first := true
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		entries, err := fs.ReadDir(w.FS, ".")
+		if err != nil {
+			return err
+		}
+		var ready []string
+		mu.Lock()
+		seen := make(map[string]bool, len(entries))
+		for _, entry := range entries {
+			name := entry.Name()
+			if !entry.Type().IsRegular() || strings.HasPrefix(name, ".") {
+				continue
+			}
+			if w.Pattern != "" {
+				if ok, _ := path.Match(w.Pattern, name); !ok {
+					continue
+				}
+			}
+			info, err := entry.Info()
+			if err != nil {
+				// Removed since being listed.
+				continue
+			}
+			seen[name] = true
+			st, ok := files[name]
+			if !ok {
+				st = &fileState{size: info.Size(), modTime: info.ModTime()}
+				st.done = first && w.SkipExisting
+				files[name] = st
+				continue
+			}
+			if info.Size() != st.size || !info.ModTime().Equal(st.modTime) {
+				st.size, st.modTime = info.Size(), info.ModTime()
+				st.done = false
+				continue
+			}
+			if !st.done && !st.active {
+				st.active, st.done = true, true
+				ready = append(ready, name)
+			}
+		}
+		for name, st := range files {
+			if !seen[name] && !st.active {
+				delete(files, name)
+			}
+		}
+		mu.Unlock()
+		first = false
+
+		for _, name := range ready {
+			select {
+			case jobs <- name:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+// process runs the named file through the chain, retrying on failure.
+func (w *Watcher) process(ctx context.Context, name string) (r Result) {
+	r.Name = name
+	for {
+		r.Attempts++
+		r.Report, r.Err = w.run(ctx, name)
+		if r.Err == nil || r.Attempts > w.Retries || ctx.Err() != nil {
+			return r
+		}
+		if w.RetryDelay > 0 {
+			t := time.NewTimer(w.RetryDelay)
+			select {
+			case <-t.C:
+			case <-ctx.Done():
+				t.Stop()
+				return r
+			}
+		}
+	}
+}
+
+// run makes one attempt to run the named file through the chain.
+func (w *Watcher) run(ctx context.Context, name string) (report iofl.RunReport, err error) {
+	file, err := w.FS.Open(name)
+	if err != nil {
+		return report, err
+	}
+	dst, err := w.Dest(name)
+	if err != nil {
+		file.Close()
+		return report, err
+	}
+	src := &source{File: file}
+	opts := append(w.Options[:len(w.Options):len(w.Options)], iofl.Cancel(ctx))
+	report, err = w.Chains.Run(w.Chain, dst, src, opts...)
+	// Not closed by Run if the chain failed to resolve.
+	src.Close()
+	if out, ok := dst.(*outputFile); ok && err != nil {
+		out.abort()
+		return report, err
+	}
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return report, err
+}
+
+// source is a file that may be closed more than once.
+type source struct {
+	fs.File
+	closed bool
+}
+
+func (s *source) Close() error {
+	if s.closed {
+		return nil
+	}
+	s.closed = true
+	return s.File.Close()
+}
+
+// OutputDir returns a function, suitable for the Dest field of a Watcher, that
+// creates a file in dir with the name of the processed file, followed by ext.
+// Output is written to a temporary file in dir, which is renamed into place
+// once it has been closed, so that incomplete output is never visible under
+// the final name. If processing fails, the temporary file is removed.
+func OutputDir(dir, ext string) func(name string) (io.WriteCloser, error) {
+	return func(name string) (io.WriteCloser, error) {
+		final := filepath.Join(dir, filepath.FromSlash(name)+ext)
+		file, err := ioutil.TempFile(dir, "."+path.Base(name)+".*")
+		if err != nil {
+			return nil, err
+		}
+		return &outputFile{File: file, final: final}, nil
+	}
+}
+
+// outputFile renames a temporary file to its final name when closed.
+type outputFile struct {
+	*os.File
+	final string
+}
+
+// abort closes and removes the temporary file.
+func (f *outputFile) abort() {
+	f.File.Close()
+	os.Remove(f.Name())
+}
+
+func (f *outputFile) Close() error {
+	if err := f.File.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	if err := os.Rename(f.Name(), f.final); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return nil
+}
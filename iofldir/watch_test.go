@@ -0,0 +1,290 @@
+package iofldir_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/iofldir"
+)
+
+var errBoom = errors.New("boom")
+
+// chainSet returns a ChainSet with an "upper" chain that upper-cases its
+// content, and a "fail" chain that fails to read.
+func chainSet(t *testing.T) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet(iofl.FilterDef{
+		Name: "fail",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return iofl.AsFilter(ioutil.NopCloser(iotestErrReader{})), nil
+		},
+	})
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"fail":  {{Filter: "fail"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// iotestErrReader is a reader that fails.
+type iotestErrReader struct{}
+
+func (iotestErrReader) Read([]byte) (int, error) { return 0, errBoom }
+
+// watch runs w over dir in the background, sending each result to the
+// returned channel. The returned function stops the watcher, and returns the
+// error of Run.
+func watch(t *testing.T, w *iofldir.Watcher, dir string) (results <-chan iofldir.Result, stop func() error) {
+	t.Helper()
+	ch := make(chan iofldir.Result, 100)
+	w.FS = os.DirFS(dir)
+	if w.Interval == 0 {
+		w.Interval = 5 * time.Millisecond
+	}
+	w.OnResult = func(r iofldir.Result) { ch <- r }
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- w.Run(ctx) }()
+	return ch, func() error {
+		cancel()
+		select {
+		case err := <-done:
+			return err
+		case <-time.After(5 * time.Second):
+			t.Fatal("watcher did not stop")
+			return nil
+		}
+	}
+}
+
+// next returns the next result, failing the test if it does not arrive.
+func next(t *testing.T, results <-chan iofldir.Result) iofldir.Result {
+	t.Helper()
+	select {
+	case r := <-results:
+		return r
+	case <-time.After(5 * time.Second):
+		t.Fatal("no result")
+		return iofldir.Result{}
+	}
+}
+
+// writeFile writes a file in dir.
+func writeFile(t *testing.T, dir, name, content string) {
+	t.Helper()
+	if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0666); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// readDir returns the names of the files in dir, with their content.
+func readDir(t *testing.T, dir string) map[string]string {
+	t.Helper()
+	entries, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := map[string]string{}
+	for _, entry := range entries {
+		b, err := ioutil.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[entry.Name()] = string(b)
+	}
+	return files
+}
+
+func TestWatcher(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	writeFile(t, in, "a.txt", "alpha")
+	writeFile(t, in, ".b.txt", "hidden")
+	writeFile(t, in, "c.log", "unmatched")
+	w := &iofldir.Watcher{
+		Chains:  chainSet(t),
+		Chain:   "upper",
+		Dest:    iofldir.OutputDir(out, ".up"),
+		Pattern: "*.txt",
+	}
+	results, stop := watch(t, w, in)
+	r := next(t, results)
+	if r.Name != "a.txt" || r.Attempts != 1 || r.Err != nil || r.Report.Written != 5 {
+		t.Errorf("got %+v", r)
+	}
+
+	// A modified file is processed again.
+	writeFile(t, in, "a.txt", "alpha, again")
+	if r := next(t, results); r.Name != "a.txt" || r.Err != nil {
+		t.Errorf("got %+v", r)
+	}
+	if err := stop(); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+	select {
+	case r := <-results:
+		t.Errorf("unexpected result %+v", r)
+	default:
+	}
+	if got := readDir(t, out); len(got) != 1 || got["a.txt.up"] != "ALPHA, AGAIN" {
+		t.Errorf("got output %v", got)
+	}
+}
+
+func TestWatcherSkipExisting(t *testing.T) {
+	in := t.TempDir()
+	writeFile(t, in, "existing", "x")
+	var mu sync.Mutex
+	var dests []string
+	w := &iofldir.Watcher{
+		Chains:       chainSet(t),
+		Chain:        "upper",
+		SkipExisting: true,
+		Dest: func(name string) (io.WriteCloser, error) {
+			mu.Lock()
+			defer mu.Unlock()
+			dests = append(dests, name)
+			return nopWriteCloser{ioutil.Discard}, nil
+		},
+	}
+	results, stop := watch(t, w, in)
+	time.Sleep(5 * w.Interval)
+	writeFile(t, in, "new", "y")
+	if r := next(t, results); r.Name != "new" {
+		t.Errorf("got %+v", r)
+	}
+	stop()
+	if len(dests) != 1 {
+		t.Errorf("got destinations %v", dests)
+	}
+}
+
+// nopWriteCloser adds a no-op Close method to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+func TestWatcherRetries(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	writeFile(t, in, "retried", "r")
+	var attempts int
+	dest := iofldir.OutputDir(out, "")
+	w := &iofldir.Watcher{
+		Chains:     chainSet(t),
+		Chain:      "upper",
+		Retries:    2,
+		RetryDelay: time.Millisecond,
+		Dest: func(name string) (io.WriteCloser, error) {
+			if attempts++; attempts < 3 {
+				return nil, errBoom
+			}
+			return dest(name)
+		},
+	}
+	results, stop := watch(t, w, in)
+	if r := next(t, results); r.Attempts != 3 || r.Err != nil {
+		t.Errorf("got %+v", r)
+	}
+	stop()
+	if got := readDir(t, out); got["retried"] != "R" {
+		t.Errorf("got output %v", got)
+	}
+}
+
+func TestWatcherFailure(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	writeFile(t, in, "failed", "f")
+	w := &iofldir.Watcher{
+		Chains:  chainSet(t),
+		Chain:   "fail",
+		Retries: 1,
+		Dest:    iofldir.OutputDir(out, ""),
+	}
+	results, stop := watch(t, w, in)
+	r := next(t, results)
+	if r.Attempts != 2 || !errors.Is(r.Err, errBoom) {
+		t.Errorf("got %+v", r)
+	}
+	// Once its attempts are exhausted, the file is not processed again
+	// until it is modified.
+	time.Sleep(5 * w.Interval)
+	stop()
+	if len(results) != 0 {
+		t.Errorf("got %d more results", len(results))
+	}
+	// Incomplete output is removed.
+	if got := readDir(t, out); len(got) != 0 {
+		t.Errorf("got output %v", got)
+	}
+}
+
+func TestWatcherConcurrency(t *testing.T) {
+	in, out := t.TempDir(), t.TempDir()
+	var want []string
+	for i := 0; i < 10; i++ {
+		name := string(rune('a' + i))
+		writeFile(t, in, name, strings.Repeat(name, 100))
+		want = append(want, name)
+	}
+	w := &iofldir.Watcher{
+		Chains:      chainSet(t),
+		Chain:       "upper",
+		Concurrency: 3,
+		Dest:        iofldir.OutputDir(out, ""),
+	}
+	results, stop := watch(t, w, in)
+	var got []string
+	for range want {
+		r := next(t, results)
+		if r.Err != nil {
+			t.Error(r.Err)
+		}
+		got = append(got, r.Name)
+	}
+	stop()
+	sort.Strings(got)
+	if strings.Join(got, "") != strings.Join(want, "") {
+		t.Errorf("got %v", got)
+	}
+	files := readDir(t, out)
+	for _, name := range want {
+		if files[name] != strings.Repeat(strings.ToUpper(name), 100) {
+			t.Errorf("%s: got %q", name, files[name])
+		}
+	}
+}
+
+func TestWatcherErrors(t *testing.T) {
+	s := chainSet(t)
+	dest := iofldir.OutputDir(t.TempDir(), "")
+	ctx := context.Background()
+	for name, w := range map[string]*iofldir.Watcher{
+		"no fs":       {Chains: s, Dest: dest},
+		"no chains":   {FS: os.DirFS("."), Dest: dest},
+		"no dest":     {FS: os.DirFS("."), Chains: s},
+		"pattern":     {FS: os.DirFS("."), Chains: s, Dest: dest, Pattern: "["},
+		"missing dir": {FS: os.DirFS(filepath.Join(t.TempDir(), "missing")), Chains: s, Dest: dest},
+	} {
+		if err := w.Run(ctx); err == nil || err == context.Canceled {
+			t.Errorf("%s: got %v", name, err)
+		}
+	}
+}
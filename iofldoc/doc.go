@@ -0,0 +1,218 @@
+// The iofldoc package renders human-readable documentation of the filters and
+// chains of an iofl.ChainSet, as Markdown or HTML.
+package iofldoc
+
+import (
+	"encoding/json"
+	"fmt"
+	"html/template"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/anaminus/iofl"
+)
+
+// Doc is the documentation model of a ChainSet.
+type Doc struct {
+	// Chains documents each configured chain, in order of name.
+	Chains []ChainDoc
+	// Filters documents each registered filter, in order of name.
+	Filters []iofl.FilterDef
+}
+
+// ChainDoc documents a chain.
+type ChainDoc struct {
+	// Name is the name of the chain.
+	Name string
+	// Links documents each link of the chain.
+	Links []LinkDoc
+	// Diagram is a Mermaid flowchart of the data flow through the chain.
+	Diagram string
+}
+
+// LinkDoc documents a link of a chain.
+type LinkDoc struct {
+	// Filter is the name of the link's filter.
+	Filter string
+	// Chain is the name of the chain referred to by the link, if the link is
+	// a reference to another chain.
+	Chain string
+	// Registered is whether the filter is registered with the ChainSet.
+	Registered bool
+	// Params lists the params of the link as "key: value" pairs, in order of
+	// key, with values encoded as JSON.
+	Params []string
+}
+
+// New returns the documentation model of s.
+func New(s *iofl.ChainSet) *Doc {
+	doc := &Doc{Filters: s.Filters()}
+	registered := map[string]bool{}
+	for _, def := range doc.Filters {
+		registered[def.Name] = true
+	}
+	config := s.Config()
+	names := make([]string, 0, len(config.Chains))
+	for name := range config.Chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		chain := config.Chains[name]
+		cd := ChainDoc{Name: name, Diagram: Diagram(chain)}
+		for _, link := range chain {
+			cd.Links = append(cd.Links, LinkDoc{
+				Filter:     link.Filter,
+				Chain:      link.Chain,
+				Registered: registered[link.Filter],
+				Params:     formatParams(link.Params),
+			})
+		}
+		doc.Chains = append(doc.Chains, cd)
+	}
+	return doc
+}
+
+// formatParams formats params as "key: value" pairs.
+func formatParams(params iofl.Params) []string {
+	keys := make([]string, 0, len(params))
+	for k := range params {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	pairs := make([]string, len(keys))
+	for i, k := range keys {
+		v, err := json.Marshal(params[k])
+		if err != nil {
+			v = []byte(fmt.Sprint(params[k]))
+		}
+		pairs[i] = k + ": " + string(v)
+	}
+	return pairs
+}
+
+// Diagram returns a Mermaid flowchart of the data flow through chain, from its
+// source to its output. A reference to another chain is drawn as a subroutine.
+func Diagram(chain iofl.Chain) string {
+	var b strings.Builder
+	b.WriteString("flowchart LR\n    src([source])")
+	for i, link := range chain {
+		if link.Chain != "" {
+			label := strings.ReplaceAll(link.Chain, `"`, "#quot;")
+			fmt.Fprintf(&b, " --> l%d[[\"%s\"]]", i, label)
+			continue
+		}
+		label := strings.ReplaceAll(link.Filter, `"`, "#quot;")
+		fmt.Fprintf(&b, " --> l%d[\"%s\"]", i, label)
+	}
+	b.WriteString(" --> out([output])\n")
+	return b.String()
+}
+
+// mdEscape escapes text for use within a Markdown table cell.
+func mdEscape(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	s = strings.ReplaceAll(s, "|", `\|`)
+	return strings.ReplaceAll(s, "\n", " ")
+}
+
+// Markdown writes the documentation to w as Markdown. Diagrams are written as
+// Mermaid code blocks.
+func (d *Doc) Markdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("# Pipelines\n\n## Chains\n")
+	if len(d.Chains) == 0 {
+		b.WriteString("\nNo chains are configured.\n")
+	}
+	for _, c := range d.Chains {
+		fmt.Fprintf(&b, "\n<a id=\"chain-%s\"></a>\n### %s\n\n```mermaid\n%s```\n", c.Name, c.Name, c.Diagram)
+		if len(c.Links) == 0 {
+			b.WriteString("\nThe chain passes its source through unchanged.\n")
+			continue
+		}
+		b.WriteString("\n| # | Filter | Params |\n|---|--------|--------|\n")
+		for i, l := range c.Links {
+			filter := fmt.Sprintf("[%s](#filter-%s)", mdEscape(l.Filter), l.Filter)
+			if l.Chain != "" {
+				filter = fmt.Sprintf("chain [%s](#chain-%s)", mdEscape(l.Chain), l.Chain)
+			} else if !l.Registered {
+				filter = mdEscape(l.Filter) + " (unregistered)"
+			}
+			params := make([]string, len(l.Params))
+			for j, p := range l.Params {
+				params[j] = "`" + mdEscape(p) + "`"
+			}
+			fmt.Fprintf(&b, "| %d | %s | %s |\n", i, filter, strings.Join(params, "<br>"))
+		}
+	}
+	b.WriteString("\n## Filters\n")
+	for _, f := range d.Filters {
+		fmt.Fprintf(&b, "\n<a id=\"filter-%s\"></a>\n### %s\n", f.Name, f.Name)
+		if f.Description != "" {
+			fmt.Fprintf(&b, "\n%s\n", f.Description)
+		}
+		if len(f.Params) == 0 {
+			continue
+		}
+		b.WriteString("\n| Param | Description | Default |\n|-------|-------------|---------|\n")
+		for _, p := range f.Params {
+			fmt.Fprintf(&b, "| `%s` | %s | %s |\n", mdEscape(p.Name), mdEscape(p.Description), mdEscape(p.Default))
+		}
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// htmlTemplate renders a Doc as HTML.
+var htmlTemplate = template.Must(template.New("doc").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>Pipelines</title>
+</head>
+<body>
+<h1>Pipelines</h1>
+<h2>Chains</h2>
+{{- range .Chains}}
+<h3 id="chain-{{.Name}}">{{.Name}}</h3>
+<pre class="mermaid">
+{{.Diagram}}</pre>
+{{- if .Links}}
+<table>
+<tr><th>#</th><th>Filter</th><th>Params</th></tr>
+{{- range $i, $l := .Links}}
+<tr><td>{{$i}}</td><td>{{if $l.Chain}}chain <a href="#chain-{{$l.Chain}}">{{$l.Chain}}</a>{{else if $l.Registered}}<a href="#filter-{{$l.Filter}}">{{$l.Filter}}</a>{{else}}{{$l.Filter}} (unregistered){{end}}</td><td>{{range $l.Params}}<code>{{.}}</code><br>{{end}}</td></tr>
+{{- end}}
+</table>
+{{- else}}
+<p>The chain passes its source through unchanged.</p>
+{{- end}}
+{{- else}}
+<p>No chains are configured.</p>
+{{- end}}
+<h2>Filters</h2>
+{{- range .Filters}}
+<h3 id="filter-{{.Name}}">{{.Name}}</h3>
+{{- if .Description}}
+<p>{{.Description}}</p>
+{{- end}}
+{{- if .Params}}
+<table>
+<tr><th>Param</th><th>Description</th><th>Default</th></tr>
+{{- range .Params}}
+<tr><td><code>{{.Name}}</code></td><td>{{.Description}}</td><td>{{.Default}}</td></tr>
+{{- end}}
+</table>
+{{- end}}
+{{- end}}
+</body>
+</html>
+`))
+
+// HTML writes the documentation to w as an HTML document. Diagrams are written
+// as Mermaid source within pre elements of the "mermaid" class, which are
+// rendered when the page includes the Mermaid script.
+func (d *Doc) HTML(w io.Writer) error {
+	return htmlTemplate.Execute(w, d)
+}
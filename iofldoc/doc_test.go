@@ -0,0 +1,126 @@
+package iofldoc_test
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/iofldoc"
+)
+
+func nopFilter(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+	return iofl.NopFilter(r), nil
+}
+
+// docSet returns a ChainSet with documented filters and a few chains.
+func docSet(t *testing.T) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet(
+		iofl.FilterDef{Name: "upper", New: nopFilter, Description: "Upper-cases | text.", Params: []iofl.ParamDef{
+			{Name: "locale", Description: "The locale\nof the text.", Default: "en"},
+			{Name: "strict", Description: "Whether to fail on <invalid> input."},
+		}},
+		iofl.FilterDef{Name: "bare", New: nopFilter},
+	)
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"main":  {{Filter: "upper", Params: iofl.Params{"strict": true, "locale": "tr"}}, {Chain: "tail"}},
+		"tail":  {{Filter: "bare"}},
+		"empty": {},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestNew(t *testing.T) {
+	doc := iofldoc.New(docSet(t))
+	var names []string
+	for _, f := range doc.Filters {
+		names = append(names, f.Name)
+	}
+	if want := []string{"bare", "upper"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("got filters %v, want %v", names, want)
+	}
+	if len(doc.Chains) != 3 {
+		t.Fatalf("got %d chains, want 3", len(doc.Chains))
+	}
+	main := doc.Chains[1]
+	if main.Name != "main" {
+		t.Fatalf("got chain %q, want main", main.Name)
+	}
+	want := []iofldoc.LinkDoc{
+		{Filter: "upper", Registered: true, Params: []string{`locale: "tr"`, "strict: true"}},
+		{Chain: "tail", Params: []string{}},
+	}
+	if !reflect.DeepEqual(main.Links, want) {
+		t.Errorf("got links %+v, want %+v", main.Links, want)
+	}
+	if !doc.Chains[2].Links[0].Registered {
+		t.Error("filter not reported as registered")
+	}
+}
+
+func TestDiagram(t *testing.T) {
+	got := iofldoc.Diagram(iofl.Chain{{Filter: `a"b`}, {Chain: "other"}})
+	want := "flowchart LR\n    src([source]) --> l0[\"a#quot;b\"] --> l1[[\"other\"]] --> out([output])\n"
+	if got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+	if got, want := iofldoc.Diagram(nil), "flowchart LR\n    src([source]) --> out([output])\n"; got != want {
+		t.Errorf("got %q, want %q", got, want)
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	var buf bytes.Buffer
+	if err := iofldoc.New(docSet(t)).Markdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		"### empty\n",
+		"The chain passes its source through unchanged.",
+		"| 0 | [upper](#filter-upper) | `locale: \"tr\"`<br>`strict: true` |",
+		"| 1 | chain [tail](#chain-tail) |  |",
+		"| 0 | [bare](#filter-bare) |  |",
+		"```mermaid\nflowchart LR\n",
+		"<a id=\"filter-upper\"></a>\n### upper\n\nUpper-cases | text.\n",
+		"| `locale` | The locale of the text. | en |",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	buf.Reset()
+	if err := iofldoc.New(iofl.NewChainSet()).Markdown(&buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "No chains are configured.") {
+		t.Errorf("got %s", buf.String())
+	}
+}
+
+func TestHTML(t *testing.T) {
+	var buf bytes.Buffer
+	if err := iofldoc.New(docSet(t)).HTML(&buf); err != nil {
+		t.Fatal(err)
+	}
+	out := buf.String()
+	for _, want := range []string{
+		`<h3 id="chain-main">main</h3>`,
+		`<a href="#filter-upper">upper</a>`,
+		`chain <a href="#chain-tail">tail</a>`,
+		`<a href="#filter-bare">bare</a>`,
+		`<pre class="mermaid">`,
+		`Whether to fail on &lt;invalid&gt; input.`,
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+}
@@ -0,0 +1,247 @@
+package ioflhttp
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"path"
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/ioflmetrics"
+)
+
+// Admin is an http.Handler that exposes a ChainSet for the operation of a
+// service that embeds it. Responses are encoded as JSON. The following paths
+// are served, relative to the path at which the handler is mounted, such as
+// with http.StripPrefix:
+//
+//	GET  /chains:   The name and links of each configured chain.
+//	GET  /filters:  The catalog of registered filters.
+//	GET  /config:   The effective configuration.
+//	POST /validate: Validates the configuration in the request body, or the
+//	                effective configuration if the body is empty.
+//	POST /verify:   Runs the test vectors of the configuration.
+//	GET  /metrics:  Live metrics of each link of each chain.
+//
+// Validation and verification respond with an object whose "ok" field
+// indicates success, and whose "errors" field lists each failure.
+//
+// The handler performs no authentication, and should only be exposed to
+// operators.
+type Admin struct {
+	chains  *iofl.ChainSet
+	metrics *ioflmetrics.Registry
+
+	mu    sync.Mutex
+	links map[ioflmetrics.Link]*linkCounts
+}
+
+// linkCounts counts the instances of a link.
+type linkCounts struct {
+	constructed uint64
+	closed      uint64
+}
+
+// NewAdmin returns an Admin that exposes s. Hooks are registered with s to
+// count the instances of each link. If metrics is not nil, the latency of each
+// link recorded in metrics is included in the metrics served by the handler;
+// chains must be resolved with the ioflmetrics.Latency option for latencies to
+// be recorded.
+func NewAdmin(s *iofl.ChainSet, metrics *ioflmetrics.Registry) *Admin {
+	a := &Admin{chains: s, metrics: metrics, links: map[ioflmetrics.Link]*linkCounts{}}
+	s.OnConstruct(func(chain string, index int, def iofl.LinkDef, f iofl.Filter) {
+		atomic.AddUint64(&a.counts(chain, index, def).constructed, 1)
+	})
+	s.OnClose(func(chain string, index int, def iofl.LinkDef, f iofl.Filter) {
+		atomic.AddUint64(&a.counts(chain, index, def).closed, 1)
+	})
+	return a
+}
+
+// counts returns the counts of a link, creating them if needed.
+func (a *Admin) counts(chain string, index int, def iofl.LinkDef) *linkCounts {
+	link := ioflmetrics.Link{Chain: chain, Index: index, Filter: def.Filter}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	c, ok := a.links[link]
+	if !ok {
+		c = &linkCounts{}
+		a.links[link] = c
+	}
+	return c
+}
+
+// ServeHTTP implements http.Handler.
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	method := http.MethodGet
+	var serve func(w http.ResponseWriter, r *http.Request)
+	switch path.Clean("/" + r.URL.Path) {
+	case "/chains":
+		serve = a.serveChains
+	case "/filters":
+		serve = a.serveFilters
+	case "/config":
+		serve = a.serveConfig
+	case "/validate":
+		method, serve = http.MethodPost, a.serveValidate
+	case "/verify":
+		method, serve = http.MethodPost, a.serveVerify
+	case "/metrics":
+		serve = a.serveMetrics
+	default:
+		http.NotFound(w, r)
+		return
+	}
+	if r.Method != method {
+		w.Header().Set("Allow", method)
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	serve(w, r)
+}
+
+// writeJSON writes v to w, encoded as JSON.
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	e := json.NewEncoder(w)
+	e.SetIndent("", "\t")
+	e.Encode(v)
+}
+
+// adminChain describes a chain served by Admin.
+type adminChain struct {
+	Name  string     `json:"name"`
+	Links iofl.Chain `json:"links"`
+}
+
+func (a *Admin) serveChains(w http.ResponseWriter, r *http.Request) {
+	config := a.chains.Config()
+	chains := make([]adminChain, 0, len(config.Chains))
+	for name, chain := range config.Chains {
+		chains = append(chains, adminChain{Name: name, Links: chain})
+	}
+	sort.Slice(chains, func(i, j int) bool { return chains[i].Name < chains[j].Name })
+	writeJSON(w, chains)
+}
+
+func (a *Admin) serveFilters(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.chains.Catalog())
+}
+
+func (a *Admin) serveConfig(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, a.chains.Config())
+}
+
+// adminResult is the result of validation or verification.
+type adminResult struct {
+	OK     bool     `json:"ok"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// writeResult writes the result corresponding to err.
+func writeResult(w http.ResponseWriter, err error) {
+	result := adminResult{OK: err == nil}
+	var errs iofl.Errors
+	if errors.As(err, &errs) {
+		for _, err := range errs {
+			result.Errors = append(result.Errors, err.Error())
+		}
+	} else if err != nil {
+		result.Errors = []string{err.Error()}
+	}
+	writeJSON(w, result)
+}
+
+func (a *Admin) serveValidate(w http.ResponseWriter, r *http.Request) {
+	config, err := iofl.LoadConfig(r.Body, "json")
+	if err != nil {
+		var syntax *json.SyntaxError
+		if !errors.As(err, &syntax) || syntax.Offset > 0 {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		// Empty body.
+		config = a.chains.Config()
+	}
+	writeResult(w, a.chains.Validate(config))
+}
+
+func (a *Admin) serveVerify(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, a.chains.Verify())
+}
+
+// adminLink contains the metrics of a link.
+type adminLink struct {
+	Chain  string `json:"chain"`
+	Index  int    `json:"index"`
+	Filter string `json:"filter"`
+	// Constructed is the number of instances of the link that have been
+	// constructed.
+	Constructed uint64 `json:"constructed"`
+	// Open is the number of instances of the link that have not been closed.
+	Open uint64 `json:"open"`
+	// Reads is the number of Reads recorded by the latency Histogram of the
+	// link.
+	Reads uint64 `json:"reads,omitempty"`
+	// The mean, median, and 99th percentile of read latency.
+	Mean string `json:"mean,omitempty"`
+	P50  string `json:"p50,omitempty"`
+	P99  string `json:"p99,omitempty"`
+}
+
+func (a *Admin) serveMetrics(w http.ResponseWriter, r *http.Request) {
+	links := map[ioflmetrics.Link]*adminLink{}
+	get := func(link ioflmetrics.Link) *adminLink {
+		l, ok := links[link]
+		if !ok {
+			l = &adminLink{Chain: link.Chain, Index: link.Index, Filter: link.Filter}
+			links[link] = l
+		}
+		return l
+	}
+	a.mu.Lock()
+	for link, c := range a.links {
+		l := get(link)
+		l.Constructed = atomic.LoadUint64(&c.constructed)
+		l.Open = l.Constructed - atomic.LoadUint64(&c.closed)
+	}
+	a.mu.Unlock()
+	if a.metrics != nil {
+		for _, link := range a.metrics.Links() {
+			s := a.metrics.Histogram(link).Snapshot()
+			l := get(link)
+			l.Reads = s.Count
+			if s.Count > 0 {
+				l.Mean = s.Mean().String()
+				l.P50 = quantile(s, 0.5)
+				l.P99 = quantile(s, 0.99)
+			}
+		}
+	}
+	list := make([]*adminLink, 0, len(links))
+	for _, l := range links {
+		list = append(list, l)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if list[i].Chain != list[j].Chain {
+			return list[i].Chain < list[j].Chain
+		}
+		if list[i].Index != list[j].Index {
+			return list[i].Index < list[j].Index
+		}
+		return list[i].Filter < list[j].Filter
+	})
+	writeJSON(w, list)
+}
+
+// quantile formats the q-quantile of s.
+func quantile(s ioflmetrics.Snapshot, q float64) string {
+	d := s.Quantile(q)
+	if d < 0 {
+		return "+Inf"
+	}
+	return d.String()
+}
@@ -0,0 +1,222 @@
+package ioflhttp_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/ioflhttp"
+	"github.com/anaminus/iofl/ioflmetrics"
+)
+
+func newAdmin(t *testing.T, reg *ioflmetrics.Registry) (*iofl.ChainSet, *ioflhttp.Admin) {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+			"pair":  {{Filter: "identity"}, {Filter: "translate"}},
+		},
+		Tests: map[string][]iofl.TestVector{
+			"upper": {{Input: "a", SHA256: "00"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, ioflhttp.NewAdmin(s, reg)
+}
+
+// decode decodes the JSON body of rec into v.
+func decode(t *testing.T, rec *httptest.ResponseRecorder, v interface{}) {
+	t.Helper()
+	if rec.Code != http.StatusOK {
+		t.Fatalf("got status %d: %s", rec.Code, rec.Body)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "application/json" {
+		t.Errorf("got content type %q", ct)
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// post sends a POST request with the given body to h.
+func post(h http.Handler, path, body string) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	return rec
+}
+
+// adminResult is the result of validation or verification.
+type adminResult struct {
+	OK     bool     `json:"ok"`
+	Errors []string `json:"errors"`
+}
+
+func TestAdmin(t *testing.T) {
+	_, h := newAdmin(t, nil)
+
+	var chains []struct {
+		Name  string     `json:"name"`
+		Links iofl.Chain `json:"links"`
+	}
+	decode(t, get(h, http.MethodGet, "/chains", nil), &chains)
+	if len(chains) != 2 || chains[0].Name != "pair" || chains[1].Name != "upper" ||
+		len(chains[0].Links) != 2 || chains[1].Links[0].Params.GetString("preset") != "upper" {
+		t.Errorf("got chains %+v", chains)
+	}
+
+	rec := get(h, http.MethodGet, "/filters", nil)
+	if rec.Code != http.StatusOK || !strings.Contains(rec.Body.String(), `"translate"`) {
+		t.Errorf("filters: got %d: %s", rec.Code, rec.Body)
+	}
+
+	var config iofl.Config
+	decode(t, get(h, http.MethodGet, "/./config", nil), &config)
+	if len(config.Chains) != 2 || len(config.Tests["upper"]) != 1 {
+		t.Errorf("got config %+v", config)
+	}
+
+	for _, tt := range []struct {
+		method, path string
+		status       int
+		allow        string
+	}{
+		{http.MethodGet, "/missing", http.StatusNotFound, ""},
+		{http.MethodPost, "/chains", http.StatusMethodNotAllowed, http.MethodGet},
+		{http.MethodGet, "/validate", http.StatusMethodNotAllowed, http.MethodPost},
+		{http.MethodGet, "/verify", http.StatusMethodNotAllowed, http.MethodPost},
+	} {
+		rec := get(h, tt.method, tt.path, nil)
+		if rec.Code != tt.status || rec.Header().Get("Allow") != tt.allow {
+			t.Errorf("%s %s: got %d, allow %q", tt.method, tt.path, rec.Code, rec.Header().Get("Allow"))
+		}
+	}
+}
+
+func TestAdminValidate(t *testing.T) {
+	_, h := newAdmin(t, nil)
+	for _, tt := range []struct {
+		body   string
+		ok     bool
+		errors int
+	}{
+		{"", true, 0},
+		{`{"chains": {"c": ["identity"]}}`, true, 0},
+		{`{"chains": {"c": ["missing"], "d": [{"chain": "e"}]}}`, false, 2},
+	} {
+		var result adminResult
+		decode(t, post(h, "/validate", tt.body), &result)
+		if result.OK != tt.ok || len(result.Errors) != tt.errors {
+			t.Errorf("%q: got %+v", tt.body, result)
+		}
+	}
+	if rec := post(h, "/validate", `{"chains": `); rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed: got %d", rec.Code)
+	}
+
+	var result adminResult
+	decode(t, post(h, "/verify", ""), &result)
+	if result.OK || len(result.Errors) != 1 || !strings.Contains(result.Errors[0], "upper") {
+		t.Errorf("verify: got %+v", result)
+	}
+}
+
+// adminLink contains the metrics of a link.
+type adminLink struct {
+	Chain       string `json:"chain"`
+	Index       int    `json:"index"`
+	Filter      string `json:"filter"`
+	Constructed uint64 `json:"constructed"`
+	Open        uint64 `json:"open"`
+	Reads       uint64 `json:"reads"`
+	Mean        string `json:"mean"`
+	P99         string `json:"p99"`
+}
+
+func TestAdminMetrics(t *testing.T) {
+	reg := ioflmetrics.NewRegistry()
+	s, h := newAdmin(t, reg)
+	var links []adminLink
+	decode(t, get(h, http.MethodGet, "/metrics", nil), &links)
+	if len(links) != 0 {
+		t.Errorf("got metrics before resolving: %+v", links)
+	}
+
+	open, err := s.Resolve("pair", ioutil.NopCloser(strings.NewReader("x")), ioflmetrics.Latency(reg))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	for i := 0; i < 2; i++ {
+		f, err := s.Resolve("pair", ioutil.NopCloser(strings.NewReader("abc")), ioflmetrics.Latency(reg))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ioutil.ReadAll(f); err != nil {
+			t.Fatal(err)
+		}
+		f.Close()
+	}
+	decode(t, get(h, http.MethodGet, "/metrics", nil), &links)
+	if len(links) != 2 {
+		t.Fatalf("got metrics %+v", links)
+	}
+	for i, l := range links {
+		if l.Chain != "pair" || l.Index != i || l.Constructed != 3 || l.Open != 1 {
+			t.Errorf("link %d: got %+v", i, l)
+		}
+		if l.Reads == 0 || l.Mean == "" || l.P99 == "" {
+			t.Errorf("link %d: no latency: %+v", i, l)
+		}
+	}
+	if links[0].Filter != "identity" || links[1].Filter != "translate" {
+		t.Errorf("got filters %q, %q", links[0].Filter, links[1].Filter)
+	}
+}
+
+func TestAdminConcurrent(t *testing.T) {
+	reg := ioflmetrics.NewRegistry()
+	s, h := newAdmin(t, reg)
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				f, err := s.Resolve("upper", ioutil.NopCloser(bytes.NewReader([]byte("abc"))), ioflmetrics.Latency(reg))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				ioutil.ReadAll(f)
+				f.Close()
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if rec := get(h, http.MethodGet, "/metrics", nil); rec.Code != http.StatusOK {
+					t.Errorf("got status %d", rec.Code)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	var links []adminLink
+	decode(t, get(h, http.MethodGet, "/metrics", nil), &links)
+	if len(links) != 1 || links[0].Constructed != 200 || links[0].Open != 0 {
+		t.Errorf("got metrics %+v", links)
+	}
+}
@@ -0,0 +1,157 @@
+// The ioflhttp package provides HTTP integration for iofl chains.
+package ioflhttp
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"mime"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/anaminus/iofl"
+)
+
+// FileServer is an http.Handler that serves files from a file system, passing
+// the content of each file through a chain selected by the file's extension.
+type FileServer struct {
+	// FS is the file system from which files are served.
+	FS fs.FS
+	// Chains is the ChainSet from which chains are resolved.
+	Chains *iofl.ChainSet
+	// Extensions maps a file extension, including the leading dot, to the
+	// name of a chain. Files with an unmapped extension are served unchanged.
+	Extensions map[string]string
+	// TrimExtension causes the content type of a file served through a chain
+	// to be determined from the file name with the mapped extension removed.
+	// For example, "data.json.gz" is served as "data.json". This is useful
+	// when a chain decodes the file.
+	TrimExtension bool
+}
+
+// ServeHTTP implements http.Handler.
+//
+// When a file is served unchanged, range requests and conditional requests
+// are supported. When a file is served through a chain that preserves size, as
+// reported by iofl.PreservesSize, the Content-Length header is set to the size
+// of the file, and a strong ETag is produced. Otherwise, the content length is
+// not known in advance, and a weak ETag is produced. The ETag incorporates the
+// name of the chain, the size of the file, and its modification time.
+func (h *FileServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	name := strings.TrimPrefix(path.Clean("/"+r.URL.Path), "/")
+	if name == "" {
+		name = "."
+	}
+	file, err := h.FS.Open(name)
+	if err != nil {
+		serveError(w, err)
+		return
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		serveError(w, err)
+		return
+	}
+	if stat.IsDir() {
+		file.Close()
+		http.NotFound(w, r)
+		return
+	}
+
+	ext := path.Ext(name)
+	chain, ok := h.Extensions[ext]
+	if !ok {
+		defer file.Close()
+		w.Header().Set("ETag", etag("", stat, false))
+		if rs, ok := file.(io.ReadSeeker); ok {
+			http.ServeContent(w, r, name, stat.ModTime(), rs)
+			return
+		}
+		h.serve(w, r, name, stat, file, true)
+		return
+	}
+
+	f, err := h.Chains.Resolve(chain, file)
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	defer f.Close()
+	preserves := iofl.PreservesSize(f)
+	w.Header().Set("ETag", etag(chain, stat, !preserves))
+	if h.TrimExtension {
+		name = strings.TrimSuffix(name, ext)
+	}
+	h.serve(w, r, name, stat, f, preserves)
+}
+
+// serve writes the content of r to w. If sized is true, the Content-Length
+// header is set to the size of the file.
+func (h *FileServer) serve(w http.ResponseWriter, r *http.Request, name string, stat fs.FileInfo, content io.Reader, sized bool) {
+	if match := r.Header.Get("If-None-Match"); match != "" && etagMatch(match, w.Header().Get("ETag")) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	ctype := mime.TypeByExtension(path.Ext(name))
+	if ctype == "" {
+		ctype = "application/octet-stream"
+	}
+	w.Header().Set("Content-Type", ctype)
+	if !stat.ModTime().IsZero() {
+		w.Header().Set("Last-Modified", stat.ModTime().UTC().Format(http.TimeFormat))
+	}
+	if sized {
+		w.Header().Set("Content-Length", strconv.FormatInt(stat.Size(), 10))
+	}
+	w.WriteHeader(http.StatusOK)
+	if r.Method != http.MethodHead {
+		io.Copy(w, content)
+	}
+}
+
+// etag returns an entity tag for a file served through chain.
+func etag(chain string, stat fs.FileInfo, weak bool) string {
+	tag := fmt.Sprintf("%x-%x", stat.Size(), uint64(stat.ModTime().UnixNano()))
+	if chain != "" {
+		tag = chain + "-" + tag
+	}
+	tag = strconv.Quote(tag)
+	if weak {
+		return "W/" + tag
+	}
+	return tag
+}
+
+// etagMatch returns whether the If-None-Match header value matches tag, using
+// weak comparison.
+func etagMatch(header, tag string) bool {
+	tag = strings.TrimPrefix(tag, "W/")
+	for _, t := range strings.Split(header, ",") {
+		t = strings.TrimSpace(t)
+		if t == "*" || strings.TrimPrefix(t, "W/") == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// serveError writes an HTTP error corresponding to a file system error.
+func serveError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		http.Error(w, http.StatusText(http.StatusNotFound), http.StatusNotFound)
+	case errors.Is(err, fs.ErrPermission):
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+	default:
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+	}
+}
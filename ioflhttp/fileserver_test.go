@@ -0,0 +1,146 @@
+package ioflhttp_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/ioflhttp"
+)
+
+func newFileServer(t *testing.T) *ioflhttp.FileServer {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"strip": {{Filter: "translate", Params: iofl.Params{"delete": "-"}}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	mod := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	return &ioflhttp.FileServer{
+		FS: fstest.MapFS{
+			"plain.txt":      {Data: []byte("hello world"), ModTime: mod},
+			"shout.up":       {Data: []byte("hello"), ModTime: mod},
+			"data.json.up":   {Data: []byte(`{"a":1}`), ModTime: mod},
+			"dashes.strip":   {Data: []byte("a-b-c"), ModTime: mod},
+			"dir/nested.txt": {Data: []byte("nested"), ModTime: mod},
+		},
+		Chains: s,
+		Extensions: map[string]string{
+			".up":    "upper",
+			".strip": "strip",
+		},
+	}
+}
+
+func get(h http.Handler, method, path string, header http.Header) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, path, nil)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestFileServer(t *testing.T) {
+	h := newFileServer(t)
+	tests := []struct {
+		path   string
+		status int
+		body   string
+		length string
+		weak   bool
+	}{
+		{"/plain.txt", 200, "hello world", "11", false},
+		{"/dir/nested.txt", 200, "nested", "6", false},
+		{"/shout.up", 200, "HELLO", "5", false},
+		{"/dashes.strip", 200, "abc", "", true},
+		{"/missing.txt", 404, "", "", false},
+		{"/dir", 404, "", "", false},
+		{"/../plain.txt", 200, "hello world", "11", false},
+	}
+	for _, tt := range tests {
+		rec := get(h, "GET", tt.path, nil)
+		if rec.Code != tt.status {
+			t.Errorf("%s: got status %d, want %d", tt.path, rec.Code, tt.status)
+			continue
+		}
+		if tt.status != 200 {
+			continue
+		}
+		if got := rec.Body.String(); got != tt.body {
+			t.Errorf("%s: got body %q, want %q", tt.path, got, tt.body)
+		}
+		if got := rec.Header().Get("Content-Length"); got != tt.length {
+			t.Errorf("%s: got Content-Length %q, want %q", tt.path, got, tt.length)
+		}
+		etag := rec.Header().Get("ETag")
+		if etag == "" || strings.HasPrefix(etag, "W/") != tt.weak {
+			t.Errorf("%s: got ETag %q, want weak %v", tt.path, etag, tt.weak)
+		}
+	}
+}
+
+func TestFileServerETag(t *testing.T) {
+	h := newFileServer(t)
+	for _, path := range []string{"/plain.txt", "/shout.up", "/dashes.strip"} {
+		etag := get(h, "GET", path, nil).Header().Get("ETag")
+		rec := get(h, "GET", path, http.Header{"If-None-Match": {etag}})
+		if rec.Code != http.StatusNotModified {
+			t.Errorf("%s: got status %d, want 304", path, rec.Code)
+		}
+		if rec.Body.Len() != 0 {
+			t.Errorf("%s: unexpected body", path)
+		}
+	}
+
+	// The chain is part of the tag.
+	plain := get(h, "GET", "/plain.txt", nil).Header().Get("ETag")
+	up := get(h, "GET", "/shout.up", nil).Header().Get("ETag")
+	if !strings.Contains(up, "upper-") || strings.Contains(plain, "upper-") {
+		t.Errorf("unexpected tags %q, %q", plain, up)
+	}
+}
+
+func TestFileServerRange(t *testing.T) {
+	h := newFileServer(t)
+	rec := get(h, "GET", "/plain.txt", http.Header{"Range": {"bytes=6-"}})
+	if rec.Code != http.StatusPartialContent || rec.Body.String() != "world" {
+		t.Errorf("got %d %q", rec.Code, rec.Body.String())
+	}
+}
+
+func TestFileServerMethod(t *testing.T) {
+	h := newFileServer(t)
+	rec := get(h, "POST", "/plain.txt", nil)
+	if rec.Code != http.StatusMethodNotAllowed || rec.Header().Get("Allow") != "GET, HEAD" {
+		t.Errorf("got %d, Allow %q", rec.Code, rec.Header().Get("Allow"))
+	}
+
+	rec = get(h, "HEAD", "/shout.up", nil)
+	if rec.Code != 200 || rec.Body.Len() != 0 || rec.Header().Get("Content-Length") != "5" {
+		t.Errorf("HEAD: got %d, %q, length %q", rec.Code, rec.Body.String(), rec.Header().Get("Content-Length"))
+	}
+}
+
+func TestFileServerTrimExtension(t *testing.T) {
+	h := newFileServer(t)
+	if got := get(h, "GET", "/data.json.up", nil).Header().Get("Content-Type"); strings.HasPrefix(got, "application/json") {
+		t.Errorf("untrimmed: got %q", got)
+	}
+	h.TrimExtension = true
+	if got := get(h, "GET", "/data.json.up", nil).Header().Get("Content-Type"); !strings.HasPrefix(got, "application/json") {
+		t.Errorf("trimmed: got %q, want application/json", got)
+	}
+}
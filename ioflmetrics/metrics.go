@@ -0,0 +1,186 @@
+// The ioflmetrics package collects metrics from iofl chains.
+package ioflmetrics
+
+import (
+	"io"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// numBuckets is the number of bounded buckets of a Histogram. Bucket i counts
+// durations up to 1µs<<i, so the largest bound is about 67 seconds.
+const numBuckets = 27
+
+// Histogram records a distribution of durations in exponentially sized
+// buckets. A Histogram is safe for concurrent use.
+type Histogram struct {
+	// counts[numBuckets] counts durations beyond the largest bound.
+	counts [numBuckets + 1]uint64
+	count  uint64
+	sum    int64
+}
+
+// Observe records the duration d.
+func (h *Histogram) Observe(d time.Duration) {
+	i := 0
+	for i < numBuckets && d > time.Microsecond<<i {
+		i++
+	}
+	atomic.AddUint64(&h.counts[i], 1)
+	atomic.AddUint64(&h.count, 1)
+	atomic.AddInt64(&h.sum, int64(d))
+}
+
+// Bucket is a bucket of a Snapshot.
+type Bucket struct {
+	// UpperBound is the largest duration counted by the bucket. The last
+	// bucket is unbounded, and has an UpperBound of 0.
+	UpperBound time.Duration
+	// Count is the number of durations counted by the bucket.
+	Count uint64
+}
+
+// Snapshot is the state of a Histogram at a point in time.
+type Snapshot struct {
+	// Buckets contains the count of each bucket, in order of bound.
+	Buckets []Bucket
+	// Count is the total number of durations recorded.
+	Count uint64
+	// Sum is the total of the durations recorded.
+	Sum time.Duration
+}
+
+// Snapshot returns the current state of the Histogram.
+func (h *Histogram) Snapshot() Snapshot {
+	s := Snapshot{
+		Buckets: make([]Bucket, numBuckets+1),
+		Count:   atomic.LoadUint64(&h.count),
+		Sum:     time.Duration(atomic.LoadInt64(&h.sum)),
+	}
+	for i := range s.Buckets {
+		if i < numBuckets {
+			s.Buckets[i].UpperBound = time.Microsecond << i
+		}
+		s.Buckets[i].Count = atomic.LoadUint64(&h.counts[i])
+	}
+	return s
+}
+
+// Mean returns the mean of the recorded durations.
+func (s Snapshot) Mean() time.Duration {
+	if s.Count == 0 {
+		return 0
+	}
+	return s.Sum / time.Duration(s.Count)
+}
+
+// Quantile returns an upper bound of the q-quantile of the recorded durations,
+// where q is between 0 and 1. Returns the bound of the bucket containing the
+// quantile, or -1 if the quantile lies beyond the largest bound.
+func (s Snapshot) Quantile(q float64) time.Duration {
+	var total uint64
+	for _, b := range s.Buckets {
+		total += b.Count
+	}
+	if total == 0 {
+		return 0
+	}
+	rank := uint64(q*float64(total) + 0.5)
+	if rank < 1 {
+		rank = 1
+	}
+	var n uint64
+	for _, b := range s.Buckets {
+		n += b.Count
+		if n >= rank {
+			if b.UpperBound == 0 {
+				return -1
+			}
+			return b.UpperBound
+		}
+	}
+	return -1
+}
+
+// Link identifies a link of a chain.
+type Link struct {
+	Chain  string
+	Index  int
+	Filter string
+}
+
+// Registry holds a latency Histogram for each link of the chains it observes.
+// A Registry is safe for concurrent use.
+type Registry struct {
+	mu    sync.Mutex
+	hists map[Link]*Histogram
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{hists: map[Link]*Histogram{}}
+}
+
+// Histogram returns the Histogram of the given link, creating it if needed.
+func (r *Registry) Histogram(link Link) *Histogram {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	h, ok := r.hists[link]
+	if !ok {
+		h = &Histogram{}
+		r.hists[link] = h
+	}
+	return h
+}
+
+// Links returns the links that have a Histogram, ordered by chain and index.
+func (r *Registry) Links() []Link {
+	r.mu.Lock()
+	links := make([]Link, 0, len(r.hists))
+	for link := range r.hists {
+		links = append(links, link)
+	}
+	r.mu.Unlock()
+	sort.Slice(links, func(i, j int) bool {
+		if links[i].Chain != links[j].Chain {
+			return links[i].Chain < links[j].Chain
+		}
+		if links[i].Index != links[j].Index {
+			return links[i].Index < links[j].Index
+		}
+		return links[i].Filter < links[j].Filter
+	})
+	return links
+}
+
+// Latency returns an Option that records the duration of each Read of each
+// link of a chain in the Histogram of the link within r. The duration of a
+// Read includes the time spent reading from the link's source.
+func Latency(r *Registry) iofl.Option {
+	return iofl.Decorate(func(link iofl.Link, f iofl.Filter) iofl.Filter {
+		return &latencyFilter{
+			f: f,
+			h: r.Histogram(Link{Chain: link.Chain, Index: link.Index, Filter: link.Def.Filter}),
+		}
+	})
+}
+
+// latencyFilter records the duration of each Read of a Filter.
+type latencyFilter struct {
+	f iofl.Filter
+	h *Histogram
+}
+
+func (l *latencyFilter) Read(p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = l.f.Read(p)
+	l.h.Observe(time.Since(start))
+	return n, err
+}
+
+func (l *latencyFilter) Close() error          { return l.f.Close() }
+func (l *latencyFilter) Source() io.ReadCloser { return l.f }
@@ -0,0 +1,117 @@
+package ioflmetrics_test
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/ioflmetrics"
+)
+
+func TestHistogram(t *testing.T) {
+	var h ioflmetrics.Histogram
+	for _, d := range []time.Duration{
+		0,
+		time.Microsecond,
+		3 * time.Microsecond,
+		time.Millisecond,
+		time.Hour,
+	} {
+		h.Observe(d)
+	}
+	s := h.Snapshot()
+	if s.Count != 5 {
+		t.Errorf("got count %d, want 5", s.Count)
+	}
+	if want := time.Hour + time.Millisecond + 4*time.Microsecond; s.Sum != want {
+		t.Errorf("got sum %v, want %v", s.Sum, want)
+	}
+	if got, want := s.Mean(), s.Sum/5; got != want {
+		t.Errorf("got mean %v, want %v", got, want)
+	}
+	counts := map[time.Duration]uint64{}
+	for _, b := range s.Buckets {
+		if b.Count > 0 {
+			counts[b.UpperBound] = b.Count
+		}
+	}
+	want := map[time.Duration]uint64{
+		time.Microsecond:        2,
+		4 * time.Microsecond:    1,
+		1024 * time.Microsecond: 1,
+		0:                       1,
+	}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("got buckets %v, want %v", counts, want)
+	}
+	for _, tt := range []struct {
+		q    float64
+		want time.Duration
+	}{
+		{0, time.Microsecond},
+		{0.4, time.Microsecond},
+		{0.6, 4 * time.Microsecond},
+		{0.8, 1024 * time.Microsecond},
+		{1, -1},
+	} {
+		if got := s.Quantile(tt.q); got != tt.want {
+			t.Errorf("Quantile(%v): got %v, want %v", tt.q, got, tt.want)
+		}
+	}
+
+	var empty ioflmetrics.Histogram
+	if s := empty.Snapshot(); s.Mean() != 0 || s.Quantile(0.5) != 0 {
+		t.Error("expected zero mean and quantile for empty histogram")
+	}
+}
+
+func TestLatency(t *testing.T) {
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"c": {{Filter: "translate"}, {Filter: "identity"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := ioflmetrics.NewRegistry()
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			f, err := s.Resolve("c", ioutil.NopCloser(strings.NewReader("abc")), ioflmetrics.Latency(r))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			ioutil.ReadAll(f)
+			f.Close()
+		}()
+	}
+	wg.Wait()
+
+	want := []ioflmetrics.Link{
+		{Chain: "c", Index: 0, Filter: "translate"},
+		{Chain: "c", Index: 1, Filter: "identity"},
+	}
+	if got := r.Links(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("got links %v, want %v", got, want)
+	}
+	for _, link := range want {
+		// Each instance reads at least the content and EOF.
+		if n := r.Histogram(link).Snapshot().Count; n < 8 {
+			t.Errorf("%v: got %d reads, want at least 8", link, n)
+		}
+	}
+	if r.Histogram(want[0]) != r.Histogram(want[0]) {
+		t.Error("Histogram returned different histograms for the same link")
+	}
+}
@@ -0,0 +1,160 @@
+// The ioflmq package adapts message queues, such as Kafka topics and NATS
+// subjects, to iofl chains. Each message is treated as a frame: a Source
+// produces the messages of a subscription as frames, and Forward publishes the
+// frames produced by a chain as messages.
+//
+// The package does not depend on any particular client. A client is adapted by
+// implementing Subscription and Publisher, or by using SubscriptionFunc and
+// PublisherFunc. For example, with nats.go:
+//
+//	sub, _ := nc.SubscribeSync("events.in")
+//	src := ioflmq.NewSource(ctx, ioflmq.SubscriptionFunc(func(ctx context.Context) ([]byte, error) {
+//		msg, err := sub.NextMsgWithContext(ctx)
+//		if err != nil {
+//			return nil, err
+//		}
+//		return msg.Data, nil
+//	}))
+//
+// And with kafka-go:
+//
+//	pub := ioflmq.PublisherFunc(func(ctx context.Context, msg []byte) error {
+//		return w.WriteMessages(ctx, kafka.Message{Value: msg})
+//	})
+package ioflmq
+
+import (
+	"context"
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Subscription receives messages from a topic or subject.
+type Subscription interface {
+	// Next blocks until the next message is received, returning its payload.
+	// Returns io.EOF when no further messages will be received.
+	Next(ctx context.Context) ([]byte, error)
+}
+
+// Publisher sends messages to a topic or subject.
+type Publisher interface {
+	// Publish sends msg. msg must not be retained after Publish returns.
+	Publish(ctx context.Context, msg []byte) error
+}
+
+// SubscriptionFunc implements Subscription with a function.
+type SubscriptionFunc func(ctx context.Context) ([]byte, error)
+
+// Next implements Subscription.
+func (f SubscriptionFunc) Next(ctx context.Context) ([]byte, error) {
+	return f(ctx)
+}
+
+// PublisherFunc implements Publisher with a function.
+type PublisherFunc func(ctx context.Context, msg []byte) error
+
+// Publish implements Publisher.
+func (f PublisherFunc) Publish(ctx context.Context, msg []byte) error {
+	return f(ctx, msg)
+}
+
+// Source is a Filter with no source that produces the messages of a
+// Subscription. It implements iofl.Framer, with each message being a frame.
+// When read as a stream of bytes, messages are concatenated.
+type Source struct {
+	ctx    context.Context
+	sub    Subscription
+	buf    []byte
+	closed bool
+}
+
+// NewSource returns a Source that receives messages from sub using ctx. If sub
+// implements io.Closer, it is closed when the Source is closed.
+func NewSource(ctx context.Context, sub Subscription) *Source {
+	return &Source{ctx: ctx, sub: sub}
+}
+
+// Source implements iofl.Filter, returning nil.
+func (s *Source) Source() io.ReadCloser {
+	return nil
+}
+
+// ReadFrame implements iofl.Framer, returning the next message.
+func (s *Source) ReadFrame() ([]byte, error) {
+	if s.closed {
+		return nil, iofl.Closed
+	}
+	return s.sub.Next(s.ctx)
+}
+
+// Read implements io.Reader.
+func (s *Source) Read(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, iofl.Closed
+	}
+	for len(s.buf) == 0 {
+		if s.buf, err = s.sub.Next(s.ctx); err != nil {
+			return 0, err
+		}
+	}
+	n = copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// Close implements io.Closer.
+func (s *Source) Close() error {
+	if s.closed {
+		return iofl.Closed
+	}
+	s.closed = true
+	if c, ok := s.sub.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
+// Forward publishes the content of r to pub, returning the number of messages
+// published. If r implements iofl.Framer, each frame is published as a
+// message. Otherwise, the entire content of r is published as one message.
+func Forward(ctx context.Context, pub Publisher, r io.Reader) (n int, err error) {
+	fr, ok := r.(iofl.Framer)
+	if !ok {
+		msg, err := io.ReadAll(r)
+		if err != nil {
+			return 0, err
+		}
+		return 1, pub.Publish(ctx, msg)
+	}
+	for {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		msg, err := fr.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if err := pub.Publish(ctx, msg); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Pipe receives messages from sub, passes them through the named chain of s,
+// and publishes the frames produced by the chain to pub, until sub is
+// exhausted or ctx is done. Returns the number of messages published. The chain
+// should produce frames by implementing iofl.Framer.
+func Pipe(ctx context.Context, s *iofl.ChainSet, chain string, sub Subscription, pub Publisher, opts ...iofl.Option) (n int, err error) {
+	src := NewSource(ctx, sub)
+	f, err := s.Resolve(chain, src, opts...)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	return Forward(ctx, pub, f)
+}
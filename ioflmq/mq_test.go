@@ -0,0 +1,144 @@
+package ioflmq_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/anaminus/iofl/ioflmq"
+)
+
+// sliceSub is a Subscription that receives a fixed list of messages.
+type sliceSub struct {
+	msgs   []string
+	closed bool
+}
+
+func (s *sliceSub) Next(ctx context.Context) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	if len(s.msgs) == 0 {
+		return nil, io.EOF
+	}
+	msg := s.msgs[0]
+	s.msgs = s.msgs[1:]
+	return []byte(msg), nil
+}
+
+func (s *sliceSub) Close() error {
+	s.closed = true
+	return nil
+}
+
+// collector is a Publisher that records each message.
+type collector struct {
+	msgs []string
+}
+
+func (c *collector) Publish(ctx context.Context, msg []byte) error {
+	c.msgs = append(c.msgs, string(msg))
+	return nil
+}
+
+func TestSource(t *testing.T) {
+	sub := &sliceSub{msgs: []string{"ab", "", "cd"}}
+	src := ioflmq.NewSource(context.Background(), sub)
+	if src.Source() != nil {
+		t.Error("expected nil source")
+	}
+	b, err := ioutil.ReadAll(src)
+	if err != nil || string(b) != "abcd" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if err := src.Close(); err != nil || !sub.closed {
+		t.Errorf("close: %v, closed %v", err, sub.closed)
+	}
+	if _, err := src.ReadFrame(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
+
+func TestSourceFrames(t *testing.T) {
+	src := ioflmq.NewSource(context.Background(), &sliceSub{msgs: []string{"ab", "", "cd"}})
+	var frames []string
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, string(frame))
+	}
+	if want := []string{"ab", "", "cd"}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("got %q, want %q", frames, want)
+	}
+}
+
+func TestForward(t *testing.T) {
+	var c collector
+	n, err := ioflmq.Forward(context.Background(), &c, strings.NewReader("whole"))
+	if err != nil || n != 1 || !reflect.DeepEqual(c.msgs, []string{"whole"}) {
+		t.Errorf("reader: got %d, %q, %v", n, c.msgs, err)
+	}
+
+	c = collector{}
+	src := ioflmq.NewSource(context.Background(), &sliceSub{msgs: []string{"a", "b"}})
+	n, err = ioflmq.Forward(context.Background(), &c, src)
+	if err != nil || n != 2 || !reflect.DeepEqual(c.msgs, []string{"a", "b"}) {
+		t.Errorf("framer: got %d, %q, %v", n, c.msgs, err)
+	}
+
+	errPublish := errors.New("publish")
+	pub := ioflmq.PublisherFunc(func(ctx context.Context, msg []byte) error { return errPublish })
+	src = ioflmq.NewSource(context.Background(), &sliceSub{msgs: []string{"a"}})
+	if _, err := ioflmq.Forward(context.Background(), pub, src); err != errPublish {
+		t.Errorf("got %v, want publish error", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	src = ioflmq.NewSource(context.Background(), &sliceSub{msgs: []string{"a"}})
+	if _, err := ioflmq.Forward(ctx, &c, src); err != context.Canceled {
+		t.Errorf("got %v, want context.Canceled", err)
+	}
+}
+
+func TestPipe(t *testing.T) {
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		// Delimit each message, then split the stream back into messages.
+		"reframe": {
+			{Filter: "protodelim", Params: iofl.Params{"mode": "join"}},
+			{Filter: "protodelim", Params: iofl.Params{"policy": "skip"}},
+		},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	msgs := []string{"\x08\x01", "\x00bad", "\x12\x02hi"}
+	sub := ioflmq.SubscriptionFunc((&sliceSub{msgs: msgs}).Next)
+	var c collector
+	n, err := ioflmq.Pipe(context.Background(), s, "reframe", sub, &c)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"\x08\x01", "\x12\x02hi"}; n != 2 || !reflect.DeepEqual(c.msgs, want) {
+		t.Errorf("got %d, %q, want %q", n, c.msgs, want)
+	}
+
+	if _, err := ioflmq.Pipe(context.Background(), s, "missing", sub, &c); err == nil {
+		t.Error("expected error for unknown chain")
+	}
+}
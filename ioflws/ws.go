@@ -0,0 +1,205 @@
+// The ioflws package adapts WebSocket connections to iofl chains. The binary
+// messages of a connection can be used as the source of a chain, and the
+// output of a chain can be sent as messages.
+//
+// The package does not depend on any particular WebSocket implementation.
+// Conn is satisfied by *websocket.Conn of github.com/gorilla/websocket, and can
+// be implemented for other packages with a small adapter.
+package ioflws
+
+import (
+	"io"
+
+	"github.com/anaminus/iofl"
+)
+
+// Message types, as defined by RFC 6455.
+const (
+	TextMessage   = 1
+	BinaryMessage = 2
+)
+
+// Conn is a WebSocket connection.
+type Conn interface {
+	// NextReader returns the type of the next data message received, and a
+	// reader that produces its content.
+	NextReader() (messageType int, r io.Reader, err error)
+	// NextWriter returns a writer for the next message to be sent, of the
+	// given type. The message is sent when the writer is closed.
+	NextWriter(messageType int) (io.WriteCloser, error)
+	// Close closes the connection.
+	Close() error
+}
+
+// Source is a Filter with no source that produces the binary messages received
+// by a Conn. It implements iofl.Framer, with each message being a frame. When
+// read as a stream of bytes, messages are concatenated.
+type Source struct {
+	// Text causes text messages to be produced in addition to binary
+	// messages. By default, text messages are discarded.
+	Text bool
+
+	// EOF reports whether an error returned by the Conn marks the normal end
+	// of the stream, in which case io.EOF is returned instead. With gorilla,
+	// this may be:
+	//
+	//	func(err error) bool {
+	//		return websocket.IsCloseError(err, websocket.CloseNormalClosure)
+	//	}
+	//
+	// If nil, all errors are returned as is.
+	EOF func(err error) bool
+
+	conn   Conn
+	r      io.Reader
+	closed bool
+}
+
+// NewSource returns a Source that reads messages from conn.
+func NewSource(conn Conn) *Source {
+	return &Source{conn: conn}
+}
+
+// Source implements iofl.Filter, returning nil.
+func (s *Source) Source() io.ReadCloser {
+	return nil
+}
+
+// next advances to the next accepted message.
+func (s *Source) next() error {
+	for {
+		typ, r, err := s.conn.NextReader()
+		if err != nil {
+			if s.EOF != nil && s.EOF(err) {
+				return io.EOF
+			}
+			return err
+		}
+		if typ == BinaryMessage || typ == TextMessage && s.Text {
+			s.r = r
+			return nil
+		}
+	}
+}
+
+// ReadFrame implements iofl.Framer, returning the content of the next message.
+// If the current message was partially read by Read, the remainder is
+// returned.
+func (s *Source) ReadFrame() ([]byte, error) {
+	if s.closed {
+		return nil, iofl.Closed
+	}
+	if s.r == nil {
+		if err := s.next(); err != nil {
+			return nil, err
+		}
+	}
+	r := s.r
+	s.r = nil
+	return io.ReadAll(r)
+}
+
+// Read implements io.Reader.
+func (s *Source) Read(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, iofl.Closed
+	}
+	for {
+		if s.r == nil {
+			if err := s.next(); err != nil {
+				return 0, err
+			}
+		}
+		n, err = s.r.Read(p)
+		if err == io.EOF {
+			s.r = nil
+			if n == 0 {
+				continue
+			}
+			err = nil
+		}
+		return n, err
+	}
+}
+
+// Close implements io.Closer, closing the connection.
+func (s *Source) Close() error {
+	if s.closed {
+		return iofl.Closed
+	}
+	s.closed = true
+	return s.conn.Close()
+}
+
+// Send sends the content of r to conn as binary messages, returning the number
+// of messages sent. If r implements iofl.Framer, each frame is sent as a
+// message. Otherwise, the entire content of r is streamed as one message.
+func Send(conn Conn, r io.Reader) (n int, err error) {
+	fr, ok := r.(iofl.Framer)
+	if !ok {
+		w, err := conn.NextWriter(BinaryMessage)
+		if err != nil {
+			return 0, err
+		}
+		if _, err := io.Copy(w, r); err != nil {
+			w.Close()
+			return 0, err
+		}
+		return 1, w.Close()
+	}
+	for {
+		msg, err := fr.ReadFrame()
+		if err != nil {
+			if err == io.EOF {
+				return n, nil
+			}
+			return n, err
+		}
+		if _, err := sendMessage(conn, msg); err != nil {
+			return n, err
+		}
+		n++
+	}
+}
+
+// Sink is an io.WriteCloser that sends each Write as a binary message, for use
+// as the destination of a chain.
+type Sink struct {
+	conn   Conn
+	closed bool
+}
+
+// NewSink returns a Sink that sends messages to conn.
+func NewSink(conn Conn) *Sink {
+	return &Sink{conn: conn}
+}
+
+// Write implements io.Writer, sending p as one binary message.
+func (s *Sink) Write(p []byte) (n int, err error) {
+	if s.closed {
+		return 0, iofl.Closed
+	}
+	return sendMessage(s.conn, p)
+}
+
+// sendMessage sends p to conn as one binary message.
+func sendMessage(conn Conn, p []byte) (n int, err error) {
+	w, err := conn.NextWriter(BinaryMessage)
+	if err != nil {
+		return 0, err
+	}
+	if n, err = w.Write(p); err != nil {
+		w.Close()
+		return n, err
+	}
+	return n, w.Close()
+}
+
+// Close implements io.Closer, closing the connection.
+func (s *Sink) Close() error {
+	if s.closed {
+		return iofl.Closed
+	}
+	s.closed = true
+	return s.conn.Close()
+}
@@ -0,0 +1,179 @@
+package ioflws_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/ioflws"
+)
+
+var errClosed = errors.New("connection closed")
+
+type message struct {
+	typ  int
+	data string
+}
+
+// fakeConn is a Conn that receives a fixed list of messages, and records
+// messages that are sent.
+type fakeConn struct {
+	in     []message
+	out    []message
+	closed bool
+}
+
+func (c *fakeConn) NextReader() (int, io.Reader, error) {
+	if len(c.in) == 0 {
+		return 0, nil, errClosed
+	}
+	m := c.in[0]
+	c.in = c.in[1:]
+	return m.typ, strings.NewReader(m.data), nil
+}
+
+func (c *fakeConn) NextWriter(typ int) (io.WriteCloser, error) {
+	if c.closed {
+		return nil, errClosed
+	}
+	return &messageWriter{conn: c, typ: typ}, nil
+}
+
+func (c *fakeConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+type messageWriter struct {
+	conn *fakeConn
+	typ  int
+	buf  bytes.Buffer
+}
+
+func (w *messageWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+func (w *messageWriter) Close() error {
+	w.conn.out = append(w.conn.out, message{w.typ, w.buf.String()})
+	return nil
+}
+
+func isClosed(err error) bool { return err == errClosed }
+
+func messages() []message {
+	return []message{
+		{ioflws.BinaryMessage, "ab"},
+		{ioflws.TextMessage, "text"},
+		{ioflws.BinaryMessage, ""},
+		{ioflws.BinaryMessage, "cd"},
+	}
+}
+
+func TestSource(t *testing.T) {
+	conn := &fakeConn{in: messages()}
+	src := ioflws.NewSource(conn)
+	src.EOF = isClosed
+	if src.Source() != nil {
+		t.Error("expected nil source")
+	}
+	b, err := ioutil.ReadAll(src)
+	if err != nil || string(b) != "abcd" {
+		t.Errorf("got %q, %v", b, err)
+	}
+	if err := src.Close(); err != nil || !conn.closed {
+		t.Errorf("close: %v, closed %v", err, conn.closed)
+	}
+	if _, err := src.Read(make([]byte, 1)); err != iofl.Closed {
+		t.Errorf("read: got %v, want Closed", err)
+	}
+	if err := src.Close(); err != iofl.Closed {
+		t.Errorf("close: got %v, want Closed", err)
+	}
+}
+
+func TestSourceFrames(t *testing.T) {
+	src := ioflws.NewSource(&fakeConn{in: messages()})
+	src.Text = true
+	src.EOF = isClosed
+
+	// A partially read message is completed by ReadFrame.
+	b := make([]byte, 1)
+	if n, err := src.Read(b); n != 1 || err != nil {
+		t.Fatalf("got %d, %v", n, err)
+	}
+	frames := []string{string(b)}
+	for {
+		frame, err := src.ReadFrame()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, string(frame))
+	}
+	if want := []string{"a", "b", "text", "", "cd"}; !reflect.DeepEqual(frames, want) {
+		t.Errorf("got %q, want %q", frames, want)
+	}
+}
+
+func TestSourceError(t *testing.T) {
+	src := ioflws.NewSource(&fakeConn{})
+	if _, err := src.Read(make([]byte, 1)); err != errClosed {
+		t.Errorf("got %v, want connection error", err)
+	}
+}
+
+func TestSend(t *testing.T) {
+	conn := &fakeConn{}
+	n, err := ioflws.Send(conn, strings.NewReader("whole"))
+	if err != nil || n != 1 {
+		t.Errorf("reader: got %d, %v", n, err)
+	}
+	src := ioflws.NewSource(&fakeConn{in: messages()})
+	src.EOF = isClosed
+	n, err = ioflws.Send(conn, src)
+	if err != nil || n != 3 {
+		t.Errorf("framer: got %d, %v", n, err)
+	}
+	want := []message{
+		{ioflws.BinaryMessage, "whole"},
+		{ioflws.BinaryMessage, "ab"},
+		{ioflws.BinaryMessage, ""},
+		{ioflws.BinaryMessage, "cd"},
+	}
+	if !reflect.DeepEqual(conn.out, want) {
+		t.Errorf("got %v, want %v", conn.out, want)
+	}
+
+	conn.Close()
+	if _, err := ioflws.Send(conn, strings.NewReader("x")); err != errClosed {
+		t.Errorf("got %v, want connection error", err)
+	}
+}
+
+func TestSink(t *testing.T) {
+	conn := &fakeConn{}
+	sink := ioflws.NewSink(conn)
+	for _, s := range []string{"a", "bc"} {
+		if n, err := sink.Write([]byte(s)); n != len(s) || err != nil {
+			t.Errorf("%q: got %d, %v", s, n, err)
+		}
+	}
+	want := []message{{ioflws.BinaryMessage, "a"}, {ioflws.BinaryMessage, "bc"}}
+	if !reflect.DeepEqual(conn.out, want) {
+		t.Errorf("got %v, want %v", conn.out, want)
+	}
+	if err := sink.Close(); err != nil || !conn.closed {
+		t.Errorf("close: %v, closed %v", err, conn.closed)
+	}
+	if _, err := sink.Write([]byte("x")); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+	if err := sink.Close(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+}
@@ -0,0 +1,13 @@
+module github.com/anaminus/iofl/ioflzstd
+
+// The go version is that required by github.com/klauspost/compress, and is
+// newer than that of the iofl module. Keeping the compressor in this module
+// lets iofl itself continue to build with go 1.16.
+go 1.25
+
+require (
+	github.com/anaminus/iofl v0.0.0
+	github.com/klauspost/compress v1.20.1
+)
+
+replace github.com/anaminus/iofl => ../
@@ -0,0 +1,414 @@
+// The ioflzstd package provides a zstd filter implemented with
+// github.com/klauspost/compress/zstd. It is a separate module, so that the iofl
+// module does not depend on the compressor, nor on the newer Go toolchain
+// required by the compressor, which is declared by the go.mod of this module.
+//
+// Register adds the filter to a ChainSet, and registers the "zstd" FrameCodec
+// used by the zstdseek filter of the filters package.
+package ioflzstd
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Zstd compresses or decompresses zstd data. Params:
+//
+//	mode:   "decompress" (default) or "compress".
+//	level:  The compression level, from 1 (fastest) to 22 (smallest), mapped
+//	        to the nearest level supported by the compressor. Defaults to 3.
+//	window: When compressing, the size of the window, in bytes, which must
+//	        be a power of two from 1KiB to 512MiB. When decompressing, the
+//	        maximum size of a window, in bytes. Defaults to 8MiB when
+//	        compressing, and 64MiB when decompressing.
+//	dict:   The name of a dictionary registered with RegisterDict. When
+//	        decompressing, the dictionary is used by frames that refer to its
+//	        ID.
+//
+// When compressing, the source is compressed as a single frame. When used in
+// a write chain, the filter compresses written content if mode is
+// "decompress". A filter in "compress" mode cannot be written to.
+var Zstd = iofl.FilterDef{
+	Name:         "zstd",
+	New:          newZstd,
+	NewWriter:    newZstdWriter,
+	Validate:     validateZstd,
+	Description:  "Compresses or decompresses zstd data.",
+	StrictParams: true,
+	Params: []iofl.ParamDef{
+		{Name: "mode", Type: iofl.ParamString, Description: "\"decompress\" or \"compress\".", Default: "decompress"},
+		{Name: "level", Type: iofl.ParamInt, Description: "The compression level, from 1 (fastest) to 22 (smallest).", Default: "3"},
+		{Name: "window", Type: iofl.ParamInt, Description: "The window size when compressing, or the maximum window size when decompressing, in bytes.", Default: "8MiB or 64MiB"},
+		{Name: "dict", Type: iofl.ParamString, Description: "The name of a registered dictionary."},
+	},
+	Traits: func(params iofl.Params) iofl.Trait {
+		if params.GetString("mode") == "compress" {
+			return iofl.Compresses
+		}
+		return iofl.Decompresses
+	},
+}
+
+// Register registers the Zstd filter with s, and registers Codec as the "zstd"
+// FrameCodec.
+func Register(s *iofl.ChainSet) error {
+	filters.RegisterCodec("zstd", Codec)
+	return s.Register(Zstd)
+}
+
+// dicts is the registry of dictionaries.
+var dicts = struct {
+	sync.RWMutex
+	m map[string][]byte
+}{m: map[string][]byte{}}
+
+// RegisterDict registers a zstd dictionary under the given name, replacing any
+// dictionary of the same name. The dictionary is referred to by the dict
+// param.
+func RegisterDict(name string, dict []byte) {
+	dicts.Lock()
+	defer dicts.Unlock()
+	dicts.m[name] = dict
+}
+
+// getDict returns the dictionary of the given name.
+func getDict(name string) ([]byte, error) {
+	dicts.RLock()
+	defer dicts.RUnlock()
+	dict, ok := dicts.m[name]
+	if !ok {
+		return nil, fmt.Errorf("dictionary %q not registered", name)
+	}
+	return dict, nil
+}
+
+// Default values of the window param.
+const (
+	defaultWindow    = 8 << 20
+	defaultMaxWindow = 64 << 20
+)
+
+// zstdOptions contains the parsed params of the filter.
+type zstdOptions struct {
+	compress bool
+	level    int
+	window   int
+	dict     []byte
+}
+
+func parseOptions(params iofl.Params) (o zstdOptions, err error) {
+	switch mode := params.GetString("mode"); mode {
+	case "", "decompress":
+	case "compress":
+		o.compress = true
+	default:
+		return o, fmt.Errorf("unknown mode %q", mode)
+	}
+	o.level = 3
+	if _, ok := params["level"]; ok {
+		if o.level = params.GetInt("level"); o.level < 1 || o.level > 22 {
+			return o, fmt.Errorf("invalid level %d", o.level)
+		}
+	}
+	if o.window = params.GetInt("window"); o.window <= 0 {
+		o.window = defaultMaxWindow
+		if o.compress {
+			o.window = defaultWindow
+		}
+	} else if o.compress && (o.window < zstd.MinWindowSize || o.window > zstd.MaxWindowSize || o.window&(o.window-1) != 0) {
+		return o, fmt.Errorf("invalid window %d", o.window)
+	}
+	if name := params.GetString("dict"); name != "" {
+		if o.dict, err = getDict(name); err != nil {
+			return o, err
+		}
+	}
+	return o, nil
+}
+
+func validateZstd(params iofl.Params) error {
+	_, err := parseOptions(params)
+	return err
+}
+
+// encoder returns an encoder that writes to w.
+func (o zstdOptions) encoder(w io.Writer) (*zstd.Encoder, error) {
+	opts := []zstd.EOption{
+		zstd.WithEncoderLevel(zstd.EncoderLevelFromZstd(o.level)),
+		zstd.WithWindowSize(o.window),
+		zstd.WithEncoderConcurrency(1),
+	}
+	if o.dict != nil {
+		opts = append(opts, zstd.WithEncoderDict(o.dict))
+	}
+	return zstd.NewWriter(w, opts...)
+}
+
+// decoder returns a decoder that reads from r.
+func (o zstdOptions) decoder(r io.Reader) (*zstd.Decoder, error) {
+	opts := []zstd.DOption{
+		zstd.WithDecoderMaxWindow(uint64(o.window)),
+		zstd.WithDecoderConcurrency(1),
+	}
+	if o.dict != nil {
+		opts = append(opts, zstd.WithDecoderDicts(o.dict))
+	}
+	return zstd.NewReader(r, opts...)
+}
+
+// zstdError classifies errors caused by malformed zstd data as corrupt.
+func zstdError(err error) error {
+	switch {
+	case errors.Is(err, zstd.ErrMagicMismatch),
+		errors.Is(err, zstd.ErrCRCMismatch),
+		errors.Is(err, zstd.ErrReservedBlockType),
+		errors.Is(err, zstd.ErrBlockTooSmall):
+		return &iofl.CorruptError{Err: err}
+	}
+	return err
+}
+
+func newZstd(params iofl.Params, r io.ReadCloser) (f iofl.Filter, err error) {
+	if r == nil {
+		return nil, errors.New("source required")
+	}
+	o, err := parseOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	if o.compress {
+		filter := &compressFilter{opts: o, chunk: make([]byte, 32<<10)}
+		if filter.zw, err = o.encoder(&filter.buf); err != nil {
+			return nil, err
+		}
+		filter.Reset(r)
+		return filter, nil
+	}
+	filter := &decompressFilter{opts: o}
+	if err := filter.Reset(r); err != nil {
+		return nil, err
+	}
+	return filter, nil
+}
+
+// decompressFilter implements the Zstd filter in decompress mode.
+type decompressFilter struct {
+	src    io.ReadCloser
+	opts   zstdOptions
+	zr     *zstd.Decoder
+	closed bool
+}
+
+// Source implements iofl.Filter.
+func (f *decompressFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *decompressFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	n, err = f.zr.Read(p)
+	return n, zstdError(err)
+}
+
+// Close implements io.Closer, releasing the decoder, and closing the source.
+func (f *decompressFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	f.zr.Close()
+	f.zr = nil
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *decompressFilter) Reset(src io.ReadCloser) (err error) {
+	f.src = src
+	f.closed = false
+	if f.zr == nil {
+		f.zr, err = f.opts.decoder(src)
+		return err
+	}
+	return f.zr.Reset(src)
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *decompressFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *decompressFilter) MemoryUsage() int {
+	// The window is an upper bound.
+	return f.opts.window
+}
+
+// compressFilter implements the Zstd filter in compress mode.
+type compressFilter struct {
+	src    io.ReadCloser
+	opts   zstdOptions
+	closed bool
+
+	zw    *zstd.Encoder
+	buf   bytes.Buffer
+	chunk []byte
+	err   error
+}
+
+// Source implements iofl.Filter.
+func (f *compressFilter) Source() io.ReadCloser {
+	return f.src
+}
+
+// Read implements io.Reader.
+func (f *compressFilter) Read(p []byte) (n int, err error) {
+	if f.closed {
+		return 0, iofl.Closed
+	}
+	for f.buf.Len() == 0 {
+		if f.err != nil {
+			return 0, f.err
+		}
+		n, err := f.src.Read(f.chunk)
+		if n > 0 {
+			if _, werr := f.zw.Write(f.chunk[:n]); werr != nil {
+				return 0, werr
+			}
+		}
+		if err == io.EOF {
+			if cerr := f.zw.Close(); cerr != nil {
+				return 0, cerr
+			}
+		}
+		f.err = err
+	}
+	return f.buf.Read(p)
+}
+
+// Close implements io.Closer, closing the source.
+func (f *compressFilter) Close() error {
+	if f.closed {
+		return iofl.Closed
+	}
+	f.closed = true
+	return f.src.Close()
+}
+
+// Reset implements iofl.Resetter.
+func (f *compressFilter) Reset(src io.ReadCloser) error {
+	f.src = src
+	f.closed = false
+	f.err = nil
+	f.buf.Reset()
+	f.zw.Reset(&f.buf)
+	return nil
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (f *compressFilter) CPUIntensive() bool {
+	return true
+}
+
+// MemoryUsage implements iofl.MemoryUser.
+func (f *compressFilter) MemoryUsage() int {
+	return cap(f.chunk) + f.buf.Cap() + 2*f.opts.window
+}
+
+// newZstdWriter returns a writer that compresses written content. The mode
+// param must be "decompress".
+func newZstdWriter(params iofl.Params, w io.WriteCloser) (f iofl.WriteFilter, err error) {
+	o, err := parseOptions(params)
+	if err != nil {
+		return nil, err
+	}
+	if o.compress {
+		return nil, iofl.NotWritable
+	}
+	// The window param limits decompression, and is not a window size.
+	o.window = defaultWindow
+	zw, err := o.encoder(w)
+	if err != nil {
+		return nil, err
+	}
+	return &zstdWriter{dst: w, zw: zw}, nil
+}
+
+// zstdWriter implements the Zstd filter in a write chain.
+type zstdWriter struct {
+	dst    io.WriteCloser
+	zw     *zstd.Encoder
+	closed bool
+}
+
+// Sink implements iofl.WriteFilter.
+func (w *zstdWriter) Sink() io.WriteCloser {
+	return w.dst
+}
+
+// Write implements io.Writer.
+func (w *zstdWriter) Write(p []byte) (n int, err error) {
+	if w.closed {
+		return 0, iofl.Closed
+	}
+	return w.zw.Write(p)
+}
+
+// Flush implements iofl.Flusher, completing the current compressed block.
+func (w *zstdWriter) Flush() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	return w.zw.Flush()
+}
+
+// Close implements io.Closer, completing the compressed stream, and closing
+// the sink.
+func (w *zstdWriter) Close() error {
+	if w.closed {
+		return iofl.Closed
+	}
+	w.closed = true
+	err := w.zw.Close()
+	if cerr := w.dst.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// CPUIntensive implements iofl.CPUIntensive.
+func (w *zstdWriter) CPUIntensive() bool {
+	return true
+}
+
+// Codec is a filters.FrameCodec that compresses and decompresses zstd frames
+// at the default level.
+var Codec filters.FrameCodec = codec{}
+
+// Shared encoder and decoder of Codec. EncodeAll and DecodeAll are safe for
+// concurrent use.
+var (
+	codecEncoder, _ = zstd.NewWriter(nil, zstd.WithEncoderConcurrency(1))
+	codecDecoder, _ = zstd.NewReader(nil, zstd.WithDecoderConcurrency(0))
+)
+
+// codec implements Codec.
+type codec struct{}
+
+func (codec) EncodeFrame(dst, src []byte) ([]byte, error) {
+	return codecEncoder.EncodeAll(src, dst), nil
+}
+
+func (codec) DecodeFrame(dst, src []byte) ([]byte, error) {
+	dst, err := codecDecoder.DecodeAll(src, dst)
+	return dst, zstdError(err)
+}
@@ -0,0 +1,219 @@
+package ioflzstd_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/ioflzstd"
+	"github.com/klauspost/compress/zstd"
+)
+
+// nopWriteCloser adds a no-op Close method to an io.Writer.
+type nopWriteCloser struct {
+	io.Writer
+}
+
+func (nopWriteCloser) Close() error { return nil }
+
+// read constructs the Zstd filter over in, and returns everything read from
+// it.
+func read(t *testing.T, params iofl.Params, in []byte) ([]byte, error) {
+	t.Helper()
+	f, err := ioflzstd.Zstd.New(params, ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		return nil, err
+	}
+	out, err := ioutil.ReadAll(f)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	return out, err
+}
+
+func mustRead(t *testing.T, params iofl.Params, in []byte) []byte {
+	t.Helper()
+	out, err := read(t, params, in)
+	if err != nil {
+		t.Fatalf("%v: %v", params, err)
+	}
+	return out
+}
+
+var content = []byte(strings.Repeat("zstandard compresses this content. ", 2000))
+
+func TestZstdRoundTrip(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "compress"},
+		{"mode": "compress", "level": 1},
+		{"mode": "compress", "level": 22},
+		{"mode": "compress", "window": 1 << 10},
+	} {
+		compressed := mustRead(t, params, content)
+		if len(compressed) >= len(content) {
+			t.Errorf("%v: compressed to %d bytes", params, len(compressed))
+		}
+		if out := mustRead(t, nil, compressed); !bytes.Equal(out, content) {
+			t.Errorf("%v: round trip does not match input", params)
+		}
+	}
+	if out := mustRead(t, iofl.Params{"mode": "compress"}, nil); len(out) == 0 {
+		t.Error("empty source produced no frame")
+	}
+}
+
+func TestZstdWriter(t *testing.T) {
+	var buf bytes.Buffer
+	w, err := ioflzstd.Zstd.NewWriter(nil, nopWriteCloser{&buf})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(content); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.(iofl.Flusher).Flush(); err != nil {
+		t.Fatal(err)
+	}
+	// The content written so far can be decompressed before the frame ends.
+	f, err := ioflzstd.Zstd.New(nil, ioutil.NopCloser(bytes.NewReader(buf.Bytes())))
+	if err != nil {
+		t.Fatal(err)
+	}
+	flushed := make([]byte, len(content))
+	if _, err := io.ReadFull(f, flushed); err != nil || !bytes.Equal(flushed, content) {
+		t.Errorf("after flush: got %d bytes, %v", len(flushed), err)
+	}
+	f.Close()
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != iofl.Closed {
+		t.Errorf("got %v, want Closed", err)
+	}
+	if err := w.(iofl.Flusher).Flush(); err != iofl.Closed {
+		t.Errorf("flush after close: got %v, want Closed", err)
+	}
+	if out := mustRead(t, nil, buf.Bytes()); !bytes.Equal(out, content) {
+		t.Error("written content does not match")
+	}
+	if _, err := ioflzstd.Zstd.NewWriter(iofl.Params{"mode": "compress"}, nopWriteCloser{&buf}); err != iofl.NotWritable {
+		t.Errorf("got %v, want NotWritable", err)
+	}
+}
+
+func TestZstdCorrupt(t *testing.T) {
+	compressed := mustRead(t, iofl.Params{"mode": "compress"}, content)
+	mutate := func(i int) []byte {
+		b := append([]byte{}, compressed...)
+		b[i] ^= 0xFF
+		return b
+	}
+	for name, in := range map[string][]byte{
+		"magic":    mutate(0),
+		"checksum": mutate(len(compressed) - 1),
+	} {
+		if _, err := read(t, nil, in); !iofl.IsCorrupt(err) {
+			t.Errorf("%s: got %v, want corrupt", name, err)
+		}
+	}
+	if _, err := read(t, nil, compressed[:len(compressed)/2]); err == nil {
+		t.Error("truncated: expected error")
+	}
+	// A window larger than the maximum is rejected.
+	large := mustRead(t, iofl.Params{"mode": "compress", "window": 1 << 20}, bytes.Repeat(content, 20))
+	if _, err := read(t, iofl.Params{"window": 1 << 10}, large); err == nil {
+		t.Error("expected error for window beyond maximum")
+	}
+}
+
+func TestZstdDict(t *testing.T) {
+	history := bytes.Repeat([]byte("common phrase alpha beta gamma delta "), 16)
+	var samples [][]byte
+	for _, s := range []string{"city=honolulu", "city=seattle", "city=london", "city=paris"} {
+		samples = append(samples, append(append([]byte{}, history...), s...))
+	}
+	dict, err := zstd.BuildDict(zstd.BuildDictOptions{
+		ID:       1,
+		Contents: samples,
+		History:  history,
+		Offsets:  [3]int{1, 4, 8},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ioflzstd.RegisterDict("test", dict)
+	in := append(append([]byte{}, history...), "city=tokyo"...)
+	compressed := mustRead(t, iofl.Params{"mode": "compress", "dict": "test"}, in)
+	if out := mustRead(t, iofl.Params{"dict": "test"}, compressed); !bytes.Equal(out, in) {
+		t.Error("round trip does not match input")
+	}
+	if _, err := read(t, nil, compressed); err == nil {
+		t.Error("expected error decompressing without dictionary")
+	}
+}
+
+func TestZstdParams(t *testing.T) {
+	for _, params := range []iofl.Params{
+		{"mode": "inflate"},
+		{"level": 0},
+		{"level": 23},
+		{"mode": "compress", "window": 1000},
+		{"mode": "compress", "window": 512},
+		{"dict": "missing"},
+	} {
+		if err := ioflzstd.Zstd.Validate(params); err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+	if _, err := ioflzstd.Zstd.New(nil, nil); err == nil {
+		t.Error("expected error without source")
+	}
+}
+
+func TestCodec(t *testing.T) {
+	frame, err := ioflzstd.Codec.EncodeFrame([]byte("prefix"), content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.HasPrefix(frame, []byte("prefix")) {
+		t.Fatal("EncodeFrame did not append to dst")
+	}
+	out, err := ioflzstd.Codec.DecodeFrame(nil, frame[len("prefix"):])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, content) {
+		t.Error("round trip does not match input")
+	}
+	_, err = ioflzstd.Codec.DecodeFrame(nil, []byte("not a zstd frame"))
+	var corrupt *iofl.CorruptError
+	if !errors.As(err, &corrupt) {
+		t.Errorf("got %v, want CorruptError", err)
+	}
+}
+
+func TestRegister(t *testing.T) {
+	s := iofl.NewChainSet()
+	if err := ioflzstd.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"c": {{Filter: "zstd", Params: iofl.Params{"mode": "compress"}}, {Filter: "zstd"}},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	f, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(content)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	out, err := ioutil.ReadAll(f)
+	f.Close()
+	if err != nil || !bytes.Equal(out, content) {
+		t.Errorf("got %d bytes, %v", len(out), err)
+	}
+}
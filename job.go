@@ -0,0 +1,327 @@
+package iofl
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnknownJob is returned when a job is not configured.
+var UnknownJob = errors.New("unknown job")
+
+// JobRunning is returned by Runner.RunJob when the job is already running.
+var JobRunning = errors.New("job already running")
+
+// JobDef describes a job, which runs a chain from a source to a sink. Jobs are
+// configured by the Jobs field of Config, and are run by a Runner.
+type JobDef struct {
+	// Source refers to the source of the chain, of the form "scheme:name".
+	// The source is opened by the SourceOpener registered with the Runner for
+	// the scheme.
+	Source string
+	// Chain is the name of the chain that is run.
+	Chain string
+	// Sink refers to the destination of the output of the chain, of the form
+	// "scheme:name". The sink is opened by the SinkOpener registered with the
+	// Runner for the scheme.
+	Sink string
+	// Interval is the interval at which the job is run by Runner.Serve. If
+	// zero, the job is run only on demand, by Runner.RunJob.
+	Interval time.Duration
+}
+
+// validateJob returns an error if job is not valid for config.
+func validateJob(config Config, job JobDef) error {
+	if job.Chain == "" {
+		return errors.New("chain required")
+	}
+	if _, ok := config.Chains[job.Chain]; !ok {
+		return fmt.Errorf("%w %q", UnknownChain, job.Chain)
+	}
+	if _, _, err := splitJobRef(job.Source); err != nil {
+		return fmt.Errorf("source: %w", err)
+	}
+	if _, _, err := splitJobRef(job.Sink); err != nil {
+		return fmt.Errorf("sink: %w", err)
+	}
+	if job.Interval < 0 {
+		return errors.New("negative interval")
+	}
+	return nil
+}
+
+// splitJobRef splits a reference of the form "scheme:name".
+func splitJobRef(ref string) (scheme, name string, err error) {
+	i := strings.IndexByte(ref, ':')
+	if i <= 0 {
+		return "", "", fmt.Errorf("reference %q must be of the form \"scheme:name\"", ref)
+	}
+	return ref[:i], ref[i+1:], nil
+}
+
+// job returns the configured job of the given name.
+func (s *ChainSet) job(name string) (JobDef, bool) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	job, ok := s.jobs[name]
+	return job, ok
+}
+
+// SourceOpener opens the source of a job, given the name part of its
+// reference.
+type SourceOpener func(name string) (io.ReadCloser, error)
+
+// SinkOpener opens the sink of a job, given the name part of its reference.
+type SinkOpener func(name string) (io.WriteCloser, error)
+
+// JobStatus describes the runs of a job by a Runner.
+type JobStatus struct {
+	// Name is the name of the job.
+	Name string
+	// Running is whether the job is currently running.
+	Running bool
+	// Runs is the number of completed runs of the job.
+	Runs int
+	// Failures is the number of completed runs that failed.
+	Failures int
+	// LastStart is the time at which the last run started.
+	LastStart time.Time
+	// LastEnd is the time at which the last completed run ended.
+	LastEnd time.Time
+	// LastReport is the report of the last completed run.
+	LastReport RunReport
+	// LastError is the error of the last completed run, or nil if it
+	// succeeded.
+	LastError error
+}
+
+// Runner runs the jobs configured for a ChainSet, on demand or on a schedule,
+// and tracks the status of each job. A job does not run concurrently with
+// itself. Jobs are looked up when they are run, so changes to the
+// configuration of the ChainSet apply to subsequent runs. A Runner is safe for
+// concurrent use.
+type Runner struct {
+	set  *ChainSet
+	opts []Option
+
+	mu      sync.Mutex
+	sources map[string]SourceOpener
+	sinks   map[string]SinkOpener
+	status  map[string]*JobStatus
+}
+
+// NewRunner returns a Runner for the jobs of s. Each Option is applied to each
+// run of a job. The "file" scheme is registered for sources and sinks, opening
+// and creating files by path.
+func (s *ChainSet) NewRunner(opts ...Option) *Runner {
+	r := &Runner{
+		set:     s,
+		opts:    opts,
+		sources: map[string]SourceOpener{},
+		sinks:   map[string]SinkOpener{},
+		status:  map[string]*JobStatus{},
+	}
+	r.RegisterSource("file", func(name string) (io.ReadCloser, error) {
+		return os.Open(name)
+	})
+	r.RegisterSink("file", func(name string) (io.WriteCloser, error) {
+		return os.Create(name)
+	})
+	return r
+}
+
+// RegisterSource registers open as the opener of sources of the given scheme,
+// replacing any existing opener.
+func (r *Runner) RegisterSource(scheme string, open SourceOpener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources[scheme] = open
+}
+
+// RegisterSink registers open as the opener of sinks of the given scheme,
+// replacing any existing opener.
+func (r *Runner) RegisterSink(scheme string, open SinkOpener) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sinks[scheme] = open
+}
+
+// open opens the source and sink of job.
+func (r *Runner) open(job JobDef) (src io.ReadCloser, dst io.WriteCloser, err error) {
+	srcScheme, srcName, err := splitJobRef(job.Source)
+	if err != nil {
+		return nil, nil, fmt.Errorf("source: %w", err)
+	}
+	dstScheme, dstName, err := splitJobRef(job.Sink)
+	if err != nil {
+		return nil, nil, fmt.Errorf("sink: %w", err)
+	}
+	r.mu.Lock()
+	openSource, okSource := r.sources[srcScheme]
+	openSink, okSink := r.sinks[dstScheme]
+	r.mu.Unlock()
+	if !okSource {
+		return nil, nil, fmt.Errorf("source: unknown scheme %q", srcScheme)
+	}
+	if !okSink {
+		return nil, nil, fmt.Errorf("sink: unknown scheme %q", dstScheme)
+	}
+	if src, err = openSource(srcName); err != nil {
+		return nil, nil, fmt.Errorf("source: %w", err)
+	}
+	if dst, err = openSink(dstName); err != nil {
+		src.Close()
+		return nil, nil, fmt.Errorf("sink: %w", err)
+	}
+	return src, dst, nil
+}
+
+// RunJob runs the named job, returning the report of the run. ctx is applied
+// to the run with the Cancel option. Returns UnknownJob if the job is not
+// configured, or JobRunning if the job is already running.
+func (r *Runner) RunJob(ctx context.Context, name string) (report RunReport, err error) {
+	job, ok := r.set.job(name)
+	if !ok {
+		return report, fmt.Errorf("%w %q", UnknownJob, name)
+	}
+	r.mu.Lock()
+	st := r.status[name]
+	if st == nil {
+		st = &JobStatus{Name: name}
+		r.status[name] = st
+	}
+	if st.Running {
+		r.mu.Unlock()
+		return report, fmt.Errorf("%w %q", JobRunning, name)
+	}
+	st.Running = true
+	st.LastStart = time.Now()
+	r.mu.Unlock()
+
+	report, err = r.run(ctx, job)
+	if err != nil {
+		err = fmt.Errorf("job %q: %w", name, err)
+	}
+
+	r.mu.Lock()
+	st.Running = false
+	st.Runs++
+	if err != nil {
+		st.Failures++
+	}
+	st.LastEnd = time.Now()
+	st.LastReport = report
+	st.LastError = err
+	r.mu.Unlock()
+	return report, err
+}
+
+// run makes one run of job.
+func (r *Runner) run(ctx context.Context, job JobDef) (report RunReport, err error) {
+	src, dst, err := r.open(job)
+	if err != nil {
+		return report, err
+	}
+	opts := append(r.opts[:len(r.opts):len(r.opts)], Cancel(ctx))
+	report, err = r.set.Run(job.Chain, dst, src, opts...)
+	if cerr := dst.Close(); err == nil {
+		err = cerr
+	}
+	return report, err
+}
+
+// Status returns the status of the named job. Returns false if the job is not
+// configured.
+func (r *Runner) Status(name string) (status JobStatus, ok bool) {
+	if _, ok := r.set.job(name); !ok {
+		return status, false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if st := r.status[name]; st != nil {
+		return *st, true
+	}
+	return JobStatus{Name: name}, true
+}
+
+// Statuses returns the status of each configured job, in order of name.
+func (r *Runner) Statuses() []JobStatus {
+	r.set.mu.RLock()
+	names := make([]string, 0, len(r.set.jobs))
+	for name := range r.set.jobs {
+		names = append(names, name)
+	}
+	r.set.mu.RUnlock()
+	sort.Strings(names)
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	statuses := make([]JobStatus, len(names))
+	for i, name := range names {
+		if st := r.status[name]; st != nil {
+			statuses[i] = *st
+		} else {
+			statuses[i] = JobStatus{Name: name}
+		}
+	}
+	return statuses
+}
+
+// maxServeWait is the longest Serve waits before checking the configuration
+// for changes to the schedule.
+const maxServeWait = time.Second
+
+// Serve runs each job that has an Interval, until ctx is done. A job is first
+// run when Serve is called, or when the job is added to the configuration, and
+// then once per interval. A run is skipped if the previous run of the job has
+// not completed. Failures are written to the Logger of the ChainSet. Once ctx
+// is done, Serve waits for running jobs to stop, and returns ctx.Err().
+func (r *Runner) Serve(ctx context.Context) error {
+	var wg sync.WaitGroup
+	defer wg.Wait()
+	next := map[string]time.Time{}
+	for {
+		r.set.mu.RLock()
+		jobs := r.set.jobs
+		r.set.mu.RUnlock()
+		now := time.Now()
+		wait := maxServeWait
+		for name, job := range jobs {
+			if job.Interval <= 0 {
+				continue
+			}
+			t, ok := next[name]
+			if !ok || !now.Before(t) {
+				t = now.Add(job.Interval)
+				next[name] = t
+				wg.Add(1)
+				go func(name string) {
+					defer wg.Done()
+					if _, err := r.RunJob(ctx, name); err != nil && !errors.Is(err, JobRunning) {
+						r.set.logf("%s", err)
+					}
+				}(name)
+			}
+			if d := t.Sub(now); d < wait {
+				wait = d
+			}
+		}
+		for name := range next {
+			if job, ok := jobs[name]; !ok || job.Interval <= 0 {
+				delete(next, name)
+			}
+		}
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		}
+	}
+}
@@ -0,0 +1,278 @@
+package iofl_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestRunJob(t *testing.T) {
+	dir := t.TempDir()
+	in, out := filepath.Join(dir, "in"), filepath.Join(dir, "out")
+	if err := ioutil.WriteFile(in, []byte("job"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := newChainSet(t, nil, failFilter("fail"))
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+			"fail":  {{Filter: "fail"}},
+		},
+		Jobs: map[string]iofl.JobDef{
+			"file": {Source: "file:" + in, Chain: "upper", Sink: "file:" + out},
+			"fail": {Source: "file:" + in, Chain: "fail", Sink: "file:" + out + ".fail"},
+			"none": {Source: "file:" + filepath.Join(dir, "missing"), Chain: "upper", Sink: "file:" + out},
+			"mem":  {Source: "mem:a", Chain: "upper", Sink: "mem:b"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.NewRunner()
+	ctx := context.Background()
+
+	// Files are opened by the built-in scheme.
+	report, err := r.RunJob(ctx, "file")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Written != 3 {
+		t.Errorf("got report %+v", report)
+	}
+	if b, _ := ioutil.ReadFile(out); string(b) != "JOB" {
+		t.Errorf("got output %q", b)
+	}
+	st, ok := r.Status("file")
+	if !ok || st.Running || st.Runs != 1 || st.Failures != 0 || st.LastError != nil || !reflect.DeepEqual(st.LastReport, report) {
+		t.Errorf("got status %+v", st)
+	}
+	if st.LastStart.IsZero() || st.LastEnd.Before(st.LastStart) {
+		t.Errorf("got start %v, end %v", st.LastStart, st.LastEnd)
+	}
+
+	// Failures are recorded.
+	for i := 0; i < 2; i++ {
+		if _, err := r.RunJob(ctx, "fail"); !errors.Is(err, errBoom) || !strings.Contains(err.Error(), `job "fail"`) {
+			t.Errorf("got %v", err)
+		}
+	}
+	if st, _ := r.Status("fail"); st.Runs != 2 || st.Failures != 2 || !errors.Is(st.LastError, errBoom) {
+		t.Errorf("got status %+v", st)
+	}
+	if _, err := r.RunJob(ctx, "none"); err == nil || !strings.Contains(err.Error(), "source:") {
+		t.Errorf("missing source: got %v", err)
+	}
+
+	// Other schemes are registered.
+	if _, err := r.RunJob(ctx, "mem"); err == nil || !strings.Contains(err.Error(), `unknown scheme "mem"`) {
+		t.Errorf("unregistered scheme: got %v", err)
+	}
+	var sink bytes.Buffer
+	r.RegisterSource("mem", func(name string) (io.ReadCloser, error) { return source(name), nil })
+	r.RegisterSink("mem", func(name string) (io.WriteCloser, error) { return nopWriteCloser{&sink}, nil })
+	if _, err := r.RunJob(ctx, "mem"); err != nil || sink.String() != "A" {
+		t.Errorf("got %q, %v", sink.String(), err)
+	}
+
+	// A failure to open the sink closes the source.
+	src := &closeCounter{Reader: strings.NewReader("")}
+	r.RegisterSource("mem", func(name string) (io.ReadCloser, error) { return src, nil })
+	r.RegisterSink("mem", func(name string) (io.WriteCloser, error) { return nil, errBoom })
+	if _, err := r.RunJob(ctx, "mem"); !errors.Is(err, errBoom) || !strings.Contains(err.Error(), "sink:") {
+		t.Errorf("got %v", err)
+	}
+	if src.closes != 1 {
+		t.Errorf("source closed %d times", src.closes)
+	}
+
+	if _, err := r.RunJob(ctx, "missing"); !errors.Is(err, iofl.UnknownJob) {
+		t.Errorf("got %v, want UnknownJob", err)
+	}
+	if _, ok := r.Status("missing"); ok {
+		t.Error("got status of unknown job")
+	}
+	var names []string
+	for _, st := range r.Statuses() {
+		names = append(names, st.Name)
+	}
+	if strings.Join(names, ",") != "fail,file,mem,none" {
+		t.Errorf("got statuses of %v", names)
+	}
+}
+
+func TestRunJobRunning(t *testing.T) {
+	s := newChainSet(t, nil)
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{"c": {{Filter: "translate"}}},
+		Jobs:   map[string]iofl.JobDef{"j": {Source: "pipe:", Chain: "c", Sink: "pipe:"}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.NewRunner()
+	pr, pw := io.Pipe()
+	opened := make(chan struct{})
+	r.RegisterSource("pipe", func(string) (io.ReadCloser, error) {
+		close(opened)
+		return pr, nil
+	})
+	r.RegisterSink("pipe", func(string) (io.WriteCloser, error) { return nopWriteCloser{ioutil.Discard}, nil })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		_, err := r.RunJob(ctx, "j")
+		done <- err
+	}()
+	<-opened
+	if st, _ := r.Status("j"); !st.Running {
+		t.Error("job is not running")
+	}
+	// A job does not run concurrently with itself.
+	if _, err := r.RunJob(context.Background(), "j"); !errors.Is(err, iofl.JobRunning) {
+		t.Errorf("got %v, want JobRunning", err)
+	}
+	// The context of the run cancels it.
+	cancel()
+	select {
+	case err := <-done:
+		if !errors.Is(err, context.Canceled) {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("job did not stop")
+	}
+	pw.Close()
+	if st, _ := r.Status("j"); st.Running || st.Runs != 1 || st.Failures != 1 {
+		t.Errorf("got status %+v", st)
+	}
+}
+
+func TestJobConfig(t *testing.T) {
+	s := newChainSet(t, nil)
+	chains := map[string]iofl.Chain{"c": {{Filter: "translate"}}}
+	for _, job := range []iofl.JobDef{
+		{Source: "a:b", Sink: "a:b"},
+		{Source: "a:b", Chain: "missing", Sink: "a:b"},
+		{Source: "ab", Chain: "c", Sink: "a:b"},
+		{Source: "a:b", Chain: "c", Sink: ":b"},
+		{Source: "a:b", Chain: "c", Sink: "a:b", Interval: -1},
+	} {
+		err := s.SetConfig(iofl.Config{Chains: chains, Jobs: map[string]iofl.JobDef{"j": job}})
+		if err == nil || !strings.Contains(err.Error(), `job "j"`) {
+			t.Errorf("%+v: got %v", job, err)
+		}
+	}
+	job := iofl.JobDef{Source: "a:b", Chain: "c", Sink: "a:b:c", Interval: time.Minute}
+	if err := s.SetConfig(iofl.Config{Chains: chains, Jobs: map[string]iofl.JobDef{"j": job}}); err != nil {
+		t.Fatal(err)
+	}
+	if got := s.Config().Jobs["j"]; got != job {
+		t.Errorf("got %+v", got)
+	}
+}
+
+func TestServe(t *testing.T) {
+	s := newChainSet(t, nil, failFilter("fail"))
+	var log logRecorder
+	s.SetLogger(&log)
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"c":    {{Filter: "translate"}},
+			"fail": {{Filter: "fail"}},
+		},
+		Jobs: map[string]iofl.JobDef{
+			"often":     {Source: "mem:often", Chain: "c", Sink: "mem:", Interval: 5 * time.Millisecond},
+			"failing":   {Source: "mem:failing", Chain: "fail", Sink: "mem:", Interval: 5 * time.Millisecond},
+			"on demand": {Source: "mem:on demand", Chain: "c", Sink: "mem:"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := s.NewRunner()
+	var mu sync.Mutex
+	opens := map[string]int{}
+	r.RegisterSource("mem", func(name string) (io.ReadCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opens[name]++
+		return source(name), nil
+	})
+	var sinks int32
+	r.RegisterSink("mem", func(string) (io.WriteCloser, error) {
+		atomic.AddInt32(&sinks, 1)
+		return nopWriteCloser{ioutil.Discard}, nil
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() { done <- r.Serve(ctx) }()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if st, _ := r.Status("often"); st.Runs >= 3 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("job was not run on its interval")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	// A job added to the configuration is scheduled.
+	config := s.Config()
+	config.Jobs["added"] = iofl.JobDef{Source: "mem:added", Chain: "c", Sink: "mem:", Interval: time.Hour}
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	for {
+		if st, _ := r.Status("added"); st.Runs == 1 {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("added job was not run")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Serve did not return")
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if opens["on demand"] != 0 || opens["added"] != 1 || opens["failing"] == 0 {
+		t.Errorf("got opens %v", opens)
+	}
+	// No job is running once Serve returns.
+	for _, st := range r.Statuses() {
+		if st.Running {
+			t.Errorf("%s: still running", st.Name)
+		}
+	}
+	var failed bool
+	for _, msg := range log.messages() {
+		if strings.Contains(msg, `job "failing"`) {
+			failed = true
+		}
+	}
+	if !failed {
+		t.Error("failure was not logged")
+	}
+}
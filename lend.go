@@ -0,0 +1,107 @@
+package iofl
+
+import "io"
+
+// defaultLendBufferSize is the size of the buffer used by Lend when a size is
+// not given.
+const defaultLendBufferSize = 32 * 1024
+
+// Lender is implemented by a Filter that can lend slices of its internal
+// buffers, allowing the caller to consume the output of the filter without
+// copying it into a buffer of its own, as with Read.
+type Lender interface {
+	// Next returns a slice of at most n bytes of the output, and advances
+	// past them. If n is less than or equal to zero, the slice may be of any
+	// length. Next returns a non-empty slice, or an error, such as io.EOF when
+	// no output remains. The slice is owned by the filter, must not be
+	// modified, and is valid only until the next call to Next, Read, or Close.
+	// Calls to Next and Read may be interleaved, each consuming the output
+	// where the previous call left off.
+	Next(n int) ([]byte, error)
+}
+
+// Lend returns a Lender over the content of r. If r implements Lender, or
+// wraps a Filter that implements Lender without altering its output, such as
+// a Root or a resolved chain, then r is lent from directly. Otherwise, the
+// returned Lender reads r into a buffer of the given size, which is lent
+// instead. If size is less than or equal to zero, a default size is used.
+//
+// In the latter case, the content of r is buffered, and must subsequently be
+// read only through the returned Lender, such as with LenderReader.
+func Lend(r io.Reader, size int) Lender {
+	if l, ok := findLender(r); ok {
+		return l
+	}
+	if size <= 0 {
+		size = defaultLendBufferSize
+	}
+	return &bufLender{r: r, buf: make([]byte, size)}
+}
+
+// findLender returns the Lender that produces the output of r, looking through
+// wrappers that do not alter the output.
+func findLender(r io.Reader) (Lender, bool) {
+	for {
+		if l, ok := r.(Lender); ok {
+			return l, true
+		}
+		switch v := r.(type) {
+		case Root:
+			r = v.ReadCloser
+		case *releaseFilter:
+			r = v.f
+		case *hooked:
+			r = v.f
+		case *resolvedFilter:
+			r = v.f
+		case resolvedFramer:
+			r = v.f
+		default:
+			return nil, false
+		}
+	}
+}
+
+// bufLender lends the content of a reader by reading it into a buffer.
+type bufLender struct {
+	r    io.Reader
+	buf  []byte
+	i, j int
+	err  error
+}
+
+// Next implements Lender.
+func (b *bufLender) Next(n int) ([]byte, error) {
+	for b.i == b.j {
+		if b.err != nil {
+			return nil, b.err
+		}
+		b.i = 0
+		b.j, b.err = b.r.Read(b.buf)
+	}
+	if n <= 0 || n > b.j-b.i {
+		n = b.j - b.i
+	}
+	p := b.buf[b.i : b.i+n]
+	b.i += n
+	return p, nil
+}
+
+// LenderReader returns an io.Reader that reads the output of l, copying each
+// lent slice into the buffer given to Read.
+func LenderReader(l Lender) io.Reader {
+	return lenderReader{l: l}
+}
+
+// lenderReader implements LenderReader.
+type lenderReader struct {
+	l Lender
+}
+
+func (r lenderReader) Read(p []byte) (n int, err error) {
+	if len(p) == 0 {
+		return 0, nil
+	}
+	b, err := r.l.Next(len(p))
+	return copy(p, b), err
+}
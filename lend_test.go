@@ -0,0 +1,155 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+)
+
+// lenderSource is a source that lends slices of its content.
+type lenderSource struct {
+	b []byte
+}
+
+func (s *lenderSource) Next(n int) ([]byte, error) {
+	if len(s.b) == 0 {
+		return nil, io.EOF
+	}
+	if n <= 0 || n > len(s.b) {
+		n = len(s.b)
+	}
+	p := s.b[:n]
+	s.b = s.b[n:]
+	return p, nil
+}
+
+func (s *lenderSource) Read(p []byte) (n int, err error) {
+	return iofl.LenderReader(s).Read(p)
+}
+
+func (s *lenderSource) Close() error { return nil }
+
+// lendAll consumes l with calls to Next of at most n bytes.
+func lendAll(l iofl.Lender, n int) (string, error) {
+	var b strings.Builder
+	for {
+		p, err := l.Next(n)
+		if err == io.EOF {
+			return b.String(), nil
+		} else if err != nil {
+			return b.String(), err
+		}
+		if len(p) == 0 || n > 0 && len(p) > n {
+			return b.String(), errors.New("unexpected slice length")
+		}
+		b.Write(p)
+	}
+}
+
+func TestLend(t *testing.T) {
+	in := strings.Repeat("0123456789", 100)
+	for _, test := range []struct {
+		name string
+		r    io.Reader
+		size int
+		n    int
+	}{
+		{"Default", strings.NewReader(in), 0, 0},
+		{"Small", strings.NewReader(in), 7, 0},
+		{"Limited", strings.NewReader(in), 64, 5},
+		{"LargeN", strings.NewReader(in), 16, 100},
+		{"OneByte", iotest.OneByteReader(strings.NewReader(in)), 16, 3},
+		{"DataErr", iotest.DataErrReader(strings.NewReader(in)), 16, 0},
+		{"Lender", &lenderSource{b: []byte(in)}, 0, 9},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			out, err := lendAll(iofl.Lend(test.r, test.size), test.n)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out != in {
+				t.Errorf("got %d bytes, want %d", len(out), len(in))
+			}
+		})
+	}
+}
+
+func TestLendError(t *testing.T) {
+	errRead := errors.New("read failed")
+	l := iofl.Lend(io.MultiReader(strings.NewReader("abc"), iotest.ErrReader(errRead)), 2)
+	out, err := lendAll(l, 0)
+	if out != "abc" || err != errRead {
+		t.Errorf("got %q, %v", out, err)
+	}
+	if _, err := l.Next(1); err != errRead {
+		t.Errorf("got %v, want error again", err)
+	}
+}
+
+func TestLenderReader(t *testing.T) {
+	in := strings.Repeat("lent ", 1000)
+	r := iofl.LenderReader(iofl.Lend(strings.NewReader(in), 10))
+	if err := iotest.TestReader(r, []byte(in)); err != nil {
+		t.Error(err)
+	}
+
+	// Reads and lent slices may be interleaved.
+	l := iofl.Lend(strings.NewReader("abcdefgh"), 4)
+	var got []string
+	p, _ := l.Next(3)
+	got = append(got, string(p))
+	buf := make([]byte, 2)
+	n, _ := iofl.LenderReader(l).Read(buf)
+	got = append(got, string(buf[:n]))
+	p, _ = l.Next(0)
+	got = append(got, string(p))
+	if s := strings.Join(got, ","); s != "abc,d,efgh" {
+		t.Errorf("got %s", s)
+	}
+	if n, err := iofl.LenderReader(l).Read(nil); n != 0 || err != nil {
+		t.Errorf("empty read: got %d, %v", n, err)
+	}
+}
+
+func TestLendChain(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"identity": {{Filter: "identity"}, {Filter: "identity"}},
+		"hex":      {{Filter: "hex"}},
+	})
+	in := []byte(strings.Repeat("zero copy ", 100))
+	f, err := s.Resolve("identity", &lenderSource{b: in})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	// The chain lends the slices of the source, through the identity links.
+	l := iofl.Lend(f, 0)
+	for off := 0; off < len(in); {
+		p, err := l.Next(64)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if &p[0] != &in[off] {
+			t.Fatalf("slice at %d is not lent from the source", off)
+		}
+		off += len(p)
+	}
+	if _, err := l.Next(0); err != io.EOF {
+		t.Errorf("got %v, want EOF", err)
+	}
+
+	// A chain that alters the output is buffered.
+	f, err = s.Resolve("hex", &lenderSource{b: []byte("abc")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	out, err := lendAll(iofl.Lend(f, 4), 0)
+	if err != nil || out != "616263" {
+		t.Errorf("got %q, %v", out, err)
+	}
+}
@@ -0,0 +1,86 @@
+package iofl
+
+import (
+	"context"
+	"errors"
+	"io"
+	"sync"
+)
+
+// TooManyInstances is returned by Resolve when a chain has reached its limit
+// of open instances, and the limit does not wait.
+var TooManyInstances = errors.New("too many instances")
+
+// ChainLimit limits the number of instances of a chain that may be open at
+// once, and the memory used by each instance. An instance is open from when it
+// is resolved until it is closed.
+type ChainLimit struct {
+	// Max is the maximum number of open instances. If less than 1, the number
+	// is not limited.
+	Max int
+	// Wait causes Resolve to block until an instance is closed when the limit
+	// is reached. The wait is bounded by the context given with the Cancel
+	// option, if any. Otherwise, Resolve returns TooManyInstances.
+	Wait bool
+	// MaxMemory is the maximum number of bytes of buffer memory that may be
+	// reserved by the filters of an instance through its MemoryBudget. If
+	// less than 1, memory is not limited.
+	MaxMemory int64
+}
+
+// chainLimiter enforces a ChainLimit.
+type chainLimiter struct {
+	limit ChainLimit
+	slots chan struct{}
+}
+
+func newChainLimiter(limit ChainLimit) *chainLimiter {
+	l := &chainLimiter{limit: limit}
+	if limit.Max > 0 {
+		l.slots = make(chan struct{}, limit.Max)
+	}
+	return l
+}
+
+// acquire reserves an instance, returning a function that releases it. A nil
+// chainLimiter, or one without a maximum, does not limit.
+func (l *chainLimiter) acquire(ctx context.Context) (release func(), err error) {
+	if l == nil || l.slots == nil {
+		return func() {}, nil
+	}
+	release = func() { <-l.slots }
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	default:
+	}
+	if !l.limit.Wait {
+		return nil, TooManyInstances
+	}
+	var done <-chan struct{}
+	if ctx != nil {
+		done = ctx.Done()
+	}
+	select {
+	case l.slots <- struct{}{}:
+		return release, nil
+	case <-done:
+		return nil, ctx.Err()
+	}
+}
+
+// releaseFilter releases an instance of a limited chain when closed.
+type releaseFilter struct {
+	f       Filter
+	release func()
+	once    sync.Once
+}
+
+func (r *releaseFilter) Read(p []byte) (n int, err error) { return r.f.Read(p) }
+func (r *releaseFilter) Source() io.ReadCloser            { return r.f }
+
+func (r *releaseFilter) Close() error {
+	err := r.f.Close()
+	r.once.Do(r.release)
+	return err
+}
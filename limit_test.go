@@ -0,0 +1,126 @@
+package iofl_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// limitSet returns a ChainSet with a chain "c" limited by limit, and a chain
+// "fail" whose construction fails.
+func limitSet(t *testing.T, limit iofl.ChainLimit) *iofl.ChainSet {
+	t.Helper()
+	failDef := iofl.FilterDef{
+		Name: "fail",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return nil, errors.New("cannot construct")
+		},
+	}
+	s := newChainSet(t, nil, failDef)
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"c":    {{Filter: "translate"}},
+			"fail": {{Filter: "fail"}},
+		},
+		Limits: map[string]iofl.ChainLimit{"c": limit, "fail": limit},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestChainLimit(t *testing.T) {
+	s := limitSet(t, iofl.ChainLimit{Max: 2})
+	a, err := s.Resolve("c", source("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := s.Resolve("c", source("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve("c", source("c")); !errors.Is(err, iofl.TooManyInstances) {
+		t.Fatalf("got %v, want TooManyInstances", err)
+	}
+	// Closing twice releases the instance once.
+	a.Close()
+	a.Close()
+	c, err := s.Resolve("c", source("c"))
+	if err != nil {
+		t.Fatalf("after close: %v", err)
+	}
+	if got := readAll(t, c); got != "c" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := s.Resolve("c", source("d")); err != nil {
+		t.Errorf("after second close: %v", err)
+	}
+	if _, err := s.Resolve("c", source("e")); !errors.Is(err, iofl.TooManyInstances) {
+		t.Errorf("got %v, want TooManyInstances", err)
+	}
+	b.Close()
+
+	if got := s.Config().Limits["c"]; got != (iofl.ChainLimit{Max: 2}) {
+		t.Errorf("got config limit %+v", got)
+	}
+}
+
+func TestChainLimitFailedResolve(t *testing.T) {
+	s := limitSet(t, iofl.ChainLimit{Max: 1})
+	for i := 0; i < 3; i++ {
+		if _, err := s.Resolve("fail", source("")); err == nil || errors.Is(err, iofl.TooManyInstances) {
+			t.Fatalf("%d: got %v, want construction error", i, err)
+		}
+	}
+}
+
+func TestChainLimitWait(t *testing.T) {
+	s := limitSet(t, iofl.ChainLimit{Max: 1, Wait: true})
+	a, err := s.Resolve("c", source("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error)
+	go func() {
+		b, err := s.Resolve("c", source("b"))
+		if err == nil {
+			b.Close()
+		}
+		done <- err
+	}()
+	select {
+	case err := <-done:
+		t.Fatalf("Resolve did not wait: %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+	a.Close()
+	if err := <-done; err != nil {
+		t.Errorf("after close: %v", err)
+	}
+
+	// The wait is bounded by the context of the Cancel option.
+	a, err = s.Resolve("c", source("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer a.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, err := s.Resolve("c", source("b"), iofl.Cancel(ctx)); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("got %v, want context.DeadlineExceeded", err)
+	}
+}
+
+func TestChainLimitUnlimited(t *testing.T) {
+	s := limitSet(t, iofl.ChainLimit{})
+	for i := 0; i < 10; i++ {
+		if _, err := s.Resolve("c", source("")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
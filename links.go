@@ -0,0 +1,79 @@
+package iofl
+
+import "io"
+
+// LinkInfo describes a link of a resolved chain.
+type LinkInfo struct {
+	// Link identifies the link, including its definition as configured.
+	Link Link
+	// Params are the parameters with which the filter of the link was
+	// constructed, after applying overrides and variables, and excluding
+	// meta-parameters.
+	Params Params
+	// Filter is the Filter produced by the filter definition of the link,
+	// without the wrappers applied by the ChainSet or by options.
+	Filter Filter
+}
+
+// ResolvedChain is implemented by a Filter produced by Resolve, and by the
+// other methods of ChainSet that resolve a chain, allowing the chain that
+// produced the Filter to be inspected for debugging and tooling. It does not
+// affect the capabilities of the chain, such as whether it produces frames, or
+// whether it can be checkpointed or pooled.
+type ResolvedChain interface {
+	Filter
+	// Chain returns the name of the resolved chain. Returns an empty string
+	// for a chain resolved with ResolveChain.
+	Chain() string
+	// Links returns each link of the chain, in order, after expanding
+	// references to other chains.
+	Links() []LinkInfo
+}
+
+// resolved returns a ResolvedChain that wraps f.
+func resolved(chain string, links []LinkInfo, f Filter) Filter {
+	r := &resolvedFilter{f: f, chain: chain, links: links}
+	if fr, ok := f.(Framer); ok {
+		return resolvedFramer{resolvedFilter: r, fr: fr}
+	}
+	return r
+}
+
+// resolvedFilter implements ResolvedChain.
+type resolvedFilter struct {
+	f     Filter
+	chain string
+	links []LinkInfo
+}
+
+func (r *resolvedFilter) Read(p []byte) (n int, err error) { return r.f.Read(p) }
+func (r *resolvedFilter) Close() error                     { return r.f.Close() }
+func (r *resolvedFilter) Source() io.ReadCloser            { return r.f }
+func (r *resolvedFilter) Chain() string                    { return r.chain }
+
+func (r *resolvedFilter) Links() []LinkInfo {
+	links := make([]LinkInfo, len(r.links))
+	copy(links, r.links)
+	return links
+}
+
+// PreservesSize implements SizePreserver, returning true.
+func (r *resolvedFilter) PreservesSize() bool { return true }
+
+// resolvedFramer is a resolvedFilter over a Framer, preserving the ability of
+// the caller to read frames.
+type resolvedFramer struct {
+	*resolvedFilter
+	fr Framer
+}
+
+func (r resolvedFramer) ReadFrame() ([]byte, error) { return r.fr.ReadFrame() }
+
+// isResolved returns whether r is a wrapper produced by resolved.
+func isResolved(r io.ReadCloser) bool {
+	switch r.(type) {
+	case *resolvedFilter, resolvedFramer:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,130 @@
+package iofl_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestResolvedChain(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"c": {
+			{Chain: "upper"},
+			{Filter: "identity", Params: iofl.Params{"#buffer": 16.0}},
+			{Filter: "percent", Params: iofl.Params{"mode": "encode"}},
+		},
+	})
+	f, err := s.Resolve("c", source("a b"), iofl.Instrument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok := f.(iofl.ResolvedChain)
+	if !ok {
+		t.Fatal("resolved filter is not a ResolvedChain")
+	}
+	if r.Chain() != "c" {
+		t.Errorf("got chain %q", r.Chain())
+	}
+	links := r.Links()
+	want := []struct {
+		chain, filter string
+		index         int
+		params        iofl.Params
+	}{
+		{"upper", "translate", 0, iofl.Params{"preset": "upper"}},
+		{"c", "identity", 1, iofl.Params{}},
+		{"c", "percent", 2, iofl.Params{"mode": "encode"}},
+	}
+	if len(links) != len(want) {
+		t.Fatalf("got %d links, want %d", len(links), len(want))
+	}
+	for i, w := range want {
+		l := links[i]
+		if l.Link.Chain != w.chain || l.Link.Index != w.index || l.Link.Def.Filter != w.filter {
+			t.Errorf("link %d: got %+v", i, l.Link)
+		}
+		if len(l.Params) != len(w.params) || (len(w.params) > 0 && !reflect.DeepEqual(l.Params, w.params)) {
+			t.Errorf("link %d: got params %v, want %v", i, l.Params, w.params)
+		}
+		if l.Filter == nil {
+			t.Errorf("link %d: no filter", i)
+		}
+	}
+	// The filters are those produced by the links, without the wrappers
+	// applied by options.
+	if w := f.Source().(iofl.Filter); w == links[2].Filter || w.Source() != links[2].Filter {
+		t.Error("filter of link is wrapped")
+	}
+
+	// The returned links are a copy.
+	links[0].Link.Chain = "changed"
+	if r.Links()[0].Link.Chain != "upper" {
+		t.Error("links of the chain were modified")
+	}
+	if got := readAll(t, f); got != "A%20B" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestResolvedChainUnnamed(t *testing.T) {
+	s := newChainSet(t, nil)
+	f, err := s.ResolveChain(iofl.Chain{{Filter: "translate"}}, source("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := f.(iofl.ResolvedChain)
+	if r.Chain() != "" || len(r.Links()) != 1 || r.Links()[0].Link.Def.Filter != "translate" {
+		t.Errorf("got chain %q with links %+v", r.Chain(), r.Links())
+	}
+	if got := readAll(t, f); got != "x" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestResolvedChainFramer(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"framed": {{Filter: "gzip"}},
+		"plain":  {{Filter: "translate"}},
+	})
+	in := append(gzipBytes(t, []byte("one")), gzipBytes(t, []byte("two"))...)
+	f, err := s.Resolve("framed", ioutil.NopCloser(bytes.NewReader(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fr, ok := f.(iofl.Framer)
+	if !ok {
+		t.Fatal("resolved chain is not a Framer")
+	}
+	if _, ok := f.(iofl.ResolvedChain); !ok {
+		t.Fatal("framed chain is not a ResolvedChain")
+	}
+	var frames []string
+	for {
+		b, err := fr.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, string(b))
+	}
+	if !reflect.DeepEqual(frames, []string{"one", "two"}) {
+		t.Errorf("got frames %q", frames)
+	}
+
+	// A chain that does not produce frames does not become a Framer.
+	f, err = s.Resolve("plain", source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, ok := f.(iofl.Framer); ok {
+		t.Error("unframed chain is a Framer")
+	}
+}
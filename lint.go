@@ -0,0 +1,173 @@
+package iofl
+
+import (
+	"fmt"
+	"sort"
+)
+
+// Trait describes a property of a filter that is significant to the
+// arrangement of a chain. Traits are used by Lint.
+type Trait uint
+
+const (
+	// Compresses indicates that a filter compresses its source.
+	Compresses Trait = 1 << iota
+	// Decompresses indicates that a filter decompresses its source.
+	Decompresses
+	// Encrypts indicates that a filter encrypts its source.
+	Encrypts
+	// Decrypts indicates that a filter decrypts its source.
+	Decrypts
+	// BuffersAll indicates that a filter may buffer its entire source in
+	// memory.
+	BuffersAll
+)
+
+// Has returns whether t includes each trait of u.
+func (t Trait) Has(u Trait) bool {
+	return t&u == u
+}
+
+// Warning describes a suspicious pattern found by Lint.
+type Warning struct {
+	// Chain is the name of the chain containing the pattern.
+	Chain string
+	// Index is the position of the offending link within the chain, or -1 if
+	// the warning applies to the chain as a whole.
+	Index int
+	// Message describes the pattern.
+	Message string
+}
+
+func (w Warning) String() string {
+	if w.Index < 0 {
+		return fmt.Sprintf("%s: %s", w.Chain, w.Message)
+	}
+	return fmt.Sprintf("%s[%d]: %s", w.Chain, w.Index, w.Message)
+}
+
+// Lint examines config for patterns that are valid, but likely to be mistakes,
+// using the Traits and Params of filters to interpret each link. The following
+// patterns are reported:
+//
+//   - A chain that compresses content that it has already compressed.
+//   - A chain that compresses content after encrypting it, which gains
+//     nothing, as encrypted content does not compress.
+//   - A link with a #limit meta-parameter after a link that buffers its entire
+//     source, which the limit cannot bound.
+//   - A chain with no links.
+//   - A parameter that refers to a chain that is not defined.
+//   - A limit or test vector for a chain that is not defined.
+//
+// Links using filters not in filters are ignored. Warnings are ordered by
+// chain and index.
+func Lint(config Config, filters []FilterDef) []Warning {
+	defs := make(map[string]FilterDef, len(filters))
+	for _, def := range filters {
+		defs[def.Name] = def
+	}
+	names := make([]string, 0, len(config.Chains))
+	for name := range config.Chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var warnings []Warning
+	warn := func(chain string, index int, format string, v ...interface{}) {
+		warnings = append(warnings, Warning{Chain: chain, Index: index, Message: fmt.Sprintf(format, v...)})
+	}
+	for _, name := range names {
+		chain := config.Chains[name]
+		if len(chain) == 0 {
+			warn(name, -1, "chain has no links")
+		}
+		compressed, encrypted, buffered := -1, -1, -1
+		for i, link := range chain {
+			def, ok := defs[link.Filter]
+			if !ok {
+				continue
+			}
+			params, meta := splitMeta(link.Params)
+			if _, ok := meta["#limit"]; ok && buffered >= 0 {
+				warn(name, i, "#limit follows link %d, which buffers its entire source", buffered)
+			}
+			for _, p := range def.Params {
+				if !p.Chain {
+					continue
+				}
+				for _, ref := range chainRefs(params[p.Name]) {
+					if _, ok := config.Chains[ref]; !ok && ref != "" {
+						warn(name, i, "%s: undefined chain %q", p.Name, ref)
+					}
+				}
+			}
+			var traits Trait
+			if def.Traits != nil {
+				traits = def.Traits(params)
+			}
+			if traits.Has(Compresses) {
+				if encrypted >= 0 {
+					warn(name, i, "compresses content encrypted by link %d", encrypted)
+				} else if compressed >= 0 {
+					warn(name, i, "compresses content compressed by link %d", compressed)
+				}
+				compressed = i
+			}
+			if traits.Has(Decompresses) {
+				compressed = -1
+			}
+			if traits.Has(Encrypts) {
+				encrypted = i
+			}
+			if traits.Has(Decrypts) {
+				encrypted = -1
+			}
+			if traits.Has(BuffersAll) {
+				buffered = i
+			}
+		}
+	}
+	for name := range config.Limits {
+		if _, ok := config.Chains[name]; !ok {
+			warn(name, -1, "limit for undefined chain")
+		}
+	}
+	for name := range config.Vars {
+		if _, ok := config.Chains[name]; !ok {
+			warn(name, -1, "variables for undefined chain")
+		}
+	}
+	for name := range config.Tests {
+		if _, ok := config.Chains[name]; !ok {
+			warn(name, -1, "test vectors for undefined chain")
+		}
+	}
+	sort.Slice(warnings, func(i, j int) bool {
+		if warnings[i].Chain != warnings[j].Chain {
+			return warnings[i].Chain < warnings[j].Chain
+		}
+		if warnings[i].Index != warnings[j].Index {
+			return warnings[i].Index < warnings[j].Index
+		}
+		return warnings[i].Message < warnings[j].Message
+	})
+	return warnings
+}
+
+// chainRefs returns the chain names referred to by a parameter value, which is
+// either a string or a list of strings.
+func chainRefs(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		refs := make([]string, 0, len(v))
+		for _, e := range v {
+			if s, ok := e.(string); ok {
+				refs = append(refs, s)
+			}
+		}
+		return refs
+	}
+	return nil
+}
@@ -0,0 +1,82 @@
+package iofl_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// traitDef returns a FilterDef with the given traits.
+func traitDef(name string, traits iofl.Trait) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name:   name,
+		Traits: func(iofl.Params) iofl.Trait { return traits },
+	}
+}
+
+func TestLint(t *testing.T) {
+	defs := []iofl.FilterDef{
+		traitDef("zip", iofl.Compresses),
+		traitDef("unzip", iofl.Decompresses),
+		traitDef("seal", iofl.Encrypts),
+		traitDef("open", iofl.Decrypts),
+		traitDef("slurp", iofl.BuffersAll),
+		{Name: "plain"},
+		{Name: "route", Params: []iofl.ParamDef{{Name: "to", Chain: true}}},
+	}
+	config := iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"double":     {{Filter: "zip"}, {Filter: "plain"}, {Filter: "zip"}},
+			"recompress": {{Filter: "zip"}, {Filter: "unzip"}, {Filter: "zip"}},
+			"sealed":     {{Filter: "seal"}, {Filter: "zip"}},
+			"opened":     {{Filter: "seal"}, {Filter: "open"}, {Filter: "zip"}},
+			"buffered":   {{Filter: "plain", Params: iofl.Params{"#limit": 10.0}}, {Filter: "slurp"}, {Filter: "plain", Params: iofl.Params{"#limit": 10.0}}},
+			"empty":      {},
+			"routes":     {{Filter: "route", Params: iofl.Params{"to": []interface{}{"empty", "gone"}}}, {Filter: "route", Params: iofl.Params{"to": "lost"}}},
+			"unknown":    {{Filter: "missing"}, {Filter: "missing"}},
+		},
+		Limits: map[string]iofl.ChainLimit{"nolimit": {Max: 1}},
+		Tests:  map[string][]iofl.TestVector{"notest": {{}}},
+		Vars:   map[string][]iofl.VarDef{"novars": {{Name: "v"}}},
+	}
+	want := []string{
+		"buffered[2]: #limit follows link 1, which buffers its entire source",
+		"double[2]: compresses content compressed by link 0",
+		"empty: chain has no links",
+		"nolimit: limit for undefined chain",
+		"notest: test vectors for undefined chain",
+		"novars: variables for undefined chain",
+		"routes[0]: to: undefined chain \"gone\"",
+		"routes[1]: to: undefined chain \"lost\"",
+		"sealed[1]: compresses content encrypted by link 0",
+	}
+	var got []string
+	for _, w := range iofl.Lint(config, defs) {
+		got = append(got, w.String())
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("got warnings:\n%q\nwant:\n%q", got, want)
+	}
+	if w := iofl.Lint(iofl.Config{}, defs); len(w) != 0 {
+		t.Errorf("empty config: got %v", w)
+	}
+}
+
+func TestTraitHas(t *testing.T) {
+	traits := iofl.Compresses | iofl.BuffersAll
+	for _, tt := range []struct {
+		u    iofl.Trait
+		want bool
+	}{
+		{0, true},
+		{iofl.Compresses, true},
+		{iofl.Compresses | iofl.BuffersAll, true},
+		{iofl.Encrypts, false},
+		{iofl.Compresses | iofl.Encrypts, false},
+	} {
+		if got := traits.Has(tt.u); got != tt.want {
+			t.Errorf("Has(%d): got %v, want %v", tt.u, got, tt.want)
+		}
+	}
+}
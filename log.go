@@ -0,0 +1,25 @@
+package iofl
+
+// Logger receives messages about events within a ChainSet. *log.Logger
+// satisfies Logger.
+type Logger interface {
+	Printf(format string, v ...interface{})
+}
+
+// SetLogger sets the Logger that receives messages from the ChainSet. If l is
+// nil, messages are discarded.
+func (s *ChainSet) SetLogger(l Logger) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.logger = l
+}
+
+// logf writes a message to the ChainSet's Logger, if any.
+func (s *ChainSet) logf(format string, v ...interface{}) {
+	s.mu.RLock()
+	logger := s.logger
+	s.mu.RUnlock()
+	if logger != nil {
+		logger.Printf(format, v...)
+	}
+}
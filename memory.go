@@ -0,0 +1,118 @@
+package iofl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"sync/atomic"
+)
+
+// MemoryUser is implemented by a Filter or WriteFilter that holds buffer
+// memory.
+type MemoryUser interface {
+	// MemoryUsage returns the approximate number of bytes of buffer memory
+	// currently held by the filter, not including its source or sink.
+	MemoryUsage() int
+}
+
+// MemoryUsage returns the approximate number of bytes of buffer memory held by
+// the chain of r, by summing the usage of each filter that implements
+// MemoryUser.
+func MemoryUsage(r io.ReadCloser) (n int) {
+	Apply(r, func(r io.ReadCloser) error {
+		if m, ok := r.(MemoryUser); ok {
+			n += m.MemoryUsage()
+		}
+		return nil
+	})
+	return n
+}
+
+// WriterMemoryUsage returns the approximate number of bytes of buffer memory
+// held by the chain of w, by summing the usage of each filter that implements
+// MemoryUser.
+func WriterMemoryUsage(w io.WriteCloser) (n int) {
+	ApplyWriter(w, func(w io.WriteCloser) error {
+		if m, ok := w.(MemoryUser); ok {
+			n += m.MemoryUsage()
+		}
+		return nil
+	})
+	return n
+}
+
+// MemoryExceeded is returned when a filter requests buffer memory beyond the
+// memory budget of its chain.
+var MemoryExceeded = errors.New("memory budget exceeded")
+
+// MemoryBudget tracks the buffer memory allocated by the filters of an
+// instance of a chain, as limited by the MaxMemory field of ChainLimit.
+// Filters request memory before allocating it, so that a chain fails with
+// MemoryExceeded instead of exhausting the memory of the process. A nil
+// *MemoryBudget does not limit. MemoryBudget is safe for concurrent use.
+type MemoryBudget struct {
+	chain string
+	max   int64
+	used  int64
+}
+
+// Alloc reserves n bytes from the budget. Returns an error wrapping
+// MemoryExceeded if the reservation would exceed the budget, in which case
+// nothing is reserved.
+func (b *MemoryBudget) Alloc(n int) error {
+	if b == nil || n <= 0 {
+		return nil
+	}
+	if used := atomic.AddInt64(&b.used, int64(n)); used > b.max {
+		atomic.AddInt64(&b.used, -int64(n))
+		return fmt.Errorf("%w: chain %q requested %d bytes with %d of %d in use", MemoryExceeded, b.chain, n, used-int64(n), b.max)
+	}
+	return nil
+}
+
+// Free returns n bytes to the budget.
+func (b *MemoryBudget) Free(n int) {
+	if b == nil || n <= 0 {
+		return
+	}
+	atomic.AddInt64(&b.used, -int64(n))
+}
+
+// Resize adjusts the reservation of a buffer that is reallocated from size
+// from to size to. If the budget is exceeded, the original reservation is
+// retained.
+func (b *MemoryBudget) Resize(from, to int) error {
+	if to > from {
+		return b.Alloc(to - from)
+	}
+	b.Free(from - to)
+	return nil
+}
+
+// Used returns the number of bytes reserved from the budget.
+func (b *MemoryBudget) Used() int64 {
+	if b == nil {
+		return 0
+	}
+	return atomic.LoadInt64(&b.used)
+}
+
+// BudgetUser is implemented by a Filter that allocates buffer memory through
+// a MemoryBudget. A Filter produced by a decorator, such as with Pipelined,
+// may also implement BudgetUser. Buffers placed by the #buffer meta-parameter
+// are reserved from the budget of the chain as well.
+type BudgetUser interface {
+	// SetMemoryBudget sets the budget from which the filter reserves memory.
+	// Called before the first Read, and possibly more than once with the same
+	// budget.
+	SetMemoryBudget(b *MemoryBudget)
+}
+
+// newMemoryBudget returns the budget for an instance of the chain of the given
+// name, or nil if the limiter has no memory limit. A nil limiter has no limit.
+func (l *chainLimiter) newMemoryBudget(chain string) *MemoryBudget {
+	if l == nil || l.limit.MaxMemory <= 0 {
+		return nil
+	}
+	return &MemoryBudget{chain: chain, max: l.limit.MaxMemory}
+}
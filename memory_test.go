@@ -0,0 +1,240 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestMemoryUsage(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"two": {
+			{Filter: "percent"},
+			{Filter: "identity"},
+			{Filter: "percent", Params: iofl.Params{"mode": "decode"}},
+		},
+	})
+	f, err := s.Resolve("two", source("a b c"), iofl.BufferSize(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := iofl.MemoryUsage(f); got != 0 {
+		t.Errorf("before Read: got %d, want 0", got)
+	}
+	var p [1]byte
+	if _, err := f.Read(p[:]); err != nil {
+		t.Fatal(err)
+	}
+	// Each percent link holds a source and destination buffer.
+	if got, want := iofl.MemoryUsage(f), 4*100; got != want {
+		t.Errorf("after Read: got %d, want %d", got, want)
+	}
+	if got := readAll(t, f); got != " b c" {
+		t.Errorf("got %q", got)
+	}
+	if got := iofl.MemoryUsage(f); got != 0 {
+		t.Errorf("after Close: got %d, want 0", got)
+	}
+	if got := iofl.MemoryUsage(nil); got != 0 {
+		t.Errorf("nil: got %d, want 0", got)
+	}
+}
+
+func TestWriterMemoryUsage(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"enc": {{Filter: "percent", Params: iofl.Params{"mode": "decode"}}},
+	})
+	var buf bytes.Buffer
+	w, err := s.ResolveWriter("enc", nopWriteCloser{&buf}, iofl.BufferSize(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := iofl.WriterMemoryUsage(w), 2*64; got != want {
+		t.Errorf("got %d, want %d", got, want)
+	}
+	if _, err := w.Write([]byte("a b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "a%20b" {
+		t.Errorf("got %q", buf.String())
+	}
+}
+
+// budgetFilter is a Filter that records its MemoryBudget.
+type budgetFilter struct {
+	funcFilter
+	budget *iofl.MemoryBudget
+}
+
+func (f *budgetFilter) SetMemoryBudget(b *iofl.MemoryBudget) { f.budget = b }
+
+func TestMaxMemory(t *testing.T) {
+	var last *budgetFilter
+	s := newChainSet(t, nil, iofl.FilterDef{
+		Name: "budget",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			last = &budgetFilter{funcFilter: funcFilter{src: r, read: r.Read}}
+			return last, nil
+		},
+	})
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"percent":   {{Filter: "percent", Params: iofl.Params{iofl.ParamBufferSize: 512}}},
+			"buffer":    {{Filter: "translate", Params: iofl.Params{"#buffer": 2048.0}}},
+			"budget":    {{Filter: "budget"}},
+			"unlimited": {{Filter: "percent", Params: iofl.Params{iofl.ParamBufferSize: 512}}},
+		},
+		Limits: map[string]iofl.ChainLimit{
+			"percent": {MaxMemory: 1000},
+			"buffer":  {MaxMemory: 1000},
+			"budget":  {MaxMemory: 100},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The source and destination buffers of the link exceed the budget.
+	f, err := s.Resolve("percent", source("a b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ioutil.ReadAll(f); !errors.Is(err, iofl.MemoryExceeded) {
+		t.Errorf("percent: got %v, want MemoryExceeded", err)
+	}
+	f.Close()
+	if _, err := s.Resolve("buffer", source("")); !errors.Is(err, iofl.MemoryExceeded) {
+		t.Errorf("buffer: got %v, want MemoryExceeded", err)
+	}
+	f, err = s.Resolve("unlimited", source("a b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "a%20b" {
+		t.Errorf("unlimited: got %q", got)
+	}
+
+	f, err = s.Resolve("budget", source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b := last.budget
+	if b == nil {
+		t.Fatal("budget not set")
+	}
+	if err := b.Alloc(60); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Alloc(60); !errors.Is(err, iofl.MemoryExceeded) || !strings.Contains(err.Error(), `"budget"`) {
+		t.Errorf("got %v, want MemoryExceeded", err)
+	}
+	if got := b.Used(); got != 60 {
+		t.Errorf("after failed Alloc: used %d, want 60", got)
+	}
+	if err := b.Resize(60, 100); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Resize(100, 101); err == nil {
+		t.Error("expected error growing beyond budget")
+	}
+	b.Resize(100, 10)
+	b.Free(10)
+	if got := b.Used(); got != 0 {
+		t.Errorf("used %d, want 0", got)
+	}
+
+	// Allocations from concurrent filters never exceed the budget.
+	var wg sync.WaitGroup
+	var held, peak int32
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				if b.Alloc(30) != nil {
+					continue
+				}
+				n := atomic.AddInt32(&held, 1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				atomic.AddInt32(&held, -1)
+				b.Free(30)
+			}
+		}()
+	}
+	wg.Wait()
+	if peak > 3 || b.Used() != 0 {
+		t.Errorf("peak of %d allocations, used %d", peak, b.Used())
+	}
+}
+
+func TestMaxMemoryResolveError(t *testing.T) {
+	var last *budgetFilter
+	s := newChainSet(t, nil, iofl.FilterDef{
+		Name: "budget",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			last = &budgetFilter{funcFilter: funcFilter{src: r, read: r.Read}}
+			return last, nil
+		},
+	}, iofl.FilterDef{
+		Name: "broken",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return nil, errBoom
+		},
+	})
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"tee":   {{Filter: "budget"}, {Filter: "identity", Params: iofl.Params{"#buffer": 50.0, "#tee": "missing"}}},
+			"later": {{Filter: "budget"}, {Filter: "identity", Params: iofl.Params{"#buffer": 50.0}}, {Filter: "broken"}},
+		},
+		Limits: map[string]iofl.ChainLimit{
+			"tee":   {MaxMemory: 100},
+			"later": {MaxMemory: 100},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The buffer of #buffer is returned to the budget when resolving fails.
+	for _, chain := range []string{"tee", "later"} {
+		last = nil
+		if _, err := s.Resolve(chain, source("")); err == nil {
+			t.Errorf("%s: expected error", chain)
+		}
+		if last == nil || last.budget == nil {
+			t.Fatalf("%s: budget not set", chain)
+		}
+		if got := last.budget.Used(); got != 0 {
+			t.Errorf("%s: used %d, want 0", chain, got)
+		}
+	}
+}
+
+func TestMemoryBudgetNil(t *testing.T) {
+	var b *iofl.MemoryBudget
+	if err := b.Alloc(1 << 40); err != nil {
+		t.Error(err)
+	}
+	if err := b.Resize(0, 1<<40); err != nil {
+		t.Error(err)
+	}
+	b.Free(1)
+	if b.Used() != 0 {
+		t.Error("nil budget has usage")
+	}
+}
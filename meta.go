@@ -0,0 +1,360 @@
+package iofl
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// LimitExceeded is returned by a link that produces more bytes than allowed by
+// its #limit meta-parameter.
+var LimitExceeded = errors.New("limit exceeded")
+
+// TimedOut is returned by a link when a Read does not complete within the
+// duration specified by its #timeout meta-parameter.
+var TimedOut = errors.New("timed out")
+
+// MetaPrefix is the prefix of a reserved parameter key. Such parameters are
+// removed from Params before being passed to a filter, and are instead
+// implemented by the ChainSet, by decorating the Filter produced by the link.
+// The following meta-parameters are defined:
+//
+//	#outputs: An object mapping the name of a side output of the link's filter
+//	          to the name of a sink, configured with the Sink option, that
+//	          receives the side output. The filter must implement
+//	          SideOutputter.
+//	#limit:   The maximum number of bytes the link may produce. Reading beyond
+//	          the limit returns LimitExceeded.
+//	#ratio:   The maximum ratio of the number of bytes produced by the link to
+//	          the number of bytes it reads from its source, guarding against
+//	          decompression bombs. The first 64KiB of output are always
+//	          allowed. Exceeding the ratio returns RatioExceeded.
+//	#timeout: The maximum duration of a single Read from the link, as a
+//	          duration string ("5s") or a number of seconds. If a Read takes
+//	          longer, TimedOut is returned. The pending Read continues in the
+//	          background, and its result is returned by the next Read. Closing
+//	          the chain while a Read is pending closes the root of the chain
+//	          to unblock the Read.
+//	#buffer:  The size of a buffer placed after the link.
+//	#tee:     The name of a sink, configured with the Sink option, that receives
+//	          a copy of the bytes produced by the link.
+//
+// Meta-parameters are applied in the order listed.
+const MetaPrefix = "#"
+
+// Sink returns an Option that configures a named sink, which can be referred
+// to by the #tee and #outputs meta-parameters.
+func Sink(name string, w io.Writer) Option {
+	return func(o *resolveOptions) {
+		if o.sinks == nil {
+			o.sinks = map[string]io.Writer{}
+		}
+		o.sinks[name] = w
+	}
+}
+
+// splitMeta separates meta-parameters from params.
+func splitMeta(params Params) (p, meta Params) {
+	for k := range params {
+		if strings.HasPrefix(k, MetaPrefix) {
+			goto split
+		}
+	}
+	return params, nil
+split:
+	p = make(Params, len(params))
+	meta = Params{}
+	for k, v := range params {
+		if strings.HasPrefix(k, MetaPrefix) {
+			meta[k] = v
+		} else {
+			p[k] = v
+		}
+	}
+	return p, meta
+}
+
+// metaTypes are the types of the meta-parameters that have scalar values.
+var metaTypes = map[string]ParamType{
+	"#limit":   ParamInt,
+	"#ratio":   ParamNumber,
+	"#timeout": ParamDuration,
+	"#buffer":  ParamInt,
+	"#tee":     ParamString,
+}
+
+// validateMeta returns an error if meta contains an unknown or malformed
+// meta-parameter.
+func validateMeta(meta Params) error {
+	for k := range meta {
+		switch k {
+		case "#outputs", "#limit", "#ratio", "#timeout", "#buffer", "#tee":
+		default:
+			return fmt.Errorf("unknown meta-parameter %q", k)
+		}
+	}
+	if v, ok := meta["#outputs"]; ok {
+		outputs, ok := v.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("#outputs: expected object, got %T", v)
+		}
+		for name, sink := range outputs {
+			if _, ok := sink.(string); !ok {
+				return fmt.Errorf("#outputs: %s: expected sink name, got %T", name, sink)
+			}
+		}
+	}
+	for key, t := range metaTypes {
+		if v, ok := meta[key]; ok {
+			if err := t.check(v, false); err != nil {
+				return fmt.Errorf("%s: %w", key, err)
+			}
+		}
+	}
+	if _, ok := meta["#limit"]; ok {
+		if meta.GetInt("#limit") < 0 {
+			return fmt.Errorf("#limit: must be non-negative")
+		}
+	}
+	if _, ok := meta["#ratio"]; ok {
+		if meta.GetFloat64("#ratio") <= 0 {
+			return fmt.Errorf("#ratio: must be a positive number")
+		}
+	}
+	if _, ok := meta["#timeout"]; ok {
+		if meta.GetDuration("#timeout") <= 0 {
+			return fmt.Errorf("#timeout: must be positive")
+		}
+	}
+	if _, ok := meta["#buffer"]; ok {
+		if meta.GetInt("#buffer") <= 0 {
+			return fmt.Errorf("#buffer: must be positive")
+		}
+	}
+	return nil
+}
+
+// applyMeta decorates f according to the given meta-parameters, which have been
+// validated. in counts the bytes read by the link from its source, and is nil
+// if #ratio is not specified or the link has no source. The buffer of #buffer
+// is reserved from budget. #outputs is applied separately by applyOutputs.
+func (o *resolveOptions) applyMeta(meta Params, f Filter, in *countFilter, budget *MemoryBudget) (Filter, error) {
+	// The sink of #tee is located first, so that the buffer of #buffer is not
+	// left reserved when the sink is unknown.
+	var sink io.Writer
+	_, tee := meta["#tee"]
+	if tee {
+		name := meta.GetString("#tee")
+		var ok bool
+		if sink, ok = o.sinks[name]; !ok {
+			return nil, fmt.Errorf("#tee: unknown sink %q", name)
+		}
+	}
+	if _, ok := meta["#limit"]; ok {
+		f = &limitFilter{f: f, n: int64(meta.GetInt("#limit"))}
+	}
+	if _, ok := meta["#ratio"]; ok {
+		if in == nil {
+			return nil, fmt.Errorf("#ratio: link has no source")
+		}
+		f = &ratioFilter{f: f, in: in, ratio: meta.GetFloat64("#ratio")}
+	}
+	if _, ok := meta["#timeout"]; ok {
+		f = &timeoutFilter{f: f, async: asyncReader{r: f}, d: meta.GetDuration("#timeout")}
+	}
+	if _, ok := meta["#buffer"]; ok {
+		size := meta.GetInt("#buffer")
+		if err := budget.Alloc(size); err != nil {
+			return nil, fmt.Errorf("#buffer: %w", err)
+		}
+		f = &bufferFilter{r: bufio.NewReaderSize(f, size), f: f, budget: budget, reserved: size}
+	}
+	if tee {
+		f = &teeFilter{f: f, w: sink}
+	}
+	return f, nil
+}
+
+// limitFilter returns LimitExceeded when more than n bytes are read.
+type limitFilter struct {
+	f Filter
+	n int64
+}
+
+func (l *limitFilter) Read(p []byte) (n int, err error) {
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+	n, err = l.f.Read(p)
+	if int64(n) > l.n {
+		n = int(l.n)
+		err = LimitExceeded
+	}
+	l.n -= int64(n)
+	return n, err
+}
+
+func (l *limitFilter) Close() error          { return l.f.Close() }
+func (l *limitFilter) Source() io.ReadCloser { return l.f }
+
+// asyncResult is the result of a Read made by an asyncReader.
+type asyncResult struct {
+	b   []byte
+	err error
+}
+
+// asyncReader performs Reads in a separate goroutine, allowing a caller to
+// stop waiting on a Read that is blocked. Only one Read is pending at a time.
+type asyncReader struct {
+	r       io.Reader
+	pending chan asyncResult
+	scratch []byte
+	buf     []byte
+	err     error
+}
+
+// errAborted is returned by asyncReader.Read when abort is closed before the
+// pending read completes.
+var errAborted = errors.New("aborted")
+
+// Read reads from the underlying reader into p, waiting until the read
+// completes, or until abort is closed. Returns errAborted if abort was closed
+// first, in which case the pending read is continued by the next call.
+func (a *asyncReader) Read(p []byte, abort <-chan struct{}) (n int, err error) {
+	if len(a.buf) > 0 {
+		n = copy(p, a.buf)
+		a.buf = a.buf[n:]
+		if len(a.buf) == 0 && a.err != nil {
+			return n, a.err
+		}
+		return n, nil
+	}
+	if a.err != nil {
+		return 0, a.err
+	}
+	if len(p) == 0 {
+		return 0, nil
+	}
+	if a.pending == nil {
+		if cap(a.scratch) < len(p) {
+			a.scratch = make([]byte, len(p))
+		}
+		buf := a.scratch[:len(p)]
+		ch := make(chan asyncResult, 1)
+		a.pending = ch
+		go func() {
+			n, err := a.r.Read(buf)
+			ch <- asyncResult{b: buf[:n], err: err}
+		}()
+	}
+	select {
+	case res := <-a.pending:
+		a.pending = nil
+		n = copy(p, res.b)
+		a.buf = res.b[n:]
+		if res.err != nil && len(a.buf) > 0 {
+			a.err = res.err
+			return n, nil
+		}
+		return n, res.err
+	case <-abort:
+		return 0, errAborted
+	}
+}
+
+// close closes f, the Filter read by a. If a Read is pending, the root of the
+// chain of f is closed first to unblock it, and the Read is waited on, so that
+// f is not closed while it is being read. Since f closes the root again, an
+// error indicating that the root is already closed is not returned, and the
+// error of the first close of the root is returned instead.
+func (a *asyncReader) close(f Filter) error {
+	if a.pending == nil {
+		return f.Close()
+	}
+	var root io.Closer
+	Apply(f, func(r io.ReadCloser) error {
+		root = r
+		return nil
+	})
+	rerr := root.Close()
+	<-a.pending
+	a.pending = nil
+	err := f.Close()
+	if alreadyClosed(err) {
+		err = nil
+	}
+	if rerr != nil {
+		return rerr
+	}
+	return err
+}
+
+// alreadyClosed returns whether err indicates that a file, connection, or
+// Filter was closed more than once.
+func alreadyClosed(err error) bool {
+	return errors.Is(err, os.ErrClosed) || errors.Is(err, net.ErrClosed) || errors.Is(err, Closed)
+}
+
+// timeoutFilter returns TimedOut when a Read does not complete within d.
+type timeoutFilter struct {
+	f     Filter
+	async asyncReader
+	d     time.Duration
+}
+
+func (t *timeoutFilter) Read(p []byte) (n int, err error) {
+	abort := make(chan struct{})
+	timer := time.AfterFunc(t.d, func() { close(abort) })
+	n, err = t.async.Read(p, abort)
+	timer.Stop()
+	if err == errAborted {
+		return 0, TimedOut
+	}
+	return n, err
+}
+
+func (t *timeoutFilter) Close() error          { return t.async.close(t.f) }
+func (t *timeoutFilter) Source() io.ReadCloser { return t.f }
+
+// bufferFilter buffers reads from a Filter. The buffer is reserved from budget
+// until the filter is closed.
+type bufferFilter struct {
+	r        *bufio.Reader
+	f        Filter
+	budget   *MemoryBudget
+	reserved int
+}
+
+func (b *bufferFilter) Read(p []byte) (n int, err error) { return b.r.Read(p) }
+func (b *bufferFilter) Source() io.ReadCloser            { return b.f }
+func (b *bufferFilter) MemoryUsage() int                 { return b.r.Size() }
+
+func (b *bufferFilter) Close() error {
+	b.budget.Free(b.reserved)
+	b.reserved = 0
+	return b.f.Close()
+}
+
+// teeFilter writes bytes read from a Filter to a writer.
+type teeFilter struct {
+	f Filter
+	w io.Writer
+}
+
+func (t *teeFilter) Read(p []byte) (n int, err error) {
+	n, err = t.f.Read(p)
+	if n > 0 {
+		if _, werr := t.w.Write(p[:n]); werr != nil {
+			return n, werr
+		}
+	}
+	return n, err
+}
+
+func (t *teeFilter) Close() error          { return t.f.Close() }
+func (t *teeFilter) Source() io.ReadCloser { return t.f }
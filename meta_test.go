@@ -0,0 +1,211 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func TestMetaLimit(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"under": {{Filter: "translate", Params: iofl.Params{"#limit": 6}}},
+		"over":  {{Filter: "translate", Params: iofl.Params{"#limit": 4}}},
+	})
+	f, err := s.Resolve("under", source("banana"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "banana" {
+		t.Errorf("under: got %q", got)
+	}
+
+	f, err = s.Resolve("over", source("banana"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := ioutil.ReadAll(f)
+	f.Close()
+	if !errors.Is(err, iofl.LimitExceeded) {
+		t.Errorf("over: got error %v, want LimitExceeded", err)
+	}
+	if string(b) != "bana" {
+		t.Errorf("over: got %q, want %q", b, "bana")
+	}
+}
+
+func TestMetaTimeout(t *testing.T) {
+	release := make(chan struct{})
+	s := newChainSet(t, map[string]iofl.Chain{
+		"slow": {{Filter: "slow", Params: iofl.Params{"#timeout": "10ms"}}},
+	}, hookFilter("slow", func() { <-release }))
+	f, err := s.Resolve("slow", source("data"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	p := make([]byte, 8)
+	if _, err := f.Read(p); !errors.Is(err, iofl.TimedOut) {
+		t.Fatalf("got error %v, want TimedOut", err)
+	}
+	close(release)
+	// The pending read completes, and its result is returned next.
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		n, err := f.Read(p)
+		if err == nil {
+			if string(p[:n]) != "data" {
+				t.Errorf("got %q, want %q", p[:n], "data")
+			}
+			break
+		}
+		if !errors.Is(err, iofl.TimedOut) || time.Now().After(deadline) {
+			t.Fatalf("got error %v", err)
+		}
+	}
+}
+
+func TestMetaBuffer(t *testing.T) {
+	var reads int
+	s := newChainSet(t, map[string]iofl.Chain{
+		"buffered": {{Filter: "count", Params: iofl.Params{"#buffer": 4096}}},
+	}, hookFilter("count", func() { reads++ }))
+	f, err := s.Resolve("buffered", source("abcdef"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := make([]byte, 1)
+	for i := 0; i < 6; i++ {
+		if _, err := f.Read(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.Close()
+	if reads != 1 {
+		t.Errorf("link read %d times, want 1", reads)
+	}
+}
+
+func TestMetaTee(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"tee": {
+			{Filter: "translate", Params: iofl.Params{"preset": "upper", "#tee": "mid"}},
+			{Filter: "translate", Params: iofl.Params{"from": "A", "to": "4"}},
+		},
+	})
+	var mid bytes.Buffer
+	f, err := s.Resolve("tee", source("banana"), iofl.Sink("mid", &mid))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "B4N4N4" {
+		t.Errorf("got %q, want %q", got, "B4N4N4")
+	}
+	if mid.String() != "BANANA" {
+		t.Errorf("tee: got %q, want %q", mid.String(), "BANANA")
+	}
+
+	if _, err := s.Resolve("tee", source("")); err == nil {
+		t.Error("expected error for unknown sink")
+	}
+}
+
+func TestMetaValidate(t *testing.T) {
+	tests := []iofl.Params{
+		{"#unknown": 1},
+		{"#limit": -1},
+		{"#limit": "10"},
+		{"#limit": 1.5},
+		{"#ratio": 0},
+		{"#ratio": "high"},
+		{"#timeout": "soon"},
+		{"#timeout": 0},
+		{"#timeout": true},
+		{"#buffer": 0},
+		{"#tee": 1},
+		{"#outputs": "sink"},
+		{"#outputs": map[string]interface{}{"out": 1}},
+	}
+	for _, params := range tests {
+		s := iofl.NewChainSet()
+		filters.Register(s)
+		err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+			"c": {{Filter: "translate", Params: params}},
+		}})
+		if err == nil {
+			t.Errorf("%v: expected error", params)
+		}
+	}
+}
+
+func TestMetaTimeoutSeconds(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate", Params: iofl.Params{"#timeout": 1.5}}},
+	})
+	f, err := s.Resolve("c", source("ok"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ok" {
+		t.Errorf("got %q, want %q", got, "ok")
+	}
+}
+
+func TestMetaTimeoutClose(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate", Params: iofl.Params{"#timeout": "10ms"}}},
+	})
+	pr, pw := io.Pipe()
+	defer pw.Close()
+	f, err := s.Resolve("c", pr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 8)); !errors.Is(err, iofl.TimedOut) {
+		t.Fatalf("got error %v, want TimedOut", err)
+	}
+	// Closing unblocks the pending read, which must not race with Close.
+	done := make(chan struct{})
+	go func() {
+		f.Close()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Close blocked on pending read")
+	}
+}
+
+func TestMetaTimeoutCloseFile(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate", Params: iofl.Params{"#timeout": "10ms"}}},
+	})
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	f, err := s.Resolve("c", r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 8)); !errors.Is(err, iofl.TimedOut) {
+		t.Fatalf("got error %v, want TimedOut", err)
+	}
+	// The file is closed to unblock the pending read, and is not reported as
+	// closed twice.
+	if err := f.Close(); err != nil {
+		t.Errorf("got error %v, want nil", err)
+	}
+	if err := r.Close(); !errors.Is(err, os.ErrClosed) {
+		t.Errorf("file not closed: %v", err)
+	}
+}
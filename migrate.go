@@ -0,0 +1,123 @@
+package iofl
+
+import "fmt"
+
+// Migration upgrades a configuration by one version. It may modify config in
+// place.
+type Migration func(config *Config) error
+
+// RegisterMigration registers a migration that upgrades a configuration from
+// the given version to the next. The version of a ChainSet is one greater than
+// the highest version from which a migration is registered, or 1 if there are
+// no migrations. Returns an error if a migration from the version is already
+// registered, or if version is less than 1.
+func (s *ChainSet) RegisterMigration(from int, m Migration) error {
+	if from < 1 {
+		return fmt.Errorf("invalid version %d", from)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.migrations[from]; ok {
+		return fmt.Errorf("migration from version %d already registered", from)
+	}
+	if s.migrations == nil {
+		s.migrations = map[int]Migration{}
+	}
+	s.migrations[from] = m
+	return nil
+}
+
+// Version returns the current configuration version of the ChainSet.
+func (s *ChainSet) Version() int {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.version()
+}
+
+// version returns the current configuration version. s.mu must be held.
+func (s *ChainSet) version() int {
+	v := 1
+	for from := range s.migrations {
+		if from+1 > v {
+			v = from + 1
+		}
+	}
+	return v
+}
+
+// migrate upgrades config to the current version. A version of 0 is treated as
+// 1. The chains of config are copied before any migration is applied.
+func (s *ChainSet) migrate(config *Config) error {
+	current := s.Version()
+	if config.Version == 0 {
+		config.Version = 1
+	}
+	if config.Version > current {
+		return fmt.Errorf("config version %d is newer than supported version %d", config.Version, current)
+	}
+	if config.Version == current {
+		return nil
+	}
+	chains := make(map[string]Chain, len(config.Chains))
+	for name, chain := range config.Chains {
+		c := make(Chain, len(chain))
+		for i, link := range chain {
+			c[i] = LinkDef{Filter: link.Filter, Params: link.Params.copy(), Chain: link.Chain}
+		}
+		chains[name] = c
+	}
+	config.Chains = chains
+	for config.Version < current {
+		s.mu.RLock()
+		m, ok := s.migrations[config.Version]
+		s.mu.RUnlock()
+		if !ok {
+			return fmt.Errorf("no migration from config version %d", config.Version)
+		}
+		if err := m(config); err != nil {
+			return fmt.Errorf("migrate from version %d: %w", config.Version, err)
+		}
+		config.Version++
+	}
+	return nil
+}
+
+// RenameFilter returns a Migration that renames each link using the filter
+// from to use the filter to instead.
+func RenameFilter(from, to string) Migration {
+	return func(config *Config) error {
+		for _, chain := range config.Chains {
+			for i := range chain {
+				if chain[i].Filter == from {
+					chain[i].Filter = to
+				}
+			}
+		}
+		return nil
+	}
+}
+
+// RenameParam returns a Migration that renames the parameter from to to in
+// each link using the given filter. Returns an error if a link specifies both
+// parameters.
+func RenameParam(filter, from, to string) Migration {
+	return func(config *Config) error {
+		for name, chain := range config.Chains {
+			for i, link := range chain {
+				if link.Filter != filter {
+					continue
+				}
+				v, ok := link.Params[from]
+				if !ok {
+					continue
+				}
+				if _, ok := link.Params[to]; ok {
+					return &ResolveError{Chain: name, Index: i, Filter: filter, Err: fmt.Errorf("both %q and %q specified", from, to)}
+				}
+				delete(link.Params, from)
+				link.Params[to] = v
+			}
+		}
+		return nil
+	}
+}
@@ -0,0 +1,101 @@
+package iofl_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// migrationSet returns a ChainSet at version 3, where version 1 named the
+// translate filter "upcase", and version 2 named its preset param "mode".
+func migrationSet(t *testing.T) *iofl.ChainSet {
+	t.Helper()
+	s := newChainSet(t, nil)
+	if err := s.RegisterMigration(1, iofl.RenameFilter("upcase", "translate")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterMigration(2, iofl.RenameParam("translate", "mode", "preset")); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestMigrate(t *testing.T) {
+	s := migrationSet(t)
+	if v := s.Version(); v != 3 {
+		t.Fatalf("got version %d, want 3", v)
+	}
+	for _, config := range []iofl.Config{
+		{Chains: map[string]iofl.Chain{"c": {{Filter: "upcase", Params: iofl.Params{"mode": "upper"}}}}},
+		{Version: 2, Chains: map[string]iofl.Chain{"c": {{Filter: "translate", Params: iofl.Params{"mode": "upper"}}}}},
+		{Version: 3, Chains: map[string]iofl.Chain{"c": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}}}},
+	} {
+		link := config.Chains["c"][0]
+		if err := s.SetConfig(config); err != nil {
+			t.Fatalf("version %d: %v", config.Version, err)
+		}
+		f, err := s.Resolve("c", source("abc"))
+		if err != nil {
+			t.Fatalf("version %d: %v", config.Version, err)
+		}
+		if got := readAll(t, f); got != "ABC" {
+			t.Errorf("version %d: got %q", config.Version, got)
+		}
+		if config.Chains["c"][0].Filter != link.Filter || len(config.Chains["c"][0].Params) != 1 {
+			t.Errorf("version %d: migration modified the original config", config.Version)
+		}
+		if v := s.Config().Version; v != 3 {
+			t.Errorf("got config version %d, want 3", v)
+		}
+	}
+}
+
+func TestMigrateErrors(t *testing.T) {
+	s := migrationSet(t)
+	if err := s.SetConfig(iofl.Config{Version: 4}); err == nil || !strings.Contains(err.Error(), "newer") {
+		t.Errorf("newer: got %v", err)
+	}
+
+	err := s.SetConfig(iofl.Config{Version: 2, Chains: map[string]iofl.Chain{
+		"c": {{Filter: "translate"}, {Filter: "translate", Params: iofl.Params{"mode": "upper", "preset": "lower"}}},
+	}})
+	var rerr *iofl.ResolveError
+	if !errors.As(err, &rerr) || rerr.Chain != "c" || rerr.Index != 1 {
+		t.Errorf("conflict: got %v, want ResolveError at c[1]", err)
+	}
+	if rerr != nil && rerr.Filter != "translate" {
+		t.Errorf("conflict: got filter %q", rerr.Filter)
+	}
+
+	errMigrate := errors.New("cannot migrate")
+	s = newChainSet(t, nil)
+	s.RegisterMigration(1, func(*iofl.Config) error { return errMigrate })
+	s.RegisterMigration(3, iofl.RenameFilter("a", "b"))
+	if v := s.Version(); v != 4 {
+		t.Errorf("got version %d, want 4", v)
+	}
+	if err := s.SetConfig(iofl.Config{}); !errors.Is(err, errMigrate) {
+		t.Errorf("got %v, want migration error", err)
+	}
+	if err := s.SetConfig(iofl.Config{Version: 2}); err == nil || !strings.Contains(err.Error(), "no migration") {
+		t.Errorf("gap: got %v", err)
+	}
+}
+
+func TestRegisterMigration(t *testing.T) {
+	s := iofl.NewChainSet()
+	if v := s.Version(); v != 1 {
+		t.Errorf("got version %d, want 1", v)
+	}
+	if err := s.RegisterMigration(0, iofl.RenameFilter("a", "b")); err == nil {
+		t.Error("expected error for version 0")
+	}
+	if err := s.RegisterMigration(1, iofl.RenameFilter("a", "b")); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.RegisterMigration(1, iofl.RenameFilter("a", "b")); err == nil {
+		t.Error("expected error for duplicate migration")
+	}
+}
@@ -0,0 +1,111 @@
+package iofl
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Option configures the resolution of a chain.
+type Option func(*resolveOptions)
+
+// resolveOptions contains the options applied to a call to Resolve.
+type resolveOptions struct {
+	decorators []Decorator
+	finishers  []func(last Link, f Filter) Filter
+	bufferSize int
+	sinks      map[string]io.Writer
+	sched      *Scheduler
+	priority   Priority
+	ctx        context.Context
+	idle       time.Duration
+	ratio      float64
+	vars       map[string]string
+	overrides  map[int]Params
+	sparse     int
+	fallback   bool
+}
+
+func newResolveOptions(opts []Option) *resolveOptions {
+	o := &resolveOptions{}
+	for _, opt := range opts {
+		if opt != nil {
+			opt(o)
+		}
+	}
+	return o
+}
+
+// Link describes a link within a chain being resolved.
+type Link struct {
+	// Chain is the name of the chain.
+	Chain string
+	// Index is the position of the link within the chain.
+	Index int
+	// Def is the definition of the link.
+	Def LinkDef
+}
+
+// Decorator wraps the Filter f produced by a link. The returned Filter is used
+// in place of f as the source of the next link. The Source method of the
+// returned Filter should return f.
+type Decorator func(link Link, f Filter) Filter
+
+// Decorate returns an Option that applies d to the Filter produced by each link
+// of a chain. Decorators are applied in the order they are given.
+func Decorate(d Decorator) Option {
+	return func(o *resolveOptions) {
+		o.decorators = append(o.decorators, d)
+	}
+}
+
+// decorate applies each decorator to f.
+func (o *resolveOptions) decorate(link Link, f Filter, budget *MemoryBudget) Filter {
+	for _, d := range o.decorators {
+		f = d(link, f)
+		// A decorator that holds buffers reserves them from the budget of the
+		// chain, as does a filter.
+		if b, ok := f.(BudgetUser); ok && budget != nil {
+			b.SetMemoryBudget(budget)
+		}
+	}
+	return f
+}
+
+// finish applies each finisher to the Filter produced by last, the final link
+// of a chain. The Index of last is -1 if the chain has no links.
+func (o *resolveOptions) finish(last Link, f Filter) Filter {
+	for _, fn := range o.finishers {
+		f = fn(last, f)
+	}
+	return f
+}
+
+// ParamBufferSize is the name of the parameter that configures the size, in
+// bytes, of buffers held by a filter. Filters that buffer data should honor this
+// parameter.
+const ParamBufferSize = "bufferSize"
+
+// BufferSize returns an Option that sets the bufferSize parameter of each link
+// that does not already specify it.
+func BufferSize(size int) Option {
+	return func(o *resolveOptions) {
+		o.bufferSize = size
+	}
+}
+
+// params returns the parameters of def, the link at index within the expanded
+// chain, with options applied.
+func (o *resolveOptions) params(index int, def LinkDef) Params {
+	params := def.Params
+	if override, ok := o.overrides[index]; ok {
+		params = overrideParams(params, override)
+	}
+	if o.bufferSize > 0 {
+		if _, ok := params[ParamBufferSize]; !ok {
+			params = params.copy()
+			params[ParamBufferSize] = float64(o.bufferSize)
+		}
+	}
+	return params
+}
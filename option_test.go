@@ -0,0 +1,58 @@
+package iofl_test
+
+import (
+	"io"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// paramsFilter returns the definition of a filter of the given name that
+// records the params of each link.
+func paramsFilter(name string, record func(iofl.Params)) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			record(params)
+			return &funcFilter{src: r, read: r.Read}, nil
+		},
+	}
+}
+
+func TestBufferSizeOption(t *testing.T) {
+	var got []iofl.Params
+	record := paramsFilter("record", func(p iofl.Params) { got = append(got, p) })
+	chain := iofl.Chain{
+		{Filter: "record"},
+		{Filter: "record", Params: iofl.Params{iofl.ParamBufferSize: 64}},
+	}
+	s := newChainSet(t, map[string]iofl.Chain{"c": chain}, record)
+	f, err := s.Resolve("c", source("x"), iofl.BufferSize(1024))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, f)
+	if len(got) != 2 {
+		t.Fatalf("got %d links", len(got))
+	}
+	if size := got[0].GetInt(iofl.ParamBufferSize); size != 1024 {
+		t.Errorf("link 0: got bufferSize %d, want 1024", size)
+	}
+	if size := got[1].GetInt(iofl.ParamBufferSize); size != 64 {
+		t.Errorf("link 1: got bufferSize %d, want 64", size)
+	}
+	// The option does not modify the params of the configuration.
+	if _, ok := s.Config().Chains["c"][0].Params[iofl.ParamBufferSize]; ok {
+		t.Error("configuration was modified")
+	}
+
+	got = nil
+	f, err = s.Resolve("c", source("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readAll(t, f)
+	if _, ok := got[0][iofl.ParamBufferSize]; ok {
+		t.Error("bufferSize set without option")
+	}
+}
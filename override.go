@@ -0,0 +1,56 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+)
+
+// ResolveWith behaves the same as Resolve, but overrides the parameters of
+// links of the chain, as by the Overrides option. This allows a caller to
+// adjust a parameter, such as a compression level or a path, for a single
+// resolution without modifying the configuration.
+func (s *ChainSet) ResolveWith(chain string, overrides map[int]Params, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	opts = append(opts[:len(opts):len(opts)], Overrides(overrides))
+	return s.Resolve(chain, src, opts...)
+}
+
+// Overrides returns an Option that overrides the parameters of links of a
+// chain. Each key of overrides is the position of a link within the chain,
+// after references to other chains have been expanded. Each parameter of the
+// corresponding Params replaces the parameter of the same name, or removes it
+// if the value is nil. Parameters are overridden before variables are
+// expanded, so overrides may themselves contain variables. Resolving fails if a
+// position does not refer to a link of the chain.
+func Overrides(overrides map[int]Params) Option {
+	return func(o *resolveOptions) {
+		o.overrides = overrides
+	}
+}
+
+// checkOverrides returns an error if an override does not refer to one of
+// links.
+func (o *resolveOptions) checkOverrides(chain string, links []Link) error {
+	for i := range o.overrides {
+		if i < 0 || i >= len(links) {
+			return &ResolveError{Chain: chain, Index: i, Err: errors.New("override does not refer to a link")}
+		}
+	}
+	return nil
+}
+
+// overrideParams returns a copy of params with the parameters of override
+// applied.
+func overrideParams(params, override Params) Params {
+	p := make(Params, len(params)+len(override))
+	for k, v := range params {
+		p[k] = v
+	}
+	for k, v := range override {
+		if v == nil {
+			delete(p, k)
+		} else {
+			p[k] = v
+		}
+	}
+	return p
+}
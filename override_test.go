@@ -0,0 +1,105 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestResolveWith(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"c": {
+			{Chain: "upper"},
+			{Filter: "translate", Params: iofl.Params{"from": "B", "to": "X"}},
+			{Filter: "param", Params: iofl.Params{"v": "configured"}},
+		},
+		"t": {
+			{Chain: "upper"},
+			{Filter: "translate", Params: iofl.Params{"from": "B", "to": "X"}},
+		},
+	}, paramFilter("param"))
+	tests := []struct {
+		chain     string
+		overrides map[int]iofl.Params
+		want      string
+	}{
+		{"t", nil, "AXC"},
+		// Positions refer to links of the expanded chain.
+		{"t", map[int]iofl.Params{0: {"preset": "rot13"}}, "nop"},
+		{"t", map[int]iofl.Params{1: {"to": "Y"}}, "AYC"},
+		// A nil value removes the parameter.
+		{"t", map[int]iofl.Params{0: {"preset": nil}, 1: {"from": "b"}}, "aXc"},
+		{"c", map[int]iofl.Params{2: {"v": "overridden"}}, "overridden"},
+	}
+	for _, tt := range tests {
+		f, err := s.ResolveWith(tt.chain, tt.overrides, source("abc"))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.overrides, err)
+		}
+		if got := readAll(t, f); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.overrides, got, tt.want)
+		}
+	}
+	// The configuration is unchanged.
+	config := s.Config()
+	if got := config.Chains["upper"][0].Params["preset"]; got != "upper" {
+		t.Errorf("got configured preset %v", got)
+	}
+	if got := config.Chains["t"][1].Params["to"]; got != "X" {
+		t.Errorf("got configured to %v", got)
+	}
+}
+
+func TestOverridesVars(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "param", Params: iofl.Params{"v": "configured"}}},
+	}, paramFilter("param"))
+	// Overrides are applied before variables are expanded.
+	override := iofl.Overrides(map[int]iofl.Params{0: {"v": "${name}"}})
+	f, err := s.ResolveVars("c", map[string]string{"name": "expanded"}, source(""), override)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "expanded" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestOverridesErrors(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate"}, {Filter: "translate"}},
+	})
+	for _, i := range []int{-1, 2} {
+		_, err := s.ResolveWith("c", map[int]iofl.Params{i: {"preset": "upper"}}, source(""))
+		var rerr *iofl.ResolveError
+		if !errors.As(err, &rerr) || rerr.Chain != "c" || rerr.Index != i {
+			t.Errorf("%d: got %v, want ResolveError", i, err)
+		}
+	}
+	if _, err := s.ResolveWith("c", map[int]iofl.Params{0: {"preset": "bogus"}}, source("")); err == nil {
+		t.Error("expected error for invalid override")
+	}
+}
+
+func TestOverridesWriter(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "hex"}}})
+	var buf bytes.Buffer
+	// A writer applies the inverse of mode.
+	w, err := s.ResolveWriter("c", nopWriteCloser{&buf}, iofl.Overrides(map[int]iofl.Params{0: {"mode": "decode"}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("abc"))
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != "616263" {
+		t.Errorf("got %q", buf.String())
+	}
+	if _, err := s.ResolveWriter("c", nopWriteCloser{&buf}, iofl.Overrides(map[int]iofl.Params{1: nil})); err == nil {
+		t.Error("expected error for override beyond chain")
+	}
+}
@@ -0,0 +1,354 @@
+package iofl
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Decode decodes p into the struct pointed to by v, allowing a filter to
+// receive its parameters as a typed struct rather than retrieving each one.
+//
+// Each exported field of the struct receives the parameter of the same name,
+// with the first letter lowercased, such that a field named BufferSize
+// receives the "bufferSize" parameter. The name may be given with a "param"
+// struct tag, which may also specify the "required" option:
+//
+//	type config struct {
+//		Level   int           `param:"level"`
+//		Key     string        `param:"key,required"`
+//		Timeout time.Duration // Receives "timeout".
+//		Ignored string        `param:"-"`
+//	}
+//
+// A field of a struct, slice, or map type receives a map, list, or map
+// parameter, respectively, and its elements are decoded recursively. A number
+// is converted to any numeric field that can represent it exactly. A
+// time.Duration receives a string, as parsed by time.ParseDuration, or a
+// number of seconds, as with GetDuration. An
+// interface{} field receives any value. A pointer field is allocated as
+// needed. A field whose parameter is not present is left unchanged, so fields
+// may be initialized with default values before decoding.
+//
+// Decode reports each parameter that does not correspond to a field, each
+// required field whose parameter is not present, and each parameter that
+// cannot be converted to the type of its field. The ParamBufferSize parameter
+// is not reported, since it may be given to any filter. Problems are returned
+// together as Errors.
+func (p Params) Decode(v interface{}) error {
+	rv := reflect.ValueOf(v)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return errors.New("decode: expected non-nil pointer to struct")
+	}
+	var d paramDecoder
+	d.decodeStruct("", reflect.ValueOf(map[string]interface{}(p)), rv.Elem())
+	return d.errs.errorOrNil()
+}
+
+// paramDecoder accumulates the problems encountered by Decode.
+type paramDecoder struct {
+	errs Errors
+}
+
+// fail records a problem with the parameter at path.
+func (d *paramDecoder) fail(path string, format string, args ...interface{}) {
+	d.errs = append(d.errs, fmt.Errorf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+// joinPath returns the path of key within the value at path.
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+// paramName returns the name of the parameter received by field, and whether
+// it is required. Returns an empty name if the field is skipped.
+func paramName(field reflect.StructField) (name string, required bool) {
+	if field.PkgPath != "" {
+		return "", false
+	}
+	tag := field.Tag.Get("param")
+	if tag == "-" {
+		return "", false
+	}
+	if i := strings.IndexByte(tag, ','); i >= 0 {
+		required = tag[i+1:] == "required"
+		tag = tag[:i]
+	}
+	if tag != "" {
+		return tag, required
+	}
+	r, n := utf8.DecodeRuneInString(field.Name)
+	return string(unicode.ToLower(r)) + field.Name[n:], required
+}
+
+// decodeStruct decodes the map m into the struct v.
+func (d *paramDecoder) decodeStruct(path string, m reflect.Value, v reflect.Value) {
+	t := v.Type()
+	known := make(map[string]bool, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		name, required := paramName(t.Field(i))
+		if name == "" {
+			continue
+		}
+		known[name] = true
+		value := m.MapIndex(reflect.ValueOf(name).Convert(m.Type().Key()))
+		if !value.IsValid() {
+			if required {
+				d.fail(joinPath(path, name), "required")
+			}
+			continue
+		}
+		d.decode(joinPath(path, name), value.Interface(), v.Field(i))
+	}
+	keys := make([]string, 0, m.Len())
+	for _, key := range m.MapKeys() {
+		keys = append(keys, key.String())
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		if !known[key] && !(path == "" && key == ParamBufferSize) {
+			d.fail(joinPath(path, key), "unknown parameter")
+		}
+	}
+}
+
+var durationType = reflect.TypeOf(time.Duration(0))
+
+// decode decodes src into v.
+func (d *paramDecoder) decode(path string, src interface{}, v reflect.Value) {
+	if src == nil {
+		v.Set(reflect.Zero(v.Type()))
+		return
+	}
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			v.Set(reflect.New(v.Type().Elem()))
+		}
+		d.decode(path, src, v.Elem())
+		return
+	}
+	s := reflect.ValueOf(src)
+	if v.Type() == durationType {
+		str, ok := src.(string)
+		if !ok {
+			secs, ok := toFloat(s)
+			if !ok {
+				d.fail(path, "expected duration, got %s", paramKind(s))
+				return
+			}
+			v.SetInt(int64(secs * float64(time.Second)))
+			return
+		}
+		dur, err := time.ParseDuration(str)
+		if err != nil {
+			d.fail(path, "%s", err)
+			return
+		}
+		v.SetInt(int64(dur))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Interface:
+		if !s.Type().AssignableTo(v.Type()) {
+			d.fail(path, "cannot assign %s to %s", paramKind(s), v.Type())
+			return
+		}
+		v.Set(s)
+	case reflect.String:
+		if s.Kind() != reflect.String {
+			d.fail(path, "expected string, got %s", paramKind(s))
+			return
+		}
+		v.SetString(s.String())
+	case reflect.Bool:
+		if s.Kind() != reflect.Bool {
+			d.fail(path, "expected bool, got %s", paramKind(s))
+			return
+		}
+		v.SetBool(s.Bool())
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, ok := toInt(s)
+		if !ok || v.OverflowInt(n) {
+			d.fail(path, "expected %s, got %s", v.Type(), paramValue(s))
+			return
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n, ok := toUint(s)
+		if !ok || v.OverflowUint(n) {
+			d.fail(path, "expected %s, got %s", v.Type(), paramValue(s))
+			return
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		f, ok := toFloat(s)
+		if !ok || v.OverflowFloat(f) {
+			d.fail(path, "expected %s, got %s", v.Type(), paramValue(s))
+			return
+		}
+		v.SetFloat(f)
+	case reflect.Slice:
+		if s.Kind() != reflect.Slice && s.Kind() != reflect.Array {
+			d.fail(path, "expected list, got %s", paramKind(s))
+			return
+		}
+		list := reflect.MakeSlice(v.Type(), s.Len(), s.Len())
+		for i := 0; i < s.Len(); i++ {
+			d.decode(path+"["+strconv.Itoa(i)+"]", s.Index(i).Interface(), list.Index(i))
+		}
+		v.Set(list)
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			d.fail(path, "unsupported type %s", v.Type())
+			return
+		}
+		if s.Kind() != reflect.Map || s.Type().Key().Kind() != reflect.String {
+			d.fail(path, "expected map, got %s", paramKind(s))
+			return
+		}
+		m := reflect.MakeMapWithSize(v.Type(), s.Len())
+		for _, key := range s.MapKeys() {
+			elem := reflect.New(v.Type().Elem()).Elem()
+			d.decode(joinPath(path, key.String()), s.MapIndex(key).Interface(), elem)
+			m.SetMapIndex(reflect.ValueOf(key.String()).Convert(v.Type().Key()), elem)
+		}
+		v.Set(m)
+	case reflect.Struct:
+		if s.Kind() != reflect.Map || s.Type().Key().Kind() != reflect.String {
+			d.fail(path, "expected map, got %s", paramKind(s))
+			return
+		}
+		d.decodeStruct(path, s, v)
+	default:
+		d.fail(path, "unsupported type %s", v.Type())
+	}
+}
+
+// toInt converts a numeric value to an int64. Returns false if the value is
+// not a number, or cannot be represented exactly.
+func toInt(s reflect.Value) (int64, bool) {
+	switch s.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return s.Int(), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		n := s.Uint()
+		return int64(n), n <= math.MaxInt64
+	case reflect.Float32, reflect.Float64:
+		f := s.Float()
+		if f != math.Trunc(f) || f < math.MinInt64 || f >= math.MaxInt64 {
+			return 0, false
+		}
+		return int64(f), true
+	}
+	return 0, false
+}
+
+// toUint converts a numeric value to a uint64. Returns false if the value is
+// not a number, or cannot be represented exactly.
+func toUint(s reflect.Value) (uint64, bool) {
+	switch s.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n := s.Int()
+		return uint64(n), n >= 0
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return s.Uint(), true
+	case reflect.Float32, reflect.Float64:
+		f := s.Float()
+		if f != math.Trunc(f) || f < 0 || f >= math.MaxUint64 {
+			return 0, false
+		}
+		return uint64(f), true
+	}
+	return 0, false
+}
+
+// toFloat converts a numeric value to a float64. Returns false if the value is
+// not a number.
+func toFloat(s reflect.Value) (float64, bool) {
+	switch s.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(s.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return float64(s.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return s.Float(), true
+	}
+	return 0, false
+}
+
+// paramKind describes the kind of a parameter value in an error message.
+func paramKind(s reflect.Value) string {
+	switch s.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64:
+		return "number"
+	case reflect.Slice, reflect.Array:
+		return "list"
+	case reflect.Map:
+		return "map"
+	}
+	return s.Kind().String()
+}
+
+// paramValue describes a parameter value in an error message, including the
+// value of a number.
+func paramValue(s reflect.Value) string {
+	if paramKind(s) == "number" {
+		return fmt.Sprintf("%v", s.Interface())
+	}
+	return paramKind(s)
+}
+
+// byteUnits maps a unit of size to its number of bytes.
+var byteUnits = map[string]float64{
+	"":    1,
+	"B":   1,
+	"KB":  1e3,
+	"MB":  1e6,
+	"GB":  1e9,
+	"TB":  1e12,
+	"PB":  1e15,
+	"EB":  1e18,
+	"KiB": 1 << 10,
+	"MiB": 1 << 20,
+	"GiB": 1 << 30,
+	"TiB": 1 << 40,
+	"PiB": 1 << 50,
+	"EiB": 1 << 60,
+}
+
+// parseByteSize parses a size, such as "64KiB", as described by
+// Params.GetByteSize.
+func parseByteSize(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+	i := strings.IndexFunc(s, func(r rune) bool {
+		return !('0' <= r && r <= '9' || r == '.')
+	})
+	if i < 0 {
+		i = len(s)
+	}
+	f, err := strconv.ParseFloat(s[:i], 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid size %q", s)
+	}
+	unit, ok := byteUnits[strings.TrimSpace(s[i:])]
+	if !ok {
+		return 0, fmt.Errorf("invalid size %q: unknown unit %q", s, s[i:])
+	}
+	n := f * unit
+	if n >= math.MaxInt64 {
+		return 0, fmt.Errorf("invalid size %q: out of range", s)
+	}
+	return int64(n), nil
+}
@@ -0,0 +1,224 @@
+package iofl_test
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+type decodeInner struct {
+	Name  string
+	Count uint8
+}
+
+type decodeTarget struct {
+	Level    int           `param:"level"`
+	Key      string        `param:"key,required"`
+	Timeout  time.Duration // Receives "timeout".
+	Ignored  string        `param:"-"`
+	Ratio    float32
+	Enabled  bool
+	Default  string
+	Any      interface{}
+	Ptr      *int
+	List     []int64
+	Names    map[string]string
+	Inner    decodeInner
+	Inners   []decodeInner
+	NilSlice []string
+	private  int
+}
+
+func TestParamsDecode(t *testing.T) {
+	params := iofl.Params{
+		"level":              9.0,
+		"key":                "k",
+		"timeout":            "1m30s",
+		"ratio":              0.5,
+		"enabled":            true,
+		"any":                []interface{}{"x", 1.0},
+		"ptr":                3.0,
+		"list":               []interface{}{1.0, -2.0},
+		"names":              map[string]interface{}{"a": "b"},
+		"inner":              map[string]interface{}{"name": "n", "count": 255.0},
+		"inners":             []interface{}{map[string]interface{}{"name": "m"}},
+		"nilSlice":           nil,
+		iofl.ParamBufferSize: 1024.0,
+	}
+	v := decodeTarget{Default: "unchanged", NilSlice: []string{"set"}}
+	if err := params.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	three := 3
+	want := decodeTarget{
+		Level:   9,
+		Key:     "k",
+		Timeout: 90 * time.Second,
+		Ratio:   0.5,
+		Enabled: true,
+		Default: "unchanged",
+		Any:     []interface{}{"x", 1.0},
+		Ptr:     &three,
+		List:    []int64{1, -2},
+		Names:   map[string]string{"a": "b"},
+		Inner:   decodeInner{Name: "n", Count: 255},
+		Inners:  []decodeInner{{Name: "m"}},
+	}
+	if !reflect.DeepEqual(v, want) {
+		t.Errorf("got %+v\nwant %+v", v, want)
+	}
+
+	// Durations may be given as a number of seconds.
+	var d struct{ Timeout time.Duration }
+	if err := (iofl.Params{"timeout": 1.5}).Decode(&d); err != nil || d.Timeout != 1500*time.Millisecond {
+		t.Errorf("got %v, %v", d.Timeout, err)
+	}
+}
+
+func TestParamsDecodeErrors(t *testing.T) {
+	tests := []struct {
+		params iofl.Params
+		want   []string
+	}{
+		{iofl.Params{}, []string{"key: required"}},
+		{iofl.Params{"key": "k", "extra": 1.0, "inner": map[string]interface{}{"other": 1.0}}, []string{
+			"inner.other: unknown parameter",
+			"extra: unknown parameter",
+		}},
+		{iofl.Params{"key": "k", "level": 1.5}, []string{"level: expected int, got 1.5"}},
+		{iofl.Params{"key": "k", "level": "1"}, []string{"level: expected int, got string"}},
+		{iofl.Params{"key": "k", "inner": map[string]interface{}{"count": 256.0}}, []string{"inner.count: expected uint8, got 256"}},
+		{iofl.Params{"key": "k", "inner": map[string]interface{}{"count": -1.0}}, []string{"inner.count: expected uint8, got -1"}},
+		{iofl.Params{"key": 1.0, "enabled": "yes"}, []string{
+			"key: expected string, got number",
+			"enabled: expected bool, got string",
+		}},
+		{iofl.Params{"key": "k", "timeout": "soon"}, []string{"timeout: "}},
+		{iofl.Params{"key": "k", "timeout": true}, []string{"timeout: expected duration, got bool"}},
+		{iofl.Params{"key": "k", "list": []interface{}{1.0, "2"}}, []string{"list[1]: expected int64, got string"}},
+		{iofl.Params{"key": "k", "list": 1.0}, []string{"list: expected list, got number"}},
+		{iofl.Params{"key": "k", "names": []interface{}{}}, []string{"names: expected map, got list"}},
+		{iofl.Params{"key": "k", "inner": "n"}, []string{"inner: expected map, got string"}},
+		{iofl.Params{"key": "k", "ratio": "half"}, []string{"ratio: expected float32, got string"}},
+		{iofl.Params{"key": "k", "ignored": "x", "private": 1.0}, []string{
+			"ignored: unknown parameter",
+			"private: unknown parameter",
+		}},
+	}
+	for _, tt := range tests {
+		var v decodeTarget
+		err := tt.params.Decode(&v)
+		var errs iofl.Errors
+		if !errors.As(err, &errs) {
+			t.Errorf("%v: got %v, want Errors", tt.params, err)
+			continue
+		}
+		if len(errs) != len(tt.want) {
+			t.Errorf("%v: got %v, want %d errors", tt.params, err, len(tt.want))
+			continue
+		}
+		// The errors of a struct are in order of its fields, followed by its
+		// unknown parameters in order of name.
+		for i, want := range tt.want {
+			if !strings.HasPrefix(errs[i].Error(), want) {
+				t.Errorf("%v: error %d: got %q, want %q", tt.params, i, errs[i], want)
+			}
+		}
+	}
+
+	for _, v := range []interface{}{nil, decodeTarget{}, (*decodeTarget)(nil), new(int)} {
+		if err := (iofl.Params{}).Decode(v); err == nil {
+			t.Errorf("%T: expected error", v)
+		}
+	}
+	var unsupported struct {
+		C  chan int
+		IM map[int]string
+	}
+	err := (iofl.Params{"c": 1.0, "iM": map[string]interface{}{}}).Decode(&unsupported)
+	if err == nil || !strings.Contains(err.Error(), "unsupported type chan int") || !strings.Contains(err.Error(), "unsupported type map[int]string") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestParamsGet(t *testing.T) {
+	p := iofl.Params{
+		"float":   2.9,
+		"int":     -3,
+		"int64":   int64(1 << 40),
+		"uint8":   uint8(200),
+		"string":  "5s",
+		"bool":    true,
+		"seconds": 1.5,
+		"nil":     nil,
+	}
+	ints := map[string]int{"float": 2, "int": -3, "int64": 1 << 40, "uint8": 200, "string": 0, "bool": 0, "nil": 0, "missing": 0}
+	for key, want := range ints {
+		if got := p.GetInt(key); got != want {
+			t.Errorf("GetInt(%q): got %d, want %d", key, got, want)
+		}
+	}
+	floats := map[string]float64{"float": 2.9, "int": -3, "uint8": 200, "string": 0, "missing": 0}
+	for key, want := range floats {
+		if got := p.GetFloat64(key); got != want {
+			t.Errorf("GetFloat64(%q): got %v, want %v", key, got, want)
+		}
+	}
+	bools := map[string]bool{"bool": true, "string": false, "float": false, "missing": false}
+	for key, want := range bools {
+		if got := p.GetBool(key); got != want {
+			t.Errorf("GetBool(%q): got %v, want %v", key, got, want)
+		}
+	}
+	durations := map[string]time.Duration{
+		"string":  5 * time.Second,
+		"seconds": 1500 * time.Millisecond,
+		"int":     -3 * time.Second,
+		"bool":    0,
+		"missing": 0,
+	}
+	for key, want := range durations {
+		if got := p.GetDuration(key); got != want {
+			t.Errorf("GetDuration(%q): got %v, want %v", key, got, want)
+		}
+	}
+	if got := (iofl.Params{"d": "soon"}).GetDuration("d"); got != 0 {
+		t.Errorf("GetDuration of invalid string: got %v", got)
+	}
+}
+
+func TestParamsGetByteSize(t *testing.T) {
+	tests := []struct {
+		v    interface{}
+		want int64
+	}{
+		{"64KiB", 64 << 10},
+		{"1.5MB", 1500000},
+		{"10", 10},
+		{" 10 B ", 10},
+		{"2GiB", 2 << 30},
+		{"1TB", 1e12},
+		{"7EiB", 7 << 60},
+		{"0.5KiB", 512},
+		{4096.0, 4096},
+		{int64(1 << 33), 1 << 33},
+		{"8EiB", 0},
+		{"1XB", 0},
+		{"1kib", 0},
+		{"-1", 0},
+		{"", 0},
+		{"KiB", 0},
+		{1.5, 0},
+		{true, 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		if got := (iofl.Params{"size": tt.v}).GetByteSize("size"); got != tt.want {
+			t.Errorf("%#v: got %d, want %d", tt.v, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,146 @@
+package iofl
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ParamType is the type of the value of a parameter, as declared by a
+// ParamDef.
+type ParamType string
+
+const (
+	// ParamAny is any value. An empty ParamType is the same as ParamAny.
+	ParamAny ParamType = "any"
+	// ParamString is a string.
+	ParamString ParamType = "string"
+	// ParamNumber is a number.
+	ParamNumber ParamType = "number"
+	// ParamInt is a number with no fractional part.
+	ParamInt ParamType = "int"
+	// ParamBool is a boolean.
+	ParamBool ParamType = "bool"
+	// ParamList is a list of values.
+	ParamList ParamType = "list"
+	// ParamMap is a map of names to values.
+	ParamMap ParamType = "map"
+	// ParamDuration is a string, as parsed by time.ParseDuration, or a number
+	// of seconds.
+	ParamDuration ParamType = "duration"
+)
+
+// known returns whether t is a known type.
+func (t ParamType) known() bool {
+	switch t {
+	case "", ParamAny, ParamString, ParamNumber, ParamInt, ParamBool, ParamList, ParamMap, ParamDuration:
+		return true
+	}
+	return false
+}
+
+// Check returns an error if v is not of type t. It allows a filter to check
+// values nested within a parameter, such as the elements of a list, as the
+// value of a parameter is checked against its ParamDef.
+func (t ParamType) Check(v interface{}) error {
+	return t.check(v, false)
+}
+
+// check returns an error if v is not of type t. If vars is true, a string may
+// contain variable references that are yet to be expanded, so its content is
+// not checked.
+func (t ParamType) check(v interface{}, vars bool) error {
+	s := reflect.ValueOf(v)
+	ok := true
+	switch t {
+	case "", ParamAny:
+	case ParamString:
+		ok = s.Kind() == reflect.String
+	case ParamNumber:
+		_, ok = toFloat(s)
+	case ParamInt:
+		_, ok = toInt(s)
+	case ParamBool:
+		ok = s.Kind() == reflect.Bool
+	case ParamList:
+		ok = s.Kind() == reflect.Slice || s.Kind() == reflect.Array
+	case ParamMap:
+		ok = s.Kind() == reflect.Map && s.Type().Key().Kind() == reflect.String
+	case ParamDuration:
+		str, isString := v.(string)
+		if !isString {
+			_, ok = toFloat(s)
+			break
+		}
+		if vars && strings.Contains(str, "$") {
+			break
+		}
+		if _, err := time.ParseDuration(str); err != nil {
+			return err
+		}
+	}
+	if !ok {
+		if v == nil {
+			return fmt.Errorf("expected %s, got null", t)
+		}
+		return fmt.Errorf("expected %s, got %s", t, paramValue(s))
+	}
+	return nil
+}
+
+// validateParamDefs returns an error if the parameter declarations of a filter
+// are not valid.
+func validateParamDefs(defs []ParamDef) error {
+	seen := make(map[string]bool, len(defs))
+	for _, p := range defs {
+		switch {
+		case p.Name == "":
+			return errors.New("params: parameter name required")
+		case seen[p.Name]:
+			return fmt.Errorf("params: parameter %q declared more than once", p.Name)
+		case !p.Type.known():
+			return fmt.Errorf("params: parameter %q: unknown type %q", p.Name, string(p.Type))
+		}
+		seen[p.Name] = true
+	}
+	return nil
+}
+
+// checkParams returns an error for each problem with the params of a link of
+// the filter def, as described by FilterDef.Params. If vars is true, variable
+// references within params are yet to be expanded. Meta-parameters must have
+// been removed from params. params is not modified, and the Default of an
+// absent parameter is not applied.
+func checkParams(def FilterDef, params Params, vars bool) Errors {
+	var errs Errors
+	declared := make(map[string]bool, len(def.Params))
+	for _, p := range def.Params {
+		declared[p.Name] = true
+		v, ok := params[p.Name]
+		if !ok {
+			if p.Required {
+				errs = append(errs, fmt.Errorf("%s: required", p.Name))
+			}
+			continue
+		}
+		if err := p.Type.check(v, vars); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", p.Name, err))
+		}
+	}
+	if def.StrictParams {
+		var unknown []string
+		for name := range params {
+			if !declared[name] && name != ParamBufferSize {
+				unknown = append(unknown, name)
+			}
+		}
+		sort.Strings(unknown)
+		for _, name := range unknown {
+			errs = append(errs, fmt.Errorf("%s: unknown parameter", name))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,178 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestParamTypeCheck(t *testing.T) {
+	tests := []struct {
+		typ  iofl.ParamType
+		ok   []interface{}
+		fail []interface{}
+	}{
+		{"", []interface{}{nil, "s", 1.0, []interface{}{}}, nil},
+		{iofl.ParamAny, []interface{}{nil, true}, nil},
+		{iofl.ParamString, []interface{}{"", "s"}, []interface{}{nil, 1.0, true}},
+		{iofl.ParamNumber, []interface{}{1.5, 2, uint8(3)}, []interface{}{"1", nil}},
+		{iofl.ParamInt, []interface{}{1.0, -2, int64(3)}, []interface{}{1.5, "1"}},
+		{iofl.ParamBool, []interface{}{true, false}, []interface{}{"true", 1.0}},
+		{iofl.ParamList, []interface{}{[]interface{}{}, []string{"a"}, [2]int{}}, []interface{}{"a", map[string]interface{}{}}},
+		{iofl.ParamMap, []interface{}{map[string]interface{}{}, iofl.Params{}}, []interface{}{map[int]string{}, []interface{}{}}},
+		{iofl.ParamDuration, []interface{}{"1m", "0", 1.5, 2}, []interface{}{"soon", "1", true, "${d}"}},
+	}
+	for _, tt := range tests {
+		for _, v := range tt.ok {
+			if err := tt.typ.Check(v); err != nil {
+				t.Errorf("%s %#v: %v", tt.typ, v, err)
+			}
+		}
+		for _, v := range tt.fail {
+			if err := tt.typ.Check(v); err == nil {
+				t.Errorf("%s %#v: expected error", tt.typ, v)
+			}
+		}
+	}
+	if err := iofl.ParamInt.Check(1.5); err == nil || err.Error() != "expected int, got 1.5" {
+		t.Errorf("got %v", err)
+	}
+	if err := iofl.ParamString.Check(nil); err == nil || err.Error() != "expected string, got null" {
+		t.Errorf("got %v", err)
+	}
+}
+
+// typedFilter returns the definition of a filter that declares the types of
+// its parameters, and outputs its "v" parameter.
+func typedFilter(name string, strict bool) iofl.FilterDef {
+	def := paramFilter(name)
+	def.StrictParams = strict
+	def.Params = []iofl.ParamDef{
+		{Name: "v", Type: iofl.ParamString, Required: true},
+		{Name: "n", Type: iofl.ParamInt},
+		{Name: "wait", Type: iofl.ParamDuration},
+	}
+	return def
+}
+
+func TestParamDefsRegister(t *testing.T) {
+	s := iofl.NewChainSet()
+	for _, params := range [][]iofl.ParamDef{
+		{{}},
+		{{Name: "a"}, {Name: "a"}},
+		{{Name: "a", Type: "float"}},
+	} {
+		if err := s.Register(iofl.FilterDef{Name: "f", Params: params}); err == nil || !strings.Contains(err.Error(), "params:") {
+			t.Errorf("%+v: got %v", params, err)
+		}
+	}
+	if err := s.Register(typedFilter("f", true)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParamDefsConfig(t *testing.T) {
+	s := newChainSet(t, nil, typedFilter("typed", false), typedFilter("strict", true))
+	tests := []struct {
+		filter string
+		params iofl.Params
+		want   []string
+	}{
+		{"typed", iofl.Params{"v": "x"}, nil},
+		{"typed", iofl.Params{"v": "x", "n": 2.0, "wait": "1s", "other": 1.0}, nil},
+		{"strict", iofl.Params{"v": "x", iofl.ParamBufferSize: 64.0, "#limit": 10.0}, nil},
+		// Variables are yet to be expanded.
+		{"strict", iofl.Params{"v": "x", "wait": "${wait}"}, nil},
+		{"typed", iofl.Params{}, []string{"v: required"}},
+		{"typed", iofl.Params{"v": 1.0, "n": 1.5}, []string{"v: expected string, got 1", "n: expected int, got 1.5"}},
+		{"typed", iofl.Params{"v": "x", "wait": "soon"}, []string{"wait: "}},
+		{"strict", iofl.Params{"v": "x", "vv": "y", "a": 1.0}, []string{"a: unknown parameter", "vv: unknown parameter"}},
+	}
+	for _, tt := range tests {
+		config := iofl.Config{Chains: map[string]iofl.Chain{"c": {{Filter: tt.filter, Params: tt.params}}}}
+		err := s.Validate(config)
+		var errs iofl.Errors
+		if len(tt.want) == 0 {
+			if err != nil {
+				t.Errorf("%s %v: %v", tt.filter, tt.params, err)
+			}
+			continue
+		}
+		if !errors.As(err, &errs) || len(errs) != len(tt.want) {
+			t.Errorf("%s %v: got %v, want %d errors", tt.filter, tt.params, err, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(errs[i].Error(), want) {
+				t.Errorf("%s %v: error %d: got %q, want %q", tt.filter, tt.params, i, errs[i], want)
+			}
+		}
+		if err := s.SetConfig(config); err == nil {
+			t.Errorf("%s %v: SetConfig accepted invalid params", tt.filter, tt.params)
+		}
+	}
+
+	// A misspelled parameter of a built-in filter is rejected.
+	config := iofl.Config{Chains: map[string]iofl.Chain{"c": {{Filter: "translate", Params: iofl.Params{"presett": "upper"}}}}}
+	if err := s.Validate(config); err == nil || !strings.Contains(err.Error(), "presett: unknown parameter") {
+		t.Errorf("got %v", err)
+	}
+}
+
+func TestParamDefsResolve(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "strict", Params: iofl.Params{"v": "x", "wait": "${wait}"}}},
+		"w": {{Filter: "hex"}},
+	}, typedFilter("strict", true))
+
+	// Parameters are checked after variables are expanded.
+	f, err := s.ResolveVars("c", map[string]string{"wait": "1s"}, source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "x" {
+		t.Errorf("got %q", got)
+	}
+	_, err = s.ResolveVars("c", map[string]string{"wait": "soon"}, source(""))
+	var rerr *iofl.ResolveError
+	if !errors.As(err, &rerr) || rerr.Index != 0 || !strings.Contains(err.Error(), "wait:") {
+		t.Errorf("got %v, want link error", err)
+	}
+
+	// Parameters are checked after overrides are applied.
+	for _, override := range []iofl.Params{{"v": 1.0}, {"extra": "y"}} {
+		_, err = s.ResolveVars("c", map[string]string{"wait": "1s"}, source(""), iofl.Overrides(map[int]iofl.Params{0: override}))
+		if !errors.As(err, &rerr) || rerr.Index != 0 {
+			t.Errorf("%v: got %v, want link error", override, err)
+		}
+	}
+	_, err = s.ResolveWriter("w", nopWriteCloser{&bytes.Buffer{}}, iofl.Overrides(map[int]iofl.Params{0: {"mode": 1.0}}))
+	if !errors.As(err, &rerr) || rerr.Index != 0 || !strings.Contains(err.Error(), "mode:") {
+		t.Errorf("writer: got %v, want link error", err)
+	}
+}
+
+func TestParamDefsDefault(t *testing.T) {
+	// A documented default is not applied to an absent parameter.
+	var got iofl.Params
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "default"}}}, iofl.FilterDef{
+		Name:   "default",
+		Params: []iofl.ParamDef{{Name: "n", Type: iofl.ParamInt, Default: "3"}},
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			got = params
+			return iofl.AsFilter(r), nil
+		},
+	})
+	f, err := s.Resolve("c", source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if _, ok := got["n"]; ok {
+		t.Errorf("default applied: got params %v", got)
+	}
+}
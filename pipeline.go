@@ -0,0 +1,140 @@
+package iofl
+
+import "io"
+
+// Pipelined returns an Option that runs each link of a chain in its own
+// goroutine. Each link reads ahead from its filter into buffers of size bytes,
+// handing them to the next link, so that CPU-intensive links, such as those
+// that compress or encrypt, run concurrently rather than serially within each
+// Read of the chain. If size is less than 1, a default size of 32KiB is used.
+//
+// Each link holds two buffers. Closing a link waits for the pending Read of
+// its filter to return before closing the filter, so that the filter is never
+// read and closed concurrently.
+func Pipelined(size int) Option {
+	if size < 1 {
+		size = defaultRunBufferSize
+	}
+	return Decorate(func(link Link, f Filter) Filter {
+		return &pipeFilter{f: f, size: size}
+	})
+}
+
+// pipeChunk is a buffer filled by a Read of the filter of a pipeFilter.
+type pipeChunk struct {
+	b   []byte
+	err error
+}
+
+// pipeFilter reads from a Filter in a separate goroutine, which is started by
+// the first Read.
+type pipeFilter struct {
+	f      Filter
+	size   int
+	budget *MemoryBudget
+
+	// chunks receives filled buffers from the goroutine.
+	chunks chan pipeChunk
+	// free receives buffers that have been consumed.
+	free chan []byte
+	// done is closed to stop the goroutine.
+	done chan struct{}
+	// exited is closed when the goroutine returns.
+	exited chan struct{}
+	closed bool
+
+	// buf is the buffer being consumed, and cur is its unconsumed portion.
+	buf []byte
+	cur []byte
+	err error
+}
+
+// start starts the goroutine.
+func (p *pipeFilter) start() {
+	p.chunks = make(chan pipeChunk, 1)
+	p.free = make(chan []byte, 2)
+	p.free <- make([]byte, p.size)
+	p.free <- make([]byte, p.size)
+	p.done = make(chan struct{})
+	p.exited = make(chan struct{})
+	go func() {
+		defer close(p.exited)
+		for {
+			var buf []byte
+			select {
+			case buf = <-p.free:
+			case <-p.done:
+				return
+			}
+			n, err := p.f.Read(buf)
+			select {
+			case p.chunks <- pipeChunk{b: buf[:n], err: err}:
+			case <-p.done:
+				return
+			}
+			if err != nil {
+				return
+			}
+		}
+	}()
+}
+
+func (p *pipeFilter) Read(b []byte) (n int, err error) {
+	if p.closed {
+		return 0, Closed
+	}
+	for len(p.cur) == 0 {
+		if p.err != nil {
+			return 0, p.err
+		}
+		if len(b) == 0 {
+			return 0, nil
+		}
+		if p.done == nil {
+			if err := p.budget.Alloc(2 * p.size); err != nil {
+				return 0, err
+			}
+			p.start()
+		}
+		if p.buf != nil {
+			p.free <- p.buf[:cap(p.buf)]
+		}
+		c := <-p.chunks
+		p.buf, p.cur, p.err = c.b, c.b, c.err
+	}
+	n = copy(b, p.cur)
+	p.cur = p.cur[n:]
+	if len(p.cur) == 0 && p.err != nil {
+		return n, p.err
+	}
+	return n, nil
+}
+
+func (p *pipeFilter) Close() error {
+	if p.closed {
+		return Closed
+	}
+	p.closed = true
+	if p.done != nil {
+		close(p.done)
+		<-p.exited
+		p.budget.Free(2 * p.size)
+	}
+	return p.f.Close()
+}
+
+func (p *pipeFilter) Source() io.ReadCloser { return p.f }
+
+// SetMemoryBudget implements BudgetUser. The buffers of the filter are
+// reserved from b.
+func (p *pipeFilter) SetMemoryBudget(b *MemoryBudget) {
+	p.budget = b
+}
+
+// MemoryUsage implements MemoryUser.
+func (p *pipeFilter) MemoryUsage() int {
+	if p.done == nil {
+		return 0
+	}
+	return 2 * p.size
+}
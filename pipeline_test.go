@@ -0,0 +1,211 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestPipelined(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "percent"},
+			{Filter: "translate", Params: iofl.Params{"preset": "upper"}},
+			{Filter: "percent", Params: iofl.Params{"mode": "decode"}},
+			{Filter: "identity"},
+		},
+	})
+	rnd := rand.New(rand.NewSource(1))
+	in := make([]byte, 100000)
+	for i := range in {
+		in[i] = byte('a' + rnd.Intn(26))
+	}
+	f, err := s.Resolve("c", source(string(in)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := readAll(t, f)
+	for _, size := range []int{0, 1, 7, 4096} {
+		f, err := s.Resolve("c", source(string(in)), iofl.Pipelined(size))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, f); got != want {
+			t.Errorf("size %d: output differs from serial chain", size)
+		}
+	}
+
+	// Reads smaller than the buffers are served from the buffered chunk.
+	f, err = s.Resolve("c", source(string(in)), iofl.Pipelined(64))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	p := make([]byte, 5)
+	for {
+		n, err := f.Read(p)
+		out.Write(p[:n])
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if out.String() != want {
+		t.Error("output of small reads differs from serial chain")
+	}
+	if n, err := f.Read(nil); n != 0 || err != io.EOF {
+		t.Errorf("after EOF: got %d, %v", n, err)
+	}
+	f.Close()
+}
+
+func TestPipelinedError(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "fail"}, {Filter: "translate"}},
+	}, failFilter("fail"))
+	f, err := s.Resolve("c", source("partial"), iofl.Pipelined(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	b, err := ioutil.ReadAll(f)
+	if string(b) != "partial" || !errors.Is(err, errBoom) {
+		t.Errorf("got %q, %v", b, err)
+	}
+	// The error persists.
+	if _, err := f.Read(make([]byte, 1)); !errors.Is(err, errBoom) {
+		t.Errorf("subsequent read: got %v", err)
+	}
+}
+
+// closeCounter is a source that counts its closes.
+type closeCounter struct {
+	io.Reader
+	closes int32
+}
+
+func (c *closeCounter) Close() error {
+	atomic.AddInt32(&c.closes, 1)
+	return nil
+}
+
+func TestPipelinedClose(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate"}, {Filter: "identity"}},
+	})
+
+	// Closing an unread chain closes its source.
+	src := &closeCounter{Reader: bytes.NewReader(nil)}
+	f, err := s.Resolve("c", src, iofl.Pipelined(0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if src.closes != 1 {
+		t.Errorf("got %d closes, want 1", src.closes)
+	}
+	if err := f.Close(); err != iofl.Closed {
+		t.Errorf("second close: got %v, want Closed", err)
+	}
+	if _, err := f.Read(make([]byte, 1)); err != iofl.Closed {
+		t.Errorf("read after close: got %v, want Closed", err)
+	}
+
+	// Closing mid-stream waits for the pending read of the source before
+	// closing it.
+	pr, pw := io.Pipe()
+	src = &closeCounter{Reader: pr}
+	f, err = s.Resolve("c", src, iofl.Pipelined(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	go pw.Write([]byte("abcd"))
+	if _, err := io.ReadFull(f, make([]byte, 4)); err != nil {
+		t.Fatal(err)
+	}
+	done := make(chan error, 1)
+	go func() { done <- f.Close() }()
+	select {
+	case <-done:
+		t.Fatal("close returned while a read was pending")
+	case <-time.After(20 * time.Millisecond):
+	}
+	if n := atomic.LoadInt32(&src.closes); n != 0 {
+		t.Errorf("source closed during a pending read")
+	}
+	pw.Close()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Error(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("close did not return")
+	}
+	if src.closes != 1 {
+		t.Errorf("got %d closes, want 1", src.closes)
+	}
+}
+
+func TestPipelinedMemory(t *testing.T) {
+	s := newChainSet(t, nil)
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"c":     {{Filter: "translate"}, {Filter: "identity"}},
+			"small": {{Filter: "translate"}, {Filter: "identity"}},
+		},
+		Limits: map[string]iofl.ChainLimit{
+			"small": {MaxMemory: 300},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each link holds two buffers, in addition to those of its filter.
+	f, err := s.Resolve("c", source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := f.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	base := iofl.MemoryUsage(f)
+	f.Close()
+	f, err = s.Resolve("c", source("abc"), iofl.Pipelined(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := iofl.MemoryUsage(f); got != 0 {
+		t.Errorf("before Read: got %d, want 0", got)
+	}
+	if _, err := f.Read(make([]byte, 1)); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := iofl.MemoryUsage(f), base+2*2*100; got != want {
+		t.Errorf("after Read: got %d, want %d", got, want)
+	}
+	if got := readAll(t, f); got != "bc" {
+		t.Errorf("got %q", got)
+	}
+
+	// The buffers are reserved from the budget of the chain.
+	f, err = s.Resolve("small", source("abc"), iofl.Pipelined(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := ioutil.ReadAll(f); !errors.Is(err, iofl.MemoryExceeded) {
+		t.Errorf("got %v, want MemoryExceeded", err)
+	}
+}
@@ -0,0 +1,114 @@
+package iofl
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+// Resetter is implemented by a Filter that can be reused after it has been
+// closed.
+type Resetter interface {
+	// Reset discards the internal state of the filter, and sets its source to
+	// src, reopening the filter if it was closed.
+	Reset(src io.ReadCloser) error
+}
+
+// emptySource returns an empty source used to construct pooled chains.
+func emptySource() io.ReadCloser {
+	return ioutil.NopCloser(bytes.NewReader(nil))
+}
+
+// ChainPool holds reusable instances of a resolved chain, amortizing the cost
+// of construction for callers that apply the same chain repeatedly. Every
+// Filter in the chain that has a source must implement Resetter. A ChainPool is
+// safe for concurrent use.
+type ChainPool struct {
+	set   *ChainSet
+	chain string
+	opts  []Option
+	size  int
+
+	mu   sync.Mutex
+	free []Filter
+}
+
+// NewPool returns a ChainPool that holds up to n instances of the chain, and
+// resolves n instances in advance. Each Option is applied when resolving.
+// Returns an error if the chain cannot be resolved, or if it is not reusable.
+func (s *ChainSet) NewPool(chain string, n int, opts ...Option) (*ChainPool, error) {
+	p := &ChainPool{set: s, chain: chain, opts: opts, size: n}
+	for i := 0; i < n; i++ {
+		f, err := s.Resolve(chain, emptySource(), opts...)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := resetters(f); err != nil {
+			return nil, err
+		}
+		p.free = append(p.free, f)
+	}
+	return p, nil
+}
+
+// resetters returns each Filter in the chain of f that has a source, from the
+// last link to the first. Returns an error if such a Filter does not implement
+// Resetter.
+func resetters(f Filter) (links []Filter, err error) {
+	err = Apply(f, func(r io.ReadCloser) error {
+		f, ok := r.(Filter)
+		if !ok || f.Source() == nil || isResolved(f) {
+			return nil
+		}
+		if _, ok := f.(Resetter); !ok {
+			return fmt.Errorf("%T does not implement Resetter", f)
+		}
+		links = append(links, f)
+		return nil
+	})
+	return links, err
+}
+
+// Get returns an instance of the chain that reads from src. A pooled instance
+// is reset and reused if available, otherwise a new instance is resolved.
+func (p *ChainPool) Get(src io.ReadCloser) (filter Filter, err error) {
+	p.mu.Lock()
+	if n := len(p.free); n > 0 {
+		filter = p.free[n-1]
+		p.free = p.free[:n-1]
+	}
+	p.mu.Unlock()
+	if filter == nil {
+		return p.set.Resolve(p.chain, src, p.opts...)
+	}
+	links, err := resetters(filter)
+	if err != nil {
+		return nil, err
+	}
+	// Reset from the first link to the last, so that each link receives a
+	// source that has already been reset.
+	var source io.ReadCloser = AsFilter(src)
+	for i := len(links) - 1; i >= 0; i-- {
+		if err := links[i].(Resetter).Reset(source); err != nil {
+			return nil, err
+		}
+		source = links[i]
+	}
+	return filter, nil
+}
+
+// Put returns an instance previously returned by Get to the pool. The instance
+// should be closed before being returned. If the pool is full, the instance is
+// discarded.
+func (p *ChainPool) Put(filter Filter) {
+	if filter == nil {
+		return
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.free) < p.size {
+		p.free = append(p.free, filter)
+	}
+}
@@ -0,0 +1,120 @@
+package iofl_test
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func poolSet(t *testing.T) *iofl.ChainSet {
+	return newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "translate", Params: iofl.Params{"preset": "upper"}},
+			{Filter: "charset", Params: iofl.Params{"mode": "convert"}},
+			{Filter: "percent", Params: iofl.Params{"mode": "decode"}},
+		},
+		"hook": {{Filter: "hook"}},
+	}, hookFilter("hook", func() {}))
+}
+
+func TestChainPool(t *testing.T) {
+	p, err := poolSet(t).NewPool("c", 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	reused := map[iofl.Filter]bool{}
+	for i := 0; i < 5; i++ {
+		f, err := p.Get(source(fmt.Sprintf("a%%20b%d", i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want := fmt.Sprintf("A B%d", i); readAll(t, f) != want {
+			t.Errorf("%d: unexpected output", i)
+		}
+		reused[f] = true
+		p.Put(f)
+	}
+	if len(reused) != 1 {
+		t.Errorf("got %d distinct instances, want 1", len(reused))
+	}
+}
+
+func TestChainPoolOverflow(t *testing.T) {
+	p, err := poolSet(t).NewPool("c", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, err := p.Get(source("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The pool is empty, so a new instance is resolved.
+	b, err := p.Get(source("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if a == b {
+		t.Fatal("same instance returned twice")
+	}
+	if readAll(t, a) != "A" || readAll(t, b) != "B" {
+		t.Error("unexpected output")
+	}
+	p.Put(a)
+	p.Put(b)
+	p.Put(nil)
+	if c, _ := p.Get(source("")); c != a {
+		t.Error("expected pooled instance")
+	}
+	if c, _ := p.Get(source("")); c == b {
+		t.Error("instance beyond pool size was kept")
+	}
+}
+
+func TestChainPoolNotReusable(t *testing.T) {
+	if _, err := poolSet(t).NewPool("hook", 1); err == nil {
+		t.Error("expected error for chain without Resetter")
+	}
+	if _, err := poolSet(t).NewPool("missing", 1); err == nil {
+		t.Error("expected error for unknown chain")
+	}
+}
+
+func TestChainPoolConcurrent(t *testing.T) {
+	p, err := poolSet(t).NewPool("c", 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 50; i++ {
+				in := fmt.Sprintf("g%d-%d", g, i)
+				f, err := p.Get(source(in))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				var b strings.Builder
+				buf := make([]byte, 3)
+				for {
+					n, err := f.Read(buf)
+					b.Write(buf[:n])
+					if err != nil {
+						break
+					}
+				}
+				f.Close()
+				if b.String() != strings.ToUpper(in) {
+					t.Errorf("got %q, want %q", b.String(), strings.ToUpper(in))
+				}
+				p.Put(f)
+			}
+		}(g)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,58 @@
+package iofl
+
+import (
+	"context"
+	"io"
+	"runtime/pprof"
+	"strconv"
+)
+
+// Profiler labels applied by ProfileLabels.
+const (
+	LabelChain  = "iofl.chain"
+	LabelLink   = "iofl.link"
+	LabelFilter = "iofl.filter"
+)
+
+// ProfileLabels returns an Option that applies pprof labels to each Read call
+// of each link, identifying the chain, the index of the link, and the name of
+// the filter. Goroutines started by a filter during a Read inherit the labels.
+// Labels are added to those of ctx.
+func ProfileLabels(ctx context.Context) Option {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Decorate(func(link Link, f Filter) Filter {
+		return &labeled{
+			ctx: ctx,
+			f:   f,
+			labels: pprof.Labels(
+				LabelChain, link.Chain,
+				LabelLink, strconv.Itoa(link.Index),
+				LabelFilter, link.Def.Filter,
+			),
+		}
+	})
+}
+
+// labeled applies pprof labels to Read calls of a Filter.
+type labeled struct {
+	ctx    context.Context
+	f      Filter
+	labels pprof.LabelSet
+}
+
+func (l *labeled) Read(p []byte) (n int, err error) {
+	pprof.Do(l.ctx, l.labels, func(context.Context) {
+		n, err = l.f.Read(p)
+	})
+	return n, err
+}
+
+func (l *labeled) Close() error {
+	return l.f.Close()
+}
+
+func (l *labeled) Source() io.ReadCloser {
+	return l.f
+}
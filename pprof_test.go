@@ -0,0 +1,51 @@
+package iofl_test
+
+import (
+	"bytes"
+	"context"
+	"runtime/pprof"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestProfileLabels(t *testing.T) {
+	var profile bytes.Buffer
+	probe := hookFilter("probe", func() {
+		if profile.Len() == 0 {
+			pprof.Lookup("goroutine").WriteTo(&profile, 1)
+		}
+	})
+	s := newChainSet(t, map[string]iofl.Chain{
+		"labeled": {{Filter: "identity"}, {Filter: "probe"}},
+	}, probe)
+	ctx := pprof.WithLabels(context.Background(), pprof.Labels("request", "r1"))
+	f, err := s.Resolve("labeled", source("data"), iofl.ProfileLabels(ctx))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "data" {
+		t.Fatalf("got %q", got)
+	}
+	for _, label := range []string{
+		`"iofl.chain":"labeled"`,
+		`"iofl.link":"1"`,
+		`"iofl.filter":"probe"`,
+		`"request":"r1"`,
+	} {
+		if !bytes.Contains(profile.Bytes(), []byte(label)) {
+			t.Errorf("goroutine profile does not contain label %s", label)
+		}
+	}
+}
+
+func TestProfileLabelsNilContext(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "identity"}}})
+	f, err := s.Resolve("c", source("x"), iofl.ProfileLabels(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "x" {
+		t.Fatalf("got %q", got)
+	}
+}
@@ -0,0 +1,70 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+)
+
+// RatioExceeded is returned when the number of bytes produced by a link or
+// chain exceeds the number of bytes it has read by more than an allowed ratio,
+// such as by a decompression bomb. Once returned, it is returned by every
+// subsequent Read, without further reading from the source.
+var RatioExceeded = errors.New("expansion ratio exceeded")
+
+// ratioGrace is the number of bytes that may be produced regardless of the
+// expansion ratio, allowing small inputs to expand by a large ratio.
+const ratioGrace = 64 << 10
+
+// MaxRatio returns an Option that limits the ratio of the number of bytes
+// produced by a chain to the number of bytes read from its source. The first
+// 64KiB of output are always allowed. When the ratio is exceeded, a Read
+// returns RatioExceeded. Has no effect if ratio is not positive, or if the
+// chain is resolved without a source. To limit a single link, use the #ratio
+// meta-parameter.
+func MaxRatio(ratio float64) Option {
+	return func(o *resolveOptions) {
+		o.ratio = ratio
+	}
+}
+
+// countFilter counts the bytes read from a Filter.
+type countFilter struct {
+	f Filter
+	n int64
+}
+
+func (c *countFilter) Read(p []byte) (n int, err error) {
+	n, err = c.f.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countFilter) Close() error          { return c.f.Close() }
+func (c *countFilter) Source() io.ReadCloser { return c.f }
+
+// ratioFilter returns RatioExceeded when the bytes read from f exceed the
+// bytes counted by in by more than ratio. Once exceeded, f is no longer read,
+// and each Read returns RatioExceeded.
+type ratioFilter struct {
+	f     Filter
+	in    *countFilter
+	ratio float64
+	n     int64
+	err   error
+}
+
+func (r *ratioFilter) Read(p []byte) (n int, err error) {
+	if r.err != nil {
+		return 0, r.err
+	}
+	n, err = r.f.Read(p)
+	r.n += int64(n)
+	if r.n > ratioGrace && float64(r.n) > float64(r.in.n)*r.ratio {
+		r.err = RatioExceeded
+		return 0, r.err
+	}
+	return n, err
+}
+
+func (r *ratioFilter) Close() error          { return r.f.Close() }
+func (r *ratioFilter) Source() io.ReadCloser { return r.f }
@@ -0,0 +1,126 @@
+package iofl_test
+
+import (
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// gzipBytes returns b compressed with gzip.
+func gzipBytes(t *testing.T, b []byte) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(b); err != nil {
+		t.Fatal(err)
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestMaxRatio(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"gunzip": {{Filter: "gzip"}},
+		"link":   {{Filter: "translate"}, {Filter: "gzip", Params: iofl.Params{"#ratio": 10.0}}},
+	})
+	bomb := gzipBytes(t, make([]byte, 1<<20))
+	small := gzipBytes(t, make([]byte, 32<<10))
+
+	tests := []struct {
+		chain string
+		in    []byte
+		opts  []iofl.Option
+		err   error
+	}{
+		{"gunzip", bomb, []iofl.Option{iofl.MaxRatio(10)}, iofl.RatioExceeded},
+		{"gunzip", bomb, []iofl.Option{iofl.MaxRatio(10000)}, nil},
+		{"gunzip", bomb, []iofl.Option{iofl.MaxRatio(0)}, nil},
+		{"gunzip", bomb, nil, nil},
+		{"gunzip", small, []iofl.Option{iofl.MaxRatio(1)}, nil},
+		{"link", bomb, nil, iofl.RatioExceeded},
+		{"link", small, nil, nil},
+	}
+	for _, tt := range tests {
+		f, err := s.Resolve(tt.chain, ioutil.NopCloser(bytes.NewReader(tt.in)), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = ioutil.ReadAll(f)
+		f.Close()
+		if !errors.Is(err, tt.err) || (tt.err == nil) != (err == nil) {
+			t.Errorf("%s, %d bytes: got %v, want %v", tt.chain, len(tt.in), err, tt.err)
+		}
+	}
+}
+
+func TestMaxRatioCompressible(t *testing.T) {
+	// Content that compresses by less than the ratio passes.
+	s := newChainSet(t, map[string]iofl.Chain{"gunzip": {{Filter: "gzip", Params: iofl.Params{"#ratio": 5.0}}}})
+	rnd := rand.New(rand.NewSource(1))
+	text := make([]byte, 256<<10)
+	for i := range text {
+		text[i] = "abcdefghijklmnopqrstuvwxyz "[rnd.Intn(27)]
+	}
+	f, err := s.Resolve("gunzip", ioutil.NopCloser(bytes.NewReader(gzipBytes(t, text))), iofl.MaxRatio(5))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != string(text) {
+		t.Error("output does not match")
+	}
+}
+
+// readCounter counts the bytes read from a reader.
+type readCounter struct {
+	io.Reader
+	n int
+}
+
+func (r *readCounter) Read(p []byte) (n int, err error) {
+	n, err = r.Reader.Read(p)
+	r.n += n
+	return n, err
+}
+
+func TestMaxRatioSticky(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"gunzip": {{Filter: "gzip"}},
+		"link":   {{Filter: "gzip", Params: iofl.Params{"#ratio": 10.0}}},
+	})
+	bomb := gzipBytes(t, make([]byte, 8<<20))
+	for _, tt := range []struct {
+		chain string
+		opts  []iofl.Option
+	}{
+		{"gunzip", []iofl.Option{iofl.MaxRatio(10)}},
+		{"link", nil},
+	} {
+		src := &readCounter{Reader: bytes.NewReader(bomb)}
+		f, err := s.Resolve(tt.chain, ioutil.NopCloser(src), tt.opts...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := io.Copy(ioutil.Discard, f); !errors.Is(err, iofl.RatioExceeded) {
+			t.Fatalf("%s: got %v, want RatioExceeded", tt.chain, err)
+		}
+		read := src.n
+		p := make([]byte, 64<<10)
+		for i := 0; i < 100; i++ {
+			if n, err := f.Read(p); n != 0 || !errors.Is(err, iofl.RatioExceeded) {
+				t.Fatalf("%s: read after exceeding: got %d, %v", tt.chain, n, err)
+			}
+		}
+		if src.n != read {
+			t.Errorf("%s: source read after exceeding: %d bytes, then %d", tt.chain, read, src.n)
+		}
+		f.Close()
+	}
+}
@@ -0,0 +1,53 @@
+package iofl
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// ReferenceCycle is returned when resolving a chain that refers to itself,
+// directly or through other chains.
+var ReferenceCycle = errors.New("chain reference cycle")
+
+// expand returns the links of chain, identified by name, with references to
+// other chains expanded recursively. Referenced chains are located with
+// lookup. Each Link identifies the chain that defines it.
+func expand(name string, chain Chain, lookup func(string) (Chain, bool)) ([]Link, error) {
+	return expandLinks(nil, name, chain, lookup, nil)
+}
+
+// expandLinks appends the expanded links of chain to links. stack contains the
+// names of the chains being expanded.
+func expandLinks(links []Link, name string, chain Chain, lookup func(string) (Chain, bool), stack []string) ([]Link, error) {
+	stack = append(stack, name)
+	for i, def := range chain {
+		link := Link{Chain: name, Index: i, Def: def}
+		if def.Chain == "" {
+			links = append(links, link)
+			continue
+		}
+		if def.Filter != "" || len(def.Params) > 0 {
+			return nil, link.error(errors.New("chain reference cannot specify a filter or params"))
+		}
+		for k, s := range stack {
+			if s == def.Chain {
+				return nil, link.error(fmt.Errorf("%w: %s -> %s", ReferenceCycle, strings.Join(stack[k:], " -> "), def.Chain))
+			}
+		}
+		sub, ok := lookup(def.Chain)
+		if !ok {
+			return nil, link.error(fmt.Errorf("%w %q", UnknownChain, def.Chain))
+		}
+		var err error
+		if links, err = expandLinks(links, def.Chain, sub, lookup, stack); err != nil {
+			return nil, err
+		}
+	}
+	return links, nil
+}
+
+// error returns err as a *ResolveError identifying the link.
+func (l Link) error(err error) *ResolveError {
+	return &ResolveError{Chain: l.Chain, Index: l.Index, Filter: l.Def.Filter, Err: err}
+}
@@ -0,0 +1,98 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestChainReference(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper":  {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"rot13":  {{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}},
+		"both":   {{Chain: "upper"}, {Chain: "rot13"}},
+		"nested": {{Chain: "both"}, {Filter: "identity"}, {Chain: "rot13"}},
+		"twice":  {{Chain: "rot13"}, {Chain: "rot13"}},
+	})
+	for _, tt := range []struct {
+		chain, want string
+	}{
+		{"both", "NOP"},
+		{"nested", "ABC"},
+		{"twice", "abc"},
+	} {
+		f, err := s.Resolve(tt.chain, source("abc"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, f); got != tt.want {
+			t.Errorf("%s: got %q, want %q", tt.chain, got, tt.want)
+		}
+	}
+}
+
+func TestChainReferenceErrors(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"inner": {{Filter: "translate"}, {Filter: "broken"}},
+		"outer": {{Filter: "translate"}, {Chain: "inner"}},
+	}, iofl.FilterDef{
+		Name: "broken",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return nil, errBoom
+		},
+	})
+	// Errors identify the link within the referenced chain.
+	_, err := s.Resolve("outer", source(""))
+	var rerr *iofl.ResolveError
+	if !errors.As(err, &rerr) || !errors.Is(err, errBoom) {
+		t.Fatalf("got %v, want *ResolveError", err)
+	}
+	if rerr.Chain != "inner" || rerr.Index != 1 || rerr.Filter != "broken" {
+		t.Errorf("got link %s[%d]%s, want inner[1]broken", rerr.Chain, rerr.Index, rerr.Filter)
+	}
+
+	for _, tt := range []struct {
+		chain iofl.Chain
+		err   error
+		msg   string
+	}{
+		{iofl.Chain{{Chain: "missing"}}, iofl.UnknownChain, ""},
+		{iofl.Chain{{Chain: "inner", Filter: "translate"}}, nil, "cannot specify a filter"},
+		{iofl.Chain{{Chain: "inner", Params: iofl.Params{"preset": "upper"}}}, nil, "cannot specify a filter or params"},
+	} {
+		_, err := s.ResolveChain(tt.chain, source(""))
+		if err == nil || tt.err != nil && !errors.Is(err, tt.err) || !strings.Contains(err.Error(), tt.msg) {
+			t.Errorf("%v: got %v", tt.chain, err)
+		}
+	}
+}
+
+func TestChainReferenceCycle(t *testing.T) {
+	s := newChainSet(t, nil)
+	for _, chains := range []map[string]iofl.Chain{
+		{"self": {{Chain: "self"}}},
+		{"a": {{Chain: "b"}}, "b": {{Filter: "translate"}, {Chain: "c"}}, "c": {{Chain: "a"}}},
+	} {
+		var errs iofl.Errors
+		if err := s.SetConfig(iofl.Config{Chains: chains}); !errors.As(err, &errs) {
+			t.Fatalf("got %v, want Errors", err)
+		}
+		for _, err := range errs {
+			if !errors.Is(err, iofl.ReferenceCycle) {
+				t.Errorf("got %v, want ReferenceCycle", err)
+			}
+		}
+	}
+	// A chain referred to more than once without a cycle is accepted.
+	err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{
+		"a": {{Filter: "translate"}},
+		"b": {{Chain: "a"}, {Chain: "a"}},
+		"c": {{Chain: "a"}, {Chain: "b"}},
+	}})
+	if err != nil {
+		t.Error(err)
+	}
+}
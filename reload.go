@@ -0,0 +1,97 @@
+package iofl
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// Reload replaces the configuration of the ChainSet with config, for a
+// long-running service that changes its chains without restarting. As with
+// SetConfig, config is migrated and validated, and the current configuration
+// is kept if any problems are found.
+//
+// The configuration is swapped atomically: a call to Resolve locates the chain
+// and the chains it refers to from either the old or the new configuration,
+// never a mix of both. Instances resolved before the swap are unaffected. The
+// limiter of a chain whose ChainLimit is unchanged is retained, so that open
+// instances continue to count toward the limit.
+func (s *ChainSet) Reload(config Config) error {
+	if err := s.migrate(&config); err != nil {
+		return err
+	}
+	if err := s.validate(config); err != nil {
+		return err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setConfig(config, true)
+	return nil
+}
+
+// DefaultWatchInterval is the interval at which WatchFile polls when the given
+// interval is not positive.
+const DefaultWatchInterval = 5 * time.Second
+
+// WatchFile loads the configuration of the given format from the file at path
+// with LoadConfig, and applies it with Reload. The file is then polled at the
+// given interval, and reloaded whenever its size or modification time changes,
+// until ctx is done. Returns an error if the initial configuration cannot be
+// loaded. Afterwards, errors are written to the Logger of the ChainSet, and
+// the current configuration is kept. Returns ctx.Err() once ctx is done.
+func (s *ChainSet) WatchFile(ctx context.Context, path, format string, interval time.Duration) error {
+	if interval <= 0 {
+		interval = DefaultWatchInterval
+	}
+	last, err := s.reloadFile(path, format)
+	if err != nil {
+		return err
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		stat, err := os.Stat(path)
+		if err != nil {
+			s.logf("watch %s: %s", path, err)
+			continue
+		}
+		if stat.Size() == last.Size() && stat.ModTime().Equal(last.ModTime()) {
+			continue
+		}
+		loaded, err := s.reloadFile(path, format)
+		if err != nil {
+			s.logf("reload %s: %s", path, err)
+		} else {
+			s.logf("reloaded %s", path)
+		}
+		if loaded != nil {
+			// A configuration that fails to apply is retried only once the
+			// file changes again.
+			last = loaded
+		}
+	}
+}
+
+// reloadFile loads and applies the configuration in the file at path. Returns
+// the state of the file as it was loaded, which is returned even if the
+// configuration could not be applied.
+func (s *ChainSet) reloadFile(path, format string) (stat os.FileInfo, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	if stat, err = file.Stat(); err != nil {
+		return nil, err
+	}
+	config, err := LoadConfig(file, format)
+	if err != nil {
+		return stat, err
+	}
+	return stat, s.Reload(config)
+}
@@ -0,0 +1,198 @@
+package iofl_test
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestReload(t *testing.T) {
+	s := limitSet(t, iofl.ChainLimit{Max: 1})
+	config := s.Config()
+	open, err := s.Resolve("c", source("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// An open instance counts toward an unchanged limit.
+	config.Chains["c"] = iofl.Chain{{Filter: "translate", Params: iofl.Params{"preset": "upper"}}}
+	if err := s.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Resolve("c", source("b")); !errors.Is(err, iofl.TooManyInstances) {
+		t.Errorf("unchanged limit: got %v, want TooManyInstances", err)
+	}
+	// The instance resolved before the swap is unaffected.
+	if got := readAll(t, open); got != "a" {
+		t.Errorf("got %q from open instance", got)
+	}
+	f, err := s.Resolve("c", source("b"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "B" {
+		t.Errorf("got %q after reload", got)
+	}
+
+	// A changed limit starts afresh.
+	if open, err = s.Resolve("c", source("c")); err != nil {
+		t.Fatal(err)
+	}
+	defer open.Close()
+	config.Limits["c"] = iofl.ChainLimit{Max: 2}
+	if err := s.Reload(config); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		f, err := s.Resolve("c", source("d"))
+		if err != nil {
+			t.Fatalf("changed limit: %v", err)
+		}
+		defer f.Close()
+	}
+
+	// An invalid configuration is rejected, and the current one kept.
+	bad := s.Config()
+	bad.Chains["c"] = iofl.Chain{{Filter: "missing"}}
+	if err := s.Reload(bad); err == nil {
+		t.Error("expected error for invalid configuration")
+	}
+	if got := s.Config().Chains["c"][0].Filter; got != "translate" {
+		t.Errorf("got filter %q after failed reload", got)
+	}
+}
+
+func TestReloadConsistent(t *testing.T) {
+	upper := iofl.Params{"preset": "upper"}
+	rot13 := iofl.Params{"preset": "rot13"}
+	configs := []iofl.Config{
+		{Chains: map[string]iofl.Chain{
+			"outer": {{Chain: "inner"}},
+			"inner": {{Filter: "translate", Params: upper}},
+		}},
+		{Chains: map[string]iofl.Chain{
+			"outer": {{Chain: "inner"}, {Filter: "translate", Params: rot13}},
+			"inner": {{Filter: "identity"}},
+		}},
+	}
+	s := newChainSet(t, configs[0].Chains)
+	// Each configuration produces a distinct output, and a chain mixing the
+	// two configurations produces some other output.
+	valid := map[string]bool{"ABC": true, "nop": true}
+
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				f, err := s.Resolve("outer", source("abc"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b, _ := ioutil.ReadAll(f)
+				f.Close()
+				if !valid[string(b)] {
+					t.Errorf("got %q from mixed configurations", b)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 200; i++ {
+		if err := s.Reload(configs[i%2]); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+}
+
+func TestWatchFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.json")
+	write := func(content string) {
+		t.Helper()
+		if err := ioutil.WriteFile(path, []byte(content), 0666); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := newChainSet(t, nil)
+	var log logRecorder
+	s.SetLogger(&log)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// The initial configuration must load.
+	if err := s.WatchFile(ctx, path, "json", time.Millisecond); err == nil {
+		t.Fatal("expected error for missing file")
+	}
+
+	write(`{"chains": {"c": ["identity"]}}`)
+	done := make(chan error, 1)
+	go func() { done <- s.WatchFile(ctx, path, "json", 5*time.Millisecond) }()
+	waitFor := func(what string, cond func() bool) {
+		t.Helper()
+		deadline := time.Now().Add(5 * time.Second)
+		for !cond() {
+			if time.Now().After(deadline) {
+				t.Fatalf("timed out waiting for %s", what)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	filter := func() string {
+		chain := s.Config().Chains["c"]
+		if len(chain) == 0 {
+			return ""
+		}
+		return chain[0].Filter
+	}
+	waitFor("initial load", func() bool { return filter() == "identity" })
+
+	write(`{"chains": {"c": ["translate"]}}`)
+	waitFor("reload", func() bool { return filter() == "translate" })
+
+	// A configuration that fails to load is logged, and the current one kept.
+	logged := func(prefix string) func() bool {
+		return func() bool {
+			for _, msg := range log.messages() {
+				if strings.HasPrefix(msg, prefix) {
+					return true
+				}
+			}
+			return false
+		}
+	}
+	write(`{"chains": {"c": ["missing filter"]}}`)
+	waitFor("reload error", logged("reload "+path))
+	if got := filter(); got != "translate" {
+		t.Errorf("got filter %q after failed reload", got)
+	}
+
+	// A file that cannot be read is logged.
+	if err := os.Remove(path); err != nil {
+		t.Fatal(err)
+	}
+	waitFor("watch error", logged("watch "+path))
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != context.Canceled {
+			t.Errorf("got %v, want context.Canceled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("WatchFile did not return")
+	}
+}
@@ -0,0 +1,78 @@
+package iofl
+
+import "time"
+
+// Reporter is implemented by a Filter that reports values describing its work,
+// such as a digest of its content, or a count of records. The values are
+// included in the RunReport produced by Run.
+type Reporter interface {
+	// Report returns the values reported by the filter, mapped by name.
+	// Called once the chain has been read, before it is closed.
+	Report() map[string]interface{}
+}
+
+// RunReport summarizes a call to Run.
+type RunReport struct {
+	// Chain is the name of the chain that was run.
+	Chain string
+	// Written is the number of bytes written to the destination.
+	Written int64
+	// Skipped is the number of bytes included in Written that were seeked
+	// over rather than written, when the Sparse option is given.
+	Skipped int64
+	// Duration is the duration of the run, including resolving and closing
+	// the chain.
+	Duration time.Duration
+	// Links contains a report for each link of the chain, in order.
+	Links []LinkReport
+}
+
+// LinkReport describes the work of one link of a chain within a RunReport.
+type LinkReport struct {
+	// Link identifies the link.
+	Link Link
+	// Bytes is the number of bytes produced by the link.
+	Bytes int64
+	// Duration is the time spent reading from the link, not including the
+	// time spent reading from its source.
+	Duration time.Duration
+	// Values contains the values reported by the filter of the link, if it
+	// implements Reporter.
+	Values map[string]interface{}
+}
+
+// linkFilter returns the Filter produced by a link, looking through the
+// wrappers applied by the ChainSet for meta-parameters and hooks.
+func linkFilter(f Filter) Filter {
+	for {
+		switch v := f.(type) {
+		case *hooked:
+			f = v.f
+		case *limitFilter:
+			f = v.f
+		case *ratioFilter:
+			f = v.f
+		case *timeoutFilter:
+			f = v.f
+		case *bufferFilter:
+			f = v.f
+		case *teeFilter:
+			f = v.f
+		default:
+			return f
+		}
+	}
+}
+
+// linkReports returns the reports of links, which are in order of the chain.
+func linkReports(links []*statsFilter) []LinkReport {
+	stats := linkStats(links)
+	reports := make([]LinkReport, len(links))
+	for i, s := range links {
+		reports[i] = LinkReport{Link: s.link, Bytes: stats[i].Bytes, Duration: stats[i].Duration}
+		if rep, ok := linkFilter(s.f).(Reporter); ok {
+			reports[i].Values = rep.Report()
+		}
+	}
+	return reports
+}
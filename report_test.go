@@ -0,0 +1,72 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestRunReport(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "translate", Params: iofl.Params{"preset": "upper", "delete": " "}},
+			// Values are reported through the wrappers of meta-params.
+			{Filter: "checksum", Params: iofl.Params{"#buffer": 1024.0}},
+		},
+	})
+	in := strings.Repeat("report me ", 100)
+	want := strings.ToUpper(strings.Replace(in, " ", "", -1))
+	var buf bytes.Buffer
+	report, err := s.Run("c", &buf, source(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if buf.String() != want {
+		t.Fatalf("got %q", buf.String())
+	}
+	if report.Chain != "c" || report.Written != int64(len(want)) || report.Skipped != 0 {
+		t.Errorf("got chain %q, written %d, skipped %d", report.Chain, report.Written, report.Skipped)
+	}
+	if report.Duration <= 0 {
+		t.Errorf("got duration %v", report.Duration)
+	}
+	if len(report.Links) != 2 {
+		t.Fatalf("got %d links", len(report.Links))
+	}
+	for i, l := range report.Links {
+		if l.Link.Chain != "c" || l.Link.Index != i {
+			t.Errorf("link %d: got %+v", i, l.Link)
+		}
+		if l.Bytes != int64(len(want)) {
+			t.Errorf("link %d: got %d bytes, want %d", i, l.Bytes, len(want))
+		}
+		if l.Duration < 0 {
+			t.Errorf("link %d: got duration %v", i, l.Duration)
+		}
+	}
+	if v := report.Links[0].Values; v != nil {
+		t.Errorf("translate reported %v", v)
+	}
+	values := report.Links[1].Values
+	if values["algorithm"] != "sha256" || values["digest"] != sha256Hex(want) {
+		t.Errorf("got values %v", values)
+	}
+}
+
+func TestRunReportError(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "fail"}}}, failFilter("fail"))
+	report, err := s.Run("missing", &bytes.Buffer{}, source("abc"))
+	if !errors.Is(err, iofl.UnknownChain) || report.Chain != "missing" || report.Links != nil {
+		t.Errorf("got %+v, %v", report, err)
+	}
+	report, err = s.Run("c", &bytes.Buffer{}, source("abc"))
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got %v, want read error", err)
+	}
+	if len(report.Links) != 1 || report.Written != 3 {
+		t.Errorf("got %+v", report)
+	}
+}
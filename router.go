@@ -0,0 +1,191 @@
+package iofl
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"strings"
+)
+
+// NoRoute is returned by Router.Resolve when content matches no route.
+var NoRoute = errors.New("no route")
+
+// RouteMeta describes content to be routed by a Router.
+type RouteMeta struct {
+	// ContentType is the declared media type of the content, such as the
+	// Content-Type header of a request. Parameters, such as charset, are
+	// ignored. May be empty.
+	ContentType string
+	// Name is the name of the content, such as a file name or path, from
+	// which its extension is taken. May be empty.
+	Name string
+}
+
+// Router selects the chain through which content is resolved, according to
+// its magic signature, its media type, or the extension of its name. It allows
+// a service that ingests varied content to configure its routes rather than
+// selecting chains by hand.
+//
+// Routes are selected with the following precedence:
+//
+//  1. Signature: the content begins with a registered signature. The content
+//     is authoritative, so a signature overrides what is declared about it.
+//     The longest matching signature is selected.
+//  2. Type: the media type of the content matches a registered type. An exact
+//     match, such as "text/csv", is selected over a wildcard, such as
+//     "text/*".
+//  3. Extension: the name of the content ends with a registered extension.
+//     The longest matching extension is selected, so ".tar.gz" is selected
+//     over ".gz".
+//
+// Types and extensions are matched without regard to case. A Router must not
+// be modified while it is in use, but is otherwise safe for concurrent use.
+type Router struct {
+	set        *ChainSet
+	types      map[string]string
+	extensions map[string]string
+	signatures []signatureRoute
+	sniff      int
+}
+
+// signatureRoute routes content beginning with sig to chain.
+type signatureRoute struct {
+	sig   []byte
+	chain string
+}
+
+// NewRouter returns a Router with no routes that resolves chains from s.
+func (s *ChainSet) NewRouter() *Router {
+	return &Router{
+		set:        s,
+		types:      map[string]string{},
+		extensions: map[string]string{},
+	}
+}
+
+// Type routes content of the given media type to chain, replacing any existing
+// route for the type. A type whose subtype is "*", such as "image/*", matches
+// any media type with the same type. Returns the Router.
+func (r *Router) Type(mediaType, chain string) *Router {
+	r.types[strings.ToLower(mediaType)] = chain
+	return r
+}
+
+// Extension routes content whose name ends with ext to chain, replacing any
+// existing route for the extension. ext includes the leading dot, such as
+// ".gz". Returns the Router.
+func (r *Router) Extension(ext, chain string) *Router {
+	r.extensions[strings.ToLower(ext)] = chain
+	return r
+}
+
+// Signature routes content beginning with sig to chain, replacing any existing
+// route for the signature. Content is sniffed for signatures only if at least
+// one is registered. Returns the Router.
+func (r *Router) Signature(sig []byte, chain string) *Router {
+	for i, route := range r.signatures {
+		if bytes.Equal(route.sig, sig) {
+			r.signatures[i].chain = chain
+			return r
+		}
+	}
+	r.signatures = append(r.signatures, signatureRoute{sig: append([]byte(nil), sig...), chain: chain})
+	if len(sig) > r.sniff {
+		r.sniff = len(sig)
+	}
+	return r
+}
+
+// Route returns the name of the chain selected for content described by meta
+// and beginning with prefix. Returns false if no route matches.
+func (r *Router) Route(meta RouteMeta, prefix []byte) (chain string, ok bool) {
+	if chain, ok = r.routeSignature(prefix); ok {
+		return chain, true
+	}
+	if chain, ok = r.routeType(meta.ContentType); ok {
+		return chain, true
+	}
+	return r.routeExtension(meta.Name)
+}
+
+func (r *Router) routeSignature(prefix []byte) (chain string, ok bool) {
+	n := 0
+	for _, route := range r.signatures {
+		if len(route.sig) > n && bytes.HasPrefix(prefix, route.sig) {
+			chain, ok, n = route.chain, true, len(route.sig)
+		}
+	}
+	return chain, ok
+}
+
+func (r *Router) routeType(contentType string) (chain string, ok bool) {
+	if contentType == "" {
+		return "", false
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return "", false
+	}
+	if chain, ok = r.types[mediaType]; ok {
+		return chain, true
+	}
+	if i := strings.IndexByte(mediaType, '/'); i >= 0 {
+		chain, ok = r.types[mediaType[:i]+"/*"]
+	}
+	return chain, ok
+}
+
+func (r *Router) routeExtension(name string) (chain string, ok bool) {
+	name = strings.ToLower(name)
+	n := 0
+	for ext, c := range r.extensions {
+		if len(ext) > n && strings.HasSuffix(name, ext) {
+			chain, ok, n = c, true, len(ext)
+		}
+	}
+	return chain, ok
+}
+
+// Resolve resolves the chain selected for content described by meta and read
+// from src, as by Route. If signatures are registered, a prefix of src is read
+// to match them, and is then read through the chain as usual. Each Option is
+// applied to the resolution.
+//
+// An error is returned as a *ResolveError. If no route matches, the default
+// chain is resolved if the Fallback option is given. Otherwise, the error
+// wraps NoRoute. As with ChainSet.Resolve, src is closed if an error is
+// returned.
+func (r *Router) Resolve(meta RouteMeta, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	var prefix []byte
+	if r.sniff > 0 && src != nil {
+		prefix = make([]byte, r.sniff)
+		n, err := io.ReadFull(src, prefix)
+		switch err {
+		case nil, io.EOF, io.ErrUnexpectedEOF:
+		default:
+			src.Close()
+			return nil, &ResolveError{Index: -1, Err: err}
+		}
+		prefix = prefix[:n]
+		src = prefixCloser{Reader: io.MultiReader(bytes.NewReader(prefix), src), Closer: src}
+	}
+	chain, ok := r.Route(meta, prefix)
+	if !ok {
+		chain, ok = r.set.Snapshot().fallback(opts)
+	}
+	if !ok {
+		if src != nil {
+			src.Close()
+		}
+		return nil, &ResolveError{Index: -1, Err: NoRoute}
+	}
+	return r.set.Resolve(chain, src, opts...)
+}
+
+// prefixCloser reads a sniffed prefix followed by the remainder of a source,
+// and closes the source.
+type prefixCloser struct {
+	io.Reader
+	io.Closer
+}
@@ -0,0 +1,157 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestRoute(t *testing.T) {
+	s := newChainSet(t, nil)
+	r := s.NewRouter().
+		Signature([]byte("\x1f\x8b"), "gzip").
+		Signature([]byte("PK"), "zip").
+		Signature([]byte("PK\x03\x04"), "zip-local").
+		Type("text/csv", "csv").
+		Type("TEXT/*", "text").
+		Type("image/*", "image").
+		Extension(".gz", "gz").
+		Extension(".TAR.gz", "tgz").
+		Extension(".txt", "txt")
+	tests := []struct {
+		name   string
+		meta   iofl.RouteMeta
+		prefix string
+		chain  string
+	}{
+		{"signature", iofl.RouteMeta{}, "\x1f\x8bdata", "gzip"},
+		{"signature over type", iofl.RouteMeta{ContentType: "text/csv"}, "\x1f\x8b", "gzip"},
+		{"signature over extension", iofl.RouteMeta{Name: "a.txt"}, "PKxx", "zip"},
+		{"longest signature", iofl.RouteMeta{}, "PK\x03\x04rest", "zip-local"},
+		{"short prefix", iofl.RouteMeta{Name: "a.gz"}, "\x1f", "gz"},
+		{"exact type", iofl.RouteMeta{ContentType: "text/csv; charset=utf-8"}, "a,b", "csv"},
+		{"wildcard type", iofl.RouteMeta{ContentType: "Text/Plain"}, "", "text"},
+		{"type over extension", iofl.RouteMeta{ContentType: "image/png", Name: "a.gz"}, "", "image"},
+		{"invalid type", iofl.RouteMeta{ContentType: "text/", Name: "a.txt"}, "", "txt"},
+		{"unknown type", iofl.RouteMeta{ContentType: "application/json", Name: "a.gz"}, "", "gz"},
+		{"extension", iofl.RouteMeta{Name: "dir/FILE.TXT"}, "", "txt"},
+		{"longest extension", iofl.RouteMeta{Name: "a.tar.gz"}, "", "tgz"},
+		{"no route", iofl.RouteMeta{ContentType: "application/json", Name: "a.json"}, "{}", ""},
+		{"empty", iofl.RouteMeta{}, "", ""},
+	}
+	for _, tt := range tests {
+		chain, ok := r.Route(tt.meta, []byte(tt.prefix))
+		if chain != tt.chain || ok != (tt.chain != "") {
+			t.Errorf("%s: got %q, %v, want %q", tt.name, chain, ok, tt.chain)
+		}
+	}
+
+	// Routes are replaced.
+	r.Signature([]byte("PK"), "zip2").Type("text/csv", "csv2").Extension(".TXT", "txt2")
+	for meta, want := range map[iofl.RouteMeta]string{
+		{ContentType: "text/csv"}: "csv2",
+		{Name: "a.txt"}:           "txt2",
+	} {
+		if chain, _ := r.Route(meta, nil); chain != want {
+			t.Errorf("%+v: got %q, want %q", meta, chain, want)
+		}
+	}
+	if chain, _ := r.Route(iofl.RouteMeta{}, []byte("PKx")); chain != "zip2" {
+		t.Errorf("replaced signature: got %q", chain)
+	}
+}
+
+func TestRouterResolve(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"rot13": {{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}},
+	})
+	r := s.NewRouter().
+		Signature([]byte("#!"), "upper").
+		Extension(".r13", "rot13").
+		Extension(".bad", "missing")
+
+	// The sniffed prefix is read through the chain.
+	f, err := r.Resolve(iofl.RouteMeta{Name: "a.r13"}, source("#!script"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "#!SCRIPT" {
+		t.Errorf("got %q", got)
+	}
+	// Content shorter than the longest signature is read in full.
+	f, err = r.Resolve(iofl.RouteMeta{Name: "a.r13"}, source("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "n" {
+		t.Errorf("got %q", got)
+	}
+	// Closing the chain closes the source.
+	src := &closeCounter{Reader: bytes.NewReader([]byte("#!"))}
+	f, err = r.Resolve(iofl.RouteMeta{}, src)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if src.closes != 1 {
+		t.Errorf("source closed %d times", src.closes)
+	}
+
+	var rerr *iofl.ResolveError
+	if _, err := r.Resolve(iofl.RouteMeta{Name: "a.txt"}, source("")); !errors.Is(err, iofl.NoRoute) || !errors.As(err, &rerr) {
+		t.Errorf("got %v, want NoRoute", err)
+	}
+	if _, err := r.Resolve(iofl.RouteMeta{Name: "a.bad"}, source("")); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+	src = &closeCounter{Reader: iotest.ErrReader(errBoom)}
+	if _, err := r.Resolve(iofl.RouteMeta{}, src); !errors.Is(err, errBoom) || !errors.As(err, &rerr) {
+		t.Errorf("got %v, want read error", err)
+	}
+	if src.closes != 1 {
+		t.Errorf("source closed %d times after error", src.closes)
+	}
+
+	// The default chain is resolved with the Fallback option.
+	config := s.Config()
+	config.DefaultChain = "rot13"
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Resolve(iofl.RouteMeta{}, source("x")); !errors.Is(err, iofl.NoRoute) {
+		t.Errorf("without Fallback: got %v, want NoRoute", err)
+	}
+	f, err = r.Resolve(iofl.RouteMeta{}, source("abc"), iofl.Fallback())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "nop" {
+		t.Errorf("got %q", got)
+	}
+
+	// Without signatures, the source is not read before resolving.
+	plain := s.NewRouter().Extension(".up", "upper")
+	var reads int
+	f, err = plain.Resolve(iofl.RouteMeta{Name: "a.up"}, ioutil.NopCloser(readFunc(func(p []byte) (int, error) {
+		reads++
+		return 0, io.EOF
+	})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reads != 0 {
+		t.Errorf("source read %d times before resolving", reads)
+	}
+	f.Close()
+}
+
+// readFunc is an io.Reader that calls itself.
+type readFunc func(p []byte) (int, error)
+
+func (f readFunc) Read(p []byte) (int, error) { return f(p) }
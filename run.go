@@ -0,0 +1,84 @@
+package iofl
+
+import (
+	"io"
+	"time"
+)
+
+// defaultRunBufferSize is the size of the buffer used by Run when a Scheduler
+// is not configured.
+const defaultRunBufferSize = 32 * 1024
+
+// Schedule returns an Option that causes Run to acquire its copy buffer and
+// transfer bandwidth from sched, with the given priority.
+func Schedule(sched *Scheduler, p Priority) Option {
+	return func(o *resolveOptions) {
+		o.sched = sched
+		o.priority = p
+	}
+}
+
+// Run resolves chain with src, copies the output of the chain to dst, and
+// closes the chain. If the chain cannot be resolved, src is closed, as by
+// Resolve. Returns a report of the run, including the number of bytes
+// written to dst, and the work of each link. Each Option is applied to the
+// resolution of the chain. With the Sparse option, runs of zero bytes may be
+// seeked over rather than written to dst.
+func (s *ChainSet) Run(chain string, dst io.Writer, src io.ReadCloser, opts ...Option) (report RunReport, err error) {
+	start := time.Now()
+	report.Chain = chain
+	var links []*statsFilter
+	opts = append(opts[:len(opts):len(opts)], Decorate(instrument(&links)))
+	o := newResolveOptions(opts)
+	f, err := s.Resolve(chain, src, opts...)
+	if err != nil {
+		return report, err
+	}
+	w := o.sparseWriter(dst)
+	report.Written, err = o.copy(w, f)
+	if sw, ok := w.(*sparseWriter); ok {
+		if serr := sw.Close(); err == nil {
+			err = serr
+		}
+		report.Skipped = sw.skipped
+	}
+	report.Links = linkReports(links)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	report.Duration = time.Since(start)
+	return report, err
+}
+
+// copy copies from src to dst, using resources from the configured Scheduler.
+func (o *resolveOptions) copy(dst io.Writer, src io.Reader) (n int64, err error) {
+	var buf []byte
+	if o.sched != nil {
+		buf = o.sched.AcquireBuffer(o.priority)
+		defer o.sched.ReleaseBuffer(buf)
+	} else {
+		buf = make([]byte, defaultRunBufferSize)
+	}
+	for {
+		nr, rerr := src.Read(buf)
+		if nr > 0 {
+			if o.sched != nil {
+				o.sched.Limiter.WaitN(o.priority, nr)
+			}
+			nw, werr := dst.Write(buf[:nr])
+			n += int64(nw)
+			if werr != nil {
+				return n, werr
+			}
+			if nw != nr {
+				return n, io.ErrShortWrite
+			}
+		}
+		if rerr == io.EOF {
+			return n, nil
+		}
+		if rerr != nil {
+			return n, rerr
+		}
+	}
+}
@@ -0,0 +1,228 @@
+package iofl
+
+import (
+	"sync"
+	"time"
+)
+
+// Priority is the priority class of a run. When resources are contended, runs
+// of a higher priority are served before runs of a lower priority.
+type Priority int
+
+const (
+	// PriorityBackground is for bulk work that may be delayed indefinitely,
+	// such as batch re-encoding.
+	PriorityBackground Priority = -1
+	// PriorityNormal is the default priority.
+	PriorityNormal Priority = 0
+	// PriorityInteractive is for work that a user is waiting on.
+	PriorityInteractive Priority = 1
+)
+
+// waiter is a blocked request for a resource.
+type waiter struct {
+	priority Priority
+	seq      uint64
+	ready    chan struct{}
+	buf      []byte
+}
+
+// waitQueue orders waiters by priority, and then by arrival.
+type waitQueue struct {
+	seq     uint64
+	waiters []*waiter
+}
+
+func (q *waitQueue) push(p Priority) *waiter {
+	q.seq++
+	w := &waiter{priority: p, seq: q.seq, ready: make(chan struct{}, 1)}
+	i := len(q.waiters)
+	for i > 0 && q.waiters[i-1].priority < p {
+		i--
+	}
+	q.waiters = append(q.waiters, nil)
+	copy(q.waiters[i+1:], q.waiters[i:])
+	q.waiters[i] = w
+	return w
+}
+
+func (q *waitQueue) head() *waiter {
+	if len(q.waiters) == 0 {
+		return nil
+	}
+	return q.waiters[0]
+}
+
+func (q *waitQueue) remove(w *waiter) {
+	for i, v := range q.waiters {
+		if v == w {
+			copy(q.waiters[i:], q.waiters[i+1:])
+			q.waiters[len(q.waiters)-1] = nil
+			q.waiters = q.waiters[:len(q.waiters)-1]
+			return
+		}
+	}
+}
+
+// notify wakes the waiter at the head of the queue, if any.
+func (q *waitQueue) notify() {
+	if w := q.head(); w != nil {
+		select {
+		case w.ready <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// Limiter limits the rate at which bytes are transferred, using a token
+// bucket. Waiters of a higher priority are served first. A Limiter is safe for
+// concurrent use.
+type Limiter struct {
+	mu     sync.Mutex
+	rate   float64
+	burst  float64
+	tokens float64
+	last   time.Time
+	queue  waitQueue
+}
+
+// NewLimiter returns a Limiter that allows rate bytes per second, with bursts
+// of up to burst bytes. If burst is less than 1, it is set to rate. If rate is
+// less than 1, the Limiter does not limit.
+func NewLimiter(rate, burst int64) *Limiter {
+	if burst < 1 {
+		burst = rate
+	}
+	return &Limiter{
+		rate:   float64(rate),
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+// refill adds tokens accumulated since the last refill.
+func (l *Limiter) refill(now time.Time) {
+	l.tokens += now.Sub(l.last).Seconds() * l.rate
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.last = now
+}
+
+// WaitN blocks until n bytes may be transferred by a run of priority p. A
+// request larger than the burst size is allowed once the bucket is full, and
+// delays subsequent requests accordingly.
+func (l *Limiter) WaitN(p Priority, n int) {
+	if l == nil || n <= 0 {
+		return
+	}
+	l.mu.Lock()
+	w := l.queue.push(p)
+	for {
+		if l.queue.head() != w {
+			l.mu.Unlock()
+			<-w.ready
+			l.mu.Lock()
+			continue
+		}
+		if l.rate < 1 {
+			l.queue.remove(w)
+			l.queue.notify()
+			l.mu.Unlock()
+			return
+		}
+		l.refill(time.Now())
+		need := float64(n)
+		if need > l.burst {
+			need = l.burst
+		}
+		if l.tokens >= need {
+			l.tokens -= float64(n)
+			l.queue.remove(w)
+			l.queue.notify()
+			l.mu.Unlock()
+			return
+		}
+		d := time.Duration((need - l.tokens) / l.rate * float64(time.Second))
+		l.mu.Unlock()
+		timer := time.NewTimer(d)
+		select {
+		case <-timer.C:
+		case <-w.ready:
+			timer.Stop()
+		}
+		l.mu.Lock()
+	}
+}
+
+// Scheduler arbitrates shared resources between concurrent runs according to
+// their priority: a fixed pool of copy buffers, and an optional Limiter on the
+// total transfer rate. A Scheduler is safe for concurrent use.
+type Scheduler struct {
+	// Limiter limits the total rate of all runs using the Scheduler. If nil,
+	// the rate is not limited.
+	Limiter *Limiter
+
+	mu      sync.Mutex
+	size    int
+	free    [][]byte
+	waiting waitQueue
+}
+
+// NewScheduler returns a Scheduler with a pool of n buffers, each of the given
+// size. limiter optionally limits the total transfer rate.
+func NewScheduler(n, size int, limiter *Limiter) *Scheduler {
+	s := &Scheduler{Limiter: limiter, size: size}
+	for i := 0; i < n; i++ {
+		s.free = append(s.free, make([]byte, size))
+	}
+	return s
+}
+
+// AcquireBuffer blocks until a buffer is available to a run of priority p, and
+// returns it. The buffer must be returned with ReleaseBuffer.
+func (s *Scheduler) AcquireBuffer(p Priority) []byte {
+	s.mu.Lock()
+	if n := len(s.free); n > 0 && s.waiting.head() == nil {
+		b := s.free[n-1]
+		s.free = s.free[:n-1]
+		s.mu.Unlock()
+		return b
+	}
+	w := s.waiting.push(p)
+	s.mu.Unlock()
+	<-w.ready
+	return w.buf
+}
+
+// ReleaseBuffer returns a buffer acquired with AcquireBuffer. The buffer is
+// handed to the waiting run of the highest priority, if any.
+func (s *Scheduler) ReleaseBuffer(b []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if w := s.waiting.head(); w != nil {
+		s.waiting.remove(w)
+		w.buf = b
+		w.ready <- struct{}{}
+		return
+	}
+	s.free = append(s.free, b)
+}
+
+// SetRate changes the rate and burst size of the Limiter. If burst is less than
+// 1, it is set to rate. If rate is less than 1, the Limiter does not limit.
+func (l *Limiter) SetRate(rate, burst int64) {
+	if burst < 1 {
+		burst = rate
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.refill(time.Now())
+	l.rate = float64(rate)
+	l.burst = float64(burst)
+	if l.tokens > l.burst {
+		l.tokens = l.burst
+	}
+	l.queue.notify()
+}
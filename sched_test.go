@@ -0,0 +1,135 @@
+package iofl_test
+
+import (
+	"bytes"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+// order runs each function in its own goroutine, starting them in the given
+// order with a short delay between, and returns the order in which they
+// completed.
+func order(start func(), fns ...func()) []int {
+	var mu sync.Mutex
+	var done []int
+	var wg sync.WaitGroup
+	for i, fn := range fns {
+		wg.Add(1)
+		go func(i int, fn func()) {
+			defer wg.Done()
+			fn()
+			mu.Lock()
+			done = append(done, i)
+			mu.Unlock()
+		}(i, fn)
+		time.Sleep(20 * time.Millisecond)
+	}
+	start()
+	wg.Wait()
+	return done
+}
+
+func TestSchedulerBuffers(t *testing.T) {
+	s := iofl.NewScheduler(1, 16, nil)
+	held := s.AcquireBuffer(iofl.PriorityNormal)
+	if len(held) != 16 {
+		t.Fatalf("got buffer of %d bytes, want 16", len(held))
+	}
+	acquire := func(p iofl.Priority) func() {
+		return func() {
+			b := s.AcquireBuffer(p)
+			time.Sleep(5 * time.Millisecond)
+			s.ReleaseBuffer(b)
+		}
+	}
+	got := order(func() { s.ReleaseBuffer(held) },
+		acquire(iofl.PriorityBackground),
+		acquire(iofl.PriorityNormal),
+		acquire(iofl.PriorityInteractive),
+		acquire(iofl.PriorityNormal),
+	)
+	want := []int{2, 1, 3, 0}
+	if len(got) != len(want) {
+		t.Fatalf("got %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got %v, want %v", got, want)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	var l *iofl.Limiter
+	l.WaitN(iofl.PriorityNormal, 100)
+
+	l = iofl.NewLimiter(0, 0)
+	start := time.Now()
+	l.WaitN(iofl.PriorityNormal, 1<<30)
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("unlimited limiter blocked")
+	}
+
+	l = iofl.NewLimiter(1000, 100)
+	start = time.Now()
+	l.WaitN(iofl.PriorityNormal, 100)
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("burst blocked")
+	}
+	l.WaitN(iofl.PriorityNormal, 100)
+	if d := time.Since(start); d < 80*time.Millisecond {
+		t.Errorf("rate not limited: took %v", d)
+	}
+
+	l.SetRate(0, 0)
+	start = time.Now()
+	l.WaitN(iofl.PriorityNormal, 1<<20)
+	if time.Since(start) > 50*time.Millisecond {
+		t.Error("limiter blocked after rate removed")
+	}
+}
+
+func TestLimiterPriority(t *testing.T) {
+	l := iofl.NewLimiter(1000, 10)
+	l.WaitN(iofl.PriorityNormal, 10)
+	l.SetRate(1, 10)
+	wait := func(p iofl.Priority) func() {
+		return func() { l.WaitN(p, 10) }
+	}
+	got := order(func() { l.SetRate(100000, 10) },
+		wait(iofl.PriorityBackground),
+		wait(iofl.PriorityInteractive),
+	)
+	if len(got) != 2 || got[0] != 1 {
+		t.Errorf("got %v, want interactive first", got)
+	}
+}
+
+func TestRunSchedule(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+	})
+	sched := iofl.NewScheduler(2, 4, iofl.NewLimiter(0, 0))
+	var wg sync.WaitGroup
+	for i := 0; i < 6; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			var buf bytes.Buffer
+			in := strings.Repeat("abc", 100)
+			report, err := s.Run("upper", &buf, source(in), iofl.Schedule(sched, iofl.PriorityNormal))
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if buf.String() != strings.ToUpper(in) || report.Written != int64(len(in)) {
+				t.Errorf("got %d bytes written", report.Written)
+			}
+		}()
+	}
+	wg.Wait()
+}
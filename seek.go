@@ -0,0 +1,70 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+)
+
+// NotSeekable is returned by ResolveSeeker when the output of a chain cannot be
+// seeked.
+var NotSeekable = errors.New("chain is not seekable")
+
+// ResolveSeeker behaves the same as Resolve, but produces an io.ReadSeekCloser
+// over the output of the chain, for callers that require random access. The
+// output is seekable if the last link of the chain implements io.Seeker, such
+// as a filter that provides random access over a seekable source, or if the
+// chain is empty, in which case src is seeked directly. The returned value
+// also implements Filter.
+//
+// Wrappers applied by the ChainSet, such as for ChainLimit, OnClose, and
+// ReapIdle, do not affect whether the output is seekable, but wrappers applied
+// by options such as Decorate and MaxRatio hide the last link. If the output
+// is not seekable, the resolved chain is closed, and NotSeekable is returned
+// as a *ResolveError.
+func (s *ChainSet) ResolveSeeker(chain string, src io.ReadSeekCloser, opts ...Option) (r io.ReadSeekCloser, err error) {
+	filter, err := s.Resolve(chain, src, opts...)
+	if err != nil {
+		return nil, err
+	}
+	if filter == nil {
+		return nil, &ResolveError{Chain: chain, Index: -1, Err: NotSeekable}
+	}
+	seeker, ok := findSeeker(filter)
+	if !ok {
+		filter.Close()
+		return nil, &ResolveError{Chain: chain, Index: -1, Err: NotSeekable}
+	}
+	return seekFilter{Filter: filter, Seeker: seeker}, nil
+}
+
+// findSeeker returns the io.Seeker that produces the output of r, looking
+// through wrappers that do not alter the output.
+func findSeeker(r io.ReadCloser) (io.Seeker, bool) {
+	for {
+		if s, ok := r.(io.Seeker); ok {
+			return s, true
+		}
+		switch v := r.(type) {
+		case Root:
+			r = v.ReadCloser
+		case *releaseFilter:
+			r = v.f
+		case *idleFilter:
+			r = v.f
+		case *hooked:
+			r = v.f
+		case *resolvedFilter:
+			r = v.f
+		case resolvedFramer:
+			r = v.f
+		default:
+			return nil, false
+		}
+	}
+}
+
+// seekFilter is a Filter whose output is seeked with Seeker.
+type seekFilter struct {
+	Filter
+	io.Seeker
+}
@@ -0,0 +1,108 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// seekSource is a seekable source that records whether it is closed.
+type seekSource struct {
+	*strings.Reader
+	closed bool
+}
+
+func (s *seekSource) Close() error {
+	s.closed = true
+	return nil
+}
+
+// seekFilter is a Filter that passes through a seekable source, which may be
+// wrapped in an iofl.Root.
+type seekFilter struct {
+	funcFilter
+	io.Seeker
+}
+
+var seekDef = iofl.FilterDef{
+	Name: "seek",
+	New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+		src := r
+		if root, ok := src.(iofl.Root); ok {
+			src = root.ReadCloser
+		}
+		s, ok := src.(io.ReadSeeker)
+		if !ok {
+			return nil, errors.New("source is not seekable")
+		}
+		return &seekFilter{funcFilter: funcFilter{src: r, read: s.Read}, Seeker: s}, nil
+	},
+}
+
+func TestResolveSeeker(t *testing.T) {
+	s := newChainSet(t, nil, seekDef)
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"empty":   {},
+			"seek":    {{Filter: "seek"}},
+			"limited": {{Filter: "seek"}},
+		},
+		Limits: map[string]iofl.ChainLimit{"limited": {Max: 1}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.OnClose(func(string, int, iofl.LinkDef, iofl.Filter) {})
+	for _, chain := range []string{"empty", "seek", "limited"} {
+		src := &seekSource{Reader: strings.NewReader("abcdef")}
+		r, err := s.ResolveSeeker(chain, src)
+		if err != nil {
+			t.Fatalf("%s: %v", chain, err)
+		}
+		if _, err := r.Seek(3, io.SeekStart); err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, r); got != "def" {
+			t.Errorf("%s: got %q, want %q", chain, got, "def")
+		}
+		if !src.closed {
+			t.Errorf("%s: source not closed", chain)
+		}
+		if _, ok := r.(iofl.Filter); !ok {
+			t.Errorf("%s: result is not a Filter", chain)
+		}
+	}
+}
+
+func TestResolveSeekerNotSeekable(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"plain": {{Filter: "translate"}},
+		"seek":  {{Filter: "seek"}},
+	}, seekDef)
+	decorate := iofl.Decorate(func(link iofl.Link, f iofl.Filter) iofl.Filter {
+		return &funcFilter{src: f, read: f.Read}
+	})
+	for _, tt := range []struct {
+		chain string
+		opts  []iofl.Option
+	}{
+		{"plain", nil},
+		{"seek", []iofl.Option{decorate}},
+	} {
+		src := &seekSource{Reader: strings.NewReader("abc")}
+		_, err := s.ResolveSeeker(tt.chain, src, tt.opts...)
+		var rerr *iofl.ResolveError
+		if !errors.As(err, &rerr) || !errors.Is(err, iofl.NotSeekable) {
+			t.Errorf("%s: got %v, want NotSeekable", tt.chain, err)
+		}
+		if !src.closed {
+			t.Errorf("%s: chain not closed", tt.chain)
+		}
+	}
+	if _, err := s.ResolveSeeker("missing", &seekSource{Reader: strings.NewReader("")}); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+}
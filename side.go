@@ -0,0 +1,49 @@
+package iofl
+
+import (
+	"fmt"
+	"io"
+)
+
+// SideOutputter is implemented by a Filter that produces side outputs:
+// secondary streams, such as rejected records or extracted metadata, produced
+// alongside its main output. Side outputs are directed to sinks with the
+// #outputs meta-parameter.
+type SideOutputter interface {
+	// SideOutputs returns the names of the side outputs of the filter.
+	SideOutputs() []string
+	// SetSideOutput directs the side output of the given name to w. A side
+	// output that has not been set is discarded.
+	SetSideOutput(name string, w io.Writer)
+}
+
+// applyOutputs directs the side outputs of f according to the #outputs
+// meta-parameter, if present, which maps the name of a side output to the name
+// of a sink. meta has been validated.
+func (o *resolveOptions) applyOutputs(meta Params, f Filter) error {
+	v, ok := meta["#outputs"]
+	if !ok {
+		return nil
+	}
+	outputs := v.(map[string]interface{})
+	so, ok := f.(SideOutputter)
+	if !ok {
+		return fmt.Errorf("#outputs: filter has no side outputs")
+	}
+	names := map[string]bool{}
+	for _, name := range so.SideOutputs() {
+		names[name] = true
+	}
+	for name, v := range outputs {
+		if !names[name] {
+			return fmt.Errorf("#outputs: unknown side output %q", name)
+		}
+		sink, _ := v.(string)
+		w, ok := o.sinks[sink]
+		if !ok {
+			return fmt.Errorf("#outputs: %s: unknown sink %q", name, sink)
+		}
+		so.SetSideOutput(name, w)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package iofl_test
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// sideFilter is a Filter that passes through its source, writing a copy of
+// each vowel to its "vowels" side output.
+type sideFilter struct {
+	funcFilter
+	vowels io.Writer
+}
+
+func (f *sideFilter) SideOutputs() []string { return []string{"vowels"} }
+
+func (f *sideFilter) SetSideOutput(name string, w io.Writer) {
+	if name == "vowels" {
+		f.vowels = w
+	}
+}
+
+var sideDef = iofl.FilterDef{
+	Name: "side",
+	New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+		f := &sideFilter{}
+		f.funcFilter = funcFilter{src: r, read: func(p []byte) (int, error) {
+			n, err := r.Read(p)
+			if f.vowels != nil {
+				for _, c := range p[:n] {
+					if strings.IndexByte("aeiou", c) >= 0 {
+						f.vowels.Write([]byte{c})
+					}
+				}
+			}
+			return n, err
+		}}
+		return f, nil
+	},
+}
+
+func TestSideOutputs(t *testing.T) {
+	outputs := func(sink string) iofl.Params {
+		return iofl.Params{"#outputs": map[string]interface{}{"vowels": sink}}
+	}
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c":       {{Filter: "side", Params: outputs("v")}, {Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"unset":   {{Filter: "side"}},
+		"none":    {{Filter: "translate", Params: outputs("v")}},
+		"unknown": {{Filter: "side", Params: iofl.Params{"#outputs": map[string]interface{}{"other": "v"}}}},
+		"nosink":  {{Filter: "side", Params: outputs("missing")}},
+	}, sideDef)
+
+	var vowels bytes.Buffer
+	f, err := s.Resolve("c", source("banana split"), iofl.Sink("v", &vowels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "BANANA SPLIT" {
+		t.Errorf("got %q", got)
+	}
+	if got := vowels.String(); got != "aaai" {
+		t.Errorf("got side output %q, want %q", got, "aaai")
+	}
+
+	// A side output that is not directed is discarded.
+	f, err = s.Resolve("unset", source("abc"), iofl.Sink("v", &vowels))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "abc" {
+		t.Errorf("got %q", got)
+	}
+
+	for chain, msg := range map[string]string{
+		"none":    "filter has no side outputs",
+		"unknown": `unknown side output "other"`,
+		"nosink":  `unknown sink "missing"`,
+	} {
+		_, err := s.Resolve(chain, source(""), iofl.Sink("v", &vowels))
+		if err == nil || !strings.Contains(err.Error(), msg) {
+			t.Errorf("%s: got %v, want %q", chain, err, msg)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+package iofl
+
+import "io"
+
+// SizePreserver is implemented by a Filter that may produce exactly as many
+// bytes as it reads from its source.
+type SizePreserver interface {
+	// PreservesSize returns whether the filter produces exactly as many bytes
+	// as it reads from its source.
+	PreservesSize() bool
+}
+
+// PreservesSize returns whether the chain of r produces exactly as many bytes
+// as are read from its root. Each Filter in the chain that has a source must
+// implement SizePreserver and report true.
+func PreservesSize(r io.ReadCloser) bool {
+	for r != nil {
+		f, ok := r.(Filter)
+		if !ok {
+			break
+		}
+		src := f.Source()
+		if src == nil {
+			break
+		}
+		if sp, ok := f.(SizePreserver); !ok || !sp.PreservesSize() {
+			return false
+		}
+		r = src
+	}
+	return true
+}
@@ -0,0 +1,35 @@
+package iofl_test
+
+import (
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestPreservesSize(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"empty": {},
+		"map":   {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}, {Filter: "translate"}},
+		"del":   {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}, {Filter: "translate", Params: iofl.Params{"delete": "a"}}},
+		"other": {{Filter: "hook"}},
+	}, hookFilter("hook", func() {}))
+	tests := map[string]bool{
+		"empty": true,
+		"map":   true,
+		"del":   false,
+		"other": false,
+	}
+	for chain, want := range tests {
+		f, err := s.Resolve(chain, source(""))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := iofl.PreservesSize(f); got != want {
+			t.Errorf("%s: got %v, want %v", chain, got, want)
+		}
+		f.Close()
+	}
+	if !iofl.PreservesSize(nil) {
+		t.Error("nil: got false, want true")
+	}
+}
@@ -0,0 +1,118 @@
+package iofl
+
+import (
+	"io"
+	"sort"
+)
+
+// Snapshot is an immutable view of the filters, chains, limits, and hooks of a
+// ChainSet at a point in time. It is unaffected by later changes to the
+// ChainSet, such as by Register, SetConfig, or Reload, and resolves chains
+// without acquiring the lock of the ChainSet. A Snapshot is safe for
+// concurrent use.
+//
+// Chains resolved from a Snapshot share the limiters and bandwidth limiters of
+// the ChainSet as they were when the Snapshot was taken.
+type Snapshot struct {
+	set         *ChainSet
+	registry    map[string]FilterDef
+	chains      map[string]Chain
+	tenants     map[string]map[string]Chain
+	limits      map[string]*chainLimiter
+	onConstruct []Hook
+	onClose     []Hook
+	vars        map[string][]VarDef
+
+	defaultChain string
+}
+
+// Snapshot returns a Snapshot of the current state of the ChainSet. The
+// Snapshot is cached until the state changes, so calling Snapshot is cheap
+// when the ChainSet is not being modified.
+func (s *ChainSet) Snapshot() *Snapshot {
+	if v, _ := s.snapshot.Load().(*Snapshot); v != nil {
+		return v
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	// The snapshot is stored while the lock is held, so that it cannot replace
+	// the invalidation of a change made after it was taken.
+	v := &Snapshot{
+		set:      s,
+		registry: make(map[string]FilterDef, len(s.registry)),
+		// The chains, tenants, and limits maps are replaced rather than
+		// modified, so they may be shared.
+		chains:  s.chains,
+		tenants: s.tenants,
+		limits:  s.limits,
+		vars:    s.vars,
+		// Hooks are only appended, so the slices may be shared.
+		onConstruct: s.onConstruct[:len(s.onConstruct):len(s.onConstruct)],
+		onClose:     s.onClose[:len(s.onClose):len(s.onClose)],
+
+		defaultChain: s.defaultChain,
+	}
+	for k, def := range s.registry {
+		v.registry[k] = def
+	}
+	s.snapshot.Store(v)
+	return v
+}
+
+// invalidate discards the cached Snapshot. s.mu must be held for writing.
+func (s *ChainSet) invalidate() {
+	s.snapshot.Store((*Snapshot)(nil))
+}
+
+// Filter returns the definition of the filter of the given name.
+func (v *Snapshot) Filter(name string) (def FilterDef, ok bool) {
+	def, ok = v.registry[name]
+	return def, ok
+}
+
+// Chain returns the chain of the given name.
+func (v *Snapshot) Chain(name string) (chain Chain, ok bool) {
+	chain, ok = v.chains[name]
+	return chain, ok
+}
+
+// tenantChain returns the chain of the given name, looking first at the chains
+// specific to the tenant of the given ID.
+func (v *Snapshot) tenantChain(id, name string) (chain Chain, ok bool) {
+	if chain, ok = v.tenants[id][name]; ok {
+		return chain, true
+	}
+	return v.Chain(name)
+}
+
+// Chains returns the names of the chains, in order.
+func (v *Snapshot) Chains() []string {
+	names := make([]string, 0, len(v.chains))
+	for name := range v.chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve behaves the same as ChainSet.Resolve, but locates the chain, the
+// chains it refers to, and their filters within the Snapshot.
+func (v *Snapshot) Resolve(chain string, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	filterChain, ok := v.Chain(chain)
+	if !ok {
+		if def, fallback := v.fallback(opts); fallback && def != chain {
+			return v.Resolve(def, src, opts...)
+		}
+		if src != nil {
+			src.Close()
+		}
+		return nil, &ResolveError{Chain: chain, Index: -1, Err: UnknownChain}
+	}
+	return v.resolve(chain, filterChain, v.Chain, src, opts)
+}
+
+// ResolveChain behaves the same as ChainSet.ResolveChain, but locates the
+// chains referred to by chain, and their filters, within the Snapshot.
+func (v *Snapshot) ResolveChain(chain Chain, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	return v.resolve("", chain, v.Chain, src, opts)
+}
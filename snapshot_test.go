@@ -0,0 +1,147 @@
+package iofl_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"reflect"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestSnapshot(t *testing.T) {
+	upper := iofl.Params{"preset": "upper"}
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c":   {{Filter: "translate", Params: upper}},
+		"ref": {{Chain: "c"}, {Filter: "identity"}},
+	})
+	v := s.Snapshot()
+	if s.Snapshot() != v {
+		t.Error("snapshot of unchanged ChainSet is not cached")
+	}
+	var constructed int32
+	s.OnConstruct(func(string, int, iofl.LinkDef, iofl.Filter) { atomic.AddInt32(&constructed, 1) })
+	s.Register(hookFilter("new", func() {}))
+	config := s.Config()
+	config.Chains["c"] = iofl.Chain{{Filter: "new"}}
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.AddChain("added", iofl.Chain{{Filter: "translate"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// The snapshot is unaffected by the changes.
+	if got := v.Chains(); !reflect.DeepEqual(got, []string{"c", "ref"}) {
+		t.Errorf("got chains %v", got)
+	}
+	if _, ok := v.Filter("new"); ok {
+		t.Error("snapshot has filter registered after it")
+	}
+	if def, ok := v.Filter("translate"); !ok || def.Name != "translate" {
+		t.Errorf("got filter %+v, %v", def, ok)
+	}
+	if chain, ok := v.Chain("c"); !ok || chain[0].Filter != "translate" {
+		t.Errorf("got chain %v, %v", chain, ok)
+	}
+	if _, ok := v.Chain("added"); ok {
+		t.Error("snapshot has chain added after it")
+	}
+	f, err := v.Resolve("ref", source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "ABC" {
+		t.Errorf("got %q", got)
+	}
+	if _, err := v.Resolve("added", source("")); err == nil {
+		t.Error("resolved chain added after snapshot")
+	}
+	f, err = v.ResolveChain(iofl.Chain{{Chain: "c"}}, source("x"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "X" {
+		t.Errorf("got %q", got)
+	}
+	if n := atomic.LoadInt32(&constructed); n != 0 {
+		t.Errorf("hook added after snapshot was called %d times", n)
+	}
+
+	// A new snapshot reflects the changes.
+	w := s.Snapshot()
+	if w == v {
+		t.Fatal("snapshot not replaced after change")
+	}
+	if _, ok := w.Chain("added"); !ok {
+		t.Error("new snapshot missing added chain")
+	}
+	f, err = w.Resolve("ref", source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "abc" {
+		t.Errorf("got %q", got)
+	}
+	if n := atomic.LoadInt32(&constructed); n != 2 {
+		t.Errorf("hook called %d times, want 2", n)
+	}
+
+	// Each kind of change replaces the snapshot.
+	for name, change := range map[string]func(){
+		"OnClose":  func() { s.OnClose(func(string, int, iofl.LinkDef, iofl.Filter) {}) },
+		"Register": func() { s.Register(hookFilter("other", func() {})) },
+		"Reload":   func() { s.Reload(s.Config()) },
+		"Tenant":   func() { s.Tenant("t").SetChain("c", iofl.Chain{{Filter: "identity"}}) },
+	} {
+		v := s.Snapshot()
+		change()
+		if s.Snapshot() == v {
+			t.Errorf("%s: snapshot not replaced", name)
+		}
+	}
+}
+
+func TestSnapshotConcurrent(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+	})
+	var stop int32
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for atomic.LoadInt32(&stop) == 0 {
+				f, err := s.Snapshot().Resolve("c", source("abc"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b, _ := ioutil.ReadAll(f)
+				f.Close()
+				if string(b) != "ABC" {
+					t.Errorf("got %q", b)
+					return
+				}
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		name := fmt.Sprintf("f%d", i)
+		if err := s.Register(hookFilter(name, func() {})); err != nil {
+			t.Fatal(err)
+		}
+		s.OnConstruct(func(string, int, iofl.LinkDef, iofl.Filter) {})
+		if err := s.AddChain(name, iofl.Chain{{Filter: name}}); err != nil {
+			t.Fatal(err)
+		}
+		if err := s.Reload(s.Config()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	atomic.StoreInt32(&stop, 1)
+	wg.Wait()
+}
@@ -0,0 +1,131 @@
+package iofl
+
+import "io"
+
+// Sparse returns an Option that causes Run to produce a sparse destination.
+// When the destination implements io.Seeker, such as an *os.File, runs of at
+// least size zero bytes in the output of the chain are skipped by seeking
+// instead of being written, leaving holes in file systems that support them.
+// This prevents the output of disk images and similar content from being
+// inflated. The destination must contain no data beyond its current offset,
+// such as a newly created or truncated file. Has no effect if size is less
+// than 1, or if the destination is not seekable.
+func Sparse(size int) Option {
+	return func(o *resolveOptions) {
+		o.sparse = size
+	}
+}
+
+// sparseWriter writes to a seekable destination, seeking over runs of zero
+// bytes.
+type sparseWriter struct {
+	w io.WriteSeeker
+	// min is the minimum length of a run that is seeked over.
+	min int
+	// pending is the length of the current run of zero bytes, which has not
+	// yet been written.
+	pending int64
+	// skipped is the total number of bytes seeked over.
+	skipped int64
+}
+
+// sparseWriter returns a *sparseWriter if sparse copying is configured and w
+// is seekable. Otherwise, w is returned.
+func (o *resolveOptions) sparseWriter(w io.Writer) io.Writer {
+	if o.sparse < 1 {
+		return w
+	}
+	ws, ok := w.(io.WriteSeeker)
+	if !ok {
+		return w
+	}
+	return &sparseWriter{w: ws, min: o.sparse}
+}
+
+// zeros is written in place of runs of zero bytes that are too short to skip.
+var zeros [4096]byte
+
+// flush writes or skips the pending run. If final is true, the last byte of a
+// skipped run is written, so that the length of the destination includes the
+// run.
+func (w *sparseWriter) flush(final bool) error {
+	if w.pending == 0 {
+		return nil
+	}
+	if w.pending >= int64(w.min) {
+		skip := w.pending
+		if final {
+			skip--
+		}
+		if _, err := w.w.Seek(skip, io.SeekCurrent); err != nil {
+			return err
+		}
+		w.skipped += skip
+		w.pending -= skip
+	}
+	for w.pending > 0 {
+		n := int64(len(zeros))
+		if w.pending < n {
+			n = w.pending
+		}
+		m, err := w.w.Write(zeros[:n])
+		w.pending -= int64(m)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Write implements io.Writer. Zero bytes at the end of p are held until
+// followed by non-zero bytes, or until Close is called.
+func (w *sparseWriter) Write(p []byte) (n int, err error) {
+	for len(p) > 0 {
+		// Count leading zero bytes.
+		i := 0
+		for i < len(p) && p[i] == 0 {
+			i++
+		}
+		w.pending += int64(i)
+		n += i
+		p = p[i:]
+		if len(p) == 0 {
+			break
+		}
+		// Find the end of the data, which is the start of a run of zero
+		// bytes long enough to skip, or the end of p.
+		j := 0
+		for j < len(p) {
+			if p[j] != 0 {
+				j++
+				continue
+			}
+			k := j
+			for k < len(p) && k-j < w.min && p[k] == 0 {
+				k++
+			}
+			if k-j >= w.min || k == len(p) {
+				break
+			}
+			j = k
+		}
+		if err := w.flush(false); err != nil {
+			return n, err
+		}
+		m, err := w.w.Write(p[:j])
+		n += m
+		if err != nil {
+			return n, err
+		}
+		if m != j {
+			return n, io.ErrShortWrite
+		}
+		p = p[j:]
+	}
+	return n, nil
+}
+
+// Close completes the pending run. It does not close the destination.
+func (w *sparseWriter) Close() error {
+	return w.flush(true)
+}
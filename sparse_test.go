@@ -0,0 +1,135 @@
+package iofl_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"testing/iotest"
+
+	"github.com/anaminus/iofl"
+)
+
+// seekBuffer is an in-memory io.WriteSeeker that counts the bytes written to
+// it.
+type seekBuffer struct {
+	b       []byte
+	off     int64
+	written int64
+}
+
+func (s *seekBuffer) Write(p []byte) (int, error) {
+	if end := s.off + int64(len(p)); end > int64(len(s.b)) {
+		s.b = append(s.b, make([]byte, end-int64(len(s.b)))...)
+	}
+	copy(s.b[s.off:], p)
+	s.off += int64(len(p))
+	s.written += int64(len(p))
+	return len(p), nil
+}
+
+func (s *seekBuffer) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekStart:
+		s.off = offset
+	case io.SeekCurrent:
+		s.off += offset
+	case io.SeekEnd:
+		s.off = int64(len(s.b)) + offset
+	}
+	return s.off, nil
+}
+
+// sparseContent returns content with runs of zero bytes of the given lengths,
+// separated by non-zero bytes.
+func sparseContent(runs ...int) []byte {
+	var b []byte
+	for i, n := range runs {
+		if i > 0 {
+			b = append(b, "data"...)
+		}
+		b = append(b, make([]byte, n)...)
+	}
+	return b
+}
+
+func TestSparse(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "identity"}}})
+	tests := []struct {
+		in      []byte
+		skipped int64
+	}{
+		{sparseContent(0, 100, 0), 100},
+		{sparseContent(0, 15, 16, 0), 16},
+		// The final byte of a trailing run is written, so that the
+		// destination has the full length.
+		{sparseContent(0, 50), 49},
+		{sparseContent(64), 63},
+		{sparseContent(32, 0), 32},
+		{sparseContent(0, 0), 0},
+		{nil, 0},
+	}
+	for _, tt := range tests {
+		// Reading one byte at a time splits runs across writes.
+		for _, src := range []io.Reader{bytes.NewReader(tt.in), iotest.OneByteReader(bytes.NewReader(tt.in))} {
+			var dst seekBuffer
+			report, err := s.Run("c", &dst, ioutil.NopCloser(src), iofl.Sparse(16))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !bytes.Equal(dst.b, tt.in) {
+				t.Errorf("%q: got %q", tt.in, dst.b)
+			}
+			if report.Written != int64(len(tt.in)) || report.Skipped != tt.skipped {
+				t.Errorf("%q: got written %d, skipped %d, want %d, %d", tt.in, report.Written, report.Skipped, len(tt.in), tt.skipped)
+			}
+			if dst.written != int64(len(tt.in))-tt.skipped {
+				t.Errorf("%q: wrote %d bytes", tt.in, dst.written)
+			}
+		}
+	}
+}
+
+func TestSparseNoEffect(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "identity"}}})
+	in := sparseContent(0, 100, 0)
+	for _, opt := range []iofl.Option{iofl.Sparse(0), iofl.Sparse(-1)} {
+		var dst seekBuffer
+		report, err := s.Run("c", &dst, ioutil.NopCloser(bytes.NewReader(in)), opt)
+		if err != nil || report.Skipped != 0 || dst.written != int64(len(in)) {
+			t.Errorf("got skipped %d, wrote %d, %v", report.Skipped, dst.written, err)
+		}
+	}
+	// A destination that is not seekable is written in full.
+	var buf bytes.Buffer
+	report, err := s.Run("c", &buf, ioutil.NopCloser(bytes.NewReader(in)), iofl.Sparse(16))
+	if err != nil || report.Skipped != 0 || !bytes.Equal(buf.Bytes(), in) {
+		t.Errorf("got skipped %d, %v", report.Skipped, err)
+	}
+}
+
+func TestSparseFile(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "identity"}}})
+	in := sparseContent(0, 1<<20, 1<<16)
+	file, err := os.Create(filepath.Join(t.TempDir(), "sparse"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	report, err := s.Run("c", file, ioutil.NopCloser(bytes.NewReader(in)), iofl.Sparse(4096))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.Skipped != 1<<20+1<<16-1 {
+		t.Errorf("got skipped %d", report.Skipped)
+	}
+	out, err := ioutil.ReadFile(file.Name())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(out, in) {
+		t.Errorf("got %d bytes, want %d", len(out), len(in))
+	}
+}
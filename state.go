@@ -0,0 +1,17 @@
+package iofl
+
+// StateSaver is implemented by a Filter that can export and import its internal
+// state, such as a partially computed hash, or data buffered by a codec. This
+// allows a long-running chain to be checkpointed, and resumed after a restart.
+//
+// A saved state describes the filter only; the state of its source is saved
+// separately. A state is restored to a newly constructed Filter, configured
+// identically to the Filter from which the state was saved, before any data is
+// read from it.
+type StateSaver interface {
+	// SaveState returns an encoding of the filter's internal state.
+	SaveState() (state []byte, err error)
+	// RestoreState restores the filter's internal state from a value
+	// previously returned by SaveState.
+	RestoreState(state []byte) error
+}
@@ -0,0 +1,135 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+	"sync/atomic"
+	"time"
+)
+
+// NotInstrumented is returned by Stats when a Filter was not resolved with the
+// Instrument option.
+var NotInstrumented = errors.New("filter not instrumented")
+
+// LinkStats describes the reads of one link of a chain resolved with the
+// Instrument option.
+type LinkStats struct {
+	// Link identifies the link.
+	Link Link
+	// Bytes is the number of bytes produced by the link.
+	Bytes int64
+	// Reads is the number of calls to Read or ReadFrame of the link.
+	Reads int64
+	// Duration is the time spent reading from the link, not including the
+	// time spent reading from its source.
+	Duration time.Duration
+	// Total is the time spent reading from the link, including the time spent
+	// reading from its source.
+	Total time.Duration
+}
+
+// Instrument returns an Option that counts the bytes produced, the calls to
+// Read, and the time spent reading, for each link of a chain. The counts are
+// retrieved with Stats.
+func Instrument() Option {
+	return Decorate(instrument(nil))
+}
+
+// instrument returns a Decorator that wraps each link with a statsFilter. If
+// links is not nil, each statsFilter is appended to it.
+func instrument(links *[]*statsFilter) Decorator {
+	return func(link Link, f Filter) Filter {
+		s := &statsFilter{f: f, link: link}
+		if links != nil {
+			*links = append(*links, s)
+		}
+		if fr, ok := f.(Framer); ok {
+			return statsFramer{statsFilter: s, fr: fr}
+		}
+		return s
+	}
+}
+
+// Stats returns the statistics of each link of filter, which must have been
+// resolved with the Instrument option, in order of the chain. Stats may be
+// called while the filter is being read, and after it has been closed. Returns
+// NotInstrumented if the filter has no instrumented links.
+func Stats(filter Filter) ([]LinkStats, error) {
+	var links []*statsFilter
+	Apply(filter, func(r io.ReadCloser) error {
+		switch s := r.(type) {
+		case *statsFilter:
+			links = append(links, s)
+		case statsFramer:
+			links = append(links, s.statsFilter)
+		}
+		return nil
+	})
+	if len(links) == 0 {
+		return nil, NotInstrumented
+	}
+	// Links were found from last to first.
+	for i, j := 0, len(links)-1; i < j; i, j = i+1, j-1 {
+		links[i], links[j] = links[j], links[i]
+	}
+	return linkStats(links), nil
+}
+
+// linkStats returns the statistics of links, which are in order of the chain.
+func linkStats(links []*statsFilter) []LinkStats {
+	stats := make([]LinkStats, len(links))
+	var prev time.Duration
+	for i, s := range links {
+		total := time.Duration(atomic.LoadInt64(&s.d))
+		stats[i] = LinkStats{
+			Link:     s.link,
+			Bytes:    atomic.LoadInt64(&s.n),
+			Reads:    atomic.LoadInt64(&s.reads),
+			Duration: total - prev,
+			Total:    total,
+		}
+		prev = total
+	}
+	return stats
+}
+
+// statsFilter counts the bytes, calls, and time spent reading a link. Counts
+// are updated atomically so that they may be retrieved concurrently.
+type statsFilter struct {
+	f     Filter
+	link  Link
+	n     int64
+	reads int64
+	d     int64
+}
+
+func (s *statsFilter) Read(p []byte) (n int, err error) {
+	start := time.Now()
+	n, err = s.f.Read(p)
+	s.record(start, n)
+	return n, err
+}
+
+// record records a read of n bytes that began at start.
+func (s *statsFilter) record(start time.Time, n int) {
+	atomic.AddInt64(&s.d, int64(time.Since(start)))
+	atomic.AddInt64(&s.n, int64(n))
+	atomic.AddInt64(&s.reads, 1)
+}
+
+func (s *statsFilter) Close() error          { return s.f.Close() }
+func (s *statsFilter) Source() io.ReadCloser { return s.f }
+
+// statsFramer is a statsFilter over a Framer, preserving the ability of the
+// next link to read frames.
+type statsFramer struct {
+	*statsFilter
+	fr Framer
+}
+
+func (s statsFramer) ReadFrame() ([]byte, error) {
+	start := time.Now()
+	b, err := s.fr.ReadFrame()
+	s.record(start, len(b))
+	return b, err
+}
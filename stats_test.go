@@ -0,0 +1,127 @@
+package iofl_test
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestStats(t *testing.T) {
+	const delay = 2 * time.Millisecond
+	s := newChainSet(t, map[string]iofl.Chain{
+		"c": {
+			{Filter: "slow"},
+			{Filter: "translate", Params: iofl.Params{"delete": "-"}},
+		},
+	}, hookFilter("slow", func() { time.Sleep(delay) }))
+	src := ioutil.NopCloser(iotest.OneByteReader(strings.NewReader("a-b-c")))
+	f, err := s.Resolve("c", src, iofl.Instrument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "abc" {
+		t.Fatalf("got %q", got)
+	}
+	// Stats are available after the filter is closed.
+	stats, err := iofl.Stats(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stats) != 2 {
+		t.Fatalf("got %d links", len(stats))
+	}
+	for i, want := range []int64{5, 3} {
+		if l := stats[i].Link; l.Chain != "c" || l.Index != i {
+			t.Errorf("link %d: got %+v", i, l)
+		}
+		if stats[i].Bytes != want {
+			t.Errorf("link %d: got %d bytes, want %d", i, stats[i].Bytes, want)
+		}
+		if stats[i].Reads == 0 {
+			t.Errorf("link %d: no reads", i)
+		}
+	}
+	// The time spent by the slow link is attributed to it, and not to the
+	// link reading from it.
+	slow, next := stats[0], stats[1]
+	if slow.Duration < time.Duration(slow.Reads)*delay || slow.Duration != slow.Total {
+		t.Errorf("slow link: got duration %v, total %v over %d reads", slow.Duration, slow.Total, slow.Reads)
+	}
+	if next.Total < slow.Total || next.Duration != next.Total-slow.Total {
+		t.Errorf("next link: got duration %v, total %v", next.Duration, next.Total)
+	}
+}
+
+func TestStatsFramer(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "gzip"}}})
+	in := append(gzipBytes(t, []byte("one")), gzipBytes(t, []byte("three"))...)
+	f, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(in)), iofl.Instrument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fr, ok := f.(iofl.Framer)
+	if !ok {
+		t.Fatal("instrumented link is not a Framer")
+	}
+	for {
+		if _, err := fr.ReadFrame(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+	}
+	stats, err := iofl.Stats(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats[0].Bytes != 8 || stats[0].Reads != 3 {
+		t.Errorf("got %d bytes over %d reads", stats[0].Bytes, stats[0].Reads)
+	}
+}
+
+func TestStatsConcurrent(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}, {Filter: "translate"}}})
+	f, err := s.Resolve("c", source(strings.Repeat("x", 1<<16)), iofl.Instrument())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if _, err := iofl.Stats(f); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	buf := make([]byte, 16)
+	for {
+		if _, err := f.Read(buf); err != nil {
+			break
+		}
+	}
+	wg.Wait()
+	f.Close()
+}
+
+func TestStatsNotInstrumented(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}}})
+	f, err := s.Resolve("c", source("abc"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := iofl.Stats(f); err != iofl.NotInstrumented {
+		t.Errorf("got %v, want NotInstrumented", err)
+	}
+}
@@ -0,0 +1,136 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+	"sort"
+)
+
+// Tenant is a view of a ChainSet scoped to a tenant. A Tenant has its own
+// chains, which take precedence over the chains of the ChainSet, allowing a
+// tenant to customize a pipeline without affecting other tenants. Tenant
+// chains use the filters registered with the ChainSet, and are not part of its
+// Config.
+//
+// References to other chains within a chain are also located with the Tenant.
+// Filters that resolve other chains by name, such as routing filters, resolve
+// them from the ChainSet, not the Tenant.
+type Tenant struct {
+	set *ChainSet
+	id  string
+}
+
+// Tenant returns a view of the ChainSet scoped to the tenant of the given ID.
+func (s *ChainSet) Tenant(id string) *Tenant {
+	return &Tenant{set: s, id: id}
+}
+
+// ID returns the ID of the tenant.
+func (t *Tenant) ID() string {
+	return t.id
+}
+
+// SetChain sets a chain of the given name specific to the tenant, replacing any
+// existing tenant chain of the same name. The chain is validated as by
+// ChainSet.Validate, where references to other chains are located with Chain.
+// If the chain is not valid, an error of type Errors is returned, and the
+// chain is not set.
+func (t *Tenant) SetChain(name string, chain Chain) error {
+	v := t.set.Snapshot()
+	lookup := func(ref string) (Chain, bool) {
+		if ref == name {
+			return chain, true
+		}
+		return v.tenantChain(t.id, ref)
+	}
+	errs := t.set.validateChain(name, chain, lookup)
+	if _, err := expand(name, chain, lookup); errors.Is(err, ReferenceCycle) {
+		errs = append(errs, err)
+	}
+	if err := errs.errorOrNil(); err != nil {
+		return err
+	}
+	t.set.mu.Lock()
+	defer t.set.mu.Unlock()
+	t.set.setTenantChain(t.id, name, chain, false)
+	return nil
+}
+
+// RemoveChain removes the chain of the given name specific to the tenant. The
+// chain of the ChainSet of the same name, if any, becomes visible to the tenant.
+func (t *Tenant) RemoveChain(name string) {
+	t.set.mu.Lock()
+	defer t.set.mu.Unlock()
+	if _, ok := t.set.tenants[t.id][name]; ok {
+		t.set.setTenantChain(t.id, name, nil, true)
+	}
+}
+
+// setTenantChain sets or, if remove is true, removes the chain of the given name
+// specific to the tenant of the given ID. As with setConfig, each map is
+// replaced rather than modified, so that it may be shared by a Snapshot. s.mu
+// must be held.
+func (s *ChainSet) setTenantChain(id, name string, chain Chain, remove bool) {
+	chains := make(map[string]Chain, len(s.tenants[id])+1)
+	for k, c := range s.tenants[id] {
+		chains[k] = c
+	}
+	if remove {
+		delete(chains, name)
+	} else {
+		chains[name] = chain
+	}
+	tenants := make(map[string]map[string]Chain, len(s.tenants)+1)
+	for k, c := range s.tenants {
+		tenants[k] = c
+	}
+	if len(chains) > 0 {
+		tenants[id] = chains
+	} else {
+		delete(tenants, id)
+	}
+	s.tenants = tenants
+	s.invalidate()
+}
+
+// Chains returns the names of the chains specific to the tenant, in order.
+func (t *Tenant) Chains() []string {
+	t.set.mu.RLock()
+	defer t.set.mu.RUnlock()
+	chains := t.set.tenants[t.id]
+	names := make([]string, 0, len(chains))
+	for name := range chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Chain returns the chain of the given name, looking first at the chains
+// specific to the tenant, then at the chains of the ChainSet.
+func (t *Tenant) Chain(name string) (chain Chain, ok bool) {
+	return t.set.Snapshot().tenantChain(t.id, name)
+}
+
+// Resolve behaves the same as ChainSet.Resolve, but locates the chain with
+// Chain. The chain, and the chains it refers to, are located within a single
+// Snapshot, so that a resolution is unaffected by concurrent changes to the
+// chains of the tenant or the ChainSet.
+func (t *Tenant) Resolve(chain string, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	return t.resolve(t.set.Snapshot(), chain, src, opts)
+}
+
+// resolve resolves the chain of the given name within v.
+func (t *Tenant) resolve(v *Snapshot, chain string, src io.ReadCloser, opts []Option) (filter Filter, err error) {
+	lookup := func(name string) (Chain, bool) {
+		return v.tenantChain(t.id, name)
+	}
+	filterChain, ok := lookup(chain)
+	if !ok {
+		if def, fallback := v.fallback(opts); fallback && def != chain {
+			return t.resolve(v, def, src, opts)
+		}
+		return nil, &ResolveError{Chain: chain, Index: -1, Err: UnknownChain}
+	}
+	return v.resolve(chain, filterChain, lookup, src, opts)
+}
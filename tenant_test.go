@@ -0,0 +1,143 @@
+package iofl_test
+
+import (
+	"errors"
+	"reflect"
+	"sync"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// tenantSet returns a ChainSet where "outer" refers to "inner", which
+// upper-cases its input.
+func tenantSet(t *testing.T) *iofl.ChainSet {
+	t.Helper()
+	return newChainSet(t, map[string]iofl.Chain{
+		"inner": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		"outer": {{Chain: "inner"}},
+		"plain": {{Filter: "translate"}},
+	})
+}
+
+func tenantRead(t *testing.T, tenant *iofl.Tenant, chain, in string) string {
+	t.Helper()
+	f, err := tenant.Resolve(chain, source(in))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return readAll(t, f)
+}
+
+func TestTenant(t *testing.T) {
+	s := tenantSet(t)
+	a := s.Tenant("a")
+	b := s.Tenant("b")
+	if a.ID() != "a" {
+		t.Errorf("got ID %q", a.ID())
+	}
+	if err := a.SetChain("inner", iofl.Chain{{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.SetChain("extra", iofl.Chain{{Chain: "plain"}}); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := a.Chains(), []string{"extra", "inner"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("got chains %q, want %q", got, want)
+	}
+
+	// References within global chains are located with the tenant.
+	for _, tt := range []struct {
+		tenant      *iofl.Tenant
+		chain, want string
+	}{
+		{a, "inner", "nop"},
+		{a, "outer", "nop"},
+		{a, "plain", "abc"},
+		{a, "extra", "abc"},
+		{b, "inner", "ABC"},
+		{b, "outer", "ABC"},
+	} {
+		if got := tenantRead(t, tt.tenant, tt.chain, "abc"); got != tt.want {
+			t.Errorf("%s %s: got %q, want %q", tt.tenant.ID(), tt.chain, got, tt.want)
+		}
+	}
+	if _, err := b.Resolve("extra", source("")); !errors.Is(err, iofl.UnknownChain) {
+		t.Errorf("got %v, want UnknownChain", err)
+	}
+	if _, ok := s.Config().Chains["extra"]; ok {
+		t.Error("tenant chain visible in ChainSet")
+	}
+
+	a.RemoveChain("inner")
+	if got := tenantRead(t, a, "outer", "abc"); got != "ABC" {
+		t.Errorf("after remove: got %q", got)
+	}
+	a.RemoveChain("extra")
+	if got := a.Chains(); len(got) != 0 {
+		t.Errorf("got chains %q", got)
+	}
+}
+
+func TestTenantSetChainErrors(t *testing.T) {
+	s := tenantSet(t)
+	a := s.Tenant("a")
+	for name, chain := range map[string]iofl.Chain{
+		"unknown filter": {{Filter: "missing"}},
+		"self":           {{Chain: "self"}},
+		"cycle":          {{Chain: "outer"}},
+	} {
+		target := name
+		if name == "cycle" {
+			// inner -> outer -> inner.
+			target = "inner"
+		}
+		err := a.SetChain(target, chain)
+		var errs iofl.Errors
+		if !errors.As(err, &errs) {
+			t.Errorf("%s: got %v, want Errors", name, err)
+		}
+	}
+	if got := a.Chains(); len(got) != 0 {
+		t.Errorf("invalid chains were set: %q", got)
+	}
+}
+
+func TestTenantConcurrent(t *testing.T) {
+	s := tenantSet(t)
+	a := s.Tenant("a")
+	rot13 := iofl.Chain{{Filter: "translate", Params: iofl.Params{"preset": "rot13"}}}
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				f, err := a.Resolve("outer", source("abc"))
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				b := make([]byte, 3)
+				n, _ := f.Read(b)
+				f.Close()
+				if got := string(b[:n]); got != "ABC" && got != "nop" {
+					t.Errorf("got %q", got)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 50; j++ {
+				if j%2 == 0 {
+					a.SetChain("inner", rot13)
+				} else {
+					a.RemoveChain("inner")
+				}
+				a.Chains()
+			}
+		}()
+	}
+	wg.Wait()
+}
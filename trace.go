@@ -0,0 +1,80 @@
+package iofl
+
+import (
+	"context"
+	"io"
+	"runtime/trace"
+	"strconv"
+)
+
+// Trace returns an Option that places each Read, ReadFrame, and Close call of
+// each link within a runtime/trace region, so that the execution trace of a
+// program, as viewed with "go tool trace", attributes the time spent by a
+// chain to its links. A region is named after the chain, the index of the
+// link, and the name of the filter, such as "input[1]gzip", and the region of
+// a Close call is further suffixed with " close".
+//
+// Since a link reads from its source, the region of a link encloses the
+// regions of the links before it, and the time spent by the link itself is
+// the time of its region less that of the nested region. Regions are
+// associated with the task of ctx, if any, such as one created with
+// trace.NewTask to group the regions of a single run of a chain. Regions are
+// only recorded while tracing is enabled, and are otherwise inexpensive.
+func Trace(ctx context.Context) Option {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	return Decorate(func(link Link, f Filter) Filter {
+		t := &traced{
+			ctx:  ctx,
+			f:    f,
+			name: link.Chain + "[" + strconv.Itoa(link.Index) + "]" + link.Def.Filter,
+		}
+		if fr, ok := f.(Framer); ok {
+			return tracedFramer{traced: t, fr: fr}
+		}
+		return t
+	})
+}
+
+// traced places calls to a Filter within trace regions.
+type traced struct {
+	ctx  context.Context
+	f    Filter
+	name string
+}
+
+func (t *traced) Read(p []byte) (n int, err error) {
+	if !trace.IsEnabled() {
+		return t.f.Read(p)
+	}
+	defer trace.StartRegion(t.ctx, t.name).End()
+	return t.f.Read(p)
+}
+
+func (t *traced) Close() error {
+	if !trace.IsEnabled() {
+		return t.f.Close()
+	}
+	defer trace.StartRegion(t.ctx, t.name+" close").End()
+	return t.f.Close()
+}
+
+func (t *traced) Source() io.ReadCloser {
+	return t.f
+}
+
+// tracedFramer is a traced Filter over a Framer, preserving the ability of the
+// next link to read frames.
+type tracedFramer struct {
+	*traced
+	fr Framer
+}
+
+func (t tracedFramer) ReadFrame() ([]byte, error) {
+	if !trace.IsEnabled() {
+		return t.fr.ReadFrame()
+	}
+	defer trace.StartRegion(t.ctx, t.name).End()
+	return t.fr.ReadFrame()
+}
@@ -0,0 +1,78 @@
+package iofl_test
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"runtime/trace"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestTrace(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "translate"}, {Filter: "hex"}}})
+
+	// Without tracing enabled, links are passed through.
+	f, err := s.Resolve("c", source("abc"), iofl.Trace(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out := readAll(t, f); out != "616263" {
+		t.Errorf("got %q, want %q", out, "616263")
+	}
+
+	var buf bytes.Buffer
+	if err := trace.Start(&buf); err != nil {
+		t.Fatal(err)
+	}
+	ctx, task := trace.NewTask(context.Background(), "test")
+	f, err = s.Resolve("c", source("abc"), iofl.Trace(ctx))
+	if err == nil {
+		var out []byte
+		if out, err = ioutil.ReadAll(f); err == nil && string(out) != "616263" {
+			t.Errorf("got %q, want %q", out, "616263")
+		}
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+	}
+	task.End()
+	trace.Stop()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, region := range []string{"c[0]translate", "c[1]hex", "c[1]hex close", "c[0]translate close"} {
+		if !bytes.Contains(buf.Bytes(), []byte(region)) {
+			t.Errorf("trace does not contain region %q", region)
+		}
+	}
+}
+
+func TestTraceFramer(t *testing.T) {
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "gzip"}}})
+	in := append(gzipBytes(t, []byte("one")), gzipBytes(t, []byte("two"))...)
+	f, err := s.Resolve("c", ioutil.NopCloser(bytes.NewReader(in)), iofl.Trace(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	fr, ok := f.(iofl.Framer)
+	if !ok {
+		t.Fatal("traced link is not a Framer")
+	}
+	var frames []string
+	for {
+		b, err := fr.ReadFrame()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		frames = append(frames, string(b))
+	}
+	if len(frames) != 2 || frames[0] != "one" || frames[1] != "two" {
+		t.Errorf("got frames %q", frames)
+	}
+}
@@ -0,0 +1,122 @@
+package iofl
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Validate checks config against the filters registered with the ChainSet,
+// without applying it. Each link must refer to a registered filter, and have
+// well-formed meta-parameters, or refer to a defined chain without forming a
+// cycle. The parameters of a link are also checked by the
+// Validate function of its filter, if any. config is first upgraded to the
+// current version, as by SetConfig.
+//
+// Every problem is reported; the returned error is of type Errors, where each
+// element is a *ResolveError identifying the offending link.
+func (s *ChainSet) Validate(config Config) error {
+	if err := s.migrate(&config); err != nil {
+		return err
+	}
+	return s.validate(config)
+}
+
+// validate checks config, which is of the current version.
+func (s *ChainSet) validate(config Config) error {
+	names := make([]string, 0, len(config.Chains))
+	for name := range config.Chains {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lookup := func(name string) (Chain, bool) {
+		chain, ok := config.Chains[name]
+		return chain, ok
+	}
+	var errs Errors
+	for _, name := range names {
+		errs = append(errs, s.validateChain(name, config.Chains[name], lookup)...)
+	}
+	// Report each link that closes a cycle once.
+	type linkKey struct {
+		chain string
+		index int
+	}
+	cycles := map[linkKey]bool{}
+	for _, name := range names {
+		_, err := expand(name, config.Chains[name], lookup)
+		var rerr *ResolveError
+		if errors.Is(err, ReferenceCycle) && errors.As(err, &rerr) {
+			key := linkKey{rerr.Chain, rerr.Index}
+			if !cycles[key] {
+				cycles[key] = true
+				errs = append(errs, err)
+			}
+		}
+	}
+	vars := make([]string, 0, len(config.Vars))
+	for name := range config.Vars {
+		vars = append(vars, name)
+	}
+	sort.Strings(vars)
+	for _, name := range vars {
+		for _, err := range validateVars(config.Vars[name]) {
+			errs = append(errs, &ResolveError{Chain: name, Index: -1, Err: err})
+		}
+	}
+	if config.DefaultChain != "" {
+		if _, ok := config.Chains[config.DefaultChain]; !ok {
+			errs = append(errs, fmt.Errorf("default chain: %w %q", UnknownChain, config.DefaultChain))
+		}
+	}
+	jobs := make([]string, 0, len(config.Jobs))
+	for name := range config.Jobs {
+		jobs = append(jobs, name)
+	}
+	sort.Strings(jobs)
+	for _, name := range jobs {
+		if err := validateJob(config, config.Jobs[name]); err != nil {
+			errs = append(errs, fmt.Errorf("job %q: %w", name, err))
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// validateChain returns the problems with the links of the chain of the given
+// name, other than reference cycles. A chain reference must name a chain found
+// by lookup.
+func (s *ChainSet) validateChain(name string, chain Chain, lookup func(string) (Chain, bool)) Errors {
+	var errs Errors
+	for i, link := range chain {
+		fail := func(err error) {
+			errs = append(errs, &ResolveError{Chain: name, Index: i, Filter: link.Filter, Err: err})
+		}
+		if link.Chain != "" {
+			if link.Filter != "" || len(link.Params) > 0 {
+				fail(errors.New("chain reference cannot specify a filter or params"))
+			}
+			if _, ok := lookup(link.Chain); !ok {
+				fail(fmt.Errorf("%w %q", UnknownChain, link.Chain))
+			}
+			continue
+		}
+		def, ok := s.filter(link.Filter)
+		if !ok {
+			fail(UnknownFilter)
+			continue
+		}
+		params, meta := splitMeta(link.Params)
+		if err := validateMeta(meta); err != nil {
+			fail(err)
+		}
+		for _, err := range checkParams(def, params, true) {
+			fail(err)
+		}
+		if def.Validate != nil {
+			if err := def.Validate(params); err != nil {
+				fail(err)
+			}
+		}
+	}
+	return errs
+}
@@ -0,0 +1,96 @@
+package iofl_test
+
+import (
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+func TestValidate(t *testing.T) {
+	checked := iofl.FilterDef{
+		Name: "checked",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			return iofl.AsFilter(r), nil
+		},
+		Validate: func(params iofl.Params) error {
+			if params.GetBool("bad") {
+				return errBoom
+			}
+			return nil
+		},
+	}
+	s := newChainSet(t, map[string]iofl.Chain{"old": {{Filter: "translate"}}}, checked)
+	config := iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"a":    {{Filter: "translate"}, {Filter: "missing"}, {Filter: "checked", Params: iofl.Params{"bad": true}}},
+			"b":    {{Chain: "gone"}, {Chain: "a", Filter: "translate"}},
+			"c":    {{Filter: "translate", Params: iofl.Params{"#unknown": 1.0}}},
+			"x":    {{Chain: "y"}},
+			"y":    {{Chain: "x"}},
+			"good": {{Filter: "checked"}, {Chain: "a"}},
+		},
+		Vars:         map[string][]iofl.VarDef{"a": {{Name: ""}}},
+		DefaultChain: "none",
+	}
+	want := []struct {
+		chain string
+		index int
+		err   error
+	}{
+		{"a", 1, iofl.UnknownFilter},
+		{"a", 2, errBoom},
+		{"b", 0, iofl.UnknownChain},
+		{"b", 1, nil},
+		{"c", 0, nil},
+		{"y", 0, iofl.ReferenceCycle},
+		{"x", 0, iofl.ReferenceCycle},
+		{"a", -1, nil},
+		{"", -1, iofl.UnknownChain},
+	}
+
+	for _, apply := range []bool{false, true} {
+		var err error
+		if apply {
+			err = s.SetConfig(config)
+		} else {
+			err = s.Validate(config)
+		}
+		var errs iofl.Errors
+		if !errors.As(err, &errs) {
+			t.Fatalf("got %v, want Errors", err)
+		}
+		if len(errs) != len(want) {
+			t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), err)
+		}
+		for i, w := range want {
+			err := errs[i]
+			if w.err != nil && !errors.Is(err, w.err) {
+				t.Errorf("error %d: got %v, want %v", i, err, w.err)
+			}
+			var rerr *iofl.ResolveError
+			if !errors.As(err, &rerr) {
+				if w.chain != "" {
+					t.Errorf("error %d: got %v, want *ResolveError", i, err)
+				}
+				continue
+			}
+			if rerr.Chain != w.chain || rerr.Index != w.index {
+				t.Errorf("error %d: got link %s[%d], want %s[%d]", i, rerr.Chain, rerr.Index, w.chain, w.index)
+			}
+		}
+		// An invalid configuration is not applied.
+		if _, ok := s.Config().Chains["old"]; !ok {
+			t.Error("invalid configuration was applied")
+		}
+	}
+
+	valid := iofl.Config{Chains: map[string]iofl.Chain{"new": {{Filter: "checked"}}}}
+	if err := s.Validate(valid); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := s.Config().Chains["new"]; ok {
+		t.Error("Validate applied the configuration")
+	}
+}
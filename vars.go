@@ -0,0 +1,269 @@
+package iofl
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Expander is implemented by Filters that use variables beyond those expanded
+// within their parameters. Expand is called with the variables passed to
+// ResolveVars, after the Filter is constructed.
+type Expander interface {
+	Expand(vars map[string]string) error
+}
+
+// ResolveVars behaves the same as Resolve, but expands variables within the
+// parameters of each link before the link is constructed, allowing parameters
+// such as file paths to be templated.
+//
+// Within each string parameter, including strings within lists and maps, a
+// reference of the form "${name}" is replaced by the value of name in vars,
+// and "$$" is replaced by "$". Referring to a variable not in vars, or an
+// unterminated reference, is an error. Any Filter that implements Expander is
+// then called with vars. If the chain declares variables, vars are first
+// checked against the declarations, as described by VarDef.
+func (s *ChainSet) ResolveVars(chain string, vars map[string]string, src io.ReadCloser, opts ...Option) (filter Filter, err error) {
+	if vars == nil {
+		vars = map[string]string{}
+	}
+	opts = append(opts[:len(opts):len(opts)], func(o *resolveOptions) {
+		o.vars = vars
+	})
+	return s.Resolve(chain, src, opts...)
+}
+
+// expandParams returns a copy of params with variables expanded.
+func expandParams(params Params, vars map[string]string) (Params, error) {
+	if params == nil {
+		return nil, nil
+	}
+	p := make(Params, len(params))
+	for k, v := range params {
+		v, err := expandValue(v, vars)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", k, err)
+		}
+		p[k] = v
+	}
+	return p, nil
+}
+
+// expandValue expands the variables within v.
+func expandValue(v interface{}, vars map[string]string) (interface{}, error) {
+	switch v := v.(type) {
+	case string:
+		return expandString(v, vars)
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, e := range v {
+			e, err := expandValue(e, vars)
+			if err != nil {
+				return nil, fmt.Errorf("[%d]: %w", i, err)
+			}
+			list[i] = e
+		}
+		return list, nil
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, e := range v {
+			e, err := expandValue(e, vars)
+			if err != nil {
+				return nil, fmt.Errorf("%s: %w", k, err)
+			}
+			m[k] = e
+		}
+		return m, nil
+	}
+	return v, nil
+}
+
+// expandString expands the variables within s.
+func expandString(s string, vars map[string]string) (string, error) {
+	if !strings.Contains(s, "$") {
+		return s, nil
+	}
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(s, '$')
+		if i < 0 {
+			b.WriteString(s)
+			return b.String(), nil
+		}
+		b.WriteString(s[:i])
+		s = s[i+1:]
+		switch {
+		case strings.HasPrefix(s, "$"):
+			b.WriteByte('$')
+			s = s[1:]
+		case strings.HasPrefix(s, "{"):
+			j := strings.IndexByte(s, '}')
+			if j < 0 {
+				return "", fmt.Errorf("unterminated variable reference")
+			}
+			name := s[1:j]
+			v, ok := vars[name]
+			if !ok {
+				return "", fmt.Errorf("undefined variable %q", name)
+			}
+			b.WriteString(v)
+			s = s[j+1:]
+		default:
+			b.WriteByte('$')
+		}
+	}
+}
+
+// MissingVariable is returned when resolving a chain that declares a required
+// variable that is not given.
+var MissingVariable = errors.New("missing variable")
+
+// VarType is the type of the value of a variable, as declared by a VarDef.
+type VarType string
+
+const (
+	// VarString is any string. An empty VarType is the same as VarString.
+	VarString VarType = "string"
+	// VarInt is a decimal integer.
+	VarInt VarType = "int"
+	// VarBool is a boolean, as parsed by strconv.ParseBool.
+	VarBool VarType = "bool"
+	// VarDuration is a duration, as parsed by time.ParseDuration.
+	VarDuration VarType = "duration"
+)
+
+// check returns an error if value is not of type t.
+func (t VarType) check(value string) (err error) {
+	switch t {
+	case "", VarString:
+	case VarInt:
+		_, err = strconv.ParseInt(value, 10, 64)
+	case VarBool:
+		_, err = strconv.ParseBool(value)
+	case VarDuration:
+		_, err = time.ParseDuration(value)
+	default:
+		return fmt.Errorf("unknown type %q", string(t))
+	}
+	if err != nil {
+		return fmt.Errorf("expected %s", t)
+	}
+	return nil
+}
+
+// VarDef declares a variable used by a chain. Variables are declared by the
+// Vars field of Config.
+//
+// When a chain that declares variables is resolved, the variables passed to
+// ResolveVars are checked against the declarations of the chain and of the
+// chains it refers to. A required variable that is not given causes an error
+// wrapping MissingVariable, and a given value that is not of the declared type
+// causes an error. A variable that is not given receives its default value, if
+// any. Variables are then expanded as by ResolveVars, even if the chain was
+// resolved with Resolve, so that a reference to a variable is never left
+// unexpanded.
+type VarDef struct {
+	// Name is the name of the variable.
+	Name string `json:"name"`
+	// Type is the type of the value of the variable.
+	Type VarType `json:"type,omitempty"`
+	// Required indicates that the variable must be given.
+	Required bool `json:"required,omitempty"`
+	// Default is the value of the variable if it is not given. Must be empty
+	// if Required is true.
+	Default string `json:"default,omitempty"`
+	// Description is a short, human-readable description of the variable.
+	Description string `json:"description,omitempty"`
+}
+
+// validateVars returns an error for each problem with defs.
+func validateVars(defs []VarDef) Errors {
+	var errs Errors
+	seen := make(map[string]bool, len(defs))
+	for _, def := range defs {
+		fail := func(err error) {
+			errs = append(errs, fmt.Errorf("variable %q: %w", def.Name, err))
+		}
+		switch {
+		case def.Name == "":
+			fail(errors.New("name required"))
+		case seen[def.Name]:
+			fail(errors.New("declared more than once"))
+		}
+		seen[def.Name] = true
+		switch def.Type {
+		case "", VarString, VarInt, VarBool, VarDuration:
+		default:
+			fail(fmt.Errorf("unknown type %q", string(def.Type)))
+			continue
+		}
+		if def.Required && def.Default != "" {
+			fail(errors.New("required variable cannot have a default"))
+		} else if def.Default != "" {
+			if err := def.Type.check(def.Default); err != nil {
+				fail(fmt.Errorf("default: %w", err))
+			}
+		}
+	}
+	return errs
+}
+
+// checkVars checks vars against the variables declared by chain and by each
+// chain containing links. Returns vars with default values added, or vars
+// unchanged if no variables are declared.
+func (v *Snapshot) checkVars(chain string, links []Link, vars map[string]string) (map[string]string, error) {
+	if len(v.vars) == 0 {
+		return vars, nil
+	}
+	var checked map[string]string
+	done := map[string]bool{}
+	check := func(name string) error {
+		if done[name] {
+			return nil
+		}
+		done[name] = true
+		defs := v.vars[name]
+		if len(defs) == 0 {
+			return nil
+		}
+		if checked == nil {
+			checked = make(map[string]string, len(vars)+len(defs))
+			for k, value := range vars {
+				checked[k] = value
+			}
+		}
+		for _, def := range defs {
+			value, ok := vars[def.Name]
+			switch {
+			case ok:
+				if err := def.Type.check(value); err != nil {
+					return &ResolveError{Chain: name, Index: -1, Err: fmt.Errorf("variable %q: %w", def.Name, err)}
+				}
+			case def.Required:
+				return &ResolveError{Chain: name, Index: -1, Err: fmt.Errorf("%w %q", MissingVariable, def.Name)}
+			case def.Default != "":
+				if _, ok := checked[def.Name]; !ok {
+					checked[def.Name] = def.Default
+				}
+			}
+		}
+		return nil
+	}
+	if chain != "" {
+		if err := check(chain); err != nil {
+			return nil, err
+		}
+	}
+	for _, link := range links {
+		if err := check(link.Chain); err != nil {
+			return nil, err
+		}
+	}
+	if checked == nil {
+		return vars, nil
+	}
+	return checked, nil
+}
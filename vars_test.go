@@ -0,0 +1,269 @@
+package iofl_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// paramFilter returns the definition of a filter that reads the formatted
+// value of its "v" parameter, ignoring its source.
+func paramFilter(name string) iofl.FilterDef {
+	return iofl.FilterDef{
+		Name: name,
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			out := strings.NewReader(fmt.Sprint(params["v"]))
+			return &funcFilter{src: r, read: out.Read}, nil
+		},
+	}
+}
+
+// expandFilter is a Filter that records the variables it is expanded with.
+type expandFilter struct {
+	funcFilter
+	vars map[string]string
+}
+
+func (f *expandFilter) Expand(vars map[string]string) error {
+	if vars["fail"] != "" {
+		return errBoom
+	}
+	f.vars = vars
+	return nil
+}
+
+func TestResolveVars(t *testing.T) {
+	s := newChainSet(t, nil, paramFilter("param"))
+	vars := map[string]string{"dir": "/tmp", "name": "a.txt", "empty": ""}
+	tests := []struct {
+		v    interface{}
+		want string
+	}{
+		{"${dir}/${name}", "/tmp/a.txt"},
+		{"plain", "plain"},
+		{"$$dir $dir ${empty}$", "$dir $dir $"},
+		{[]interface{}{"${name}", 1.0}, "[a.txt 1]"},
+		{map[string]interface{}{"k": "${dir}"}, "map[k:/tmp]"},
+		{true, "true"},
+	}
+	for _, tt := range tests {
+		chain := iofl.Chain{{Filter: "param", Params: iofl.Params{"v": tt.v}}}
+		if err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{"c": chain}}); err != nil {
+			t.Fatal(err)
+		}
+		f, err := s.ResolveVars("c", vars, source(""))
+		if err != nil {
+			t.Fatalf("%v: %v", tt.v, err)
+		}
+		if got := readAll(t, f); got != tt.want {
+			t.Errorf("%v: got %q, want %q", tt.v, got, tt.want)
+		}
+	}
+}
+
+func TestResolveVarsErrors(t *testing.T) {
+	s := newChainSet(t, nil, paramFilter("param"))
+	for _, v := range []interface{}{
+		"${missing}",
+		"${dir",
+		[]interface{}{"ok", "${missing}"},
+		map[string]interface{}{"k": "${missing}"},
+	} {
+		chain := iofl.Chain{{Filter: "param", Params: iofl.Params{"v": v}}}
+		if err := s.SetConfig(iofl.Config{Chains: map[string]iofl.Chain{"c": chain}}); err != nil {
+			t.Fatal(err)
+		}
+		_, err := s.ResolveVars("c", map[string]string{"dir": "/tmp"}, source(""))
+		var rerr *iofl.ResolveError
+		if !errors.As(err, &rerr) || rerr.Index != 0 {
+			t.Errorf("%v: got %v, want link error", v, err)
+		}
+		// Without variables, parameters are not expanded.
+		f, err := s.Resolve("c", source(""))
+		if err != nil {
+			t.Fatalf("%v: %v", v, err)
+		}
+		readAll(t, f)
+	}
+}
+
+func TestExpander(t *testing.T) {
+	var last *expandFilter
+	s := newChainSet(t, map[string]iofl.Chain{"c": {{Filter: "expand"}}}, iofl.FilterDef{
+		Name: "expand",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			last = &expandFilter{funcFilter: funcFilter{src: r, read: r.Read}}
+			return last, nil
+		},
+	})
+	f, err := s.ResolveVars("c", map[string]string{"a": "1"}, source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if last.vars["a"] != "1" {
+		t.Errorf("got vars %v", last.vars)
+	}
+	if f, err = s.ResolveVars("c", nil, source("")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if last.vars == nil {
+		t.Error("Expand not called with nil vars")
+	}
+	if f, err = s.Resolve("c", source("")); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if last.vars != nil {
+		t.Error("Expand called by Resolve")
+	}
+	_, err = s.ResolveVars("c", map[string]string{"fail": "1"}, source(""))
+	if !errors.Is(err, errBoom) {
+		t.Errorf("got %v, want Expand error", err)
+	}
+}
+
+func TestDeclaredVars(t *testing.T) {
+	s := newChainSet(t, nil, paramFilter("param"))
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"path":  {{Filter: "param", Params: iofl.Params{"v": "${tenant}/${n}/${fmt}"}}},
+			"outer": {{Chain: "path"}},
+			"plain": {{Filter: "param", Params: iofl.Params{"v": "${x}"}}},
+		},
+		Vars: map[string][]iofl.VarDef{
+			"path": {
+				{Name: "tenant", Required: true},
+				{Name: "n", Type: iofl.VarInt, Default: "1"},
+				{Name: "fmt", Default: "json"},
+				{Name: "on", Type: iofl.VarBool},
+				{Name: "wait", Type: iofl.VarDuration},
+			},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		chain string
+		vars  map[string]string
+		want  string
+	}{
+		{"path", map[string]string{"tenant": "a"}, "a/1/json"},
+		{"path", map[string]string{"tenant": "a", "n": "-5", "fmt": "", "on": "true", "wait": "1m"}, "a/-5/"},
+		// The declarations of referred chains are checked.
+		{"outer", map[string]string{"tenant": "b"}, "b/1/json"},
+		// A chain without declarations is unaffected.
+		{"plain", map[string]string{"x": "y"}, "y"},
+	}
+	for _, tt := range tests {
+		f, err := s.ResolveVars(tt.chain, tt.vars, source(""))
+		if err != nil {
+			t.Fatalf("%s %v: %v", tt.chain, tt.vars, err)
+		}
+		if got := readAll(t, f); got != tt.want {
+			t.Errorf("%s %v: got %q, want %q", tt.chain, tt.vars, got, tt.want)
+		}
+	}
+	// The given variables are not modified.
+	vars := map[string]string{"tenant": "a"}
+	f, err := s.ResolveVars("path", vars, source(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+	if len(vars) != 1 {
+		t.Errorf("got vars %v", vars)
+	}
+
+	for _, tt := range []struct {
+		chain string
+		vars  map[string]string
+		want  string
+	}{
+		{"path", nil, `missing variable "tenant"`},
+		{"outer", map[string]string{"n": "1"}, `missing variable "tenant"`},
+		{"path", map[string]string{"tenant": "a", "n": "one"}, `variable "n": expected int`},
+		{"path", map[string]string{"tenant": "a", "on": "maybe"}, `variable "on": expected bool`},
+		{"path", map[string]string{"tenant": "a", "wait": "10"}, `variable "wait": expected duration`},
+	} {
+		_, err := s.ResolveVars(tt.chain, tt.vars, source(""))
+		var rerr *iofl.ResolveError
+		if !errors.As(err, &rerr) || rerr.Chain != "path" || rerr.Index != -1 || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%s %v: got %v, want %s", tt.chain, tt.vars, err, tt.want)
+		}
+		if strings.Contains(tt.want, "missing") && !errors.Is(err, iofl.MissingVariable) {
+			t.Errorf("%s %v: got %v, want MissingVariable", tt.chain, tt.vars, err)
+		}
+	}
+
+	// Declared variables are checked and expanded by Resolve.
+	if _, err := s.Resolve("path", source("")); !errors.Is(err, iofl.MissingVariable) {
+		t.Errorf("got %v, want MissingVariable", err)
+	}
+	config := s.Config()
+	config.Vars["path"][0] = iofl.VarDef{Name: "tenant", Default: "d"}
+	if err := s.SetConfig(config); err != nil {
+		t.Fatal(err)
+	}
+	if f, err = s.Resolve("path", source("")); err != nil {
+		t.Fatal(err)
+	}
+	if got := readAll(t, f); got != "d/1/json" {
+		t.Errorf("got %q", got)
+	}
+}
+
+func TestDeclaredVarsConfig(t *testing.T) {
+	s := newChainSet(t, nil)
+	chains := map[string]iofl.Chain{"c": {{Filter: "identity"}}}
+	for _, tt := range []struct {
+		defs []iofl.VarDef
+		want string
+	}{
+		{[]iofl.VarDef{{}}, "name required"},
+		{[]iofl.VarDef{{Name: "a"}, {Name: "a"}}, "declared more than once"},
+		{[]iofl.VarDef{{Name: "a", Type: "float"}}, `unknown type "float"`},
+		{[]iofl.VarDef{{Name: "a", Required: true, Default: "x"}}, "cannot have a default"},
+		{[]iofl.VarDef{{Name: "a", Type: iofl.VarInt, Default: "x"}}, "default: expected int"},
+	} {
+		err := s.SetConfig(iofl.Config{Chains: chains, Vars: map[string][]iofl.VarDef{"c": tt.defs}})
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("%+v: got %v, want %s", tt.defs, err, tt.want)
+		}
+	}
+	defs := []iofl.VarDef{
+		{Name: "a", Type: iofl.VarString, Required: true, Description: "the a"},
+		{Name: "b", Type: iofl.VarDuration, Default: "1s"},
+	}
+	if err := s.SetConfig(iofl.Config{Chains: chains, Vars: map[string][]iofl.VarDef{"c": defs}}); err != nil {
+		t.Fatal(err)
+	}
+	// The configuration holds a copy of the declarations.
+	defs[0].Name = "changed"
+	got := s.Config().Vars["c"]
+	if len(got) != 2 || got[0].Name != "a" || got[1].Default != "1s" {
+		t.Errorf("got %+v", got)
+	}
+
+	// Declarations survive a round trip through JSON.
+	b, err := json.Marshal(s.Config())
+	if err != nil {
+		t.Fatal(err)
+	}
+	config, err := iofl.LoadConfig(bytes.NewReader(b), "json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(config.Vars, s.Config().Vars) {
+		t.Errorf("round trip: got %+v", config.Vars)
+	}
+}
@@ -0,0 +1,90 @@
+package iofl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// TestVector describes the expected output of a chain for a given input.
+type TestVector struct {
+	// Name identifies the vector in errors. Defaults to the index of the
+	// vector.
+	Name string
+	// Input is the content passed to the chain.
+	Input string
+	// File is the path of a file whose content is passed to the chain, used
+	// instead of Input if not empty.
+	File string
+	// SHA256 is the expected SHA-256 hash of the output, as a hexadecimal
+	// string.
+	SHA256 string
+}
+
+// Verify runs each test vector configured for the ChainSet, in order of chain
+// name. Returns an Errors containing each failure, or nil if all vectors pass.
+func (s *ChainSet) Verify() error {
+	// SetConfig replaces the map of tests rather than modifying it.
+	s.mu.RLock()
+	tests := s.tests
+	s.mu.RUnlock()
+	names := make([]string, 0, len(tests))
+	for name := range tests {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var errs Errors
+	for _, name := range names {
+		for i, v := range tests[name] {
+			id := v.Name
+			if id == "" {
+				id = fmt.Sprint(i)
+			}
+			if err := s.verify(name, v); err != nil {
+				errs = append(errs, fmt.Errorf("%s: test %s: %w", name, id, err))
+			}
+		}
+	}
+	return errs.errorOrNil()
+}
+
+// verify runs a single test vector against chain.
+func (s *ChainSet) verify(chain string, v TestVector) error {
+	var src io.ReadCloser = io.NopCloser(strings.NewReader(v.Input))
+	if v.File != "" {
+		file, err := os.Open(v.File)
+		if err != nil {
+			return err
+		}
+		src = file
+	}
+	f, err := s.Resolve(chain, src)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return err
+	}
+	if sum := hex.EncodeToString(h.Sum(nil)); !strings.EqualFold(sum, v.SHA256) {
+		return fmt.Errorf("output hash %s, expected %s", sum, v.SHA256)
+	}
+	return nil
+}
+
+// Preflight resolves chain over an empty source, and immediately closes it,
+// surfacing problems that occur when the chain is constructed, such as invalid
+// parameters, at startup rather than on first use. Problems that a filter
+// encounters only while reading are not detected.
+func (s *ChainSet) Preflight(chain string) error {
+	f, err := s.Resolve(chain, io.NopCloser(strings.NewReader("")))
+	if err != nil {
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,159 @@
+package iofl_test
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/anaminus/iofl"
+	"github.com/anaminus/iofl/filters"
+)
+
+func sha256Hex(s string) string {
+	sum := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(sum[:])
+}
+
+// verifySet returns a ChainSet configured with an upper-casing chain and the
+// given tests.
+func verifySet(t *testing.T, tests map[string][]iofl.TestVector) *iofl.ChainSet {
+	t.Helper()
+	s := iofl.NewChainSet()
+	if err := filters.Register(s); err != nil {
+		t.Fatal(err)
+	}
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"upper": {{Filter: "translate", Params: iofl.Params{"preset": "upper"}}},
+		},
+		Tests: tests,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestVerify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "input")
+	if err := ioutil.WriteFile(path, []byte("from file"), 0666); err != nil {
+		t.Fatal(err)
+	}
+	s := verifySet(t, map[string][]iofl.TestVector{
+		"upper": {
+			{Name: "text", Input: "hello", SHA256: sha256Hex("HELLO")},
+			{Input: "", SHA256: sha256Hex("")},
+			{Name: "file", File: path, SHA256: strings.ToUpper(sha256Hex("FROM FILE"))},
+		},
+	})
+	if err := s.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	if err := iofl.NewChainSet().Verify(); err != nil {
+		t.Errorf("no tests: %v", err)
+	}
+}
+
+func TestVerifyFailures(t *testing.T) {
+	s := verifySet(t, map[string][]iofl.TestVector{
+		"upper": {
+			{Name: "pass", Input: "a", SHA256: sha256Hex("A")},
+			{Input: "a", SHA256: sha256Hex("a")},
+			{Name: "missing", File: filepath.Join(t.TempDir(), "missing"), SHA256: sha256Hex("")},
+		},
+		"absent": {{Name: "chain", SHA256: sha256Hex("")}},
+	})
+	err := s.Verify()
+	var errs iofl.Errors
+	if !errors.As(err, &errs) {
+		t.Fatalf("got %v, want Errors", err)
+	}
+	want := []string{"absent: test chain: ", "upper: test 1: output hash", "upper: test missing: "}
+	if len(errs) != len(want) {
+		t.Fatalf("got %d errors, want %d: %v", len(errs), len(want), err)
+	}
+	for i, err := range errs {
+		if !strings.HasPrefix(err.Error(), want[i]) {
+			t.Errorf("error %d: got %q, want prefix %q", i, err, want[i])
+		}
+	}
+	if !strings.HasPrefix(err.Error(), "3 errors: ") {
+		t.Errorf("got message %q", err)
+	}
+}
+
+func TestConfigTests(t *testing.T) {
+	tests := map[string][]iofl.TestVector{"upper": {{Input: "a", SHA256: sha256Hex("A")}}}
+	s := verifySet(t, tests)
+	tests["upper"][0].SHA256 = ""
+	config := s.Config()
+	if got := config.Tests["upper"]; len(got) != 1 || got[0].SHA256 != sha256Hex("A") {
+		t.Errorf("got %+v", got)
+	}
+	config.Tests["upper"][0].SHA256 = ""
+	if err := s.Verify(); err != nil {
+		t.Errorf("tests were modified through copies: %v", err)
+	}
+}
+
+func TestErrors(t *testing.T) {
+	a, b := errors.New("a"), errors.New("b")
+	for _, tt := range []struct {
+		errs iofl.Errors
+		want string
+	}{
+		{nil, "no errors"},
+		{iofl.Errors{a}, "a"},
+		{iofl.Errors{a, b}, "2 errors: a; b"},
+	} {
+		if got := tt.errs.Error(); got != tt.want {
+			t.Errorf("got %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestPreflight(t *testing.T) {
+	var constructed, closed int
+	counted := iofl.FilterDef{
+		Name: "counted",
+		New: func(params iofl.Params, r io.ReadCloser) (iofl.Filter, error) {
+			if params.GetBool("fail") {
+				return nil, errors.New("bad key")
+			}
+			constructed++
+			return &funcFilter{src: closeFunc{r, func() { closed++ }}, read: r.Read}, nil
+		},
+	}
+	s := newChainSet(t, map[string]iofl.Chain{
+		"good": {{Filter: "counted"}, {Filter: "translate"}},
+		"bad":  {{Filter: "translate"}, {Filter: "counted", Params: iofl.Params{"fail": true}}},
+	}, counted)
+	if err := s.Preflight("good"); err != nil {
+		t.Fatal(err)
+	}
+	if constructed != 1 || closed != 1 {
+		t.Errorf("constructed %d, closed %d", constructed, closed)
+	}
+	if err := s.Preflight("bad"); err == nil || !strings.Contains(err.Error(), "bad key") {
+		t.Errorf("got %v, want construction error", err)
+	}
+	if err := s.Preflight("missing"); err == nil {
+		t.Error("expected error for unknown chain")
+	}
+}
+
+// closeFunc is an io.ReadCloser that calls fn when closed.
+type closeFunc struct {
+	io.ReadCloser
+	fn func()
+}
+
+func (c closeFunc) Close() error {
+	c.fn()
+	return c.ReadCloser.Close()
+}
@@ -0,0 +1,106 @@
+package iofl
+
+import (
+	"errors"
+	"io"
+)
+
+// WriteFilter is implemented by any value that writes to an underlying sink
+// while being written to. It is the counterpart of Filter for output
+// pipelines. The Close method must flush any buffered data, and close the Sink.
+// A WriteFilter that buffers data should implement Flusher.
+type WriteFilter interface {
+	io.WriteCloser
+	// Sink returns the destination to which the WriteFilter is writing, or nil
+	// if there is no sink.
+	Sink() io.WriteCloser
+}
+
+// NewWriteFilter returns a new WriteFilter, configured by the given parameters,
+// that writes to w. The WriteFilter performs the inverse of the Filter returned
+// by the corresponding NewFilter with the same parameters, such that a chain
+// that decodes when read encodes when written.
+type NewWriteFilter func(params Params, w io.WriteCloser) (f WriteFilter, err error)
+
+// NotWritable is returned when resolving a write chain containing a filter
+// that cannot be written to.
+var NotWritable = errors.New("filter cannot be written to")
+
+// ResolveWriter locates the chain of the given name, and produces a WriteFilter
+// that applies the inverse of each filter in the chain, in reverse order,
+// writing the result to dst. Thus, content written to the WriteFilter, once
+// read back through the chain, is the same as the original content. Links are
+// constructed with the NewWriter of each filter definition.
+//
+// The bufferSize parameter and parameter overrides are applied according to
+// the options. Other options, meta-parameters, and construction hooks do not
+// apply to write chains. An error that occurs while resolving is returned as a
+// *ResolveError.
+func (s *ChainSet) ResolveWriter(chain string, dst io.WriteCloser, opts ...Option) (w WriteFilter, err error) {
+	v := s.Snapshot()
+	o := newResolveOptions(opts)
+	filterChain, ok := v.Chain(chain)
+	if !ok && o.fallback && v.defaultChain != "" {
+		chain = v.defaultChain
+		filterChain, ok = v.Chain(chain)
+	}
+	if !ok {
+		return nil, &ResolveError{Chain: chain, Index: -1, Err: UnknownChain}
+	}
+	w = RootWriter{WriteCloser: dst}
+	links, err := expand(chain, filterChain, v.Chain)
+	if err != nil {
+		return nil, err
+	}
+	if err := o.checkOverrides(chain, links); err != nil {
+		return nil, err
+	}
+	// The first link wraps dst, so that written content passes through the
+	// last link first.
+	for i, link := range links {
+		filterDef, ok := v.Filter(link.Def.Filter)
+		if !ok {
+			return nil, link.error(UnknownFilter)
+		}
+		if filterDef.NewWriter == nil {
+			return nil, link.error(NotWritable)
+		}
+		params, meta := splitMeta(o.params(i, link.Def))
+		if meta != nil {
+			return nil, link.error(errors.New("meta-parameters are not supported by write chains"))
+		}
+		if err = checkParams(filterDef, params, false).errorOrNil(); err != nil {
+			return nil, link.error(err)
+		}
+		if w, err = filterDef.NewWriter(params, w); err != nil {
+			return nil, link.error(err)
+		}
+	}
+	return w, nil
+}
+
+// RootWriter wraps a general io.WriteCloser to be used as a WriteFilter by
+// returning a nil sink.
+type RootWriter struct {
+	io.WriteCloser
+}
+
+// Sink implements WriteFilter. Returns nil.
+func (RootWriter) Sink() io.WriteCloser { return nil }
+
+// ApplyWriter calls cb for each io.WriteCloser that implements WriteFilter. The
+// filter's chain is traversed downward until a non-WriteFilter is found. If cb
+// returns an error, that error is returned by ApplyWriter.
+func ApplyWriter(w io.WriteCloser, cb func(io.WriteCloser) error) error {
+	for w != nil {
+		if err := cb(w); err != nil {
+			return err
+		}
+		if f, ok := w.(WriteFilter); ok {
+			w = f.Sink()
+		} else {
+			break
+		}
+	}
+	return nil
+}
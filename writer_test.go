@@ -0,0 +1,141 @@
+package iofl_test
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"testing"
+
+	"github.com/anaminus/iofl"
+)
+
+// upperWriter is a WriteFilter that upper-cases ASCII letters.
+type upperWriter struct {
+	dst io.WriteCloser
+}
+
+func (w upperWriter) Write(p []byte) (int, error) { return w.dst.Write(bytes.ToUpper(p)) }
+func (w upperWriter) Close() error                { return w.dst.Close() }
+func (w upperWriter) Sink() io.WriteCloser        { return w.dst }
+
+func TestApplyWriter(t *testing.T) {
+	var buf bytes.Buffer
+	dst := nopWriteCloser{&buf}
+	root := iofl.RootWriter{WriteCloser: dst}
+	inner := upperWriter{root}
+	outer := upperWriter{inner}
+
+	var visited []io.WriteCloser
+	err := iofl.ApplyWriter(outer, func(w io.WriteCloser) error {
+		visited = append(visited, w)
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []io.WriteCloser{outer, inner, root}
+	if len(visited) != len(want) {
+		t.Fatalf("visited %d writers, want %d", len(visited), len(want))
+	}
+	for i := range want {
+		if visited[i] != want[i] {
+			t.Errorf("writer %d: got %T, want %T", i, visited[i], want[i])
+		}
+	}
+
+	if _, err := outer.Write([]byte("abc")); err != nil || buf.String() != "ABC" {
+		t.Errorf("got %q, %v", buf.String(), err)
+	}
+	if root.Sink() != nil {
+		t.Error("expected nil sink")
+	}
+}
+
+func TestApplyWriterStop(t *testing.T) {
+	errStop := errors.New("stop")
+	outer := upperWriter{upperWriter{iofl.RootWriter{WriteCloser: nopWriteCloser{io.Discard}}}}
+	n := 0
+	err := iofl.ApplyWriter(outer, func(w io.WriteCloser) error {
+		n++
+		if n == 2 {
+			return errStop
+		}
+		return nil
+	})
+	if err != errStop || n != 2 {
+		t.Errorf("got %v after %d writers", err, n)
+	}
+
+	// A writer that is not a WriteFilter ends the traversal.
+	n = 0
+	iofl.ApplyWriter(nopWriteCloser{io.Discard}, func(io.WriteCloser) error {
+		n++
+		return nil
+	})
+	if n != 1 {
+		t.Errorf("visited %d writers, want 1", n)
+	}
+	if err := iofl.ApplyWriter(nil, func(io.WriteCloser) error { return errStop }); err != nil {
+		t.Errorf("nil: got %v", err)
+	}
+}
+
+func TestResolveWriter(t *testing.T) {
+	s := newChainSet(t, nil)
+	err := s.SetConfig(iofl.Config{
+		Chains: map[string]iofl.Chain{
+			"c":    {{Filter: "gzip"}, {Filter: "percent", Params: iofl.Params{"mode": "decode"}}},
+			"ref":  {{Chain: "c"}},
+			"read": {{Filter: "translate"}},
+			"meta": {{Filter: "percent", Params: iofl.Params{"#limit": 10.0}}},
+		},
+		DefaultChain: "c",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	in := "a b/c?d=e"
+	for _, tt := range []struct {
+		chain string
+		opts  []iofl.Option
+	}{
+		{"c", nil},
+		{"ref", nil},
+		{"missing", []iofl.Option{iofl.Fallback()}},
+	} {
+		var buf bytes.Buffer
+		w, err := s.ResolveWriter(tt.chain, nopWriteCloser{&buf}, tt.opts...)
+		if err != nil {
+			t.Fatalf("%s: %v", tt.chain, err)
+		}
+		if _, err := w.Write([]byte(in)); err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Close(); err != nil {
+			t.Fatal(err)
+		}
+		// Content written through the chain reads back as the original.
+		f, err := s.Resolve("c", source(buf.String()))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := readAll(t, f); got != in {
+			t.Errorf("%s: got %q, want %q", tt.chain, got, in)
+		}
+	}
+
+	for _, tt := range []struct {
+		chain string
+		err   error
+	}{
+		{"missing", iofl.UnknownChain},
+		{"read", iofl.NotWritable},
+		{"meta", nil},
+	} {
+		_, err := s.ResolveWriter(tt.chain, nopWriteCloser{io.Discard})
+		var rerr *iofl.ResolveError
+		if !errors.As(err, &rerr) || tt.err != nil && !errors.Is(err, tt.err) {
+			t.Errorf("%s: got %v, want %v", tt.chain, err, tt.err)
+		}
+	}
+}